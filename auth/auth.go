@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
@@ -18,6 +19,7 @@ type TokenIndex[token string, user Session] interface {
 	Find(t token) (u user, found bool)
 	Upsert(t token, check index_utils.UpdateCheck[string, Session]) (updated bool, err error)
 	Remove(t token) (removedUser user, removed bool)
+	Query(ctx context.Context, lower token, upper token) ([]index_utils.Pair[token, user], error)
 }
 
 // UserIndex defines an interface for managing users and their associated tokens.
@@ -158,6 +160,26 @@ func (a *AuthStruct) Logout(token string) (bool, error) {
 	return true, nil
 }
 
+// Revoke ends every session of the specified user, so that their tokens stop being valid.
+// It returns the number of sessions ended.
+func (a *AuthStruct) Revoke(username string) int {
+	sessions, err := a.tokenToUser.Query(context.Background(), string(rune(0)), string(rune(127)))
+	if err != nil {
+		slog.Error("Unable to list the sessions", slog.String("user", username), slog.String("error", err.Error()))
+		return 0
+	}
+	revoked := 0
+	for _, session := range sessions {
+		if session.Value.user == username {
+			if _, removed := a.tokenToUser.Remove(session.Key); removed {
+				revoked++
+			}
+		}
+	}
+	slog.Info("Sessions revoked", slog.String("user", username), slog.Int("count", revoked))
+	return revoked
+}
+
 // randomGeneratedToken generates a cryptographically secure random token
 // and encodes it as a URL-safe base64 string.
 func randomGeneratedToken() (string, error) {
@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"context"
 	"fmt"
 	"testing"
 
@@ -32,6 +33,10 @@ func (u *userIndexSkiplist) Remove(user string) (Session, bool) {
 	return u.skiplist.Remove(user)
 }
 
+func (u *userIndexSkiplist) Query(ctx context.Context, low string, hi string) ([]index_utils.Pair[string, Session], error) {
+	return u.skiplist.Query(ctx, low, hi)
+}
+
 // TokenIndex using MockSkipList
 type tokenIndexSkiplist struct {
 	skiplist *mocks.MockSL[string, string]
@@ -0,0 +1,272 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// CredentialFile is the name of the file holding the credentials in the data directory
+const CredentialFile = "users.json"
+
+// Costs passwords may be hashed with; every doubling of the work makes guessing a password twice as slow
+const (
+	DefaultCost = bcrypt.DefaultCost
+	MinCost     = bcrypt.MinCost // only suitable for tests
+)
+
+// Errors returned by the credential store
+var (
+	ErrInvalidCredentials = errors.New("Invalid username or password")
+	ErrUserExists         = errors.New("User already exists")
+	ErrNoUser             = errors.New("User does not exist")
+)
+
+// SessionRevoker ends the sessions of a user whose credentials are no longer valid
+type SessionRevoker interface {
+	Revoke(username string) int
+}
+
+// User describes an account, without its password
+type User struct {
+	Username string `json:"username"`
+	Admin    bool   `json:"admin"` // administrators may manage the other accounts
+}
+
+// credential is an account as stored, holding the bcrypt hash of its password
+type credential struct {
+	Hash   string  `json:"hash"`
+	Admin  bool    `json:"admin"`
+	Grants []Grant `json:"grants,omitempty"` // the roles held on databases and collections
+}
+
+// Credentials stores the accounts allowed to log in, persisting them to the data directory if there is one.
+// It is safe for concurrent use.
+type Credentials struct {
+	file     string         // the file the accounts are persisted to, or "" to keep them in memory
+	cost     int            // the bcrypt cost passwords are hashed with
+	sessions SessionRevoker // ends the sessions of deleted accounts and of those whose password was reset
+	dummy    []byte         // a hash compared against for unknown users, so that they take as long to reject
+
+	mu        sync.RWMutex
+	users     map[string]credential
+	tokenRole Role // the role held everywhere by users without an account, those of the token file
+}
+
+// OpenCredentials loads the accounts persisted to the data directory dir, or starts with none if dir is "" or holds
+// no accounts yet. Passwords are hashed with the bcrypt cost, and sessions are revoked when their credentials change.
+// Returns an error if the accounts could not be read
+func OpenCredentials(dir string, cost int, sessions SessionRevoker) (*Credentials, error) {
+	dummy, err := bcrypt.GenerateFromPassword([]byte("owldb"), cost)
+	if err != nil {
+		return nil, err
+	}
+	c := &Credentials{cost: cost, sessions: sessions, dummy: dummy, users: make(map[string]credential)}
+	if dir == "" {
+		return c, nil
+	}
+	c.file = filepath.Join(dir, CredentialFile)
+	data, err := os.ReadFile(c.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the credentials: %w", err)
+	}
+	if err = json.Unmarshal(data, &c.users); err != nil {
+		return nil, fmt.Errorf("malformed credentials in %s: %w", c.file, err)
+	}
+	slog.Info("Loaded credentials", "users", len(c.users))
+	return c, nil
+}
+
+// Verify checks that password is the password of username.
+// Returns ErrInvalidCredentials if it is not, or if there is no such user
+func (c *Credentials) Verify(username string, password string) error {
+	c.mu.RLock()
+	cred, found := c.users[username]
+	c.mu.RUnlock()
+	hash := c.dummy
+	if found {
+		hash = []byte(cred.Hash)
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil || !found {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+// IsAdmin reports whether username is an administrator
+func (c *Credentials) IsAdmin(username string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.users[username].Admin
+}
+
+// HasAdmin reports whether any account is an administrator, without whom no account can be created
+func (c *Credentials) HasAdmin() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, cred := range c.users {
+		if cred.Admin {
+			return true
+		}
+	}
+	return false
+}
+
+// List returns every account, sorted by username
+func (c *Credentials) List() []User {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	list := make([]User, 0, len(c.users))
+	for username, cred := range c.users {
+		list = append(list, User{Username: username, Admin: cred.Admin})
+	}
+	slices.SortFunc(list, func(a, b User) int { return strings.Compare(a.Username, b.Username) })
+	return list
+}
+
+// Create adds the account username with password, making it an administrator if admin is set.
+// Returns ErrUserExists if there already is one, or an error if the account is malformed or could not be persisted
+func (c *Credentials) Create(username string, password string, admin bool) error {
+	if username == "" {
+		return errors.New("username is empty")
+	}
+	hash, err := c.hash(password)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, found := c.users[username]; found {
+		return ErrUserExists
+	}
+	c.users[username] = credential{Hash: hash, Admin: admin}
+	if err = c.save(); err != nil {
+		delete(c.users, username)
+		return err
+	}
+	slog.Info("User created", "user", username, "admin", admin)
+	return nil
+}
+
+// Delete removes the account username and ends its sessions.
+// Returns ErrNoUser if there is none, or an error if the change could not be persisted
+func (c *Credentials) Delete(username string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cred, found := c.users[username]
+	if !found {
+		return ErrNoUser
+	}
+	delete(c.users, username)
+	if err := c.save(); err != nil {
+		c.users[username] = cred
+		return err
+	}
+	c.sessions.Revoke(username)
+	slog.Info("User deleted", "user", username)
+	return nil
+}
+
+// SetPassword replaces the password of username and ends its sessions.
+// Returns ErrNoUser if there is no such user, or an error if the password is malformed or could not be persisted
+func (c *Credentials) SetPassword(username string, password string) error {
+	hash, err := c.hash(password)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cred, found := c.users[username]
+	if !found {
+		return ErrNoUser
+	}
+	c.users[username] = credential{Hash: hash, Admin: cred.Admin, Grants: cred.Grants}
+	if err = c.save(); err != nil {
+		c.users[username] = cred
+		return err
+	}
+	c.sessions.Revoke(username)
+	slog.Info("Password reset", "user", username)
+	return nil
+}
+
+// Bootstrap makes username an administrator whose password is password, creating the account if needed, so that
+// a server always has an administrator able to manage the other accounts.
+// Returns an error if the account is malformed or could not be persisted
+func (c *Credentials) Bootstrap(username string, password string) error {
+	err := c.Create(username, password, true)
+	if !errors.Is(err, ErrUserExists) {
+		return err
+	}
+	hash, err := c.hash(password)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cred := c.users[username]
+	c.users[username] = credential{Hash: hash, Admin: true, Grants: cred.Grants}
+	if err = c.save(); err != nil {
+		c.users[username] = cred
+		return err
+	}
+	return nil
+}
+
+// hash returns the bcrypt hash of password, or an error if bcrypt cannot hash it
+func (c *Credentials) hash(password string) (string, error) {
+	if password == "" {
+		return "", errors.New("password is empty")
+	}
+	if len(password) > 72 {
+		return "", errors.New("password is longer than 72 bytes")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), c.cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// save persists the accounts, replacing the file atomically so that a crash leaves either the old or the new
+// accounts behind. The caller must hold c.mu.
+func (c *Credentials) save() error {
+	if c.file == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(c.users, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := c.file + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to save the credentials: %w", err)
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, c.file)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("unable to save the credentials: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type mockRevoker struct {
+	revoked []string
+}
+
+func (m *mockRevoker) Revoke(username string) int {
+	m.revoked = append(m.revoked, username)
+	return 1
+}
+
+func TestCredentials_Persist(t *testing.T) {
+	dir := t.TempDir()
+	revoker := &mockRevoker{}
+	creds, err := OpenCredentials(dir, MinCost, revoker)
+	if err != nil {
+		t.Fatalf("Failed to open credentials: %s", err)
+	}
+	if err = creds.Create("amy", "pw", false); err != nil {
+		t.Fatalf("Failed to create a user: %s", err)
+	}
+	if creds.HasAdmin() {
+		t.Errorf("Expected no administrator before bootstrapping one")
+	}
+	if err = creds.Bootstrap("root", "toor"); err != nil {
+		t.Fatalf("Failed to bootstrap the administrator: %s", err)
+	}
+	if !creds.HasAdmin() {
+		t.Errorf("Expected an administrator once bootstrapped")
+	}
+	if err = creds.Create("amy", "other", true); err != ErrUserExists {
+		t.Errorf("Expected ErrUserExists, got %v", err)
+	}
+	if err = creds.SetPassword("amy", "pw2"); err != nil {
+		t.Errorf("Failed to reset the password: %s", err)
+	}
+	if err = creds.Create("bob", "pw", false); err != nil {
+		t.Fatalf("Failed to create a user: %s", err)
+	}
+	if err = creds.Delete("bob"); err != nil {
+		t.Errorf("Failed to delete a user: %s", err)
+	}
+	if strings.Join(revoker.revoked, ",") != "amy,bob" {
+		t.Errorf("Expected the sessions of amy then bob to be revoked, got %v", revoker.revoked)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, CredentialFile))
+	if err != nil {
+		t.Fatalf("Failed to read the credential file: %s", err)
+	}
+	if strings.Contains(string(data), "pw2") || strings.Contains(string(data), "toor") {
+		t.Errorf("Expected only password hashes to be stored, got %s", data)
+	}
+	info, _ := os.Stat(filepath.Join(dir, CredentialFile))
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the credential file to be private, got %v", info.Mode().Perm())
+	}
+
+	reopened, err := OpenCredentials(dir, MinCost, revoker)
+	if err != nil {
+		t.Fatalf("Failed to reopen credentials: %s", err)
+	}
+	if err = reopened.Verify("amy", "pw2"); err != nil {
+		t.Errorf("Expected the reset password to survive, got %v", err)
+	}
+	if err = reopened.Verify("amy", "pw"); err != ErrInvalidCredentials {
+		t.Errorf("Expected the old password to be rejected, got %v", err)
+	}
+	if err = reopened.Verify("bob", "pw"); err != ErrInvalidCredentials {
+		t.Errorf("Expected a deleted user to be rejected, got %v", err)
+	}
+	if !reopened.HasAdmin() || !reopened.IsAdmin("root") || reopened.IsAdmin("amy") || reopened.IsAdmin("bob") {
+		t.Errorf("Expected only root to be an administrator, got %v", reopened.List())
+	}
+	if list := reopened.List(); len(list) != 2 || list[0].Username != "amy" || list[1].Username != "root" {
+		t.Errorf("Expected amy and root, got %v", list)
+	}
+}
+
+func TestCredentials_Invalid(t *testing.T) {
+	creds, _ := OpenCredentials("", MinCost, &mockRevoker{})
+	if err := creds.Create("", "pw", false); err == nil {
+		t.Errorf("Expected an empty username to be rejected")
+	}
+	if err := creds.Create("amy", "", false); err == nil {
+		t.Errorf("Expected an empty password to be rejected")
+	}
+	if err := creds.Create("amy", strings.Repeat("a", 73), false); err == nil {
+		t.Errorf("Expected a password bcrypt would truncate to be rejected")
+	}
+	if err := creds.SetPassword("amy", "pw"); err != ErrNoUser {
+		t.Errorf("Expected ErrNoUser, got %v", err)
+	}
+	if err := creds.Delete("amy"); err != ErrNoUser {
+		t.Errorf("Expected ErrNoUser, got %v", err)
+	}
+	if err := creds.Verify("amy", ""); err != ErrInvalidCredentials {
+		t.Errorf("Expected ErrInvalidCredentials, got %v", err)
+	}
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, CredentialFile), []byte("{"), 0600)
+	if _, err := OpenCredentials(dir, MinCost, &mockRevoker{}); err == nil {
+		t.Errorf("Expected malformed credentials to be rejected")
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	service := setupAuth()
+	first, _ := service.Login("amy")
+	second, _ := service.Login("amy")
+	other, _ := service.Login("bob")
+	if revoked := service.Revoke("amy"); revoked != 2 {
+		t.Errorf("Expected both sessions of amy to be revoked, got %d", revoked)
+	}
+	if _, err := service.ValidateSession(first); err == nil {
+		t.Errorf("Expected the first session of amy to end")
+	}
+	if _, err := service.ValidateSession(second); err == nil {
+		t.Errorf("Expected the second session of amy to end")
+	}
+	if user, err := service.ValidateSession(other); err != nil || user != "bob" {
+		t.Errorf("Expected the session of bob to remain, got %s, %v", user, err)
+	}
+}
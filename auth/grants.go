@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Role is the access a user holds to a database or collection, and to everything beneath it. Each role allows
+// everything the roles before it do.
+type Role int
+
+const (
+	NoRole Role = iota // no access
+	Reader             // reads and subscribes to documents and collections
+	Writer             // creates, replaces, patches and deletes documents and collections
+	Admin              // creates, deletes and imports databases, manages indexes and webhooks, and grants roles
+)
+
+// ErrNoGrant is returned when revoking a grant a user does not hold
+var ErrNoGrant = errors.New("Grant does not exist")
+
+// roleNames are the names of the roles, as they appear in JSON
+var roleNames = []string{"none", "reader", "writer", "admin"}
+
+// String returns the name of the role
+func (r Role) String() string {
+	if r < NoRole || int(r) >= len(roleNames) {
+		return fmt.Sprintf("Role(%d)", int(r))
+	}
+	return roleNames[r]
+}
+
+// MarshalText encodes the role as its name
+func (r Role) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// UnmarshalText decodes a role from its name, failing for any other than reader, writer or admin
+func (r *Role) UnmarshalText(text []byte) error {
+	i := slices.Index(roleNames, string(text))
+	if i <= int(NoRole) {
+		return fmt.Errorf("unknown role '%s'", text)
+	}
+	*r = Role(i)
+	return nil
+}
+
+// ParseRole parses the name of a role, accepting none as well as reader, writer and admin.
+// Returns the role, or an error for any other name
+func ParseRole(name string) (Role, error) {
+	if name == NoRole.String() {
+		return NoRole, nil
+	}
+	var r Role
+	return r, r.UnmarshalText([]byte(name))
+}
+
+// Grant gives a user a role on the resource at a path, such as "/db" or "/db/doc/col/", and on everything beneath it.
+// The path "/" stands for every database.
+type Grant struct {
+	Path string `json:"path"`
+	Role Role   `json:"role"`
+}
+
+// ValidateGrantPath checks that path names the server, a database, a document or a collection.
+// Returns an error describing the problem otherwise
+func ValidateGrantPath(path string) error {
+	if !strings.HasPrefix(path, "/") {
+		return fmt.Errorf("path '%s' must start with a slash", path)
+	}
+	if path == "/" {
+		return nil
+	}
+	for _, seg := range strings.Split(strings.TrimSuffix(path[1:], "/"), "/") {
+		if seg == "" {
+			return fmt.Errorf("path '%s' has an empty segment", path)
+		}
+	}
+	return nil
+}
+
+// covers reports whether a grant on prefix extends to the resource at path; a grant covers the resource it names
+// and everything beneath it, whether or not either path ends with a slash
+func covers(prefix string, path string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	path = strings.TrimSuffix(path, "/")
+	return prefix == "" || path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// samePath reports whether two grant paths name the same resource
+func samePath(a string, b string) bool {
+	return a == b || (a != "/" && b != "/" && strings.TrimSuffix(a, "/") == strings.TrimSuffix(b, "/"))
+}
+
+// Role returns the role username holds on the resource at path: the highest role granted on it or on any resource
+// above it. Administrators of the server hold the admin role everywhere, while users without an account hold the
+// token role everywhere
+func (c *Credentials) Role(username string, path string) Role {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	cred, found := c.users[username]
+	if !found {
+		return c.tokenRole
+	}
+	if cred.Admin {
+		return Admin
+	}
+	role := NoRole
+	for _, grant := range cred.Grants {
+		if grant.Role > role && covers(grant.Path, path) {
+			role = grant.Role
+		}
+	}
+	return role
+}
+
+// SetTokenRole sets the role held everywhere by users without an account, who can only have signed in with a
+// token from the token file. They hold none until it is set
+func (c *Credentials) SetTokenRole(role Role) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tokenRole = role
+}
+
+// Grants returns the grants held by username, sorted by path.
+// Returns ErrNoUser if there is no such user
+func (c *Credentials) Grants(username string) ([]Grant, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	cred, found := c.users[username]
+	if !found {
+		return nil, ErrNoUser
+	}
+	grants := append(make([]Grant, 0, len(cred.Grants)), cred.Grants...)
+	slices.SortFunc(grants, func(a, b Grant) int { return strings.Compare(a.Path, b.Path) })
+	return grants, nil
+}
+
+// SetGrant gives username the role on the resource at path, replacing any role granted on that same path.
+// Returns ErrNoUser if there is no such user, or an error if the grant is malformed or could not be persisted
+func (c *Credentials) SetGrant(username string, path string, role Role) error {
+	if err := ValidateGrantPath(path); err != nil {
+		return err
+	}
+	if role <= NoRole || role > Admin {
+		return fmt.Errorf("unknown role '%s'", role)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cred, found := c.users[username]
+	if !found {
+		return ErrNoUser
+	}
+	grants := slices.DeleteFunc(slices.Clone(cred.Grants), func(g Grant) bool { return samePath(g.Path, path) })
+	c.users[username] = credential{Hash: cred.Hash, Admin: cred.Admin, Grants: append(grants, Grant{Path: path, Role: role})}
+	if err := c.save(); err != nil {
+		c.users[username] = cred
+		return err
+	}
+	return nil
+}
+
+// RemoveGrant takes away the role username was granted on the resource at path; roles granted above or beneath it
+// are kept.
+// Returns ErrNoUser if there is no such user, ErrNoGrant if no role was granted on path, or an error if the change
+// could not be persisted
+func (c *Credentials) RemoveGrant(username string, path string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cred, found := c.users[username]
+	if !found {
+		return ErrNoUser
+	}
+	grants := slices.DeleteFunc(slices.Clone(cred.Grants), func(g Grant) bool { return samePath(g.Path, path) })
+	if len(grants) == len(cred.Grants) {
+		return ErrNoGrant
+	}
+	c.users[username] = credential{Hash: cred.Hash, Admin: cred.Admin, Grants: grants}
+	if err := c.save(); err != nil {
+		c.users[username] = cred
+		return err
+	}
+	return nil
+}
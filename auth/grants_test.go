@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCredentials_Role(t *testing.T) {
+	creds, _ := OpenCredentials("", MinCost, &mockRevoker{})
+	creds.Bootstrap("root", "toor")
+	creds.Create("amy", "pw", false)
+	creds.SetGrant("amy", "/db", Reader)
+	creds.SetGrant("amy", "/db/doc/col/", Writer)
+	creds.SetGrant("amy", "/db/doc/col/sub/deep", Reader)
+	creds.SetGrant("amy", "/other/doc", Admin)
+
+	tests := []struct {
+		user string
+		path string
+		role Role
+	}{
+		{"amy", "/db", Reader},
+		{"amy", "/db/", Reader},
+		{"amy", "/db/doc", Reader},
+		{"amy", "/db/doc/col/", Writer},
+		{"amy", "/db/doc/col", Writer},
+		{"amy", "/db/doc/col/sub", Writer},
+		{"amy", "/db/doc/col/sub/deep/col/doc", Writer}, //the highest role above a resource wins
+		{"amy", "/db/doc/col2/", Reader},                //a grant does not extend to siblings sharing its prefix
+		{"amy", "/dbx", NoRole},
+		{"amy", "/other", NoRole},
+		{"amy", "/other/doc/col/", Admin},
+		{"amy", "/", NoRole},
+		{"root", "/anything/at/all", Admin},
+		{"root", "/", Admin},
+		{"bob", "/db", NoRole},
+	}
+	for _, tt := range tests {
+		if role := creds.Role(tt.user, tt.path); role != tt.role {
+			t.Errorf("Expected %s to hold %s on %s, got %s", tt.user, tt.role, tt.path, role)
+		}
+	}
+
+	creds.Create("bob", "pw", false)
+	creds.SetGrant("bob", "/", Reader)
+	if role := creds.Role("bob", "/db/doc/col/"); role != Reader {
+		t.Errorf("Expected a grant on / to cover every database, got %s", role)
+	}
+}
+
+func TestCredentials_Grants(t *testing.T) {
+	dir := t.TempDir()
+	creds, _ := OpenCredentials(dir, MinCost, &mockRevoker{})
+	creds.Create("amy", "pw", false)
+	if err := creds.SetGrant("amy", "/db/col/", Writer); err != nil {
+		t.Fatalf("Failed to grant a role: %s", err)
+	}
+	creds.SetGrant("amy", "/db", Reader)
+	if err := creds.SetGrant("amy", "/db/col", Reader); err != nil {
+		t.Errorf("Failed to replace a grant: %s", err)
+	}
+	creds.SetPassword("amy", "pw2")
+
+	reopened, _ := OpenCredentials(dir, MinCost, &mockRevoker{})
+	grants, err := reopened.Grants("amy")
+	if err != nil || len(grants) != 2 || grants[0] != (Grant{"/db", Reader}) || grants[1] != (Grant{"/db/col", Reader}) {
+		t.Errorf("Expected the grants to be replaced and persisted, got %v, %v", grants, err)
+	}
+	if err = reopened.RemoveGrant("amy", "/db/"); err != nil {
+		t.Errorf("Failed to remove a grant: %s", err)
+	}
+	if err = reopened.RemoveGrant("amy", "/db"); err != ErrNoGrant {
+		t.Errorf("Expected ErrNoGrant, got %v", err)
+	}
+	if role := reopened.Role("amy", "/db/col/doc"); role != Reader {
+		t.Errorf("Expected the grant beneath the one removed to remain, got %s", role)
+	}
+
+	if _, err = creds.Grants("bob"); err != ErrNoUser {
+		t.Errorf("Expected ErrNoUser, got %v", err)
+	}
+	if err = creds.SetGrant("bob", "/db", Reader); err != ErrNoUser {
+		t.Errorf("Expected ErrNoUser, got %v", err)
+	}
+	if err = creds.SetGrant("amy", "db", Reader); err == nil {
+		t.Errorf("Expected a path without a leading slash to be rejected")
+	}
+	if err = creds.SetGrant("amy", "/db//col/", Reader); err == nil {
+		t.Errorf("Expected a path with an empty segment to be rejected")
+	}
+	if err = creds.SetGrant("amy", "/db", NoRole); err == nil {
+		t.Errorf("Expected granting no role to be rejected")
+	}
+}
+
+func TestRole_JSON(t *testing.T) {
+	var grant Grant
+	if err := json.Unmarshal([]byte(`{"path":"/db","role":"writer"}`), &grant); err != nil || grant.Role != Writer {
+		t.Errorf("Expected the writer role, got %v, %v", grant.Role, err)
+	}
+	if b, _ := json.Marshal(grant); string(b) != `{"path":"/db","role":"writer"}` {
+		t.Errorf("Expected the role to be encoded by name, got %s", b)
+	}
+	for _, bad := range []string{`"none"`, `"owner"`, `2`} {
+		var role Role
+		if err := json.Unmarshal([]byte(bad), &role); err == nil {
+			t.Errorf("Expected %s to be rejected, got %s", bad, role)
+		}
+	}
+}
+
+func TestCredentials_TokenRole(t *testing.T) {
+	creds, _ := OpenCredentials("", MinCost, &mockRevoker{})
+	creds.Create("amy", "pw", false)
+	creds.SetTokenRole(Writer)
+	if role := creds.Role("fernando", "/db/doc"); role != Writer {
+		t.Errorf("Expected a user without an account to hold the token role, got %s", role)
+	}
+	if role := creds.Role("amy", "/db/doc"); role != NoRole {
+		t.Errorf("Expected the token role not to extend to accounts, got %s", role)
+	}
+	if creds.IsAdmin("fernando") {
+		t.Errorf("Expected a user without an account not to manage the accounts")
+	}
+}
+
+func TestParseRole(t *testing.T) {
+	for name, want := range map[string]Role{"none": NoRole, "reader": Reader, "writer": Writer, "admin": Admin} {
+		if role, err := ParseRole(name); err != nil || role != want {
+			t.Errorf("Expected %s to parse as %s, got %s, %v", name, want, role, err)
+		}
+	}
+	if _, err := ParseRole("owner"); err == nil {
+		t.Errorf("Expected an unknown role to be rejected")
+	}
+}
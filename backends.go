@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/concurrentSkipList"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/db"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/diskIndex"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/document"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/fieldIndex"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/patcher"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/persistence"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/subscriptionManager"
+)
+
+// The index backends a database can be stored in
+const (
+	backendMemory = "memory" // skip lists held in memory
+	backendDisk   = "disk"   // indices whose values live in a data file
+)
+
+// backends records the index backend chosen for each database
+type backends struct {
+	def   string            // the backend of databases without an override
+	perDB map[string]string // per-database overrides
+}
+
+// parseBackends parses a backend specification: a default backend, optionally followed by comma-separated
+// overrides of the form name=backend, e.g. "memory,archive=disk".
+// Returns the parsed specification, or an error if it names an unknown backend
+func parseBackends(spec string) (backends, error) {
+	res := backends{def: backendMemory, perDB: map[string]string{}}
+	for i, part := range strings.Split(spec, ",") {
+		name, backend, isOverride := strings.Cut(part, "=")
+		if !isOverride {
+			backend = name
+		}
+		if backend != backendMemory && backend != backendDisk {
+			return res, fmt.Errorf("unknown index backend '%s'", backend)
+		}
+		switch {
+		case isOverride:
+			res.perDB[name] = backend
+		case i == 0:
+			res.def = backend
+		default:
+			return res, fmt.Errorf("malformed backend override '%s'", part)
+		}
+	}
+	return res, nil
+}
+
+// For returns the backend chosen for the database name
+func (b backends) For(name string) string {
+	if backend, found := b.perDB[name]; found {
+		return backend
+	}
+	return b.def
+}
+
+// usesDisk reports whether any database may be stored on disk
+func (b backends) usesDisk() bool {
+	if b.def == backendDisk {
+		return true
+	}
+	for _, backend := range b.perDB {
+		if backend == backendDisk {
+			return true
+		}
+	}
+	return false
+}
+
+// indexDir returns the directory holding the data files of disk-backed databases
+func indexDir(dataDir string) string {
+	return filepath.Join(dataDir, "index")
+}
+
+// newDiskDatabase creates the database name with every document index, top-level or nested, backed by a data file
+// kept in dir. Collection subscription managers, which queue events as delivery says, and secondary indices stay in
+// memory and are released when their collection is dropped.
+// Returns the database, or an error if its data file could not be created
+func newDiskDatabase(name string, dir string, validator document.Validator, smFactory document.SubscriptionManagerFactory, messager *subscriptionManager.Messager, journal persistence.Journal, delivery subscriptionManager.Delivery) (*db.Database[string, *document.Document], error) {
+	store, err := diskIndex.Open(filepath.Join(indexDir(dir), url.PathEscape(name)+".dat"), diskIndex.DefaultCompactAt)
+	if err != nil {
+		return nil, err
+	}
+	colSubs := concurrentSkipList.NewSL[string, *subscriptionManager.ColSubscriptionManager](string(rune(0)), string(rune(127)))
+	colIndexes := concurrentSkipList.NewSL[string, *fieldIndex.Set](string(rune(0)), string(rune(127)))
+	store.OnDrop(func(prefix string) {
+		colSubs.Remove("/" + prefix)
+		colIndexes.Remove("/" + prefix)
+	})
+	colSubManager := func(colpath string) *subscriptionManager.ColSubscriptionManager {
+		var sm *subscriptionManager.ColSubscriptionManager
+		colSubs.Upsert("/"+colpath+"/", func(key string, curVal *subscriptionManager.ColSubscriptionManager, exists bool) (*subscriptionManager.ColSubscriptionManager, error) {
+			if !exists {
+				curVal = subscriptionManager.NewColSubManager(concurrentSkipList.NewSL[string, subscriptionManager.Colsubscriber](string(rune(0)), string(rune(127))), delivery)
+			}
+			sm = curVal
+			return curVal, nil
+		})
+		return sm
+	}
+	colIndexSet := func(colpath string) *fieldIndex.Set {
+		var set *fieldIndex.Set
+		colIndexes.Upsert("/"+colpath+"/", func(key string, curVal *fieldIndex.Set, exists bool) (*fieldIndex.Set, error) {
+			if !exists {
+				curVal = fieldIndex.NewSet()
+			}
+			set = curVal
+			return curVal, nil
+		})
+		return set
+	}
+
+	var docFactory db.DocFactory[*document.Document]
+	var colFactory document.CollectionFactory
+	var docColFactory document.DocumentIndexFactory[document.DocumentIndex[string, *document.Collection]]
+	docCodec := document.DocumentCodec{New: func(docpath string) *document.Document {
+		return docFactory(nil, "", docpath)
+	}}
+
+	docFactory = func(payload []byte, user string, path string) *document.Document {
+		return document.New(payload, user, path, docColFactory, colFactory, smFactory, validator, patcher.Patcher{}, messager, journal)
+	}
+	docColFactory = func(docpath string) document.DocumentIndex[string, *document.Collection] {
+		return diskIndex.NewIndex[*document.Collection](store, docpath+"/", document.CollectionCodec{DocPath: docpath, Factory: colFactory})
+	}
+	colFactory = func(colpath string) *document.Collection {
+		return &document.Collection{
+			Name:                path.Base(colpath),
+			Docs:                diskIndex.NewIndex[*document.Document](store, colpath+"/", docCodec),
+			SubscriptionManager: colSubManager(colpath),
+			Indexes:             colIndexSet(colpath),
+		}
+	}
+
+	topDocs := diskIndex.NewIndex[*document.Document](store, "", docCodec)
+	sm := subscriptionManager.NewColSubManager(concurrentSkipList.NewSL[string, subscriptionManager.Colsubscriber](string(rune(0)), string(rune(127))), delivery)
+	return db.New[string, *document.Document](name, docFactory, topDocs, sm, fieldIndex.NewSet(), messager, validator, journal), nil
+}
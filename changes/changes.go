@@ -0,0 +1,409 @@
+// Package changes keeps the change feed of each database: an ordered, gap-free log of every mutation made to it,
+// numbered so that consumers such as ETL jobs can checkpoint their position and resume from it. The feed wraps the
+// journal, so it sees every mutation the write-ahead log does, as it is acknowledged. When the journal is the
+// write-ahead log, the log numbers the changes and hands them to the feed, and the changes the feed no longer keeps
+// are read back from the log, so that they survive restarts.
+package changes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/persistence"
+)
+
+// Limit is the number of changes kept in memory for each database. A consumer further behind has its changes read
+// from the write-ahead log, or has to read the database again if there is none.
+const Limit = 10000
+
+// ErrNoDatabase is returned for a database that has no feed, since it was never created
+var ErrNoDatabase = errors.New("Database does not exist")
+
+// ErrCompacted is returned for changes that are no longer kept, in memory or in the write-ahead log; the consumer has
+// to read the database again
+var ErrCompacted = errors.New("changes no longer kept")
+
+// Change is an entry of the feed of a database
+type Change struct {
+	Seq       int64           `json:"seq"`            // the position of the change in the feed, starting at 1
+	Op        string          `json:"op"`             // the operation, named as in the write-ahead log: createdb, deletedb, importdb, putdoc, deletedoc, putcol or deletecol
+	Path      string          `json:"path"`           // the resource changed, such as "/doc" or "/doc/col/", or "/" for the database itself
+	User      string          `json:"user"`           // the user who made the change
+	Body      json.RawMessage `json:"body,omitempty"` // the new contents of the document for putdoc, or the entries imported for importdb
+	Timestamp int64           `json:"timestamp"`      // the time of the change, in Unix milliseconds
+}
+
+// changeLog is the feed of a single database
+type changeLog struct {
+	seq     int64                   // the sequence number of the latest change
+	changes []Change                // the most recent changes, oldest first
+	subs    map[string]*chan []byte // the channels of the consumers streaming the feed, by ID
+}
+
+// durable is implemented by journals that number the changes of every database themselves and keep them across
+// restarts, such as the write-ahead log
+type durable interface {
+	Epoch() string                                                    // identifies the numbering of the changes, which only starts over if the journal is lost
+	LastChange(dbName string) int64                                   // the number of the latest change logged for dbName, or 0 if there is none
+	Observe(fn func(rec persistence.Record))                          // has fn told of every record replayed or logged, in order
+	Records(dbName string, after int64) ([]persistence.Record, error) // the records still logged for dbName holding changes numbered after after
+}
+
+// Feed records the changes of every database to its journal, then appends them to the feed of the database unless
+// the journal failed to record them. It implements persistence.Journal and is safe for concurrent use.
+type Feed struct {
+	persistence.Journal // the journal every change is recorded to first
+
+	epoch string // the time the numbering of the changes started, in Unix milliseconds
+	depth int    // the number of events queued for each consumer streaming a feed
+
+	mu   sync.Mutex
+	next int64                 // the number of consumers that streamed a feed so far
+	logs map[string]*changeLog // the feeds, by database; the feed of a deleted database is kept
+}
+
+// New creates a feed recording every change to journal first, queueing depth events for each consumer streaming it.
+// If journal numbers the changes durably, the feed takes its epoch from it and observes it, adding the changes it
+// replays or logs as it numbered them; it must then be created before the journal is replayed. Otherwise the feeds
+// are kept in memory only, and start over, from a new epoch, every time the server does.
+func New(journal persistence.Journal, depth int) *Feed {
+	f := &Feed{Journal: journal, epoch: strconv.FormatInt(time.Now().UnixMilli(), 10), depth: depth, logs: make(map[string]*changeLog)}
+	if d, ok := journal.(durable); ok {
+		f.epoch = d.Epoch()
+		d.Observe(f.logged)
+	}
+	return f
+}
+
+// Epoch returns the epoch of the feed, in Unix milliseconds. Sequence numbers only identify a change within an epoch.
+func (f *Feed) Epoch() string {
+	return f.epoch
+}
+
+// Changes returns the changes made to the database dtb after the change numbered since, oldest first, or an error if
+// the database has no feed or some of those changes are not kept
+func (f *Feed) Changes(dtb string, since int64) ([]Change, error) {
+	l, older, since, err := f.gather(dtb, since)
+	if err != nil {
+		return nil, err
+	}
+	defer f.mu.Unlock()
+	kept := l.since(since)
+	if len(older) == 0 {
+		return kept, nil
+	}
+	return append(older, kept...), nil
+}
+
+// Subscribe starts streaming the feed of the database dtb. The changes made after the change numbered since, and
+// every later one, are sent as server-sent events whose ID is their sequence number. A consumer that falls more than
+// the queue depth behind is disconnected, its channel closed, and may resume from the last event it received.
+// Returns the events it starts with, the channel of the events that follow and its ID, or an error as Changes does
+func (f *Feed) Subscribe(dtb string, since int64) ([][]byte, *chan []byte, string, error) {
+	l, older, since, err := f.gather(dtb, since)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	defer f.mu.Unlock()
+	missed := append(older, l.since(since)...)
+	events := make([][]byte, len(missed))
+	for i, change := range missed {
+		events[i] = formatEvent(change)
+	}
+	f.next++
+	id := strconv.FormatInt(f.next, 10)
+	ch := make(chan []byte, f.depth)
+	l.subs[id] = &ch
+	return events, &ch, id, nil
+}
+
+// Unsubscribe stops streaming the feed of the database dtb to the consumer id
+func (f *Feed) Unsubscribe(dtb string, id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if l, found := f.logs[dtb]; found {
+		delete(l.subs, id)
+	}
+}
+
+// gather looks up the feed of dtb and locks f.mu, reading the changes made after the change numbered since that the
+// feed no longer keeps from a durable journal, without f.mu held so that no change waits for the read.
+// Returns the feed, the changes read from the journal, oldest first, and the number of the last change read, which
+// the changes the feed keeps carry on from; or, with f.mu unlocked, an error if the database has no feed or some of
+// those changes are not kept anywhere
+func (f *Feed) gather(dtb string, since int64) (*changeLog, []Change, int64, error) {
+	var last int64
+	d, isDurable := f.Journal.(durable)
+	if isDurable {
+		last = d.LastChange(dtb)
+	}
+	f.mu.Lock()
+	l, err := f.find(dtb, since, last)
+	if err == nil || !isDurable || !errors.Is(err, ErrCompacted) {
+		if err != nil {
+			f.mu.Unlock()
+		}
+		return l, nil, since, err
+	}
+	f.mu.Unlock()
+
+	records, err := d.Records(dtb, since)
+	if errors.Is(err, persistence.ErrCompacted) {
+		return nil, nil, since, compacted(since)
+	}
+	if err != nil {
+		return nil, nil, since, err
+	}
+	var older []Change
+	for _, rec := range records {
+		for _, change := range changesOf(rec) {
+			if change.Seq > since {
+				older = append(older, change)
+			}
+		}
+	}
+	from := since
+	if len(older) > 0 {
+		from = older[len(older)-1].Seq
+	}
+	f.mu.Lock()
+	if l, err = f.find(dtb, from, last); err != nil {
+		f.mu.Unlock()
+		return nil, nil, since, err
+	}
+	return l, older, from, nil
+}
+
+// find looks up the feed of dtb, checking that every change made after the change numbered since is kept. last is
+// the number of the latest change a durable journal logged for dtb, which a database none of whose changes were
+// replayed still has.
+// The caller must hold f.mu.
+func (f *Feed) find(dtb string, since int64, last int64) (*changeLog, error) {
+	l, found := f.logs[dtb]
+	if !found {
+		if last == 0 {
+			return nil, ErrNoDatabase
+		}
+		l = f.log(dtb)
+		l.seq = last
+	}
+	if since < 0 || since > l.seq {
+		return nil, fmt.Errorf("no change numbered %d has been made", since)
+	}
+	if since < l.seq && (len(l.changes) == 0 || since < l.changes[0].Seq-1) {
+		return nil, compacted(since)
+	}
+	return l, nil
+}
+
+// compacted describes the changes made after the change numbered since as no longer kept
+func compacted(since int64) error {
+	return fmt.Errorf("%w: the changes since %d have been compacted away, read the database again", ErrCompacted, since)
+}
+
+// since returns the changes kept after the change numbered seq
+func (l *changeLog) since(seq int64) []Change {
+	for i, change := range l.changes {
+		if change.Seq > seq {
+			return append([]Change(nil), l.changes[i:]...)
+		}
+	}
+	return make([]Change, 0)
+}
+
+// log returns the feed of the database dtb, creating it if there is none. The caller must hold f.mu.
+func (f *Feed) log(dtb string) *changeLog {
+	l, found := f.logs[dtb]
+	if !found {
+		l = &changeLog{subs: make(map[string]*chan []byte)}
+		f.logs[dtb] = l
+	}
+	return l
+}
+
+// append numbers a change of the database dtb and adds it to its feed, sending it to the consumers streaming it.
+// The caller must hold f.mu.
+func (f *Feed) append(dtb string, change Change) {
+	l := f.log(dtb)
+	l.seq++
+	change.Seq = l.seq
+	if change.Timestamp == 0 {
+		change.Timestamp = time.Now().UnixMilli()
+	}
+	l.changes = append(l.changes, change)
+	if len(l.changes) > Limit {
+		l.changes = l.changes[len(l.changes)-Limit:]
+	}
+	event := formatEvent(change)
+	for id, ch := range l.subs {
+		select {
+		case *ch <- event:
+		default:
+			//dropping the event would leave a gap, so the consumer is disconnected and resumes from its last event
+			slog.Warn("Disconnecting a consumer of the change feed, its queue is full", "db", dtb, "id", id)
+			delete(l.subs, id)
+			close(*ch)
+		}
+	}
+}
+
+// record has the journal record a mutation of the database dtb, then adds the changes it made to its feed unless
+// journaling failed. f.mu is not held while the journal writes, so that no reader of a feed waits for it. A durable
+// journal numbers the changes as it logs them and hands them to logged, in the order it logged them; otherwise they
+// are numbered here, under f.mu, once journaled.
+// Returns the error of the journal
+func (f *Feed) record(dtb string, journal func() error, changes ...Change) error {
+	if err := journal(); err != nil {
+		return err
+	}
+	if _, ok := f.Journal.(durable); ok {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, change := range changes {
+		f.append(dtb, change)
+	}
+	return nil
+}
+
+// logged adds the changes a record of the write-ahead log made to the feed of its database, numbered and timed as
+// they were when it was logged. The log tells it of every record it replays or logs, in order.
+func (f *Feed) logged(rec persistence.Record) {
+	changes := changesOf(rec)
+	if len(changes) == 0 {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.log(rec.DB).seq = rec.Change - 1
+	for _, change := range changes {
+		f.append(rec.DB, change)
+	}
+}
+
+// changesOf returns the changes a record of the write-ahead log made to its database, numbered and timed as they were
+// when it was logged, or nothing if it made none or predates the numbering of changes
+func changesOf(rec persistence.Record) []Change {
+	var changes []Change
+	switch rec.Op {
+	case persistence.OpCreateDB, persistence.OpDeleteDB:
+		changes = []Change{{Op: rec.Op, Path: "/", User: rec.User}}
+	case persistence.OpImportDB:
+		changes = []Change{{Op: rec.Op, Path: "/", User: rec.User, Body: rec.Body}}
+	case persistence.OpPutDoc:
+		changes = []Change{putDoc(rec.Path, rec.Body)}
+	case persistence.OpDeleteDoc:
+		changes = []Change{{Op: rec.Op, Path: "/" + rec.Path, User: rec.User}}
+	case persistence.OpPutCol, persistence.OpDeleteCol:
+		changes = []Change{{Op: rec.Op, Path: "/" + rec.Path + "/", User: rec.User}}
+	case persistence.OpTransaction:
+		var writes []persistence.Record
+		json.Unmarshal(rec.Body, &writes)
+		for _, write := range writes {
+			if write.Op == persistence.OpDeleteDoc {
+				changes = append(changes, Change{Op: write.Op, Path: "/" + write.Path, User: rec.User})
+			} else {
+				changes = append(changes, putDoc(write.Path, write.Body))
+			}
+		}
+	}
+	if rec.Change == 0 {
+		return nil
+	}
+	for i := range changes {
+		changes[i].Seq = rec.Change + int64(i)
+		changes[i].Timestamp = rec.Time
+	}
+	return changes
+}
+
+// putDoc describes the new state of the document at docpath, serial being the document as returned by GetSerial
+func putDoc(docpath string, serial []byte) Change {
+	var doc struct {
+		Doc  json.RawMessage `json:"doc"`
+		Meta struct {
+			LastModifiedBy string `json:"lastModifiedBy"`
+		} `json:"meta"`
+	}
+	json.Unmarshal(serial, &doc)
+	return Change{Op: persistence.OpPutDoc, Path: "/" + docpath, User: doc.Meta.LastModifiedBy, Body: doc.Doc}
+}
+
+// RecordCreateDB records the creation of the database dbName by user
+func (f *Feed) RecordCreateDB(dbName string, user string) error {
+	return f.record(dbName, func() error {
+		return f.Journal.RecordCreateDB(dbName, user)
+	}, Change{Op: persistence.OpCreateDB, Path: "/", User: user})
+}
+
+// RecordDeleteDB records the deletion of the database dbName by user; its feed is kept
+func (f *Feed) RecordDeleteDB(dbName string, user string) error {
+	return f.record(dbName, func() error {
+		return f.Journal.RecordDeleteDB(dbName, user)
+	}, Change{Op: persistence.OpDeleteDB, Path: "/", User: user})
+}
+
+// RecordPutDoc records the new state of the document at docpath; serial is the document as returned by GetSerial,
+// whose metadata names the user who wrote it
+func (f *Feed) RecordPutDoc(dbName string, docpath string, serial []byte) error {
+	return f.record(dbName, func() error {
+		return f.Journal.RecordPutDoc(dbName, docpath, serial)
+	}, putDoc(docpath, serial))
+}
+
+// RecordPutCol records the creation of the collection at colpath by user
+func (f *Feed) RecordPutCol(dbName string, colpath string, user string) error {
+	return f.record(dbName, func() error {
+		return f.Journal.RecordPutCol(dbName, colpath, user)
+	}, Change{Op: persistence.OpPutCol, Path: "/" + colpath + "/", User: user})
+}
+
+// RecordDeleteDoc records the deletion of the document at docpath by user
+func (f *Feed) RecordDeleteDoc(dbName string, docpath string, user string) error {
+	return f.record(dbName, func() error {
+		return f.Journal.RecordDeleteDoc(dbName, docpath, user)
+	}, Change{Op: persistence.OpDeleteDoc, Path: "/" + docpath, User: user})
+}
+
+// RecordDeleteCol records the deletion of the collection at colpath by user
+func (f *Feed) RecordDeleteCol(dbName string, colpath string, user string) error {
+	return f.record(dbName, func() error {
+		return f.Journal.RecordDeleteCol(dbName, colpath, user)
+	}, Change{Op: persistence.OpDeleteCol, Path: "/" + colpath + "/", User: user})
+}
+
+// RecordImportDB records the replacement of the database dbName by an import made by user; entries is a JSON array
+// holding every imported entry
+func (f *Feed) RecordImportDB(dbName string, entries []byte, user string) error {
+	return f.record(dbName, func() error {
+		return f.Journal.RecordImportDB(dbName, entries, user)
+	}, Change{Op: persistence.OpImportDB, Path: "/", User: user, Body: entries})
+}
+
+// RecordTransaction records the writes of a transaction made by user as consecutive changes. The document at
+// docpaths[i] was left in the state serials[i], or deleted if serials[i] is nil
+func (f *Feed) RecordTransaction(dbName string, docpaths []string, serials [][]byte, user string) error {
+	changes := make([]Change, len(docpaths))
+	for i, docpath := range docpaths {
+		if serials[i] == nil {
+			changes[i] = Change{Op: persistence.OpDeleteDoc, Path: "/" + docpath, User: user}
+		} else {
+			changes[i] = putDoc(docpath, serials[i])
+		}
+	}
+	return f.record(dbName, func() error {
+		return f.Journal.RecordTransaction(dbName, docpaths, serials, user)
+	}, changes...)
+}
+
+// formatEvent formats change as a server-sent event whose ID is its sequence number
+func formatEvent(change Change) []byte {
+	data, _ := json.Marshal(change)
+	return []byte("event: change\ndata: " + string(data) + "\nid: " + strconv.FormatInt(change.Seq, 10) + "\n\n")
+}
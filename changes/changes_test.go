@@ -0,0 +1,308 @@
+package changes
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/fieldIndex"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/mocks"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/persistence"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/precondition"
+)
+
+func TestFeed_Record(t *testing.T) {
+	journal := &mocks.MockJournal{}
+	f := New(journal, 8)
+	f.RecordCreateDB("db", "alice")
+	f.RecordPutDoc("db", "doc", []byte(`{"path":"/doc","doc":{"a":1},"meta":{"lastModifiedBy":"bob"}}`))
+	f.RecordPutCol("db", "doc/col", "alice")
+	f.RecordTransaction("db", []string{"doc/col/a", "doc"}, [][]byte{[]byte(`{"path":"/doc/col/a","doc":{},"meta":{"lastModifiedBy":"carol"}}`), nil}, "carol")
+	f.RecordDeleteCol("db", "doc/col", "bob")
+	f.RecordCreateDB("other", "bob")
+	f.RecordDeleteDB("db", "alice")
+
+	if len(journal.Ops) != 7 {
+		t.Errorf("TestFeed_Record failed, expected every change to be journaled, got %v", journal.Ops)
+	}
+	list, err := f.Changes("db", 0)
+	if err != nil {
+		t.Fatalf("TestFeed_Record failed: %v", err)
+	}
+	type change struct{ op, path, user, body string }
+	got := make([]change, len(list))
+	for i, c := range list {
+		if c.Seq != int64(i+1) || c.Timestamp == 0 {
+			t.Errorf("TestFeed_Record failed, expected change %d to be numbered and timed, got %+v", i+1, c)
+		}
+		got[i] = change{c.Op, c.Path, c.User, string(c.Body)}
+	}
+	want := []change{
+		{"createdb", "/", "alice", ""},
+		{"putdoc", "/doc", "bob", `{"a":1}`},
+		{"putcol", "/doc/col/", "alice", ""},
+		{"putdoc", "/doc/col/a", "carol", `{}`},
+		{"deletedoc", "/doc", "carol", ""},
+		{"deletecol", "/doc/col/", "bob", ""},
+		{"deletedb", "/", "alice", ""},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("TestFeed_Record failed, expected %v, got %v", want, got)
+	}
+	if other, _ := f.Changes("other", 0); len(other) != 1 || other[0].Seq != 1 {
+		t.Errorf("TestFeed_Record failed, expected each database to be numbered on its own, got %+v", other)
+	}
+}
+
+func TestFeed_Changes(t *testing.T) {
+	f := New(&mocks.MockJournal{}, 8)
+	if _, err := f.Changes("db", 0); err != ErrNoDatabase {
+		t.Errorf("TestFeed_Changes failed, expected ErrNoDatabase, got %v", err)
+	}
+	f.RecordCreateDB("db", "alice")
+	for i := 0; i < Limit+5; i++ {
+		f.RecordDeleteDoc("db", "doc", "alice")
+	}
+	if _, err := f.Changes("db", 5); err == nil {
+		t.Errorf("TestFeed_Changes failed, expected the changes no longer kept to be unavailable")
+	}
+	if _, err := f.Changes("db", Limit+7); err == nil {
+		t.Errorf("TestFeed_Changes failed, expected the changes not made yet to be unavailable")
+	}
+	list, err := f.Changes("db", 6)
+	if err != nil || len(list) != Limit || list[0].Seq != 7 {
+		t.Errorf("TestFeed_Changes failed, expected every change kept, got %d changes and %v", len(list), err)
+	}
+	if list, err := f.Changes("db", Limit+6); err != nil || len(list) != 0 {
+		t.Errorf("TestFeed_Changes failed, expected no change after the latest one, got %+v and %v", list, err)
+	}
+}
+
+func TestFeed_Subscribe(t *testing.T) {
+	f := New(&mocks.MockJournal{}, 2)
+	f.RecordCreateDB("db", "alice")
+	f.RecordPutCol("db", "col", "alice")
+
+	initial, ch, id, err := f.Subscribe("db", 1)
+	if err != nil || len(initial) != 1 || !strings.Contains(string(initial[0]), "\nid: 2\n") {
+		t.Fatalf("TestFeed_Subscribe failed, expected the second change to be replayed, got %q and %v", initial, err)
+	}
+	f.RecordDeleteCol("db", "col", "alice")
+	if event := <-*ch; !strings.HasPrefix(string(event), "event: change\ndata: ") || !strings.Contains(string(event), `"op":"deletecol"`) || !strings.HasSuffix(string(event), "\nid: 3\n\n") {
+		t.Errorf("TestFeed_Subscribe failed, unexpected event %q", event)
+	}
+
+	//a consumer that falls behind is disconnected rather than sent a feed with gaps
+	for i := 0; i < 3; i++ {
+		f.RecordPutCol("db", "col", "alice")
+	}
+	count := 0
+	for range *ch {
+		count++
+	}
+	if count != 2 {
+		t.Errorf("TestFeed_Subscribe failed, expected the queued events before the channel closed, got %d", count)
+	}
+
+	_, ch, id, _ = f.Subscribe("db", 6)
+	f.Unsubscribe("db", id)
+	f.RecordPutCol("db", "col", "alice")
+	if len(*ch) != 0 {
+		t.Errorf("TestFeed_Subscribe failed, expected no events after unsubscribing")
+	}
+}
+
+// replayTarget journals every operation replayed onto it back to its feed, as the services of the server do
+type replayTarget struct {
+	f *Feed
+}
+
+func (r replayTarget) CreateDB(dbName string, user string) ([]byte, int, string) {
+	r.f.RecordCreateDB(dbName, user)
+	return nil, http.StatusCreated, ""
+}
+
+func (r replayTarget) PutCol(dtb string, colpath string, user string) ([]byte, int, string) {
+	r.f.RecordPutCol(dtb, colpath, user)
+	return nil, http.StatusCreated, ""
+}
+
+func (r replayTarget) RestoreDoc(dbName string, docpath string, serial []byte) ([]byte, int) {
+	r.f.RecordPutDoc(dbName, docpath, serial)
+	return nil, http.StatusCreated
+}
+
+func (r replayTarget) ImportDB(dbName string, dump []byte, user string) ([]byte, int, string) {
+	return nil, http.StatusCreated, ""
+}
+
+func (r replayTarget) PutIndex(dtb string, colpath string, def fieldIndex.Definition) ([]byte, int, string) {
+	return nil, http.StatusCreated, ""
+}
+
+func (r replayTarget) DeleteDB(dbName string, user string) ([]byte, int) {
+	return nil, http.StatusNoContent
+}
+
+func (r replayTarget) DeleteCol(dtb string, colpath string, user string) ([]byte, int) {
+	return nil, http.StatusNoContent
+}
+
+func (r replayTarget) DeleteDoc(dbName string, docpath string, user string, cond precondition.Conditions) ([]byte, int) {
+	r.f.RecordDeleteDoc(dbName, docpath, user)
+	return nil, http.StatusNoContent
+}
+
+func (r replayTarget) DeleteIndex(dtb string, colpath string, field string) ([]byte, int) {
+	return nil, http.StatusNoContent
+}
+
+func TestFeed_Restore(t *testing.T) {
+	dir := t.TempDir()
+	wal, err := persistence.Open(dir)
+	if err != nil {
+		t.Fatalf("TestFeed_Restore failed, unable to open the log: %v", err)
+	}
+	f := New(wal, 8)
+	f.RecordCreateDB("db", "alice")
+	f.RecordPutIndex("db", "doc/col", fieldIndex.Definition{Field: "/email"})
+	f.RecordTransaction("db", []string{"a", "b"}, [][]byte{[]byte(`{"path":"/a","doc":{},"meta":{"lastModifiedBy":"carol"}}`), nil}, "carol")
+	f.RecordPutCol("db", "a/col", "bob")
+	before, _ := f.Changes("db", 0)
+	wal.Close()
+
+	wal, err = persistence.Open(dir)
+	if err != nil {
+		t.Fatalf("TestFeed_Restore failed, unable to reopen the log: %v", err)
+	}
+	defer wal.Close()
+	restored := New(wal, 8)
+	if err = wal.Replay(replayTarget{restored}, replayTarget{restored}); err != nil {
+		t.Fatalf("TestFeed_Restore failed, unable to replay the log: %v", err)
+	}
+	if restored.Epoch() != f.Epoch() {
+		t.Errorf("TestFeed_Restore failed, expected the epoch %s to be kept, got %s", f.Epoch(), restored.Epoch())
+	}
+	after, err := restored.Changes("db", 0)
+	if err != nil || !reflect.DeepEqual(seqs(after), []int64{1, 2, 3, 4}) {
+		t.Fatalf("TestFeed_Restore failed, expected the changes to be restored once, as numbered, got %+v, %v", after, err)
+	}
+	for i := range after {
+		if after[i].Op != before[i].Op || after[i].Path != before[i].Path || after[i].User != before[i].User || string(after[i].Body) != string(before[i].Body) {
+			t.Errorf("TestFeed_Restore failed, expected %+v, got %+v", before[i], after[i])
+		}
+	}
+	restored.RecordDeleteDoc("db", "a", "bob")
+	if list, _ := restored.Changes("db", 4); len(list) != 1 || list[0].Seq != 5 {
+		t.Errorf("TestFeed_Restore failed, expected the numbering to continue, got %+v", list)
+	}
+}
+
+// emptySource is a database server holding nothing, whose snapshots only bound the log
+type emptySource struct{}
+
+func (emptySource) Walk(ctx context.Context, visitDB func(dbName string), visitCol func(dbName string, colpath string, indexes []fieldIndex.Definition), visitDoc func(dbName string, docpath string, serial []byte)) error {
+	return nil
+}
+
+func TestFeed_History(t *testing.T) {
+	dir := t.TempDir()
+	wal, err := persistence.Open(dir)
+	if err != nil {
+		t.Fatalf("TestFeed_History failed, unable to open the log: %v", err)
+	}
+	f := New(wal, 8)
+	f.RecordCreateDB("db", "alice")
+	f.RecordPutCol("db", "col", "alice")
+	wal.Snapshot(context.Background(), emptySource{}, 2)
+	f.RecordDeleteCol("db", "col", "bob")
+	wal.Snapshot(context.Background(), emptySource{}, 2)
+	f.RecordPutCol("db", "col", "bob")
+	wal.Close()
+
+	//only the change logged since the latest snapshot is replayed, the others are read back from the log
+	wal, err = persistence.Open(dir)
+	if err != nil {
+		t.Fatalf("TestFeed_History failed, unable to reopen the log: %v", err)
+	}
+	defer wal.Close()
+	restored := New(wal, 8)
+	if err = wal.Replay(replayTarget{restored}, replayTarget{restored}); err != nil {
+		t.Fatalf("TestFeed_History failed, unable to replay the log: %v", err)
+	}
+	list, err := restored.Changes("db", 0)
+	if err != nil || !reflect.DeepEqual(seqs(list), []int64{1, 2, 3, 4}) || list[2].Op != persistence.OpDeleteCol {
+		t.Fatalf("TestFeed_History failed, expected every change still logged, got %+v, %v", list, err)
+	}
+	initial, _, id, err := restored.Subscribe("db", 1)
+	if err != nil || len(initial) != 3 || !strings.Contains(string(initial[0]), "\nid: 2\n") {
+		t.Errorf("TestFeed_History failed, expected a consumer to resume from the log, got %q, %v", initial, err)
+	}
+	restored.Unsubscribe("db", id)
+
+	//the segment holding the first changes goes once no snapshot needs it
+	wal.Snapshot(context.Background(), emptySource{}, 2)
+	if _, err = restored.Changes("db", 0); !errors.Is(err, ErrCompacted) {
+		t.Errorf("TestFeed_History failed, expected the changes compacted away to be reported, got %v", err)
+	}
+	if list, err = restored.Changes("db", 2); err != nil || !reflect.DeepEqual(seqs(list), []int64{3, 4}) {
+		t.Errorf("TestFeed_History failed, expected the changes still logged, got %+v, %v", list, err)
+	}
+}
+
+// blockingJournal holds every change in the middle of being journaled until release is closed
+type blockingJournal struct {
+	*mocks.MockJournal
+	journaling chan struct{} // receives once a change is being journaled
+	release    chan struct{}
+}
+
+func (j *blockingJournal) RecordPutCol(dbName string, colpath string, user string) error {
+	j.journaling <- struct{}{}
+	<-j.release
+	return j.MockJournal.RecordPutCol(dbName, colpath, user)
+}
+
+func TestFeed_RecordDoesNotBlockReaders(t *testing.T) {
+	journal := &blockingJournal{MockJournal: &mocks.MockJournal{}, journaling: make(chan struct{}), release: make(chan struct{})}
+	f := New(journal, 8)
+	f.RecordCreateDB("db", "alice")
+	done := make(chan struct{})
+	go func() {
+		f.RecordPutCol("db", "col", "alice")
+		close(done)
+	}()
+	<-journal.journaling
+
+	read := make(chan []Change)
+	go func() {
+		list, _ := f.Changes("db", 0)
+		read <- list
+	}()
+	select {
+	case list := <-read:
+		if len(list) != 1 {
+			t.Errorf("TestFeed_RecordDoesNotBlockReaders failed, expected only the change journaled, got %+v", list)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("TestFeed_RecordDoesNotBlockReaders failed, a reader waited for the journal")
+	}
+	close(journal.release)
+	<-done
+	if list, _ := f.Changes("db", 0); len(list) != 2 {
+		t.Errorf("TestFeed_RecordDoesNotBlockReaders failed, expected the change to be added once journaled, got %+v", list)
+	}
+}
+
+// seqs returns the sequence numbers of changes
+func seqs(changes []Change) []int64 {
+	res := make([]int64, len(changes))
+	for i, change := range changes {
+		res[i] = change.Seq
+	}
+	return res
+}
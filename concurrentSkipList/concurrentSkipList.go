@@ -22,7 +22,7 @@ type node[K cmp.Ordered, V any] struct {
 
 	key K //the key the node stores
 
-	val V //the value the node stores
+	val atomic.Pointer[V] //the value the node stores, swapped whole so that Find and Query can read it without the lock
 
 	nexts []atomic.Pointer[node[K, V]] //an array of pointers to the next nodes in the skiplist
 
@@ -31,6 +31,15 @@ type node[K cmp.Ordered, V any] struct {
 	beingUpdated atomic.Bool
 }
 
+// value returns the value the node stores, or the zero value of V for the head and tail
+func (n *node[K, V]) value() V {
+	if p := n.val.Load(); p != nil {
+		return *p
+	}
+	var nullV V
+	return nullV
+}
+
 // Skiplist implements an abstract set of key-value pairs, of type K and V respectively.
 // This list employs lazy synchronization for concurrent operations.
 type Skiplist[K cmp.Ordered, V any] struct {
@@ -49,7 +58,7 @@ type Skiplist[K cmp.Ordered, V any] struct {
 // Returns a pointer to the newly created skiplist
 func NewSL[K cmp.Ordered, V any](minkey K, maxkey K) *Skiplist[K, V] {
 
-	dummyHead := node[K, V]{} 
+	dummyHead := node[K, V]{}
 
 	dummyHead.key = minkey
 
@@ -65,16 +74,16 @@ func NewSL[K cmp.Ordered, V any](minkey K, maxkey K) *Skiplist[K, V] {
 
 	dummyTail.nexts = make([]atomic.Pointer[node[K, V]], 8) //prealloc saves memory and space
 
-	dummyTail.marked.Store(false) 
+	dummyTail.marked.Store(false)
 
-	dummyTail.topLevel = 8 
+	dummyTail.topLevel = 8
 
-    // Initialize the head and tail nodes
+	// Initialize the head and tail nodes
 	for i := 0; i < 8; i++ {
-		dummyHead.nexts[i].Store(&dummyTail) 
-		dummyTail.nexts[i].Store(nil) 
+		dummyHead.nexts[i].Store(&dummyTail)
+		dummyTail.nexts[i].Store(nil)
 	}
-    // Initialize the skiplist
+	// Initialize the skiplist
 	var sl Skiplist[K, V] = Skiplist[K, V]{
 		minK: minkey,
 		maxK: maxkey,
@@ -92,7 +101,7 @@ func (sl *Skiplist[K, V]) getState() {
 
 		cur := sl.head
 		for cur != nil {
-			slog.Debug(fmt.Sprintf("%+v (%+v) ----", cur.key, cur.val))
+			slog.Debug(fmt.Sprintf("%+v (%+v) ----", cur.key, cur.value()))
 			cur = cur.nexts[curLevel].Load()
 		}
 		slog.Debug(fmt.Sprintf("<nil>"))
@@ -163,16 +172,19 @@ func (sl *Skiplist[K, V]) Upsert(key K, check index_utils.UpdateCheck[K, V]) (up
 				slog.Debug(fmt.Sprintf("A node with this key exists: %+v \n", key))
 
 				foundNode.mtx.Lock()
+				if foundNode.marked.Load() { //removed while we waited for the lock, so the key has to be found again
+					foundNode.mtx.Unlock()
+					continue
+				}
 
-				newVal, err1 := check(foundNode.key, foundNode.val, true)
+				newVal, err1 := check(foundNode.key, foundNode.value(), true)
 
 				if err1 == nil { //we proceed with the update
 					//Prevents other upserts from updating this value
 
-					foundNode.val = newVal
+					foundNode.val.Store(&newVal)
 
 					foundNode.mtx.Unlock()
-					slog.Debug(fmt.Sprintf("OLD VALUE IS %+v \n ", foundNode.val))
 					slog.Debug(fmt.Sprintf("Our updated value should be %+v \n", newVal))
 					sl.mutationCount.Add(1)
 					return true, nil
@@ -243,10 +255,10 @@ func (sl *Skiplist[K, V]) Upsert(key K, check index_utils.UpdateCheck[K, V]) (up
 		newNode := &node[K, V]{
 
 			key:      key,
-			val:      newVal,
 			nexts:    make([]atomic.Pointer[node[K, V]], newNodeLevel+1),
 			topLevel: newNodeLevel,
 		}
+		newNode.val.Store(&newVal)
 
 		for level := 0; level <= newNodeLevel; level++ {
 			newNode.nexts[level].Store(succs[level].Load())
@@ -286,13 +298,22 @@ func (sl *Skiplist[K, V]) Find(key K) (V, bool) {
 	found := succs[foundLevel].Load()
 	for found.beingUpdated.Load() {
 	}
-	return found.val, found.fullyLinked.Load() && !found.marked.Load()
+	return found.value(), found.fullyLinked.Load() && !found.marked.Load()
 }
 
 // Remove removes the node with key K (if it exists)
 // Returns the value of the removed node and true if the node was removed,
 // otherwise returns the zero value of V and false
 func (sl *Skiplist[K, V]) Remove(key K) (removedVal V, removed bool) {
+	removedVal, removed, _ = sl.RemoveIf(key, nil)
+	return removedVal, removed
+}
+
+// RemoveIf removes the node with key K (if it exists) provided check, run while the node is locked, returns no error;
+// a nil check always allows the removal
+// Returns the value of the removed node and true if the node was removed, otherwise returns the zero value of V, false
+// and the error returned by check, if any
+func (sl *Skiplist[K, V]) RemoveIf(key K, check index_utils.RemoveCheck[K, V]) (removedVal V, removed bool, err error) {
 	var nullV V
 	var victim *node[K, V]
 	topLevel := -1
@@ -309,19 +330,19 @@ func (sl *Skiplist[K, V]) Remove(key K) (removedVal V, removed bool) {
 		if !isMarked {
 
 			if foundLevel == -1 {
-				return nullV, false
+				return nullV, false, nil
 			}
 
 			if !victim.fullyLinked.Load() {
-				return nullV, false
+				return nullV, false, nil
 			}
 
 			if victim.marked.Load() {
-				return nullV, false
+				return nullV, false, nil
 			}
 
 			if victim.topLevel != foundLevel {
-				return nullV, false
+				return nullV, false, nil
 			}
 
 			topLevel = victim.topLevel
@@ -330,7 +351,14 @@ func (sl *Skiplist[K, V]) Remove(key K) (removedVal V, removed bool) {
 			//slog.Debug(fmt.Sprintf("LOCK SUCCESS\n"))
 			if victim.marked.Load() {
 				victim.mtx.Unlock()
-				return nullV, false
+				return nullV, false, nil
+			}
+
+			if check != nil {
+				if err := check(key, victim.value()); err != nil {
+					victim.mtx.Unlock()
+					return nullV, false, err
+				}
 			}
 
 			victim.marked.Store(true)
@@ -400,7 +428,7 @@ func (sl *Skiplist[K, V]) Remove(key K) (removedVal V, removed bool) {
 			unlockLevel -= 1
 		}
 		sl.mutationCount.Add(1)
-		return victim.val, true
+		return victim.value(), true, nil
 
 	}
 }
@@ -430,9 +458,9 @@ func (sl *Skiplist[K, V]) Query(ctx context.Context, lower K, upper K) ([]index_
 				break
 			}
 			//slog.Debug(fmt.Sprintf("current key %+v \n", iterkey))
-			if ((lower <= iterkey) && (iterkey < sl.maxK) && (sl.minK < iterkey)) && curr.fullyLinked.Load() && !curr.marked.Load() {
+			if ((lower <= iterkey) && (iterkey <= upper) && (iterkey < sl.maxK) && (sl.minK < iterkey)) && curr.fullyLinked.Load() && !curr.marked.Load() {
 				//slog.Debug(fmt.Sprintf("node with key %+v is in range!\n", iterkey))
-				pair := index_utils.Pair[K, V]{Key: curr.key, Value: curr.val}
+				pair := index_utils.Pair[K, V]{Key: curr.key, Value: curr.value()}
 				resPair = append(resPair, pair)
 
 			}
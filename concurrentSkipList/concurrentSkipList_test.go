@@ -8,6 +8,7 @@ import (
 	"strconv"
 	"sync"
 	"testing"
+	"time"
 )
 
 func TestSkiplist_Query(t *testing.T) {
@@ -20,6 +21,19 @@ func TestSkiplist_Query(t *testing.T) {
 	}
 }
 
+func TestSkiplist_QueryAboveUpper(t *testing.T) {
+	sl := NewSL[int, string](1, 100)
+	sl.Upsert(70, func(int, string, bool) (string, error) {
+		return "test70", nil
+	})
+
+	res, err := sl.Query(context.TODO(), 11, 65)
+
+	if err != nil || len(res) != 0 {
+		t.Errorf("TestSkiplist_QueryAboveUpper failed, expected no pairs, got %v", res)
+	}
+}
+
 func TestSkiplist_FindElemDoesntExist(t *testing.T) {
 	sl := NewSL[int, string](1, 100)
 
@@ -306,3 +320,65 @@ func TestUpsertFailIfNotExist(t *testing.T) {
 		t.Errorf("TestUpsertFailIfNotExist, err is nil")
 	}
 }
+
+func TestSkiplist_RemoveIf(t *testing.T) {
+	chk := func(int, string, bool) (string, error) {
+		return "hello", nil
+	}
+	sl := NewSL[int, string](-1, 100)
+	sl.Upsert(4, chk)
+
+	refuse := func(int, string) error {
+		return fmt.Errorf("refused")
+	}
+	if _, removed, err := sl.RemoveIf(4, refuse); removed || err == nil {
+		t.Errorf("TestSkiplist_RemoveIf failed, expected the removal to be refused")
+	}
+	if _, found := sl.Find(4); !found {
+		t.Errorf("TestSkiplist_RemoveIf failed, a refused removal removed the element")
+	}
+
+	allow := func(key int, val string) error {
+		if val != "hello" {
+			return fmt.Errorf("unexpected value %s", val)
+		}
+		return nil
+	}
+	removedVal, removed, err := sl.RemoveIf(4, allow)
+	if !removed || err != nil || removedVal != "hello" {
+		t.Errorf("TestSkiplist_RemoveIf failed, got %s, %t and %v", removedVal, removed, err)
+	}
+	if _, found := sl.Find(4); found {
+		t.Errorf("TestSkiplist_RemoveIf failed, element was not removed")
+	}
+}
+
+func TestUpsertWaitingOnRemoval(t *testing.T) {
+	sl := NewSL[int, string](-1, 100)
+	sl.Upsert(4, checkFactory[int, string]("old"))
+
+	inCheck := make(chan struct{})
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		sl.RemoveIf(4, func(int, string) error {
+			close(inCheck)
+			<-release
+			return nil
+		})
+	}()
+	<-inCheck
+	go func() {
+		defer wg.Done()
+		sl.Upsert(4, checkFactory[int, string]("new"))
+	}()
+	time.Sleep(20 * time.Millisecond) //lets the upsert find the node and wait for its lock
+	close(release)
+	wg.Wait()
+
+	if val, found := sl.Find(4); !found || val != "new" {
+		t.Errorf("TestUpsertWaitingOnRemoval failed, expected the upsert to insert the key again, got %s and %t", val, found)
+	}
+}
@@ -4,41 +4,103 @@ package db
 import (
 	"context"
 	"encoding/json"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/fieldIndex"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/history"
 	"github.com/RICE-COMP318-FALL24/owldb-p1group24/index_utils"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/precondition"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/query"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/transaction"
+	"time"
 )
 
 // DocumentAdder encapsulates the functionalities of the top-level documents with respect to adding new resources to the database
 type DocumentAdder interface {
-	AddChildDocument(docpath string, payload []byte, docname string, user string, overwrite bool, isPost bool, dbName string) ([]byte, int, string) //adds a child document
-	AddChildCollection(colpath string, dbName string) ([]byte, int, string)                                                                         //adds a child collection
+	AddChildDocument(docpath string, payload []byte, docname string, user string, overwrite bool, isPost bool, dbName string, cond precondition.Conditions) ([]byte, int, string) //adds a child document
+	AddChildCollection(colpath string, dbName string, user string) ([]byte, int, string)                                                                                          //adds a child collection
+	AddChildIndex(colpath string, def fieldIndex.Definition, dbName string) ([]byte, int, string)                                                                                 //adds a secondary index to a child collection
 }
 
 // DocumentDeleter encapsulates the functionalities of the top-level documents with respect to deleting resources the database
 type DocumentGetter interface {
-	GetChildDocument(docpath string, isSubscribe bool, dbName string) (payload []byte, status_code int, sub_id string, subChan *chan []byte, docEvent []byte)      //retrieves a document within the document
-	GetChildCollection(colpath string, lo string, hi string, isSubscribe bool) (res []byte, stat_code int, subChan *chan []byte, subId string, docEvents [][]byte) // retrieves a collection within the document
-	Notify(uri string, payload []byte, evType string)                                                                                                              // notifies all subscribers of a change
+	GetChildDocument(docpath string, isSubscribe bool, dbName string, lastEventID string) (payload []byte, status_code int, sub_id string, subChan *chan []byte, docEvent []byte)                          //retrieves a document within the document
+	GetChildCollection(colpath string, lo string, hi string, isSubscribe bool, opts query.Options, lastEventID string) (res []byte, stat_code int, subChan *chan []byte, subId string, docEvents [][]byte) // retrieves a collection within the document
+	GetChildDocumentHistory(docpath string, sel history.Selector) ([]byte, int)                                                                                                                            // reads the history of a document within the document
+	Notify(uri string, payload []byte, evType string)                                                                                                                                                      // notifies all subscribers of a change
+	UnsubscribeChildDocument(docpath string, dbName string, subId string)                                                                                                                                  // removes a subscriber from a document within the document
+	UnsubscribeChildCollection(colpath string, subId string)                                                                                                                                               // removes a subscriber from a collection within the document
+	GetChildSubtree(path string) (payload []byte, stat_code int, subtree [][]byte)                                                                                                                         // reads a subtree within the document
 }
 
 // DocumentDeleter encapsulates the functionalities of the top-level documents with respect to getting resources from the database
 type DocumentDeleter interface {
-	DeleteChildDocument(docpath string, dbName string) ([]byte, int) //deletes a child document
-	DeleteChildCollection(colpath string) ([]byte, int)              //deletes a child collection
+	DeleteChildDocument(docpath string, dbName string, user string, cond precondition.Conditions) ([]byte, int) //deletes a child document
+	DeleteChildCollection(colpath string, dbName string, user string) ([]byte, int)                             //deletes a child collection
+	DeleteChildIndex(colpath string, field string, dbName string) ([]byte, int)                                 //deletes a secondary index of a child collection
 }
 
 type DocumentPatcher interface {
-	ApplyPatchDocument(dbName string, docPath string, patch []byte, user string) ([]byte, int) //applies a patch to a document
-	DoPatch(patch []byte) ([]byte, error)                                                      //applies a patch to a document
-	UpdateDoc(newRaw []byte, user string)                                                      //updates a document
+	ApplyPatchDocument(dbName string, docPath string, patch []byte, user string, mediaType string, cond precondition.Conditions) ([]byte, int) //applies a patch to a document
+	DoPatch(patch []byte, mediaType string) ([]byte, error)                                                                                    //applies a patch to a document
+	UpdateDoc(newRaw []byte, user string)                                                                                                      //updates a document
+}
+
+// DocumentTransactor encapsulates the functionalities of the top-level documents with respect to transactions
+type DocumentTransactor interface {
+	TransactChildDocument(dbName string, op transaction.Operation, user string) (*transaction.Write, []byte, int) //applies an operation of a transaction to a descendant document
+}
+
+// DocumentRestorer encapsulates the functionalities of the top-level documents with respect to rebuilding resources
+// from their serialized form
+type DocumentRestorer interface {
+	Restore(serial []byte) error                                               //replaces the document's contents and metadata
+	RestoreChildDocument(docpath string, serial []byte) ([]byte, int)          //recreates a descendant document
+	RestoreChildCollection(colpath string) ([]byte, int)                       //recreates a descendant collection
+	RestoreChildIndex(colpath string, def fieldIndex.Definition) ([]byte, int) //recreates a secondary index of a descendant collection
+}
+
+// DocumentWalker encapsulates the functionalities of the top-level documents with respect to enumerating every
+// resource beneath them
+type DocumentWalker interface {
+	Walk(ctx context.Context, visitCol func(colpath string, indexes []fieldIndex.Definition), visitDoc func(docpath string, serial []byte)) error //visits the document and all of its descendants
+}
+
+// Journal records every successful mutation performed on the top-level documents of a database. A mutation the
+// journal fails to record is not applied.
+type Journal interface {
+	RecordPutDoc(dbName string, docpath string, serial []byte) error                         //records the new state of a document
+	RecordDeleteDoc(dbName string, docpath string, user string) error                        //records the deletion of a document by user
+	RecordTransaction(dbName string, docpaths []string, serials [][]byte, user string) error //records the writes of a transaction made by user as a whole; a nil serial records a deletion
+	RecordPutIndex(dbName string, colpath string, def fieldIndex.Definition) error           //records the creation of a secondary index
+	RecordDeleteIndex(dbName string, colpath string, field string) error                     //records the deletion of a secondary index
+}
+
+// FieldIndexer keeps the secondary indices of the top-level collection, which map the values of a document field to
+// the names of the documents holding them
+type FieldIndexer interface {
+	Create(def fieldIndex.Definition) (created bool, err error)                         // Adds an index; the documents already held must then be passed to Update
+	Drop(field string) (dropped bool)                                                   // Removes the index on a field
+	Definitions() []fieldIndex.Definition                                               // Describes every index
+	Update(docname string, oldDoc []byte, newDoc []byte) error                          // Reindexes a document; nil stands for a missing document. Fails with fieldIndex.ErrDuplicate on a unique field
+	Reindex(docname string, oldDoc []byte, newDoc []byte)                               // Reindexes a document without enforcing unique fields
+	Lookup(ctx context.Context, filter *query.Filter) (docnames []string, indexed bool) // Returns the only documents that may satisfy a filter
 }
 
 // ColSubscriptionManager represents the contract necessary for the database's top-level collection to manage subscriptions
 type ColSubscriptionManager interface {
-	NotifyAll(colname string)                                             //notifies all subscribers of a change
-	AddSubscriber(lo string, hi string) (subChan *chan []byte, id string) //adds a subscriber
-	Notify(docname string, evType string, payload []byte)                 //notifies a subscriber of a change
-	GenerateEvent(evType string, content []byte) []byte                   //generates an event
+	NotifyAll(colname string)                                                                                                                                      //notifies all subscribers of a change
+	AddSubscriber(lo string, hi string, filter func(serial []byte) bool, lastEventID string, snapshot [][]byte) (subChan *chan []byte, id string, events [][]byte) //adds a subscriber to the documents satisfying filter, if not nil, returning the events it starts with
+	Notify(docname string, evType string, payload []byte)                                                                                                          //notifies a subscriber of a change
+	GenerateEvent(evType string, content []byte) []byte                                                                                                            //generates an event
+	Remove(id string)                                                                                                                                              //removes a subscriber
+}
+
+// TreeSubscriber manages the subscriptions to whole subtrees of the databases, which every resource reports its changes to
+type TreeSubscriber interface {
+	AddTreeSubscriber(uri string, lastEventID string, snapshot [][]byte) (subChan *chan []byte, id string, events [][]byte) //adds a subscriber to a subtree, returning the events it starts with
+	RemoveTreeSubscriber(uri string, id string)                                                                             //removes a subscriber from a subtree
+	NotifyDocs(uri string, evtype string, payload []byte)                                                                   //notifies the subscribers of a resource, and of the subtrees holding it
 }
+
 type Validator interface {
 	Validate([]byte) error //validates a document
 }
@@ -52,15 +114,21 @@ type DBDocumenter interface {
 	DocumentGetter
 	DocumentDeleter
 	DocumentPatcher
+	DocumentTransactor
+	DocumentRestorer
+	DocumentWalker
 	GetSerial() []byte
+	Body() []byte
+	Version() int64
 }
 
 // DocIndex encompasses the behaviors needed for the indices in a document (pointing to collections)
 type DocIndex[K string, V any] interface {
-	Upsert(key K, check index_utils.UpdateCheck[K, V]) (updated bool, err error)             //Updates or inserts a a value
-	Remove(key K) (removedValue V, removed bool)                                             //Removes a value
-	Find(key K) (foundValue V, found bool)                                                   // Finds a value
-	Query(ctx context.Context, start K, end K) (results []index_utils.Pair[K, V], err error) //Queries the index
+	Upsert(key K, check index_utils.UpdateCheck[K, V]) (updated bool, err error)                   //Updates or inserts a a value
+	Remove(key K) (removedValue V, removed bool)                                                   //Removes a value
+	RemoveIf(key K, check index_utils.RemoveCheck[K, V]) (removedValue V, removed bool, err error) //Removes a value if check allows it
+	Find(key K) (foundValue V, found bool)                                                         // Finds a value
+	Query(ctx context.Context, start K, end K) (results []index_utils.Pair[K, V], err error)       //Queries the index
 }
 
 // DocFactory is a factory function used to create documents
@@ -75,22 +143,48 @@ type Database[K string, T DBDocumenter] struct {
 
 	colSubscriptionManager ColSubscriptionManager // colSubscriptionManager manages subscriptions
 
+	indexes FieldIndexer // indexes holds the secondary indices of the top-level collection
+
+	tree TreeSubscriber // tree manages the subscriptions to subtrees of the database
+
 	validator Validator // validator validates documents
+
+	journal Journal // journal records mutations to the top-level documents
+
+	// docLocks locks the documents written by a transaction until it ends. Every other read or write of a single
+	// document locks it too, so it waits only for the transactions writing that document.
+	docLocks docLocks
+
+	// gate keeps the requests spanning many documents (collection reads, index builds, subtree subscriptions, walks)
+	// and transactions apart, so that none of the former sees a transaction half applied. Transactions run alongside
+	// each other, as do the requests spanning many documents.
+	gate sidedLock
 }
 
 // New creates a database object
-func New[K string, T DBDocumenter](name string, dcf DocFactory[T], index DocIndex[K, T], manager ColSubscriptionManager, v Validator) *Database[K, T] {
+func New[K string, T DBDocumenter](name string, dcf DocFactory[T], index DocIndex[K, T], manager ColSubscriptionManager, indexes FieldIndexer, tree TreeSubscriber, v Validator, journal Journal) *Database[K, T] {
 	db := Database[K, T]{}
 	db.dcf = dcf
 	db.name = name
 	db.docs = index
 	db.colSubscriptionManager = manager
+	db.indexes = indexes
+	db.tree = tree
 	db.validator = v
+	db.journal = journal
 	return &db
 }
 
-// NotifyAll notifies all subscribers to a DB that a database has been deleted
+// NotifyAll notifies all subscribers to a DB, or to any resource within it, that a database has been deleted, ending
+// their subscriptions
 func (db *Database[K, T]) NotifyAll(colname string) {
 	b, _ := json.Marshal("/")
 	db.colSubscriptionManager.NotifyAll(string(b))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	docs, _ := db.docs.Query(ctx, K(string(rune(0))), K(string(rune(127))))
+	for _, doc := range docs {
+		doc.Value.Notify(db.name+"/"+string(doc.Key), b, "delete")
+	}
+	db.tree.NotifyDocs(db.name+"/", "delete", b)
 }
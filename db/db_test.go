@@ -1,14 +1,23 @@
-
 package db
 
 import (
 	"cmp"
 	"context"
+	"errors"
+	"fmt"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/fieldIndex"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/history"
 	"github.com/RICE-COMP318-FALL24/owldb-p1group24/index_utils"
 	"github.com/RICE-COMP318-FALL24/owldb-p1group24/mocks"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/precondition"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/query"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/transaction"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"sync"
 	"testing"
+	"time"
 )
 
 // AddChildDocument(docpath string, payload []byte, docname string, user string, overwrite bool, isPost bool, dbName string) ([]byte, int) //adds a child document
@@ -35,6 +44,21 @@ func (m *mockSL[K, V]) Remove(key K) (foundValue V, found bool) {
 	return v, true
 }
 
+func (m *mockSL[K, V]) RemoveIf(key K, check index_utils.RemoveCheck[K, V]) (foundValue V, found bool, err error) {
+	v, ok := m.sl[key]
+	if !ok {
+		return v, false, nil
+	}
+	if check != nil {
+		if err := check(key, v); err != nil {
+			var nullV V
+			return nullV, false, err
+		}
+	}
+	delete(m.sl, key)
+	return v, true, nil
+}
+
 func (m *mockSL[K, V]) Query(ctx context.Context, low K, hi K) (result []index_utils.Pair[K, V], err error) {
 
 	res := make([]index_utils.Pair[K, V], 0)
@@ -62,27 +86,39 @@ func (m *mockSL[K, V]) Upsert(key K, check index_utils.UpdateCheck[K, V]) (bool,
 type mockDoc struct {
 }
 
-func (m mockDoc) AddChildDocument(docpath string, payload []byte, docname string, user string, overwrite bool, isPost bool, dbName string) ([]byte, int, string) {
+func (m mockDoc) AddChildDocument(docpath string, payload []byte, docname string, user string, overwrite bool, isPost bool, dbName string, cond precondition.Conditions) ([]byte, int, string) {
 	return nil, 201, "/v1/db/dummy/dummy/"
 }
 
-func (m mockDoc) AddChildCollection(colpath string, dbName string) ([]byte, int, string) {
+func (m mockDoc) AddChildCollection(colpath string, dbName string, user string) ([]byte, int, string) {
 	return nil, 201, "/v1/db/dummy/dummy/"
 }
 
-func (m mockDoc) GetChildDocument(docpath string, isSubscribe bool, dbName string) (payload []byte, status_code int, sub_id string, subChan *chan []byte, docEvent []byte) {
+func (m mockDoc) AddChildIndex(colpath string, def fieldIndex.Definition, dbName string) ([]byte, int, string) {
+	return nil, 201, "/v1/db/dummy/dummy/?index=" + url.QueryEscape(def.Field)
+}
+
+func (m mockDoc) GetChildDocument(docpath string, isSubscribe bool, dbName string, lastEventID string) (payload []byte, status_code int, sub_id string, subChan *chan []byte, docEvent []byte) {
 	return payload, 200, "", nil, nil
 }
 
-func (m mockDoc) GetChildCollection(colpath string, lo string, hi string, isSubscribe bool) (res []byte, stat_code int, subChan *chan []byte, subId string, docEvents [][]byte) {
+func (m mockDoc) GetChildCollection(colpath string, lo string, hi string, isSubscribe bool, opts query.Options, lastEventID string) (res []byte, stat_code int, subChan *chan []byte, subId string, docEvents [][]byte) {
 	return nil, 200, nil, "", nil
 }
 
-func (m mockDoc) DeleteChildDocument(docpath string, dbName string) ([]byte, int) {
+func (m mockDoc) GetChildDocumentHistory(docpath string, sel history.Selector) ([]byte, int) {
+	return []byte("PLACEHOLDER"), http.StatusOK
+}
+
+func (m mockDoc) DeleteChildDocument(docpath string, dbName string, user string, cond precondition.Conditions) ([]byte, int) {
 	return nil, http.StatusNoContent
 }
 
-func (m mockDoc) DeleteChildCollection(colpath string) ([]byte, int) {
+func (m mockDoc) DeleteChildCollection(colpath string, dbName string, user string) ([]byte, int) {
+	return nil, http.StatusNoContent
+}
+
+func (m mockDoc) DeleteChildIndex(colpath string, field string, dbName string) ([]byte, int) {
 	return nil, http.StatusNoContent
 }
 
@@ -90,13 +126,23 @@ func (m mockDoc) Notify(uri string, payload []byte, evType string) {
 	slog.Debug("Notify called")
 }
 
-func (m mockDoc) ApplyPatchDocument(dbName string, docPath string, patch []byte, user string) ([]byte, int) {
+func (m mockDoc) GetChildSubtree(path string) ([]byte, int, [][]byte) {
+	return nil, 200, [][]byte{[]byte(`{"path":"/` + path + `"}`)}
+}
+
+func (m mockDoc) UnsubscribeChildDocument(docpath string, dbName string, subId string) {
+}
+
+func (m mockDoc) UnsubscribeChildCollection(colpath string, subId string) {
+}
+
+func (m mockDoc) ApplyPatchDocument(dbName string, docPath string, patch []byte, user string, mediaType string, cond precondition.Conditions) ([]byte, int) {
 
 	slog.Debug("ApplyPatchDocument Called")
 	return []byte("placeholder"), 200
 }
 
-func (m mockDoc) DoPatch(patch []byte) ([]byte, error) {
+func (m mockDoc) DoPatch(patch []byte, mediaType string) ([]byte, error) {
 
 	slog.Debug("DoPatch Called")
 	return []byte("placeholder"), nil
@@ -107,16 +153,68 @@ func (m mockDoc) UpdateDoc(newRaw []byte, user string) {
 	slog.Debug("UpdateDoc Called")
 }
 
+func (m mockDoc) Restore(serial []byte) error {
+	return nil
+}
+
+func (m mockDoc) RestoreChildDocument(docpath string, serial []byte) ([]byte, int) {
+	return nil, http.StatusCreated
+}
+
+func (m mockDoc) RestoreChildCollection(colpath string) ([]byte, int) {
+	return nil, http.StatusCreated
+}
+
+func (m mockDoc) RestoreChildIndex(colpath string, def fieldIndex.Definition) ([]byte, int) {
+	return nil, http.StatusCreated
+}
+
+func (m mockDoc) Walk(ctx context.Context, visitCol func(string, []fieldIndex.Definition), visitDoc func(string, []byte)) error {
+	visitDoc("doc", m.GetSerial())
+	return nil
+}
+
 func (m mockDoc) GetSerial() []byte {
 	//TODO implement me
 	return []byte("PLACEHOLDER")
 }
 
+func (m mockDoc) Body() []byte {
+	return mocks.MockPayload()
+}
+
+func (m mockDoc) Version() int64 {
+	return 1
+}
+
+func (m mockDoc) TransactChildDocument(dbName string, op transaction.Operation, user string) (*transaction.Write, []byte, int) {
+	return &transaction.Write{Status: http.StatusOK, Undo: func() {}, Notify: func() {}}, nil, http.StatusOK
+}
+
+type mockTree struct {
+	uris []string // the roots of the subtrees subscribed to or notified about, in order
+}
+
+func (m *mockTree) AddTreeSubscriber(uri string, lastEventID string, snapshot [][]byte) (*chan []byte, string, [][]byte) {
+	m.uris = append(m.uris, uri)
+	ch := make(chan []byte)
+	return &ch, "1", snapshot
+}
+
+func (m *mockTree) RemoveTreeSubscriber(uri string, id string) {
+	m.uris = append(m.uris, uri)
+}
+
+func (m *mockTree) NotifyDocs(uri string, evtype string, payload []byte) {
+	m.uris = append(m.uris, uri)
+}
+
 type mockColSubber struct {
 	NotifyAllInvoked     bool
 	AddSubscriberInvoked bool
 	NotifyInvoked        bool
 	GenerateEventInvoked bool
+	mu                   sync.Mutex
 }
 
 func (m *mockColSubber) NotifyAll(colname string) {
@@ -125,7 +223,7 @@ func (m *mockColSubber) NotifyAll(colname string) {
 	slog.Debug("NotifyAll called")
 }
 
-func (m *mockColSubber) AddSubscriber(lo string, hi string) (subChan *chan []byte, id string) {
+func (m *mockColSubber) AddSubscriber(lo string, hi string, filter func(serial []byte) bool, lastEventID string, snapshot [][]byte) (subChan *chan []byte, id string, events [][]byte) {
 
 	m.AddSubscriberInvoked = true
 	slog.Debug("AddSubscriber called")
@@ -133,8 +231,13 @@ func (m *mockColSubber) AddSubscriber(lo string, hi string) (subChan *chan []byt
 	return
 }
 
-func (m *mockColSubber) Notify(docname string, evType string, payload []byte) {
+func (m *mockColSubber) Remove(id string) {
+	slog.Debug("Remove called")
+}
 
+func (m *mockColSubber) Notify(docname string, evType string, payload []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.NotifyInvoked = true
 	slog.Debug("mockColSubber Notify invoked")
 }
@@ -159,9 +262,9 @@ func TestDatabase_UploadDocument(t *testing.T) {
 		return mockDoc{}
 	}
 	docIndex := mocks.NewMockSL[string, mockDoc]()
-	db := New[string, mockDoc]("db", mockDcf, docIndex, &mockColSubber{}, &mockValidator{})
+	db := New[string, mockDoc]("db", mockDcf, docIndex, &mockColSubber{}, fieldIndex.NewSet(), &mockTree{}, &mockValidator{}, &mocks.MockJournal{})
 
-	_, stat, _ := db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", true, false, "db")
+	_, stat, _ := db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", true, false, "db", precondition.Conditions{})
 	if stat != http.StatusCreated {
 		t.Errorf("Error TestDatabase_UploadDocument, expected stat code 201,got %d", stat)
 	}
@@ -172,9 +275,9 @@ func TestDatabase_DeleteDoc(t *testing.T) {
 		return mockDoc{}
 	}
 	docIndex := mocks.NewMockSL[string, mockDoc]()
-	db := New[string, mockDoc]("db", mockDcf, docIndex, &mockColSubber{}, &mockValidator{})
-	db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", true, false, "db")
-	_, stat := db.DeleteDoc("doc1")
+	db := New[string, mockDoc]("db", mockDcf, docIndex, &mockColSubber{}, fieldIndex.NewSet(), &mockTree{}, &mockValidator{}, &mocks.MockJournal{})
+	db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", true, false, "db", precondition.Conditions{})
+	_, stat := db.DeleteDoc("doc1", "user", precondition.Conditions{})
 
 	if stat != http.StatusNoContent {
 		t.Errorf("TestDatabase_DeleteDoc failed, got stat code %d", stat)
@@ -192,10 +295,10 @@ func TestDatabase_UploadDocumentNooverwrite(t *testing.T) {
 		NotifyInvoked:        false,
 		GenerateEventInvoked: false,
 	}
-	db := New[string, mockDoc]("db", mockDcf, docIndex, mockSubber, &mockValidator{})
+	db := New[string, mockDoc]("db", mockDcf, docIndex, mockSubber, fieldIndex.NewSet(), &mockTree{}, &mockValidator{}, &mocks.MockJournal{})
 
-	db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", false, false, "db")
-	_, stat, _ := db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", false, false, "db")
+	db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", false, false, "db", precondition.Conditions{})
+	_, stat, _ := db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", false, false, "db", precondition.Conditions{})
 
 	if stat != http.StatusPreconditionFailed {
 		t.Errorf("TestDatabase_UploadDocumentNooverwrite failed, got stat code %d", stat)
@@ -213,10 +316,10 @@ func TestDatabase_UploadDocumentOverwrite(t *testing.T) {
 		NotifyInvoked:        false,
 		GenerateEventInvoked: false,
 	}
-	db := New[string, mockDoc]("db", mockDcf, docIndex, mockSubber, &mockValidator{})
+	db := New[string, mockDoc]("db", mockDcf, docIndex, mockSubber, fieldIndex.NewSet(), &mockTree{}, &mockValidator{}, &mocks.MockJournal{})
 
-	db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", false, false, "db")
-	_, stat, _ := db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", true, false, "db")
+	db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", false, false, "db", precondition.Conditions{})
+	_, stat, _ := db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", true, false, "db", precondition.Conditions{})
 
 	if stat != http.StatusOK {
 		t.Errorf("TestDatabase_UploadDocumentNooverwrite failed, got stat code %d", stat)
@@ -235,8 +338,8 @@ func TestDatabase_Notify(t *testing.T) {
 		NotifyInvoked:        false,
 		GenerateEventInvoked: false,
 	}
-	db := New[string, mockDoc]("db", mockDcf, docIndex, mockSubber, &mockValidator{})
-	db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", false, false, "db")
+	db := New[string, mockDoc]("db", mockDcf, docIndex, mockSubber, fieldIndex.NewSet(), &mockTree{}, &mockValidator{}, &mocks.MockJournal{})
+	db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", false, false, "db", precondition.Conditions{})
 
 	if !mockSubber.NotifyInvoked {
 		t.Errorf("TestDatabase_Notify: not invoked")
@@ -258,8 +361,8 @@ func TestDatabase_UploadColNotFound(t *testing.T) {
 		NotifyInvoked:        false,
 		GenerateEventInvoked: false,
 	}
-	db := New[string, mockDoc]("db", mockDcf, docIndex, mockSubber, &mockValidator{})
-	db.UploadCol("doc1/col1/doc2/col2", "db")
+	db := New[string, mockDoc]("db", mockDcf, docIndex, mockSubber, fieldIndex.NewSet(), &mockTree{}, &mockValidator{}, &mocks.MockJournal{})
+	db.UploadCol("doc1/col1/doc2/col2", "db", "user")
 
 }
 
@@ -268,10 +371,10 @@ func TestDatabase_DeleteColNotFound(t *testing.T) {
 		return mockDoc{}
 	}
 	docIndex := mocks.NewMockSL[string, mockDoc]()
-	db := New[string, mockDoc]("db", mockDcf, docIndex, &mockColSubber{}, &mockValidator{})
+	db := New[string, mockDoc]("db", mockDcf, docIndex, &mockColSubber{}, fieldIndex.NewSet(), &mockTree{}, &mockValidator{}, &mocks.MockJournal{})
 
-	db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", true, false, "db")
-	db.DeleteCol("doc2/col1/")
+	db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", true, false, "db", precondition.Conditions{})
+	db.DeleteCol("doc2/col1/", "user")
 }
 
 func TestDatabase_DeleteCol(t *testing.T) {
@@ -279,10 +382,10 @@ func TestDatabase_DeleteCol(t *testing.T) {
 		return mockDoc{}
 	}
 	docIndex := mocks.NewMockSL[string, mockDoc]()
-	db := New[string, mockDoc]("db", mockDcf, docIndex, &mockColSubber{}, &mockValidator{})
+	db := New[string, mockDoc]("db", mockDcf, docIndex, &mockColSubber{}, fieldIndex.NewSet(), &mockTree{}, &mockValidator{}, &mocks.MockJournal{})
 
-	db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", true, false, "db")
-	db.DeleteCol("doc1/col1/")
+	db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", true, false, "db", precondition.Conditions{})
+	db.DeleteCol("doc1/col1/", "user")
 }
 
 func TestDatabase_GetDocumentSerial(t *testing.T) {
@@ -290,10 +393,10 @@ func TestDatabase_GetDocumentSerial(t *testing.T) {
 		return mockDoc{}
 	}
 	docIndex := mocks.NewMockSL[string, mockDoc]()
-	db := New[string, mockDoc]("db", mockDcf, docIndex, &mockColSubber{}, &mockValidator{})
+	db := New[string, mockDoc]("db", mockDcf, docIndex, &mockColSubber{}, fieldIndex.NewSet(), &mockTree{}, &mockValidator{}, &mocks.MockJournal{})
 
-	db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", true, false, "db")
-	db.GetDocumentSerial("doc1", true)
+	db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", true, false, "db", precondition.Conditions{})
+	db.GetDocumentSerial("doc1", true, "")
 }
 
 func TestDatabase_GetDocumentSerialNotFound(t *testing.T) {
@@ -305,16 +408,16 @@ func TestDatabase_UploadCol(t *testing.T) {
 		return mockDoc{}
 	}
 	docIndex := mocks.NewMockSL[string, mockDoc]()
-	db := New[string, mockDoc]("db", mockDcf, docIndex, &mockColSubber{}, &mockValidator{})
+	db := New[string, mockDoc]("db", mockDcf, docIndex, &mockColSubber{}, fieldIndex.NewSet(), &mockTree{}, &mockValidator{}, &mocks.MockJournal{})
 
-	db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", true, false, "db")
+	db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", true, false, "db", precondition.Conditions{})
 	//var mockDcf DocFactory[mockDoc] = func([]byte, string, string) mockDoc {
 	//	return mockDoc{}
 	//}
 	//docIndex := mocks.NewMockSL[string, mockDoc]()
 	//db := New[string, mockDoc]("db", mockDcf, docIndex, &mockColSubber{}, mockValidator{})
 
-	db.UploadCol("doc1/col1/", "db")
+	db.UploadCol("doc1/col1/", "db", "user")
 }
 
 func TestDatabase_GetColSerialTop(t *testing.T) {
@@ -322,9 +425,9 @@ func TestDatabase_GetColSerialTop(t *testing.T) {
 		return mockDoc{}
 	}
 	docIndex := mocks.NewMockSL[string, mockDoc]()
-	db := New[string, mockDoc]("db", mockDcf, docIndex, &mockColSubber{}, &mockValidator{})
-	db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", true, false, "db")
-	db.GetColSerial("", "", "z", false)
+	db := New[string, mockDoc]("db", mockDcf, docIndex, &mockColSubber{}, fieldIndex.NewSet(), &mockTree{}, &mockValidator{}, &mocks.MockJournal{})
+	db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", true, false, "db", precondition.Conditions{})
+	db.GetColSerial("", "", "z", false, query.Options{}, "")
 }
 
 func TestDatabase_GetColSerialSubscribe(t *testing.T) {
@@ -332,9 +435,9 @@ func TestDatabase_GetColSerialSubscribe(t *testing.T) {
 		return mockDoc{}
 	}
 	docIndex := mocks.NewMockSL[string, mockDoc]()
-	db := New[string, mockDoc]("db", mockDcf, docIndex, &mockColSubber{}, &mockValidator{})
-	db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", true, false, "db")
-	db.GetColSerial("", "", "z", true)
+	db := New[string, mockDoc]("db", mockDcf, docIndex, &mockColSubber{}, fieldIndex.NewSet(), &mockTree{}, &mockValidator{}, &mocks.MockJournal{})
+	db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", true, false, "db", precondition.Conditions{})
+	db.GetColSerial("", "", "z", true, query.Options{}, "")
 }
 
 func TestDatabase_GetColSerial(t *testing.T) {
@@ -342,9 +445,9 @@ func TestDatabase_GetColSerial(t *testing.T) {
 		return mockDoc{}
 	}
 	docIndex := mocks.NewMockSL[string, mockDoc]()
-	db := New[string, mockDoc]("db", mockDcf, docIndex, &mockColSubber{}, &mockValidator{})
-	db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", true, false, "db")
-	db.GetColSerial("doc1/col1/", "", "z", false)
+	db := New[string, mockDoc]("db", mockDcf, docIndex, &mockColSubber{}, fieldIndex.NewSet(), &mockTree{}, &mockValidator{}, &mocks.MockJournal{})
+	db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", true, false, "db", precondition.Conditions{})
+	db.GetColSerial("doc1/col1/", "", "z", false, query.Options{}, "")
 }
 
 func TestDatabase_GetColSerialTopSubscribe(t *testing.T) {
@@ -352,9 +455,9 @@ func TestDatabase_GetColSerialTopSubscribe(t *testing.T) {
 		return mockDoc{}
 	}
 	docIndex := mocks.NewMockSL[string, mockDoc]()
-	db := New[string, mockDoc]("db", mockDcf, docIndex, &mockColSubber{}, &mockValidator{})
-	db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", true, false, "db")
-	db.GetColSerial("doc1/col1", "", "z", true)
+	db := New[string, mockDoc]("db", mockDcf, docIndex, &mockColSubber{}, fieldIndex.NewSet(), &mockTree{}, &mockValidator{}, &mocks.MockJournal{})
+	db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", true, false, "db", precondition.Conditions{})
+	db.GetColSerial("doc1/col1", "", "z", true, query.Options{}, "")
 }
 
 func TestDatabase_DeleteDocNested(t *testing.T) {
@@ -362,9 +465,9 @@ func TestDatabase_DeleteDocNested(t *testing.T) {
 		return mockDoc{}
 	}
 	docIndex := mocks.NewMockSL[string, mockDoc]()
-	db := New[string, mockDoc]("db", mockDcf, docIndex, &mockColSubber{}, &mockValidator{})
-	db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", true, false, "db")
-	db.DeleteDoc("doc1/col1/doc2")
+	db := New[string, mockDoc]("db", mockDcf, docIndex, &mockColSubber{}, fieldIndex.NewSet(), &mockTree{}, &mockValidator{}, &mocks.MockJournal{})
+	db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", true, false, "db", precondition.Conditions{})
+	db.DeleteDoc("doc1/col1/doc2", "user", precondition.Conditions{})
 }
 
 func TestDatabase_GetColSerialNotFound(t *testing.T) {
@@ -372,9 +475,9 @@ func TestDatabase_GetColSerialNotFound(t *testing.T) {
 		return mockDoc{}
 	}
 	docIndex := mocks.NewMockSL[string, mockDoc]()
-	db := New[string, mockDoc]("db", mockDcf, docIndex, &mockColSubber{}, &mockValidator{})
-	db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", true, false, "db")
-	db.GetColSerial("doc2/col1/doc2/col2", "", "z", true)
+	db := New[string, mockDoc]("db", mockDcf, docIndex, &mockColSubber{}, fieldIndex.NewSet(), &mockTree{}, &mockValidator{}, &mocks.MockJournal{})
+	db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", true, false, "db", precondition.Conditions{})
+	db.GetColSerial("doc2/col1/doc2/col2", "", "z", true, query.Options{}, "")
 }
 
 func TestDatabase_DeleteDocNotFound(t *testing.T) {
@@ -382,9 +485,9 @@ func TestDatabase_DeleteDocNotFound(t *testing.T) {
 		return mockDoc{}
 	}
 	docIndex := mocks.NewMockSL[string, mockDoc]()
-	db := New[string, mockDoc]("db", mockDcf, docIndex, &mockColSubber{}, &mockValidator{})
-	db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", true, false, "db")
-	db.DeleteDoc("doc2/col1/doc4")
+	db := New[string, mockDoc]("db", mockDcf, docIndex, &mockColSubber{}, fieldIndex.NewSet(), &mockTree{}, &mockValidator{}, &mocks.MockJournal{})
+	db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", true, false, "db", precondition.Conditions{})
+	db.DeleteDoc("doc2/col1/doc4", "user", precondition.Conditions{})
 }
 
 func TestDatabase_PatchTop(t *testing.T) {
@@ -392,10 +495,10 @@ func TestDatabase_PatchTop(t *testing.T) {
 		return mockDoc{}
 	}
 	docIndex := mocks.NewMockSL[string, mockDoc]()
-	db := New[string, mockDoc]("db", mockDcf, docIndex, &mockColSubber{}, &mockValidator{})
-	db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", true, false, "db")
+	db := New[string, mockDoc]("db", mockDcf, docIndex, &mockColSubber{}, fieldIndex.NewSet(), &mockTree{}, &mockValidator{}, &mocks.MockJournal{})
+	db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", true, false, "db", precondition.Conditions{})
 
-	db.Patch("doc1", []byte("patch"), "user")
+	db.Patch("doc1", []byte("patch"), "user", "application/json", precondition.Conditions{})
 }
 
 func TestDatabase_PatchNested(t *testing.T) {
@@ -403,10 +506,10 @@ func TestDatabase_PatchNested(t *testing.T) {
 		return mockDoc{}
 	}
 	docIndex := mocks.NewMockSL[string, mockDoc]()
-	db := New[string, mockDoc]("db", mockDcf, docIndex, &mockColSubber{}, &mockValidator{})
-	db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", true, false, "db")
+	db := New[string, mockDoc]("db", mockDcf, docIndex, &mockColSubber{}, fieldIndex.NewSet(), &mockTree{}, &mockValidator{}, &mocks.MockJournal{})
+	db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", true, false, "db", precondition.Conditions{})
 
-	db.Patch("doc1/col1/doc2", []byte("patch"), "user")
+	db.Patch("doc1/col1/doc2", []byte("patch"), "user", "application/json", precondition.Conditions{})
 }
 
 func TestDatabase_NotifyAll(t *testing.T) {
@@ -414,8 +517,38 @@ func TestDatabase_NotifyAll(t *testing.T) {
 		return mockDoc{}
 	}
 	docIndex := mocks.NewMockSL[string, mockDoc]()
-	db := New[string, mockDoc]("db", mockDcf, docIndex, &mockColSubber{}, &mockValidator{})
+	db := New[string, mockDoc]("db", mockDcf, docIndex, &mockColSubber{}, fieldIndex.NewSet(), &mockTree{}, &mockValidator{}, &mocks.MockJournal{})
+	db.NotifyAll("/")
+}
+
+func TestDatabase_SubscribeSubtree(t *testing.T) {
+	var mockDcf DocFactory[mockDoc] = func([]byte, string, string) mockDoc {
+		return mockDoc{}
+	}
+	docIndex := mocks.NewMockSL[string, mockDoc]()
+	tree := &mockTree{}
+	db := New[string, mockDoc]("db", mockDcf, docIndex, &mockColSubber{}, fieldIndex.NewSet(), tree, &mockValidator{}, &mocks.MockJournal{})
+	db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", true, false, "db", precondition.Conditions{})
+	db.UploadDocument("doc2", mocks.MockPayload(), "doc2", "USER", true, false, "db", precondition.Conditions{})
+
+	_, stat, subChan, _, events := db.SubscribeSubtree("", "")
+	if stat != http.StatusOK || subChan == nil || len(events) != 2 || string(events[1]) != `{"path":"/doc2"}` {
+		t.Errorf("TestDatabase_SubscribeSubtree failed, expected the whole database, got %d %s", stat, events)
+	}
+	_, stat, _, _, events = db.SubscribeSubtree("doc1/col1/", "")
+	if stat != http.StatusOK || len(events) != 1 || string(events[0]) != `{"path":"/doc1/col1/"}` {
+		t.Errorf("TestDatabase_SubscribeSubtree failed, expected the collection, got %d %s", stat, events)
+	}
+	if _, stat, _, _, _ = db.SubscribeSubtree("doc3/col1/", ""); stat != http.StatusNotFound {
+		t.Errorf("TestDatabase_SubscribeSubtree failed, expected 404 for a missing document, got %d", stat)
+	}
+	db.UnsubscribeSubtree("doc1/col1/", "1")
 	db.NotifyAll("/")
+
+	want := []string{"db/", "db/doc1/col1/", "db/doc1/col1/", "db/"}
+	if fmt.Sprint(tree.uris) != fmt.Sprint(want) {
+		t.Errorf("TestDatabase_SubscribeSubtree failed, expected %v, got %v", want, tree.uris)
+	}
 }
 
 func TestDatabase_UploadDocNested(t *testing.T) {
@@ -423,10 +556,10 @@ func TestDatabase_UploadDocNested(t *testing.T) {
 		return mockDoc{}
 	}
 	docIndex := mocks.NewMockSL[string, mockDoc]()
-	db := New[string, mockDoc]("db", mockDcf, docIndex, &mockColSubber{}, &mockValidator{})
-	db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", true, false, "db")
+	db := New[string, mockDoc]("db", mockDcf, docIndex, &mockColSubber{}, fieldIndex.NewSet(), &mockTree{}, &mockValidator{}, &mocks.MockJournal{})
+	db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", true, false, "db", precondition.Conditions{})
 
-	db.UploadDocument("doc1/col1/doc2", mocks.MockPayload(), "doc2", "USER", false, false, "db")
+	db.UploadDocument("doc1/col1/doc2", mocks.MockPayload(), "doc2", "USER", false, false, "db", precondition.Conditions{})
 }
 
 func TestDatabase_RemoveTopNotFound(t *testing.T) {
@@ -434,11 +567,274 @@ func TestDatabase_RemoveTopNotFound(t *testing.T) {
 		return mockDoc{}
 	}
 	docIndex := mocks.NewMockSL[string, mockDoc]()
-	db := New[string, mockDoc]("db", mockDcf, docIndex, &mockColSubber{}, &mockValidator{})
-	db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", true, false, "db")
-	_, stat := db.DeleteDoc("doc2")
+	db := New[string, mockDoc]("db", mockDcf, docIndex, &mockColSubber{}, fieldIndex.NewSet(), &mockTree{}, &mockValidator{}, &mocks.MockJournal{})
+	db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", true, false, "db", precondition.Conditions{})
+	_, stat := db.DeleteDoc("doc2", "user", precondition.Conditions{})
 
 	if stat != http.StatusNotFound {
 		t.Errorf("TestDatabase_DeleteDoc failed, got stat code %d", stat)
 	}
 }
+
+func TestDatabase_UploadIndex(t *testing.T) {
+	var mockDcf DocFactory[mockDoc] = func([]byte, string, string) mockDoc {
+		return mockDoc{}
+	}
+	docIndex := mocks.NewMockSL[string, mockDoc]()
+	db := New[string, mockDoc]("db", mockDcf, docIndex, &mockColSubber{}, fieldIndex.NewSet(), &mockTree{}, &mockValidator{}, &mocks.MockJournal{})
+	db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", true, false, "db", precondition.Conditions{})
+
+	if _, stat, uri := db.UploadIndex("doc1/col1", fieldIndex.Definition{Field: "/email"}, "db"); stat != http.StatusCreated || uri != "/v1/db/dummy/dummy/?index=%2Femail" {
+		t.Errorf("TestDatabase_UploadIndex failed, got stat code %d and uri %s", stat, uri)
+	}
+	if _, stat, _ := db.UploadIndex("doc2/col1", fieldIndex.Definition{Field: "/email"}, "db"); stat != http.StatusNotFound {
+		t.Errorf("TestDatabase_UploadIndex failed, expected 404 for a missing document, got %d", stat)
+	}
+	if _, stat := db.DeleteIndex("doc1/col1", "/email"); stat != http.StatusNoContent {
+		t.Errorf("TestDatabase_UploadIndex failed, expected the index to be deleted, got %d", stat)
+	}
+
+	//an empty path names the top-level collection
+	if _, stat, uri := db.UploadIndex("", fieldIndex.Definition{Field: "/email"}, "db"); stat != http.StatusCreated || uri != "/v1/db/?index=%2Femail" {
+		t.Errorf("TestDatabase_UploadIndex failed, got stat code %d and uri %s for the top-level collection", stat, uri)
+	}
+	if _, stat, _ := db.UploadIndex("", fieldIndex.Definition{Field: "/email"}, "db"); stat != http.StatusBadRequest {
+		t.Errorf("TestDatabase_UploadIndex failed, expected 400 for an existing index, got %d", stat)
+	}
+	if _, stat := db.DeleteIndex("", "/email"); stat != http.StatusNoContent {
+		t.Errorf("TestDatabase_UploadIndex failed, expected the top-level index to be deleted, got %d", stat)
+	}
+	if _, stat := db.DeleteIndex("", "/email"); stat != http.StatusNotFound {
+		t.Errorf("TestDatabase_UploadIndex failed, expected 404 for a missing index, got %d", stat)
+	}
+}
+
+func TestDatabase_Transact(t *testing.T) {
+	var mockDcf DocFactory[mockDoc] = func([]byte, string, string) mockDoc {
+		return mockDoc{}
+	}
+	docIndex := mocks.NewMockSL[string, mockDoc]()
+	journal := &mocks.MockJournal{}
+	db := New[string, mockDoc]("db", mockDcf, docIndex, &mockColSubber{}, fieldIndex.NewSet(), &mockTree{}, &mockValidator{}, journal)
+	db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", true, false, "db", precondition.Conditions{})
+
+	ops := []transaction.Operation{
+		{Op: transaction.OpPut, Path: "/doc2", Doc: mocks.MockPayload()},
+		{Op: transaction.OpPatch, Path: "/doc1/col1/doc3", Patch: []byte("[]")},
+		{Op: transaction.OpDelete, Path: "/doc1"},
+	}
+	_, stat := db.Transact(ops, "USER")
+	if stat != http.StatusOK {
+		t.Fatalf("TestDatabase_Transact: expected stat code 200, got %d", stat)
+	}
+	if _, found := docIndex.Find("doc1"); found {
+		t.Errorf("TestDatabase_Transact: doc1 should have been deleted")
+	}
+	if _, found := docIndex.Find("doc2"); !found {
+		t.Errorf("TestDatabase_Transact: doc2 should have been created")
+	}
+	last := journal.Ops[len(journal.Ops)-1]
+	if last != "transaction db/doc2,doc1/col1/doc3,doc1" {
+		t.Errorf("TestDatabase_Transact: expected the transaction to be journaled as a whole, got %s", last)
+	}
+}
+
+func TestDatabase_TransactRollback(t *testing.T) {
+	var mockDcf DocFactory[mockDoc] = func([]byte, string, string) mockDoc {
+		return mockDoc{}
+	}
+	docIndex := mocks.NewMockSL[string, mockDoc]()
+	journal := &mocks.MockJournal{}
+	mockSubber := &mockColSubber{}
+	db := New[string, mockDoc]("db", mockDcf, docIndex, mockSubber, fieldIndex.NewSet(), &mockTree{}, &mockValidator{}, journal)
+
+	tests := []struct {
+		name string
+		ops  []transaction.Operation
+		stat int
+	}{
+		{"missing document", []transaction.Operation{
+			{Op: transaction.OpPut, Path: "/doc1", Doc: mocks.MockPayload()},
+			{Op: transaction.OpPatch, Path: "/doc2", Patch: []byte("[]")},
+		}, http.StatusNotFound},
+		{"failed precondition", []transaction.Operation{
+			{Op: transaction.OpPut, Path: "/doc1", Doc: mocks.MockPayload()},
+			{Op: transaction.OpPut, Path: "/doc2", Doc: mocks.MockPayload(), IfMatch: `"7"`},
+		}, http.StatusPreconditionFailed},
+		{"write beneath a deleted document", []transaction.Operation{
+			{Op: transaction.OpPut, Path: "/doc1", Doc: mocks.MockPayload()},
+			{Op: transaction.OpDelete, Path: "/doc1"},
+			{Op: transaction.OpPut, Path: "/doc1/col1/doc2", Doc: mocks.MockPayload()},
+		}, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, stat := db.Transact(tt.ops, "USER")
+			if stat != tt.stat {
+				t.Errorf("expected stat code %d, got %d", tt.stat, stat)
+			}
+			if _, found := docIndex.Find("doc1"); found {
+				t.Errorf("doc1 should have been rolled back")
+			}
+		})
+	}
+	if len(journal.Ops) != 0 {
+		t.Errorf("nothing should have been journaled, got %v", journal.Ops)
+	}
+	if mockSubber.NotifyInvoked {
+		t.Errorf("subscribers should not have been notified")
+	}
+}
+
+func TestDatabase_JournalFailure(t *testing.T) {
+	var mockDcf DocFactory[mockDoc] = func([]byte, string, string) mockDoc {
+		return mockDoc{}
+	}
+	docIndex := mocks.NewMockSL[string, mockDoc]()
+	journal := &mocks.MockJournal{}
+	mockSubber := &mockColSubber{}
+	db := New[string, mockDoc]("db", mockDcf, docIndex, mockSubber, fieldIndex.NewSet(), &mockTree{}, &mockValidator{}, journal)
+	db.UploadDocument("doc1", mocks.MockPayload(), "doc1", "USER", true, false, "db", precondition.Conditions{})
+	mockSubber.NotifyInvoked = false
+
+	journal.Err = errors.New("no space left on device")
+	if _, stat, _ := db.UploadDocument("doc2", mocks.MockPayload(), "doc2", "USER", true, false, "db", precondition.Conditions{}); stat != http.StatusInternalServerError {
+		t.Errorf("TestDatabase_JournalFailure failed, expected a write that was not journaled to fail with 500, got %d", stat)
+	}
+	if _, found := docIndex.Find("doc2"); found {
+		t.Errorf("TestDatabase_JournalFailure failed, doc2 should not have been created")
+	}
+	if _, stat := db.DeleteDoc("doc1", "USER", precondition.Conditions{}); stat != http.StatusInternalServerError {
+		t.Errorf("TestDatabase_JournalFailure failed, expected a deletion that was not journaled to fail with 500, got %d", stat)
+	}
+	if _, found := docIndex.Find("doc1"); !found {
+		t.Errorf("TestDatabase_JournalFailure failed, doc1 should not have been deleted")
+	}
+	ops := []transaction.Operation{{Op: transaction.OpPut, Path: "/doc3", Doc: mocks.MockPayload()}}
+	if _, stat := db.Transact(ops, "USER"); stat != http.StatusInternalServerError {
+		t.Errorf("TestDatabase_JournalFailure failed, expected a transaction that was not journaled to fail with 500, got %d", stat)
+	}
+	if _, found := docIndex.Find("doc3"); found {
+		t.Errorf("TestDatabase_JournalFailure failed, the transaction should have been undone")
+	}
+	if mockSubber.NotifyInvoked {
+		t.Errorf("TestDatabase_JournalFailure failed, subscribers should not have been notified")
+	}
+}
+
+func TestDatabase_TransactDoesNotStarveWriters(t *testing.T) {
+	var mockDcf DocFactory[mockDoc] = func([]byte, string, string) mockDoc {
+		return mockDoc{}
+	}
+	docIndex := mocks.NewMockSL[string, mockDoc]()
+	db := New[string, mockDoc]("db", mockDcf, docIndex, &mockColSubber{}, fieldIndex.NewSet(), &mockTree{}, &mockValidator{}, &mocks.MockJournal{})
+
+	//transactions run back to back, each locking the document it writes
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ops := []transaction.Operation{{Op: transaction.OpPut, Path: "/txdoc", Doc: mocks.MockPayload()}}
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				db.Transact(ops, "USER")
+			}
+		}
+	}()
+
+	//writes to unrelated documents proceed alongside them
+	written := make(chan int)
+	go func() {
+		for i := 0; i < 200; i++ {
+			if _, stat, _ := db.UploadDocument(fmt.Sprintf("doc%d", i), mocks.MockPayload(), fmt.Sprintf("doc%d", i), "USER", true, false, "db", precondition.Conditions{}); stat != http.StatusCreated {
+				t.Errorf("TestDatabase_TransactDoesNotStarveWriters failed, expected 201, got %d", stat)
+			}
+		}
+		written <- 200
+	}()
+	select {
+	case <-written:
+	case <-time.After(5 * time.Second):
+		t.Errorf("TestDatabase_TransactDoesNotStarveWriters failed, writes made no progress while transactions ran")
+	}
+	close(stop)
+	<-stopped
+}
+
+// blockingJournal holds every transaction in the middle of being journaled until release is closed
+type blockingJournal struct {
+	*mocks.MockJournal
+	journaling chan struct{} // receives once a transaction is being journaled
+	release    chan struct{}
+}
+
+func (j *blockingJournal) RecordTransaction(dbName string, docpaths []string, serials [][]byte, user string) error {
+	j.journaling <- struct{}{}
+	<-j.release
+	return j.MockJournal.RecordTransaction(dbName, docpaths, serials, user)
+}
+
+func TestDatabase_TransactDoesNotBlockUnrelatedWrites(t *testing.T) {
+	var mockDcf DocFactory[mockDoc] = func([]byte, string, string) mockDoc {
+		return mockDoc{}
+	}
+	docIndex := mocks.NewMockSL[string, mockDoc]()
+	journal := &blockingJournal{MockJournal: &mocks.MockJournal{}, journaling: make(chan struct{}), release: make(chan struct{})}
+	db := New[string, mockDoc]("db", mockDcf, docIndex, &mockColSubber{}, fieldIndex.NewSet(), &mockTree{}, &mockValidator{}, journal)
+
+	done := make(chan int)
+	go func() {
+		ops := []transaction.Operation{{Op: transaction.OpPut, Path: "/txdoc", Doc: mocks.MockPayload()}}
+		_, stat := db.Transact(ops, "USER")
+		done <- stat
+	}()
+	<-journal.journaling
+
+	//the transaction is still running, and holds only txdoc
+	wrote := make(chan int)
+	go func() {
+		_, stat, _ := db.UploadDocument("other", mocks.MockPayload(), "other", "USER", true, false, "db", precondition.Conditions{})
+		wrote <- stat
+	}()
+	select {
+	case stat := <-wrote:
+		if stat != http.StatusCreated {
+			t.Errorf("TestDatabase_TransactDoesNotBlockUnrelatedWrites failed, expected 201, got %d", stat)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("TestDatabase_TransactDoesNotBlockUnrelatedWrites failed, a write to an unrelated document waited for the transaction")
+	}
+
+	go func() {
+		_, stat, _ := db.UploadDocument("txdoc", mocks.MockPayload(), "txdoc", "USER", true, false, "db", precondition.Conditions{})
+		wrote <- stat
+	}()
+	select {
+	case <-wrote:
+		t.Errorf("TestDatabase_TransactDoesNotBlockUnrelatedWrites failed, a write to a document of the transaction did not wait for it")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	listed := make(chan struct{})
+	go func() {
+		db.GetColSerial("", "", "z", false, query.Options{}, "")
+		close(listed)
+	}()
+	select {
+	case <-listed:
+		t.Errorf("TestDatabase_TransactDoesNotBlockUnrelatedWrites failed, a collection read did not wait for the transaction")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(journal.release)
+	<-listed
+	if stat := <-done; stat != http.StatusOK {
+		t.Errorf("TestDatabase_TransactDoesNotBlockUnrelatedWrites failed, expected the transaction to succeed, got %d", stat)
+	}
+	if stat := <-wrote; stat != http.StatusOK {
+		t.Errorf("TestDatabase_TransactDoesNotBlockUnrelatedWrites failed, expected the waiting write to replace txdoc, got %d", stat)
+	}
+}
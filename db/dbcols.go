@@ -10,30 +10,35 @@ import (
 	"net/http"
 	"strings"
 	"time"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/query"
 )
 
 // GetColSerial retrieves and serializes the collection at the specified path.
 // It returns a byte slice of the serialized collection, a status code, and an optional subscription channel if applicable.
-// The range is defined by the 'lo' and 'hi' keys, and if isSubscription is true, the function will return events for document changes.
-func (db *Database[K, T]) GetColSerial(colpath string, lo string, hi string, isSubscription bool) ([]byte, int, *chan []byte, string, [][]byte) {
+// The range is defined by the 'lo' and 'hi' keys, and if isSubscription is true, the function will return events for document changes,
+// resuming from the event lastEventID if it is given.
+// opts further selects the documents serialized.
+func (db *Database[K, T]) GetColSerial(colpath string, lo string, hi string, isSubscription bool, opts query.Options, lastEventID string) ([]byte, int, *chan []byte, string, [][]byte) {
+	db.gate.lock(spanning)
+	defer db.gate.unlock(spanning)
 
 	splitPath := strings.Split(colpath, "/")
 
 	topDocName := splitPath[0] //get the name of the parent document
 
 	if len(colpath) == 0 { //TOP-LEVEL DATABASE GET
-		res, stat := db.serialTop(lo, hi)
+		res, stat := db.serialTop(lo, hi, opts)
 		if isSubscription {
 			docBytes := make([][]byte, 0)
-			subChan, subId := db.colSubscriptionManager.AddSubscriber(lo, hi)
 			ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(15*time.Second))
 			defer cancel()
 			docs, _ := db.docs.Query(ctx, K(lo), K(hi))
 			for _, v := range docs {
-				event := db.colSubscriptionManager.GenerateEvent("update", v.Value.GetSerial())
-				docBytes = append(docBytes, event)
+				docBytes = append(docBytes, v.Value.GetSerial())
 			}
-			return res, stat, subChan, subId, docBytes
+			subChan, subId, events := db.colSubscriptionManager.AddSubscriber(lo, hi, opts.Matcher(), lastEventID, docBytes)
+			return res, stat, subChan, subId, events
 		}
 
 		return res, stat, nil, "", nil
@@ -48,13 +53,15 @@ func (db *Database[K, T]) GetColSerial(colpath string, lo string, hi string, isS
 		return errmsg, http.StatusNotFound, nil, "", nil
 	}
 	//delegated to the documents
-	return topDoc.GetChildCollection(colpath, lo, hi, isSubscription)
+	return topDoc.GetChildCollection(colpath, lo, hi, isSubscription, opts, lastEventID)
 
 }
 
-// DeleteCol deletes the collection located at the path colpath in the database.
+// DeleteCol deletes the collection located at the path colpath in the database, on behalf of user.
 // Returns a response (if an error occurred) and a status code.
-func (db *Database[K, T]) DeleteCol(colpath string) ([]byte, int) {
+func (db *Database[K, T]) DeleteCol(colpath string, user string) ([]byte, int) {
+	db.gate.lock(spanning)
+	defer db.gate.unlock(spanning)
 
 	slog.Debug(fmt.Sprintf("Deleting the collection at path %+v", colpath))
 
@@ -70,13 +77,12 @@ func (db *Database[K, T]) DeleteCol(colpath string) ([]byte, int) {
 
 		return errmsg, http.StatusNotFound
 	}
-	return topDoc.DeleteChildCollection(colpath)
+	return topDoc.DeleteChildCollection(colpath, db.name, user)
 }
 
-// UploadCol uploads a collection at the path colpath, at the database dbName
+// UploadCol uploads a collection at the path colpath, at the database dbName, on behalf of user
 // Returns a response (if an error occurred) and a status code.
-func (db *Database[K, T]) UploadCol(colpath string, dbName string) ([]byte, int, string) {
-
+func (db *Database[K, T]) UploadCol(colpath string, dbName string, user string) ([]byte, int, string) {
 	slog.Debug(fmt.Sprintf("UploadCol: uploading a collection at path %s,database name %s", colpath, dbName))
 
 	splitPath := strings.Split(colpath, "/")
@@ -91,25 +97,50 @@ func (db *Database[K, T]) UploadCol(colpath string, dbName string) ([]byte, int,
 
 		return errmsg, http.StatusNotFound, ""
 	}
-	return topDoc.AddChildCollection(colpath, dbName)
+	return topDoc.AddChildCollection(colpath, dbName, user)
 }
 
 // serialTop is an internal routine that returns a serialized representation of the top-level collection
-// represented as a JSON-encoded byte slice. Also returns a status code
-func (db *Database[K, T]) serialTop(lo string, hi string) ([]byte, int) {
+// represented as a JSON-encoded byte slice, keeping the documents selected by opts. Also returns a status code
+func (db *Database[K, T]) serialTop(lo string, hi string, opts query.Options) ([]byte, int) {
 	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(10*time.Second))
 	defer cancel()
-	res, err := db.docs.Query(ctx, K(lo), K(hi)) //query on top-level collection
+	if names, indexed := db.indexes.Lookup(ctx, opts.Filter); indexed {
+		page := opts.NewPage()
+		for _, name := range names {
+			if name < opts.Low(lo) || name > hi {
+				continue
+			}
+			doc, found := db.docs.Find(K(name))
+			if found && page.Add(name, doc.GetSerial()) {
+				break
+			}
+		}
+		return page.Marshal(), http.StatusOK
+	}
+	res, err := db.docs.Query(ctx, K(opts.Low(lo)), K(hi)) //query on top-level collection
 	if err != nil {
 		errmsg, _ := json.Marshal(err.Error())
 		return errmsg, http.StatusBadRequest
 	}
-	resArr := []json.RawMessage{}
-	for _, pair := range res { //serializing all documents
-		bytes := pair.Value.GetSerial()
-		var rj json.RawMessage = bytes
-		resArr = append(resArr, rj)
+	page := opts.NewPage()
+	for _, pair := range res { //serializing the documents selected
+		if page.Add(string(pair.Key), pair.Value.GetSerial()) {
+			break
+		}
+	}
+	return page.Marshal(), http.StatusOK
+}
+
+// RestoreCollection recreates the collection at colpath, doing nothing if it already exists. No subscribers are
+// notified and nothing is journaled; this is used to rebuild state, not to serve clients.
+// Returns a response (if an error occurred) and a status code
+func (db *Database[K, T]) RestoreCollection(colpath string) ([]byte, int) {
+	topDocName := strings.Split(colpath, "/")[0]
+	topDoc, found := db.docs.Find(K(topDocName))
+	if !found {
+		errmsg, _ := json.Marshal("Document does not exist")
+		return errmsg, http.StatusNotFound
 	}
-	resSerial, _ := json.Marshal(resArr)
-	return resSerial, http.StatusOK
+	return topDoc.RestoreChildCollection(colpath)
 }
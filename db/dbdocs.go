@@ -4,18 +4,24 @@ package db
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net/http"
 	"strings"
 
-	"net/http"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/fieldIndex"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/history"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/precondition"
 )
 
 // UploadDocument uploads a document to the database at the specified path.
 // This function handles both PUT and POST requests. It supports options for overwriting
 // an existing document and allows for top-level document management as well as child documents.
+// The conditions cond are checked against the document being replaced, if any.
 // Returns a serialized response, status code, and potential errors if the operation fails.
-func (db *Database[K, T]) UploadDocument(docpath string, payload []byte, docname, user string, overwrite, isPost bool, dbName string) ([]byte, int, string) {
+func (db *Database[K, T]) UploadDocument(docpath string, payload []byte, docname, user string, overwrite, isPost bool, dbName string, cond precondition.Conditions) ([]byte, int, string) {
+	defer db.docLocks.lock(docpath)()
 
 	slog.Debug(fmt.Sprintf("uploading document at path %+v to the database %s dbName, isPost is %t", docpath, dbName, isPost))
 
@@ -24,7 +30,7 @@ func (db *Database[K, T]) UploadDocument(docpath string, payload []byte, docname
 	splitPath := strings.Split(stringPath, "/")
 
 	if len(splitPath) == 1 { //we are posting to the top DB
-		return db.uploadTop(K(docpath), payload, docname, user, overwrite, isPost, dbName)
+		return db.uploadTop(K(docpath), payload, docname, user, overwrite, isPost, dbName, cond)
 	}
 
 	topDocName := K(splitPath[0])
@@ -34,14 +40,14 @@ func (db *Database[K, T]) UploadDocument(docpath string, payload []byte, docname
 		errmsg, _ := json.Marshal("Document does not exist")
 		return errmsg, http.StatusNotFound, ""
 	}
-	return parentDoc.AddChildDocument(stringPath, payload, docname, user, overwrite, isPost, dbName)
+	return parentDoc.AddChildDocument(stringPath, payload, docname, user, overwrite, isPost, dbName, cond)
 
 }
 
 // uploadTop uploads a document to the top level of the database.
 // It creates a new document or overwrites an existing one depending on the overwrite flag.
 // Returns a response indicating the result of the operation and the associated status code.
-func (db *Database[K, T]) uploadTop(docpath K, payload []byte, docname string, user string, overwrite bool, isPost bool, dbName string) ([]byte, int, string) {
+func (db *Database[K, T]) uploadTop(docpath K, payload []byte, docname string, user string, overwrite bool, isPost bool, dbName string, cond precondition.Conditions) ([]byte, int, string) {
 
 	slog.Debug(fmt.Sprintf("uploading to the top of database %+v, isPost %t", string(docpath), isPost))
 
@@ -49,9 +55,24 @@ func (db *Database[K, T]) uploadTop(docpath K, payload []byte, docname string, u
 
 	var nullDoc T
 	var stat_code int
+	var journalErr error
 	check := func(docname K, curVal T, exists bool) (newVal T, err error) {
+		var version int64
+		if exists {
+			version = curVal.Version()
+		}
+		if err := cond.Check(exists, version); err != nil {
+			return nullDoc, err
+		}
 		if !exists {
 			stat_code = http.StatusCreated
+			if err := db.indexes.Update(string(docname), nil, payload); err != nil {
+				return nullDoc, err
+			}
+			if journalErr = db.journal.RecordPutDoc(dbName, string(docname), newDoc.GetSerial()); journalErr != nil {
+				db.indexes.Reindex(string(docname), payload, nil)
+				return nullDoc, journalErr
+			}
 			db.colSubscriptionManager.Notify(string(docname), "update", newDoc.GetSerial())
 			newDoc.Notify(dbName+"/"+string(docname), newDoc.GetSerial(), "update")
 			return newDoc, nil
@@ -61,7 +82,17 @@ func (db *Database[K, T]) uploadTop(docpath K, payload []byte, docname string, u
 				return nullDoc, fmt.Errorf("document already exists")
 			} else {
 				stat_code = http.StatusOK
+				oldBody := curVal.Body()
+				if err := db.indexes.Update(string(docname), oldBody, payload); err != nil {
+					return nullDoc, err
+				}
+				oldSerial := curVal.GetSerial()
 				curVal.UpdateDoc(payload, user)
+				if journalErr = db.journal.RecordPutDoc(dbName, string(docname), curVal.GetSerial()); journalErr != nil {
+					curVal.Restore(oldSerial)
+					db.indexes.Reindex(string(docname), payload, oldBody)
+					return nullDoc, journalErr
+				}
 				db.colSubscriptionManager.Notify(string(docname), "update", curVal.GetSerial())
 				curVal.Notify(db.name+"/"+string(docname), curVal.GetSerial(), "update")
 			}
@@ -72,9 +103,15 @@ func (db *Database[K, T]) uploadTop(docpath K, payload []byte, docname string, u
 
 	_, err := db.docs.Upsert(K(docname), check)
 
+	if journalErr != nil {
+		errmsg, stat := journalFailure(journalErr)
+		return errmsg, stat, ""
+	}
 	if err != nil { //doc failed -
 		var statCode int = 400
-		if !isPost {
+		if errors.Is(err, fieldIndex.ErrDuplicate) {
+			statCode = http.StatusConflict
+		} else if !isPost || errors.Is(err, precondition.ErrFailed) {
 			statCode = 412
 		}
 		b, _ := json.Marshal(err.Error())
@@ -95,9 +132,11 @@ func (db *Database[K, T]) uploadTop(docpath K, payload []byte, docname string, u
 }
 
 // GetDocumentSerial retrieves and returns a serialized version of the document located at docpath.
-// If isSubscribe is true, the function returns a channel for subscription to document changes.
+// If isSubscribe is true, the function returns a channel for subscription to document changes, resuming from the
+// event lastEventID if it is given.
 // Returns the serialized payload, a subscription channel, subscription ID, status code, and any document events.
-func (db *Database[K, T]) GetDocumentSerial(docpath string, isSubscribe bool) (payload []byte, subChannel *chan []byte, subId string, statusCode int, docEvent []byte) {
+func (db *Database[K, T]) GetDocumentSerial(docpath string, isSubscribe bool, lastEventID string) (payload []byte, subChannel *chan []byte, subId string, statusCode int, docEvent []byte) {
+	defer db.docLocks.rlock(docpath)()
 
 	slog.Debug(fmt.Sprintf("GetDocumentSerial,docpath is %s", docpath))
 
@@ -114,19 +153,34 @@ func (db *Database[K, T]) GetDocumentSerial(docpath string, isSubscribe bool) (p
 		return errmsg, nil, "", http.StatusNotFound, docEvent
 	}
 
-	payload, statusCode, subId, subChannel, docEvent = topDoc.GetChildDocument(docpath, isSubscribe, db.name)
+	payload, statusCode, subId, subChannel, docEvent = topDoc.GetChildDocument(docpath, isSubscribe, db.name, lastEventID)
 	return payload, subChannel, subId, statusCode, docEvent
 }
 
-// DeleteDoc deletes the document at the specified path.
+// GetDocumentHistory reads the history of the document located at docpath: the versions kept if sel is zero, or the
+// version sel picks.
+// Returns a JSON-encoded response and a status code.
+func (db *Database[K, T]) GetDocumentHistory(docpath string, sel history.Selector) ([]byte, int) {
+	defer db.docLocks.rlock(docpath)()
+
+	topDoc, found := db.docs.Find(K(strings.Split(docpath, "/")[0]))
+	if !found {
+		errmsg, _ := json.Marshal("Document does not exist")
+		return errmsg, http.StatusNotFound
+	}
+	return topDoc.GetChildDocumentHistory(docpath, sel)
+}
+
+// DeleteDoc deletes the document at the specified path on behalf of user, provided it satisfies the conditions cond.
 // This method can handle both top-level documents and child documents.
 // Returns a serialized response and a status code indicating success or failure.
-func (db *Database[K, T]) DeleteDoc(docpath string) ([]byte, int) {
+func (db *Database[K, T]) DeleteDoc(docpath string, user string, cond precondition.Conditions) ([]byte, int) {
+	defer db.docLocks.lock(docpath)()
 
 	splitPath := strings.Split(docpath, "/")
 
 	if len(splitPath) == 1 {
-		return db.deleteTop(docpath)
+		return db.deleteTop(docpath, user, cond)
 	}
 
 	topDocName := K(splitPath[0])
@@ -140,18 +194,37 @@ func (db *Database[K, T]) DeleteDoc(docpath string) ([]byte, int) {
 		return errmsg, http.StatusNotFound
 	}
 	//delegated to the documents
-	return topDoc.DeleteChildDocument(docpath, db.name)
+	return topDoc.DeleteChildDocument(docpath, db.name, user, cond)
 }
 
 // deleteTop handles the case where the topmost document must be deleted
 // Returns a response and status code indicating the outcome of the operation.
-func (db *Database[K, T]) deleteTop(docpath string) ([]byte, int) {
+func (db *Database[K, T]) deleteTop(docpath string, user string, cond precondition.Conditions) ([]byte, int) {
 
 	slog.Debug(fmt.Sprintf("deleting the top document,resource path is %s", docpath))
 
-	removedDoc, removed := db.docs.Remove(K(docpath))
+	var journalErr error
+	removedDoc, removed, err := db.docs.RemoveIf(K(docpath), func(key K, curVal T) error {
+		if err := cond.Check(true, curVal.Version()); err != nil {
+			return err
+		}
+		//journaled and unindexed while the document is locked, so that a write racing the deletion is logged in the
+		//same order and cannot have its index entries removed
+		if journalErr = db.journal.RecordDeleteDoc(db.name, docpath, user); journalErr != nil {
+			return journalErr
+		}
+		db.indexes.Reindex(string(key), curVal.Body(), nil)
+		return nil
+	})
 
-	//This is the only way removes can fail
+	if journalErr != nil {
+		return journalFailure(journalErr)
+	}
+	if err != nil {
+		errmsg, _ := json.Marshal(err.Error())
+		return errmsg, http.StatusPreconditionFailed
+	}
+	//This is the only other way removes can fail
 	if !removed {
 
 		errmsg, _ := json.Marshal("Document does not exist")
@@ -169,7 +242,8 @@ func (db *Database[K, T]) deleteTop(docpath string) ([]byte, int) {
 
 // deleteTop handles the deletion of a top-level document.
 // Returns a response and status code indicating the outcome of the operation.
-func (db *Database[K, T]) Patch(docPath string, patches []byte, user string) ([]byte, int) {
+func (db *Database[K, T]) Patch(docPath string, patches []byte, user string, mediaType string, cond precondition.Conditions) ([]byte, int) {
+	defer db.docLocks.lock(docPath)()
 
 	splitPath := strings.Split(docPath, "/")
 	if len(splitPath) == 0 {
@@ -182,11 +256,11 @@ func (db *Database[K, T]) Patch(docPath string, patches []byte, user string) ([]
 		return errmsg, http.StatusNotFound
 	}
 	if len(splitPath) == 1 {
-		return db.patchTop(docPath, patches, user)
+		return db.patchTop(docPath, patches, user, mediaType, cond)
 	}
 
 	slog.Debug(fmt.Sprintf("From Patch in db, user is %s", user))
-	responseBytes, statusCode := topDoc.ApplyPatchDocument(db.name, docPath, patches, user)
+	responseBytes, statusCode := topDoc.ApplyPatchDocument(db.name, docPath, patches, user, mediaType, cond)
 	if statusCode != http.StatusOK {
 		return responseBytes, statusCode
 	}
@@ -194,18 +268,22 @@ func (db *Database[K, T]) Patch(docPath string, patches []byte, user string) ([]
 }
 
 // Patch applies a JSON patch to the document at the specified path
-// and returns a response indicating the outcome of the operation.	
-func (db *Database[K, T]) patchTop(docName string, patch []byte, user string) ([]byte, int) {
+// and returns a response indicating the outcome of the operation.
+func (db *Database[K, T]) patchTop(docName string, patch []byte, user string, mediaType string, cond precondition.Conditions) ([]byte, int) {
 
 	var nullDoc T
 	var newDocPayload []byte
+	var journalErr error
 	slog.Debug(fmt.Sprintf("user is %s", user))
 	chk := func(name K, curDoc T, exists bool) (newDoc T, err error) { //guaranteed to be atomic due to locks in SL
 		if !exists {
 			return nullDoc, fmt.Errorf("Document does not exist")
 		}
+		if err := cond.Check(true, curDoc.Version()); err != nil {
+			return nullDoc, err
+		}
 
-		newRaw, patchErr := curDoc.DoPatch(patch)
+		newRaw, patchErr := curDoc.DoPatch(patch, mediaType)
 		if patchErr != nil { //DO NOT UPDATE
 			slog.Debug("DO NOT UPDATE,WE GOT AN ERROR")
 			return nullDoc, patchErr
@@ -214,10 +292,20 @@ func (db *Database[K, T]) patchTop(docName string, patch []byte, user string) ([
 		if err != nil {
 			return nullDoc, err
 		} //this will not update
+		oldBody := curDoc.Body()
+		if err = db.indexes.Update(string(name), oldBody, newRaw); err != nil {
+			return nullDoc, err
+		}
 		slog.Debug("About to update DOCUMENT SUBSCRIBERS")
+		oldSerial := curDoc.GetSerial()
 		curDoc.UpdateDoc(newRaw, user)
 
 		newDocPayload = curDoc.GetSerial() //serializing new documents
+		if journalErr = db.journal.RecordPutDoc(db.name, docName, newDocPayload); journalErr != nil {
+			curDoc.Restore(oldSerial)
+			db.indexes.Reindex(string(name), newRaw, oldBody)
+			return nullDoc, journalErr
+		}
 		curDoc.Notify(db.name+"/"+docName, newDocPayload, "update")
 		db.colSubscriptionManager.Notify(docName, "update", newDocPayload)
 		return curDoc, nil
@@ -227,12 +315,20 @@ func (db *Database[K, T]) patchTop(docName string, patch []byte, user string) ([
 	msg := "patch applied"
 	if !updated {
 
-		if er.Error() == "Document does not exist" {
+		if journalErr != nil {
+			return journalFailure(journalErr)
+		} else if er.Error() == "Document does not exist" {
 			errmsg, _ := json.Marshal(er.Error())
 			return errmsg, http.StatusNotFound
 		} else if strings.HasPrefix(er.Error(), "bad patch operation") {
 			errmsg, _ := json.Marshal(er.Error())
 			return errmsg, http.StatusBadRequest
+		} else if errors.Is(er, fieldIndex.ErrDuplicate) {
+			errmsg, _ := json.Marshal(er.Error())
+			return errmsg, http.StatusConflict
+		} else if errors.Is(er, precondition.ErrFailed) {
+			errmsg, _ := json.Marshal(er.Error())
+			return errmsg, http.StatusPreconditionFailed
 		} else {
 			msg = er.Error()
 		}
@@ -242,6 +338,51 @@ func (db *Database[K, T]) patchTop(docName string, patch []byte, user string) ([
 	return generatePatchResponse(db.name, docName, !updated, msg), http.StatusOK
 }
 
+// RestoreDocument recreates the document at docpath from serial, a document previously produced by GetSerial,
+// keeping its original metadata. No subscribers are notified, unique indices are not enforced and nothing is journaled.
+// Returns a response (if an error occurred) and a status code
+func (db *Database[K, T]) RestoreDocument(docpath string, serial []byte) ([]byte, int) {
+	defer db.docLocks.lock(docpath)()
+	splitPath := strings.Split(docpath, "/")
+	topDocName := K(splitPath[0])
+
+	if len(splitPath) > 1 {
+		topDoc, found := db.docs.Find(topDocName)
+		if !found {
+			errmsg, _ := json.Marshal("Document does not exist")
+			return errmsg, http.StatusNotFound
+		}
+		return topDoc.RestoreChildDocument(docpath, serial)
+	}
+
+	check := func(key K, curVal T, exists bool) (T, error) {
+		var oldBody []byte
+		if !exists {
+			curVal = db.dcf(nil, "", docpath)
+		} else {
+			oldBody = curVal.Body()
+		}
+		if err := curVal.Restore(serial); err != nil {
+			return curVal, err
+		}
+		db.indexes.Reindex(string(key), oldBody, curVal.Body())
+		return curVal, nil
+	}
+	if _, err := db.docs.Upsert(topDocName, check); err != nil {
+		errmsg, _ := json.Marshal(err.Error())
+		return errmsg, http.StatusBadRequest
+	}
+	return nil, http.StatusCreated
+}
+
+// journalFailure builds the response to a request whose change was not applied, since the journal failed to record it
+// with err.
+// Returns a JSON-encoded error and a status code
+func journalFailure(err error) ([]byte, int) {
+	errmsg, _ := json.Marshal("unable to persist the change: " + err.Error())
+	return errmsg, http.StatusInternalServerError
+}
+
 // generatePatchResponse generates a response for a patch operation.
 // The response includes the URI of the patched document, a flag indicating whether the patch failed,
 // and a message describing the outcome of the operation.
@@ -0,0 +1,155 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/fieldIndex"
+)
+
+// UploadIndex creates the secondary index described by def over the collection at colpath, at the database dbName.
+// An empty colpath names the top-level collection.
+// Returns a response, a status code and the URI of the index.
+func (db *Database[K, T]) UploadIndex(colpath string, def fieldIndex.Definition, dbName string) ([]byte, int, string) {
+	db.gate.lock(spanning)
+	defer db.gate.unlock(spanning)
+
+	slog.Debug(fmt.Sprintf("UploadIndex: indexing %s in the collection at path %s", def.Field, colpath))
+
+	if colpath == "" {
+		return db.uploadTopIndex(def, dbName)
+	}
+
+	topDoc, found := db.docs.Find(K(strings.Split(colpath, "/")[0]))
+	if !found {
+		errmsg, _ := json.Marshal("Collection does not exist")
+		return errmsg, http.StatusNotFound, ""
+	}
+	return topDoc.AddChildIndex(colpath, def, dbName)
+}
+
+// RestoreIndex recreates the secondary index described by def over the collection at colpath, doing nothing if it
+// already exists. Nothing is journaled; this is used to rebuild state, not to serve clients.
+// Returns a response (if an error occurred) and a status code
+func (db *Database[K, T]) RestoreIndex(colpath string, def fieldIndex.Definition) ([]byte, int) {
+	db.gate.lock(spanning)
+	defer db.gate.unlock(spanning)
+	if colpath == "" {
+		if _, errmsg, stat := db.createTopIndex(def); errmsg != nil {
+			return errmsg, stat
+		}
+		return nil, http.StatusCreated
+	}
+	topDoc, found := db.docs.Find(K(strings.Split(colpath, "/")[0]))
+	if !found {
+		errmsg, _ := json.Marshal("Collection does not exist")
+		return errmsg, http.StatusNotFound
+	}
+	return topDoc.RestoreChildIndex(colpath, def)
+}
+
+// DeleteIndex drops the secondary index on the field named by the JSON pointer field from the collection at colpath.
+// Returns a response (if an error occurred) and a status code.
+func (db *Database[K, T]) DeleteIndex(colpath string, field string) ([]byte, int) {
+	db.gate.lock(spanning)
+	defer db.gate.unlock(spanning)
+	if colpath == "" {
+		return db.deleteTopIndex(field)
+	}
+	topDoc, found := db.docs.Find(K(strings.Split(colpath, "/")[0]))
+	if !found {
+		errmsg, _ := json.Marshal("Collection does not exist")
+		return errmsg, http.StatusNotFound
+	}
+	return topDoc.DeleteChildIndex(colpath, field, db.name)
+}
+
+// uploadTopIndex creates the secondary index described by def over the top-level collection, at the database dbName.
+// Returns a response, a status code and the URI of the index
+func (db *Database[K, T]) uploadTopIndex(def fieldIndex.Definition, dbName string) ([]byte, int, string) {
+	created, errmsg, stat := db.createTopIndex(def)
+	if errmsg != nil {
+		return errmsg, stat, ""
+	}
+	if !created {
+		errmsg, _ := json.Marshal("Index already exists")
+		return errmsg, http.StatusBadRequest, ""
+	}
+	if err := db.journal.RecordPutIndex(dbName, "", def); err != nil {
+		db.indexes.Drop(def.Field)
+		errmsg, stat := journalFailure(err)
+		return errmsg, stat, ""
+	}
+	respJson := struct {
+		Uri string `json:"uri"`
+	}{
+		Uri: "/v1/" + dbName + "/?index=" + url.QueryEscape(def.Field),
+	}
+	b, _ := json.Marshal(respJson)
+	return b, http.StatusCreated, respJson.Uri
+}
+
+// createTopIndex creates the secondary index described by def over the top-level collection, indexing the documents
+// it already holds.
+// Returns whether the index was created, false if it already existed, or a JSON-encoded error and a status code
+func (db *Database[K, T]) createTopIndex(def fieldIndex.Definition) (bool, []byte, int) {
+	created, err := db.indexes.Create(def)
+	if err != nil {
+		errmsg, _ := json.Marshal(err.Error())
+		return false, errmsg, http.StatusBadRequest
+	}
+	if !created {
+		return false, nil, http.StatusOK
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(15*time.Second))
+	defer cancel()
+	docs, err := db.docs.Query(ctx, K(string(rune(0))), K(string(rune(127))))
+	if err != nil {
+		db.indexes.Drop(def.Field)
+		errmsg, _ := json.Marshal(err.Error())
+		return false, errmsg, http.StatusBadRequest
+	}
+	for _, pair := range docs {
+		//indexing under the document's lock, so that no concurrent write is indexed out of order
+		var dupErr error
+		db.docs.Upsert(pair.Key, func(key K, curVal T, exists bool) (T, error) {
+			if !exists {
+				return curVal, fmt.Errorf("document does not exist")
+			}
+			dupErr = db.indexes.Update(string(key), nil, curVal.Body())
+			return curVal, dupErr
+		})
+		if dupErr != nil {
+			db.indexes.Drop(def.Field)
+			errmsg, _ := json.Marshal(dupErr.Error())
+			return false, errmsg, http.StatusConflict
+		}
+	}
+	return true, nil, http.StatusOK
+}
+
+// deleteTopIndex drops the secondary index on the field named by the JSON pointer field from the top-level collection.
+// Returns a response (if an error occurred) and a status code
+func (db *Database[K, T]) deleteTopIndex(field string) ([]byte, int) {
+	var def fieldIndex.Definition
+	for _, held := range db.indexes.Definitions() {
+		if held.Field == field {
+			def = held
+		}
+	}
+	if !db.indexes.Drop(field) {
+		errmsg, _ := json.Marshal("Index does not exist")
+		return errmsg, http.StatusNotFound
+	}
+	if err := db.journal.RecordDeleteIndex(db.name, "", field); err != nil {
+		db.createTopIndex(def) //the index held the documents it is rebuilt from, so it can be rebuilt
+		return journalFailure(err)
+	}
+	return nil, http.StatusNoContent
+}
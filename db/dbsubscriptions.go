@@ -0,0 +1,77 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// UnsubscribeDoc removes the subscriber subId from the document located at docpath, closing its channel. Nothing
+// happens if the subscriber was already removed, as it is when the document is deleted.
+func (db *Database[K, T]) UnsubscribeDoc(docpath string, subId string) {
+	splitPath := strings.Split(docpath, "/")
+	topDoc, found := db.docs.Find(K(splitPath[0]))
+	if !found {
+		return
+	}
+	topDoc.UnsubscribeChildDocument(docpath, db.name, subId)
+}
+
+// UnsubscribeCol removes the subscriber subId from the collection located at colpath, closing its channel. Nothing
+// happens if the collection or the subscriber no longer exists.
+func (db *Database[K, T]) UnsubscribeCol(colpath string, subId string) {
+	if len(colpath) == 0 {
+		db.colSubscriptionManager.Remove(subId)
+		return
+	}
+	splitPath := strings.Split(colpath, "/")
+	topDoc, found := db.docs.Find(K(splitPath[0]))
+	if !found {
+		return
+	}
+	topDoc.UnsubscribeChildCollection(colpath, subId)
+}
+
+// SubscribeSubtree subscribes to the subtree rooted at path: a document, a collection if path ends with "/", or every
+// document of the database if path is empty. The subscription resumes from the event lastEventID if given.
+// Returns an error message and a status code, along with the subscription and the events it starts with
+func (db *Database[K, T]) SubscribeSubtree(path string, lastEventID string) ([]byte, int, *chan []byte, string, [][]byte) {
+	db.gate.lock(spanning)
+	defer db.gate.unlock(spanning)
+
+	var snapshot [][]byte
+	if len(path) == 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+		docs, _ := db.docs.Query(ctx, K(string(rune(0))), K(string(rune(127))))
+		for _, doc := range docs {
+			_, _, subtree := doc.Value.GetChildSubtree(string(doc.Key))
+			snapshot = append(snapshot, subtree...)
+		}
+	} else {
+		splitPath := strings.Split(path, "/")
+		topDoc, found := db.docs.Find(K(splitPath[0]))
+		if !found {
+			errmsg, _ := json.Marshal("Document does not exist")
+			if len(splitPath) > 1 {
+				errmsg, _ = json.Marshal("Collection does not exist")
+			}
+			return errmsg, http.StatusNotFound, nil, "", nil
+		}
+		errmsg, stat, subtree := topDoc.GetChildSubtree(path)
+		if stat != http.StatusOK {
+			return errmsg, stat, nil, "", nil
+		}
+		snapshot = subtree
+	}
+	subChan, subId, events := db.tree.AddTreeSubscriber(db.name+"/"+path, lastEventID, snapshot)
+	return nil, http.StatusOK, subChan, subId, events
+}
+
+// UnsubscribeSubtree removes the subscriber subId from the subtree rooted at path, closing its channel. Nothing
+// happens if the subscriber was already removed, as it is when the root of the subtree is deleted.
+func (db *Database[K, T]) UnsubscribeSubtree(path string, subId string) {
+	db.tree.RemoveTreeSubscriber(db.name+"/"+path, subId)
+}
@@ -0,0 +1,243 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/transaction"
+)
+
+// Transact applies the operations of a transaction, in order, as a whole: either every operation applies or none
+// does. The documents it writes are locked until it ends, so requests to them wait for it while requests to other
+// documents proceed, and requests spanning many documents wait as well, so no reader sees it half applied.
+// The transaction is only journaled once every operation has applied, and subscribers are only notified once it has
+// been journaled; if journaling fails, every operation is undone.
+// Returns a JSON array holding the URI and status of each operation, or the error of the first operation that
+// failed along with its status code
+func (db *Database[K, T]) Transact(ops []transaction.Operation, user string) ([]byte, int) {
+	docpaths := make([]string, len(ops))
+	for i, op := range ops {
+		docpaths[i] = op.DocPath()
+	}
+	db.gate.lock(transacting)
+	defer db.gate.unlock(transacting)
+	defer db.docLocks.lockAll(docpaths)()
+
+	slog.Debug(fmt.Sprintf("Transact: applying %d operations to the database %s", len(ops), db.name))
+
+	writes := make([]*transaction.Write, 0, len(ops))
+	var deleted []string
+	for i, op := range ops {
+		docpath := op.DocPath()
+		var write *transaction.Write
+		var errmsg []byte
+		var statCode int
+		if gone := deletedAncestor(deleted, docpath); gone != "" {
+			errmsg, _ = json.Marshal(fmt.Sprintf("document '/%s' was deleted earlier in the transaction", gone))
+			statCode = http.StatusConflict
+		} else {
+			write, errmsg, statCode = db.transactOne(op, user)
+		}
+		if write == nil {
+			for j := len(writes) - 1; j >= 0; j-- {
+				writes[j].Undo()
+			}
+			var msg string
+			json.Unmarshal(errmsg, &msg)
+			b, _ := json.Marshal(fmt.Sprintf("operation %d: %s", i, msg))
+			return b, statCode
+		}
+		writes = append(writes, write)
+		if op.Op == transaction.OpDelete {
+			deleted = append(deleted, docpath)
+		}
+	}
+
+	serials := make([][]byte, len(ops))
+	for i, write := range writes {
+		serials[i] = write.Serial
+	}
+	if err := db.journal.RecordTransaction(db.name, docpaths, serials, user); err != nil {
+		for j := len(writes) - 1; j >= 0; j-- {
+			writes[j].Undo()
+		}
+		return journalFailure(err)
+	}
+	for _, write := range writes {
+		if write.Commit != nil {
+			write.Commit()
+		}
+	}
+
+	type result struct {
+		Uri    string `json:"uri"`
+		Status int    `json:"status"`
+	}
+	results := make([]result, len(writes))
+	for i, write := range writes {
+		write.Notify()
+		results[i] = result{Uri: "/v1/" + db.name + ops[i].Path, Status: write.Status}
+	}
+	b, _ := json.Marshal(results)
+	return b, http.StatusOK
+}
+
+// deletedAncestor finds the document among deleted that is docpath or one of its ancestors.
+// Returns the path of that document, or "" if there is none
+func deletedAncestor(deleted []string, docpath string) string {
+	for _, gone := range deleted {
+		if docpath == gone || strings.HasPrefix(docpath, gone+"/") {
+			return gone
+		}
+	}
+	return ""
+}
+
+// transactOne applies a single operation of a transaction, delegating to the top-level documents for any document
+// beneath them.
+// Returns the write, or nil, a JSON-encoded error and a status code if the operation cannot be applied
+func (db *Database[K, T]) transactOne(op transaction.Operation, user string) (*transaction.Write, []byte, int) {
+	splitPath := strings.Split(op.DocPath(), "/")
+	if len(splitPath) == 1 {
+		if op.Op == transaction.OpDelete {
+			return db.transactDeleteTop(op)
+		}
+		return db.transactWriteTop(op, user)
+	}
+	topDoc, found := db.docs.Find(K(splitPath[0]))
+	if !found {
+		errmsg, _ := json.Marshal("Document does not exist")
+		return nil, errmsg, http.StatusNotFound
+	}
+	return topDoc.TransactChildDocument(db.name, op, user)
+}
+
+// transactWriteTop applies a put or a patch of a transaction to a top-level document.
+// Returns the write, or nil, a JSON-encoded error and a status code if the operation cannot be applied
+func (db *Database[K, T]) transactWriteTop(op transaction.Operation, user string) (*transaction.Write, []byte, int) {
+	docname := op.DocPath()
+	cond := op.Conditions()
+	var nullDoc T
+	var write *transaction.Write
+	var statCode int
+	check := func(key K, curVal T, exists bool) (T, error) {
+		var version int64
+		if exists {
+			version = curVal.Version()
+		}
+		if err := cond.Check(exists, version); err != nil {
+			statCode = http.StatusPreconditionFailed
+			return nullDoc, err
+		}
+		payload := []byte(op.Doc)
+		if op.Op == transaction.OpPatch {
+			if !exists {
+				statCode = http.StatusNotFound
+				return nullDoc, fmt.Errorf("Document does not exist")
+			}
+			newRaw, err := curVal.DoPatch(op.Patch, op.MediaType())
+			if err != nil {
+				statCode = http.StatusConflict
+				if strings.HasPrefix(err.Error(), "bad patch operation") {
+					statCode = http.StatusBadRequest
+				}
+				return nullDoc, err
+			}
+			if err = db.validator.Validate(newRaw); err != nil {
+				statCode = http.StatusBadRequest
+				return nullDoc, err
+			}
+			payload = newRaw
+		}
+
+		if !exists {
+			if err := db.indexes.Update(docname, nil, payload); err != nil {
+				statCode = http.StatusConflict
+				return nullDoc, err
+			}
+			newDoc := db.dcf(payload, user, docname)
+			serial := newDoc.GetSerial()
+			write = &transaction.Write{
+				Status: http.StatusCreated,
+				Serial: serial,
+				Undo: func() {
+					db.docs.Remove(key)
+					db.indexes.Reindex(docname, payload, nil)
+				},
+				Notify: func() {
+					db.colSubscriptionManager.Notify(docname, "update", serial)
+					newDoc.Notify(db.name+"/"+docname, serial, "update")
+				},
+			}
+			return newDoc, nil
+		}
+
+		oldBody := curVal.Body()
+		if err := db.indexes.Update(docname, oldBody, payload); err != nil {
+			statCode = http.StatusConflict
+			return nullDoc, err
+		}
+		oldSerial := curVal.GetSerial()
+		curVal.UpdateDoc(payload, user)
+		serial := curVal.GetSerial()
+		write = &transaction.Write{
+			Status: http.StatusOK,
+			Serial: serial,
+			Undo: func() {
+				db.docs.Upsert(key, func(key K, cur T, exists bool) (T, error) {
+					if !exists {
+						return nullDoc, fmt.Errorf("Document does not exist")
+					}
+					return cur, cur.Restore(oldSerial)
+				})
+				db.indexes.Reindex(docname, payload, oldBody)
+			},
+			Notify: func() {
+				db.colSubscriptionManager.Notify(docname, "update", serial)
+				curVal.Notify(db.name+"/"+docname, serial, "update")
+			},
+		}
+		return curVal, nil
+	}
+	if _, err := db.docs.Upsert(K(docname), check); err != nil {
+		errmsg, _ := json.Marshal(err.Error())
+		return nil, errmsg, statCode
+	}
+	return write, nil, http.StatusOK
+}
+
+// transactDeleteTop applies a delete of a transaction to a top-level document. The document is only unindexed for
+// now, and removed when the transaction commits.
+// Returns the write, or nil, a JSON-encoded error and a status code if the operation cannot be applied
+func (db *Database[K, T]) transactDeleteTop(op transaction.Operation) (*transaction.Write, []byte, int) {
+	docname := op.DocPath()
+	victim, found := db.docs.Find(K(docname))
+	if !found {
+		errmsg, _ := json.Marshal("Document does not exist")
+		return nil, errmsg, http.StatusNotFound
+	}
+	if err := op.Conditions().Check(true, victim.Version()); err != nil {
+		errmsg, _ := json.Marshal(err.Error())
+		return nil, errmsg, http.StatusPreconditionFailed
+	}
+	body := victim.Body()
+	db.indexes.Reindex(docname, body, nil)
+	write := &transaction.Write{
+		Status: http.StatusNoContent,
+		Undo: func() {
+			db.indexes.Reindex(docname, nil, body)
+		},
+		Commit: func() {
+			db.docs.Remove(K(docname))
+		},
+		Notify: func() {
+			b, _ := json.Marshal("/" + docname)
+			victim.Notify(db.name+"/"+docname, b, "delete")
+			db.colSubscriptionManager.Notify(docname, "delete", b)
+		},
+	}
+	return write, nil, http.StatusNoContent
+}
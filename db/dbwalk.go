@@ -0,0 +1,29 @@
+package db
+
+import (
+	"context"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/fieldIndex"
+)
+
+// Walk visits every top-level document of the database and, through them, every collection and document beneath
+// them, parents before children. Paths are relative to the database. The top-level collection is visited first,
+// with an empty path, if it has secondary indices.
+// Returns an error if ctx expires before the walk completes
+func (db *Database[K, T]) Walk(ctx context.Context, visitCol func(colpath string, indexes []fieldIndex.Definition), visitDoc func(docpath string, serial []byte)) error {
+	db.gate.lock(spanning)
+	defer db.gate.unlock(spanning)
+	if indexes := db.indexes.Definitions(); len(indexes) > 0 {
+		visitCol("", indexes)
+	}
+	docs, err := db.docs.Query(ctx, K(string(rune(0))), K(string(rune(127))))
+	if err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		if err = doc.Value.Walk(ctx, visitCol, visitDoc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package db
+
+import (
+	"slices"
+	"sync"
+)
+
+// docLocks locks the documents of a database by path, so that a transaction keeps other requests away from only the
+// documents it writes. The zero value holds no locks.
+type docLocks struct {
+	mu    sync.Mutex
+	locks map[string]*docLock
+}
+
+// docLock is the lock of a single document, kept only while a request holds or waits on it
+type docLock struct {
+	sync.RWMutex
+	refs int // the number of requests holding or waiting on the lock
+}
+
+// acquire returns the lock of the document at docpath, creating it if no one holds it
+func (l *docLocks) acquire(docpath string) *docLock {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.locks == nil {
+		l.locks = make(map[string]*docLock)
+	}
+	lock, found := l.locks[docpath]
+	if !found {
+		lock = &docLock{}
+		l.locks[docpath] = lock
+	}
+	lock.refs++
+	return lock
+}
+
+// release forgets the lock of the document at docpath once no one holds or waits on it
+func (l *docLocks) release(docpath string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lock := l.locks[docpath]
+	lock.refs--
+	if lock.refs == 0 {
+		delete(l.locks, docpath)
+	}
+}
+
+// lock locks the document at docpath against every other request to it.
+// Returns the function unlocking it
+func (l *docLocks) lock(docpath string) func() {
+	lock := l.acquire(docpath)
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		l.release(docpath)
+	}
+}
+
+// rlock locks the document at docpath against writes, letting other reads of it proceed.
+// Returns the function unlocking it
+func (l *docLocks) rlock(docpath string) func() {
+	lock := l.acquire(docpath)
+	lock.RLock()
+	return func() {
+		lock.RUnlock()
+		l.release(docpath)
+	}
+}
+
+// lockAll locks each of the documents at docpaths against every other request to it. The documents are locked in
+// sorted order, so that two requests locking some of the same documents never each wait on a lock the other holds.
+// Returns the function unlocking them
+func (l *docLocks) lockAll(docpaths []string) func() {
+	sorted := slices.Clone(docpaths)
+	slices.Sort(sorted)
+	sorted = slices.Compact(sorted)
+	unlocks := make([]func(), len(sorted))
+	for i, docpath := range sorted {
+		unlocks[i] = l.lock(docpath)
+	}
+	return func() {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			unlocks[i]()
+		}
+	}
+}
+
+// The sides of a sidedLock
+const (
+	spanning    = 0 // requests that read or remove many documents at once
+	transacting = 1 // transactions
+)
+
+// sidedLock is held by any number of requests at once, as long as they all hold the same side of it. A request finding
+// the other side held or waited on waits, and once the holders of a side are done, every request then waiting on the
+// other side is let in together, so neither side starves. The zero value is unlocked.
+type sidedLock struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	holders  [2]int // the number of requests holding each side
+	waiting  [2]int // the number of requests waiting on each side
+	admitted [2]int // the number of requests waiting on each side that have been let in, but have yet to hold it
+}
+
+// lock waits until no request holds the other side, then holds side
+func (l *sidedLock) lock(side int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.cond == nil {
+		l.cond = sync.NewCond(&l.mu)
+	}
+	other := 1 - side
+	if l.holders[other] == 0 && l.waiting[other] == 0 && l.admitted[other] == 0 {
+		l.holders[side]++
+		return
+	}
+	l.waiting[side]++
+	for l.admitted[side] == 0 {
+		l.cond.Wait()
+	}
+	l.admitted[side]--
+	l.waiting[side]--
+	l.holders[side]++
+}
+
+// unlock stops holding side, letting in every request waiting on the other side once no one holds this one
+func (l *sidedLock) unlock(side int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	other := 1 - side
+	l.holders[side]--
+	if l.holders[side] == 0 && l.admitted[side] == 0 && l.waiting[other] > 0 {
+		l.admitted[other] = l.waiting[other]
+		l.cond.Broadcast()
+	}
+}
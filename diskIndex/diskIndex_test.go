@@ -0,0 +1,147 @@
+package diskIndex
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// stringCodec stores strings as-is
+type stringCodec struct{}
+
+func (stringCodec) Encode(value string) ([]byte, error) {
+	return []byte(value), nil
+}
+
+func (stringCodec) Decode(key string, b []byte) (string, error) {
+	return string(b), nil
+}
+
+// put unconditionally stores value under key
+func put(ix *Index[string], key string, value string) {
+	ix.Upsert(key, func(string, string, bool) (string, error) {
+		return value, nil
+	})
+}
+
+func openStore(t *testing.T, compactAt int64) *Store {
+	s, err := Open(filepath.Join(t.TempDir(), "test.dat"), compactAt)
+	if err != nil {
+		t.Fatalf("Open failed: %s", err.Error())
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestIndex_FindUpsertRemove(t *testing.T) {
+	ix := NewIndex[string](openStore(t, DefaultCompactAt), "", stringCodec{})
+	put(ix, "a", "one")
+	put(ix, "a", "two")
+	if v, found := ix.Find("a"); !found || v != "two" {
+		t.Errorf("TestIndex_FindUpsertRemove failed, expected two, got %s (%t)", v, found)
+	}
+
+	//a failed check leaves the value untouched
+	updated, err := ix.Upsert("a", func(string, string, bool) (string, error) {
+		return "", fmt.Errorf("no")
+	})
+	if updated || err == nil {
+		t.Errorf("TestIndex_FindUpsertRemove failed, expected the upsert to be rejected")
+	}
+	if v, _ := ix.Find("a"); v != "two" {
+		t.Errorf("TestIndex_FindUpsertRemove failed, expected two after a rejected upsert, got %s", v)
+	}
+
+	if v, removed := ix.Remove("a"); !removed || v != "two" {
+		t.Errorf("TestIndex_FindUpsertRemove failed, expected to remove two, got %s (%t)", v, removed)
+	}
+	if _, found := ix.Find("a"); found {
+		t.Errorf("TestIndex_FindUpsertRemove failed, expected a to be removed")
+	}
+}
+
+func TestIndex_Query(t *testing.T) {
+	ix := NewIndex[string](openStore(t, DefaultCompactAt), "", stringCodec{})
+	for _, k := range []string{"d", "a", "c", "b"} {
+		put(ix, k, "v"+k)
+	}
+	res, err := ix.Query(context.Background(), "b", "c")
+	if err != nil || len(res) != 2 || res[0].Key != "b" || res[1].Value != "vc" {
+		t.Errorf("TestIndex_Query failed, got %v, %v", res, err)
+	}
+}
+
+func TestIndex_RemoveDropsNested(t *testing.T) {
+	s := openStore(t, DefaultCompactAt)
+	var dropped []string
+	s.OnDrop(func(prefix string) {
+		dropped = append(dropped, prefix)
+	})
+	top := NewIndex[string](s, "", stringCodec{})
+	put(top, "doc", "x")
+	nested := NewIndex[string](s, "doc/col/", stringCodec{})
+	put(nested, "child", "y")
+	if NewIndex[string](s, "doc/col/", stringCodec{}) != nested {
+		t.Errorf("TestIndex_RemoveDropsNested failed, expected the same index for the same prefix")
+	}
+
+	top.Remove("doc")
+	if len(dropped) != 1 || dropped[0] != "doc/col/" {
+		t.Errorf("TestIndex_RemoveDropsNested failed, expected doc/col/ to be dropped, got %v", dropped)
+	}
+	if _, found := NewIndex[string](s, "doc/col/", stringCodec{}).Find("child"); found {
+		t.Errorf("TestIndex_RemoveDropsNested failed, expected a recreated index to be empty")
+	}
+}
+
+func TestStore_Compact(t *testing.T) {
+	s := openStore(t, 1<<30)
+	ix := NewIndex[string](s, "", stringCodec{})
+	for i := 0; i < 100; i++ {
+		put(ix, "key"+strconv.Itoa(i%10), "value"+strconv.Itoa(i))
+	}
+	before := s.active.size
+	if err := s.Compact(); err != nil {
+		t.Fatalf("Compact failed: %s", err.Error())
+	}
+	if s.active.size >= before {
+		t.Errorf("TestStore_Compact failed, expected the data file to shrink from %d, got %d", before, s.active.size)
+	}
+	for i := 90; i < 100; i++ {
+		if v, _ := ix.Find("key" + strconv.Itoa(i%10)); v != "value"+strconv.Itoa(i) {
+			t.Errorf("TestStore_Compact failed, expected value%d, got %s", i, v)
+		}
+	}
+}
+
+func TestStore_CompactConcurrently(t *testing.T) {
+	s := openStore(t, 256)
+	ix := NewIndex[string](s, "", stringCodec{})
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				key := "w" + strconv.Itoa(w) + "k" + strconv.Itoa(i%5)
+				put(ix, key, strconv.Itoa(i))
+				if _, found := ix.Find(key); !found {
+					t.Errorf("TestStore_CompactConcurrently failed, lost %s", key)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	s.Compact()
+	for w := 0; w < 8; w++ {
+		for i := 195; i < 200; i++ {
+			key := "w" + strconv.Itoa(w) + "k" + strconv.Itoa(i%5)
+			if v, _ := ix.Find(key); v != strconv.Itoa(i) {
+				t.Errorf("TestStore_CompactConcurrently failed, expected %s=%d, got %s", key, i, v)
+			}
+		}
+	}
+}
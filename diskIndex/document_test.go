@@ -0,0 +1,104 @@
+package diskIndex
+
+import (
+	"context"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/concurrentSkipList"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/document"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/fieldIndex"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/mocks"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/patcher"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/precondition"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/subscriptionManager"
+)
+
+// acceptAll is a validator accepting every document
+type acceptAll struct{}
+
+func (acceptAll) Validate([]byte) error {
+	return nil
+}
+
+// diskDocument creates a top-level document whose collections, and everything beneath them, live in s
+func diskDocument(s *Store, name string) (*document.Document, *Index[*document.Document]) {
+	smFactory := func() document.SubscriptionManager {
+		return subscriptionManager.New(concurrentSkipList.NewSL[string, *chan []byte](string(rune(0)), string(rune(127))), subscriptionManager.Delivery{Depth: 1})
+	}
+	idtosubfactory := func() subscriptionManager.IdToSub[string, *chan []byte] {
+		return concurrentSkipList.NewSL[string, *chan []byte](string(rune(0)), string(rune(127)))
+	}
+	messager := subscriptionManager.NewMessager(idtosubfactory, concurrentSkipList.NewSL[string, *subscriptionManager.SubscriptionManager](string(rune(0)), string(rune(127))), concurrentSkipList.NewSL[string, *subscriptionManager.SubscriptionManager](string(rune(0)), string(rune(127))), subscriptionManager.Delivery{Depth: 1}, nil)
+
+	var newDoc func(payload []byte, user string, docpath string) *document.Document
+	var colFactory document.CollectionFactory
+	var docColFactory document.DocumentIndexFactory[document.DocumentIndex[string, *document.Collection]]
+	codec := document.DocumentCodec{New: func(docpath string) *document.Document {
+		return newDoc(nil, "", docpath)
+	}}
+	newDoc = func(payload []byte, user string, docpath string) *document.Document {
+		return document.New(payload, user, docpath, docColFactory, colFactory, smFactory, acceptAll{}, patcher.Patcher{}, messager, &mocks.MockJournal{})
+	}
+	docColFactory = func(docpath string) document.DocumentIndex[string, *document.Collection] {
+		return NewIndex[*document.Collection](s, docpath+"/", document.CollectionCodec{DocPath: docpath, Factory: colFactory})
+	}
+	colFactory = func(colpath string) *document.Collection {
+		return &document.Collection{
+			Name:                path.Base(colpath),
+			Docs:                NewIndex[*document.Document](s, colpath+"/", codec),
+			SubscriptionManager: subscriptionManager.NewColSubManager(concurrentSkipList.NewSL[string, subscriptionManager.Colsubscriber](string(rune(0)), string(rune(127))), subscriptionManager.Delivery{Depth: 1}),
+			Indexes:             fieldIndex.NewSet(),
+		}
+	}
+
+	top := NewIndex[*document.Document](s, "", codec)
+	doc := newDoc([]byte(`{"a":1}`), "user", name)
+	top.Upsert(name, func(string, *document.Document, bool) (*document.Document, error) {
+		return doc, nil
+	})
+	found, _ := top.Find(name)
+	return found, top
+}
+
+func TestDiskDocuments(t *testing.T) {
+	s := openStore(t, DefaultCompactAt)
+	doc, top := diskDocument(s, "doc")
+
+	if _, stat, _ := doc.AddChildCollection("doc/col", "db", "user"); stat != 201 {
+		t.Fatalf("TestDiskDocuments failed, unable to add collection: %d", stat)
+	}
+	if _, stat, _ := doc.AddChildDocument("doc/col/child", []byte(`{"b":2}`), "child", "user", false, false, "db", precondition.Conditions{}); stat != 201 {
+		t.Fatalf("TestDiskDocuments failed, unable to add document: %d", stat)
+	}
+	if _, stat := doc.ApplyPatchDocument("db", "doc/col/child", []byte(`[{"op":"ObjectAdd","path":"/c","value":3}]`), "user", patcher.MediaTypeOwlDB, precondition.Conditions{}); stat != 200 {
+		t.Fatalf("TestDiskDocuments failed, unable to patch document: %d", stat)
+	}
+
+	//a freshly decoded copy of the top-level document sees everything beneath it
+	reloaded, _ := top.Find("doc")
+	payload, stat, _, _, _ := reloaded.GetChildDocument("doc/col/child", false, "db", "")
+	if stat != 200 {
+		t.Fatalf("TestDiskDocuments failed, expected to find the nested document, got %d", stat)
+	}
+	var visited []string
+	reloaded.Walk(context.Background(), func(colpath string, indexes []fieldIndex.Definition) {
+		visited = append(visited, colpath)
+	}, func(docpath string, serial []byte) {
+		visited = append(visited, docpath)
+	})
+	if len(visited) != 3 || visited[2] != "doc/col/child" {
+		t.Errorf("TestDiskDocuments failed, unexpected walk %v", visited)
+	}
+	if want := `"doc":{"b":2,"c":3}`; !strings.Contains(string(payload), want) {
+		t.Errorf("TestDiskDocuments failed, expected the patch to persist, got %s", payload)
+	}
+
+	//deleting and recreating the collection leaves it empty
+	doc.DeleteChildCollection("doc/col", "db", "user")
+	doc.AddChildCollection("doc/col", "db", "user")
+	if _, stat, _, _, _ := doc.GetChildDocument("doc/col/child", false, "db", ""); stat != 404 {
+		t.Errorf("TestDiskDocuments failed, expected the recreated collection to be empty, got %d", stat)
+	}
+}
@@ -0,0 +1,228 @@
+package diskIndex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/concurrentSkipList"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/index_utils"
+)
+
+// Codec converts the values held by an index to and from their stored form
+type Codec[V any] interface {
+	Encode(value V) ([]byte, error)         // encodes a value
+	Decode(key string, b []byte) (V, error) // decodes the value stored under key
+}
+
+// errGone is returned internally when a value vanished while being relocated
+var errGone = errors.New("value no longer exists")
+
+// Index is an ordered key-value index whose values live in a Store's data file. It implements the same interface as
+// the in-memory skip list: Find, Upsert, Remove and Query. It is safe for concurrent use.
+type Index[V any] struct {
+	store *Store // the store holding the values
+
+	prefix string // the path of the index within the store's hierarchy
+
+	codec Codec[V] // encodes and decodes values
+
+	locs *concurrentSkipList.Skiplist[string, *location] // the location of every value, by key
+}
+
+// NewIndex returns the index with the given prefix in store s, creating it if needed. Values are encoded with codec.
+// Returns the index
+func NewIndex[V any](s *Store, prefix string, codec Codec[V]) *Index[V] {
+	if existing, found := s.indices.Find("/" + prefix); found {
+		if ix, ok := existing.(*Index[V]); ok {
+			return ix
+		}
+	}
+	var res *Index[V]
+	s.indices.Upsert("/"+prefix, func(key string, curVal view, exists bool) (view, error) {
+		if exists {
+			if ix, ok := curVal.(*Index[V]); ok {
+				res = ix
+				return curVal, nil
+			}
+		}
+		res = &Index[V]{
+			store:  s,
+			prefix: prefix,
+			codec:  codec,
+			locs:   concurrentSkipList.NewSL[string, *location](string(rune(0)), string(rune(127))),
+		}
+		return res, nil
+	})
+	return res
+}
+
+// decode reads and decodes the value held at loc
+func (ix *Index[V]) decode(key string, loc *location) (V, error) {
+	var nullV V
+	b, err := ix.store.read(loc)
+	if err != nil {
+		return nullV, err
+	}
+	return ix.codec.Decode(key, b)
+}
+
+// load reads the value stored under key, retrying if it is relocated while being read.
+// Returns the value and true if the key exists, otherwise returns the zero value of V and false
+func (ix *Index[V]) load(key string, loc *location) (V, bool) {
+	var nullV V
+	for {
+		val, err := ix.decode(key, loc)
+		if err == nil {
+			return val, true
+		}
+		if !errors.Is(err, os.ErrClosed) {
+			slog.Error("Unable to read value from index", "prefix", ix.prefix, "key", key, "error", err)
+			return nullV, false
+		}
+		//the value was moved by a compaction; follow it to its new location
+		newLoc, found := ix.locs.Find(key)
+		if !found || newLoc == loc {
+			return nullV, false
+		}
+		loc = newLoc
+	}
+}
+
+// Find gets the value associated with key.
+// Returns the value and true if the key exists, otherwise returns the zero value of V and false
+func (ix *Index[V]) Find(key string) (V, bool) {
+	var nullV V
+	loc, found := ix.locs.Find(key)
+	if !found {
+		return nullV, false
+	}
+	return ix.load(key, loc)
+}
+
+// Upsert either updates or inserts the value under key, depending on the function check's behavior. check sees a
+// decoded copy of the current value; the value it returns is encoded and written back.
+// Returns true if the operation was successful, and an error if the operation failed
+func (ix *Index[V]) Upsert(key string, check index_utils.UpdateCheck[string, V]) (bool, error) {
+	var written *location
+	updated, err := ix.locs.Upsert(key, func(k string, curLoc *location, exists bool) (*location, error) {
+		var curVal V
+		if exists {
+			var err error
+			if curVal, err = ix.decode(k, curLoc); err != nil {
+				return curLoc, fmt.Errorf("unable to read current value: %w", err)
+			}
+		}
+		newVal, err := check(k, curVal, exists)
+		if err != nil {
+			return curLoc, err
+		}
+		b, err := ix.codec.Encode(newVal)
+		if err != nil {
+			return curLoc, fmt.Errorf("unable to encode value: %w", err)
+		}
+		if written, err = ix.store.append(b); err != nil {
+			return curLoc, fmt.Errorf("unable to write value: %w", err)
+		}
+		if exists {
+			ix.store.discard(curLoc)
+		}
+		return written, nil
+	})
+	if written != nil {
+		ix.store.published(written)
+	}
+	return updated, err
+}
+
+// Remove removes the value under key (if it exists), along with every index nested beneath it.
+// Returns the removed value and true if it was removed, otherwise returns the zero value of V and false
+func (ix *Index[V]) Remove(key string) (V, bool) {
+	val, removed, _ := ix.RemoveIf(key, nil)
+	return val, removed
+}
+
+// RemoveIf removes the value under key (if it exists), along with every index nested beneath it, provided check
+// returns no error for the value; a nil check always allows the removal.
+// Returns the removed value and true if it was removed, otherwise returns the zero value of V, false and the error
+// returned by check, if any
+func (ix *Index[V]) RemoveIf(key string, check index_utils.RemoveCheck[string, V]) (V, bool, error) {
+	var nullV V
+	var val V
+	loc, removed, err := ix.locs.RemoveIf(key, func(k string, curLoc *location) error {
+		var err error
+		if val, err = ix.decode(k, curLoc); err != nil {
+			val = nullV
+		}
+		if check == nil {
+			return nil
+		}
+		return check(k, val)
+	})
+	if !removed {
+		return nullV, false, err
+	}
+	ix.store.discard(loc)
+	ix.store.dropBeneath(ix.prefix + key + "/")
+	return val, true, nil
+}
+
+// Query retrieves all key-value pairs in the range [lower,upper]
+// Returns a slice of index_utils.Pair and an error if the operation fails
+func (ix *Index[V]) Query(ctx context.Context, lower string, upper string) ([]index_utils.Pair[string, V], error) {
+	locs, err := ix.locs.Query(ctx, lower, upper)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]index_utils.Pair[string, V], 0, len(locs))
+	for _, pair := range locs {
+		if val, found := ix.load(pair.Key, pair.Value); found {
+			res = append(res, index_utils.Pair[string, V]{Key: pair.Key, Value: val})
+		}
+	}
+	return res, nil
+}
+
+// relocate moves every value held in from to the store's active data file
+func (ix *Index[V]) relocate(from *dataFile) {
+	locs, _ := ix.locs.Query(context.Background(), string(rune(0)), string(rune(127)))
+	for _, pair := range locs {
+		if pair.Value.file != from {
+			continue
+		}
+		var written *location
+		//the location is checked again under the key's lock: a value written since the query went to the active file
+		//and must be kept rather than replaced by a copy of the stale one
+		ix.locs.Upsert(pair.Key, func(k string, curLoc *location, exists bool) (*location, error) {
+			if !exists {
+				return nil, errGone
+			}
+			if curLoc.file != from {
+				return curLoc, nil
+			}
+			b, err := ix.store.read(curLoc)
+			if err != nil {
+				return curLoc, err
+			}
+			if written, err = ix.store.append(b); err != nil {
+				return curLoc, err
+			}
+			return written, nil
+		})
+		if written != nil {
+			ix.store.published(written)
+		}
+	}
+}
+
+// drop discards every value held by the index
+func (ix *Index[V]) drop() {
+	locs, _ := ix.locs.Query(context.Background(), string(rune(0)), string(rune(127)))
+	for _, pair := range locs {
+		if loc, removed := ix.locs.Remove(pair.Key); removed {
+			loc.file.garbage.Add(int64(loc.n))
+		}
+	}
+}
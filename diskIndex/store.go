@@ -0,0 +1,233 @@
+// Package diskIndex implements the index interfaces used by databases, documents and collections on top of a data
+// file, so that the values they hold do not need to stay in memory. Only keys and the location of their values are
+// kept in memory; values are encoded with a Codec, appended to the file, and decoded again on every read.
+//
+// Indices sharing a Store form a hierarchy named by path prefixes: removing a key from an index also drops every
+// index whose prefix lies beneath that key, the same way removing a document drops its collections.
+//
+// Data files are scratch space: durability is provided by the write-ahead log, which rebuilds every index on
+// startup, so the files left by a previous run are removed with Clean before any store is opened.
+package diskIndex
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/concurrentSkipList"
+)
+
+// DefaultCompactAt is the number of bytes of stale values a data file may hold before it is compacted
+const DefaultCompactAt = 4 << 20
+
+// instances numbers the stores opened by this process, so that stores opened at the same path (e.g. a database
+// that is replaced while still in use) never share data files
+var instances atomic.Uint64
+
+// view is the part of an index the store needs to compact its data file and drop indices
+type view interface {
+	relocate(from *dataFile) // moves every value held in from to the active data file
+	drop()                   // discards every value held by the index
+}
+
+// dataFile is one generation of a store's data file
+type dataFile struct {
+	f *os.File // the open file
+
+	size int64 // the number of bytes written to the file; guarded by the store's mutex
+
+	garbage atomic.Int64 // the number of bytes held by values that were overwritten or removed
+
+	pending atomic.Int64 // the number of values appended to the file whose location is not yet published
+}
+
+// location identifies an encoded value within a data file
+type location struct {
+	file *dataFile // the file holding the value
+	off  int64     // the offset of the value in the file
+	n    int       // the length of the encoded value
+}
+
+// Store is a data file shared by a hierarchy of indices. It is safe for concurrent use.
+type Store struct {
+	mtx sync.Mutex // serializes appends and the switch to a new data file
+
+	path string // the path of the data file; each generation is stored at path.I.N, I being the store's instance
+
+	instance uint64 // the instance number of the store
+
+	active *dataFile // the data file new values are appended to
+
+	gen int // the generation of the active data file
+
+	compactAt int64 // the number of bytes of stale values that triggers compaction
+
+	compacting atomic.Bool // set while the store is being compacted
+
+	indices *concurrentSkipList.Skiplist[string, view] // every live index, keyed by "/" + its prefix
+
+	onDrop func(prefix string) // called with the prefix of every index dropped
+}
+
+// Clean removes every data file held in dir, creating the directory if needed. It must be called before any store
+// is opened in dir.
+// Returns an error if the directory could not be created or emptied
+func Clean(dir string) error {
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("unable to remove stale index files: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("unable to create index directory: %w", err)
+	}
+	return nil
+}
+
+// Open creates a store whose data file lives at path. compactAt is the number of bytes of stale values tolerated
+// before the file is compacted.
+// Returns the store, or an error if the data file could not be created
+func Open(path string, compactAt int64) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("unable to create index directory: %w", err)
+	}
+	s := &Store{
+		path:      path,
+		instance:  instances.Add(1),
+		compactAt: compactAt,
+		indices:   concurrentSkipList.NewSL[string, view](string(rune(0)), string(rune(127))),
+	}
+	var err error
+	if s.active, err = s.newFile(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// OnDrop registers f to be called with the prefix of every index dropped from the store, so that state kept
+// alongside an index can be released with it. It must be called before the store is used.
+func (s *Store) OnDrop(f func(prefix string)) {
+	s.onDrop = f
+}
+
+// newFile creates the data file of the next generation
+func (s *Store) newFile() (*dataFile, error) {
+	s.gen++
+	f, err := os.OpenFile(fmt.Sprintf("%s.%d.%d", s.path, s.instance, s.gen), os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create data file: %w", err)
+	}
+	return &dataFile{f: f}, nil
+}
+
+// append writes an encoded value to the active data file. The location returned is pending until published is
+// called with it, which must happen once the location is reachable from its index.
+// Returns the location of the value
+func (s *Store) append(b []byte) (*location, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	file := s.active
+	if _, err := file.f.WriteAt(b, file.size); err != nil {
+		return nil, err
+	}
+	loc := &location{file: file, off: file.size, n: len(b)}
+	file.size += int64(len(b))
+	file.pending.Add(1)
+	return loc, nil
+}
+
+// published marks a location returned by append as reachable from its index
+func (s *Store) published(loc *location) {
+	loc.file.pending.Add(-1)
+}
+
+// read returns the encoded value held at loc. The error wraps os.ErrClosed if the file holding it was compacted away.
+func (s *Store) read(loc *location) ([]byte, error) {
+	b := make([]byte, loc.n)
+	if _, err := loc.file.f.ReadAt(b, loc.off); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// discard marks the value at loc as stale, compacting the data file in the background once enough stale values
+// have accumulated
+func (s *Store) discard(loc *location) {
+	garbage := loc.file.garbage.Add(int64(loc.n))
+	s.mtx.Lock()
+	active := s.active
+	size := active.size
+	s.mtx.Unlock()
+	if loc.file == active && garbage >= s.compactAt && 2*garbage >= size && !s.compacting.Load() {
+		go func() {
+			if err := s.Compact(); err != nil {
+				slog.Error("Unable to compact index data file", "path", s.path, "error", err)
+			}
+		}()
+	}
+}
+
+// Compact rewrites every live value into a new data file and deletes the old one. Reads and writes may proceed
+// while the store is compacted.
+// Returns an error if the new data file could not be created
+func (s *Store) Compact() error {
+	if !s.compacting.CompareAndSwap(false, true) {
+		return nil
+	}
+	defer s.compacting.Store(false)
+
+	s.mtx.Lock()
+	old := s.active
+	next, err := s.newFile()
+	if err != nil {
+		s.mtx.Unlock()
+		return err
+	}
+	s.active = next
+	s.mtx.Unlock()
+
+	//values appended to the old file just before the switch may not be reachable yet
+	for old.pending.Load() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	views, err := s.indices.Query(context.Background(), string(rune(0)), string(rune(127)))
+	if err != nil {
+		return err
+	}
+	for _, v := range views {
+		v.Value.relocate(old)
+	}
+	name := old.f.Name()
+	old.f.Close()
+	os.Remove(name)
+	slog.Debug("Compacted index data file", "path", s.path, "reclaimed", old.garbage.Load())
+	return nil
+}
+
+// dropBeneath drops every index whose prefix starts with prefix
+func (s *Store) dropBeneath(prefix string) {
+	lo := "/" + prefix
+	views, _ := s.indices.Query(context.Background(), lo, lo+string(rune(127)))
+	for _, v := range views {
+		if removed, ok := s.indices.Remove(v.Key); ok {
+			removed.drop()
+			if s.onDrop != nil {
+				s.onDrop(v.Key[1:])
+			}
+		}
+	}
+}
+
+// Close closes and deletes the data file
+func (s *Store) Close() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	name := s.active.f.Name()
+	if err := s.active.f.Close(); err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
@@ -0,0 +1,49 @@
+package document
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// DocumentCodec converts documents to and from their serialized form, for indices that do not keep documents in
+// memory. Only a document's contents, metadata and history are stored; its collections are expected to live in their own
+// indices, recovered through the document's DocumentIndexFactory when it is decoded.
+type DocumentCodec struct {
+	New func(docpath string) *Document // creates an empty document at docpath with all of its dependencies
+}
+
+// Encode returns the serialized form of doc
+func (c DocumentCodec) Encode(doc *Document) ([]byte, error) {
+	return doc.storedSerial(), nil
+}
+
+// Decode rebuilds the document named key from its serialized form b
+func (c DocumentCodec) Decode(key string, b []byte) (*Document, error) {
+	var sd serialDoc
+	if err := json.Unmarshal(b, &sd); err != nil {
+		return nil, fmt.Errorf("malformed serialized document: %w", err)
+	}
+	doc := c.New(strings.TrimPrefix(sd.Path, "/"))
+	if err := doc.Restore(b); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// CollectionCodec converts the collections of the document at DocPath to and from their stored form. Only a
+// collection's name is stored; its documents and subscribers are recovered through Factory when it is decoded.
+type CollectionCodec struct {
+	DocPath string            // the path of the document owning the collections
+	Factory CollectionFactory // returns the collection at a given path
+}
+
+// Encode returns the stored form of col
+func (c CollectionCodec) Encode(col *Collection) ([]byte, error) {
+	return json.Marshal(col.Name)
+}
+
+// Decode rebuilds the collection named key
+func (c CollectionCodec) Decode(key string, b []byte) (*Collection, error) {
+	return c.Factory(c.DocPath + "/" + key), nil
+}
@@ -3,7 +3,10 @@ package document
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/fieldIndex"
 	"github.com/RICE-COMP318-FALL24/owldb-p1group24/index_utils"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/query"
 	"net/http"
 )
 
@@ -14,42 +17,104 @@ type CollectionIndex[K string, V *Document] interface {
 	Query(ctx context.Context, low K, hi K) ([]index_utils.Pair[K, V], error)
 	Upsert(key K, check index_utils.UpdateCheck[K, V]) (updated bool, err error)
 	Remove(key K) (removedVal V, removed bool)
+	RemoveIf(key K, check index_utils.RemoveCheck[K, V]) (removedVal V, removed bool, err error)
 }
 
 // ColSubscriptionManager is responsible for managing the subscriptions of a given collection. We inject it's
 // implementation in main, so for now our Collection delegates to this interface
 type ColSubscriptionManager interface {
-	AddSubscriber(lo string, hi string) (subChan *chan []byte, id string) // Adds a subscriber
-	Remove(id string)                                                     // Removes a subscriber
-	Notify(docname string, evType string, payload []byte)                 // Notifies a subscriber of a change
+	AddSubscriber(lo string, hi string, filter func(serial []byte) bool, lastEventID string, snapshot [][]byte) (subChan *chan []byte, id string, events [][]byte) // Adds a subscriber to the documents satisfying filter, if not nil, returning the events it starts with
+	Remove(id string)                                                                                                                                              // Removes a subscriber
+	Notify(docname string, evType string, payload []byte)                                                                                                          // Notifies a subscriber of a change
 	NotifyAll(colname string)
 	GenerateEvent(evtype string, payload []byte) []byte // Notifies all subscribers of a change
 }
 
+// ColFieldIndexer keeps the secondary indices of a collection, which map the values of a document field to the names
+// of the documents holding them. We inject it's implementation in main, so for now our Collection delegates to this interface
+type ColFieldIndexer interface {
+	Create(def fieldIndex.Definition) (created bool, err error)                         // Adds an index; the documents already held must then be passed to Update
+	Drop(field string) (dropped bool)                                                   // Removes the index on a field
+	Definitions() []fieldIndex.Definition                                               // Describes every index
+	Update(docname string, oldDoc []byte, newDoc []byte) error                          // Reindexes a document; nil stands for a missing document. Fails with fieldIndex.ErrDuplicate on a unique field
+	Reindex(docname string, oldDoc []byte, newDoc []byte)                               // Reindexes a document without enforcing unique fields
+	Lookup(ctx context.Context, filter *query.Filter) (docnames []string, indexed bool) // Returns the only documents that may satisfy a filter
+}
+
 // Collection encapsulates the functionalities associated with Collections
 // We consider collections to be an internal property of documents
 type Collection struct {
 	Name                string                             //the name of the collection
 	Docs                CollectionIndex[string, *Document] //the indices to other documents in the collection
 	SubscriptionManager ColSubscriptionManager             //manages the subscriptions for a collection
+	Indexes             ColFieldIndexer                    //the secondary indices over the documents of the collection
 }
 
-// CSerialize serializes the documents of a collection whose name lies in the range [lo,hi].
+// CSerialize serializes the documents of a collection whose name lies in the range [lo,hi] and that are kept by opts.
 // Returns a serialized representation of the collection, and a status code
-func (c *Collection) CSerialize(ctx context.Context, lo string, hi string) ([]byte, int) {
-	res, err := c.Docs.Query(ctx, lo, hi)
+func (c *Collection) CSerialize(ctx context.Context, lo string, hi string, opts query.Options) ([]byte, int) {
+	if names, indexed := c.Indexes.Lookup(ctx, opts.Filter); indexed {
+		return c.serializeNames(names, opts.Low(lo), hi, opts), http.StatusOK
+	}
+	res, err := c.Docs.Query(ctx, opts.Low(lo), hi)
 	if err != nil {
 		errmsg, _ := json.Marshal(err.Error())
 		return errmsg, http.StatusBadRequest
 	}
-	resArr := []json.RawMessage{}
+	page := opts.NewPage()
+	for _, pair := range res {
+		if page.Add(pair.Key, pair.Value.GetSerial()) {
+			break
+		}
+	}
+	return page.Marshal(), http.StatusOK
+}
+
+// serializeNames serializes the documents named by names, in increasing order, whose name lies in the range [lo,hi]
+// and that are kept by opts
+func (c *Collection) serializeNames(names []string, lo string, hi string, opts query.Options) []byte {
+	page := opts.NewPage()
+	for _, name := range names {
+		if name < lo || name > hi {
+			continue
+		}
+		doc, found := c.Docs.Find(name)
+		if found && page.Add(name, doc.GetSerial()) {
+			break
+		}
+	}
+	return page.Marshal()
+}
+
+// AddIndex creates the index described by def over the documents of the collection, indexing those it already holds.
+// Returns whether the index was created, false if the field was already indexed, or an error if def is malformed or
+// is unique while two documents share a value (the index is then dropped)
+func (c *Collection) AddIndex(ctx context.Context, def fieldIndex.Definition) (bool, error) {
+	created, err := c.Indexes.Create(def)
+	if err != nil || !created {
+		return created, err
+	}
+	res, err := c.Docs.Query(ctx, string(rune(0)), string(rune(127)))
+	if err != nil {
+		c.Indexes.Drop(def.Field)
+		return false, err
+	}
 	for _, pair := range res {
-		bytes := pair.Value.GetSerial()
-		var rj json.RawMessage = bytes
-		resArr = append(resArr, rj)
+		//indexing under the document's lock, so that no concurrent write is indexed out of order
+		var dupErr error
+		c.Docs.Upsert(pair.Key, func(key string, curVal *Document, exists bool) (*Document, error) {
+			if !exists {
+				return nil, fmt.Errorf("document does not exist")
+			}
+			dupErr = c.Indexes.Update(key, nil, curVal.Info.Doc)
+			return curVal, dupErr
+		})
+		if dupErr != nil {
+			c.Indexes.Drop(def.Field)
+			return false, dupErr
+		}
 	}
-	resSerial, _ := json.Marshal(resArr)
-	return resSerial, http.StatusOK
+	return true, nil
 }
 
 // Creates a new collection
@@ -4,8 +4,13 @@ package document
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/fieldIndex"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/history"
 	"github.com/RICE-COMP318-FALL24/owldb-p1group24/jsondata"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/precondition"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/query"
 	"log/slog"
 	"net/http"
 	"strings"
@@ -46,7 +51,8 @@ type SubscriptionManagerFactory func() SubscriptionManager
 
 // AddChildDocument adds a child document at path docpath.
 // payload: a raw
-func (d *Document) AddChildDocument(docpath string, payload []byte, docname string, user string, overwrite bool, isPost bool, dbName string) ([]byte, int, string) {
+// cond: the conditions of the request, checked against the document being replaced, if any
+func (d *Document) AddChildDocument(docpath string, payload []byte, docname string, user string, overwrite bool, isPost bool, dbName string, cond precondition.Conditions) ([]byte, int, string) {
 
 	splitPath := strings.Split(docpath, "/")
 	parentColName := splitPath[len(splitPath)-2]
@@ -63,21 +69,44 @@ func (d *Document) AddChildDocument(docpath string, payload []byte, docname stri
 		return errmsg, http.StatusNotFound, ""
 	}
 
-	newDoc := New(payload, user, docpath, d.docCollectionFactory, d.collectionFactory, d.smFactory, d.validator, d.patcher, d.messager)
+	newDoc := d.newChild(payload, user, docpath)
 	var didOverwrite bool
+	var journalErr error
 	check := func(key string, curVal *Document, exists bool) (newVal *Document, err error) {
-
+		var version int64
+		if exists {
+			version = curVal.Version()
+		}
+		if err := cond.Check(exists, version); err != nil {
+			return curVal, err
+		}
 		if exists && !overwrite {
 			return nil, fmt.Errorf("document already exists")
 		}
 		if exists && overwrite {
+			if err := parentCol.Indexes.Update(key, curVal.Info.Doc, payload); err != nil {
+				return curVal, err
+			}
 			didOverwrite = true
+			old := curVal.Info
 			curVal.UpdateDoc(payload, user)
+			if journalErr = d.journal.RecordPutDoc(dbName, docpath, curVal.GetSerial()); journalErr != nil {
+				curVal.Info = old
+				parentCol.Indexes.Reindex(key, payload, old.Doc)
+				return curVal, journalErr
+			}
 			slog.Debug("ABOUT TO NOTIFY ABOUT A PUT OVERWRITE")
 			curVal.messager.NotifyDocs(dbName+"/"+docpath, "update", curVal.GetSerial())
 			parentCol.SubscriptionManager.Notify(docname, "update", curVal.GetSerial())
 			return curVal, nil
 		}
+		if err := parentCol.Indexes.Update(key, nil, payload); err != nil {
+			return nil, err
+		}
+		if journalErr = d.journal.RecordPutDoc(dbName, docpath, newDoc.GetSerial()); journalErr != nil {
+			parentCol.Indexes.Reindex(key, payload, nil)
+			return nil, journalErr
+		}
 		if isPost {
 			parentCol.SubscriptionManager.Notify(docname, "update", newDoc.GetSerial())
 			return newDoc, nil
@@ -91,7 +120,13 @@ func (d *Document) AddChildDocument(docpath string, payload []byte, docname stri
 	//attempting to upsert
 	_, err := parentCol.Docs.Upsert(docname, check)
 
-	if err != nil {
+	if journalErr != nil {
+		errmsg, stat := journalFailure(journalErr)
+		return errmsg, stat, ""
+	} else if errors.Is(err, fieldIndex.ErrDuplicate) {
+		errmsg, _ := json.Marshal(err.Error())
+		return errmsg, http.StatusConflict, ""
+	} else if err != nil {
 		errmsg, _ := json.Marshal(err.Error())
 		return errmsg, http.StatusPreconditionFailed, ""
 	}
@@ -104,9 +139,9 @@ func (d *Document) AddChildDocument(docpath string, payload []byte, docname stri
 
 }
 
-// AddChildCollection adds an empty collection to the document at resourcepath colpath.
+// AddChildCollection adds an empty collection, on behalf of user, to the document at resourcepath colpath.
 // Returns a JSON-encoded response object and a status code
-func (d *Document) AddChildCollection(colpath string, dbName string) ([]byte, int, string) {
+func (d *Document) AddChildCollection(colpath string, dbName string, user string) ([]byte, int, string) {
 	slog.Debug(fmt.Sprintf("Adding a child collection with path %s", colpath))
 	colpath = strings.TrimSuffix(colpath, "/")
 	newSplitPath := strings.Split(colpath, "/")
@@ -116,35 +151,45 @@ func (d *Document) AddChildCollection(colpath string, dbName string) ([]byte, in
 		errmsg, _ := json.Marshal("Collection does not exist")
 		return errmsg, http.StatusNotFound, ""
 	}
-	newCol := d.collectionFactory(childColName)
+	newCol := d.collectionFactory(colpath)
+	var journalErr error
 	check := func(key string, curVal *Collection, exists bool) (newVal *Collection, err error) {
 		if exists {
 			return nil, fmt.Errorf("Collection Already Exists")
 		}
+		if journalErr = d.journal.RecordPutCol(dbName, colpath, user); journalErr != nil {
+			return nil, journalErr
+		}
 		return newCol, nil
 
 	}
 	_, err := parentDoc.collections.Upsert(childColName, check)
-	if err != nil {
+	if journalErr != nil {
+		errmsg, stat := journalFailure(journalErr)
+		return errmsg, stat, ""
+	} else if err != nil {
 		errmsg, _ := json.Marshal(err.Error())
 		return errmsg, http.StatusBadRequest, ""
 	}
 	resp, uri := generatePutResponse(colpath+"/", dbName)
+	b, _ := json.Marshal("/" + colpath + "/")
+	d.messager.NotifyDocs(dbName+"/"+colpath+"/", "update", b) //tells the subscribers to the subtrees holding it
 
 	return resp, http.StatusCreated, uri
 }
 
 // GetChildDocument retrieves a document contained in another document located at the forward-slash-delimited path.
+// A subscriber resuming a subscription passes the ID of the last event it received as lastEventID.
 // returns a JSON-encoded version of the document, and a status code
-func (d *Document) GetChildDocument(docpath string, isSubscribe bool, dbName string) ([]byte, int, string, *chan []byte, []byte) {
+func (d *Document) GetChildDocument(docpath string, isSubscribe bool, dbName string, lastEventID string) ([]byte, int, string, *chan []byte, []byte) {
 
 	splitPath := strings.Split(docpath, "/")
 
 	if len(splitPath) == 0 { //returning itself
 
 		if isSubscribe {
-			subChan, subId := d.messager.AddDocSubscriber(dbName + "/" + docpath)
-			return d.GetSerial(), http.StatusOK, subId, subChan, d.sm.GenerateEvent("update", d.GetSerial())
+			subChan, subId, events := d.messager.AddDocSubscriber(dbName+"/"+docpath, lastEventID, d.GetSerial())
+			return d.GetSerial(), http.StatusOK, subId, subChan, events
 		}
 
 		return d.GetSerial(), http.StatusOK, "", nil, nil
@@ -160,8 +205,8 @@ func (d *Document) GetChildDocument(docpath string, isSubscribe bool, dbName str
 
 	if isSubscribe {
 
-		ch, subId := resDoc.messager.AddDocSubscriber(dbName + "/" + docpath)
-		return payload, http.StatusOK, subId, ch, resDoc.sm.GenerateEvent("update", payload)
+		ch, subId, events := resDoc.messager.AddDocSubscriber(dbName+"/"+docpath, lastEventID, payload)
+		return payload, http.StatusOK, subId, ch, events
 	}
 
 	return payload, http.StatusOK, "", nil, nil
@@ -170,8 +215,9 @@ func (d *Document) GetChildDocument(docpath string, isSubscribe bool, dbName str
 
 // GetChildCollection gets a child collection belonging to the document d (or one of it's descendant documents). If
 // this request is part of a subscription request, a channel and unique identifier will also be returned, and this
-// will be used to provide future updates to the client
-func (d *Document) GetChildCollection(colpath string, lo string, hi string, isSubscribe bool) ([]byte, int, *chan []byte, string, [][]byte) {
+// will be used to provide future updates to the client, starting from the ID of the last event it received,
+// lastEventID, if it is resuming a subscription
+func (d *Document) GetChildCollection(colpath string, lo string, hi string, isSubscribe bool, opts query.Options, lastEventID string) ([]byte, int, *chan []byte, string, [][]byte) {
 	colpath = strings.TrimSuffix(colpath, "/")
 	newSplitPath := strings.Split(colpath, "/")
 	childColName := newSplitPath[len(newSplitPath)-1]
@@ -187,26 +233,26 @@ func (d *Document) GetChildCollection(colpath string, lo string, hi string, isSu
 	}
 	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(10*time.Second))
 	defer cancel()
-	payload, stat := childCol.CSerialize(ctx, lo, hi)
+	payload, stat := childCol.CSerialize(ctx, lo, hi, opts)
 	if isSubscribe {
 		slog.Debug("Getting a new channel for this subscription request")
-		subChan, subId := childCol.SubscriptionManager.AddSubscriber(lo, hi)
 		ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(15*time.Second))
 		defer cancel()
 		docs, _ := childCol.Docs.Query(ctx, lo, hi)
 		docBytes := make([][]byte, 0)
 		for _, v := range docs {
-			event := childCol.SubscriptionManager.GenerateEvent("update", v.Value.GetSerial())
-			docBytes = append(docBytes, event)
+			docBytes = append(docBytes, v.Value.GetSerial())
 		}
-		return payload, stat, subChan, subId, docBytes
+		subChan, subId, events := childCol.SubscriptionManager.AddSubscriber(lo, hi, opts.Matcher(), lastEventID, docBytes)
+		return payload, stat, subChan, subId, events
 	}
 	return payload, stat, nil, "", nil
 }
 
-// DeleteChildDocument deletes a child document of the parent document d
+// DeleteChildDocument deletes a child document of the parent document d on behalf of user, provided it satisfies the
+// conditions cond
 // Returns a response (if an error occurred) and a status code
-func (d *Document) DeleteChildDocument(docpath string, dbName string) ([]byte, int) {
+func (d *Document) DeleteChildDocument(docpath string, dbName string, user string, cond precondition.Conditions) ([]byte, int) {
 
 	splitPath := strings.Split(docpath, "/")
 
@@ -229,8 +275,26 @@ func (d *Document) DeleteChildDocument(docpath string, dbName string) ([]byte, i
 		errmsg, _ := json.Marshal("Document does not exist")
 		return errmsg, http.StatusNotFound
 	}
-	removedDoc, removed := parentCol.Docs.Remove(victimName)
+	var journalErr error
+	removedDoc, removed, err := parentCol.Docs.RemoveIf(victimName, func(key string, curVal *Document) error {
+		if err := cond.Check(true, curVal.Version()); err != nil {
+			return err
+		}
+		//journaled and unindexed while the document is locked, so that a write racing the deletion is logged in the
+		//same order and cannot have its index entries removed
+		if journalErr = d.journal.RecordDeleteDoc(dbName, docpath, user); journalErr != nil {
+			return journalErr
+		}
+		parentCol.Indexes.Reindex(key, curVal.Info.Doc, nil)
+		return nil
+	})
 
+	if journalErr != nil {
+		return journalFailure(journalErr)
+	} else if err != nil {
+		errmsg, _ := json.Marshal(err.Error())
+		return errmsg, http.StatusPreconditionFailed
+	}
 	if !removed {
 		errmsg, _ := json.Marshal("Document does not exist")
 		return errmsg, http.StatusNotFound
@@ -244,9 +308,9 @@ func (d *Document) DeleteChildDocument(docpath string, dbName string) ([]byte, i
 
 }
 
-// DeleteChildCollection deletes a collection within the document
+// DeleteChildCollection deletes a collection within the document on behalf of user
 // Returns a response (if an error occurred) and a status code
-func (d *Document) DeleteChildCollection(colpath string) ([]byte, int) {
+func (d *Document) DeleteChildCollection(colpath string, dbName string, user string) ([]byte, int) {
 	newPath := colpath
 	newPath = strings.TrimSuffix(newPath, "/")
 	newSplitPath := strings.Split(newPath, "/")
@@ -256,15 +320,22 @@ func (d *Document) DeleteChildCollection(colpath string) ([]byte, int) {
 		errmsg, _ := json.Marshal("Owning document does not exist")
 		return errmsg, http.StatusNotFound
 	}
-	removedCol, removed := parentDoc.collections.Remove(childColName)
+	removedCol, removed, err := parentDoc.collections.RemoveIf(childColName, func(key string, curVal *Collection) error {
+		return d.journal.RecordDeleteCol(dbName, newPath, user)
+	})
 
-	if !removed { //the only way remove fails is if the collection doesn't exist, so...
+	if err != nil {
+		return journalFailure(err)
+	} else if !removed { //the only way remove fails is if the collection doesn't exist, so...
 		errmsg, _ := json.Marshal("Collection does not exist")
 		return errmsg, http.StatusNotFound
 	}
 	slog.Debug("Notifiying subscribers that this collection is deleted")
-	b, _ := json.Marshal("/" + colpath)
-	removedCol.SubscriptionManager.NotifyAll(string(b))
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(10*time.Second))
+	defer cancel()
+	removedCol.close(ctx, dbName, newPath)
+	b, _ := json.Marshal("/" + newPath + "/")
+	d.messager.NotifyDocs(dbName+"/"+newPath+"/", "delete", b) //ends the subscriptions to the documents beneath
 	return nil, http.StatusNoContent
 }
 
@@ -280,6 +351,19 @@ func generatePutResponse(uri string, dbName string) ([]byte, string) {
 	return b, respJson.Uri
 }
 
+// journalFailure builds the response to a request whose change was not applied, since the journal failed to record it
+// with err.
+// Returns a JSON-encoded error and a status code
+func journalFailure(err error) ([]byte, int) {
+	errmsg, _ := json.Marshal("unable to persist the change: " + err.Error())
+	return errmsg, http.StatusInternalServerError
+}
+
+// newChild creates a new document at path docpath sharing all of d's dependencies
+func (d *Document) newChild(payload []byte, user string, docpath string) *Document {
+	return New(payload, user, docpath, d.docCollectionFactory, d.collectionFactory, d.smFactory, d.validator, d.patcher, d.messager, d.journal)
+}
+
 // traverseDocuments is helper method used to navigate from a parent document to it's descendant documents
 // It returns the document at the end of the path, and a boolean indicating whether the document was found
 func (d *Document) traverseDocuments(splitPath []string) (*Document, bool) {
@@ -314,7 +398,7 @@ func (d *Document) traverseDocument(colName string, docName string) (*Document,
 
 // ApplyPatchDocument applies a JSON patch to the document at the specified path
 // Returns a JSON-encoded response object and a status code
-func (d *Document) ApplyPatchDocument(dbName string, docPath string, patch []byte, user string) ([]byte, int) {
+func (d *Document) ApplyPatchDocument(dbName string, docPath string, patch []byte, user string, mediaType string, cond precondition.Conditions) ([]byte, int) {
 	// Step 1: Split docPath into path segments and clean it
 	splitPath := strings.Split(docPath, "/")
 
@@ -339,11 +423,15 @@ func (d *Document) ApplyPatchDocument(dbName string, docPath string, patch []byt
 
 	var nullDoc *Document
 	var newDocPayload []byte
+	var journalErr error
 	chk := func(docName string, curDoc *Document, exists bool) (newDoc *Document, err error) {
 		if !exists { //can't update something that doesn't exist
 			return nullDoc, fmt.Errorf("document does not exist")
 		}
-		newRaw, patchErr := curDoc.DoPatch(patch)
+		if err := cond.Check(true, curDoc.Version()); err != nil {
+			return curDoc, err
+		}
+		newRaw, patchErr := curDoc.DoPatch(patch, mediaType)
 		if patchErr != nil { //DO NOT UPDATE
 			return curDoc, patchErr
 		}
@@ -351,9 +439,18 @@ func (d *Document) ApplyPatchDocument(dbName string, docPath string, patch []byt
 		if err != nil {
 			return curDoc, err
 		}
+		if err = parentCol.Indexes.Update(docName, curDoc.Info.Doc, newRaw); err != nil {
+			return curDoc, err
+		}
+		old := curDoc.Info
 		curDoc.UpdateDoc(newRaw, user)
 
 		newDocPayload = curDoc.GetSerial()
+		if journalErr = d.journal.RecordPutDoc(dbName, docPath, newDocPayload); journalErr != nil {
+			curDoc.Info = old
+			parentCol.Indexes.Reindex(docName, newRaw, old.Doc)
+			return curDoc, journalErr
+		}
 		//Notifying subscribers
 		parentCol.SubscriptionManager.Notify(docName, "update", newDocPayload)
 		curDoc.messager.NotifyDocs(dbName+"/"+docPath, "update", newDocPayload)
@@ -363,12 +460,20 @@ func (d *Document) ApplyPatchDocument(dbName string, docPath string, patch []byt
 	msg := "patch applied"
 	if !updated {
 
-		if er.Error() == "document does not exist" {
+		if journalErr != nil {
+			return journalFailure(journalErr)
+		} else if er.Error() == "document does not exist" {
 			errmsg, _ := json.Marshal(er.Error())
 			return errmsg, http.StatusNotFound
 		} else if strings.HasPrefix(er.Error(), "bad patch operation") {
 			errmsg, _ := json.Marshal(er.Error())
 			return errmsg, http.StatusBadRequest
+		} else if errors.Is(er, fieldIndex.ErrDuplicate) {
+			errmsg, _ := json.Marshal(er.Error())
+			return errmsg, http.StatusConflict
+		} else if errors.Is(er, precondition.ErrFailed) {
+			errmsg, _ := json.Marshal(er.Error())
+			return errmsg, http.StatusPreconditionFailed
 		} else {
 			msg = er.Error()
 		}
@@ -384,19 +489,30 @@ func (d *Document) getRawBody() []byte {
 	return deepCopy
 }
 
-// DoPatch applies a JSON patch to the document
+// DoPatch applies a JSON patch, written in the dialect of mediaType, to the document
 // Returns the patched document as a JSON-encoded byte slice
-func (d *Document) DoPatch(patch []byte) (newRaw []byte, err error) {
+func (d *Document) DoPatch(patch []byte, mediaType string) (newRaw []byte, err error) {
 	oldRaw := d.getRawBody()
-	return d.patcher.DoPatch(oldRaw, patch)
+	return d.patcher.Apply(oldRaw, patch, mediaType)
 }
 
 // UpdateDoc mutates the documents contents (DO NOT CALL THIS WITHOUT SYNCHRONIZATION)
 func (d *Document) UpdateDoc(newRawDoc []byte, user string) {
+	d.Info.History = history.Append(d.Info.History, d.currentRevision())
 	d.Info.Doc = newRawDoc
 	d.Info.Meta.LastModifiedBy = user
 	d.Info.Meta.LastModifiedAt = time.Now().UnixMilli()
+	d.Info.Meta.Version++
+}
+
+// Body returns the contents of the document, without its metadata (DO NOT CALL THIS WITHOUT SYNCHRONIZATION)
+func (d *Document) Body() []byte {
+	return d.Info.Doc
+}
 
+// Version returns the version of the document, which is bumped by every write (DO NOT CALL THIS WITHOUT SYNCHRONIZATION)
+func (d *Document) Version() int64 {
+	return d.Info.Meta.Version
 }
 
 // generatePatchResponse generates a response for a patch operation
@@ -422,7 +538,12 @@ func generatePatchResponse(dbName string, docPath string, patchFailed bool, msg
 	return b
 }
 
-// Notify forwards the event notification to an internal subscription handler
+// Notify forwards the event notification to an internal subscription handler. A delete event also ends the
+// subscriptions to d and to every resource beneath it
 func (d *Document) Notify(uri string, payload []byte, evType string) {
 	d.messager.NotifyDocs(uri, evType, payload)
+	if evType == "delete" {
+		dbName, docpath, _ := strings.Cut(uri, "/")
+		d.closeCollections(dbName, docpath)
+	}
 }
@@ -3,24 +3,42 @@
 package document
 
 import (
+	"context"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/fieldIndex"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/history"
 	"github.com/RICE-COMP318-FALL24/owldb-p1group24/index_utils"
 	"time"
 )
 
 // DocumentIndex defines the interface for managing collections within a document.
-// It provides methods to find, upsert, and remove collections.
+// It provides methods to find, upsert, remove and query collections.
 type DocumentIndex[Idx string, Col *Collection] interface {
 	Find(key Idx) (foundVal Col, found bool)
 	Upsert(key Idx, check index_utils.UpdateCheck[Idx, Col]) (updated bool, err error)
 	Remove(key Idx) (removedVal Col, removed bool)
+	RemoveIf(key Idx, check index_utils.RemoveCheck[Idx, Col]) (removedVal Col, removed bool, err error)
+	Query(ctx context.Context, low Idx, hi Idx) ([]index_utils.Pair[Idx, Col], error)
 }
 
 // Messager defines the interface for managing subscriptions within a document.
 // It provides methods to notify documents and collections about updates
 type Messager interface {
-	AddDocSubscriber(uri string) (*chan []byte, string) //Adds a subscriber to the resource at doc
+	AddDocSubscriber(uri string, lastEventID string, snapshot []byte) (*chan []byte, string, []byte) //Adds a subscriber to the resource at doc, returning the events it starts with
 
-	NotifyDocs(uri string, evtype string, payload []byte)
+	NotifyDocs(uri string, evtype string, payload []byte) //Notifies the subscribers of the resource at uri, a collection if it ends with "/", and of the subtrees holding it; a delete event also ends the subscriptions to it and every resource beneath it
+	RemoveDocSubscriber(uri string, id string)            //Removes a subscriber from the resource at uri
+}
+
+// Journal records every successful mutation performed on documents and collections, so that they can be
+// replayed after a restart. A mutation the journal fails to record is not applied.
+type Journal interface {
+	RecordPutDoc(dbName string, docpath string, serial []byte) error  // records the new state of a document
+	RecordPutCol(dbName string, colpath string, user string) error    // records the creation of a collection by user
+	RecordDeleteDoc(dbName string, docpath string, user string) error // records the deletion of a document by user
+	RecordDeleteCol(dbName string, colpath string, user string) error // records the deletion of a collection by user
+
+	RecordPutIndex(dbName string, colpath string, def fieldIndex.Definition) error // records the creation of a secondary index
+	RecordDeleteIndex(dbName string, colpath string, field string) error           // records the deletion of a secondary index
 }
 
 // Validator defines an interface for validating documents.
@@ -28,24 +46,24 @@ type Validator interface {
 	Validate(b []byte) error
 }
 
-// DocumentIndexFactory DocumentIndex holds collections
-type DocumentIndexFactory[T DocumentIndex[string, *Collection]] func() T
+// DocumentIndexFactory DocumentIndex holds collections; it receives the path of the document owning the index
+type DocumentIndexFactory[T DocumentIndex[string, *Collection]] func(docpath string) T
 
-// CollectionFactory is a function type that creates a new collection
-type CollectionFactory func(string) *Collection
+// CollectionFactory is a function type that creates a new collection; it receives the path of the collection
+type CollectionFactory func(colpath string) *Collection
 
 // SubscriptionManager manages subscriptions for changes to documents.
 // It provides methods to add and remove subscribers and notify them of changes.
 type SubscriptionManager interface {
-	AddSubscriber() (ch *chan []byte, id string)        // Adds a new subscriber and returns a channel and subscriber ID.
-	RemoveSubscriber(id string)                         // Removes a subscriber by its ID.
-	Notify(evType string, payload []byte)               // Notifies subscribers of a document event (e.g., update or delete).
-	GenerateEvent(evType string, payload []byte) []byte // Generates an event message for a given event type and payload.
+	AddSubscriber(lastEventID string, snapshot [][]byte) (ch *chan []byte, id string, events [][]byte) // Adds a new subscriber and returns a channel, subscriber ID and the events it starts with.
+	RemoveSubscriber(id string)                                                                        // Removes a subscriber by its ID.
+	Notify(evType string, payload []byte)                                                              // Notifies subscribers of a document event (e.g., update or delete).
+	GenerateEvent(evType string, payload []byte) []byte                                                // Generates an event message for a given event type and payload.
 }
 
 // Patcher defines an interface for applying patches to documents.
 type Patcher interface {
-	DoPatch(oldDoc []byte, patches []byte) (newDoc []byte, err error) // DoPatch applies a patch to the old document and returns the new document.
+	Apply(oldDoc []byte, patches []byte, mediaType string) (newDoc []byte, err error) // Apply applies a patch, written in the dialect of mediaType, to the old document and returns the new document.
 }
 
 // Document defines the structure of documents within the database.
@@ -67,6 +85,8 @@ type Document struct {
 	patcher Patcher // Patcher for applying modifications to the document.
 
 	messager Messager //Messager for handling subscriptions
+
+	journal Journal //journal records every mutation performed on the document and its descendants
 }
 
 // metadata is a struct that holds information about the document
@@ -75,6 +95,7 @@ type metadata struct {
 	CreatedAt      int64  `json:"createdAt"`      //the time the document was created
 	LastModifiedBy string `json:"lastModifiedBy"` //the user who last modified the document
 	LastModifiedAt int64  `json:"lastModifiedAt"` //the time the document was last modified
+	Version        int64  `json:"version"`        //the number of times the document was written, starting at 1; its ETag
 }
 
 // docInfo contains the document's path, metadata, and the actual contents of the document.
@@ -82,6 +103,8 @@ type docInfo struct {
 	Path string   `json:"path"` // The document's path.
 	Meta metadata `json:"meta"` // Metadata associated with the document.
 	Doc  []byte   `json:"doc"`  // The document's actual contents in byte form.
+
+	History []history.Revision `json:"history,omitempty"` // The earlier versions of the document, oldest first.
 }
 
 // newMeta creates a new metadata object with the current timestamp and the provided user.
@@ -92,12 +115,13 @@ func newMeta(user string) metadata {
 		CreatedAt:      time.Now().UnixMilli(),
 		LastModifiedBy: user,
 		LastModifiedAt: time.Now().UnixMilli(),
+		Version:        1,
 	}
 }
 
 // New creates a new document object
 // It initializes the document with the provided payload, user, path, and dependencies.
-func New(payload []byte, user string, path string, dcf DocumentIndexFactory[DocumentIndex[string, *Collection]], ccf CollectionFactory, smfactory SubscriptionManagerFactory, v Validator, patcher Patcher, messager Messager) *Document {
+func New(payload []byte, user string, path string, dcf DocumentIndexFactory[DocumentIndex[string, *Collection]], ccf CollectionFactory, smfactory SubscriptionManagerFactory, v Validator, patcher Patcher, messager Messager, journal Journal) *Document {
 
 	return &Document{
 
@@ -105,7 +129,7 @@ func New(payload []byte, user string, path string, dcf DocumentIndexFactory[Docu
 
 		docCollectionFactory: dcf,
 
-		collections: dcf(),
+		collections: dcf(path),
 
 		sm:                smfactory(),
 		collectionFactory: ccf,
@@ -114,5 +138,6 @@ func New(payload []byte, user string, path string, dcf DocumentIndexFactory[Docu
 		patcher:   patcher,
 		validator: v,
 		messager:  messager,
+		journal:   journal,
 	}
 }
@@ -5,11 +5,19 @@ import (
 	"cmp"
 	"context"
 	"encoding/json"
+	"errors"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/fieldIndex"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/history"
 	"github.com/RICE-COMP318-FALL24/owldb-p1group24/index_utils"
 
 	"github.com/RICE-COMP318-FALL24/owldb-p1group24/jsondata"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/mocks"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/patcher"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/precondition"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/query"
 	"log/slog"
 	"net/http"
+	"strings"
 	"testing"
 )
 
@@ -41,6 +49,21 @@ func (m *mockSL[K, V]) Remove(key K) (foundValue V, found bool) {
 	return v, true
 }
 
+func (m *mockSL[K, V]) RemoveIf(key K, check index_utils.RemoveCheck[K, V]) (foundValue V, found bool, err error) {
+	v, ok := m.sl[key]
+	if !ok {
+		return v, false, nil
+	}
+	if check != nil {
+		if err := check(key, v); err != nil {
+			var nullV V
+			return nullV, false, err
+		}
+	}
+	delete(m.sl, key)
+	return v, true, nil
+}
+
 func (m *mockSL[K, V]) Query(ctx context.Context, low K, hi K) (result []index_utils.Pair[K, V], err error) {
 
 	res := make([]index_utils.Pair[K, V], 0)
@@ -78,11 +101,11 @@ func newMockSubscriptionManager() *mockSubscriptionManager {
 }
 
 // AddSubscriber implements the SubscriptionManager interface
-func (m *mockSubscriptionManager) AddSubscriber() (ch *chan []byte, id string) {
+func (m *mockSubscriptionManager) AddSubscriber(lastEventID string, snapshot [][]byte) (ch *chan []byte, id string, events [][]byte) {
 	ch = new(chan []byte)
 
 	id = "abc"
-	return ch, id
+	return ch, id, snapshot
 }
 
 // RemoveSubscriber implements the SubscriptionManager interface
@@ -122,9 +145,9 @@ type mockSubberstruct struct {
 	didGenerateEvent    bool
 }
 
-func (m *mockSubberstruct) AddSubscriber() (ch *chan []byte, id string) {
+func (m *mockSubberstruct) AddSubscriber(lastEventID string, snapshot [][]byte) (ch *chan []byte, id string, events [][]byte) {
 	m.didAddSubscriber = true
-	return nil, ""
+	return nil, "", nil
 }
 
 func (m *mockSubberstruct) RemoveSubscriber(id string) {
@@ -148,14 +171,14 @@ func (m mockValidator) Validate([]byte) error {
 }
 
 type ImockPatcher interface {
-	DoPatch(oldDoc []byte, patches []byte) (newDoc []byte, err error)
+	Apply(oldDoc []byte, patches []byte, mediaType string) (newDoc []byte, err error)
 }
 
 type mockPatcher struct {
 	ImockPatcher
 }
 
-func (m mockPatcher) DoPatch(oldDoc []byte, patches []byte) (newDoc []byte, err error) {
+func (m mockPatcher) Apply(oldDoc []byte, patches []byte, mediaType string) (newDoc []byte, err error) {
 	return []byte("newDoc"), nil
 }
 
@@ -167,10 +190,10 @@ type mockColSubManager struct {
 	generateEventCalled    bool
 }
 
-func (m *mockColSubManager) AddSubscriber(lo string, hi string) (subChan *chan []byte, id string) {
+func (m *mockColSubManager) AddSubscriber(lo string, hi string, filter func(serial []byte) bool, lastEventID string, snapshot [][]byte) (subChan *chan []byte, id string, events [][]byte) {
 
 	m.addSubscriberCalled = true
-	return nil, ""
+	return nil, "", nil
 }
 
 func (m *mockColSubManager) Remove(id string) {
@@ -196,22 +219,26 @@ func (m *mockColSubManager) GenerateEvent(evtype string, payload []byte) []byte
 type mockMessenger struct {
 }
 
-func (m mockMessenger) AddDocSubscriber(uri string) (*chan []byte, string) {
-	return nil, ""
+func (m mockMessenger) AddDocSubscriber(uri string, lastEventID string, snapshot []byte) (*chan []byte, string, []byte) {
+	return nil, "", nil
 }
 
 func (m mockMessenger) NotifyDocs(uri string, evtype string, payload []byte) {
 	return
 }
 
+func (m mockMessenger) RemoveDocSubscriber(uri string, id string) {
+	return
+}
+
 // Validate satisfies the Validator interface, returning mockError if provided.
 func mockDocument() *Document {
 	// Assume we have the following components created elsewhere in the code
 	newColFactory := func(colName string) *Collection {
-		return &Collection{Docs: &mockSL[string, *Document]{sl: make(map[string]*Document)}, Name: colName, SubscriptionManager: &mockColSubManager{}}
+		return &Collection{Docs: &mockSL[string, *Document]{sl: make(map[string]*Document)}, Name: colName, SubscriptionManager: &mockColSubManager{}, Indexes: fieldIndex.NewSet()}
 	}
 
-	docColFactory := func() DocumentIndex[string, *Collection] {
+	docColFactory := func(string) DocumentIndex[string, *Collection] {
 		newCollections := &mockSL[string, *Collection]{sl: make(map[string]*Collection)}
 		return newCollections
 	}
@@ -235,7 +262,7 @@ func mockDocument() *Document {
 
 	// Create the top-level document using the New signature
 
-	topDoc := New(b, "USER", "topDoc", docColFactory, newColFactory, subscriptionManagerFactory, mockValidator{}, mockPatcher{}, mockMessenger{})
+	topDoc := New(b, "USER", "topDoc", docColFactory, newColFactory, subscriptionManagerFactory, mockValidator{}, mockPatcher{}, mockMessenger{}, &mocks.MockJournal{})
 
 	return topDoc
 }
@@ -281,9 +308,9 @@ func TestDocument_GetSerial(t *testing.T) {
 func TestDocument_AddChildCollection(t *testing.T) {
 	mockdoc := mockDocument()
 
-	mockdoc.AddChildCollection("topDoc/col1", "mydb")
+	mockdoc.AddChildCollection("topDoc/col1", "mydb", "user")
 
-	_, stat2, _, _, _ := mockdoc.GetChildCollection("topDoc/col1", "a", "b", false)
+	_, stat2, _, _, _ := mockdoc.GetChildCollection("topDoc/col1", "a", "b", false, query.Options{}, "")
 
 	if stat2 != http.StatusOK {
 		t.Errorf("AddChildCollection Failed: expected status 200, got %d", stat2)
@@ -293,9 +320,9 @@ func TestDocument_AddChildCollection(t *testing.T) {
 
 func TestDocument_AddDuplicateCollection(t *testing.T) {
 	mockdoc := mockDocument()
-	mockdoc.AddChildCollection("col1", "mydb")
+	mockdoc.AddChildCollection("col1", "mydb", "user")
 
-	_, stat2, _ := mockdoc.AddChildCollection("col1", "mydb")
+	_, stat2, _ := mockdoc.AddChildCollection("col1", "mydb", "user")
 
 	if stat2 != http.StatusBadRequest {
 		t.Errorf("AddDuplicateCollection Failed: expected status code 200,got %d", stat2)
@@ -304,14 +331,14 @@ func TestDocument_AddDuplicateCollection(t *testing.T) {
 
 func TestDocument_AddChildDocumentPutOverwrite(t *testing.T) {
 	mockdoc := mockDocument()
-	mockdoc.AddChildCollection("topDoc/col1", "mydb")
+	mockdoc.AddChildCollection("topDoc/col1", "mydb", "user")
 
-	_, stat, _ := mockdoc.AddChildDocument("topDoc/col1/doc2", mockPayload(), "doc2", "USER", true, false, "mydb")
+	_, stat, _ := mockdoc.AddChildDocument("topDoc/col1/doc2", mockPayload(), "doc2", "USER", true, false, "mydb", precondition.Conditions{})
 
 	if stat != http.StatusCreated {
 		t.Errorf("AddChildDocumentPutOverwrite failed, expected status code 201,got %d", stat)
 	}
-	_, stat, uri := mockdoc.AddChildDocument("topDoc/col1/doc2", mockPayload(), "doc2", "user", true, false, "mydb")
+	_, stat, uri := mockdoc.AddChildDocument("topDoc/col1/doc2", mockPayload(), "doc2", "user", true, false, "mydb", precondition.Conditions{})
 
 	if stat != http.StatusOK && uri != "/v1/mydb/topDoc/col1/doc2" {
 		t.Errorf("TestDocument_AddChildDocumentPutOverwrite Failed, got stat,uri %d %s", stat, uri)
@@ -320,10 +347,10 @@ func TestDocument_AddChildDocumentPutOverwrite(t *testing.T) {
 
 func TestDocument_AddChildDocumentPutNoOverwrite(t *testing.T) {
 	mockdoc := mockDocument()
-	mockdoc.AddChildCollection("topDoc/col1", "mydb")
-	mockdoc.AddChildDocument("topDoc/col1/doc2", mockPayload(), "doc2", "USER", false, false, "mydb")
+	mockdoc.AddChildCollection("topDoc/col1", "mydb", "user")
+	mockdoc.AddChildDocument("topDoc/col1/doc2", mockPayload(), "doc2", "USER", false, false, "mydb", precondition.Conditions{})
 
-	_, stat, _ := mockdoc.AddChildDocument("topDoc/col1/doc2", mockPayload(), "doc2", "USER", false, false, "mydb")
+	_, stat, _ := mockdoc.AddChildDocument("topDoc/col1/doc2", mockPayload(), "doc2", "USER", false, false, "mydb", precondition.Conditions{})
 
 	if stat != http.StatusPreconditionFailed {
 		t.Errorf("Error: expected status code 412,got %d", stat)
@@ -332,29 +359,29 @@ func TestDocument_AddChildDocumentPutNoOverwrite(t *testing.T) {
 
 func TestDocument_AddChildDocumentPost(t *testing.T) {
 	mockdoc := mockDocument()
-	mockdoc.AddChildCollection("topDoc/col1", "mydb")
-	mockdoc.AddChildDocument("topDoc/col1/doc2", mockPayload(), "doc2", "USER", false, false, "mydb")
+	mockdoc.AddChildCollection("topDoc/col1", "mydb", "user")
+	mockdoc.AddChildDocument("topDoc/col1/doc2", mockPayload(), "doc2", "USER", false, false, "mydb", precondition.Conditions{})
 }
 
 func TestDocument_AddMultipleChildCollections(t *testing.T) {
 	mockdoc := mockDocument()
 
-	mockdoc.AddChildCollection("topDoc/col1", "mydb")
-	mockdoc.AddChildCollection("topDoc/col2", "mydb")
-	mockdoc.AddChildCollection("topDoc/col3", "mydb")
+	mockdoc.AddChildCollection("topDoc/col1", "mydb", "user")
+	mockdoc.AddChildCollection("topDoc/col2", "mydb", "user")
+	mockdoc.AddChildCollection("topDoc/col3", "mydb", "user")
 
-	_, stat, _, _, _ := mockdoc.GetChildCollection("topDoc/col1", "a", "b", false)
+	_, stat, _, _, _ := mockdoc.GetChildCollection("topDoc/col1", "a", "b", false, query.Options{}, "")
 
 	if stat != http.StatusOK {
 		t.Errorf("AddMultipleChildCollections failed, expected 200 and got %d", stat)
 	}
-	_, stat, _, _, _ = mockdoc.GetChildCollection("topDoc/col2", "a", "b", false)
+	_, stat, _, _, _ = mockdoc.GetChildCollection("topDoc/col2", "a", "b", false, query.Options{}, "")
 
 	if stat != http.StatusOK {
 		t.Errorf("AddMultipleChildCollections failed, expected 200 and got %d", stat)
 	}
 
-	_, stat, _, _, _ = mockdoc.GetChildCollection("topDoc/col3", "a", "b", false)
+	_, stat, _, _, _ = mockdoc.GetChildCollection("topDoc/col3", "a", "b", false, query.Options{}, "")
 	if stat != http.StatusOK {
 		t.Errorf("AddMultipleChildCollections failed, expected 200 and got %d", stat)
 	}
@@ -363,7 +390,7 @@ func TestDocument_AddMultipleChildCollections(t *testing.T) {
 func TestDocument_PutDocNoCollection(t *testing.T) {
 	mockdoc := mockDocument()
 
-	_, stat, _ := mockdoc.AddChildDocument("topDoc/col1/doc2", mockPayload(), "doc2", "USER", false, false, "mydb")
+	_, stat, _ := mockdoc.AddChildDocument("topDoc/col1/doc2", mockPayload(), "doc2", "USER", false, false, "mydb", precondition.Conditions{})
 
 	if stat != http.StatusNotFound {
 		t.Errorf("Error: expected status code 404,got %d", stat)
@@ -373,11 +400,11 @@ func TestDocument_PutDocNoCollection(t *testing.T) {
 func TestDocument_GetChildCollectionSingle(t *testing.T) {
 	mockDoc := mockDocument()
 
-	mockDoc.AddChildCollection("topDoc/col1", "mydb")
+	mockDoc.AddChildCollection("topDoc/col1", "mydb", "user")
 
-	mockDoc.AddChildDocument("topDoc/col1/doc2", mockPayload(), "doc2", "test", true, true, "mydb")
+	mockDoc.AddChildDocument("topDoc/col1/doc2", mockPayload(), "doc2", "test", true, true, "mydb", precondition.Conditions{})
 
-	_, stat, _, _, _ := mockDoc.GetChildCollection("topDoc/col1", "a", "z", false)
+	_, stat, _, _, _ := mockDoc.GetChildCollection("topDoc/col1", "a", "z", false, query.Options{}, "")
 
 	if stat != http.StatusOK {
 		t.Errorf("GetChildCollectionSingle failed, expected status code 200,got %d", stat)
@@ -388,15 +415,15 @@ func TestDocument_GetChildCollectionSingle(t *testing.T) {
 func TestDocument_GetChildCollectionMultipleDocs(t *testing.T) {
 	mockDoc := mockDocument()
 
-	mockDoc.AddChildCollection("topDoc/col1", "mydb")
+	mockDoc.AddChildCollection("topDoc/col1", "mydb", "user")
 
-	mockDoc.AddChildDocument("topDoc/col1/doc2", mockPayload(), "doc2", "test", true, true, "mydb")
-	mockDoc.AddChildDocument("topDoc/col1/doc3", mockPayload(), "doc3", "test", true, true, "mydb")
-	mockDoc.AddChildDocument("topDoc/col1/doc4", mockPayload(), "doc4", "test", true, true, "mydb")
-	mockDoc.AddChildDocument("topDoc/col1/doc5", mockPayload(), "doc5", "test", true, true, "mydb")
-	mockDoc.AddChildDocument("topDoc/col1/doc6", mockPayload(), "doc6", "test", true, true, "mydb")
+	mockDoc.AddChildDocument("topDoc/col1/doc2", mockPayload(), "doc2", "test", true, true, "mydb", precondition.Conditions{})
+	mockDoc.AddChildDocument("topDoc/col1/doc3", mockPayload(), "doc3", "test", true, true, "mydb", precondition.Conditions{})
+	mockDoc.AddChildDocument("topDoc/col1/doc4", mockPayload(), "doc4", "test", true, true, "mydb", precondition.Conditions{})
+	mockDoc.AddChildDocument("topDoc/col1/doc5", mockPayload(), "doc5", "test", true, true, "mydb", precondition.Conditions{})
+	mockDoc.AddChildDocument("topDoc/col1/doc6", mockPayload(), "doc6", "test", true, true, "mydb", precondition.Conditions{})
 
-	_, stat, _, _, _ := mockDoc.GetChildCollection("topDoc/col1", "a", "z", false)
+	_, stat, _, _, _ := mockDoc.GetChildCollection("topDoc/col1", "a", "z", false, query.Options{}, "")
 
 	if stat != http.StatusOK {
 		t.Errorf("GetChildCollectionMultipleDocsFailed: expected status code 200,got %d", stat)
@@ -407,15 +434,15 @@ func TestDocument_GetChildCollectionMultipleDocs(t *testing.T) {
 func TestDocument_GetChildCollectionLimitedRange(t *testing.T) {
 	mockDoc := mockDocument()
 
-	mockDoc.AddChildCollection("topDoc/col1", "mydb")
+	mockDoc.AddChildCollection("topDoc/col1", "mydb", "user")
 
-	mockDoc.AddChildDocument("topDoc/col1/a", mockPayload(), "a", "test", true, true, "mydb")
-	mockDoc.AddChildDocument("topDoc/col1/b", mockPayload(), "b", "test", true, true, "mydb")
-	mockDoc.AddChildDocument("topDoc/col1/c", mockPayload(), "c", "test", true, true, "mydb")
-	mockDoc.AddChildDocument("topDoc/col1/d", mockPayload(), "d", "test", true, true, "mydb")
-	mockDoc.AddChildDocument("topDoc/col1/e", mockPayload(), "e", "test", true, true, "mydb")
+	mockDoc.AddChildDocument("topDoc/col1/a", mockPayload(), "a", "test", true, true, "mydb", precondition.Conditions{})
+	mockDoc.AddChildDocument("topDoc/col1/b", mockPayload(), "b", "test", true, true, "mydb", precondition.Conditions{})
+	mockDoc.AddChildDocument("topDoc/col1/c", mockPayload(), "c", "test", true, true, "mydb", precondition.Conditions{})
+	mockDoc.AddChildDocument("topDoc/col1/d", mockPayload(), "d", "test", true, true, "mydb", precondition.Conditions{})
+	mockDoc.AddChildDocument("topDoc/col1/e", mockPayload(), "e", "test", true, true, "mydb", precondition.Conditions{})
 
-	_, stat, _, _, _ := mockDoc.GetChildCollection("topDoc/col1", "c", "e", false)
+	_, stat, _, _, _ := mockDoc.GetChildCollection("topDoc/col1", "c", "e", false, query.Options{}, "")
 
 	if stat != http.StatusOK {
 		t.Errorf("GetChildCollection Failed, expected 200, got %d", stat)
@@ -427,11 +454,11 @@ func TestDocument_AddChildDocument(t *testing.T) {
 
 	topDoc := mockDocument()
 
-	topDoc.AddChildCollection("topDoc/col1", "db")
+	topDoc.AddChildCollection("topDoc/col1", "db", "user")
 
-	topDoc.AddChildDocument("topDoc/col1/child", mockPayload(), "child", "USER", true, false, "db")
+	topDoc.AddChildDocument("topDoc/col1/child", mockPayload(), "child", "USER", true, false, "db", precondition.Conditions{})
 
-	_, stat, _, _, _ := topDoc.GetChildDocument("topDoc/col1/child", false, "")
+	_, stat, _, _, _ := topDoc.GetChildDocument("topDoc/col1/child", false, "", "")
 
 	if stat != http.StatusOK {
 		t.Errorf("Error in AddChildDocument, expected status code 200 but got %d", stat)
@@ -441,9 +468,9 @@ func TestDocument_AddChildDocument(t *testing.T) {
 func TestDocument_DeleteChildCollection(t *testing.T) {
 	topDoc := mockDocument()
 
-	topDoc.AddChildCollection("topDoc/col1", "db")
+	topDoc.AddChildCollection("topDoc/col1", "db", "user")
 
-	_, stat := topDoc.DeleteChildCollection("topDoc/col1")
+	_, stat := topDoc.DeleteChildCollection("topDoc/col1", "db", "user")
 
 	if stat != http.StatusNoContent {
 		t.Errorf("Delete Child Collection failed")
@@ -453,7 +480,7 @@ func TestDocument_DeleteChildCollection(t *testing.T) {
 func TestDocument_DeleteChildCollectionCollectionDoesntExist(t *testing.T) {
 	topDoc := mockDocument()
 
-	_, stat := topDoc.DeleteChildCollection("topDoc/col1/")
+	_, stat := topDoc.DeleteChildCollection("topDoc/col1/", "db", "user")
 
 	if stat != http.StatusNotFound {
 		t.Errorf("TestDocument_DeleteChildCollectionCollectionDoesntExist failed, expected 404 got %d", stat)
@@ -463,7 +490,7 @@ func TestDocument_DeleteChildCollectionCollectionDoesntExist(t *testing.T) {
 func TestDocument_DeleteChildNestedCollectionCollectionDoesntExist(t *testing.T) {
 	topDoc := mockDocument()
 
-	_, stat := topDoc.DeleteChildCollection("topDoc/col1/doc2/col3/")
+	_, stat := topDoc.DeleteChildCollection("topDoc/col1/doc2/col3/", "db", "user")
 
 	if stat != http.StatusNotFound {
 		t.Errorf("TestDocument_DeleteChildCollectionCollectionDoesntExist failed, expected 404 got %d", stat)
@@ -473,7 +500,7 @@ func TestDocument_DeleteChildNestedCollectionCollectionDoesntExist(t *testing.T)
 func TestDocument_DeeplyNestedNotFoundDoc(t *testing.T) {
 	topDoc := mockDocument()
 
-	_, stat := topDoc.DeleteChildDocument("topDoc/col1/doc2/col3/doc4", "")
+	_, stat := topDoc.DeleteChildDocument("topDoc/col1/doc2/col3/doc4", "", "user", precondition.Conditions{})
 
 	if stat != http.StatusNotFound {
 		t.Errorf("TestDocument_DeleteChildCollectionCollectionDoesntExist failed, expected 404 got %d", stat)
@@ -483,7 +510,7 @@ func TestDocument_DeeplyNestedNotFoundDoc(t *testing.T) {
 func TestDocument_ApplyPatchDocumentNotFound(t *testing.T) {
 	topDoc := mockDocument()
 
-	_, stat := topDoc.ApplyPatchDocument("db", "doc1/col2/doc3", []byte("patch"), "user")
+	_, stat := topDoc.ApplyPatchDocument("db", "doc1/col2/doc3", []byte("patch"), "user", patcher.MediaTypeOwlDB, precondition.Conditions{})
 
 	if stat != http.StatusNotFound {
 		t.Errorf("TestDocument_ApplyPatchDocument Failed,got status code %d", stat)
@@ -493,9 +520,9 @@ func TestDocument_ApplyPatchDocumentNotFound(t *testing.T) {
 
 func TestDocument_ApplyPatch(t *testing.T) {
 	topDoc := mockDocument()
-	topDoc.AddChildCollection("topDoc/col1", "db")
-	topDoc.AddChildDocument("topDoc/col1/child", mockPayload(), "child", "USER", true, false, "db")
-	_, stat := topDoc.ApplyPatchDocument("db", "topDoc/col1/child", []byte("patch"), "user")
+	topDoc.AddChildCollection("topDoc/col1", "db", "user")
+	topDoc.AddChildDocument("topDoc/col1/child", mockPayload(), "child", "USER", true, false, "db", precondition.Conditions{})
+	_, stat := topDoc.ApplyPatchDocument("db", "topDoc/col1/child", []byte("patch"), "user", patcher.MediaTypeOwlDB, precondition.Conditions{})
 	if stat != http.StatusOK {
 		t.Errorf("ApplyPatch failed,got: %d", stat)
 	}
@@ -503,9 +530,9 @@ func TestDocument_ApplyPatch(t *testing.T) {
 
 func TestDocument_ApplyPatchNoDoc(t *testing.T) {
 	topDoc := mockDocument()
-	topDoc.AddChildCollection("topDoc/col1", "db")
-	//topDoc.AddChildDocument("topDoc/col1/child", mockPayload(), "child", "USER", true, false, "db")
-	_, stat := topDoc.ApplyPatchDocument("db", "topDoc/col1/child", []byte("patch"), "user")
+	topDoc.AddChildCollection("topDoc/col1", "db", "user")
+	//topDoc.AddChildDocument("topDoc/col1/child", mockPayload(), "child", "USER", true, false, "db", precondition.Conditions{})
+	_, stat := topDoc.ApplyPatchDocument("db", "topDoc/col1/child", []byte("patch"), "user", patcher.MediaTypeOwlDB, precondition.Conditions{})
 	if stat != http.StatusNotFound {
 		t.Errorf("ApplyPatch failed,got: %d", stat)
 	}
@@ -514,9 +541,9 @@ func TestDocument_ApplyPatchNoDoc(t *testing.T) {
 
 func TestDocument_ApplyPatchNoCol(t *testing.T) {
 	topDoc := mockDocument()
-	//topDoc.AddChildCollection("topDoc/col1", "db")
-	topDoc.AddChildDocument("topDoc/col1/child", mockPayload(), "child", "USER", true, false, "db")
-	_, stat := topDoc.ApplyPatchDocument("db", "topDoc/col1/child", []byte("patch"), "user")
+	//topDoc.AddChildCollection("topDoc/col1", "db", "user")
+	topDoc.AddChildDocument("topDoc/col1/child", mockPayload(), "child", "USER", true, false, "db", precondition.Conditions{})
+	_, stat := topDoc.ApplyPatchDocument("db", "topDoc/col1/child", []byte("patch"), "user", patcher.MediaTypeOwlDB, precondition.Conditions{})
 	if stat != http.StatusNotFound {
 		t.Errorf("ApplyPatch failed,got: %d", stat)
 	}
@@ -524,9 +551,9 @@ func TestDocument_ApplyPatchNoCol(t *testing.T) {
 
 func TestDocument_DeleteChildDocument(t *testing.T) {
 	topDoc := mockDocument()
-	topDoc.AddChildCollection("topDoc/col1", "db")
-	topDoc.AddChildDocument("topDoc/col1/child", mockPayload(), "child", "USER", true, false, "db")
-	_, stat := topDoc.DeleteChildDocument("topDoc/col1/child", "mydb")
+	topDoc.AddChildCollection("topDoc/col1", "db", "user")
+	topDoc.AddChildDocument("topDoc/col1/child", mockPayload(), "child", "USER", true, false, "db", precondition.Conditions{})
+	_, stat := topDoc.DeleteChildDocument("topDoc/col1/child", "mydb", "user", precondition.Conditions{})
 	if stat != http.StatusNoContent {
 		t.Errorf("%d", stat)
 	}
@@ -534,8 +561,8 @@ func TestDocument_DeleteChildDocument(t *testing.T) {
 
 func TestDocument_DeleteChildNoDoc(t *testing.T) {
 	topDoc := mockDocument()
-	topDoc.AddChildCollection("topDoc/col1", "db")
-	_, stat := topDoc.DeleteChildDocument("topDoc/col1/child", "mydb")
+	topDoc.AddChildCollection("topDoc/col1", "db", "user")
+	_, stat := topDoc.DeleteChildDocument("topDoc/col1/child", "mydb", "user", precondition.Conditions{})
 	if stat != http.StatusNotFound {
 		t.Errorf("%d", stat)
 	}
@@ -544,7 +571,7 @@ func TestDocument_DeleteChildNoDoc(t *testing.T) {
 func TestDocument_DeleteChildNoCol(t *testing.T) {
 	topDoc := mockDocument()
 
-	_, stat := topDoc.DeleteChildDocument("topDoc/col1/child", "mydb")
+	_, stat := topDoc.DeleteChildDocument("topDoc/col1/child", "mydb", "user", precondition.Conditions{})
 	if stat != http.StatusNotFound {
 		t.Errorf("%d", stat)
 	}
@@ -553,16 +580,126 @@ func TestDocument_DeleteChildNoCol(t *testing.T) {
 // Tests that the code covering subscription requests is covered correctly
 func TestDocument_AddChildDocumentSubscriberNotif(t *testing.T) {
 	topDoc := mockDocument()
-	topDoc.AddChildCollection("topDoc/col1", "db")
+	topDoc.AddChildCollection("topDoc/col1", "db", "user")
 
-	topDoc.AddChildDocument("topDoc/col1/child", mockPayload(), "child", "USER", true, false, "db")
-	topDoc.GetChildDocument("topDoc/col1/child", true, "")
+	topDoc.AddChildDocument("topDoc/col1/child", mockPayload(), "child", "USER", true, false, "db", precondition.Conditions{})
+	topDoc.GetChildDocument("topDoc/col1/child", true, "", "")
 
 }
 
 // tests that the collection subscription request is covered correctly
 func TestCollectionSubRequest(t *testing.T) {
 	topDoc := mockDocument()
-	topDoc.AddChildCollection("topDoc/col1", "db")
-	topDoc.GetChildCollection("topDoc/col1", "", "", true)
+	topDoc.AddChildCollection("topDoc/col1", "db", "user")
+	topDoc.GetChildCollection("topDoc/col1", "", "", true, query.Options{}, "")
+}
+
+func TestDocument_History(t *testing.T) {
+	mockdoc := mockDocument()
+	mockdoc.UpdateDoc([]byte(`{"v":2}`), "alice")
+	mockdoc.UpdateDoc([]byte(`{"v":3}`), "bob")
+
+	res, stat := mockdoc.GetChildDocumentHistory("topDoc", history.Selector{})
+	var listed struct {
+		Versions []history.Revision `json:"versions"`
+	}
+	json.Unmarshal(res, &listed)
+	if stat != http.StatusOK || len(listed.Versions) != 3 || listed.Versions[0].Version != 3 || listed.Versions[0].LastModifiedBy != "bob" || listed.Versions[1].LastModifiedBy != "alice" || listed.Versions[2].Doc != nil {
+		t.Errorf("TestDocument_History failed, expected every version newest first, got %s", string(res))
+	}
+	res, stat = mockdoc.GetChildDocumentHistory("topDoc", history.Selector{Version: 2})
+	if stat != http.StatusOK || !strings.Contains(string(res), `"doc":{"v":2}`) || !strings.Contains(string(res), `"version":2`) {
+		t.Errorf("TestDocument_History failed, expected version 2, got %s", string(res))
+	}
+	if _, stat = mockdoc.GetChildDocumentHistory("topDoc", history.Selector{Version: 9}); stat != http.StatusNotFound {
+		t.Errorf("TestDocument_History failed, expected 404 for a missing version, got %d", stat)
+	}
+	if _, stat = mockdoc.GetChildDocumentHistory("topDoc/col1/doc2", history.Selector{}); stat != http.StatusNotFound {
+		t.Errorf("TestDocument_History failed, expected 404 for a missing document, got %d", stat)
+	}
+
+	//the stored form keeps the history, which a later write replayed onto it extends
+	restored := mockDocument()
+	restored.Restore(mockdoc.storedSerial())
+	mockdoc.UpdateDoc([]byte(`{"v":4}`), "carol")
+	restored.Restore(mockdoc.GetSerial())
+	if len(restored.Info.History) != 3 || restored.Info.History[2].Version != 3 || restored.Version() != 4 {
+		t.Errorf("TestDocument_History failed, expected the history to be rebuilt, got %+v", restored.Info.History)
+	}
+
+	//rolling back to an earlier version forgets the versions since
+	res, _ = restored.GetChildDocumentHistory("topDoc", history.Selector{Version: 2})
+	restored.Restore(res)
+	if len(restored.Info.History) != 1 || restored.Version() != 2 {
+		t.Errorf("TestDocument_History failed, expected the history to be rolled back, got %+v", restored.Info.History)
+	}
+}
+
+func TestDocument_JournalFailure(t *testing.T) {
+	mockdoc := mockDocument()
+	journal := mockdoc.journal.(*mocks.MockJournal)
+	mockdoc.AddChildCollection("topDoc/col1", "mydb", "user")
+	mockdoc.AddChildDocument("topDoc/col1/doc2", mockPayload(), "doc2", "USER", true, false, "mydb", precondition.Conditions{})
+
+	journal.Err = errors.New("no space left on device")
+	if _, stat, _ := mockdoc.AddChildDocument("topDoc/col1/doc2", []byte(`{"a":1}`), "doc2", "USER", true, false, "mydb", precondition.Conditions{}); stat != http.StatusInternalServerError {
+		t.Errorf("TestDocument_JournalFailure failed, expected an overwrite that was not journaled to fail with 500, got %d", stat)
+	}
+	serial, _, _, _, _ := mockdoc.GetChildDocument("topDoc/col1/doc2", false, "mydb", "")
+	var doc struct {
+		Doc  json.RawMessage `json:"doc"`
+		Meta metadata        `json:"meta"`
+	}
+	json.Unmarshal(serial, &doc)
+	if doc.Meta.Version != 1 || !strings.Contains(string(doc.Doc), `"Message":"Hello"`) {
+		t.Errorf("TestDocument_JournalFailure failed, expected the overwrite to be undone, got %s", serial)
+	}
+	if _, stat := mockdoc.DeleteChildDocument("topDoc/col1/doc2", "mydb", "USER", precondition.Conditions{}); stat != http.StatusInternalServerError {
+		t.Errorf("TestDocument_JournalFailure failed, expected a deletion that was not journaled to fail with 500, got %d", stat)
+	}
+	if _, stat := mockdoc.DeleteChildCollection("topDoc/col1", "mydb", "USER"); stat != http.StatusInternalServerError {
+		t.Errorf("TestDocument_JournalFailure failed, expected a collection deletion that was not journaled to fail with 500, got %d", stat)
+	}
+	if _, stat, _, _, _ := mockdoc.GetChildDocument("topDoc/col1/doc2", false, "mydb", ""); stat != http.StatusOK {
+		t.Errorf("TestDocument_JournalFailure failed, expected the document to remain, got %d", stat)
+	}
+}
+
+// racingSL runs raced once a removal has released the removed document, as a writer waiting on it would
+type racingSL struct {
+	*mockSL[string, *Document]
+	raced func()
+}
+
+func (m *racingSL) RemoveIf(key string, check index_utils.RemoveCheck[string, *Document]) (*Document, bool, error) {
+	val, removed, err := m.mockSL.RemoveIf(key, check)
+	if removed && m.raced != nil {
+		raced := m.raced
+		m.raced = nil
+		raced()
+	}
+	return val, removed, err
+}
+
+func TestDocument_DeleteRacingRecreate(t *testing.T) {
+	mockdoc := mockDocument()
+	mockdoc.AddChildCollection("topDoc/col1", "mydb", "user")
+	mockdoc.AddChildIndex("topDoc/col1", fieldIndex.Definition{Field: "/email", Unique: true}, "mydb")
+	col, _ := mockdoc.collections.Find("col1")
+	docs := &racingSL{mockSL: col.Docs.(*mockSL[string, *Document])}
+	col.Docs = docs
+	payload := []byte(`{"email":"amy@example.com"}`)
+
+	mockdoc.AddChildDocument("topDoc/col1/doc", payload, "doc", "USER", true, false, "mydb", precondition.Conditions{})
+	docs.raced = func() {
+		mockdoc.AddChildDocument("topDoc/col1/doc", payload, "doc", "USER", true, false, "mydb", precondition.Conditions{})
+	}
+	mockdoc.DeleteChildDocument("topDoc/col1/doc", "mydb", "USER", precondition.Conditions{})
+
+	if _, stat, _, _, _ := mockdoc.GetChildDocument("topDoc/col1/doc", false, "mydb", ""); stat != http.StatusOK {
+		t.Fatalf("TestDocument_DeleteRacingRecreate failed, expected the document to be recreated, got %d", stat)
+	}
+	if _, stat, _ := mockdoc.AddChildDocument("topDoc/col1/other", payload, "other", "USER", true, false, "mydb", precondition.Conditions{}); stat != http.StatusConflict {
+		t.Errorf("TestDocument_DeleteRacingRecreate failed, expected the recreated document to keep its unique value, got %d", stat)
+	}
 }
@@ -0,0 +1,78 @@
+package document
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/history"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/jsondata"
+)
+
+// currentRevision returns the current version of the document as an entry of its history
+func (d *Document) currentRevision() history.Revision {
+	return history.Revision{
+		Version:        d.Info.Meta.Version,
+		LastModifiedBy: d.Info.Meta.LastModifiedBy,
+		LastModifiedAt: d.Info.Meta.LastModifiedAt,
+		Doc:            d.Info.Doc,
+	}
+}
+
+// storedSerial returns the serialized form of the document along with its history, as kept in durable storage and
+// read back by Restore
+func (d *Document) storedSerial() []byte {
+	var docJSON jsondata.JSONValue
+	_ = json.Unmarshal(d.Info.Doc, &docJSON)
+
+	res, _ := json.Marshal(struct {
+		Path    string             `json:"path"`
+		Doc     jsondata.JSONValue `json:"doc"`
+		Meta    metadata           `json:"meta"`
+		History []history.Revision `json:"history,omitempty"`
+	}{
+		Path:    d.Info.Path,
+		Doc:     docJSON,
+		Meta:    d.Info.Meta,
+		History: d.Info.History,
+	})
+	return res
+}
+
+// GetChildDocumentHistory reads the history of the document at docpath, d itself or one of its descendants. The
+// zero selector lists every version kept, newest first and without their contents; any other selector reads the
+// version it picks, serialized as the document was at that version.
+// Returns a JSON-encoded response and a status code
+func (d *Document) GetChildDocumentHistory(docpath string, sel history.Selector) ([]byte, int) {
+	resDoc, found := d.traverseDocuments(strings.Split(docpath, "/"))
+	if !found {
+		errmsg, _ := json.Marshal("Document does not exist")
+		return errmsg, http.StatusNotFound
+	}
+	revs := append(append([]history.Revision{}, resDoc.Info.History...), resDoc.currentRevision())
+	meta := resDoc.Info.Meta
+	path := resDoc.Info.Path
+
+	if sel.IsZero() {
+		versions := make([]history.Revision, 0, len(revs))
+		for i := len(revs) - 1; i >= 0; i-- {
+			rev := revs[i]
+			rev.Doc = nil
+			versions = append(versions, rev)
+		}
+		res, _ := json.Marshal(struct {
+			Path     string             `json:"path"`
+			Versions []history.Revision `json:"versions"`
+		}{Path: path, Versions: versions})
+		return res, http.StatusOK
+	}
+
+	rev, err := sel.Select(revs)
+	if err != nil {
+		errmsg, _ := json.Marshal(err.Error())
+		return errmsg, http.StatusNotFound
+	}
+	meta.Version, meta.LastModifiedBy, meta.LastModifiedAt = rev.Version, rev.LastModifiedBy, rev.LastModifiedAt
+	old := Document{Info: docInfo{Path: path, Meta: meta, Doc: rev.Doc}}
+	return old.GetSerial(), http.StatusOK
+}
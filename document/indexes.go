@@ -0,0 +1,116 @@
+package document
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/fieldIndex"
+)
+
+// AddChildIndex creates the secondary index described by def over the documents of the descendant collection at colpath.
+// A unique index cannot be created while two documents share a value at its field.
+// Returns a JSON-encoded response object, a status code and the URI of the index
+func (d *Document) AddChildIndex(colpath string, def fieldIndex.Definition, dbName string) ([]byte, int, string) {
+	colpath = strings.TrimSuffix(colpath, "/")
+	created, errmsg, stat := d.createIndex(colpath, def)
+	if errmsg != nil {
+		return errmsg, stat, ""
+	}
+	if !created {
+		errmsg, _ := json.Marshal("Index already exists")
+		return errmsg, http.StatusBadRequest, ""
+	}
+	if err := d.journal.RecordPutIndex(dbName, colpath, def); err != nil {
+		if col, _, _ := d.findChildCollection(colpath); col != nil {
+			col.Indexes.Drop(def.Field)
+		}
+		errmsg, stat := journalFailure(err)
+		return errmsg, stat, ""
+	}
+	respJson := struct {
+		Uri string `json:"uri"`
+	}{
+		Uri: "/v1/" + dbName + "/" + colpath + "/?index=" + url.QueryEscape(def.Field),
+	}
+	b, _ := json.Marshal(respJson)
+	return b, http.StatusCreated, respJson.Uri
+}
+
+// RestoreChildIndex recreates the secondary index described by def over the descendant collection at colpath, doing
+// nothing if it already exists. Nothing is journaled; this is used to rebuild state, not to serve clients.
+// Returns a response (if an error occurred) and a status code
+func (d *Document) RestoreChildIndex(colpath string, def fieldIndex.Definition) ([]byte, int) {
+	_, errmsg, stat := d.createIndex(strings.TrimSuffix(colpath, "/"), def)
+	if errmsg != nil {
+		return errmsg, stat
+	}
+	return nil, http.StatusCreated
+}
+
+// createIndex creates the secondary index described by def over the descendant collection at colpath.
+// Returns whether the index was created, false if it already existed, or a JSON-encoded error and a status code
+func (d *Document) createIndex(colpath string, def fieldIndex.Definition) (bool, []byte, int) {
+	col, errmsg, stat := d.findChildCollection(colpath)
+	if col == nil {
+		return false, errmsg, stat
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(15*time.Second))
+	defer cancel()
+	created, err := col.AddIndex(ctx, def)
+	if errors.Is(err, fieldIndex.ErrDuplicate) {
+		errmsg, _ := json.Marshal(err.Error())
+		return false, errmsg, http.StatusConflict
+	} else if err != nil {
+		errmsg, _ := json.Marshal(err.Error())
+		return false, errmsg, http.StatusBadRequest
+	}
+	return created, nil, http.StatusOK
+}
+
+// DeleteChildIndex drops the secondary index on the field named by the JSON pointer field from the descendant
+// collection at colpath.
+// Returns a response (if an error occurred) and a status code
+func (d *Document) DeleteChildIndex(colpath string, field string, dbName string) ([]byte, int) {
+	colpath = strings.TrimSuffix(colpath, "/")
+	col, errmsg, stat := d.findChildCollection(colpath)
+	if col == nil {
+		return errmsg, stat
+	}
+	var def fieldIndex.Definition
+	for _, held := range col.Indexes.Definitions() {
+		if held.Field == field {
+			def = held
+		}
+	}
+	if !col.Indexes.Drop(field) {
+		errmsg, _ := json.Marshal("Index does not exist")
+		return errmsg, http.StatusNotFound
+	}
+	if err := d.journal.RecordDeleteIndex(dbName, colpath, field); err != nil {
+		d.createIndex(colpath, def) //the index held the documents it is rebuilt from, so it can be rebuilt
+		return journalFailure(err)
+	}
+	return nil, http.StatusNoContent
+}
+
+// findChildCollection finds the descendant collection at colpath.
+// Returns the collection, or nil along with a JSON-encoded error and a status code if it does not exist
+func (d *Document) findChildCollection(colpath string) (*Collection, []byte, int) {
+	splitPath := strings.Split(colpath, "/")
+	parentDoc, found := d.traverseDocuments(splitPath[:len(splitPath)-1])
+	if !found {
+		errmsg, _ := json.Marshal("Owning document does not exist")
+		return nil, errmsg, http.StatusNotFound
+	}
+	col, found := parentDoc.collections.Find(splitPath[len(splitPath)-1])
+	if !found {
+		errmsg, _ := json.Marshal("Collection does not exist")
+		return nil, errmsg, http.StatusNotFound
+	}
+	return col, nil, http.StatusOK
+}
@@ -0,0 +1,117 @@
+package document
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/history"
+)
+
+// serialDoc is the decoded form of a document serialized by GetSerial
+type serialDoc struct {
+	Path string          `json:"path"`
+	Doc  json.RawMessage `json:"doc"`
+	Meta metadata        `json:"meta"`
+
+	History *[]history.Revision `json:"history"` // only held by the stored form of a document
+}
+
+// Restore replaces the contents and metadata of d with those held in serial, a document previously produced by
+// GetSerial or stored along with its history. Without a history, a later version pushes the current contents into
+// the history as a write would, while an earlier version forgets every version since.
+// The document's collections are left untouched. (DO NOT CALL THIS WITHOUT SYNCHRONIZATION)
+func (d *Document) Restore(serial []byte) error {
+	var sd serialDoc
+	if err := json.Unmarshal(serial, &sd); err != nil {
+		return fmt.Errorf("malformed serialized document: %w", err)
+	}
+	if len(sd.Doc) == 0 {
+		return fmt.Errorf("malformed serialized document: missing doc")
+	}
+	switch {
+	case sd.History != nil:
+		d.Info.History = *sd.History
+	case sd.Meta.Version > d.Info.Meta.Version && len(d.Info.Doc) > 0:
+		d.Info.History = history.Append(d.Info.History, d.currentRevision())
+	case sd.Meta.Version < d.Info.Meta.Version:
+		d.Info.History = history.Truncate(d.Info.History, sd.Meta.Version)
+	}
+	d.Info.Doc = []byte(sd.Doc)
+	d.Info.Meta = sd.Meta
+	return nil
+}
+
+// RestoreChildDocument recreates the descendant document at docpath from serial, a document previously produced by
+// GetSerial, keeping its original metadata. An existing document is replaced in place so that its collections survive.
+// No subscribers are notified and unique indices are not enforced; this is used to rebuild state, not to serve clients.
+// Returns a response (if an error occurred) and a status code
+func (d *Document) RestoreChildDocument(docpath string, serial []byte) ([]byte, int) {
+	docpath = strings.TrimSuffix(docpath, "/")
+	splitPath := strings.Split(docpath, "/")
+	if len(splitPath) < 3 {
+		errmsg, _ := json.Marshal("bad resource path")
+		return errmsg, http.StatusBadRequest
+	}
+	parentColName := splitPath[len(splitPath)-2]
+	docName := splitPath[len(splitPath)-1]
+
+	parentDoc, found := d.traverseDocuments(splitPath[:len(splitPath)-2])
+	if !found {
+		errmsg, _ := json.Marshal("Collection does not exist")
+		return errmsg, http.StatusNotFound
+	}
+	parentCol, foundCol := parentDoc.collections.Find(parentColName)
+	if !foundCol {
+		errmsg, _ := json.Marshal("Collection does not exist")
+		return errmsg, http.StatusNotFound
+	}
+
+	check := func(key string, curVal *Document, exists bool) (*Document, error) {
+		var oldDoc []byte
+		if exists {
+			oldDoc = curVal.Info.Doc
+		} else {
+			curVal = d.newChild(nil, "", docpath)
+		}
+		if err := curVal.Restore(serial); err != nil {
+			return curVal, err
+		}
+		parentCol.Indexes.Reindex(key, oldDoc, curVal.Info.Doc)
+		return curVal, nil
+	}
+	if _, err := parentCol.Docs.Upsert(docName, check); err != nil {
+		errmsg, _ := json.Marshal(err.Error())
+		return errmsg, http.StatusBadRequest
+	}
+	return nil, http.StatusCreated
+}
+
+// RestoreChildCollection recreates the descendant collection at colpath, doing nothing if it already exists.
+// No subscribers are notified and nothing is journaled; this is used to rebuild state, not to serve clients.
+// Returns a response (if an error occurred) and a status code
+func (d *Document) RestoreChildCollection(colpath string) ([]byte, int) {
+	colpath = strings.TrimSuffix(colpath, "/")
+	splitPath := strings.Split(colpath, "/")
+	if len(splitPath)%2 != 0 {
+		errmsg, _ := json.Marshal("bad resource path")
+		return errmsg, http.StatusBadRequest
+	}
+	parentDoc, found := d.traverseDocuments(splitPath[:len(splitPath)-1])
+	if !found {
+		errmsg, _ := json.Marshal("Document does not exist")
+		return errmsg, http.StatusNotFound
+	}
+	check := func(key string, curVal *Collection, exists bool) (*Collection, error) {
+		if exists {
+			return curVal, nil
+		}
+		return d.collectionFactory(colpath), nil
+	}
+	if _, err := parentDoc.collections.Upsert(splitPath[len(splitPath)-1], check); err != nil {
+		errmsg, _ := json.Marshal(err.Error())
+		return errmsg, http.StatusBadRequest
+	}
+	return nil, http.StatusCreated
+}
@@ -0,0 +1,104 @@
+package document
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// UnsubscribeChildDocument removes the subscriber subId from the document located at the forward-slash-delimited
+// path docpath, closing its channel. Nothing happens if the subscriber was already removed
+func (d *Document) UnsubscribeChildDocument(docpath string, dbName string, subId string) {
+	d.messager.RemoveDocSubscriber(dbName+"/"+docpath, subId)
+}
+
+// UnsubscribeChildCollection removes the subscriber subId from the collection located at colpath, a collection
+// belonging to d or one of its descendant documents, closing its channel. Nothing happens if the collection or the
+// subscriber no longer exists
+func (d *Document) UnsubscribeChildCollection(colpath string, subId string) {
+	splitPath := strings.Split(strings.TrimSuffix(colpath, "/"), "/")
+	parentDoc, found := d.traverseDocuments(splitPath[:len(splitPath)-1])
+	if !found {
+		return
+	}
+	if childCol, found := parentDoc.collections.Find(splitPath[len(splitPath)-1]); found {
+		childCol.SubscriptionManager.Remove(subId)
+	}
+}
+
+// closeCollections tells the subscribers to every collection beneath d, located at docpath in the database dbName,
+// that it was deleted, ending their subscriptions
+func (d *Document) closeCollections(dbName string, docpath string) {
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(10*time.Second))
+	defer cancel()
+	cols, _ := d.collections.Query(ctx, string(rune(0)), string(rune(127)))
+	for _, col := range cols {
+		col.Value.close(ctx, dbName, docpath+"/"+col.Key)
+	}
+}
+
+// close tells the subscribers to c, located at colpath in the database dbName, and to every collection beneath it
+// that it was deleted, ending their subscriptions
+func (c *Collection) close(ctx context.Context, dbName string, colpath string) {
+	b, _ := json.Marshal("/" + colpath + "/")
+	c.SubscriptionManager.NotifyAll(string(b))
+	docs, _ := c.Docs.Query(ctx, string(rune(0)), string(rune(127)))
+	for _, doc := range docs {
+		doc.Value.closeCollections(dbName, colpath+"/"+doc.Key)
+	}
+}
+
+// GetChildSubtree reads the subtree rooted at path, a document or, if path ends with "/", a collection, beneath d.
+// Returns a payload for each resource of the subtree, parents before children: the serialized form of each
+// document and the path of each collection, or else an error message and a status code
+func (d *Document) GetChildSubtree(path string) ([]byte, int, [][]byte) {
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(15*time.Second))
+	defer cancel()
+	if strings.HasSuffix(path, "/") {
+		colpath := strings.TrimSuffix(path, "/")
+		splitPath := strings.Split(colpath, "/")
+		parentDoc, found := d.traverseDocuments(splitPath[:len(splitPath)-1])
+		if !found {
+			errmsg, _ := json.Marshal("Collection does not exist")
+			return errmsg, http.StatusNotFound, nil
+		}
+		childCol, found := parentDoc.collections.Find(splitPath[len(splitPath)-1])
+		if !found {
+			errmsg, _ := json.Marshal("Collection does not exist")
+			return errmsg, http.StatusNotFound, nil
+		}
+		return nil, http.StatusOK, childCol.subtree(ctx, colpath, nil)
+	}
+	doc, found := d.traverseDocuments(strings.Split(path, "/"))
+	if !found {
+		errmsg, _ := json.Marshal("Document does not exist")
+		return errmsg, http.StatusNotFound, nil
+	}
+	return nil, http.StatusOK, doc.subtree(ctx, nil)
+}
+
+// subtree appends a payload for d and for every resource beneath it to payloads, parents before children.
+// Returns the payloads
+func (d *Document) subtree(ctx context.Context, payloads [][]byte) [][]byte {
+	payloads = append(payloads, d.GetSerial())
+	cols, _ := d.collections.Query(ctx, string(rune(0)), string(rune(127)))
+	for _, col := range cols {
+		payloads = col.Value.subtree(ctx, strings.TrimPrefix(d.Info.Path, "/")+"/"+col.Key, payloads)
+	}
+	return payloads
+}
+
+// subtree appends a payload for c, located at colpath, and for every resource beneath it to payloads, parents
+// before children.
+// Returns the payloads
+func (c *Collection) subtree(ctx context.Context, colpath string, payloads [][]byte) [][]byte {
+	b, _ := json.Marshal("/" + colpath + "/")
+	payloads = append(payloads, b)
+	docs, _ := c.Docs.Query(ctx, string(rune(0)), string(rune(127)))
+	for _, doc := range docs {
+		payloads = doc.Value.subtree(ctx, payloads)
+	}
+	return payloads
+}
@@ -0,0 +1,159 @@
+package document
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/transaction"
+)
+
+// TransactChildDocument applies op, an operation of a transaction, to the descendant document it names, without
+// journaling it; subscribers are only told about the write through its Notify, once the transaction commits. The
+// caller must keep every other request away from the document until the transaction ends.
+// Returns the write, or nil, a JSON-encoded error and a status code if the operation cannot be applied
+func (d *Document) TransactChildDocument(dbName string, op transaction.Operation, user string) (*transaction.Write, []byte, int) {
+	docpath := op.DocPath()
+	splitPath := strings.Split(docpath, "/")
+	docname := splitPath[len(splitPath)-1]
+
+	parentDoc, found := d.traverseDocuments(splitPath[:len(splitPath)-2])
+	if !found {
+		errmsg, _ := json.Marshal("Collection does not exist")
+		return nil, errmsg, http.StatusNotFound
+	}
+	parentCol, found := parentDoc.collections.Find(splitPath[len(splitPath)-2])
+	if !found {
+		errmsg, _ := json.Marshal("Collection does not exist")
+		return nil, errmsg, http.StatusNotFound
+	}
+	if op.Op == transaction.OpDelete {
+		return d.transactDelete(dbName, docpath, docname, parentCol, op)
+	}
+	return d.transactWrite(dbName, docpath, docname, parentCol, op, user)
+}
+
+// transactWrite applies a put or a patch of a transaction to the document docname of parentCol.
+// Returns the write, or nil, a JSON-encoded error and a status code if the operation cannot be applied
+func (d *Document) transactWrite(dbName string, docpath string, docname string, parentCol *Collection, op transaction.Operation, user string) (*transaction.Write, []byte, int) {
+	cond := op.Conditions()
+	var write *transaction.Write
+	var statCode int
+	check := func(key string, curVal *Document, exists bool) (*Document, error) {
+		var version int64
+		if exists {
+			version = curVal.Version()
+		}
+		if err := cond.Check(exists, version); err != nil {
+			statCode = http.StatusPreconditionFailed
+			return curVal, err
+		}
+		payload := []byte(op.Doc)
+		if op.Op == transaction.OpPatch {
+			if !exists {
+				statCode = http.StatusNotFound
+				return nil, fmt.Errorf("document does not exist")
+			}
+			newRaw, err := curVal.DoPatch(op.Patch, op.MediaType())
+			if err != nil {
+				//a patch that is well-formed but does not fit the document conflicts with its current state
+				statCode = http.StatusConflict
+				if strings.HasPrefix(err.Error(), "bad patch operation") {
+					statCode = http.StatusBadRequest
+				}
+				return curVal, err
+			}
+			if err = d.validator.Validate(newRaw); err != nil {
+				statCode = http.StatusBadRequest
+				return curVal, err
+			}
+			payload = newRaw
+		}
+
+		if !exists {
+			if err := parentCol.Indexes.Update(key, nil, payload); err != nil {
+				statCode = http.StatusConflict
+				return nil, err
+			}
+			newDoc := d.newChild(payload, user, docpath)
+			serial := newDoc.GetSerial()
+			write = &transaction.Write{
+				Status: http.StatusCreated,
+				Serial: serial,
+				Undo: func() {
+					parentCol.Docs.Remove(key)
+					parentCol.Indexes.Reindex(key, payload, nil)
+				},
+				Notify: func() {
+					newDoc.messager.NotifyDocs(dbName+"/"+docpath, "update", serial)
+					parentCol.SubscriptionManager.Notify(key, "update", serial)
+				},
+			}
+			return newDoc, nil
+		}
+
+		if err := parentCol.Indexes.Update(key, curVal.Info.Doc, payload); err != nil {
+			statCode = http.StatusConflict
+			return curVal, err
+		}
+		old := curVal.Info
+		curVal.UpdateDoc(payload, user)
+		serial := curVal.GetSerial()
+		write = &transaction.Write{
+			Status: http.StatusOK,
+			Serial: serial,
+			Undo: func() {
+				parentCol.Docs.Upsert(key, func(key string, cur *Document, exists bool) (*Document, error) {
+					if !exists {
+						return nil, fmt.Errorf("document does not exist")
+					}
+					cur.Info = old
+					return cur, nil
+				})
+				parentCol.Indexes.Reindex(key, payload, old.Doc)
+			},
+			Notify: func() {
+				curVal.messager.NotifyDocs(dbName+"/"+docpath, "update", serial)
+				parentCol.SubscriptionManager.Notify(key, "update", serial)
+			},
+		}
+		return curVal, nil
+	}
+	if _, err := parentCol.Docs.Upsert(docname, check); err != nil {
+		errmsg, _ := json.Marshal(err.Error())
+		return nil, errmsg, statCode
+	}
+	return write, nil, http.StatusOK
+}
+
+// transactDelete applies a delete of a transaction to the document docname of parentCol. The document is only
+// unindexed for now, and removed when the transaction commits.
+// Returns the write, or nil, a JSON-encoded error and a status code if the operation cannot be applied
+func (d *Document) transactDelete(dbName string, docpath string, docname string, parentCol *Collection, op transaction.Operation) (*transaction.Write, []byte, int) {
+	victim, found := parentCol.Docs.Find(docname)
+	if !found {
+		errmsg, _ := json.Marshal("Document does not exist")
+		return nil, errmsg, http.StatusNotFound
+	}
+	if err := op.Conditions().Check(true, victim.Version()); err != nil {
+		errmsg, _ := json.Marshal(err.Error())
+		return nil, errmsg, http.StatusPreconditionFailed
+	}
+	parentCol.Indexes.Reindex(docname, victim.Info.Doc, nil)
+	write := &transaction.Write{
+		Status: http.StatusNoContent,
+		Undo: func() {
+			parentCol.Indexes.Reindex(docname, nil, victim.Info.Doc)
+		},
+		Commit: func() {
+			parentCol.Docs.Remove(docname)
+		},
+		Notify: func() {
+			victim.Notify(dbName+"/"+docpath, []byte("/"+docpath), "delete")
+			colmsg, _ := json.Marshal("/" + docpath)
+			parentCol.SubscriptionManager.Notify(docname, "delete", colmsg)
+		},
+	}
+	return write, nil, http.StatusNoContent
+}
@@ -0,0 +1,35 @@
+package document
+
+import (
+	"context"
+	"strings"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/fieldIndex"
+)
+
+// Walk visits d and every collection and document beneath it, parents before children. visitDoc receives the path
+// of each document (relative to its database) and its serialized form along with its history; visitCol receives
+// the path of each collection and the definitions of its secondary indices.
+// Returns an error if ctx expires before the walk completes
+func (d *Document) Walk(ctx context.Context, visitCol func(colpath string, indexes []fieldIndex.Definition), visitDoc func(docpath string, serial []byte)) error {
+	docpath := strings.TrimPrefix(d.Info.Path, "/")
+	visitDoc(docpath, d.storedSerial())
+
+	cols, err := d.collections.Query(ctx, string(rune(0)), string(rune(127)))
+	if err != nil {
+		return err
+	}
+	for _, col := range cols {
+		visitCol(docpath+"/"+col.Key, col.Value.Indexes.Definitions())
+		docs, err := col.Value.Docs.Query(ctx, string(rune(0)), string(rune(127)))
+		if err != nil {
+			return err
+		}
+		for _, child := range docs {
+			if err = child.Value.Walk(ctx, visitCol, visitDoc); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
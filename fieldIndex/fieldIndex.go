@@ -0,0 +1,149 @@
+// Package fieldIndex maintains secondary indices over the documents of a collection. An index maps the value every
+// document holds at a field, named by a JSON pointer, to the names of the documents holding it, so that looking
+// documents up by the value of a field does not require scanning the whole collection.
+package fieldIndex
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/concurrentSkipList"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/patcher"
+)
+
+// Definition describes a secondary index
+type Definition struct {
+	Field  string `json:"field"`            // a JSON pointer to the indexed field
+	Unique bool   `json:"unique,omitempty"` // whether no two documents of the collection may hold the same value there
+}
+
+// ErrDuplicate is returned when a write would give two documents the same value at a field with a unique index
+var ErrDuplicate = errors.New("duplicate value")
+
+// separator ends the value part of the keys of an index; encoded values never contain it
+const separator = "\x00"
+
+// index maps the values of a field to the names of the documents holding them. Only scalar values (strings,
+// numbers, booleans and null) are indexed; documents lacking the field, or holding an array or an object there,
+// are left out.
+type index struct {
+	def Definition // the definition the index was created from
+
+	field []string // the segments of the indexed field
+
+	entries *concurrentSkipList.Skiplist[string, string] // document names, keyed by the encoded value they hold, separator and the name itself
+}
+
+// newIndex creates an empty index from its definition.
+// Returns the index, or an error if the field of def is not a JSON pointer below the document root
+func newIndex(def Definition) (*index, error) {
+	field, err := patcher.SplitJSONPointer(def.Field)
+	if err != nil || len(field) == 0 {
+		return nil, fmt.Errorf("bad index field '%s': expected a JSON pointer below the document root", def.Field)
+	}
+	return &index{
+		def:     Definition{Field: joinPointer(field), Unique: def.Unique},
+		field:   field,
+		entries: concurrentSkipList.NewSL[string, string](string(rune(0)), string(rune(127))),
+	}, nil
+}
+
+// keyOf returns the key the document whose decoded contents are body is indexed by.
+// Returns false if the document is not indexed
+func (ix *index) keyOf(body any) (string, bool) {
+	return encode(lookup(body, ix.field))
+}
+
+// add indexes the document name under key
+func (ix *index) add(name string, key string) {
+	ix.entries.Upsert(key+separator+name, func(string, string, bool) (string, error) {
+		return name, nil
+	})
+}
+
+// remove removes the document name, indexed under key, from the index
+func (ix *index) remove(name string, key string) {
+	ix.entries.Remove(key + separator + name)
+}
+
+// heldByOther returns whether a document other than name is indexed under key
+func (ix *index) heldByOther(name string, key string) bool {
+	res, _ := ix.entries.Query(context.Background(), key+separator, key+string(rune(1)))
+	for _, pair := range res {
+		if pair.Value != name {
+			return true
+		}
+	}
+	return false
+}
+
+// find returns the names of the documents holding value at the indexed field, in increasing order.
+// Returns false if value cannot be indexed, or if ctx expires before the lookup completes
+func (ix *index) find(ctx context.Context, value any) ([]string, bool) {
+	key, ok := encode(value, true)
+	if !ok {
+		return nil, false
+	}
+	// no name is smaller than the empty one, and every name of the value is smaller than the key that follows it
+	res, err := ix.entries.Query(ctx, key+separator, key+string(rune(1)))
+	if err != nil {
+		return nil, false
+	}
+	names := make([]string, len(res))
+	for i, pair := range res {
+		names[i] = pair.Value
+	}
+	return names, true
+}
+
+// encode returns the key a scalar value is indexed by; values equal to one another share the same key.
+// Returns false if the value is missing (found is false) or is not a scalar
+func encode(value any, found bool) (string, bool) {
+	if !found {
+		return "", false
+	}
+	switch v := value.(type) {
+	case nil:
+		return "null", true
+	case bool:
+		return strconv.FormatBool(v), true
+	case float64:
+		if v == 0 { //-0 equals 0
+			v = math.Abs(v)
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64), true
+	case string:
+		b, _ := json.Marshal(v)
+		return string(b), true
+	}
+	return "", false
+}
+
+// lookup returns the value at the field made of segments within body, a document decoded by encoding/json.
+// Returns false if the document holds no such value
+func lookup(body any, segments []string) (any, bool) {
+	cur := body
+	for _, seg := range segments {
+		switch v := cur.(type) {
+		case map[string]any:
+			child, found := v[seg]
+			if !found {
+				return nil, false
+			}
+			cur = child
+		case []any:
+			i, err := strconv.Atoi(seg)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			cur = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return cur, true
+}
@@ -0,0 +1,145 @@
+package fieldIndex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/query"
+)
+
+// lookupNames runs a filtered lookup on set, failing the test if the filter is malformed
+func lookupNames(t *testing.T, set *Set, expr string) ([]string, bool) {
+	filter, err := query.ParseFilter(expr)
+	if err != nil {
+		t.Fatalf("bad test filter %s", expr)
+	}
+	return set.Lookup(context.Background(), filter)
+}
+
+func TestSet_Lookup(t *testing.T) {
+	set := NewSet()
+	if created, err := set.Create(Definition{Field: "/email"}); !created || err != nil {
+		t.Fatalf("TestSet_Lookup failed, unable to create index: %t, %v", created, err)
+	}
+	set.Create(Definition{Field: "/address/city"})
+	set.Update("ada", nil, []byte(`{"email":"a@x.com","address":{"city":"Houston"}}`))
+	set.Update("bob", nil, []byte(`{"email":"b@x.com","address":{"city":"Houston"}}`))
+	set.Update("cy", nil, []byte(`{"email":["not","scalar"],"address":{"city":"Austin"}}`))
+	set.Update("dee", nil, []byte(`{"age":3}`))
+
+	tests := []struct {
+		expr    string
+		want    []string
+		indexed bool
+	}{
+		{`email = "a@x.com"`, []string{"ada"}, true},
+		{`address.city = "Houston"`, []string{"ada", "bob"}, true},
+		{`address.city = "Houston" AND email = "b@x.com"`, []string{"bob"}, true},
+		{`email = "nobody@x.com"`, []string{}, true},
+		{`age = 3`, nil, false},
+		{`email != "a@x.com"`, nil, false},
+		{`email = "a@x.com" OR age = 3`, nil, false},
+	}
+	for _, test := range tests {
+		got, indexed := lookupNames(t, set, test.expr)
+		if indexed != test.indexed || (indexed && !slices.Equal(got, test.want)) {
+			t.Errorf("TestSet_Lookup failed for %s, expected %v (%t), got %v (%t)", test.expr, test.want, test.indexed, got, indexed)
+		}
+	}
+}
+
+func TestSet_Update(t *testing.T) {
+	set := NewSet()
+	set.Create(Definition{Field: "/n"})
+	set.Update("a", nil, []byte(`{"n":1}`))
+	set.Update("a", []byte(`{"n":1}`), []byte(`{"n":2}`))
+	if got, _ := lookupNames(t, set, `n = 1`); len(got) != 0 {
+		t.Errorf("TestSet_Update failed, expected the old value to be unindexed, got %v", got)
+	}
+	if got, _ := lookupNames(t, set, `n = 2.0`); !slices.Equal(got, []string{"a"}) {
+		t.Errorf("TestSet_Update failed, expected equal numbers to share a key, got %v", got)
+	}
+	set.Update("a", []byte(`{"n":2}`), nil)
+	if got, _ := lookupNames(t, set, `n = 2`); len(got) != 0 {
+		t.Errorf("TestSet_Update failed, expected a deleted document to be unindexed, got %v", got)
+	}
+}
+
+func TestSet_Definitions(t *testing.T) {
+	set := NewSet()
+	if _, err := set.Create(Definition{Field: "email"}); err == nil {
+		t.Errorf("TestSet_Definitions failed, expected a malformed pointer to be rejected")
+	}
+	if _, err := set.Create(Definition{Field: ""}); err == nil {
+		t.Errorf("TestSet_Definitions failed, expected the document root to be rejected")
+	}
+	set.Create(Definition{Field: "/b"})
+	set.Create(Definition{Field: "/a~1c"})
+	if created, _ := set.Create(Definition{Field: "/b"}); created {
+		t.Errorf("TestSet_Definitions failed, expected a duplicate index not to be created")
+	}
+	if defs := set.Definitions(); !slices.Equal(defs, []Definition{{Field: "/a~1c"}, {Field: "/b"}}) {
+		t.Errorf("TestSet_Definitions failed, got %v", defs)
+	}
+	if !set.Drop("/b") || set.Drop("/b") || len(set.Definitions()) != 1 {
+		t.Errorf("TestSet_Definitions failed, expected /b to be dropped once")
+	}
+}
+
+func TestSet_Unique(t *testing.T) {
+	set := NewSet()
+	set.Create(Definition{Field: "/username", Unique: true})
+	set.Create(Definition{Field: "/age"})
+	if err := set.Update("a", nil, []byte(`{"username":"ann","age":30}`)); err != nil {
+		t.Fatalf("TestSet_Unique failed, unexpected error %v", err)
+	}
+	if err := set.Update("b", nil, []byte(`{"username":"ann","age":40}`)); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("TestSet_Unique failed, expected a duplicate to be rejected, got %v", err)
+	}
+	if got, _ := lookupNames(t, set, `age = 40`); len(got) != 0 {
+		t.Errorf("TestSet_Unique failed, expected a rejected update to leave every index untouched, got %v", got)
+	}
+	//a document may keep its own value, and values of fields without a unique index may repeat
+	if err := set.Update("a", []byte(`{"username":"ann","age":30}`), []byte(`{"username":"ann","age":31}`)); err != nil {
+		t.Errorf("TestSet_Unique failed, unexpected error %v", err)
+	}
+	if err := set.Update("b", nil, []byte(`{"username":"bo","age":31}`)); err != nil {
+		t.Errorf("TestSet_Unique failed, unexpected error %v", err)
+	}
+	//a value is released once its document lets go of it
+	set.Update("a", []byte(`{"username":"ann","age":31}`), nil)
+	if err := set.Update("b", []byte(`{"username":"bo","age":31}`), []byte(`{"username":"ann","age":31}`)); err != nil {
+		t.Errorf("TestSet_Unique failed, unexpected error %v", err)
+	}
+	set.Reindex("c", nil, []byte(`{"username":"ann"}`))
+	if got, _ := lookupNames(t, set, `username = "ann"`); !slices.Equal(got, []string{"b", "c"}) {
+		t.Errorf("TestSet_Unique failed, expected Reindex not to enforce uniqueness, got %v", got)
+	}
+}
+
+func TestSet_UniqueConcurrent(t *testing.T) {
+	set := NewSet()
+	set.Create(Definition{Field: "/username", Unique: true})
+	var wg sync.WaitGroup
+	var mtx sync.Mutex
+	accepted := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if set.Update(fmt.Sprintf("doc%d", i), nil, []byte(`{"username":"ann"}`)) == nil {
+				mtx.Lock()
+				accepted++
+				mtx.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	if got, _ := lookupNames(t, set, `username = "ann"`); accepted != 1 || len(got) != 1 {
+		t.Errorf("TestSet_UniqueConcurrent failed, expected exactly one document to claim the value, got %d and %v", accepted, got)
+	}
+}
@@ -0,0 +1,201 @@
+package fieldIndex
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/patcher"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/query"
+)
+
+// Set holds the indices of a single collection. It is safe for concurrent use.
+type Set struct {
+	mtx sync.RWMutex // guards indices; the indices themselves are safe for concurrent use
+
+	claimMtx sync.Mutex // serializes updates while a unique index exists, so that two documents never claim the same value
+
+	indices map[string]*index // keyed by the JSON pointer of the indexed field
+}
+
+// NewSet creates a set holding no index
+func NewSet() *Set {
+	return &Set{indices: map[string]*index{}}
+}
+
+// Create adds the index described by def. Documents written after Create returns are indexed through Update;
+// those the collection already holds must then be passed to Update by the caller.
+// Returns true if the index was created, false if the field was already indexed, or an error if def is malformed
+func (s *Set) Create(def Definition) (bool, error) {
+	ix, err := newIndex(def)
+	if err != nil {
+		return false, err
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if _, found := s.indices[ix.def.Field]; found {
+		return false, nil
+	}
+	s.indices[ix.def.Field] = ix
+	return true, nil
+}
+
+// Drop removes the index on the field named by the JSON pointer field.
+// Returns whether there was such an index
+func (s *Set) Drop(field string) bool {
+	segments, err := patcher.SplitJSONPointer(field)
+	if err != nil {
+		return false
+	}
+	field = joinPointer(segments)
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	_, found := s.indices[field]
+	delete(s.indices, field)
+	return found
+}
+
+// Definitions returns the definitions of every index, ordered by field
+func (s *Set) Definitions() []Definition {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	defs := make([]Definition, 0, len(s.indices))
+	for _, ix := range s.indices {
+		defs = append(defs, ix.def)
+	}
+	slices.SortFunc(defs, func(a, b Definition) int {
+		return strings.Compare(a.Field, b.Field)
+	})
+	return defs
+}
+
+// Update reindexes the document name, whose contents change from oldDoc to newDoc. A nil oldDoc stands for a
+// document being created, and a nil newDoc for one being deleted. Calls for the same document must not race.
+// Returns an error wrapping ErrDuplicate, leaving every index untouched, if newDoc holds the value another document
+// holds at a field with a unique index
+func (s *Set) Update(name string, oldDoc []byte, newDoc []byte) error {
+	return s.update(name, oldDoc, newDoc, true)
+}
+
+// Reindex reindexes the document name like Update, but without enforcing unique indices. It is meant for rebuilding
+// state that was consistent when it was first written.
+func (s *Set) Reindex(name string, oldDoc []byte, newDoc []byte) {
+	s.update(name, oldDoc, newDoc, false)
+}
+
+// update reindexes the document name, whose contents change from oldDoc to newDoc, enforcing unique indices if
+// enforce is set.
+// Returns an error wrapping ErrDuplicate if a unique index is violated
+func (s *Set) update(name string, oldDoc []byte, newDoc []byte, enforce bool) error {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	if len(s.indices) == 0 {
+		return nil
+	}
+	for _, ix := range s.indices {
+		if ix.def.Unique {
+			s.claimMtx.Lock()
+			defer s.claimMtx.Unlock()
+			break
+		}
+	}
+	oldBody, hadOld := decode(oldDoc)
+	newBody, hasNew := decode(newDoc)
+
+	type change struct {
+		ix            *index
+		oldKey        string
+		newKey        string
+		remove, claim bool
+	}
+	changes := make([]change, 0, len(s.indices))
+	for _, ix := range s.indices {
+		oldKey, oldIndexed := ix.keyOf(oldBody)
+		newKey, newIndexed := ix.keyOf(newBody)
+		oldIndexed = oldIndexed && hadOld
+		newIndexed = newIndexed && hasNew
+		if oldIndexed && newIndexed && oldKey == newKey {
+			continue
+		}
+		if enforce && newIndexed && ix.def.Unique && ix.heldByOther(name, newKey) {
+			return fmt.Errorf("%w at unique field '%s'", ErrDuplicate, ix.def.Field)
+		}
+		changes = append(changes, change{ix: ix, oldKey: oldKey, newKey: newKey, remove: oldIndexed, claim: newIndexed})
+	}
+	for _, c := range changes {
+		if c.remove {
+			c.ix.remove(name, c.oldKey)
+		}
+		if c.claim {
+			c.ix.add(name, c.newKey)
+		}
+	}
+	return nil
+}
+
+// Lookup narrows down the documents that may satisfy filter using the indices on the fields it requires to equal
+// a value. The documents returned must still be matched against filter.
+// Returns the names of those documents in increasing order, or false if no index applies to filter
+func (s *Set) Lookup(ctx context.Context, filter *query.Filter) ([]string, bool) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	var res []string
+	indexed := false
+	for _, eq := range filter.Equalities() {
+		ix, found := s.indices[joinPointer(eq.Field)]
+		if !found {
+			continue
+		}
+		names, ok := ix.find(ctx, eq.Value)
+		if !ok {
+			continue
+		}
+		if indexed {
+			res = intersect(res, names)
+		} else {
+			res, indexed = names, true
+		}
+	}
+	return res, indexed
+}
+
+// decode parses the contents of a document.
+// Returns false if there is no document
+func decode(doc []byte) (any, bool) {
+	if doc == nil {
+		return nil, false
+	}
+	var body any
+	json.Unmarshal(doc, &body)
+	return body, true
+}
+
+// joinPointer builds the JSON pointer made of segments
+func joinPointer(segments []string) string {
+	var b strings.Builder
+	for _, seg := range segments {
+		b.WriteString("/" + patcher.EscapeJSONPointer(seg))
+	}
+	return b.String()
+}
+
+// intersect returns the names found in both a and b, both in increasing order
+func intersect(a []string, b []string) []string {
+	res := make([]string, 0, min(len(a), len(b)))
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch strings.Compare(a[i], b[j]) {
+		case -1:
+			i++
+		case 1:
+			j++
+		default:
+			res = append(res, a[i])
+			i++
+			j++
+		}
+	}
+	return res
+}
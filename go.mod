@@ -3,3 +3,5 @@ module github.com/RICE-COMP318-FALL24/owldb-p1group24
 go 1.23.0
 
 require github.com/santhosh-tekuri/jsonschema/v5 v5.3.1
+
+require golang.org/x/crypto v0.36.0
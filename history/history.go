@@ -0,0 +1,97 @@
+// Package history describes the versions OwlDB keeps of its documents: every write moves the contents it replaces
+// into a bounded history, from which earlier versions can be listed, read back and restored.
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// Limit is the number of earlier versions kept for each document; older versions are forgotten
+const Limit = 32
+
+// Revision is a version of a document
+type Revision struct {
+	Version        int64           `json:"version"`        // the version, as found in the document's metadata
+	LastModifiedBy string          `json:"lastModifiedBy"` // the user who wrote the version
+	LastModifiedAt int64           `json:"lastModifiedAt"` // the time the version was written, in Unix milliseconds
+	Doc            json.RawMessage `json:"doc,omitempty"`  // the contents of the document at that version
+}
+
+// Append records rev as the most recent earlier version in revs, forgetting the oldest versions beyond Limit.
+// Returns the new history
+func Append(revs []Revision, rev Revision) []Revision {
+	revs = append(revs, rev)
+	if len(revs) > Limit {
+		revs = revs[len(revs)-Limit:]
+	}
+	return revs
+}
+
+// Truncate forgets every version in revs numbered version or later, for a document rolled back to version.
+// Returns the new history
+func Truncate(revs []Revision, version int64) []Revision {
+	for i, rev := range revs {
+		if rev.Version >= version {
+			return revs[:i]
+		}
+	}
+	return revs
+}
+
+// Selector picks a version out of the history of a document. The zero Selector picks none: it asks for the history
+// itself.
+type Selector struct {
+	Version int64 // picks the version numbered Version, if positive
+	AsOf    int64 // picks the version that was current at the Unix time AsOf, in milliseconds, if positive
+}
+
+// ParseSelector reads a selector from the version and asOf query parameters.
+// Returns the selector, or an error if a parameter is malformed or both are given
+func ParseSelector(qs url.Values) (Selector, error) {
+	var sel Selector
+	if qs.Has("version") && qs.Has("asOf") {
+		return sel, fmt.Errorf("version and asOf cannot be combined")
+	}
+	if qs.Has("version") {
+		version, err := strconv.ParseInt(qs.Get("version"), 10, 64)
+		if err != nil || version < 1 {
+			return sel, fmt.Errorf("malformed version parameter")
+		}
+		sel.Version = version
+	}
+	if qs.Has("asOf") {
+		asOf, err := strconv.ParseInt(qs.Get("asOf"), 10, 64)
+		if err != nil || asOf < 1 {
+			return sel, fmt.Errorf("malformed asOf parameter")
+		}
+		sel.AsOf = asOf
+	}
+	return sel, nil
+}
+
+// IsZero reports whether the selector picks no version
+func (s Selector) IsZero() bool {
+	return s.Version == 0 && s.AsOf == 0
+}
+
+// Select picks a version out of revs, a history ordered from the oldest version to the current one.
+// Returns the version, or an error if it is not kept
+func (s Selector) Select(revs []Revision) (Revision, error) {
+	if s.Version > 0 {
+		for _, rev := range revs {
+			if rev.Version == s.Version {
+				return rev, nil
+			}
+		}
+		return Revision{}, fmt.Errorf("version %d is not kept", s.Version)
+	}
+	for i := len(revs) - 1; i >= 0; i-- {
+		if revs[i].LastModifiedAt <= s.AsOf {
+			return revs[i], nil
+		}
+	}
+	return Revision{}, fmt.Errorf("no version kept was current at %d", s.AsOf)
+}
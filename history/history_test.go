@@ -0,0 +1,74 @@
+package history
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestAppend(t *testing.T) {
+	var revs []Revision
+	for v := int64(1); v <= Limit+5; v++ {
+		revs = Append(revs, Revision{Version: v})
+	}
+	if len(revs) != Limit || revs[0].Version != 6 || revs[Limit-1].Version != Limit+5 {
+		t.Errorf("TestAppend failed, expected the %d most recent versions, got %d starting at %d", Limit, len(revs), revs[0].Version)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	revs := []Revision{{Version: 1}, {Version: 2}, {Version: 3}}
+	if got := Truncate(revs, 2); len(got) != 1 || got[0].Version != 1 {
+		t.Errorf("TestTruncate failed, got %+v", got)
+	}
+	if got := Truncate(revs, 4); len(got) != 3 {
+		t.Errorf("TestTruncate failed, expected nothing to be forgotten, got %+v", got)
+	}
+}
+
+func TestSelect(t *testing.T) {
+	revs := []Revision{{Version: 2, LastModifiedAt: 100}, {Version: 3, LastModifiedAt: 200}, {Version: 4, LastModifiedAt: 300}}
+	tests := []struct {
+		name    string
+		sel     Selector
+		version int64
+		found   bool
+	}{
+		{"version", Selector{Version: 3}, 3, true},
+		{"forgotten version", Selector{Version: 1}, 0, false},
+		{"exact time", Selector{AsOf: 200}, 3, true},
+		{"between versions", Selector{AsOf: 299}, 3, true},
+		{"after the current version", Selector{AsOf: 1000}, 4, true},
+		{"before the oldest version", Selector{AsOf: 99}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rev, err := tt.sel.Select(revs)
+			if (err == nil) != tt.found || rev.Version != tt.version {
+				t.Errorf("expected version %d (found %t), got %d and %v", tt.version, tt.found, rev.Version, err)
+			}
+		})
+	}
+}
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		query string
+		sel   Selector
+		ok    bool
+	}{
+		{"history", Selector{}, true},
+		{"version=3", Selector{Version: 3}, true},
+		{"asOf=1700000000000", Selector{AsOf: 1700000000000}, true},
+		{"version=0", Selector{}, false},
+		{"version=x", Selector{}, false},
+		{"asOf=-1", Selector{}, false},
+		{"version=1&asOf=1", Selector{}, false},
+	}
+	for _, tt := range tests {
+		qs, _ := url.ParseQuery(tt.query)
+		sel, err := ParseSelector(qs)
+		if (err == nil) != tt.ok || (tt.ok && sel != tt.sel) {
+			t.Errorf("TestParseSelector failed for %s, got %+v and %v", tt.query, sel, err)
+		}
+	}
+}
@@ -12,3 +12,6 @@ type Pair[K cmp.Ordered, V any] struct {
 
 // UpdateCheck is a function signature utilized by the skiplist, determining whether to update or insert a value based how UpdateCheck is implemented
 type UpdateCheck[K cmp.Ordered, V any] func(curKey K, curVal V, exists bool) (newVal V, err error)
+
+// RemoveCheck is a function signature utilized by the skiplist, determining whether to remove an existing value based on how RemoveCheck is implemented
+type RemoveCheck[K cmp.Ordered, V any] func(curKey K, curVal V) error
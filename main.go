@@ -4,35 +4,57 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"syscall"
+	"time"
+
 	"github.com/RICE-COMP318-FALL24/owldb-p1group24/auth"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/changes"
 	"github.com/RICE-COMP318-FALL24/owldb-p1group24/concurrentSkipList"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/db"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/diskIndex"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/document"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/fieldIndex"
 	"github.com/RICE-COMP318-FALL24/owldb-p1group24/logger"
 	"github.com/RICE-COMP318-FALL24/owldb-p1group24/patcher"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/persistence"
 	"github.com/RICE-COMP318-FALL24/owldb-p1group24/resourceCreatorService"
 	"github.com/RICE-COMP318-FALL24/owldb-p1group24/resourceDeleterService"
 	"github.com/RICE-COMP318-FALL24/owldb-p1group24/resourceGetterService"
 	"github.com/RICE-COMP318-FALL24/owldb-p1group24/resourcePatcherService"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/server"
 	"github.com/RICE-COMP318-FALL24/owldb-p1group24/subscriptionManager"
 	"github.com/RICE-COMP318-FALL24/owldb-p1group24/validation"
-	"log/slog"
-	"net/http"
-	"os"
-	"os/signal"
-	"syscall"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/webhook"
+)
 
-	"github.com/RICE-COMP318-FALL24/owldb-p1group24/db"
+// adminPasswordEnv is the environment variable holding the password of the administrator named by -admin, kept
+// off the command line so that it does not show up in the process list
+const adminPasswordEnv = "OWLDB_ADMIN_PASSWORD"
 
-	"github.com/RICE-COMP318-FALL24/owldb-p1group24/document"
-	"github.com/RICE-COMP318-FALL24/owldb-p1group24/server"
-)
+// defaultAdmin names the administrator made on startup when only its password is given
+const defaultAdmin = "admin"
 
 func main() {
 	var srv http.Server
 	var port int
 	var schema string
 	var tokens string
+	var dataDir string
+	var snapshotInterval time.Duration
+	var snapshotRetain int
+	var backendSpec string
+	var subscriberQueue int
+	var subscriberPolicy string
+	var admin string
+	var tokenRole string
 	var err error
 
 	// Parse command-line flags for port, schema, and tokens
@@ -43,6 +65,22 @@ func main() {
 	flag.StringVar(&schema, "s", "", "document schema")
 
 	flag.StringVar(&tokens, "t", "", "tokens")
+
+	flag.StringVar(&tokenRole, "token-role", "none", "role held everywhere by the users of the token file: none, reader, writer or admin")
+
+	flag.StringVar(&dataDir, "d", "", "data directory")
+
+	flag.DurationVar(&snapshotInterval, "snapshot-interval", 5*time.Minute, "time between snapshots of the data directory (0 disables snapshots)")
+
+	flag.IntVar(&snapshotRetain, "snapshot-retain", 2, "number of snapshots to keep in the data directory")
+
+	flag.StringVar(&backendSpec, "b", backendMemory, "index backend: memory or disk, optionally followed by per-database overrides (e.g. memory,archive=disk)")
+
+	flag.IntVar(&subscriberQueue, "subscriber-queue", subscriptionManager.DefaultDepth, "number of events queued for each subscriber")
+
+	flag.StringVar(&subscriberPolicy, "subscriber-policy", "drop-oldest", "what to do when a subscriber's queue is full: drop-oldest or disconnect")
+
+	flag.StringVar(&admin, "admin", "", "administrator account created on startup; its password is read from "+adminPasswordEnv+" (default "+defaultAdmin+" if only the password is set)")
 	flag.Parse()
 
 	// Initialize logging options
@@ -65,6 +103,52 @@ func main() {
 		fmt.Printf("Error: Bad schema file\n")
 		os.Exit(1)
 	}
+	dbBackends, err := parseBackends(backendSpec)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+	policy, err := subscriptionManager.ParsePolicy(subscriberPolicy)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+	if subscriberQueue < 1 {
+		fmt.Printf("Error: the subscriber queue must hold at least one event\n")
+		os.Exit(1)
+	}
+	role, err := auth.ParseRole(tokenRole)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+	delivery := subscriptionManager.Delivery{Depth: subscriberQueue, Policy: policy, Metrics: &subscriptionManager.Metrics{}}
+	if dbBackends.usesDisk() && dataDir == "" {
+		fmt.Printf("Error: the disk backend requires a data directory. Use -d <data-dir>\n")
+		os.Exit(1)
+	}
+
+	// Open the write-ahead log if a data directory was given; otherwise nothing is persisted
+	var journal persistence.Journal = persistence.Discard{}
+	var wal *persistence.WAL
+	if dataDir != "" {
+		wal, err = persistence.Open(dataDir)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err.Error())
+			os.Exit(1)
+		}
+		journal = wal
+	}
+	// Every mutation is also appended to the change feed of its database, whose history is rebuilt from the log
+	feed := changes.New(journal, subscriberQueue)
+	journal = feed
+	if dbBackends.usesDisk() {
+		if err = diskIndex.Clean(indexDir(dataDir)); err != nil {
+			fmt.Printf("Error: %s\n", err.Error())
+			os.Exit(1)
+		}
+	}
+
 	// Dependency injection and factory initialization
 	var docColFactory document.DocumentIndexFactory[document.DocumentIndex[string, *document.Collection]]
 
@@ -72,17 +156,18 @@ func main() {
 
 	var docFactory db.DocFactory[*document.Document]
 	var newerColFactory document.CollectionFactory
-	newerColFactory = func(colName string) *document.Collection {
+	newerColFactory = func(colpath string) *document.Collection {
 		return &document.Collection{
-			Name:                colName,
+			Name:                path.Base(colpath),
 			Docs:                concurrentSkipList.NewSL[string, *document.Document](string(rune(0)), string(rune(127))),
-			SubscriptionManager: subscriptionManager.NewColSubManager(concurrentSkipList.NewSL[string, subscriptionManager.Colsubscriber](string(rune(0)), string(rune(127)))),
+			SubscriptionManager: subscriptionManager.NewColSubManager(concurrentSkipList.NewSL[string, subscriptionManager.Colsubscriber](string(rune(0)), string(rune(127))), delivery),
+			Indexes:             fieldIndex.NewSet(),
 		}
 	}
 
 	var smFactory document.SubscriptionManagerFactory = func() document.SubscriptionManager {
 		subs := concurrentSkipList.NewSL[string, *chan []byte](string(rune(0)), string(rune(127)))
-		return subscriptionManager.New(subs)
+		return subscriptionManager.New(subs, delivery)
 	}
 
 	var idtosubfactory subscriptionManager.IdToSubFactory = func() subscriptionManager.IdToSub[string, *chan []byte] {
@@ -90,22 +175,36 @@ func main() {
 	}
 
 	docSubs := concurrentSkipList.NewSL[string, *subscriptionManager.SubscriptionManager](string(rune(0)), string(rune(127)))
-	messager := subscriptionManager.NewMessager(idtosubfactory, docSubs)
+	treeSubs := concurrentSkipList.NewSL[string, *subscriptionManager.SubscriptionManager](string(rune(0)), string(rune(127)))
+	// Load the webhooks, kept alongside the databases
+	hooks, err := webhook.OpenRegistry(dataDir, webhook.DefaultRetry)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+	messager := subscriptionManager.NewMessager(idtosubfactory, docSubs, treeSubs, delivery, hooks)
 	docFactory = func(payload []byte, user string, path string) *document.Document {
 
-		newDoc := document.New(payload, user, path, docColFactory, newerColFactory, smFactory, validator, patcher.Patcher{}, messager)
+		newDoc := document.New(payload, user, path, docColFactory, newerColFactory, smFactory, validator, patcher.Patcher{}, messager, journal)
 		return newDoc
 	}
 
-	docColFactory = func() document.DocumentIndex[string, *document.Collection] {
+	docColFactory = func(string) document.DocumentIndex[string, *document.Collection] {
 		newCollections := concurrentSkipList.NewSL[string, *document.Collection](string(rune(0)), string(rune(127)))
 		return newCollections
 	}
 
 	dbFactory = func(name string) *db.Database[string, *document.Document] {
+		if dbBackends.For(name) == backendDisk {
+			diskDB, err := newDiskDatabase(name, dataDir, validator, smFactory, messager, journal, delivery)
+			if err == nil {
+				return diskDB
+			}
+			slog.Error("Unable to create disk-backed database, keeping it in memory", "db", name, "error", err)
+		}
 		newDBIndices := concurrentSkipList.NewSL[string, *document.Document](string(rune(0)), string(rune(127)))
-		sm := subscriptionManager.NewColSubManager(concurrentSkipList.NewSL[string, subscriptionManager.Colsubscriber](string(rune(0)), string(rune(127))))
-		return db.New[string, *document.Document](name, docFactory, newDBIndices, sm, validator)
+		sm := subscriptionManager.NewColSubManager(concurrentSkipList.NewSL[string, subscriptionManager.Colsubscriber](string(rune(0)), string(rune(127))), delivery)
+		return db.New[string, *document.Document](name, docFactory, newDBIndices, sm, fieldIndex.NewSet(), messager, validator, journal)
 
 	}
 	//FOR CRUD OPERATIONS
@@ -115,19 +214,56 @@ func main() {
 	var rgsDB resourceGetterService.DatabaseIndex[string, *db.Database[string, *document.Document]] = dbs
 	var rdsDB resourceDeleterService.DatabaseIndex[string, *db.Database[string, *document.Document]] = dbs
 	var rpsDB resourcePatcherService.DatabaseIndex[string, *db.Database[string, *document.Document]] = dbs
-	rcs := resourceCreatorService.New(rcsDB, dbFactory, validator)
+	rcs := resourceCreatorService.New(rcsDB, dbFactory, validator, journal)
 	rgs := resourceGetterService.New(rgsDB)
-	rds := resourceDeleterService.New(rdsDB)
+	rds := resourceDeleterService.New(rdsDB, journal)
 	rps := resourcePatcherService.New(rpsDB)
 
+	// Rebuild the databases from the write-ahead log
+	if wal != nil {
+		err = wal.Replay(rcs, rds)
+		if err != nil {
+			fmt.Printf("Error: unable to replay the write-ahead log: %s\n", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	// Periodically snapshot the databases so the write-ahead log can be compacted
+	snapshotCtx, stopSnapshots := context.WithCancel(context.Background())
+	if wal != nil && snapshotInterval > 0 {
+		go wal.RunSnapshots(snapshotCtx, rgs, snapshotInterval, snapshotRetain)
+	}
+
 	// Initialize authentication services
 
 	var tokenMap auth.TokenIndex[string, auth.Session] = concurrentSkipList.NewSL[string, auth.Session](string(rune(0)), string(rune(127)))
 
 	authService := auth.New(tokenMap, tokens)
 
+	// Load the accounts allowed to log in, kept alongside the databases
+	users, err := auth.OpenCredentials(dataDir, auth.DefaultCost, authService)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+	users.SetTokenRole(role)
+	if admin == "" && os.Getenv(adminPasswordEnv) != "" {
+		admin = defaultAdmin
+	}
+	if admin != "" {
+		if err = users.Bootstrap(admin, os.Getenv(adminPasswordEnv)); err != nil {
+			fmt.Printf("Error: unable to create the administrator: %s\n", err.Error())
+			os.Exit(1)
+		}
+	}
+	// Only an administrator can create accounts, so without one no user could ever log in with a password
+	if !users.HasAdmin() {
+		fmt.Printf("Error: no administrator account exists. Use -admin <username> with its password in %s\n", adminPasswordEnv)
+		os.Exit(1)
+	}
+
 	// Initialize the server handler
-	handler := server.New(rds, rgs, rcs, authService, rps)
+	handler := server.New(rds, rgs, rcs, authService, rps, delivery.Metrics, hooks, feed, users)
 	srv.Handler = handler
 	srv.Addr = fmt.Sprintf(":%d", port)
 
@@ -155,5 +291,15 @@ func main() {
 		slog.Info("Server closed", "error", err)
 	}
 
+	stopSnapshots()
+	if err = hooks.Close(); err != nil {
+		slog.Error("Unable to save the undelivered webhook events", "error", err)
+	}
+	if wal != nil {
+		if err = wal.Close(); err != nil {
+			slog.Error("Unable to close the write-ahead log", "error", err)
+		}
+	}
+
 	slog.Info("Server closed")
 }
@@ -1,22 +1,31 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/RICE-COMP318-FALL24/owldb-p1group24/auth"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/changes"
 	"github.com/RICE-COMP318-FALL24/owldb-p1group24/concurrentSkipList"
 	"github.com/RICE-COMP318-FALL24/owldb-p1group24/db"
 	"github.com/RICE-COMP318-FALL24/owldb-p1group24/document"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/fieldIndex"
 	"github.com/RICE-COMP318-FALL24/owldb-p1group24/patcher"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/persistence"
 	"github.com/RICE-COMP318-FALL24/owldb-p1group24/resourceCreatorService"
 	"github.com/RICE-COMP318-FALL24/owldb-p1group24/resourceDeleterService"
 	"github.com/RICE-COMP318-FALL24/owldb-p1group24/resourceGetterService"
@@ -24,15 +33,40 @@ import (
 	"github.com/RICE-COMP318-FALL24/owldb-p1group24/server"
 	"github.com/RICE-COMP318-FALL24/owldb-p1group24/subscriptionManager"
 	"github.com/RICE-COMP318-FALL24/owldb-p1group24/validation"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/webhook"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/websocket"
 )
 
+// webhookRetry retries the deliveries of webhooks quickly, so that tests do not wait long
+var webhookRetry = webhook.Retry{Attempts: 3, Backoff: 5 * time.Millisecond, MaxBackoff: 20 * time.Millisecond, Timeout: time.Second}
+
 func setup(schemaFile string) (http.Handler, error) {
+	handler, _, _, _ := setupWithJournal(schemaFile, persistence.Discard{})
+	return handler, nil
+}
+
+// setupWithJournal wires up the server exactly like setup, journaling every mutation to journal. It also returns the
+// services needed to replay a write-ahead log and to take snapshots.
+func setupWithJournal(schemaFile string, journal persistence.Journal) (http.Handler, persistence.Creator, persistence.Deleter, persistence.Source) {
+	return setupWithUsers(schemaFile, journal, func(users *auth.Credentials) {
+		users.Bootstrap("Fernando", "owl")
+		users.Create("TestUser", "hunter2", false)
+	})
+}
+
+// setupWithUsers wires up the server like setupWithJournal, letting accounts set up the accounts and roles in place
+// of the default ones
+func setupWithUsers(schemaFile string, journal persistence.Journal, accounts func(users *auth.Credentials)) (http.Handler, persistence.Creator, persistence.Deleter, persistence.Source) {
 	validator, err := validation.New(schemaFile)
 
 	if err != nil {
 		fmt.Printf("Error: Bad schema file\n")
 		os.Exit(1)
 	}
+	delivery := subscriptionManager.Delivery{Depth: subscriptionManager.DefaultDepth, Metrics: &subscriptionManager.Metrics{}}
+	feed := changes.New(journal, subscriptionManager.DefaultDepth)
+	journal = feed
+
 	// Dependency injection and factory initialization
 	var docColFactory document.DocumentIndexFactory[document.DocumentIndex[string, *document.Collection]]
 
@@ -40,17 +74,18 @@ func setup(schemaFile string) (http.Handler, error) {
 
 	var docFactory db.DocFactory[*document.Document]
 	var newerColFactory document.CollectionFactory
-	newerColFactory = func(colName string) *document.Collection {
+	newerColFactory = func(colpath string) *document.Collection {
 		return &document.Collection{
-			Name:                colName,
+			Name:                path.Base(colpath),
 			Docs:                concurrentSkipList.NewSL[string, *document.Document](string(rune(0)), string(rune(127))),
-			SubscriptionManager: subscriptionManager.NewColSubManager(concurrentSkipList.NewSL[string, subscriptionManager.Colsubscriber](string(rune(0)), string(rune(127)))),
+			SubscriptionManager: subscriptionManager.NewColSubManager(concurrentSkipList.NewSL[string, subscriptionManager.Colsubscriber](string(rune(0)), string(rune(127))), delivery),
+			Indexes:             fieldIndex.NewSet(),
 		}
 	}
 
 	var smFactory document.SubscriptionManagerFactory = func() document.SubscriptionManager {
 		subs := concurrentSkipList.NewSL[string, *chan []byte](string(rune(0)), string(rune(127)))
-		return subscriptionManager.New(subs)
+		return subscriptionManager.New(subs, delivery)
 	}
 
 	var idtosubfactory subscriptionManager.IdToSubFactory = func() subscriptionManager.IdToSub[string, *chan []byte] {
@@ -58,22 +93,24 @@ func setup(schemaFile string) (http.Handler, error) {
 	}
 
 	docSubs := concurrentSkipList.NewSL[string, *subscriptionManager.SubscriptionManager](string(rune(0)), string(rune(127)))
-	messager := subscriptionManager.NewMessager(idtosubfactory, docSubs)
+	treeSubs := concurrentSkipList.NewSL[string, *subscriptionManager.SubscriptionManager](string(rune(0)), string(rune(127)))
+	hooks := webhook.NewRegistry(webhookRetry)
+	messager := subscriptionManager.NewMessager(idtosubfactory, docSubs, treeSubs, delivery, hooks)
 	docFactory = func(payload []byte, user string, path string) *document.Document {
 
-		newDoc := document.New(payload, user, path, docColFactory, newerColFactory, smFactory, validator, patcher.Patcher{}, messager)
+		newDoc := document.New(payload, user, path, docColFactory, newerColFactory, smFactory, validator, patcher.Patcher{}, messager, journal)
 		return newDoc
 	}
 
-	docColFactory = func() document.DocumentIndex[string, *document.Collection] {
+	docColFactory = func(string) document.DocumentIndex[string, *document.Collection] {
 		newCollections := concurrentSkipList.NewSL[string, *document.Collection](string(rune(0)), string(rune(127)))
 		return newCollections
 	}
 
 	dbFactory = func(name string) *db.Database[string, *document.Document] {
 		newDBIndices := concurrentSkipList.NewSL[string, *document.Document](string(rune(0)), string(rune(127)))
-		sm := subscriptionManager.NewColSubManager(concurrentSkipList.NewSL[string, subscriptionManager.Colsubscriber](string(rune(0)), string(rune(127))))
-		return db.New[string, *document.Document](name, docFactory, newDBIndices, sm, validator)
+		sm := subscriptionManager.NewColSubManager(concurrentSkipList.NewSL[string, subscriptionManager.Colsubscriber](string(rune(0)), string(rune(127))), delivery)
+		return db.New[string, *document.Document](name, docFactory, newDBIndices, sm, fieldIndex.NewSet(), messager, validator, journal)
 
 	}
 	//FOR CRUD OPERATIONS
@@ -83,9 +120,9 @@ func setup(schemaFile string) (http.Handler, error) {
 	var rgsDB resourceGetterService.DatabaseIndex[string, *db.Database[string, *document.Document]] = dbs
 	var rdsDB resourceDeleterService.DatabaseIndex[string, *db.Database[string, *document.Document]] = dbs
 	var rpsDB resourcePatcherService.DatabaseIndex[string, *db.Database[string, *document.Document]] = dbs
-	rcs := resourceCreatorService.New(rcsDB, dbFactory, validator)
+	rcs := resourceCreatorService.New(rcsDB, dbFactory, validator, journal)
 	rgs := resourceGetterService.New(rgsDB)
-	rds := resourceDeleterService.New(rdsDB)
+	rds := resourceDeleterService.New(rdsDB, journal)
 	rps := resourcePatcherService.New(rpsDB)
 
 	// Initialize authentication services
@@ -93,10 +130,12 @@ func setup(schemaFile string) (http.Handler, error) {
 	var tokenMap auth.TokenIndex[string, auth.Session] = concurrentSkipList.NewSL[string, auth.Session](string(rune(0)), string(rune(127)))
 
 	authService := auth.New(tokenMap, "tokens.json")
+	users, _ := auth.OpenCredentials("", auth.MinCost, authService)
+	accounts(users)
 
 	// Initialize the server handler
-	handler := server.New(rds, rgs, rcs, authService, rps)
-	return handler, nil
+	handler := server.New(rds, rgs, rcs, authService, rps, delivery.Metrics, hooks, feed, users)
+	return handler, rcs, rds, rgs
 }
 
 type PutResponse struct {
@@ -1266,7 +1305,7 @@ func TestPostNestedCollection(t *testing.T) {
 // tests correct login
 func TestAuthLogin(t *testing.T) {
 	handler, _ := setup("Allschema.json")
-	data := strings.NewReader(`{ "username":"TestUser" }`)
+	data := strings.NewReader(`{ "username":"TestUser", "password":"hunter2" }`)
 	loginReq := httptest.NewRequest("POST", "/auth", data)
 	loginReq.Header.Set("accept", "application/json")
 	loginReq.Header.Set("Content-Type", "application/json")
@@ -1285,7 +1324,7 @@ type tokenResp struct {
 // tests correct logout
 func TestAuthLogout(t *testing.T) {
 	handler, _ := setup("Allschema.json")
-	data := strings.NewReader(`{ "username":"TestUser" }`)
+	data := strings.NewReader(`{ "username":"TestUser", "password":"hunter2" }`)
 	loginReq := httptest.NewRequest("POST", "/auth", data)
 	loginReq.Header.Set("accept", "application/json")
 	loginReq.Header.Set("Content-Type", "application/json")
@@ -1326,7 +1365,7 @@ func TestAuthLogout(t *testing.T) {
 // tests an attempt to logout with the same token twice
 func TestAuthDoubleLogout(t *testing.T) {
 	handler, _ := setup("Allschema.json")
-	data := strings.NewReader(`{ "username":"TestUser" }`)
+	data := strings.NewReader(`{ "username":"TestUser", "password":"hunter2" }`)
 	loginReq := httptest.NewRequest("POST", "/auth", data)
 	loginReq.Header.Set("accept", "application/json")
 	loginReq.Header.Set("Content-Type", "application/json")
@@ -1670,3 +1709,1512 @@ func TestPatchVisitorArrayAdd(t *testing.T) {
 		t.Errorf("Doc object not found in result")
 	}
 }
+
+// doRequest sends a request with the ADMIN token to handler and returns the recorded response
+func doRequest(handler http.Handler, method string, target string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer ADMIN")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestWALRecovery(t *testing.T) {
+	dir := t.TempDir()
+	wal, err := persistence.Open(dir)
+	if err != nil {
+		t.Fatalf("unable to open the write-ahead log: %s", err.Error())
+	}
+	handler, _, _, _ := setupWithJournal("Allschema.json", wal)
+
+	doRequest(handler, "PUT", "/v1/db24", "")
+	doRequest(handler, "PUT", "/v1/db24/doc1", `{"key":"one"}`)
+	doRequest(handler, "PUT", "/v1/db24/doc1/col1/", "")
+	doRequest(handler, "PUT", "/v1/db24/doc1/col1/doc2", `{"key":"two"}`)
+	doRequest(handler, "PATCH", "/v1/db24/doc1/col1/doc2", `[{"op":"ObjectAdd","path":"/extra","value":true}]`)
+	doRequest(handler, "PUT", "/v1/db24/gone", `{"key":"gone"}`)
+	doRequest(handler, "DELETE", "/v1/db24/gone", "")
+	doRequest(handler, "PUT", "/v1/db25", "")
+	doRequest(handler, "DELETE", "/v1/db25", "")
+	before := doRequest(handler, "GET", "/v1/db24/doc1/col1/doc2", "").Body.String()
+	wal.Close()
+
+	//simulating a restart
+	wal, err = persistence.Open(dir)
+	if err != nil {
+		t.Fatalf("unable to reopen the write-ahead log: %s", err.Error())
+	}
+	defer wal.Close()
+	restarted, rcs, rds, _ := setupWithJournal("Allschema.json", wal)
+	if err = wal.Replay(rcs, rds); err != nil {
+		t.Fatalf("replay failed: %s", err.Error())
+	}
+
+	w := doRequest(restarted, "GET", "/v1/db24/doc1/col1/doc2", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("TestWALRecovery failed, expected the nested document to be recovered, got status code %d", w.Code)
+	}
+	if w.Body.String() != before {
+		t.Errorf("TestWALRecovery failed, expected %s, got %s", before, w.Body.String())
+	}
+	if w = doRequest(restarted, "GET", "/v1/db24/gone", ""); w.Code != http.StatusNotFound {
+		t.Errorf("TestWALRecovery failed, expected deleted document to stay deleted, got status code %d", w.Code)
+	}
+	if w = doRequest(restarted, "GET", "/v1/db25/", ""); w.Code != http.StatusNotFound {
+		t.Errorf("TestWALRecovery failed, expected deleted database to stay deleted, got status code %d", w.Code)
+	}
+
+	//new mutations are appended after the replayed ones
+	doRequest(restarted, "PUT", "/v1/db24/doc3", `{"key":"three"}`)
+	if w = doRequest(restarted, "GET", "/v1/db24/doc3", ""); w.Code != http.StatusOK {
+		t.Errorf("TestWALRecovery failed, expected document written after recovery, got status code %d", w.Code)
+	}
+}
+
+func TestSnapshotRecovery(t *testing.T) {
+	dir := t.TempDir()
+	wal, err := persistence.Open(dir)
+	if err != nil {
+		t.Fatalf("unable to open the write-ahead log: %s", err.Error())
+	}
+	handler, _, _, src := setupWithJournal("Allschema.json", wal)
+
+	doRequest(handler, "PUT", "/v1/db24", "")
+	doRequest(handler, "PUT", "/v1/db24/doc1", `{"key":"one"}`)
+	doRequest(handler, "PUT", "/v1/db24/doc1/col1/", "")
+	doRequest(handler, "PUT", "/v1/db24/doc1/col1/doc2", `{"key":"two"}`)
+	doRequest(handler, "PUT", "/v1/db24/gone", `{"key":"gone"}`)
+	if err = wal.Snapshot(context.Background(), src, 1); err != nil {
+		t.Fatalf("snapshot failed: %s", err.Error())
+	}
+	//mutations logged after the snapshot are replayed on top of it
+	doRequest(handler, "DELETE", "/v1/db24/gone", "")
+	doRequest(handler, "PUT", "/v1/db24/doc1/col1/doc3", `{"key":"three"}`)
+	if err = wal.Snapshot(context.Background(), src, 1); err != nil {
+		t.Fatalf("snapshot failed: %s", err.Error())
+	}
+	doRequest(handler, "PATCH", "/v1/db24/doc1/col1/doc2", `[{"op":"ObjectAdd","path":"/extra","value":true}]`)
+	before := doRequest(handler, "GET", "/v1/db24/doc1/col1/", "").Body.String()
+	wal.Close()
+
+	//only the segments needed by the latest snapshot are kept
+	segments, _ := filepath.Glob(filepath.Join(dir, "wal-*.log"))
+	if len(segments) != 2 {
+		t.Errorf("TestSnapshotRecovery failed, expected older log segments to be removed, got %v", segments)
+	}
+
+	wal, err = persistence.Open(dir)
+	if err != nil {
+		t.Fatalf("unable to reopen the write-ahead log: %s", err.Error())
+	}
+	defer wal.Close()
+	restarted, rcs, rds, _ := setupWithJournal("Allschema.json", wal)
+	if err = wal.Replay(rcs, rds); err != nil {
+		t.Fatalf("replay failed: %s", err.Error())
+	}
+	if w := doRequest(restarted, "GET", "/v1/db24/doc1/col1/", ""); w.Body.String() != before {
+		t.Errorf("TestSnapshotRecovery failed, expected %s, got %s", before, w.Body.String())
+	}
+	if w := doRequest(restarted, "GET", "/v1/db24/gone", ""); w.Code != http.StatusNotFound {
+		t.Errorf("TestSnapshotRecovery failed, expected deleted document to stay deleted, got status code %d", w.Code)
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	dir := t.TempDir()
+	wal, err := persistence.Open(dir)
+	if err != nil {
+		t.Fatalf("unable to open the write-ahead log: %s", err.Error())
+	}
+	handler, _, _, _ := setupWithJournal("Allschema.json", wal)
+
+	doRequest(handler, "PUT", "/v1/db24", "")
+	doRequest(handler, "PUT", "/v1/db24/doc1", `{"key":"one"}`)
+	doRequest(handler, "PUT", "/v1/db24/doc1/col1/", "")
+	doRequest(handler, "PUT", "/v1/db24/doc1/col1/doc2", `{"key":"two"}`)
+	doRequest(handler, "PUT", "/v1/db24/doc1/col1/doc2/col2/", "")
+	doRequest(handler, "PUT", "/v1/db24/doc3", `{"key":"three"}`)
+
+	w := doRequest(handler, "GET", "/v1/db24?export=jsonl", "")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/jsonl" {
+		t.Fatalf("TestExportImport failed, unable to export: %d", w.Code)
+	}
+	dump := w.Body.String()
+	if lines := strings.Split(strings.TrimSpace(dump), "\n"); len(lines) != 5 || !strings.Contains(lines[0], `"meta"`) {
+		t.Fatalf("TestExportImport failed, expected one line per collection and document, got %s", dump)
+	}
+
+	if w = doRequest(handler, "POST", "/v1/copy?import", dump); w.Code != http.StatusCreated {
+		t.Fatalf("TestExportImport failed, unable to import: %d %s", w.Code, w.Body.String())
+	}
+	targets := []string{"/v1/%s/", "/v1/%s/doc1/col1/", "/v1/%s/doc1/col1/doc2/col2/"}
+	for _, target := range targets {
+		want := strings.ReplaceAll(doRequest(handler, "GET", fmt.Sprintf(target, "db24"), "").Body.String(), "/db24/", "/copy/")
+		if got := doRequest(handler, "GET", fmt.Sprintf(target, "copy"), "").Body.String(); got != want {
+			t.Errorf("TestExportImport failed, expected %s, got %s", want, got)
+		}
+	}
+
+	//a malformed dump leaves the database untouched
+	if w = doRequest(handler, "POST", "/v1/copy?import", `{"path":"/doc9","doc":{}}`+"\n"+`{"path":"/doc9/col/x"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("TestExportImport failed, expected a malformed dump to be rejected, got %d", w.Code)
+	}
+	if w = doRequest(handler, "GET", "/v1/copy/doc1/col1/doc2", ""); w.Code != http.StatusOK {
+		t.Errorf("TestExportImport failed, expected the database to survive a rejected import, got %d", w.Code)
+	}
+	wal.Close()
+
+	//the import is replayed from the log
+	wal, err = persistence.Open(dir)
+	if err != nil {
+		t.Fatalf("unable to reopen the write-ahead log: %s", err.Error())
+	}
+	defer wal.Close()
+	restarted, rcs, rds, _ := setupWithJournal("Allschema.json", wal)
+	if err = wal.Replay(rcs, rds); err != nil {
+		t.Fatalf("replay failed: %s", err.Error())
+	}
+	for _, target := range targets {
+		want := doRequest(handler, "GET", fmt.Sprintf(target, "copy"), "").Body.String()
+		if got := doRequest(restarted, "GET", fmt.Sprintf(target, "copy"), "").Body.String(); got != want {
+			t.Errorf("TestExportImport failed, expected %s after a restart, got %s", want, got)
+		}
+	}
+}
+
+func TestCollectionFilter(t *testing.T) {
+	handler, _ := setup("Allschema.json")
+	doRequest(handler, "PUT", "/v1/db24", "")
+	doRequest(handler, "PUT", "/v1/db24/alice", `{"age":34,"tags":["x"]}`)
+	doRequest(handler, "PUT", "/v1/db24/bob", `{"age":25,"tags":["x"]}`)
+	doRequest(handler, "PUT", "/v1/db24/carol", `{"age":41,"tags":["y"],"address":{"city":"Houston"}}`)
+	doRequest(handler, "PUT", "/v1/db24/carol/people/", "")
+	doRequest(handler, "PUT", "/v1/db24/carol/people/dave", `{"age":52}`)
+	doRequest(handler, "PUT", "/v1/db24/carol/people/erin", `{"age":18}`)
+
+	tests := []struct {
+		target string
+		filter string
+		want   []string
+	}{
+		{"/v1/db24/", `age>30 AND tags contains "x"`, []string{"/alice"}},
+		{"/v1/db24/", `age>30`, []string{"/alice", "/carol"}},
+		{"/v1/db24/", `address.city = "Houston" OR age < 30`, []string{"/bob", "/carol"}},
+		{"/v1/db24/?interval=[a,b]", `age>30`, []string{"/alice"}},
+		{"/v1/db24/carol/people/", `NOT age<21`, []string{"/carol/people/dave"}},
+	}
+	for _, test := range tests {
+		sep := "?"
+		if strings.Contains(test.target, "?") {
+			sep = "&"
+		}
+		w := doRequest(handler, "GET", test.target+sep+"filter="+url.QueryEscape(test.filter), "")
+		var docs []struct {
+			Path string `json:"path"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &docs); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("TestCollectionFilter failed, %s returned %d: %s", test.filter, w.Code, w.Body.String())
+		}
+		var got []string
+		for _, doc := range docs {
+			got = append(got, doc.Path)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("TestCollectionFilter failed, expected %s to return %v, got %v", test.filter, test.want, got)
+		}
+	}
+
+	if w := doRequest(handler, "GET", "/v1/db24/?filter="+url.QueryEscape(`age >`), ""); w.Code != http.StatusBadRequest {
+		t.Errorf("TestCollectionFilter failed, expected a malformed filter to be rejected, got %d", w.Code)
+	}
+}
+
+func TestCollectionPagination(t *testing.T) {
+	handler, _ := setup("Allschema.json")
+	doRequest(handler, "PUT", "/v1/db24", "")
+	doRequest(handler, "PUT", "/v1/db24/top", `{"n":0}`)
+	doRequest(handler, "PUT", "/v1/db24/top/col/", "")
+	var want []string
+	for i := 0; i < 7; i++ {
+		name := fmt.Sprintf("doc%d", i)
+		doRequest(handler, "PUT", "/v1/db24/top/col/"+name, fmt.Sprintf(`{"n":%d}`, i))
+		if i%2 == 0 {
+			want = append(want, "/top/col/"+name)
+		}
+	}
+
+	//follow the next links through every page of even documents
+	var got []string
+	pages := 0
+	target := "/v1/db24/top/col/?limit=2&filter=" + url.QueryEscape(`n = 0 OR n = 2 OR n = 4 OR n = 6`)
+	for target != "" {
+		w := doRequest(handler, "GET", target, "")
+		var docs []struct {
+			Path string `json:"path"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &docs); err != nil || w.Code != http.StatusOK || len(docs) > 2 {
+			t.Fatalf("TestCollectionPagination failed, %s returned %d: %s", target, w.Code, w.Body.String())
+		}
+		for _, doc := range docs {
+			got = append(got, doc.Path)
+		}
+		pages++
+		target = ""
+		if link := w.Header().Get("Link"); link != "" {
+			target = strings.TrimPrefix(strings.Split(link, ">")[0], "<")
+		}
+	}
+	if !reflect.DeepEqual(got, want) || pages != 2 {
+		t.Errorf("TestCollectionPagination failed, expected %v over 2 pages, got %v over %d", want, got, pages)
+	}
+
+	//the top-level collection pages the same way
+	w := doRequest(handler, "GET", "/v1/db24/?limit=1", "")
+	if w.Code != http.StatusOK || w.Header().Get("Link") != "" {
+		t.Errorf("TestCollectionPagination failed, expected a single page for a single document, got %d %s", w.Code, w.Header().Get("Link"))
+	}
+}
+
+func TestCollectionSortAndProjection(t *testing.T) {
+	handler, _ := setup("Allschema.json")
+	doRequest(handler, "PUT", "/v1/db24", "")
+	doRequest(handler, "PUT", "/v1/db24/amy", `{"name":"Amy","age":30,"address":{"city":"Austin","zip":"73301"}}`)
+	doRequest(handler, "PUT", "/v1/db24/ben", `{"name":"Ben","age":52}`)
+	doRequest(handler, "PUT", "/v1/db24/cal", `{"name":"Cal"}`)
+	doRequest(handler, "PUT", "/v1/db24/dee", `{"name":"Dee","age":30}`)
+	doRequest(handler, "PUT", "/v1/db24/eve", `{"name":"Eve","age":19}`)
+
+	//page through the documents from oldest to youngest, keeping only names and cities
+	var got []string
+	target := "/v1/db24/?sort=" + url.QueryEscape("/age:desc") + "&fields=" + url.QueryEscape("/name,/address/city") + "&limit=2"
+	for target != "" {
+		w := doRequest(handler, "GET", target, "")
+		var docs []struct {
+			Path string          `json:"path"`
+			Doc  json.RawMessage `json:"doc"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &docs); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("TestCollectionSortAndProjection failed, %s returned %d: %s", target, w.Code, w.Body.String())
+		}
+		for _, doc := range docs {
+			got = append(got, doc.Path+" "+string(doc.Doc))
+		}
+		target = ""
+		if link := w.Header().Get("Link"); link != "" {
+			target = strings.TrimPrefix(strings.Split(link, ">")[0], "<")
+		}
+	}
+	want := []string{
+		`/ben {"name":"Ben"}`,
+		`/amy {"address":{"city":"Austin"},"name":"Amy"}`,
+		`/dee {"name":"Dee"}`,
+		`/eve {"name":"Eve"}`,
+		`/cal {"name":"Cal"}`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("TestCollectionSortAndProjection failed, expected %v, got %v", want, got)
+	}
+}
+
+func TestCollectionIndex(t *testing.T) {
+	handler, _ := setup("Allschema.json")
+	doRequest(handler, "PUT", "/v1/db24", "")
+	doRequest(handler, "PUT", "/v1/db24/top", `{}`)
+	doRequest(handler, "PUT", "/v1/db24/top/users/", "")
+	doRequest(handler, "PUT", "/v1/db24/top/users/amy", `{"email":"a@x.com","age":30}`)
+	doRequest(handler, "PUT", "/v1/db24/top/users/ben", `{"email":"b@x.com","age":52}`)
+	doRequest(handler, "PUT", "/v1/db24/top/users/dan", `{"age":33}`)
+
+	w := doRequest(handler, "PUT", "/v1/db24/top/users/?index="+url.QueryEscape("/email"), "")
+	if w.Code != http.StatusCreated || w.Header().Get("Location") != "/v1/db24/top/users/?index=%2Femail" {
+		t.Fatalf("TestCollectionIndex failed, expected the index to be created, got %d: %s", w.Code, w.Body.String())
+	}
+	if w = doRequest(handler, "PUT", "/v1/db24/top/users/?index="+url.QueryEscape("/email"), ""); w.Code != http.StatusBadRequest {
+		t.Errorf("TestCollectionIndex failed, expected a duplicate index to be rejected, got %d", w.Code)
+	}
+	if w = doRequest(handler, "PUT", "/v1/db24/top/users/?index=email", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("TestCollectionIndex failed, expected a bad pointer to be rejected, got %d", w.Code)
+	}
+
+	//writes made after the index was built are reflected in lookups
+	doRequest(handler, "PUT", "/v1/db24/top/users/cal", `{"email":"a@x.com","age":19}`)
+	doRequest(handler, "PUT", "/v1/db24/top/users/ben", `{"email":"c@x.com","age":52}`)
+	doRequest(handler, "PATCH", "/v1/db24/top/users/dan", `[{"op":"ObjectAdd","path":"/email","value":"d@x.com"}]`)
+	doRequest(handler, "PUT", "/v1/db24/top/users/dee", `{"email":"c@x.com","age":41}`)
+	doRequest(handler, "DELETE", "/v1/db24/top/users/dee", "")
+
+	tests := []struct {
+		filter string
+		want   []string
+	}{
+		{`email = "a@x.com"`, []string{"/top/users/amy", "/top/users/cal"}},
+		{`email = "a@x.com" AND age > 20`, []string{"/top/users/amy"}},
+		{`email = "c@x.com"`, []string{"/top/users/ben"}},
+		{`email = "d@x.com"`, []string{"/top/users/dan"}},
+		{`email = "b@x.com"`, nil},
+	}
+	for _, test := range tests {
+		w := doRequest(handler, "GET", "/v1/db24/top/users/?filter="+url.QueryEscape(test.filter), "")
+		var docs []struct {
+			Path string `json:"path"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &docs); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("TestCollectionIndex failed, %s returned %d: %s", test.filter, w.Code, w.Body.String())
+		}
+		var got []string
+		for _, doc := range docs {
+			got = append(got, doc.Path)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("TestCollectionIndex failed, expected %s to return %v, got %v", test.filter, test.want, got)
+		}
+	}
+
+	//indexes are part of an export
+	if w = doRequest(handler, "GET", "/v1/db24?export=jsonl", ""); !strings.Contains(w.Body.String(), `{"path":"/top/users/","indexes":[{"field":"/email"}]}`) {
+		t.Errorf("TestCollectionIndex failed, expected the index to be exported, got %s", w.Body.String())
+	}
+
+	if w = doRequest(handler, "DELETE", "/v1/db24/top/users/?index="+url.QueryEscape("/email"), ""); w.Code != http.StatusNoContent {
+		t.Errorf("TestCollectionIndex failed, expected the index to be dropped, got %d", w.Code)
+	}
+	if w = doRequest(handler, "DELETE", "/v1/db24/top/users/?index="+url.QueryEscape("/email"), ""); w.Code != http.StatusNotFound {
+		t.Errorf("TestCollectionIndex failed, expected 404 for a missing index, got %d", w.Code)
+	}
+}
+
+func TestUniqueIndex(t *testing.T) {
+	handler, _ := setup("Allschema.json")
+	doRequest(handler, "PUT", "/v1/db24", "")
+	doRequest(handler, "PUT", "/v1/db24/top", `{}`)
+	doRequest(handler, "PUT", "/v1/db24/top/users/", "")
+	doRequest(handler, "PUT", "/v1/db24/top/users/amy", `{"username":"amy"}`)
+	doRequest(handler, "PUT", "/v1/db24/top/users/ann", `{"username":"amy"}`)
+
+	//the collection already breaks the constraint
+	if w := doRequest(handler, "PUT", "/v1/db24/top/users/?index="+url.QueryEscape("/username")+"&unique", ""); w.Code != http.StatusConflict {
+		t.Fatalf("TestUniqueIndex failed, expected 409, got %d: %s", w.Code, w.Body.String())
+	}
+	doRequest(handler, "DELETE", "/v1/db24/top/users/ann", "")
+	if w := doRequest(handler, "PUT", "/v1/db24/top/users/?index="+url.QueryEscape("/username")+"&unique", ""); w.Code != http.StatusCreated {
+		t.Fatalf("TestUniqueIndex failed, expected the index to be created, got %d: %s", w.Code, w.Body.String())
+	}
+
+	tests := []struct {
+		method string
+		target string
+		body   string
+		want   int
+	}{
+		{"PUT", "/v1/db24/top/users/bob", `{"username":"amy"}`, http.StatusConflict},
+		{"POST", "/v1/db24/top/users/", `{"username":"amy"}`, http.StatusConflict},
+		{"PUT", "/v1/db24/top/users/bob", `{"username":"bob"}`, http.StatusCreated},
+		{"PUT", "/v1/db24/top/users/bob", `{"username":"amy"}`, http.StatusConflict},
+		{"PUT", "/v1/db24/top/users/amy", `{"username":"amy","age":3}`, http.StatusOK},
+		{"PUT", "/v1/db24/top/users/cal", `{"age":3}`, http.StatusCreated},
+		{"PATCH", "/v1/db24/top/users/cal", `[{"op":"ObjectAdd","path":"/username","value":"bob"}]`, http.StatusConflict},
+		{"PATCH", "/v1/db24/top/users/cal", `[{"op":"ObjectAdd","path":"/username","value":"cal"}]`, http.StatusOK},
+	}
+	for _, test := range tests {
+		if w := doRequest(handler, test.method, test.target, test.body); w.Code != test.want {
+			t.Errorf("TestUniqueIndex failed, expected %s %s %s to return %d, got %d: %s", test.method, test.target, test.body, test.want, w.Code, w.Body.String())
+		}
+	}
+	if w := doRequest(handler, "GET", "/v1/db24/top/users/bob", ""); !strings.Contains(w.Body.String(), `"username":"bob"`) {
+		t.Errorf("TestUniqueIndex failed, expected a rejected write to leave the document untouched, got %s", w.Body.String())
+	}
+
+	//an import holding duplicates is rejected
+	dump := doRequest(handler, "GET", "/v1/db24?export=jsonl", "").Body.String()
+	if !strings.Contains(dump, `"indexes":[{"field":"/username","unique":true}]`) {
+		t.Fatalf("TestUniqueIndex failed, expected the unique index to be exported, got %s", dump)
+	}
+	dump += `{"path":"/top/users/dup","doc":{"username":"amy"},"meta":{}}` + "\n"
+	if w := doRequest(handler, "POST", "/v1/db24?import", dump); w.Code != http.StatusBadRequest {
+		t.Errorf("TestUniqueIndex failed, expected an import holding duplicates to be rejected, got %d", w.Code)
+	}
+}
+
+func TestTopLevelIndex(t *testing.T) {
+	dir := t.TempDir()
+	wal, err := persistence.Open(dir)
+	if err != nil {
+		t.Fatalf("unable to open the write-ahead log: %s", err.Error())
+	}
+	handler, _, _, src := setupWithJournal("Allschema.json", wal)
+	doRequest(handler, "PUT", "/v1/db24", "")
+	doRequest(handler, "PUT", "/v1/db24/amy", `{"email":"a@x.com"}`)
+	doRequest(handler, "PUT", "/v1/db24/ben", `{"email":"b@x.com"}`)
+
+	w := doRequest(handler, "PUT", "/v1/db24/?index="+url.QueryEscape("/email")+"&unique", "")
+	if w.Code != http.StatusCreated || w.Header().Get("Location") != "/v1/db24/?index=%2Femail" {
+		t.Fatalf("TestTopLevelIndex failed, expected the index to be created, got %d: %s", w.Code, w.Body.String())
+	}
+	if err = wal.Snapshot(context.Background(), src, 1); err != nil {
+		t.Fatalf("snapshot failed: %s", err.Error())
+	}
+
+	tests := []struct {
+		method string
+		target string
+		body   string
+		want   int
+	}{
+		{"PUT", "/v1/db24/cal", `{"email":"a@x.com"}`, http.StatusConflict},
+		{"POST", "/v1/db24/", `{"email":"a@x.com"}`, http.StatusConflict},
+		{"PUT", "/v1/db24/dee", `{}`, http.StatusCreated},
+		{"PATCH", "/v1/db24/dee", `[{"op":"ObjectAdd","path":"/email","value":"a@x.com"}]`, http.StatusConflict},
+		{"DELETE", "/v1/db24/amy", "", http.StatusNoContent},
+		{"PUT", "/v1/db24/cal", `{"email":"a@x.com"}`, http.StatusCreated},
+	}
+	for _, test := range tests {
+		if w := doRequest(handler, test.method, test.target, test.body); w.Code != test.want {
+			t.Errorf("TestTopLevelIndex failed, expected %s %s %s to return %d, got %d: %s", test.method, test.target, test.body, test.want, w.Code, w.Body.String())
+		}
+	}
+
+	lookup := func(handler http.Handler, email string) string {
+		w := doRequest(handler, "GET", "/v1/db24/?filter="+url.QueryEscape(`email = "`+email+`"`), "")
+		var docs []struct {
+			Path string `json:"path"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &docs)
+		var paths []string
+		for _, doc := range docs {
+			paths = append(paths, doc.Path)
+		}
+		return strings.Join(paths, ",")
+	}
+	if got := lookup(handler, "a@x.com"); got != "/cal" {
+		t.Errorf("TestTopLevelIndex failed, expected the lookup to return /cal, got %s", got)
+	}
+
+	//the index is exported and imported along with the documents
+	dump := doRequest(handler, "GET", "/v1/db24?export=jsonl", "").Body.String()
+	if !strings.HasPrefix(dump, `{"path":"/","indexes":[{"field":"/email","unique":true}]}`) {
+		t.Fatalf("TestTopLevelIndex failed, expected the index to be exported first, got %s", dump)
+	}
+	if w := doRequest(handler, "POST", "/v1/db25?import", dump); w.Code != http.StatusCreated {
+		t.Fatalf("TestTopLevelIndex failed, expected the dump to be imported, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := doRequest(handler, "PUT", "/v1/db25/dan", `{"email":"b@x.com"}`); w.Code != http.StatusConflict {
+		t.Errorf("TestTopLevelIndex failed, expected the imported index to be unique, got %d", w.Code)
+	}
+	wal.Close()
+
+	//the index survives a restart, whether it was logged before or after the snapshot
+	wal, err = persistence.Open(dir)
+	if err != nil {
+		t.Fatalf("unable to reopen the write-ahead log: %s", err.Error())
+	}
+	defer wal.Close()
+	restarted, rcs, rds, _ := setupWithJournal("Allschema.json", wal)
+	if err = wal.Replay(rcs, rds); err != nil {
+		t.Fatalf("replay failed: %s", err.Error())
+	}
+	if got := lookup(restarted, "a@x.com"); got != "/cal" {
+		t.Errorf("TestTopLevelIndex failed, expected the lookup to return /cal after a restart, got %s", got)
+	}
+	if w := doRequest(restarted, "PUT", "/v1/db24/dan", `{"email":"b@x.com"}`); w.Code != http.StatusConflict {
+		t.Errorf("TestTopLevelIndex failed, expected the index to stay unique after a restart, got %d", w.Code)
+	}
+	if w := doRequest(restarted, "DELETE", "/v1/db24/?index="+url.QueryEscape("/email"), ""); w.Code != http.StatusNoContent {
+		t.Errorf("TestTopLevelIndex failed, expected the index to be dropped, got %d", w.Code)
+	}
+	if w := doRequest(restarted, "PUT", "/v1/db24/dan", `{"email":"b@x.com"}`); w.Code != http.StatusCreated {
+		t.Errorf("TestTopLevelIndex failed, expected a dropped index to accept duplicates, got %d", w.Code)
+	}
+}
+
+func TestJSONPatch(t *testing.T) {
+	handler, _ := setup("Allschema.json")
+	doRequest(handler, "PUT", "/v1/db24", "")
+	doRequest(handler, "PUT", "/v1/db24/doc1", `{"name":"amy","tags":["a","b"],"address":{"city":"Austin"}}`)
+
+	patch := `[{"op":"test","path":"/name","value":"amy"},{"op":"replace","path":"/name","value":"ann"},{"op":"add","path":"/tags/-","value":"c"},{"op":"remove","path":"/tags/0"},{"op":"move","from":"/address/city","path":"/city"}]`
+	req := httptest.NewRequest("PATCH", "/v1/db24/doc1", strings.NewReader(patch))
+	req.Header.Set("Authorization", "Bearer ADMIN")
+	req.Header.Set("Content-Type", "application/json-patch+json")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), `"patchFailed":true`) {
+		t.Fatalf("TestJSONPatch failed, got %d: %s", w.Code, w.Body.String())
+	}
+	var doc struct {
+		Doc json.RawMessage `json:"doc"`
+	}
+	json.Unmarshal(doRequest(handler, "GET", "/v1/db24/doc1", "").Body.Bytes(), &doc)
+	if want := `{"address":{},"city":"Austin","name":"ann","tags":["b","c"]}`; string(doc.Doc) != want {
+		t.Errorf("TestJSONPatch failed, expected %s, got %s", want, doc.Doc)
+	}
+
+	//a failed test leaves the document untouched
+	req = httptest.NewRequest("PATCH", "/v1/db24/doc1", strings.NewReader(`[{"op":"remove","path":"/city"},{"op":"test","path":"/name","value":"amy"}]`))
+	req.Header.Set("Authorization", "Bearer ADMIN")
+	req.Header.Set("Content-Type", "application/json-patch+json")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if !strings.Contains(w.Body.String(), `"patchFailed":true`) {
+		t.Errorf("TestJSONPatch failed, expected the patch to fail, got %d: %s", w.Code, w.Body.String())
+	}
+	json.Unmarshal(doRequest(handler, "GET", "/v1/db24/doc1", "").Body.Bytes(), &doc)
+	if !strings.Contains(string(doc.Doc), `"city":"Austin"`) {
+		t.Errorf("TestJSONPatch failed, expected the document to be untouched, got %s", doc.Doc)
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	handler, _ := setup("complexSchema1.json")
+	doRequest(handler, "PUT", "/v1/db24", "")
+	doRequest(handler, "PUT", "/v1/db24/doc1", `{"groceries":["milk"],"note":"old","extra":{"a":1,"b":2}}`)
+
+	mergePatch := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PATCH", "/v1/db24/doc1", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer ADMIN")
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+	if w := mergePatch(`{"note":null,"extra":{"b":3,"c":4}}`); w.Code != http.StatusOK || strings.Contains(w.Body.String(), `"patchFailed":true`) {
+		t.Fatalf("TestMergePatch failed, got %d: %s", w.Code, w.Body.String())
+	}
+	var doc struct {
+		Doc json.RawMessage `json:"doc"`
+	}
+	json.Unmarshal(doRequest(handler, "GET", "/v1/db24/doc1", "").Body.Bytes(), &doc)
+	if want := `{"extra":{"a":1,"b":3,"c":4},"groceries":["milk"]}`; string(doc.Doc) != want {
+		t.Errorf("TestMergePatch failed, expected %s, got %s", want, doc.Doc)
+	}
+
+	//the merged document must still conform to the schema
+	if w := mergePatch(`{"groceries":null}`); !strings.Contains(w.Body.String(), `"patchFailed":true`) {
+		t.Errorf("TestMergePatch failed, expected the patch to fail validation, got %d: %s", w.Code, w.Body.String())
+	}
+	json.Unmarshal(doRequest(handler, "GET", "/v1/db24/doc1", "").Body.Bytes(), &doc)
+	if !strings.Contains(string(doc.Doc), `"groceries":["milk"]`) {
+		t.Errorf("TestMergePatch failed, expected the document to be untouched, got %s", doc.Doc)
+	}
+}
+
+func TestLegacyPatchContentType(t *testing.T) {
+	handler, _ := setup("Allschema.json")
+	doRequest(handler, "PUT", "/v1/db24", "")
+	doRequest(handler, "PUT", "/v1/db24/doc1", `{}`)
+
+	//clients written before JSON Patch was supported send OwlDB patches with whatever type their library defaults to
+	for i, contentType := range []string{"application/x-www-form-urlencoded", "text/plain;charset=UTF-8"} {
+		body := fmt.Sprintf(`[{"op":"ObjectAdd","path":"/f%d","value":%d}]`, i, i)
+		req := httptest.NewRequest("PATCH", "/v1/db24/doc1", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer ADMIN")
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != http.StatusOK || strings.Contains(w.Body.String(), `"patchFailed":true`) {
+			t.Errorf("TestLegacyPatchContentType failed for %s, got %d: %s", contentType, w.Code, w.Body.String())
+		}
+	}
+	var doc struct {
+		Doc json.RawMessage `json:"doc"`
+	}
+	json.Unmarshal(doRequest(handler, "GET", "/v1/db24/doc1", "").Body.Bytes(), &doc)
+	if want := `{"f0":0,"f1":1}`; string(doc.Doc) != want {
+		t.Errorf("TestLegacyPatchContentType failed, expected %s, got %s", want, doc.Doc)
+	}
+}
+
+func TestConditionalRequests(t *testing.T) {
+	handler, _ := setup("Allschema.json")
+	doRequest(handler, "PUT", "/v1/db24", "")
+	doRequest(handler, "PUT", "/v1/db24/doc1", `{"a":1}`)
+	doRequest(handler, "PUT", "/v1/db24/doc1/col/", "")
+	doRequest(handler, "PUT", "/v1/db24/doc1/col/doc2", `{"a":1}`)
+
+	conditional := func(method string, target string, body string, header string, tag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer ADMIN")
+		req.Header.Set(header, tag)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+	for _, target := range []string{"/v1/db24/doc1/col/doc2", "/v1/db24/doc1"} {
+		w := doRequest(handler, "GET", target, "")
+		if etag := w.Header().Get("ETag"); etag != `"1"` {
+			t.Fatalf("TestConditionalRequests failed, expected a new document to be tagged \"1\", got %s", etag)
+		}
+		if w := conditional("GET", target, "", "If-None-Match", `"1"`); w.Code != http.StatusNotModified {
+			t.Errorf("TestConditionalRequests failed, expected 304 for a current tag, got %d", w.Code)
+		}
+
+		//a stale version is refused by every write
+		if w := conditional("PUT", target, `{"a":2}`, "If-Match", `"2"`); w.Code != http.StatusPreconditionFailed {
+			t.Errorf("TestConditionalRequests failed, expected 412 for a stale PUT, got %d", w.Code)
+		}
+		if w := conditional("PATCH", target, `[{"op":"ObjectAdd","path":"/b","value":1}]`, "If-Match", `"2"`); w.Code != http.StatusPreconditionFailed {
+			t.Errorf("TestConditionalRequests failed, expected 412 for a stale PATCH, got %d", w.Code)
+		}
+		if w := conditional("DELETE", target, "", "If-Match", `"2"`); w.Code != http.StatusPreconditionFailed {
+			t.Errorf("TestConditionalRequests failed, expected 412 for a stale DELETE, got %d", w.Code)
+		}
+		if w := conditional("PUT", target, `{"a":2}`, "If-None-Match", "*"); w.Code != http.StatusPreconditionFailed {
+			t.Errorf("TestConditionalRequests failed, expected 412 for creating an existing document, got %d", w.Code)
+		}
+
+		//the current version is accepted, and every write bumps it
+		if w := conditional("PUT", target, `{"a":2}`, "If-Match", `"1"`); w.Code != http.StatusOK {
+			t.Errorf("TestConditionalRequests failed, expected 200 for a current PUT, got %d", w.Code)
+		}
+		if w := conditional("PATCH", target, `[{"op":"ObjectAdd","path":"/b","value":1}]`, "If-Match", `"2"`); w.Code != http.StatusOK {
+			t.Errorf("TestConditionalRequests failed, expected 200 for a current PATCH, got %d", w.Code)
+		}
+		if etag := doRequest(handler, "GET", target, "").Header().Get("ETag"); etag != `"3"` {
+			t.Errorf("TestConditionalRequests failed, expected the document to be tagged \"3\", got %s", etag)
+		}
+		if w := conditional("DELETE", target, "", "If-Match", `"3"`); w.Code != http.StatusNoContent {
+			t.Errorf("TestConditionalRequests failed, expected 204 for a current DELETE, got %d", w.Code)
+		}
+
+		//If-None-Match: * only creates documents
+		if w := conditional("PUT", target, `{"a":1}`, "If-None-Match", "*"); w.Code != http.StatusCreated {
+			t.Errorf("TestConditionalRequests failed, expected 201 for creating a missing document, got %d", w.Code)
+		}
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	dir := t.TempDir()
+	wal, err := persistence.Open(dir)
+	if err != nil {
+		t.Fatalf("unable to open the write-ahead log: %s", err.Error())
+	}
+	handler, _, _, _ := setupWithJournal("Allschema.json", wal)
+	doRequest(handler, "PUT", "/v1/db24", "")
+	doRequest(handler, "PUT", "/v1/db24/alice", `{"balance":10}`)
+	doRequest(handler, "PUT", "/v1/db24/alice/history/", "")
+	doRequest(handler, "PUT", "/v1/db24/bob", `{"balance":0}`)
+	doRequest(handler, "PUT", "/v1/db24/gone", `{}`)
+
+	//a failing operation leaves every document untouched
+	failing := `[
+		{"op":"patch","path":"/alice","patch":[{"op":"replace","path":"/balance","value":5}],"patchType":"application/json-patch+json"},
+		{"op":"put","path":"/alice/history/t1","doc":{"amount":5}},
+		{"op":"delete","path":"/gone"},
+		{"op":"put","path":"/bob","doc":{"balance":5},"ifMatch":"\"7\""}
+	]`
+	if w := doRequest(handler, "POST", "/v1/db24?transaction", failing); w.Code != http.StatusPreconditionFailed || !strings.Contains(w.Body.String(), "operation 3") {
+		t.Fatalf("TestTransaction failed, expected the last operation to fail with 412, got %d %s", w.Code, w.Body.String())
+	}
+	if w := doRequest(handler, "GET", "/v1/db24/alice", ""); !strings.Contains(w.Body.String(), `"balance":10`) || w.Header().Get("ETag") != `"1"` {
+		t.Errorf("TestTransaction failed, expected the patch to be rolled back, got %s", w.Body.String())
+	}
+	if w := doRequest(handler, "GET", "/v1/db24/alice/history/t1", ""); w.Code != http.StatusNotFound {
+		t.Errorf("TestTransaction failed, expected the created document to be rolled back, got %d", w.Code)
+	}
+	if w := doRequest(handler, "GET", "/v1/db24/gone", ""); w.Code != http.StatusOK {
+		t.Errorf("TestTransaction failed, expected the deleted document to survive, got %d", w.Code)
+	}
+
+	//the same transaction applies as a whole once its conditions hold
+	succeeding := strings.Replace(failing, `"ifMatch":"\"7\""`, `"ifMatch":"\"1\""`, 1)
+	w := doRequest(handler, "POST", "/v1/db24?transaction", succeeding)
+	if w.Code != http.StatusOK {
+		t.Fatalf("TestTransaction failed, expected 200, got %d %s", w.Code, w.Body.String())
+	}
+	var results []struct {
+		Uri    string `json:"uri"`
+		Status int    `json:"status"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &results)
+	if len(results) != 4 || results[1].Uri != "/v1/db24/alice/history/t1" || results[1].Status != http.StatusCreated || results[2].Status != http.StatusNoContent {
+		t.Errorf("TestTransaction failed, unexpected results %s", w.Body.String())
+	}
+	before := doRequest(handler, "GET", "/v1/db24/bob", "").Body.String()
+	if !strings.Contains(before, `"balance":5`) {
+		t.Errorf("TestTransaction failed, expected bob to be credited, got %s", before)
+	}
+
+	//writing beneath a document deleted earlier in the transaction is refused
+	if w := doRequest(handler, "POST", "/v1/db24?transaction", `[{"op":"delete","path":"/alice"},{"op":"put","path":"/alice/history/t2","doc":{}}]`); w.Code != http.StatusConflict {
+		t.Errorf("TestTransaction failed, expected 409, got %d", w.Code)
+	}
+	if w := doRequest(handler, "POST", "/v1/db24?transaction", `[{"op":"move","path":"/alice"}]`); w.Code != http.StatusBadRequest {
+		t.Errorf("TestTransaction failed, expected 400 for a malformed transaction, got %d", w.Code)
+	}
+	if w := doRequest(handler, "POST", "/v1/db25?transaction", `[{"op":"delete","path":"/alice"}]`); w.Code != http.StatusNotFound {
+		t.Errorf("TestTransaction failed, expected 404 for a missing database, got %d", w.Code)
+	}
+	wal.Close()
+
+	//the transaction survives a restart
+	wal, err = persistence.Open(dir)
+	if err != nil {
+		t.Fatalf("unable to reopen the write-ahead log: %s", err.Error())
+	}
+	defer wal.Close()
+	restarted, rcs, rds, _ := setupWithJournal("Allschema.json", wal)
+	if err = wal.Replay(rcs, rds); err != nil {
+		t.Fatalf("replay failed: %s", err.Error())
+	}
+	if after := doRequest(restarted, "GET", "/v1/db24/bob", "").Body.String(); after != before {
+		t.Errorf("TestTransaction failed, expected %s after recovery, got %s", before, after)
+	}
+	if w := doRequest(restarted, "GET", "/v1/db24/gone", ""); w.Code != http.StatusNotFound {
+		t.Errorf("TestTransaction failed, expected the deleted document to stay deleted, got %d", w.Code)
+	}
+	if w := doRequest(restarted, "GET", "/v1/db24/alice/history/t1", ""); w.Code != http.StatusOK {
+		t.Errorf("TestTransaction failed, expected the nested document to be recovered, got %d", w.Code)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	handler, _ := setup("Allschema.json")
+	doRequest(handler, "PUT", "/v1/db24", "")
+	doRequest(handler, "PUT", "/v1/db24/doc1", `{"a":1}`)
+	doRequest(handler, "PUT", "/v1/db24/doc1/col/", "")
+
+	//operations of a batch are independent, so a failure does not stop the others
+	batch := `[
+		{"op":"put","path":"/doc2","doc":{"a":2}},
+		{"op":"put","path":"/doc1/col/doc3","doc":{"a":3}},
+		{"op":"patch","path":"/doc1","patch":[{"op":"ObjectAdd","path":"/b","value":true}]},
+		{"op":"delete","path":"/missing"},
+		{"op":"put","path":"/doc1/nocol/doc4","doc":{}},
+		{"op":"delete","path":"/doc2","ifMatch":"\"1\""}
+	]`
+	w := doRequest(handler, "POST", "/v1/db24?batch", batch)
+	var results []struct {
+		Uri    string          `json:"uri"`
+		Status int             `json:"status"`
+		Body   json.RawMessage `json:"body"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil || w.Code != http.StatusOK || len(results) != 6 {
+		t.Fatalf("TestBatch failed, got %d %s", w.Code, w.Body.String())
+	}
+	expected := []int{http.StatusCreated, http.StatusCreated, http.StatusOK, http.StatusNotFound, http.StatusNotFound, http.StatusNoContent}
+	for i, result := range results {
+		if result.Status != expected[i] {
+			t.Errorf("TestBatch failed, expected operation %d to get %d, got %d %s", i, expected[i], result.Status, string(result.Body))
+		}
+	}
+
+	//a multi-get reads every document in one round trip
+	w = doRequest(handler, "POST", "/v1/db24?multiget", `["/doc1","/doc1/col/doc3","/doc2"]`)
+	if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil || w.Code != http.StatusOK || len(results) != 3 {
+		t.Fatalf("TestBatch failed, got %d %s", w.Code, w.Body.String())
+	}
+	if results[0].Status != http.StatusOK || !strings.Contains(string(results[0].Body), `"b":true`) {
+		t.Errorf("TestBatch failed, expected the patched document, got %s", string(results[0].Body))
+	}
+	if results[1].Status != http.StatusOK || results[1].Uri != "/v1/db24/doc1/col/doc3" || !strings.Contains(string(results[1].Body), `"a":3`) {
+		t.Errorf("TestBatch failed, expected the nested document, got %s", string(results[1].Body))
+	}
+	if results[2].Status != http.StatusNotFound {
+		t.Errorf("TestBatch failed, expected the deleted document to be missing, got %d", results[2].Status)
+	}
+}
+
+func TestDocHistory(t *testing.T) {
+	dir := t.TempDir()
+	wal, err := persistence.Open(dir)
+	if err != nil {
+		t.Fatalf("unable to open the write-ahead log: %s", err.Error())
+	}
+	handler, _, _, _ := setupWithJournal("Allschema.json", wal)
+	doRequest(handler, "PUT", "/v1/db24", "")
+	doRequest(handler, "PUT", "/v1/db24/doc1", `{"a":1}`)
+	doRequest(handler, "PUT", "/v1/db24/doc1/col/", "")
+	doRequest(handler, "PUT", "/v1/db24/doc1/col/doc2", `{"b":1}`)
+	doRequest(handler, "PATCH", "/v1/db24/doc1", `[{"op":"ObjectAdd","path":"/c","value":2}]`)
+	doRequest(handler, "PUT", "/v1/db24/doc1", `{"a":3}`)
+	doRequest(handler, "PUT", "/v1/db24/doc1/col/doc2", `{"b":2}`)
+
+	//the history lists every earlier version, newest first
+	w := doRequest(handler, "GET", "/v1/db24/doc1?history", "")
+	var listed struct {
+		Path     string `json:"path"`
+		Versions []struct {
+			Version int64 `json:"version"`
+		} `json:"versions"`
+	}
+	if err = json.Unmarshal(w.Body.Bytes(), &listed); err != nil || w.Code != http.StatusOK || len(listed.Versions) != 3 || listed.Versions[0].Version != 3 || listed.Versions[2].Version != 1 {
+		t.Errorf("TestDocHistory failed, expected versions 3, 2 and 1, got %d %s", w.Code, w.Body.String())
+	}
+	if w = doRequest(handler, "GET", "/v1/db24/doc1/col/doc2?version=1", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"b":1`) {
+		t.Errorf("TestDocHistory failed, expected the first version of the nested document, got %d %s", w.Code, w.Body.String())
+	}
+	if w = doRequest(handler, "GET", "/v1/db24/doc1?asOf=1", ""); w.Code != http.StatusNotFound {
+		t.Errorf("TestDocHistory failed, expected 404 before the document existed, got %d", w.Code)
+	}
+	if w = doRequest(handler, "GET", "/v1/db24/doc1?asOf=99999999999999", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"a":3`) {
+		t.Errorf("TestDocHistory failed, expected the current version, got %d %s", w.Code, w.Body.String())
+	}
+	if w = doRequest(handler, "GET", "/v1/db24/doc1?version=99", ""); w.Code != http.StatusNotFound {
+		t.Errorf("TestDocHistory failed, expected 404 for a version not kept, got %d", w.Code)
+	}
+	if w = doRequest(handler, "GET", "/v1/db24/doc1?version=1&asOf=1", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("TestDocHistory failed, expected 400 for a combined selector, got %d", w.Code)
+	}
+
+	//restoring an earlier version writes it back as a new version
+	if w = doRequest(handler, "POST", "/v1/db24/doc1?restore&version=1", ""); w.Code != http.StatusOK {
+		t.Errorf("TestDocHistory failed, expected 200 for a restore, got %d %s", w.Code, w.Body.String())
+	}
+	before := doRequest(handler, "GET", "/v1/db24/doc1", "").Body.String()
+	if !strings.Contains(before, `"doc":{"a":1}`) || !strings.Contains(before, `"version":4`) {
+		t.Errorf("TestDocHistory failed, expected version 4 to hold the first version, got %s", before)
+	}
+	if w = doRequest(handler, "POST", "/v1/db24/doc1?restore", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("TestDocHistory failed, expected 400 for a restore without a version, got %d", w.Code)
+	}
+	wal.Close()
+
+	//the history survives a restart
+	wal, err = persistence.Open(dir)
+	if err != nil {
+		t.Fatalf("unable to reopen the write-ahead log: %s", err.Error())
+	}
+	defer wal.Close()
+	restarted, rcs, rds, _ := setupWithJournal("Allschema.json", wal)
+	if err = wal.Replay(rcs, rds); err != nil {
+		t.Fatalf("replay failed: %s", err.Error())
+	}
+	if after := doRequest(restarted, "GET", "/v1/db24/doc1?version=2", "").Body.String(); !strings.Contains(after, `"c":2`) {
+		t.Errorf("TestDocHistory failed, expected version 2 after recovery, got %s", after)
+	}
+	w = doRequest(restarted, "GET", "/v1/db24/doc1?history", "")
+	if err = json.Unmarshal(w.Body.Bytes(), &listed); err != nil || len(listed.Versions) != 4 {
+		t.Errorf("TestDocHistory failed, expected 4 versions after recovery, got %s", w.Body.String())
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	handler, _ := setup("Allschema.json")
+	w := doRequest(handler, "GET", "/metrics", "")
+	var metrics struct {
+		Subscriptions struct {
+			Delivered    *int64 `json:"delivered"`
+			Dropped      *int64 `json:"dropped"`
+			Disconnected *int64 `json:"disconnected"`
+		} `json:"subscriptions"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &metrics); err != nil || w.Code != http.StatusOK || metrics.Subscriptions.Dropped == nil || metrics.Subscriptions.Disconnected == nil {
+		t.Errorf("TestMetrics failed, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestSubscriptionCleanup(t *testing.T) {
+	handler, _ := setup("Allschema.json")
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	doRequest(handler, "PUT", "/v1/db24", "")
+	doRequest(handler, "PUT", "/v1/db24/doc1", `{"a":1}`)
+	doRequest(handler, "PUT", "/v1/db24/doc1/col/", "")
+	doRequest(handler, "PUT", "/v1/db24/doc1/col/doc2", `{"a":1}`)
+
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}, Timeout: 5 * time.Second}
+	subscribe := func(ctx context.Context, target string) *http.Response {
+		req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL+target, nil)
+		req.Header.Set("Authorization", "Bearer ADMIN")
+		resp, err := client.Do(req)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("TestSubscriptionCleanup failed, unable to subscribe to %s: %v", target, err)
+		}
+		return resp
+	}
+	listed := func() []map[string]any {
+		var subs []map[string]any
+		json.Unmarshal(doRequest(handler, "GET", "/subscriptions", "").Body.Bytes(), &subs)
+		return subs
+	}
+
+	// Deleting a document ends the subscriptions to it and to everything beneath it
+	var streams []*http.Response
+	for _, target := range []string{"/v1/db24/doc1?mode=subscribe", "/v1/db24/doc1/col/?mode=subscribe", "/v1/db24/doc1/col/doc2?mode=subscribe"} {
+		streams = append(streams, subscribe(context.Background(), target))
+	}
+	if subs := listed(); len(subs) != 3 {
+		t.Fatalf("TestSubscriptionCleanup failed, expected 3 subscriptions, got %v", subs)
+	}
+	doRequest(handler, "DELETE", "/v1/db24/doc1", "")
+	for _, stream := range streams {
+		events, err := io.ReadAll(stream.Body)
+		stream.Body.Close()
+		if err != nil || !strings.Contains(string(events), "event: delete\n") {
+			t.Errorf("TestSubscriptionCleanup failed, expected the stream to end with a delete event, got %q %v", events, err)
+		}
+	}
+	waitForNoSubscriptions := func() bool {
+		for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if len(listed()) == 0 {
+				return true
+			}
+		}
+		return false
+	}
+	if !waitForNoSubscriptions() {
+		t.Errorf("TestSubscriptionCleanup failed, expected no subscriptions after the delete, got %v", listed())
+	}
+
+	// Disconnecting ends a subscription, and a later update reaches no one
+	doRequest(handler, "PUT", "/v1/db24/doc1", `{"a":1}`)
+	ctx, cancel := context.WithCancel(context.Background())
+	subscribe(ctx, "/v1/db24/doc1?mode=subscribe")
+	cancel()
+	if !waitForNoSubscriptions() {
+		t.Errorf("TestSubscriptionCleanup failed, expected no subscriptions after disconnecting, got %v", listed())
+	}
+	if w := doRequest(handler, "PUT", "/v1/db24/doc1", `{"a":2}`); w.Code != http.StatusOK {
+		t.Errorf("TestSubscriptionCleanup failed, expected the update to succeed, got %d", w.Code)
+	}
+}
+
+func TestWebSocketSubscriptions(t *testing.T) {
+	handler, _ := setup("Allschema.json")
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	doRequest(handler, "PUT", "/v1/db24", "")
+	doRequest(handler, "PUT", "/v1/db24/doc1", `{"a":1}`)
+	doRequest(handler, "PUT", "/v1/db24/doc1/col/", "")
+
+	conn, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", http.Header{"Authorization": {"Bearer ADMIN"}})
+	if err != nil {
+		t.Fatalf("TestWebSocketSubscriptions failed, unable to connect: %v", err)
+	}
+	defer conn.Close()
+	type message struct {
+		Type  string          `json:"type"`
+		Id    string          `json:"id"`
+		Event string          `json:"event"`
+		Data  json.RawMessage `json:"data"`
+	}
+	next := func() message {
+		raw, err := conn.ReadMessage()
+		var msg message
+		if err != nil || json.Unmarshal(raw, &msg) != nil {
+			t.Fatalf("TestWebSocketSubscriptions failed, expected a message, got %s %v", raw, err)
+		}
+		return msg
+	}
+
+	conn.WriteMessage([]byte(`{"op":"subscribe","id":"doc","path":"/db24/doc1"}`))
+	if msg := next(); msg.Type != "subscribed" {
+		t.Fatalf("TestWebSocketSubscriptions failed, got %+v", msg)
+	}
+	if msg := next(); msg.Type != "event" || msg.Event != "update" || !strings.Contains(string(msg.Data), `"a":1`) {
+		t.Errorf("TestWebSocketSubscriptions failed, expected the current document, got %+v", msg)
+	}
+	conn.WriteMessage([]byte(`{"op":"subscribe","id":"col","path":"/db24/doc1/col/"}`))
+	if msg := next(); msg.Type != "subscribed" || msg.Id != "col" {
+		t.Fatalf("TestWebSocketSubscriptions failed, got %+v", msg)
+	}
+
+	doRequest(handler, "PUT", "/v1/db24/doc1/col/doc2", `{"b":2}`)
+	if msg := next(); msg.Id != "col" || msg.Event != "update" || !strings.Contains(string(msg.Data), `"b":2`) {
+		t.Errorf("TestWebSocketSubscriptions failed, expected an update to the collection, got %+v", msg)
+	}
+	doRequest(handler, "DELETE", "/v1/db24/doc1", "")
+	got := make(map[string]bool)
+	for range 4 {
+		msg := next()
+		got[msg.Id+" "+msg.Type+" "+msg.Event] = true
+	}
+	for _, want := range []string{"doc event delete", "doc unsubscribed ", "col event delete", "col unsubscribed "} {
+		if !got[want] {
+			t.Errorf("TestWebSocketSubscriptions failed, expected %q after the delete, got %v", want, got)
+		}
+	}
+}
+
+func TestSubtreeSubscription(t *testing.T) {
+	handler, _ := setup("Allschema.json")
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	doRequest(handler, "PUT", "/v1/db24", "")
+	doRequest(handler, "PUT", "/v1/db24/doc1", `{"a":1}`)
+	doRequest(handler, "PUT", "/v1/db24/doc1/col/", "")
+	doRequest(handler, "PUT", "/v1/db24/doc1/col/doc2", `{"b":2}`)
+	doRequest(handler, "PUT", "/v1/db24/doc9", `{"z":9}`)
+
+	req, _ := http.NewRequest("GET", srv.URL+"/v1/db24/doc1?mode=subscribe&depth=infinite", nil)
+	req.Header.Set("Authorization", "Bearer ADMIN")
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}, Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("TestSubtreeSubscription failed, unable to subscribe: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// Changes anywhere beneath the document are delivered, and changes elsewhere are not
+	doRequest(handler, "PUT", "/v1/db24/doc1/col/doc2/sub/", "")
+	doRequest(handler, "PUT", "/v1/db24/doc1/col/doc2/sub/doc3", `{"c":3}`)
+	doRequest(handler, "PUT", "/v1/db24/doc9", `{"z":10}`)
+	doRequest(handler, "DELETE", "/v1/db24/doc1/col/doc2/sub/doc3", "")
+	doRequest(handler, "DELETE", "/v1/db24/doc1/col/doc2/sub/", "")
+	doRequest(handler, "DELETE", "/v1/db24/doc1", "")
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("TestSubtreeSubscription failed, unable to read the stream: %v", err)
+	}
+	events := string(raw)
+	order := []string{
+		`"path":"/doc1"`,
+		`"path":"/doc1/col/doc2"`,
+		"event: update\ndata: \"/doc1/col/doc2/sub/\"\n",
+		`"path":"/doc1/col/doc2/sub/doc3"`,
+		"event: delete\ndata: /doc1/col/doc2/sub/doc3\n",
+		"event: delete\ndata: \"/doc1/col/doc2/sub/\"\n",
+		"event: delete\ndata: \"/doc1\"\n",
+	}
+	last := -1
+	for _, want := range order {
+		i := strings.Index(events, want)
+		if i <= last {
+			t.Fatalf("TestSubtreeSubscription failed, expected %q after the previous events, got %q", want, events)
+		}
+		last = i
+	}
+	if strings.Contains(events, "/doc9") {
+		t.Errorf("TestSubtreeSubscription failed, expected no events outside the subtree, got %q", events)
+	}
+
+	// A subtree subscription requires subscribing
+	if w := doRequest(handler, "GET", "/v1/db24/doc9?depth=infinite", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("TestSubtreeSubscription failed, expected 400 without subscribing, got %d", w.Code)
+	}
+}
+
+func TestFilteredSubscription(t *testing.T) {
+	handler, _ := setup("Allschema.json")
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	doRequest(handler, "PUT", "/v1/db24", "")
+	doRequest(handler, "PUT", "/v1/db24/doc1", `{"a":1}`)
+	doRequest(handler, "PUT", "/v1/db24/doc1/col/", "")
+	doRequest(handler, "PUT", "/v1/db24/doc1/col/t1", `{"status":"open"}`)
+	doRequest(handler, "PUT", "/v1/db24/doc1/col/t2", `{"status":"closed"}`)
+
+	req, _ := http.NewRequest("GET", srv.URL+"/v1/db24/doc1/col/?mode=subscribe&filter="+url.QueryEscape(`status="open"`), nil)
+	req.Header.Set("Authorization", "Bearer ADMIN")
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}, Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("TestFilteredSubscription failed, unable to subscribe: %v", err)
+	}
+	defer resp.Body.Close()
+
+	doRequest(handler, "PUT", "/v1/db24/doc1/col/t2", `{"status":"open"}`)
+	doRequest(handler, "PUT", "/v1/db24/doc1/col/t3", `{"status":"closed"}`)
+	doRequest(handler, "PUT", "/v1/db24/doc1/col/t1", `{"status":"closed"}`)
+	doRequest(handler, "PUT", "/v1/db24/doc1/col/t2", `{"status":"open","owner":"x"}`)
+	doRequest(handler, "DELETE", "/v1/db24/doc1/col/", "")
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("TestFilteredSubscription failed, unable to read the stream: %v", err)
+	}
+	events := string(raw)
+	order := []string{
+		"event: update\ndata: {\"path\":\"/doc1/col/t1\"",
+		"event: enter\ndata: {\"path\":\"/doc1/col/t2\"",
+		"event: leave\ndata: \"/doc1/col/t1\"\n",
+		"event: update\ndata: {\"path\":\"/doc1/col/t2\",\"doc\":{\"owner\":\"x\"",
+		"event: delete\n",
+	}
+	last := -1
+	for _, want := range order {
+		i := strings.Index(events, want)
+		if i <= last {
+			t.Fatalf("TestFilteredSubscription failed, expected %q after the previous events, got %q", want, events)
+		}
+		last = i
+	}
+	if strings.Contains(events, "/doc1/col/t3") {
+		t.Errorf("TestFilteredSubscription failed, expected no events about documents never matching, got %q", events)
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	handler, _ := setup("Allschema.json")
+	type delivery struct {
+		event  webhook.Event
+		signed bool
+	}
+	received := make(chan delivery, 16)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var ev webhook.Event
+		json.Unmarshal(body, &ev)
+		received <- delivery{ev, r.Header.Get(webhook.SignatureHeader) == webhook.Sign("secret", body)}
+	}))
+	defer receiver.Close()
+	unreachable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer unreachable.Close()
+
+	doRequest(handler, "PUT", "/v1/db24", "")
+	doRequest(handler, "PUT", "/v1/db24/doc1", `{"a":1}`)
+	if w := doRequest(handler, "POST", "/webhooks/db24", `{"url":"`+receiver.URL+`","prefix":"/doc1/","secret":"secret"}`); w.Code != http.StatusCreated {
+		t.Fatalf("TestWebhooks failed, unable to register a webhook: %d %s", w.Code, w.Body.String())
+	}
+	if w := doRequest(handler, "POST", "/webhooks/db24", `{"url":"`+unreachable.URL+`","events":["delete"],"secret":"secret"}`); w.Code != http.StatusCreated {
+		t.Fatalf("TestWebhooks failed, unable to register a webhook: %d %s", w.Code, w.Body.String())
+	}
+
+	doRequest(handler, "PUT", "/v1/db24/doc2", `{"a":2}`) //outside the prefix
+	doRequest(handler, "PUT", "/v1/db24/doc1/col/", "")
+	doRequest(handler, "PUT", "/v1/db24/doc1/col/doc3", `{"c":3}`)
+	doRequest(handler, "DELETE", "/v1/db24/doc1/col/doc3", "")
+	want := []struct{ typ, path string }{{"update", "/doc1/col/"}, {"update", "/doc1/col/doc3"}, {"delete", "/doc1/col/doc3"}}
+	for _, w := range want {
+		select {
+		case d := <-received:
+			if d.event.Type != w.typ || d.event.Path != w.path || d.event.Database != "db24" || !d.signed {
+				t.Errorf("TestWebhooks failed, expected %s %s, got %+v (signed: %v)", w.typ, w.path, d.event, d.signed)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("TestWebhooks failed, expected %s %s to be delivered", w.typ, w.path)
+		}
+	}
+
+	var dead []webhook.DeadLetter
+	for deadline := time.Now().Add(2 * time.Second); len(dead) == 0 && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		json.Unmarshal(doRequest(handler, "GET", "/webhooks/db24/dead-letters", "").Body.Bytes(), &dead)
+	}
+	if len(dead) != 1 || dead[0].Event.Path != "/doc1/col/doc3" || dead[0].Attempts != webhookRetry.Attempts {
+		t.Errorf("TestWebhooks failed, expected the undelivered delete to be dead-lettered, got %+v", dead)
+	}
+}
+
+func TestChangeFeed(t *testing.T) {
+	handler, _ := setup("Allschema.json")
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	doRequest(handler, "PUT", "/v1/db24", "")
+	doRequest(handler, "PUT", "/v1/db24/doc1", `{"a":1}`)
+	doRequest(handler, "PUT", "/v1/db24/doc1/col/", "")
+	doRequest(handler, "PUT", "/v1/db24/doc1", `{"a":2}`)
+	doRequest(handler, "PUT", "/v1/db24/doc1/col/doc2", `{"b":2}`)
+	doRequest(handler, "DELETE", "/v1/db24/doc1/col/doc2", "")
+	doRequest(handler, "DELETE", "/v1/db24/doc1/col/", "")
+	doRequest(handler, "POST", "/v1/db24?transaction", `[{"op":"put","path":"/doc3","doc":{"c":3}},{"op":"delete","path":"/doc1"}]`)
+
+	// Every mutation is listed in order, numbered without gaps
+	w := doRequest(handler, "GET", "/v1/db24?changes", "")
+	var list []changes.Change
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &list) != nil {
+		t.Fatalf("TestChangeFeed failed, expected the changes to be listed, got %d %s", w.Code, w.Body.String())
+	}
+	epoch := w.Header().Get(server.EpochHeader)
+	want := []struct{ op, path, body string }{
+		{"createdb", "/", ""},
+		{"putdoc", "/doc1", `{"a":1}`},
+		{"putcol", "/doc1/col/", ""},
+		{"putdoc", "/doc1", `{"a":2}`},
+		{"putdoc", "/doc1/col/doc2", `{"b":2}`},
+		{"deletedoc", "/doc1/col/doc2", ""},
+		{"deletecol", "/doc1/col/", ""},
+		{"putdoc", "/doc3", `{"c":3}`},
+		{"deletedoc", "/doc1", ""},
+	}
+	if len(list) != len(want) || epoch == "" {
+		t.Fatalf("TestChangeFeed failed, expected %d changes in an epoch, got %s (epoch %q)", len(want), w.Body.String(), epoch)
+	}
+	for i, change := range list {
+		if change.Seq != int64(i+1) || change.Op != want[i].op || change.Path != want[i].path || string(change.Body) != want[i].body || change.User != "Fernando" {
+			t.Errorf("TestChangeFeed failed, expected change %d to be %+v, got %+v", i+1, want[i], change)
+		}
+	}
+
+	// A consumer resumes after its checkpoint
+	if w := doRequest(handler, "GET", "/v1/db24?changes&since=7&epoch="+epoch, ""); json.Unmarshal(w.Body.Bytes(), &list) != nil || len(list) != 2 || list[0].Seq != 8 {
+		t.Errorf("TestChangeFeed failed, expected the changes after 7, got %d %s", w.Code, w.Body.String())
+	}
+	for target, status := range map[string]int{
+		"/v1/db24?changes&since=100":       http.StatusGone,
+		"/v1/db24?changes&epoch=1":         http.StatusGone,
+		"/v1/db24?changes&since=x":         http.StatusBadRequest,
+		"/v1/missing?changes":              http.StatusNotFound,
+		"/v1/db24?changes&mode=subscribed": http.StatusBadRequest,
+	} {
+		if w := doRequest(handler, "GET", target, ""); w.Code != status {
+			t.Errorf("TestChangeFeed failed, expected %d for %s, got %d", status, target, w.Code)
+		}
+	}
+
+	// A streaming consumer resumes from its last event, then receives every later change
+	req, _ := http.NewRequest("GET", srv.URL+"/v1/db24?changes&mode=subscribe", nil)
+	req.Header.Set("Authorization", "Bearer ADMIN")
+	req.Header.Set("Last-Event-ID", "8")
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}, Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("TestChangeFeed failed, unable to stream the changes: %v", err)
+	}
+	defer resp.Body.Close()
+	doRequest(handler, "PUT", "/v1/db24/doc4", `{"d":4}`)
+	var ids []string
+	scanner := bufio.NewScanner(resp.Body)
+	for len(ids) < 2 && scanner.Scan() {
+		if id, found := strings.CutPrefix(scanner.Text(), "id: "); found {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) != 2 || ids[0] != "9" || ids[1] != "10" {
+		t.Errorf("TestChangeFeed failed, expected changes 9 and 10 to be streamed, got %v", ids)
+	}
+}
+
+func TestChangeFeedRecovery(t *testing.T) {
+	dir := t.TempDir()
+	wal, err := persistence.Open(dir)
+	if err != nil {
+		t.Fatalf("unable to open the write-ahead log: %s", err.Error())
+	}
+	handler, _, _, src := setupWithJournal("Allschema.json", wal)
+	doRequest(handler, "PUT", "/v1/db24", "")
+	doRequest(handler, "PUT", "/v1/db24/doc1", `{"a":1}`)
+	doRequest(handler, "PUT", "/v1/db24/doc1/col/", "")
+	doRequest(handler, "PUT", "/v1/db25", "")
+	doRequest(handler, "POST", "/v1/db24?transaction", `[{"op":"put","path":"/doc3","doc":{"c":3}},{"op":"delete","path":"/doc1"}]`)
+	w := doRequest(handler, "GET", "/v1/db24?changes", "")
+	epoch := w.Header().Get(server.EpochHeader)
+	var before, list []changes.Change
+	json.Unmarshal(w.Body.Bytes(), &before)
+	wal.Close()
+
+	//simulating a restart
+	wal, err = persistence.Open(dir)
+	if err != nil {
+		t.Fatalf("unable to reopen the write-ahead log: %s", err.Error())
+	}
+	restarted, rcs, rds, src := setupWithJournal("Allschema.json", wal)
+	if err = wal.Replay(rcs, rds); err != nil {
+		t.Fatalf("replay failed: %s", err.Error())
+	}
+
+	// A checkpoint taken before the restart still holds, and replay adds no changes
+	w = doRequest(restarted, "GET", "/v1/db24?changes&epoch="+epoch, "")
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if w.Code != http.StatusOK || len(list) != len(before) || len(list) != 5 {
+		t.Fatalf("TestChangeFeedRecovery failed, expected the changes %+v to survive the restart, got %d %s", before, w.Code, w.Body.String())
+	}
+	for i, change := range list {
+		if change.Seq != before[i].Seq || change.Op != before[i].Op || change.Path != before[i].Path || string(change.Body) != string(before[i].Body) || change.User != before[i].User {
+			t.Errorf("TestChangeFeedRecovery failed, expected change %+v after the restart, got %+v", before[i], change)
+		}
+	}
+	doRequest(restarted, "PUT", "/v1/db24/doc4", `{"d":4}`)
+	w = doRequest(restarted, "GET", "/v1/db24?changes&since=3&epoch="+epoch, "")
+	if json.Unmarshal(w.Body.Bytes(), &list) != nil || len(list) != 3 || list[0].Seq != 4 || list[2].Seq != 6 || list[2].Path != "/doc4" {
+		t.Errorf("TestChangeFeedRecovery failed, expected changes 4 to 6, got %d %s", w.Code, w.Body.String())
+	}
+
+	// Once a snapshot leaves no change of a database in the log, its numbering still survives
+	for i := 0; i < 2; i++ {
+		if err = wal.Snapshot(context.Background(), src, 1); err != nil {
+			t.Fatalf("snapshot failed: %s", err.Error())
+		}
+	}
+	wal.Close()
+	wal, err = persistence.Open(dir)
+	if err != nil {
+		t.Fatalf("unable to reopen the write-ahead log: %s", err.Error())
+	}
+	defer wal.Close()
+	restarted, rcs, rds, _ = setupWithJournal("Allschema.json", wal)
+	wal.Replay(rcs, rds)
+	if w = doRequest(restarted, "GET", "/v1/db25?changes&since=1&epoch="+epoch, ""); w.Code != http.StatusOK || w.Body.String() != "[]" {
+		t.Errorf("TestChangeFeedRecovery failed, expected no changes after the last one, got %d %s", w.Code, w.Body.String())
+	}
+	if w = doRequest(restarted, "GET", "/v1/db25?changes&since=0", ""); w.Code != http.StatusGone {
+		t.Errorf("TestChangeFeedRecovery failed, expected changes no longer logged to be gone, got %d", w.Code)
+	}
+	doRequest(restarted, "PUT", "/v1/db24/doc5", `{"e":5}`)
+	if w = doRequest(restarted, "GET", "/v1/db24?changes&since=6", ""); json.Unmarshal(w.Body.Bytes(), &list) != nil || len(list) != 1 || list[0].Seq != 7 {
+		t.Errorf("TestChangeFeedRecovery failed, expected the numbering to continue from 7, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+// login logs username in with password, returning the token and the status of the response
+func login(handler http.Handler, username string, password string) (string, int) {
+	body, _ := json.Marshal(map[string]string{"username": username, "password": password})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/auth", strings.NewReader(string(body))))
+	var token tokenResp
+	json.Unmarshal(w.Body.Bytes(), &token)
+	return token.Token, w.Code
+}
+
+func TestUserAccounts(t *testing.T) {
+	handler, _ := setup("Allschema.json")
+	if _, code := login(handler, "Fernando", "hawk"); code != http.StatusUnauthorized {
+		t.Errorf("TestUserAccounts failed, expected a wrong password to be rejected, got %d", code)
+	}
+	if _, code := login(handler, "amy", "pw"); code != http.StatusUnauthorized {
+		t.Errorf("TestUserAccounts failed, expected an unknown user to be rejected, got %d", code)
+	}
+	if w := doRequest(handler, "POST", "/users", `{"username":"amy","password":"pw"}`); w.Code != http.StatusCreated || w.Header().Get("Location") != "/users/amy" {
+		t.Fatalf("TestUserAccounts failed, expected amy to be created, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := doRequest(handler, "POST", "/users", `{"username":"amy","password":"other"}`); w.Code != http.StatusConflict {
+		t.Errorf("TestUserAccounts failed, expected amy to exist, got %d", w.Code)
+	}
+	if w := doRequest(handler, "GET", "/users", ""); w.Body.String() != `[{"username":"Fernando","admin":true},{"username":"TestUser","admin":false},{"username":"amy","admin":false}]` {
+		t.Errorf("TestUserAccounts failed, unexpected accounts %s", w.Body.String())
+	}
+
+	token, code := login(handler, "amy", "pw")
+	if code != http.StatusOK {
+		t.Fatalf("TestUserAccounts failed, expected amy to log in, got %d", code)
+	}
+	req := httptest.NewRequest("DELETE", "/users/TestUser", strings.NewReader(""))
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("TestUserAccounts failed, expected a regular user to be forbidden from managing users, got %d", w.Code)
+	}
+
+	//resetting the password ends the sessions of amy
+	if w := doRequest(handler, "PUT", "/users/amy/password", `{"password":"pw2"}`); w.Code != http.StatusNoContent {
+		t.Errorf("TestUserAccounts failed, expected the password to be reset, got %d", w.Code)
+	}
+	req = httptest.NewRequest("PUT", "/v1/db24", strings.NewReader(""))
+	req.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("TestUserAccounts failed, expected the old session to end, got %d", w.Code)
+	}
+	if _, code := login(handler, "amy", "pw"); code != http.StatusUnauthorized {
+		t.Errorf("TestUserAccounts failed, expected the old password to be rejected, got %d", code)
+	}
+	if _, code := login(handler, "amy", "pw2"); code != http.StatusOK {
+		t.Errorf("TestUserAccounts failed, expected the new password to be accepted, got %d", code)
+	}
+
+	if w := doRequest(handler, "DELETE", "/users/amy", ""); w.Code != http.StatusNoContent {
+		t.Errorf("TestUserAccounts failed, expected amy to be deleted, got %d", w.Code)
+	}
+	if _, code := login(handler, "amy", "pw2"); code != http.StatusUnauthorized {
+		t.Errorf("TestUserAccounts failed, expected a deleted user to be rejected, got %d", code)
+	}
+	if w := doRequest(handler, "DELETE", "/users/amy", ""); w.Code != http.StatusNotFound {
+		t.Errorf("TestUserAccounts failed, expected amy to be gone, got %d", w.Code)
+	}
+}
+
+// doRequestAs sends a request on behalf of the user whose session token is token
+func doRequestAs(handler http.Handler, token string, method string, target string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestRolesInherited(t *testing.T) {
+	handler, _ := setup("Allschema.json")
+	for _, target := range []string{"/v1/db24", "/v1/db24/top", "/v1/db24/top/users/", "/v1/db24/top/users/amy", "/v1/db24/top/users/amy/notes/", "/v1/db24/top/users/amy/notes/n1", "/v1/db24/other"} {
+		if w := doRequest(handler, "PUT", target, `{}`); w.Code != http.StatusCreated {
+			t.Fatalf("TestRolesInherited failed, unable to create %s: %d", target, w.Code)
+		}
+	}
+	grants := map[string]string{"amy": `{"path":"/db24/top","role":"reader"}`, "bob": `{"path":"/db24/top/users/","role":"writer"}`, "carol": `{"path":"/db24/top","role":"admin"}`}
+	tokens := make(map[string]string)
+	for user, grant := range grants {
+		doRequest(handler, "POST", "/users", `{"username":"`+user+`","password":"pw"}`)
+		if w := doRequest(handler, "PUT", "/users/"+user+"/grants", grant); w.Code != http.StatusNoContent {
+			t.Fatalf("TestRolesInherited failed, unable to grant %s: %d %s", user, w.Code, w.Body.String())
+		}
+		tokens[user], _ = login(handler, user, "pw")
+	}
+
+	tests := []struct {
+		user   string
+		method string
+		target string
+		body   string
+		status int
+	}{
+		//a reader reads everything beneath its grant, and nothing else
+		{"amy", "GET", "/v1/db24/top", "", http.StatusOK},
+		{"amy", "GET", "/v1/db24/top/users/", "", http.StatusOK},
+		{"amy", "GET", "/v1/db24/top/users/amy/notes/n1", "", http.StatusOK},
+		{"amy", "GET", "/v1/db24/other", "", http.StatusForbidden},
+		{"amy", "GET", "/v1/db24/", "", http.StatusForbidden},
+		{"amy", "PUT", "/v1/db24/top/users/amy", `{"a":1}`, http.StatusForbidden},
+		{"amy", "DELETE", "/v1/db24/top/users/amy/notes/n1", "", http.StatusForbidden},
+		//a writer writes beneath its grant, at any depth, but cannot read above it
+		{"bob", "PUT", "/v1/db24/top/users/bob", `{}`, http.StatusCreated},
+		{"bob", "PUT", "/v1/db24/top/users/amy/notes/n2", `{}`, http.StatusCreated},
+		{"bob", "PATCH", "/v1/db24/top/users/amy", `[{"op":"ObjectAdd","path":"/a","value":1}]`, http.StatusOK},
+		{"bob", "DELETE", "/v1/db24/top/users/amy/notes/n1", "", http.StatusNoContent},
+		{"bob", "GET", "/v1/db24/top/users/amy", "", http.StatusOK},
+		{"bob", "GET", "/v1/db24/top", "", http.StatusForbidden},
+		{"bob", "PUT", "/v1/db24/top", `{}`, http.StatusForbidden},
+		{"bob", "PUT", "/v1/db24/top/users/?index=" + url.QueryEscape("/a"), "", http.StatusForbidden},
+		{"bob", "POST", "/v1/db24?transaction", `[{"op":"put","path":"/top/users/bob","doc":{"b":1}}]`, http.StatusOK},
+		{"bob", "POST", "/v1/db24?transaction", `[{"op":"put","path":"/top/users/bob","doc":{"b":2}},{"op":"put","path":"/other","doc":{}}]`, http.StatusForbidden},
+		//an admin manages indexes and grants beneath its grant, but not the database above it
+		{"carol", "PUT", "/v1/db24/top/users/?index=" + url.QueryEscape("/a"), "", http.StatusCreated},
+		{"carol", "PUT", "/users/bob/grants", `{"path":"/db24/top","role":"reader"}`, http.StatusNoContent},
+		{"bob", "GET", "/v1/db24/top", "", http.StatusOK},
+		{"bob", "PUT", "/v1/db24/top", `{}`, http.StatusForbidden},
+		{"carol", "PUT", "/users/bob/grants", `{"path":"/db24","role":"reader"}`, http.StatusForbidden},
+		{"carol", "DELETE", "/users/amy/grants?path=/db24/top", "", http.StatusNoContent},
+		{"amy", "GET", "/v1/db24/top", "", http.StatusForbidden},
+		{"carol", "DELETE", "/v1/db24", "", http.StatusForbidden},
+		{"carol", "GET", "/v1/db24?export=jsonl", "", http.StatusForbidden},
+		{"carol", "GET", "/users", "", http.StatusForbidden},
+		{"amy", "GET", "/users/amy/grants", "", http.StatusOK},
+		{"amy", "GET", "/users/bob/grants", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if w := doRequestAs(handler, tokens[tt.user], tt.method, tt.target, tt.body); w.Code != tt.status {
+			t.Errorf("TestRolesInherited failed for %s %s by %s, expected %d, got %d: %s", tt.method, tt.target, tt.user, tt.status, w.Code, w.Body.String())
+		}
+	}
+	if w := doRequest(handler, "GET", "/users/bob/grants", ""); w.Body.String() != `[{"path":"/db24/top","role":"reader"},{"path":"/db24/top/users/","role":"writer"}]` {
+		t.Errorf("TestRolesInherited failed, unexpected grants %s", w.Body.String())
+	}
+}
+
+func TestTokenFileOnly(t *testing.T) {
+	//started with -t alone: no account exists, and the users of the token file hold the default token role
+	handler, _, _, _ := setupWithUsers("Allschema.json", persistence.Discard{}, func(users *auth.Credentials) {
+		users.SetTokenRole(auth.Admin)
+	})
+	requests := []struct {
+		method string
+		target string
+		body   string
+		want   int
+	}{
+		{"PUT", "/v1/db24", "", http.StatusCreated},
+		{"PUT", "/v1/db24/doc1", `{"prop":"value"}`, http.StatusCreated},
+		{"GET", "/v1/db24/doc1", "", http.StatusOK},
+		{"DELETE", "/v1/db24", "", http.StatusNoContent},
+	}
+	for _, tt := range requests {
+		req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+		req.Header.Set("Authorization", "Bearer ADMIN")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("TestTokenFileOnly failed, expected %d for %s %s, got %d: %s", tt.want, tt.method, tt.target, w.Code, w.Body.String())
+		}
+	}
+
+	handler, _, _, _ = setupWithUsers("Allschema.json", persistence.Discard{}, func(users *auth.Credentials) {
+		users.SetTokenRole(auth.Reader)
+	})
+	req := httptest.NewRequest("PUT", "/v1/db24", nil)
+	req.Header.Set("Authorization", "Bearer ADMIN")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("TestTokenFileOnly failed, expected a reader to be refused, got %d", w.Code)
+	}
+}
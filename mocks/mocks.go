@@ -4,7 +4,10 @@ import (
 	"cmp"
 	"context"
 	"encoding/json"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/fieldIndex"
 	"github.com/RICE-COMP318-FALL24/owldb-p1group24/index_utils"
+	"slices"
+	"strings"
 	"sync"
 )
 
@@ -59,6 +62,23 @@ func (m *MockSL[K, V]) Remove(key K) (foundValue V, found bool) {
 	return v, true
 }
 
+func (m *MockSL[K, V]) RemoveIf(key K, check index_utils.RemoveCheck[K, V]) (foundValue V, found bool, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, ok := m.sl[key]
+	if !ok {
+		return v, false, nil
+	}
+	if check != nil {
+		if err := check(key, v); err != nil {
+			var nullV V
+			return nullV, false, err
+		}
+	}
+	delete(m.sl, key)
+	return v, true, nil
+}
+
 func (m *MockSL[K, V]) Query(ctx context.Context, low K, hi K) (result []index_utils.Pair[K, V], err error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -69,13 +89,15 @@ func (m *MockSL[K, V]) Query(ctx context.Context, low K, hi K) (result []index_u
 			res = append(res, p)
 		}
 	}
+	//in key order, as the skip list returns them
+	slices.SortFunc(res, func(a, b index_utils.Pair[K, V]) int { return cmp.Compare(a.Key, b.Key) })
 	return res, nil
 }
 
 func (m *MockSL[K, V]) Upsert(key K, check index_utils.UpdateCheck[K, V]) (bool, error) {
-	curVal, exists := m.sl[key]
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	curVal, exists := m.sl[key]
 	newVal, err := check(key, curVal, exists)
 	if err != nil {
 		return false, err
@@ -84,3 +106,61 @@ func (m *MockSL[K, V]) Upsert(key K, check index_utils.UpdateCheck[K, V]) (bool,
 	return true, nil
 
 }
+
+// MockJournal records the operations journaled through it, so tests can check what would have been persisted.
+// Setting Err makes it refuse every operation instead, as a write-ahead log that failed to write does.
+type MockJournal struct {
+	Ops []string
+	Err error
+	mu  sync.Mutex
+}
+
+func (m *MockJournal) record(op string, dbName string, path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return m.Err
+	}
+	m.Ops = append(m.Ops, op+" "+dbName+"/"+path)
+	return nil
+}
+
+func (m *MockJournal) RecordCreateDB(dbName string, user string) error {
+	return m.record("createdb", dbName, "")
+}
+
+func (m *MockJournal) RecordDeleteDB(dbName string, user string) error {
+	return m.record("deletedb", dbName, "")
+}
+
+func (m *MockJournal) RecordPutDoc(dbName string, docpath string, serial []byte) error {
+	return m.record("putdoc", dbName, docpath)
+}
+
+func (m *MockJournal) RecordPutCol(dbName string, colpath string, user string) error {
+	return m.record("putcol", dbName, colpath)
+}
+
+func (m *MockJournal) RecordDeleteDoc(dbName string, docpath string, user string) error {
+	return m.record("deletedoc", dbName, docpath)
+}
+
+func (m *MockJournal) RecordDeleteCol(dbName string, colpath string, user string) error {
+	return m.record("deletecol", dbName, colpath)
+}
+
+func (m *MockJournal) RecordImportDB(dbName string, entries []byte, user string) error {
+	return m.record("importdb", dbName, "")
+}
+
+func (m *MockJournal) RecordPutIndex(dbName string, colpath string, def fieldIndex.Definition) error {
+	return m.record("putindex", dbName, colpath+" "+def.Field)
+}
+
+func (m *MockJournal) RecordDeleteIndex(dbName string, colpath string, field string) error {
+	return m.record("deleteindex", dbName, colpath+" "+field)
+}
+
+func (m *MockJournal) RecordTransaction(dbName string, docpaths []string, serials [][]byte, user string) error {
+	return m.record("transaction", dbName, strings.Join(docpaths, ","))
+}
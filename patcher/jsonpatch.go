@@ -0,0 +1,273 @@
+package patcher
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/jsondata"
+)
+
+// jsonPatchOperation is a single operation of an RFC 6902 JSON Patch. OwlDB's own operations are accepted as
+// extensions, so that both kinds can be mixed in one patch.
+type jsonPatchOperation struct {
+	Op    string             // one of add, remove, replace, move, copy, test, ArrayAdd, ArrayRemove or ObjectAdd
+	Path  []string           // the segments of the pointer to the target location
+	From  []string           // the segments of the pointer to the source location of move and copy
+	Value jsondata.JSONValue // the value of add, replace and test
+
+	path string // the pointer to the target location, as written in the patch
+	from string // the pointer to the source location, as written in the patch
+}
+
+// DoJSONPatch applies rawPatches, an RFC 6902 JSON Patch, to the document oldRawDoc. The operations are applied in
+// order, and if any of them fails the document is left as it was.
+// Returns the patched document, or an error starting with "bad patch operation" if the patch is malformed, or another
+// error if an operation cannot be applied
+func (p Patcher) DoJSONPatch(oldRawDoc []byte, rawPatches []byte) (newDoc []byte, err error) {
+	var doc jsondata.JSONValue
+	if err := json.Unmarshal(oldRawDoc, &doc); err != nil {
+		return oldRawDoc, fmt.Errorf("malformed raw document payload")
+	}
+	ops, err := parseJSONPatch(rawPatches)
+	if err != nil {
+		return oldRawDoc, err
+	}
+	for _, op := range ops {
+		if doc, err = op.apply(doc); err != nil {
+			return oldRawDoc, err
+		}
+	}
+	return json.Marshal(doc)
+}
+
+// parseJSONPatch decodes and validates the operations of an RFC 6902 JSON Patch.
+// Returns the operations, or an error starting with "bad patch operation" naming the first malformed one
+func parseJSONPatch(rawPatches []byte) ([]jsonPatchOperation, error) {
+	//members are kept raw so that a missing value can be told apart from null
+	var members []map[string]json.RawMessage
+	if err := json.Unmarshal(rawPatches, &members); err != nil {
+		return nil, fmt.Errorf("bad patch operation: a JSON Patch must be an array of objects")
+	}
+	ops := make([]jsonPatchOperation, len(members))
+	for i, m := range members {
+		op := &ops[i]
+		if err := json.Unmarshal(m["op"], &op.Op); err != nil {
+			return nil, fmt.Errorf("bad patch operation: operation %d has no op", i)
+		}
+		if err := json.Unmarshal(m["path"], &op.path); err != nil {
+			return nil, fmt.Errorf("bad patch operation: operation %d has no path", i)
+		}
+		var err error
+		if op.Path, err = SplitJSONPointer(op.path); err != nil {
+			return nil, fmt.Errorf("bad patch operation: operation %d has a malformed path", i)
+		}
+		switch op.Op {
+		case "add", "replace", "test", "ArrayAdd", "ArrayRemove", "ObjectAdd":
+			raw, found := m["value"]
+			if !found {
+				return nil, fmt.Errorf("bad patch operation: %s operation %d has no value", op.Op, i)
+			}
+			json.Unmarshal(raw, &op.Value)
+		case "move", "copy":
+			if err := json.Unmarshal(m["from"], &op.from); err != nil {
+				return nil, fmt.Errorf("bad patch operation: %s operation %d has no from", op.Op, i)
+			}
+			if op.From, err = SplitJSONPointer(op.from); err != nil {
+				return nil, fmt.Errorf("bad patch operation: operation %d has a malformed from", i)
+			}
+		case "remove":
+		default:
+			return nil, fmt.Errorf("bad patch operation: %s", op.Op)
+		}
+	}
+	return ops, nil
+}
+
+// apply applies the operation to doc.
+// Returns the resulting document, or an error if the operation cannot be applied
+func (op jsonPatchOperation) apply(doc jsondata.JSONValue) (jsondata.JSONValue, error) {
+	switch op.Op {
+	case "add", "replace":
+		res, _, err := applyAt(doc, op.Op, op.Path, op.path, op.Value)
+		return res, err
+	case "remove":
+		res, _, err := applyAt(doc, "remove", op.Path, op.path, jsondata.JSONValue{})
+		return res, err
+	case "ArrayAdd", "ArrayRemove", "ObjectAdd":
+		res, _, err := applyOperation(doc, PatchOperation{Op: op.Op, Path: op.path, Value: op.Value})
+		if err != nil {
+			return doc, err
+		}
+		return res, nil
+	case "test":
+		_, found, err := applyAt(doc, "get", op.Path, op.path, jsondata.JSONValue{})
+		if err != nil {
+			return doc, err
+		}
+		if !found.Equal(op.Value) {
+			return doc, fmt.Errorf("test failed: the value at path '%s' differs", op.path)
+		}
+		return doc, nil
+	}
+
+	//move and copy
+	_, value, err := applyAt(doc, "get", op.From, op.from, jsondata.JSONValue{})
+	if err != nil {
+		return doc, err
+	}
+	if op.Op == "move" {
+		if op.path == op.from {
+			return doc, nil
+		}
+		if strings.HasPrefix(op.path, op.from+"/") {
+			return doc, fmt.Errorf("cannot move '%s' into its own child '%s'", op.from, op.path)
+		}
+		if doc, _, err = applyAt(doc, "remove", op.From, op.from, jsondata.JSONValue{}); err != nil {
+			return doc, err
+		}
+	}
+	res, _, err := applyAt(doc, "add", op.Path, op.path, value)
+	return res, err
+}
+
+// applyAt applies one of the add, remove, replace or get primitives to the location named by the pointer made of
+// segments within doc; path is the pointer as written in the patch.
+// Returns the resulting document, the value got, replaced or removed, and an error if the location does not exist
+func applyAt(doc jsondata.JSONValue, op string, segments []string, path string, value jsondata.JSONValue) (jsondata.JSONValue, jsondata.JSONValue, error) {
+	if len(segments) == 0 {
+		switch op {
+		case "add", "replace":
+			return value, doc, nil
+		case "get":
+			return doc, doc, nil
+		}
+		return doc, jsondata.JSONValue{}, fmt.Errorf("cannot remove the whole document")
+	}
+	visitor := &pointerVisitor{op: op, segments: segments, path: path, value: value}
+	res, err := jsondata.Accept[jsondata.JSONValue](doc, visitor)
+	if err != nil {
+		return doc, jsondata.JSONValue{}, err
+	}
+	return res, visitor.found, nil
+}
+
+// pointerVisitor applies a primitive operation to the value at the end of a JSON pointer. It walks down the pointer one
+// segment per level, and edits the parent of the location on the way back up.
+type pointerVisitor struct {
+	op       string             // one of add, remove, replace or get
+	segments []string           // the segments of the pointer left to walk
+	path     string             // the whole pointer, for error messages
+	value    jsondata.JSONValue // the value added or replaced
+	found    jsondata.JSONValue // the value got, replaced or removed
+}
+
+// descend applies the operation to the child value at the next segment of the pointer.
+// Returns the updated child
+func (pv *pointerVisitor) descend(child jsondata.JSONValue) (jsondata.JSONValue, error) {
+	next := &pointerVisitor{op: pv.op, segments: pv.segments[1:], path: pv.path, value: pv.value}
+	res, err := jsondata.Accept[jsondata.JSONValue](child, next)
+	pv.found = next.found
+	return res, err
+}
+
+// Map applies the operation to the member of an object named by the next segment of the pointer
+func (pv *pointerVisitor) Map(m map[string]jsondata.JSONValue) (jsondata.JSONValue, error) {
+	key := pv.segments[0]
+	child, exists := m[key]
+	if len(pv.segments) > 1 {
+		if !exists {
+			return jsondata.JSONValue{}, pv.missing()
+		}
+		newChild, err := pv.descend(child)
+		if err != nil {
+			return jsondata.JSONValue{}, err
+		}
+		m[key] = newChild
+		return jsondata.NewJSONValue(m)
+	}
+	if !exists && pv.op != "add" {
+		return jsondata.JSONValue{}, pv.missing()
+	}
+	pv.found = child
+	switch pv.op {
+	case "add", "replace":
+		m[key] = pv.value
+	case "remove":
+		delete(m, key)
+	}
+	return jsondata.NewJSONValue(m)
+}
+
+// Slice applies the operation to the element of an array at the index held by the next segment of the pointer, "-"
+// standing for the end of the array when adding
+func (pv *pointerVisitor) Slice(s []jsondata.JSONValue) (jsondata.JSONValue, error) {
+	last := len(pv.segments) == 1
+	i := len(s)
+	if pv.segments[0] != "-" || !last || pv.op != "add" {
+		var err error
+		if i, err = parseArrayIndex(pv.segments[0]); err != nil {
+			return jsondata.JSONValue{}, fmt.Errorf("bad array index '%s' in path '%s'", pv.segments[0], pv.path)
+		}
+	}
+	if i > len(s) || (i == len(s) && (!last || pv.op != "add")) {
+		return jsondata.JSONValue{}, pv.missing()
+	}
+	if !last {
+		newChild, err := pv.descend(s[i])
+		if err != nil {
+			return jsondata.JSONValue{}, err
+		}
+		s[i] = newChild
+		return jsondata.NewJSONValue(s)
+	}
+	switch pv.op {
+	case "add":
+		s = append(s[:i], append([]jsondata.JSONValue{pv.value}, s[i:]...)...)
+	case "remove":
+		pv.found = s[i]
+		s = append(s[:i], s[i+1:]...)
+	case "replace":
+		pv.found = s[i]
+		s[i] = pv.value
+	case "get":
+		pv.found = s[i]
+	}
+	return jsondata.NewJSONValue(s)
+}
+
+// Bool fails, as a boolean has no child at the next segment of the pointer
+func (pv *pointerVisitor) Bool(bool) (jsondata.JSONValue, error) {
+	return jsondata.JSONValue{}, pv.missing()
+}
+
+// Float64 fails, as a number has no child at the next segment of the pointer
+func (pv *pointerVisitor) Float64(float64) (jsondata.JSONValue, error) {
+	return jsondata.JSONValue{}, pv.missing()
+}
+
+// String fails, as a string has no child at the next segment of the pointer
+func (pv *pointerVisitor) String(string) (jsondata.JSONValue, error) {
+	return jsondata.JSONValue{}, pv.missing()
+}
+
+// Null fails, as null has no child at the next segment of the pointer
+func (pv *pointerVisitor) Null() (jsondata.JSONValue, error) {
+	return jsondata.JSONValue{}, pv.missing()
+}
+
+// missing returns the error reported when the pointer names a location that does not exist
+func (pv *pointerVisitor) missing() error {
+	return fmt.Errorf("path '%s' does not exist in the document", pv.path)
+}
+
+// parseArrayIndex parses a segment of a JSON pointer as an array index, which has no sign and no leading zero.
+// Returns the index, or an error if the segment is not one
+func parseArrayIndex(seg string) (int, error) {
+	if seg == "" || (len(seg) > 1 && seg[0] == '0') || strings.TrimLeft(seg, "0123456789") != "" {
+		return 0, errors.New("not an array index")
+	}
+	return strconv.Atoi(seg)
+}
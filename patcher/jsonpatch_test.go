@@ -0,0 +1,80 @@
+package patcher
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// TestPatcher_DoJSONPatch runs the examples of RFC 6902, appendix A
+func TestPatcher_DoJSONPatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		patch   string
+		want    string
+		wantErr string
+	}{
+		{"add member", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`, ""},
+		{"add element", `{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`, ""},
+		{"remove member", `{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`, ""},
+		{"remove element", `{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`, ""},
+		{"replace", `{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`, ""},
+		{"move member", `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`, `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`, `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`, ""},
+		{"move element", `{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`, ""},
+		{"test success", `{"baz":"qux","foo":["a",2,"c"]}`, `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`, `{"baz":"qux","foo":["a",2,"c"]}`, ""},
+		{"test failure", `{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"bar"}]`, "", "test failed"},
+		{"add nested", `{"foo":"bar"}`, `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`, `{"child":{"grandchild":{}},"foo":"bar"}`, ""},
+		{"ignore unknown members", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux","xyz":123}]`, `{"baz":"qux","foo":"bar"}`, ""},
+		{"add to missing parent", `{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":"qux"}]`, "", "does not exist"},
+		{"escaped pointers", `{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":10}]`, `{"/":9,"~1":10}`, ""},
+		{"compare types", `{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":"10"}]`, "", "test failed"},
+		{"append", `{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, `{"foo":["bar",["abc","def"]]}`, ""},
+		{"copy", `{"foo":{"bar":1}}`, `[{"op":"copy","from":"/foo","path":"/baz"},{"op":"replace","path":"/baz/bar","value":2}]`, `{"baz":{"bar":2},"foo":{"bar":1}}`, ""},
+		{"add null", `{}`, `[{"op":"add","path":"/a","value":null}]`, `{"a":null}`, ""},
+		{"replace root", `{"a":1}`, `[{"op":"replace","path":"","value":{"b":2}}]`, `{"b":2}`, ""},
+		{"mixed with custom ops", `{"tags":["x"]}`, `[{"op":"ArrayAdd","path":"/tags","value":"y"},{"op":"add","path":"/tags/0","value":"w"}]`, `{"tags":["w","x","y"]}`, ""},
+		{"index out of bounds", `{"foo":["bar"]}`, `[{"op":"add","path":"/foo/2","value":1}]`, "", "does not exist"},
+		{"leading zero index", `{"foo":["bar","baz"]}`, `[{"op":"remove","path":"/foo/01"}]`, "", "bad array index"},
+		{"remove append token", `{"foo":["bar"]}`, `[{"op":"remove","path":"/foo/-"}]`, "", "bad array index"},
+		{"move into child", `{"a":{"b":{}}}`, `[{"op":"move","from":"/a","path":"/a/b/c"}]`, "", "own child"},
+		{"replace missing", `{"a":1}`, `[{"op":"replace","path":"/b","value":1}]`, "", "does not exist"},
+		{"unknown op", `{}`, `[{"op":"frobnicate","path":"/a"}]`, "", "bad patch operation"},
+		{"missing value", `{}`, `[{"op":"add","path":"/a"}]`, "", "bad patch operation"},
+		{"missing from", `{}`, `[{"op":"copy","path":"/a"}]`, "", "bad patch operation"},
+		{"bad pointer", `{}`, `[{"op":"remove","path":"a"}]`, "", "bad patch operation"},
+	}
+	for _, test := range tests {
+		newDoc, err := Patcher{}.Apply([]byte(test.doc), []byte(test.patch), MediaTypeJSONPatch)
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("%s: expected an error containing '%s', got %v", test.name, test.wantErr, err)
+			} else if !bytes.Equal(newDoc, []byte(test.doc)) {
+				t.Errorf("%s: expected the document to be left as it was, got %s", test.name, newDoc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+		//reencoding sorts the members of objects
+		var got any
+		json.Unmarshal(newDoc, &got)
+		if gotJSON, _ := json.Marshal(got); string(gotJSON) != test.want {
+			t.Errorf("%s: expected %s, got %s", test.name, test.want, newDoc)
+		}
+	}
+}
+
+func TestPatcher_ApplyOwlDBDialect(t *testing.T) {
+	//the standard operations are only understood when asked for
+	if _, err := (Patcher{}).Apply([]byte(`{}`), []byte(`[{"op":"add","path":"/a","value":1}]`), MediaTypeOwlDB); err == nil || !strings.HasPrefix(err.Error(), "bad patch operation") {
+		t.Errorf("expected the add operation to be rejected, got %v", err)
+	}
+	newDoc, err := Patcher{}.Apply([]byte(`{}`), []byte(`[{"op":"ObjectAdd","path":"/a","value":1}]`), MediaTypeOwlDB)
+	if err != nil || string(newDoc) != `{"a":1}` {
+		t.Errorf("expected ObjectAdd to be applied, got %s and %v", newDoc, err)
+	}
+}
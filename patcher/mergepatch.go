@@ -0,0 +1,115 @@
+package patcher
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/jsondata"
+)
+
+// DoMergePatch applies rawPatch, an RFC 7386 JSON Merge Patch, to the document oldRawDoc: the members of an object
+// in the patch are merged into the document recursively, null members deleting theirs, and any other value replaces
+// the one it is merged into.
+// Returns the patched document, or an error if the patch is not valid JSON
+func (p Patcher) DoMergePatch(oldRawDoc []byte, rawPatch []byte) (newDoc []byte, err error) {
+	var doc, patch jsondata.JSONValue
+	if err := json.Unmarshal(oldRawDoc, &doc); err != nil {
+		return oldRawDoc, fmt.Errorf("malformed raw document payload")
+	}
+	if err := json.Unmarshal(rawPatch, &patch); err != nil {
+		return oldRawDoc, fmt.Errorf("bad patch operation: a JSON Merge Patch must be valid JSON")
+	}
+	merged, err := jsondata.Accept[jsondata.JSONValue](patch, &MergeVisitor{Target: doc})
+	if err != nil {
+		return oldRawDoc, err
+	}
+	return json.Marshal(merged)
+}
+
+// MergeVisitor merges the patch value it visits into Target, following RFC 7386.
+type MergeVisitor struct {
+	Target jsondata.JSONValue // The value the patch is merged into
+}
+
+// Map merges the members of a patch object into Target, which is replaced by an empty object if it is not one.
+// Null members delete those of Target, and the others are merged recursively.
+func (mv *MergeVisitor) Map(patch map[string]jsondata.JSONValue) (jsondata.JSONValue, error) {
+	target, err := jsondata.Accept[map[string]jsondata.JSONValue](mv.Target, objectVisitor{})
+	if err != nil {
+		return jsondata.JSONValue{}, err
+	}
+	if target == nil {
+		target = make(map[string]jsondata.JSONValue)
+	}
+	var null jsondata.JSONValue
+	for key, value := range patch {
+		if value.Equal(null) {
+			delete(target, key)
+			continue
+		}
+		merged, err := jsondata.Accept[jsondata.JSONValue](value, &MergeVisitor{Target: target[key]})
+		if err != nil {
+			return jsondata.JSONValue{}, err
+		}
+		target[key] = merged
+	}
+	return jsondata.NewJSONValue(target)
+}
+
+// Slice replaces Target with a patch array
+func (mv *MergeVisitor) Slice(patch []jsondata.JSONValue) (jsondata.JSONValue, error) {
+	return jsondata.NewJSONValue(patch)
+}
+
+// Bool replaces Target with a patch boolean
+func (mv *MergeVisitor) Bool(patch bool) (jsondata.JSONValue, error) {
+	return jsondata.NewJSONValue(patch)
+}
+
+// Float64 replaces Target with a patch number
+func (mv *MergeVisitor) Float64(patch float64) (jsondata.JSONValue, error) {
+	return jsondata.NewJSONValue(patch)
+}
+
+// String replaces Target with a patch string
+func (mv *MergeVisitor) String(patch string) (jsondata.JSONValue, error) {
+	return jsondata.NewJSONValue(patch)
+}
+
+// Null replaces Target with null; this only happens when the whole patch is null
+func (mv *MergeVisitor) Null() (jsondata.JSONValue, error) {
+	return jsondata.NewJSONValue(nil)
+}
+
+// objectVisitor returns the members of the object it visits, or nil if it visits any other value
+type objectVisitor struct{}
+
+// Map returns the members of the object
+func (objectVisitor) Map(m map[string]jsondata.JSONValue) (map[string]jsondata.JSONValue, error) {
+	return m, nil
+}
+
+// Slice returns nil, as an array is not an object
+func (objectVisitor) Slice([]jsondata.JSONValue) (map[string]jsondata.JSONValue, error) {
+	return nil, nil
+}
+
+// Bool returns nil, as a boolean is not an object
+func (objectVisitor) Bool(bool) (map[string]jsondata.JSONValue, error) {
+	return nil, nil
+}
+
+// Float64 returns nil, as a number is not an object
+func (objectVisitor) Float64(float64) (map[string]jsondata.JSONValue, error) {
+	return nil, nil
+}
+
+// String returns nil, as a string is not an object
+func (objectVisitor) String(string) (map[string]jsondata.JSONValue, error) {
+	return nil, nil
+}
+
+// Null returns nil, as null is not an object
+func (objectVisitor) Null() (map[string]jsondata.JSONValue, error) {
+	return nil, nil
+}
@@ -0,0 +1,48 @@
+package patcher
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// TestPatcher_DoMergePatch runs the examples of RFC 7386, appendix A
+func TestPatcher_DoMergePatch(t *testing.T) {
+	tests := []struct {
+		doc   string
+		patch string
+		want  string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, test := range tests {
+		newDoc, err := Patcher{}.Apply([]byte(test.doc), []byte(test.patch), MediaTypeMergePatch)
+		if err != nil {
+			t.Errorf("merging %s into %s: unexpected error %v", test.patch, test.doc, err)
+			continue
+		}
+		//reencoding sorts the members of objects
+		var got any
+		json.Unmarshal(newDoc, &got)
+		if gotJSON, _ := json.Marshal(got); string(gotJSON) != test.want {
+			t.Errorf("merging %s into %s: expected %s, got %s", test.patch, test.doc, test.want, newDoc)
+		}
+	}
+
+	if _, err := (Patcher{}).DoMergePatch([]byte(`{}`), []byte(`{"a":`)); err == nil {
+		t.Errorf("expected a malformed merge patch to be rejected")
+	}
+}
@@ -936,7 +936,7 @@ func TestPatchV_ArrayRemove_Nonexistence(t *testing.T) {
 func TestSplitJSONPointer_Invalid(t *testing.T) {
 	pointer := "invalid/pointer"
 
-	_, err := splitJSONPointer(pointer)
+	_, err := SplitJSONPointer(pointer)
 
 	if err == nil {
 		t.Fatalf("Expected error due to invalid JSON Pointer, but got nil")
@@ -13,19 +13,19 @@ import (
 // PatchVisitor applies a single patch operation to a JSONValue during traversal.
 type PatchVisitor struct {
 	Patch         PatchOperation // The patch operation to apply
-	CurrentPath   string // The current path in the JSON document
-	Failed        bool // Flag indicating if the patch operation failed
-	FailureReason string // Reason for the failure
-	pathFound     bool // Flag indicating if the path was found in the document
+	CurrentPath   string         // The current path in the JSON document
+	Failed        bool           // Flag indicating if the patch operation failed
+	FailureReason string         // Reason for the failure
+	pathFound     bool           // Flag indicating if the path was found in the document
 }
 
 // NewPatchVisitor creates a new PatchVisitor for a single patch operation.
 func NewPatchVisitor(patch PatchOperation) *PatchVisitor {
 	return &PatchVisitor{
 		Patch:         patch, // The patch operation to apply
-		CurrentPath:   "", // The current path in the JSON document
+		CurrentPath:   "",    // The current path in the JSON document
 		Failed:        false, // Flag indicating if the patch operation failed
-		FailureReason: "", // Reason for the failure
+		FailureReason: "",    // Reason for the failure
 		pathFound:     false, // Flag indicating if the path was found in the document
 	}
 }
@@ -58,7 +58,7 @@ func (pv *PatchVisitor) Map(m map[string]jsondata.JSONValue) (jsondata.JSONValue
 		return jsondata.JSONValue{}, errors.New(pv.FailureReason)
 	}
 	if pv.Patch.Op == "ObjectAdd" && pv.CurrentPath == parentJSONPointer(pv.Patch.Path) {
-		patchSegments, err := splitJSONPointer(pv.Patch.Path)
+		patchSegments, err := SplitJSONPointer(pv.Patch.Path)
 		if err != nil {
 			pv.Failed = true
 			pv.FailureReason = fmt.Sprintf("Invalid JSON Pointer '%s': %v", pv.Patch.Path, err)
@@ -88,7 +88,7 @@ func (pv *PatchVisitor) Map(m map[string]jsondata.JSONValue) (jsondata.JSONValue
 	// Traverse the map
 	for k, v := range newMap {
 		previousPath := pv.CurrentPath
-		pv.CurrentPath = pv.CurrentPath + "/" + escapeJSONPointer(k)
+		pv.CurrentPath = pv.CurrentPath + "/" + EscapeJSONPointer(k)
 
 		//check if pathfound == true, if so, it means already did object add. Can return
 		// Before recursive call
@@ -245,8 +245,8 @@ func normalizeJSONPointer(ptr string) string {
 	return ptr
 }
 
-// splitJSONPointer splits a JSON pointer into its individual segments.
-func splitJSONPointer(ptr string) ([]string, error) {
+// SplitJSONPointer splits a JSON pointer into its individual segments.
+func SplitJSONPointer(ptr string) ([]string, error) {
 	if ptr == "" {
 		return []string{}, nil
 	}
@@ -260,8 +260,8 @@ func splitJSONPointer(ptr string) ([]string, error) {
 	return parts, nil
 }
 
-// escapeJSONPointer escapes a string to be safely used as a JSON pointer.
-func escapeJSONPointer(s string) string {
+// EscapeJSONPointer escapes a string to be safely used as a JSON pointer.
+func EscapeJSONPointer(s string) string {
 	s = strings.ReplaceAll(s, "~", "~0")
 	s = strings.ReplaceAll(s, "/", "~1")
 	return s
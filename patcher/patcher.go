@@ -22,6 +22,31 @@ type PatchOperation struct {
 type Patcher struct {
 }
 
+// Media types of the patch dialects a document can be patched with
+const (
+	MediaTypeOwlDB      = "application/json"             // the ArrayAdd, ArrayRemove and ObjectAdd operations
+	MediaTypeJSONPatch  = "application/json-patch+json"  // RFC 6902 JSON Patch
+	MediaTypeMergePatch = "application/merge-patch+json" // RFC 7386 JSON Merge Patch
+)
+
+// SupportsMediaType returns whether patches of the given media type can be applied
+func SupportsMediaType(mediaType string) bool {
+	return mediaType == MediaTypeOwlDB || mediaType == MediaTypeJSONPatch || mediaType == MediaTypeMergePatch
+}
+
+// Apply applies rawPatches, written in the dialect of mediaType, to the document oldRawDoc.
+// Returns the patched document, or an error if the patches are malformed or cannot be applied
+func (p Patcher) Apply(oldRawDoc []byte, rawPatches []byte, mediaType string) (newDoc []byte, err error) {
+	switch mediaType {
+	case MediaTypeJSONPatch:
+		return p.DoJSONPatch(oldRawDoc, rawPatches)
+	case MediaTypeMergePatch:
+		return p.DoMergePatch(oldRawDoc, rawPatches)
+	default:
+		return p.DoPatch(oldRawDoc, rawPatches)
+	}
+}
+
 // DoPatch takes the raw bytes from both the original document, a json-encoded list of patch objects, and applies them sequentially
 // returns whether the patch was successful or not
 func (p Patcher) DoPatch(oldRawDoc []byte, rawPatches []byte) (newDoc []byte, err error) {
@@ -47,24 +72,12 @@ func (p Patcher) DoPatch(oldRawDoc []byte, rawPatches []byte) (newDoc []byte, er
 	}
 	for _, patchOp := range patches {
 		slog.Info("PATCHING 2")
-		// Create a new PatchVisitor for the current patch operation
-		patchVisitor := NewPatchVisitor(patchOp)
-
-		// Apply the patch to the current jsonValue
-
-		//I dont think you need to type cast here since the visitor is already a visitor.... I think
-		patchedValue, err := jsondata.Accept[jsondata.JSONValue](jsonValue, patchVisitor)
-		if err != nil || patchVisitor.Failed {
-			if patchVisitor.FailureReason != "" {
-				return oldRawDoc, err
-			}
+		patchedValue, pathFound, err := applyOperation(jsonValue, patchOp)
+		if err != nil && !pathFound {
+			return nil, err
+		} else if err != nil {
 			return oldRawDoc, err
 		}
-		if !patchVisitor.pathFound {
-			msg := fmt.Sprintf("Error Applying patches:  Path '%s' does not exist in the document", patchOp.Path) // invalid patch
-			slog.Error(msg)
-			return nil, errors.New(msg)
-		}
 
 		// Update jsonValue for the next patch operation
 		jsonValue = patchedValue
@@ -79,6 +92,30 @@ func (p Patcher) DoPatch(oldRawDoc []byte, rawPatches []byte) (newDoc []byte, er
 	return updatedData, nil
 }
 
+// applyOperation applies one of the ArrayAdd, ArrayRemove or ObjectAdd operations to jsonValue.
+// Returns the patched value, or an error if the operation cannot be applied along with whether its path was found
+func applyOperation(jsonValue jsondata.JSONValue, patchOp PatchOperation) (jsondata.JSONValue, bool, error) {
+	// Create a new PatchVisitor for the current patch operation
+	patchVisitor := NewPatchVisitor(patchOp)
+
+	// Apply the patch to the current jsonValue
+
+	//I dont think you need to type cast here since the visitor is already a visitor.... I think
+	patchedValue, err := jsondata.Accept[jsondata.JSONValue](jsonValue, patchVisitor)
+	if err == nil && patchVisitor.Failed {
+		err = errors.New(patchVisitor.FailureReason)
+	}
+	if err != nil {
+		return jsondata.JSONValue{}, true, err
+	}
+	if !patchVisitor.pathFound {
+		msg := fmt.Sprintf("Error Applying patches:  Path '%s' does not exist in the document", patchOp.Path) // invalid patch
+		slog.Error(msg)
+		return jsondata.JSONValue{}, false, errors.New(msg)
+	}
+	return patchedValue, true, nil
+}
+
 // validatePatches validates a slice of PatchOperation objects to ensure that each operation is valid.
 // It returns an error if any operation is not one of the allowed types: "ArrayAdd", "ArrayRemove", or "ObjectAdd".
 func validatePatches(ops []PatchOperation) error {
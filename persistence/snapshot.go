@@ -0,0 +1,286 @@
+package persistence
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/fieldIndex"
+)
+
+// Snapshots are named after the log segment replay must resume from once they are loaded
+const (
+	snapshotPrefix = "snapshot-"
+	snapshotSuffix = ".jsonl"
+
+	tempSuffix = ".tmp" // snapshots are written under a temporary name and renamed once complete
+)
+
+// Source is anything whose contents can be enumerated to produce a snapshot. Parents must be visited before their
+// children, and paths are relative to their database.
+type Source interface {
+	Walk(ctx context.Context, visitDB func(dbName string), visitCol func(dbName string, colpath string, indexes []fieldIndex.Definition), visitDoc func(dbName string, docpath string, serial []byte)) error
+}
+
+// snapshotHeader is the first line of a snapshot; the records rebuilding every resource follow it
+type snapshotHeader struct {
+	Segment   uint64           `json:"segment"`           // the log segment replay resumes from after loading the snapshot
+	Seq       uint64           `json:"seq"`               // the sequence number of the last record logged before the snapshot began
+	Changes   map[string]int64 `json:"changes,omitempty"` // the number of the latest change logged for each database before the snapshot began
+	CreatedAt int64            `json:"createdAt"`         // when the snapshot began, in milliseconds since the epoch
+}
+
+// Snapshot writes a point-in-time image of src to the data directory, then removes all but the retain most recent
+// snapshots along with the log segments that none of the remaining snapshots need.
+//
+// The log is rotated before src is walked, and the image records the segment that was open until then. Loading the
+// image and replaying the log from that segment onwards reproduces every mutation, including those that raced
+// with the walk, since replaying a record over state that already reflects it leaves that state unchanged.
+// Returns an error if the image could not be written, in which case no log segment is removed
+func (w *WAL) Snapshot(ctx context.Context, src Source, retain int) error {
+	w.mtx.Lock()
+	header := snapshotHeader{Segment: w.segment, Seq: w.seq, Changes: maps.Clone(w.changes), CreatedAt: time.Now().UnixMilli()}
+	err := w.rotate()
+	w.mtx.Unlock()
+	if err != nil {
+		return fmt.Errorf("unable to start a new log segment: %w", err)
+	}
+
+	final := numberedPath(w.dir, snapshotPrefix, snapshotSuffix, header.Segment)
+	tmp := final + tempSuffix
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("unable to create snapshot: %w", err)
+	}
+	count, err := writeSnapshot(ctx, f, header, src)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, final)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("unable to write snapshot: %w", err)
+	}
+	syncDir(w.dir)
+	slog.Info("Wrote snapshot", "segment", header.Segment, "records", count)
+
+	return w.compact(retain)
+}
+
+// writeSnapshot writes the header followed by one record per resource of src to f.
+// Returns the number of records written
+func writeSnapshot(ctx context.Context, f *os.File, header snapshotHeader, src Source) (int, error) {
+	buf := bufio.NewWriter(f)
+	enc := json.NewEncoder(buf)
+	if err := enc.Encode(header); err != nil {
+		return 0, err
+	}
+	count := 0
+	var encErr error
+	write := func(rec Record) {
+		if encErr == nil {
+			encErr = enc.Encode(rec)
+			count++
+		}
+	}
+	err := src.Walk(ctx, func(dbName string) {
+		write(Record{Op: OpCreateDB, DB: dbName})
+	}, func(dbName string, colpath string, indexes []fieldIndex.Definition) {
+		if colpath != "" { //the top-level collection exists along with its database
+			write(Record{Op: OpPutCol, DB: dbName, Path: colpath})
+		}
+		for _, def := range indexes {
+			body, _ := json.Marshal(def)
+			write(Record{Op: OpPutIndex, DB: dbName, Path: colpath, Body: body})
+		}
+	}, func(dbName string, docpath string, serial []byte) {
+		write(Record{Op: OpPutDoc, DB: dbName, Path: docpath, Body: serial})
+	})
+	if err == nil {
+		err = encErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	return count, buf.Flush()
+}
+
+// compact removes all but the retain most recent snapshots, and every log segment older than the oldest snapshot kept
+func (w *WAL) compact(retain int) error {
+	if retain < 1 {
+		retain = 1
+	}
+	snapshots, err := listNumbered(w.dir, snapshotPrefix, snapshotSuffix)
+	if err != nil {
+		return err
+	}
+	if len(snapshots) == 0 {
+		return nil
+	}
+	if len(snapshots) > retain {
+		for _, snap := range snapshots[:len(snapshots)-retain] {
+			if err = os.Remove(numberedPath(w.dir, snapshotPrefix, snapshotSuffix, snap)); err != nil {
+				return err
+			}
+		}
+		snapshots = snapshots[len(snapshots)-retain:]
+	}
+	segments, err := listNumbered(w.dir, segmentPrefix, segmentSuffix)
+	if err != nil {
+		return err
+	}
+	removed := 0
+	for _, seg := range segments {
+		if seg >= snapshots[0] {
+			break
+		}
+		if err = os.Remove(segmentPath(w.dir, seg)); err != nil {
+			return err
+		}
+		removed++
+	}
+	slog.Debug("Compacted write-ahead log", "segmentsRemoved", removed, "snapshotsKept", len(snapshots))
+	return nil
+}
+
+// RunSnapshots takes a snapshot of src every interval until ctx is cancelled, keeping the retain most recent ones.
+// Failed snapshots are logged and retried at the next interval.
+func (w *WAL) RunSnapshots(ctx context.Context, src Source, interval time.Duration, retain int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := w.Snapshot(ctx, src, retain); err != nil {
+				slog.Error("Unable to take snapshot", "error", err)
+			}
+		}
+	}
+}
+
+// loadSnapshot applies the most recent readable snapshot in dir to the creator and deleter. A snapshot is read in
+// full before any of it is applied, so an unreadable one is skipped in favour of the one before it.
+// Returns the header of the snapshot loaded, and whether one was found
+func loadSnapshot(dir string, c Creator, d Deleter) (snapshotHeader, bool, error) {
+	snapshots, err := listNumbered(dir, snapshotPrefix, snapshotSuffix)
+	if err != nil {
+		return snapshotHeader{}, false, err
+	}
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		name := numberedPath(dir, snapshotPrefix, snapshotSuffix, snapshots[i])
+		header, records, err := readSnapshot(name)
+		if err != nil {
+			slog.Warn("Skipping unreadable snapshot", "file", name, "error", err)
+			continue
+		}
+		for _, rec := range records {
+			apply(rec, c, d)
+		}
+		slog.Info("Loaded snapshot", "file", name, "records", len(records))
+		return header, true, nil
+	}
+	return snapshotHeader{}, false, nil
+}
+
+// readSnapshot decodes the snapshot held in the file name.
+// Returns its header and records, or an error if the file is malformed
+func readSnapshot(name string) (snapshotHeader, []Record, error) {
+	var header snapshotHeader
+	f, err := os.Open(name)
+	if err != nil {
+		return header, nil, err
+	}
+	defer f.Close()
+	reader := bufio.NewReader(f)
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		return header, nil, fmt.Errorf("missing header")
+	}
+	if err = json.Unmarshal(line, &header); err != nil {
+		return header, nil, fmt.Errorf("malformed header: %w", err)
+	}
+	var records []Record
+	for {
+		line, err = reader.ReadBytes('\n')
+		if len(line) == 0 && err != nil {
+			break
+		}
+		if err != nil {
+			return header, nil, fmt.Errorf("truncated record")
+		}
+		var rec Record
+		if err = json.Unmarshal(line, &rec); err != nil {
+			return header, nil, fmt.Errorf("malformed record: %w", err)
+		}
+		records = append(records, rec)
+	}
+	return header, records, nil
+}
+
+// segmentPath returns the path of the log segment numbered n
+func segmentPath(dir string, n uint64) string {
+	return numberedPath(dir, segmentPrefix, segmentSuffix, n)
+}
+
+// numberedPath returns the path of the file numbered n with the given prefix and suffix
+func numberedPath(dir string, prefix string, suffix string, n uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%s%08d%s", prefix, n, suffix))
+}
+
+// listNumbered returns, in ascending order, the numbers of the files in dir with the given prefix and suffix
+func listNumbered(dir string, prefix string, suffix string) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var res []uint64
+	for _, entry := range entries {
+		num, ok := strings.CutPrefix(entry.Name(), prefix)
+		if !ok {
+			continue
+		}
+		num, ok = strings.CutSuffix(num, suffix)
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseUint(num, 10, 64)
+		if err != nil {
+			continue
+		}
+		res = append(res, n)
+	}
+	slices.Sort(res)
+	return res, nil
+}
+
+// removeTemporaries deletes snapshots left incomplete by a crash
+func removeTemporaries(dir string) {
+	matches, _ := filepath.Glob(filepath.Join(dir, snapshotPrefix+"*"+tempSuffix))
+	for _, m := range matches {
+		os.Remove(m)
+	}
+}
+
+// syncDir flushes the directory entry changes in dir (e.g. a rename) to stable storage
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
@@ -0,0 +1,120 @@
+package persistence
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/fieldIndex"
+)
+
+// mockSource is a fixed tree of resources, walked parents first
+type mockSource struct{}
+
+func (mockSource) Walk(ctx context.Context, visitDB func(string), visitCol func(string, string, []fieldIndex.Definition), visitDoc func(string, string, []byte)) error {
+	visitDB("db")
+	visitDoc("db", "doc", []byte(`{"path":"/doc","doc":{},"meta":{}}`))
+	visitCol("db", "doc/col", []fieldIndex.Definition{{Field: "/email"}})
+	return nil
+}
+
+func TestWAL_SnapshotAndTail(t *testing.T) {
+	dir := t.TempDir()
+	wal, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %s", err.Error())
+	}
+	wal.RecordCreateDB("old", "")
+	if err = wal.Snapshot(context.Background(), mockSource{}, 1); err != nil {
+		t.Fatalf("Snapshot failed: %s", err.Error())
+	}
+	wal.RecordCreateDB("tail", "")
+	if err = wal.Snapshot(context.Background(), mockSource{}, 1); err != nil {
+		t.Fatalf("Snapshot failed: %s", err.Error())
+	}
+	wal.RecordCreateDB("newest", "")
+	wal.Close()
+
+	//only the latest snapshot is retained, along with the segments it needs
+	snapshots, _ := listNumbered(dir, snapshotPrefix, snapshotSuffix)
+	if !reflect.DeepEqual(snapshots, []uint64{2}) {
+		t.Errorf("TestWAL_SnapshotAndTail failed, expected only snapshot 2 to be kept, got %v", snapshots)
+	}
+	segments, _ := listNumbered(dir, segmentPrefix, segmentSuffix)
+	if !reflect.DeepEqual(segments, []uint64{2, 3}) {
+		t.Errorf("TestWAL_SnapshotAndTail failed, expected segments [2 3], got %v", segments)
+	}
+
+	wal, err = Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %s", err.Error())
+	}
+	defer wal.Close()
+	target := &mockTarget{wal: wal}
+	if err = wal.Replay(target, target); err != nil {
+		t.Fatalf("Replay failed: %s", err.Error())
+	}
+	expected := []string{
+		"createdb db",
+		`putdoc db/doc {"path":"/doc","doc":{},"meta":{}}`,
+		"putcol db/doc/col",
+		"putindex db/doc/col /email",
+		"createdb tail",
+		"createdb newest",
+	}
+	if !reflect.DeepEqual(target.ops, expected) {
+		t.Errorf("TestWAL_SnapshotAndTail failed, expected %v, got %v", expected, target.ops)
+	}
+	if wal.seq != 3 {
+		t.Errorf("TestWAL_SnapshotAndTail failed, expected sequence number 3 after replay, got %d", wal.seq)
+	}
+}
+
+func TestWAL_UnreadableSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	wal, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %s", err.Error())
+	}
+	wal.Snapshot(context.Background(), mockSource{}, 2)
+	wal.RecordCreateDB("tail", "")
+	wal.Snapshot(context.Background(), mockSource{}, 2)
+	wal.Close()
+
+	//corrupting the latest snapshot falls back to the one before it
+	os.WriteFile(numberedPath(dir, snapshotPrefix, snapshotSuffix, 2), []byte(`{"segment":2,"seq":1}`+"\n"+`{"op":`), 0o644)
+
+	wal, _ = Open(dir)
+	defer wal.Close()
+	target := &mockTarget{wal: wal}
+	if err = wal.Replay(target, target); err != nil {
+		t.Fatalf("Replay failed: %s", err.Error())
+	}
+	expected := []string{
+		"createdb db",
+		`putdoc db/doc {"path":"/doc","doc":{},"meta":{}}`,
+		"putcol db/doc/col",
+		"putindex db/doc/col /email",
+		"createdb tail",
+	}
+	if !reflect.DeepEqual(target.ops, expected) {
+		t.Errorf("TestWAL_UnreadableSnapshot failed, expected %v, got %v", expected, target.ops)
+	}
+}
+
+func TestWAL_LegacyLog(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(dir+"/"+legacyLogFileName, []byte(`{"seq":1,"op":"createdb","db":"db"}`+"\n"), 0o644)
+
+	wal, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %s", err.Error())
+	}
+	defer wal.Close()
+	target := &mockTarget{wal: wal}
+	wal.Replay(target, target)
+	if !reflect.DeepEqual(target.ops, []string{"createdb db"}) {
+		t.Errorf("TestWAL_LegacyLog failed, got %v", target.ops)
+	}
+}
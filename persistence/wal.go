@@ -0,0 +1,648 @@
+// Package persistence provides durable storage for OwlDB. Every successful mutation is appended to a
+// write-ahead log (WAL) before the server acknowledges it, and on startup the log is replayed to rebuild
+// the databases, documents and collections held in memory. Periodic snapshots bound the length of the log:
+// startup loads the latest snapshot and only replays the records logged since.
+package persistence
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/fieldIndex"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/precondition"
+)
+
+// The operations that can appear in the log
+const (
+	OpCreateDB  = "createdb"  // a database was created
+	OpDeleteDB  = "deletedb"  // a database was deleted
+	OpPutDoc    = "putdoc"    // a document was created, overwritten or patched; the body holds its full serialized state
+	OpPutCol    = "putcol"    // a collection was created
+	OpDeleteDoc = "deletedoc" // a document was deleted
+	OpDeleteCol = "deletecol" // a collection was deleted
+	OpImportDB  = "importdb"  // a database was replaced by an import; the body holds the imported entries
+
+	OpPutIndex    = "putindex"    // a secondary index was created on a collection; the body holds its definition
+	OpDeleteIndex = "deleteindex" // a secondary index was dropped from a collection; the body holds its definition
+
+	OpTransaction = "transaction" // documents were written by a transaction; the body holds a putdoc or deletedoc record for each
+)
+
+// The log is split into numbered segments; a new segment is started every time a snapshot is taken, so that the
+// segments preceding a snapshot can be removed once they are no longer needed
+const (
+	segmentPrefix = "wal-"
+	segmentSuffix = ".log"
+
+	legacyLogFileName = "wal.log" // the single, unsegmented log written by earlier versions
+
+	epochFileName = "epoch" // holds the epoch of the data directory, the time it was first used
+)
+
+// ErrBroken is returned for every change once a write to the log has failed: the log may no longer hold every change
+// acknowledged before, so none is accepted until the server is restarted and the log replayed
+var ErrBroken = errors.New("the write-ahead log is unavailable")
+
+// ErrCompacted is returned for changes whose records were removed from the log once a snapshot made them redundant
+var ErrCompacted = errors.New("the changes have been compacted away")
+
+// Record is a single entry in the write-ahead log
+type Record struct {
+	Seq    uint64          `json:"seq"`              // the position of the record in the log
+	Change int64           `json:"change,omitempty"` // the position in the change feed of its database of the first change the record made; the other writes of a transaction follow it
+	Time   int64           `json:"time,omitempty"`   // when the record was logged, in Unix milliseconds
+	Op     string          `json:"op"`               // the operation performed
+	DB     string          `json:"db"`               // the database the operation was performed on
+	Path   string          `json:"path,omitempty"`   // the path of the resource, relative to the database
+	Body   json.RawMessage `json:"body,omitempty"`   // the serialized document for OpPutDoc, the imported entries for OpImportDB, the index definition for OpPutIndex and OpDeleteIndex, or the writes of OpTransaction
+	User   string          `json:"user,omitempty"`   // the user who performed the operation, if known
+}
+
+// Journal is the full set of mutations recorded by OwlDB; it is implemented by both WAL and Discard. A mutation must
+// not be applied, or must be undone, if recording it fails.
+type Journal interface {
+	RecordCreateDB(dbName string, user string) error
+	RecordDeleteDB(dbName string, user string) error
+	RecordPutDoc(dbName string, docpath string, serial []byte) error
+	RecordPutCol(dbName string, colpath string, user string) error
+	RecordDeleteDoc(dbName string, docpath string, user string) error
+	RecordDeleteCol(dbName string, colpath string, user string) error
+	RecordImportDB(dbName string, entries []byte, user string) error
+	RecordPutIndex(dbName string, colpath string, def fieldIndex.Definition) error
+	RecordDeleteIndex(dbName string, colpath string, field string) error
+	RecordTransaction(dbName string, docpaths []string, serials [][]byte, user string) error
+}
+
+// Creator encapsulates the operations needed to recreate resources while replaying the log
+type Creator interface {
+	CreateDB(dbName string, user string) ([]byte, int, string)              // recreates a database
+	PutCol(dtb string, colpath string, user string) ([]byte, int, string)   // recreates a collection
+	RestoreDoc(dbName string, docpath string, serial []byte) ([]byte, int)  // recreates a document, contents and metadata included
+	ImportDB(dbName string, dump []byte, user string) ([]byte, int, string) // replaces a database with the entries of a JSONL dump
+
+	PutIndex(dtb string, colpath string, def fieldIndex.Definition) ([]byte, int, string) // recreates a secondary index
+}
+
+// Deleter encapsulates the operations needed to delete resources while replaying the log
+type Deleter interface {
+	DeleteDB(dbName string, user string) ([]byte, int)                                                // deletes a database
+	DeleteCol(dtb string, colpath string, user string) ([]byte, int)                                  // deletes a collection
+	DeleteDoc(dbName string, docpath string, user string, cond precondition.Conditions) ([]byte, int) // deletes a document
+
+	DeleteIndex(dtb string, colpath string, field string) ([]byte, int) // drops a secondary index
+}
+
+// WAL is an append-only log of every mutation performed on OwlDB. It is safe for concurrent use.
+type WAL struct {
+	mtx sync.Mutex // serializes appends to the log
+
+	dir string // the data directory holding the log
+
+	file *os.File // the open log segment
+
+	segment uint64 // the number of the open log segment
+
+	seq uint64 // the sequence number of the last record written
+
+	changes map[string]int64 // the number of the latest change logged for each database, kept across deletions
+
+	epoch string // the epoch of the data directory, identifying the numbering of the changes
+
+	observer func(rec Record) // told of every record replayed or logged, if set
+
+	broken error // the failure that left the log unable to accept records, if any
+
+	replaying atomic.Bool // set while the log is being replayed, so replayed operations are not logged again
+}
+
+// Open opens (creating it if needed) the write-ahead log held in the directory dir. New records are appended to
+// the most recent log segment.
+// Returns the log, or an error if the directory or the log file cannot be opened
+func Open(dir string) (*WAL, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("unable to create data directory '%s': %w", dir, err)
+	}
+	//a log written before segmentation was introduced becomes the first segment
+	legacy := filepath.Join(dir, legacyLogFileName)
+	if _, err := os.Stat(legacy); err == nil {
+		if err = os.Rename(legacy, segmentPath(dir, 1)); err != nil {
+			return nil, fmt.Errorf("unable to migrate write-ahead log: %w", err)
+		}
+	}
+	removeTemporaries(dir)
+
+	segments, err := listNumbered(dir, segmentPrefix, segmentSuffix)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list write-ahead log segments: %w", err)
+	}
+	epoch, err := loadEpoch(dir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the epoch of the data directory: %w", err)
+	}
+	w := &WAL{dir: dir, segment: 1, changes: make(map[string]int64), epoch: epoch}
+	if len(segments) > 0 {
+		w.segment = segments[len(segments)-1]
+	}
+	w.file, err = os.OpenFile(segmentPath(dir, w.segment), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open write-ahead log: %w", err)
+	}
+	return w, nil
+}
+
+// loadEpoch reads the epoch of the data directory dir, starting a new one if it has none
+func loadEpoch(dir string) (string, error) {
+	name := filepath.Join(dir, epochFileName)
+	b, err := os.ReadFile(name)
+	if err == nil {
+		return strings.TrimSpace(string(b)), nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	epoch := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	if err = os.WriteFile(name+tempSuffix, []byte(epoch+"\n"), 0o644); err != nil {
+		return "", err
+	}
+	if err = os.Rename(name+tempSuffix, name); err != nil {
+		return "", err
+	}
+	syncDir(dir)
+	return epoch, nil
+}
+
+// Epoch returns the epoch of the data directory. The changes of its databases are numbered within it, and it only
+// changes if the data directory is lost.
+func (w *WAL) Epoch() string {
+	return w.epoch
+}
+
+// LastChange returns the number of the latest change logged for the database dbName, or 0 if there is none
+func (w *WAL) LastChange(dbName string) int64 {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return w.changes[dbName]
+}
+
+// Replaying reports whether the log is being replayed, in which case nothing is logged
+func (w *WAL) Replaying() bool {
+	return w.replaying.Load()
+}
+
+// Observe has fn told of every record Replay applies from the log once it is applied, then of every record logged
+// once it is flushed, in the order of the log. fn is called with w.mtx held, so it must not log anything itself.
+// It must be called before Replay.
+func (w *WAL) Observe(fn func(rec Record)) {
+	w.observer = fn
+}
+
+// Records returns, oldest first, the records logged for the database dbName that hold changes numbered after after.
+// They are read from the segments of the log, so they survive restarts until a snapshot compacts them away.
+// Returns ErrCompacted if some of those changes are no longer logged
+func (w *WAL) Records(dbName string, after int64) ([]Record, error) {
+	last := w.LastChange(dbName)
+	if after >= last {
+		return nil, nil
+	}
+	for {
+		segments, err := listNumbered(w.dir, segmentPrefix, segmentSuffix)
+		if err != nil {
+			return nil, err
+		}
+		records, first, err := readRecords(w.dir, segments, dbName, after)
+		if errors.Is(err, os.ErrNotExist) { //a snapshot compacted a segment away while it was read
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if first == 0 || first > after+1 {
+			return nil, ErrCompacted
+		}
+		return records, nil
+	}
+}
+
+// readRecords reads, from the segments of the log held in dir, the records of the database dbName holding changes
+// numbered after after. A record still being written to the open segment ends the read.
+// Returns the records, oldest first, and the number of the first change of dbName found in the segments, or 0 if
+// there is none
+func readRecords(dir string, segments []uint64, dbName string, after int64) ([]Record, int64, error) {
+	var records []Record
+	var first int64
+	for _, seg := range segments {
+		f, err := os.Open(segmentPath(dir, seg))
+		if err != nil {
+			return nil, 0, err
+		}
+		reader := bufio.NewReader(f)
+		for {
+			line, err := reader.ReadBytes('\n')
+			if err != nil {
+				break
+			}
+			var rec Record
+			if json.Unmarshal(line, &rec) != nil {
+				break
+			}
+			n := changeCount(rec)
+			if rec.DB != dbName || rec.Change == 0 || n == 0 {
+				continue
+			}
+			if first == 0 {
+				first = rec.Change
+			}
+			if rec.Change+n-1 > after {
+				records = append(records, rec)
+			}
+		}
+		f.Close()
+	}
+	return records, first, nil
+}
+
+// Replay rebuilds the state held in the data directory: the latest readable snapshot is loaded first, then every
+// record logged since that snapshot began is applied, in order, to the creator and deleter. Records that can no
+// longer be applied (e.g. a document whose parent was deleted) are skipped. A torn record at the end of the log, left
+// by a crash in the middle of a write, is discarded.
+// Returns an error if a malformed record is followed by well-formed ones, since the log was then corrupted rather
+// than torn and replaying past the damage would silently lose the records it held
+func (w *WAL) Replay(c Creator, d Deleter) error {
+	w.replaying.Store(true)
+	defer w.replaying.Store(false)
+
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	from := uint64(0)
+	header, found, err := loadSnapshot(w.dir, c, d)
+	if err != nil {
+		return err
+	}
+	if found {
+		from = header.Segment
+		w.seq = header.Seq
+		for dbName, change := range header.Changes {
+			w.changes[dbName] = change
+		}
+	}
+
+	segments, err := listNumbered(w.dir, segmentPrefix, segmentSuffix)
+	if err != nil {
+		return err
+	}
+	if !found && len(segments) > 0 && segments[0] != 1 {
+		slog.Error("The write-ahead log is missing segments and no snapshot could be loaded; some data may be lost", "first", segments[0])
+	}
+	count := 0
+	for _, seg := range segments {
+		if seg < from {
+			continue
+		}
+		var n int
+		if seg == w.segment {
+			n, err = w.replayActive(c, d)
+		} else {
+			n, err = w.replaySealed(seg, c, d)
+		}
+		if err != nil {
+			return err
+		}
+		count += n
+	}
+	slog.Info("Replayed write-ahead log", "snapshot", found, "records", count)
+	return nil
+}
+
+// replaySealed applies every record of a segment that is no longer written to.
+// Returns the number of records applied
+func (w *WAL) replaySealed(seg uint64, c Creator, d Deleter) (int, error) {
+	f, err := os.Open(segmentPath(w.dir, seg))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	count, validLen, err := w.replayFrom(f, c, d)
+	if err != nil {
+		return 0, fmt.Errorf("log segment %d: %w", seg, err)
+	}
+	if info, err := f.Stat(); err == nil && info.Size() != validLen {
+		slog.Warn("Ignoring the malformed end of a write-ahead log segment", "segment", seg, "offset", validLen)
+	}
+	return count, nil
+}
+
+// replayActive applies every record of the open segment, dropping anything after the last complete record so new
+// records are appended to a well-formed log.
+// Returns the number of records applied
+func (w *WAL) replayActive(c Creator, d Deleter) (int, error) {
+	if _, err := w.file.Seek(0, 0); err != nil {
+		return 0, err
+	}
+	count, validLen, err := w.replayFrom(w.file, c, d)
+	if err != nil {
+		return 0, fmt.Errorf("log segment %d: %w", w.segment, err)
+	}
+	if err := w.file.Truncate(validLen); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// replayFrom applies records read from r until its end or the first malformed record.
+// Returns the number of records applied and the length of the well-formed prefix of r, or an error if a well-formed
+// record follows the malformed one
+func (w *WAL) replayFrom(r io.Reader, c Creator, d Deleter) (int, int64, error) {
+	var validLen int64
+	count := 0
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil { //either the end of the log, or a record without its terminating newline
+			break
+		}
+		var rec Record
+		if json.Unmarshal(line, &rec) != nil {
+			if followedByRecord(reader) {
+				return count, validLen, fmt.Errorf("malformed record at offset %d is followed by valid records", validLen)
+			}
+			break
+		}
+		validLen += int64(len(line))
+		if rec.Seq > w.seq {
+			w.seq = rec.Seq
+		}
+		w.number(&rec)
+		apply(rec, c, d)
+		if w.observer != nil {
+			w.observer(rec)
+		}
+		count++
+	}
+	return count, validLen, nil
+}
+
+// followedByRecord reports whether any of the lines left in reader holds a well-formed record. A malformed record
+// that is not followed by one was torn by a crash, whereas one that is was corrupted
+func followedByRecord(reader *bufio.Reader) bool {
+	for {
+		line, err := reader.ReadBytes('\n')
+		var rec Record
+		if len(line) > 0 && json.Unmarshal(line, &rec) == nil {
+			return true
+		}
+		if err != nil {
+			return false
+		}
+	}
+}
+
+// rotate seals the open segment and starts appending to a new one. The caller must hold w.mtx.
+func (w *WAL) rotate() error {
+	next, err := os.OpenFile(segmentPath(w.dir, w.segment+1), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if err = w.file.Sync(); err != nil {
+		next.Close()
+		return err
+	}
+	w.file.Close()
+	w.file = next
+	w.segment++
+	return nil
+}
+
+// changeCount returns the number of changes rec makes to the change feed of its database: one for each write of a
+// transaction, none for changes to indexes, and one otherwise
+func changeCount(rec Record) int64 {
+	switch rec.Op {
+	case OpPutIndex, OpDeleteIndex:
+		return 0
+	case OpTransaction:
+		var writes []json.RawMessage
+		json.Unmarshal(rec.Body, &writes)
+		return int64(len(writes))
+	}
+	return 1
+}
+
+// number gives rec the number of the first change it makes to its database, unless it already has one, and counts
+// its changes. Records logged before changes were numbered get the numbers they would have had.
+// The caller must hold w.mtx.
+func (w *WAL) number(rec *Record) {
+	n := changeCount(*rec)
+	if n == 0 {
+		return
+	}
+	if rec.Change == 0 {
+		rec.Change = w.changes[rec.DB] + 1
+	}
+	w.changes[rec.DB] = rec.Change + n - 1
+}
+
+// apply performs the operation described by a single record
+func apply(rec Record, c Creator, d Deleter) {
+	var resp []byte
+	var stat int
+	switch rec.Op {
+	case OpCreateDB:
+		resp, stat, _ = c.CreateDB(rec.DB, rec.User)
+	case OpDeleteDB:
+		resp, stat = d.DeleteDB(rec.DB, rec.User)
+	case OpPutCol:
+		resp, stat, _ = c.PutCol(rec.DB, rec.Path, rec.User)
+	case OpPutDoc:
+		resp, stat = c.RestoreDoc(rec.DB, rec.Path, rec.Body)
+	case OpDeleteCol:
+		resp, stat = d.DeleteCol(rec.DB, rec.Path, rec.User)
+	case OpDeleteDoc:
+		resp, stat = d.DeleteDoc(rec.DB, rec.Path, rec.User, precondition.Conditions{})
+	case OpImportDB:
+		var entries []json.RawMessage
+		if err := json.Unmarshal(rec.Body, &entries); err != nil {
+			slog.Warn("Skipping malformed import in write-ahead log", "seq", rec.Seq, "db", rec.DB)
+			return
+		}
+		var dump bytes.Buffer
+		for _, entry := range entries {
+			dump.Write(entry)
+			dump.WriteByte('\n')
+		}
+		resp, stat, _ = c.ImportDB(rec.DB, dump.Bytes(), rec.User)
+	case OpPutIndex, OpDeleteIndex:
+		var def fieldIndex.Definition
+		if err := json.Unmarshal(rec.Body, &def); err != nil {
+			slog.Warn("Skipping malformed index definition in write-ahead log", "seq", rec.Seq, "db", rec.DB, "path", rec.Path)
+			return
+		}
+		if rec.Op == OpPutIndex {
+			resp, stat, _ = c.PutIndex(rec.DB, rec.Path, def)
+		} else {
+			resp, stat = d.DeleteIndex(rec.DB, rec.Path, def.Field)
+		}
+	case OpTransaction:
+		var writes []Record
+		if err := json.Unmarshal(rec.Body, &writes); err != nil {
+			slog.Warn("Skipping malformed transaction in write-ahead log", "seq", rec.Seq, "db", rec.DB)
+			return
+		}
+		for _, write := range writes {
+			if write.Op != OpPutDoc && write.Op != OpDeleteDoc {
+				slog.Warn("Skipping unexpected operation in a transaction of the write-ahead log", "seq", rec.Seq, "op", write.Op)
+				continue
+			}
+			write.Seq, write.DB, write.User = rec.Seq, rec.DB, rec.User
+			apply(write, c, d)
+		}
+		return
+	default:
+		slog.Warn("Unknown operation in write-ahead log", "seq", rec.Seq, "op", rec.Op)
+		return
+	}
+	if stat >= 300 {
+		slog.Warn("Skipping log record that could not be replayed", "seq", rec.Seq, "op", rec.Op, "db", rec.DB, "path", rec.Path, "response", string(resp))
+	}
+}
+
+// append writes a record of an operation performed by user to the end of the log and flushes it to stable storage.
+// Once a write or a flush fails, every later record is refused.
+// Returns an error wrapping ErrBroken if the record could not be made durable
+func (w *WAL) append(op string, dbName string, path string, body []byte, user string) error {
+	if w.replaying.Load() {
+		return nil
+	}
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if w.broken != nil {
+		return w.broken
+	}
+
+	rec := Record{Seq: w.seq + 1, Op: op, DB: dbName, Path: path, Body: body, User: user, Time: time.Now().UnixMilli()}
+	last := w.changes[dbName]
+	w.number(&rec)
+	b, err := json.Marshal(rec)
+	if err != nil {
+		w.changes[dbName] = last
+		return fmt.Errorf("unable to encode log record: %w", err)
+	}
+	b = append(b, '\n')
+	if _, err = w.file.Write(b); err == nil {
+		err = w.file.Sync()
+	}
+	if err != nil {
+		//the record may be partly written, or written but not flushed, so nothing logged after it could be trusted
+		w.broken = fmt.Errorf("%w: %v", ErrBroken, err)
+		w.changes[dbName] = last
+		slog.Error("Unable to write to the write-ahead log, refusing every change from now on", "error", err)
+		return w.broken
+	}
+	w.seq = rec.Seq
+	if w.observer != nil {
+		w.observer(rec)
+	}
+	return nil
+}
+
+// RecordCreateDB logs the creation of the database dbName by user
+func (w *WAL) RecordCreateDB(dbName string, user string) error {
+	return w.append(OpCreateDB, dbName, "", nil, user)
+}
+
+// RecordDeleteDB logs the deletion of the database dbName by user
+func (w *WAL) RecordDeleteDB(dbName string, user string) error {
+	return w.append(OpDeleteDB, dbName, "", nil, user)
+}
+
+// RecordPutDoc logs the new state of the document at docpath; serial is the document as returned by GetSerial, whose
+// metadata names the user who wrote it
+func (w *WAL) RecordPutDoc(dbName string, docpath string, serial []byte) error {
+	return w.append(OpPutDoc, dbName, docpath, serial, "")
+}
+
+// RecordPutCol logs the creation of the collection at colpath by user
+func (w *WAL) RecordPutCol(dbName string, colpath string, user string) error {
+	return w.append(OpPutCol, dbName, colpath, nil, user)
+}
+
+// RecordDeleteDoc logs the deletion of the document at docpath by user
+func (w *WAL) RecordDeleteDoc(dbName string, docpath string, user string) error {
+	return w.append(OpDeleteDoc, dbName, docpath, nil, user)
+}
+
+// RecordDeleteCol logs the deletion of the collection at colpath by user
+func (w *WAL) RecordDeleteCol(dbName string, colpath string, user string) error {
+	return w.append(OpDeleteCol, dbName, colpath, nil, user)
+}
+
+// RecordImportDB logs the replacement of the database dbName by an import made by user; entries is a JSON array
+// holding every imported entry
+func (w *WAL) RecordImportDB(dbName string, entries []byte, user string) error {
+	return w.append(OpImportDB, dbName, "", entries, user)
+}
+
+// RecordPutIndex logs the creation of the secondary index described by def on the collection at colpath
+func (w *WAL) RecordPutIndex(dbName string, colpath string, def fieldIndex.Definition) error {
+	body, _ := json.Marshal(def)
+	return w.append(OpPutIndex, dbName, colpath, body, "")
+}
+
+// RecordDeleteIndex logs the deletion of the secondary index on field from the collection at colpath
+func (w *WAL) RecordDeleteIndex(dbName string, colpath string, field string) error {
+	body, _ := json.Marshal(fieldIndex.Definition{Field: field})
+	return w.append(OpDeleteIndex, dbName, colpath, body, "")
+}
+
+// RecordTransaction logs the writes of a transaction made by user as a single record, so that replay applies all of
+// them or none. The document at docpaths[i] was left in the state serials[i], or deleted if serials[i] is nil
+func (w *WAL) RecordTransaction(dbName string, docpaths []string, serials [][]byte, user string) error {
+	writes := make([]Record, len(docpaths))
+	for i, docpath := range docpaths {
+		writes[i] = Record{Op: OpPutDoc, Path: docpath, Body: serials[i]}
+		if serials[i] == nil {
+			writes[i].Op = OpDeleteDoc
+		}
+	}
+	body, _ := json.Marshal(writes)
+	return w.append(OpTransaction, dbName, "", body, user)
+}
+
+// Close flushes and closes the log
+func (w *WAL) Close() error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	return w.file.Close()
+}
+
+// Discard is a journal that records nothing; it is used when the server runs without a data directory
+type Discard struct{}
+
+func (Discard) RecordCreateDB(dbName string, user string) error                  { return nil }
+func (Discard) RecordDeleteDB(dbName string, user string) error                  { return nil }
+func (Discard) RecordPutDoc(dbName string, docpath string, serial []byte) error  { return nil }
+func (Discard) RecordPutCol(dbName string, colpath string, user string) error    { return nil }
+func (Discard) RecordDeleteDoc(dbName string, docpath string, user string) error { return nil }
+func (Discard) RecordDeleteCol(dbName string, colpath string, user string) error { return nil }
+func (Discard) RecordImportDB(dbName string, entries []byte, user string) error  { return nil }
+func (Discard) RecordPutIndex(dbName string, colpath string, def fieldIndex.Definition) error {
+	return nil
+}
+func (Discard) RecordDeleteIndex(dbName string, colpath string, field string) error { return nil }
+func (Discard) RecordTransaction(dbName string, docpaths []string, serials [][]byte, user string) error {
+	return nil
+}
@@ -0,0 +1,315 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/fieldIndex"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/precondition"
+)
+
+// mockTarget records every operation replayed onto it, journaling each one back to wal
+type mockTarget struct {
+	ops []string
+	wal *WAL
+}
+
+// by describes the user an operation was replayed on behalf of, if any
+func by(user string) string {
+	if user == "" {
+		return ""
+	}
+	return " by " + user
+}
+
+func (m *mockTarget) CreateDB(dbName string, user string) ([]byte, int, string) {
+	m.ops = append(m.ops, "createdb "+dbName+by(user))
+	m.wal.RecordCreateDB(dbName, user)
+	return nil, http.StatusCreated, ""
+}
+
+func (m *mockTarget) PutCol(dtb string, colpath string, user string) ([]byte, int, string) {
+	m.ops = append(m.ops, "putcol "+dtb+"/"+colpath+by(user))
+	return nil, http.StatusCreated, ""
+}
+
+func (m *mockTarget) RestoreDoc(dbName string, docpath string, serial []byte) ([]byte, int) {
+	m.ops = append(m.ops, "putdoc "+dbName+"/"+docpath+" "+string(serial))
+	return nil, http.StatusCreated
+}
+
+func (m *mockTarget) ImportDB(dbName string, dump []byte, user string) ([]byte, int, string) {
+	m.ops = append(m.ops, "importdb "+dbName+by(user)+" "+string(dump))
+	return nil, http.StatusCreated, ""
+}
+
+func (m *mockTarget) PutIndex(dtb string, colpath string, def fieldIndex.Definition) ([]byte, int, string) {
+	m.ops = append(m.ops, "putindex "+dtb+"/"+colpath+" "+def.Field)
+	return nil, http.StatusCreated, ""
+}
+
+func (m *mockTarget) DeleteIndex(dtb string, colpath string, field string) ([]byte, int) {
+	m.ops = append(m.ops, "deleteindex "+dtb+"/"+colpath+" "+field)
+	return nil, http.StatusNoContent
+}
+
+func (m *mockTarget) DeleteDB(dbName string, user string) ([]byte, int) {
+	m.ops = append(m.ops, "deletedb "+dbName+by(user))
+	return nil, http.StatusNoContent
+}
+
+func (m *mockTarget) DeleteCol(dtb string, colpath string, user string) ([]byte, int) {
+	m.ops = append(m.ops, "deletecol "+dtb+"/"+colpath+by(user))
+	return nil, http.StatusNoContent
+}
+
+func (m *mockTarget) DeleteDoc(dbName string, docpath string, user string, cond precondition.Conditions) ([]byte, int) {
+	m.ops = append(m.ops, "deletedoc "+dbName+"/"+docpath+by(user))
+	return nil, http.StatusNoContent
+}
+
+func TestWAL_Replay(t *testing.T) {
+	dir := t.TempDir()
+	wal, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %s", err.Error())
+	}
+	wal.RecordCreateDB("db", "alice")
+	wal.RecordPutDoc("db", "doc", []byte(`{"path":"/doc","doc":{},"meta":{}}`))
+	wal.RecordPutCol("db", "doc/col", "alice")
+	wal.RecordPutIndex("db", "doc/col", fieldIndex.Definition{Field: "/email"})
+	wal.RecordDeleteIndex("db", "doc/col", "/email")
+	wal.RecordDeleteCol("db", "doc/col", "bob")
+	wal.RecordDeleteDoc("db", "doc", "bob")
+	wal.RecordDeleteDB("db", "bob")
+	wal.RecordImportDB("db", []byte(`[{"path":"/a/"},{"path":"/b"}]`), "carol")
+	wal.Close()
+
+	wal, err = Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %s", err.Error())
+	}
+	defer wal.Close()
+	target := &mockTarget{wal: wal}
+	if err = wal.Replay(target, target); err != nil {
+		t.Fatalf("Replay failed: %s", err.Error())
+	}
+	expected := []string{
+		"createdb db by alice",
+		`putdoc db/doc {"path":"/doc","doc":{},"meta":{}}`,
+		"putcol db/doc/col by alice",
+		"putindex db/doc/col /email",
+		"deleteindex db/doc/col /email",
+		"deletecol db/doc/col by bob",
+		"deletedoc db/doc by bob",
+		"deletedb db by bob",
+		"importdb db by carol {\"path\":\"/a/\"}\n{\"path\":\"/b\"}\n",
+	}
+	if !reflect.DeepEqual(target.ops, expected) {
+		t.Errorf("TestWAL_Replay failed, expected %v, got %v", expected, target.ops)
+	}
+	if wal.seq != 9 {
+		t.Errorf("TestWAL_Replay failed, expected sequence number 9 after replay, got %d", wal.seq)
+	}
+}
+
+func TestWAL_ReplayTornRecord(t *testing.T) {
+	dir := t.TempDir()
+	wal, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %s", err.Error())
+	}
+	wal.RecordCreateDB("db", "")
+	wal.Close()
+
+	//simulating a crash in the middle of a write
+	f, _ := os.OpenFile(segmentPath(dir, 1), os.O_APPEND|os.O_WRONLY, 0o644)
+	f.WriteString(`{"seq":2,"op":"createdb","db":"to`)
+	f.Close()
+
+	wal, err = Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %s", err.Error())
+	}
+	target := &mockTarget{wal: wal}
+	if err = wal.Replay(target, target); err != nil {
+		t.Fatalf("Replay failed: %s", err.Error())
+	}
+	if !reflect.DeepEqual(target.ops, []string{"createdb db"}) {
+		t.Errorf("TestWAL_ReplayTornRecord failed, got %v", target.ops)
+	}
+	wal.RecordCreateDB("db2", "")
+	wal.Close()
+
+	wal, _ = Open(dir)
+	defer wal.Close()
+	target = &mockTarget{wal: wal}
+	wal.Replay(target, target)
+	if !reflect.DeepEqual(target.ops, []string{"createdb db", "createdb db2"}) {
+		t.Errorf("TestWAL_ReplayTornRecord failed, expected the torn record to be dropped, got %v", target.ops)
+	}
+}
+
+func TestWAL_ReplayCorruptRecord(t *testing.T) {
+	dir := t.TempDir()
+	wal, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %s", err.Error())
+	}
+	wal.RecordCreateDB("db", "")
+	wal.Close()
+
+	//damaging a record in the middle of the log
+	f, _ := os.OpenFile(segmentPath(dir, 1), os.O_APPEND|os.O_WRONLY, 0o644)
+	f.WriteString("{\"seq\":2,\"op\":\"crea\x00\x00\n")
+	f.WriteString(`{"seq":3,"op":"createdb","db":"db3"}` + "\n")
+	f.Close()
+	before, _ := os.ReadFile(segmentPath(dir, 1))
+
+	wal, err = Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %s", err.Error())
+	}
+	defer wal.Close()
+	target := &mockTarget{wal: wal}
+	if err = wal.Replay(target, target); err == nil {
+		t.Errorf("TestWAL_ReplayCorruptRecord failed, expected replay to refuse a corrupted log")
+	}
+	after, _ := os.ReadFile(segmentPath(dir, 1))
+	if string(after) != string(before) {
+		t.Errorf("TestWAL_ReplayCorruptRecord failed, expected the corrupted log to be left as it was")
+	}
+}
+
+func TestWAL_ReplayTransaction(t *testing.T) {
+	dir := t.TempDir()
+	wal, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %s", err.Error())
+	}
+	wal.RecordTransaction("db", []string{"a", "b/c/d"}, [][]byte{[]byte(`{"path":"/a","doc":{},"meta":{}}`), nil}, "alice")
+	wal.Close()
+
+	wal, err = Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %s", err.Error())
+	}
+	defer wal.Close()
+	target := &mockTarget{wal: wal}
+	if err = wal.Replay(target, target); err != nil {
+		t.Fatalf("Replay failed: %s", err.Error())
+	}
+	expected := []string{
+		`putdoc db/a {"path":"/a","doc":{},"meta":{}}`,
+		"deletedoc db/b/c/d by alice",
+	}
+	if !reflect.DeepEqual(target.ops, expected) {
+		t.Errorf("TestWAL_ReplayTransaction failed, expected %v, got %v", expected, target.ops)
+	}
+	if wal.seq != 1 {
+		t.Errorf("TestWAL_ReplayTransaction failed, expected a single record, got sequence number %d", wal.seq)
+	}
+}
+
+func TestWAL_Broken(t *testing.T) {
+	dir := t.TempDir()
+	wal, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %s", err.Error())
+	}
+	if err = wal.RecordCreateDB("db", "alice"); err != nil {
+		t.Fatalf("RecordCreateDB failed: %s", err.Error())
+	}
+
+	//simulating a disk that can no longer be written to
+	wal.file.Close()
+	if err = wal.RecordCreateDB("db2", "alice"); !errors.Is(err, ErrBroken) {
+		t.Errorf("TestWAL_Broken failed, expected ErrBroken, got %v", err)
+	}
+	wal.file, _ = os.OpenFile(segmentPath(dir, 1), os.O_APPEND|os.O_WRONLY, 0o644)
+	if err = wal.RecordDeleteDB("db", "alice"); !errors.Is(err, ErrBroken) {
+		t.Errorf("TestWAL_Broken failed, expected every record after a failure to be refused, got %v", err)
+	}
+	if wal.seq != 1 {
+		t.Errorf("TestWAL_Broken failed, expected the refused records not to be numbered, got sequence number %d", wal.seq)
+	}
+	wal.file.Close()
+
+	wal, _ = Open(dir)
+	defer wal.Close()
+	target := &mockTarget{wal: wal}
+	wal.Replay(target, target)
+	if !reflect.DeepEqual(target.ops, []string{"createdb db by alice"}) {
+		t.Errorf("TestWAL_Broken failed, expected only the acknowledged record, got %v", target.ops)
+	}
+}
+
+func TestWAL_ChangeNumbers(t *testing.T) {
+	dir := t.TempDir()
+	wal, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %s", err.Error())
+	}
+	epoch := wal.Epoch()
+	wal.RecordCreateDB("db", "alice")
+	wal.RecordPutIndex("db", "doc/col", fieldIndex.Definition{Field: "/email"})
+	wal.RecordTransaction("db", []string{"a", "b"}, [][]byte{[]byte(`{"path":"/a","doc":{},"meta":{}}`), nil}, "alice")
+	wal.RecordCreateDB("other", "alice")
+	wal.RecordDeleteDoc("db", "a", "bob")
+	if wal.LastChange("db") != 4 || wal.LastChange("other") != 1 || wal.LastChange("none") != 0 {
+		t.Errorf("TestWAL_ChangeNumbers failed, got %d and %d", wal.LastChange("db"), wal.LastChange("other"))
+	}
+	//the second snapshot leaves none of the records above in the log
+	for i := 0; i < 2; i++ {
+		if err = wal.Snapshot(context.Background(), mockSource{}, 1); err != nil {
+			t.Fatalf("Snapshot failed: %s", err.Error())
+		}
+	}
+	wal.RecordDeleteDB("db", "bob")
+	wal.Close()
+
+	wal, err = Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %s", err.Error())
+	}
+	defer wal.Close()
+	var replayed []Record
+	wal.Observe(func(rec Record) {
+		replayed = append(replayed, rec)
+	})
+	target := &mockTarget{wal: wal}
+	if err = wal.Replay(target, target); err != nil {
+		t.Fatalf("Replay failed: %s", err.Error())
+	}
+	if wal.Epoch() != epoch {
+		t.Errorf("TestWAL_ChangeNumbers failed, expected the epoch %s to be kept, got %s", epoch, wal.Epoch())
+	}
+	//only the record logged since the snapshots is replayed, numbered as it was logged
+	if len(replayed) != 1 || replayed[0].Op != OpDeleteDB || replayed[0].Change != 5 || replayed[0].Time == 0 {
+		t.Errorf("TestWAL_ChangeNumbers failed, expected deletedb numbered 5, got %+v", replayed)
+	}
+	wal.RecordCreateDB("db", "alice")
+	if wal.LastChange("db") != 6 || wal.LastChange("other") != 1 {
+		t.Errorf("TestWAL_ChangeNumbers failed, expected the numbering to continue, got %d and %d", wal.LastChange("db"), wal.LastChange("other"))
+	}
+}
+
+func TestWAL_ChangeNumbersOfOlderLogs(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(segmentPath(dir, 1), []byte(`{"seq":1,"op":"createdb","db":"db"}`+"\n"+`{"seq":2,"op":"putcol","db":"db","path":"doc/col"}`+"\n"), 0o644)
+	wal, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %s", err.Error())
+	}
+	defer wal.Close()
+	target := &mockTarget{wal: wal}
+	wal.Replay(target, target)
+	wal.RecordDeleteDB("db", "")
+	if wal.LastChange("db") != 3 {
+		t.Errorf("TestWAL_ChangeNumbersOfOlderLogs failed, expected records without numbers to be counted, got %d", wal.LastChange("db"))
+	}
+}
@@ -0,0 +1,82 @@
+// Package precondition evaluates the conditional request headers If-Match and If-None-Match against the versions of
+// documents, following RFC 9110. A document's entity tag is its version, quoted.
+package precondition
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrFailed is wrapped by the errors returned when a precondition does not hold
+var ErrFailed = errors.New("precondition failed")
+
+// Conditions holds the entity tags listed by the If-Match and If-None-Match headers of a request. A nil list stands for
+// an absent header, and "*" matches any existing document.
+type Conditions struct {
+	IfMatch     []string // the request applies only if the document's tag is listed
+	IfNoneMatch []string // the request applies only if the document's tag is not listed
+}
+
+// Parse builds the conditions of a request from the values of its If-Match and If-None-Match headers, either of
+// which may be empty
+func Parse(ifMatch string, ifNoneMatch string) Conditions {
+	return Conditions{IfMatch: splitTags(ifMatch), IfNoneMatch: splitTags(ifNoneMatch)}
+}
+
+// splitTags splits the comma-separated list of entity tags held by a header.
+// Returns the tags, or nil if the header is empty
+func splitTags(header string) []string {
+	if strings.TrimSpace(header) == "" {
+		return nil
+	}
+	var tags []string
+	for _, tag := range strings.Split(header, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// ETag returns the entity tag of a document at the given version
+func ETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// Check evaluates the conditions against a document that exists at the given version, or does not exist at all.
+// Returns nil if the request may proceed, otherwise an error wrapping ErrFailed
+func (c Conditions) Check(exists bool, version int64) error {
+	if c.IfMatch != nil && !(exists && matches(c.IfMatch, version, false)) {
+		return fmt.Errorf("%w: If-Match does not match the current version of the document", ErrFailed)
+	}
+	if c.IfNoneMatch != nil && exists && matches(c.IfNoneMatch, version, true) {
+		return fmt.Errorf("%w: If-None-Match matches the current version of the document", ErrFailed)
+	}
+	return nil
+}
+
+// NotModified reports whether a read of a document at the given version may be answered with 304 Not Modified,
+// because If-None-Match lists its tag
+func (c Conditions) NotModified(version int64) bool {
+	return c.IfNoneMatch != nil && matches(c.IfNoneMatch, version, true)
+}
+
+// matches reports whether tags hold "*" or the tag of version. Weak tags only match under weak comparison, which
+// If-None-Match uses, while If-Match uses strong comparison.
+func matches(tags []string, version int64, weak bool) bool {
+	etag := ETag(version)
+	for _, tag := range tags {
+		if tag == "*" {
+			return true
+		}
+		if weak {
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+		if tag == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package precondition
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestConditions_Check(t *testing.T) {
+	tests := []struct {
+		name        string
+		ifMatch     string
+		ifNoneMatch string
+		exists      bool
+		version     int64
+		wantErr     bool
+	}{
+		{"no conditions", "", "", true, 3, false},
+		{"no conditions on a missing document", "", "", false, 0, false},
+		{"if-match current", `"3"`, "", true, 3, false},
+		{"if-match listed", `"1", "3"`, "", true, 3, false},
+		{"if-match stale", `"2"`, "", true, 3, true},
+		{"if-match weak", `W/"3"`, "", true, 3, true},
+		{"if-match any", "*", "", true, 3, false},
+		{"if-match any on a missing document", "*", "", false, 0, true},
+		{"if-match on a missing document", `"1"`, "", false, 0, true},
+		{"if-none-match any", "", "*", true, 3, true},
+		{"if-none-match any on a missing document", "", "*", false, 0, false},
+		{"if-none-match current", "", `"3"`, true, 3, true},
+		{"if-none-match weak", "", `W/"3"`, true, 3, true},
+		{"if-none-match stale", "", `"2"`, true, 3, false},
+	}
+	for _, test := range tests {
+		err := Parse(test.ifMatch, test.ifNoneMatch).Check(test.exists, test.version)
+		if test.wantErr != (err != nil) {
+			t.Errorf("%s: expected an error %t, got %v", test.name, test.wantErr, err)
+		}
+		if err != nil && !errors.Is(err, ErrFailed) {
+			t.Errorf("%s: expected the error to wrap ErrFailed, got %v", test.name, err)
+		}
+	}
+}
+
+func TestConditions_NotModified(t *testing.T) {
+	if !Parse("", `"1", W/"2"`).NotModified(2) {
+		t.Errorf("expected a listed version not to be modified")
+	}
+	if Parse("", `"1"`).NotModified(2) {
+		t.Errorf("expected an unlisted version to be modified")
+	}
+	if Parse("", "").NotModified(2) {
+		t.Errorf("expected a read without If-None-Match to be modified")
+	}
+}
@@ -0,0 +1,308 @@
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/jsondata"
+)
+
+// Filter is a predicate on the contents of documents, parsed from an expression such as
+//
+//	age>30 AND tags contains "x"
+//
+// An expression combines comparisons with AND, OR, NOT and parentheses. A comparison names a field, using dots to
+// reach into nested objects and arrays (address.city, tags.0), followed by one of = != < <= > >= contains and a
+// JSON literal. A field may also be written as a JSON pointer (/address/city, /tags/0), and = as ==, so that
+// /status=="open" holds for documents whose status is "open". Comparisons on a field the document does not have
+// are false.
+type Filter struct {
+	root node // the root of the parsed expression
+}
+
+// node is a node of a parsed filter expression
+type node interface {
+	match(doc jsondata.JSONValue) bool // evaluates the node against the contents of a document
+}
+
+// andNode holds when both of its operands hold
+type andNode struct {
+	left, right node
+}
+
+// orNode holds when either of its operands holds
+type orNode struct {
+	left, right node
+}
+
+// notNode holds when its operand does not
+type notNode struct {
+	operand node
+}
+
+func (n andNode) match(doc jsondata.JSONValue) bool {
+	return n.left.match(doc) && n.right.match(doc)
+}
+
+func (n orNode) match(doc jsondata.JSONValue) bool {
+	return n.left.match(doc) || n.right.match(doc)
+}
+
+func (n notNode) match(doc jsondata.JSONValue) bool {
+	return !n.operand.match(doc)
+}
+
+// ParseFilter parses a filter expression.
+// Returns the filter, or an error describing the first malformed part of the expression
+func ParseFilter(expr string) (*Filter, error) {
+	toks, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("malformed filter: unexpected '%s'", p.toks[p.pos].text)
+	}
+	return &Filter{root: root}, nil
+}
+
+// Match reports whether the contents of a document satisfy the filter
+func (f *Filter) Match(doc jsondata.JSONValue) bool {
+	return f.root.match(doc)
+}
+
+// MatchSerial reports whether the contents of a serialized document satisfy the filter
+func (f *Filter) MatchSerial(serial []byte) bool {
+	parsed, err := parse(serial)
+	return err == nil && f.Match(parsed.Doc)
+}
+
+// Equality is a comparison of a field to a literal with =
+type Equality struct {
+	Field []string // the segments of the field's path
+	Value any      // the literal, as a float64, string, bool, nil, []any or map[string]any
+}
+
+// Equalities returns the equalities every document kept by f satisfies: the = comparisons joined to the rest of the
+// expression by AND alone. A nil filter has none.
+func (f *Filter) Equalities() []Equality {
+	if f == nil {
+		return nil
+	}
+	return equalities(f.root, nil)
+}
+
+// equalities appends the equalities every document satisfying n satisfies to acc
+func equalities(n node, acc []Equality) []Equality {
+	switch n := n.(type) {
+	case andNode:
+		return equalities(n.right, equalities(n.left, acc))
+	case comparison:
+		if n.op == "=" {
+			acc = append(acc, Equality{Field: n.field, Value: n.literal})
+		}
+	}
+	return acc
+}
+
+// The kinds of tokens in a filter expression
+const (
+	tokWord   = iota // a field, a keyword or a bare literal
+	tokString        // a quoted string literal
+	tokOp            // a comparison operator
+	tokLParen        // (
+	tokRParen        // )
+)
+
+// token is a lexical token of a filter expression
+type token struct {
+	kind int    // the kind of token
+	text string // the token as written
+}
+
+// tokenize splits a filter expression into tokens.
+// Returns the tokens, or an error if the expression holds an unterminated string
+func tokenize(expr string) ([]token, error) {
+	var toks []token
+	isDelim := func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune(`()=!<>"`, r)
+	}
+	runes := []rune(expr)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			toks = append(toks, token{kind: tokLParen, text: "("})
+			i++
+		case r == ')':
+			toks = append(toks, token{kind: tokRParen, text: ")"})
+			i++
+		case strings.ContainsRune("=!<>", r):
+			op := string(r)
+			if i+1 < len(runes) && runes[i+1] == '=' {
+				op += "="
+			}
+			toks = append(toks, token{kind: tokOp, text: op})
+			i += len(op)
+		case r == '"':
+			j := i + 1
+			for ; j < len(runes) && runes[j] != '"'; j++ {
+				if runes[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(runes) {
+				return nil, fmt.Errorf("malformed filter: unterminated string")
+			}
+			toks = append(toks, token{kind: tokString, text: string(runes[i : j+1])})
+			i = j + 1
+		default:
+			j := i
+			for j < len(runes) && !isDelim(runes[j]) {
+				j++
+			}
+			toks = append(toks, token{kind: tokWord, text: string(runes[i:j])})
+			i = j
+		}
+	}
+	return toks, nil
+}
+
+// parser is a recursive descent parser over the tokens of a filter expression
+type parser struct {
+	toks []token // the tokens of the expression
+	pos  int     // the index of the next token
+}
+
+// peekKeyword reports whether the next token is the keyword kw, compared case-insensitively
+func (p *parser) peekKeyword(kw string) bool {
+	return p.pos < len(p.toks) && p.toks[p.pos].kind == tokWord && strings.EqualFold(p.toks[p.pos].text, kw)
+}
+
+// parseOr parses a disjunction of conjunctions
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKeyword("OR") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseAnd parses a conjunction of factors
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseFactor()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKeyword("AND") {
+		p.pos++
+		right, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseFactor parses a negation, a parenthesized expression or a comparison
+func (p *parser) parseFactor() (node, error) {
+	if p.pos >= len(p.toks) {
+		return nil, fmt.Errorf("malformed filter: unexpected end of expression")
+	}
+	switch tok := p.toks[p.pos]; {
+	case p.peekKeyword("NOT"):
+		p.pos++
+		operand, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand: operand}, nil
+	case tok.kind == tokLParen:
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.pos >= len(p.toks) || p.toks[p.pos].kind != tokRParen {
+			return nil, fmt.Errorf("malformed filter: missing ')'")
+		}
+		p.pos++
+		return inner, nil
+	default:
+		return p.parseComparison()
+	}
+}
+
+// parseComparison parses a field, an operator and a literal
+func (p *parser) parseComparison() (node, error) {
+	if p.pos+3 > len(p.toks) {
+		return nil, fmt.Errorf("malformed filter: incomplete comparison")
+	}
+	fieldTok, opTok, litTok := p.toks[p.pos], p.toks[p.pos+1], p.toks[p.pos+2]
+	if fieldTok.kind != tokWord {
+		return nil, fmt.Errorf("malformed filter: expected a field, got '%s'", fieldTok.text)
+	}
+	field, err := parseField(fieldTok.text)
+	if err != nil {
+		return nil, err
+	}
+
+	op := opTok.text
+	switch {
+	case opTok.kind == tokWord && strings.EqualFold(op, "contains"):
+		op = "contains"
+	case opTok.kind == tokOp && op == "==":
+		op = "="
+	case opTok.kind == tokOp && op != "!":
+	default:
+		return nil, fmt.Errorf("malformed filter: expected an operator, got '%s'", opTok.text)
+	}
+
+	if litTok.kind != tokWord && litTok.kind != tokString {
+		return nil, fmt.Errorf("malformed filter: expected a literal, got '%s'", litTok.text)
+	}
+	var literal any
+	if err = json.Unmarshal([]byte(litTok.text), &literal); err != nil {
+		return nil, fmt.Errorf("malformed filter: bad literal '%s'; strings must be quoted", litTok.text)
+	}
+	wrapped, _ := jsondata.NewJSONValue(literal)
+	p.pos += 3
+	return comparison{field: field, op: op, literal: literal, wrapped: wrapped}, nil
+}
+
+// parseField splits the field of a comparison into the segments of its path, the field being either a JSON pointer
+// such as /address/city or dotted such as address.city.
+// Returns the segments, or an error if the field is malformed
+func parseField(text string) ([]string, error) {
+	if strings.HasPrefix(text, "/") {
+		field, err := parsePointer(text)
+		if err != nil {
+			return nil, fmt.Errorf("malformed filter: bad field '%s'", text)
+		}
+		return field, nil
+	}
+	field := strings.Split(text, ".")
+	for _, seg := range field {
+		if seg == "" {
+			return nil, fmt.Errorf("malformed filter: bad field '%s'", text)
+		}
+	}
+	return field, nil
+}
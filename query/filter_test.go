@@ -0,0 +1,149 @@
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/jsondata"
+)
+
+func mustDoc(t *testing.T, raw string) jsondata.JSONValue {
+	var doc jsondata.JSONValue
+	if err := json.Unmarshal([]byte(raw), &doc); err != nil {
+		t.Fatalf("bad test document %s", raw)
+	}
+	return doc
+}
+
+func TestFilter_Match(t *testing.T) {
+	doc := mustDoc(t, `{"age":42,"name":"ada","tags":["x","y"],"address":{"city":"Houston"},"admin":false,"boss":null}`)
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`age>30 AND tags contains "x"`, true},
+		{`age>30 AND tags contains "z"`, false},
+		{`age<30 OR name="ada"`, true},
+		{`age>=42 and age<=42`, true},
+		{`age!=42`, false},
+		{`age="42"`, false},
+		{`age!="42"`, true},
+		{`NOT (age>30)`, false},
+		{`address.city == "Houston"`, true},
+		{`tags.1 = "y"`, true},
+		{`tags.5 = "y"`, false},
+		{`/address/city=="Houston"`, true},
+		{`/tags/1 = "y" AND /age > 30`, true},
+		{`/name == "bob"`, false},
+		{`name contains "d"`, true},
+		{`name < "bob"`, true},
+		{`admin = false`, true},
+		{`boss = null`, true},
+		{`missing = 1`, false},
+		{`missing != 1`, false},
+		{`NOT missing = 1`, true},
+		{`(age>50 OR name="ada") AND NOT admin=true`, true},
+	}
+	for _, test := range tests {
+		f, err := ParseFilter(test.expr)
+		if err != nil {
+			t.Errorf("TestFilter_Match failed, unable to parse %s: %s", test.expr, err.Error())
+			continue
+		}
+		if got := f.Match(doc); got != test.want {
+			t.Errorf("TestFilter_Match failed, expected %s to be %t, got %t", test.expr, test.want, got)
+		}
+	}
+}
+
+func TestFilter_Malformed(t *testing.T) {
+	for _, expr := range []string{``, `age`, `age >`, `age > x`, `age ! 3`, `(age > 3`, `age > 3 AND`, `name = "ada`, `a..b = 1`, `age > 3 extra`} {
+		if _, err := ParseFilter(expr); err == nil {
+			t.Errorf("TestFilter_Malformed failed, expected %q to be rejected", expr)
+		}
+	}
+}
+
+func TestFilter_Equalities(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{`email = "a@b.c"`, `[{"Field":["email"],"Value":"a@b.c"}]`},
+		{`age > 3 AND (address.city == "Houston" AND admin = false)`, `[{"Field":["address","city"],"Value":"Houston"},{"Field":["admin"],"Value":false}]`},
+		{`email = "a@b.c" OR age = 3`, `null`},
+		{`NOT email = "a@b.c"`, `null`},
+		{`email != "a@b.c"`, `null`},
+		{`/address/city == "Houston"`, `[{"Field":["address","city"],"Value":"Houston"}]`},
+	}
+	for _, test := range tests {
+		f, err := ParseFilter(test.expr)
+		if err != nil {
+			t.Fatalf("TestFilter_Equalities failed, unable to parse %s: %s", test.expr, err.Error())
+		}
+		if got, _ := json.Marshal(f.Equalities()); string(got) != test.want {
+			t.Errorf("TestFilter_Equalities failed for %s, expected %s, got %s", test.expr, test.want, got)
+		}
+	}
+	var none *Filter
+	if none.Equalities() != nil {
+		t.Errorf("TestFilter_Equalities failed, expected no equalities without a filter")
+	}
+}
+
+func TestPage(t *testing.T) {
+	f, _ := ParseFilter(`n > 1`)
+	serial := func(key string, n int) []byte {
+		return []byte(fmt.Sprintf(`{"path":"/%s","doc":{"n":%d},"meta":{}}`, key, n))
+	}
+	docs := []struct {
+		key string
+		n   int
+	}{{"a", 2}, {"b", 1}, {"c", 3}, {"d", 4}, {"e", 5}}
+
+	tests := []struct {
+		opts Options
+		want []string
+	}{
+		{Options{}, []string{"a", "b", "c", "d", "e"}},
+		{Options{Filter: f}, []string{"a", "c", "d", "e"}},
+		{Options{Filter: f, Limit: 2}, []string{"a", "c"}},
+		{Options{Filter: f, Limit: 2, After: "c"}, []string{"d", "e"}},
+	}
+	for _, test := range tests {
+		page := test.opts.NewPage()
+		for _, doc := range docs {
+			if page.Add(doc.key, serial(doc.key, doc.n)) {
+				break
+			}
+		}
+		var got []struct {
+			Path string `json:"path"`
+		}
+		json.Unmarshal(page.Marshal(), &got)
+		var paths []string
+		for _, doc := range got {
+			paths = append(paths, doc.Path[1:])
+		}
+		if fmt.Sprint(paths) != fmt.Sprint(test.want) {
+			t.Errorf("TestPage failed, expected %v for %+v, got %v", test.want, test.opts, paths)
+		}
+	}
+	if (Options{After: "c"}).Low("a") != "c" || (Options{}).Low("a") != "a" {
+		t.Errorf("TestPage failed, expected the lower bound to resume from After")
+	}
+}
+
+func TestFilter_Pointer(t *testing.T) {
+	f, err := ParseFilter(`/status=="open"`)
+	if err != nil {
+		t.Fatalf("TestFilter_Pointer failed, unable to parse the filter: %s", err.Error())
+	}
+	if !f.Match(mustDoc(t, `{"status":"open"}`)) {
+		t.Errorf("TestFilter_Pointer failed, expected an open document to match")
+	}
+	if f.Match(mustDoc(t, `{"status":"closed"}`)) || f.Match(mustDoc(t, `{"/status":"open"}`)) {
+		t.Errorf("TestFilter_Pointer failed, expected only open documents to match")
+	}
+}
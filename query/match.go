@@ -0,0 +1,137 @@
+package query
+
+import (
+	"cmp"
+	"strconv"
+	"strings"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/jsondata"
+)
+
+// comparison holds when the value of a field compares to a literal as its operator requires
+type comparison struct {
+	field   []string           // the segments of the field's path
+	op      string             // one of = != < <= > >= contains
+	literal any                // the literal, as a float64, string, bool, nil, []any or map[string]any
+	wrapped jsondata.JSONValue // the literal, wrapped for equality checks
+}
+
+func (c comparison) match(doc jsondata.JSONValue) bool {
+	res, err := jsondata.Accept[bool](doc, matchVisitor{cmp: c, rest: c.field})
+	return err == nil && res
+}
+
+// equality applies an equality operator given whether the value equals the literal; other operators do not hold
+func (c comparison) equality(equal bool) bool {
+	switch c.op {
+	case "=":
+		return equal
+	case "!=":
+		return !equal
+	}
+	return false
+}
+
+// ordered applies the operator to two values of the same ordered type
+func ordered[T cmp.Ordered](op string, a T, b T) bool {
+	res := cmp.Compare(a, b)
+	switch op {
+	case "=":
+		return res == 0
+	case "!=":
+		return res != 0
+	case "<":
+		return res < 0
+	case "<=":
+		return res <= 0
+	case ">":
+		return res > 0
+	case ">=":
+		return res >= 0
+	}
+	return false
+}
+
+// matchVisitor descends along the field of a comparison, then evaluates the comparison on the value found there.
+// A field missing from the document makes the comparison false.
+type matchVisitor struct {
+	cmp  comparison // the comparison being evaluated
+	rest []string   // the segments of the field still to descend
+}
+
+// Map descends into the member named by the next segment, or compares the object to the literal
+func (v matchVisitor) Map(m map[string]jsondata.JSONValue) (bool, error) {
+	if len(v.rest) > 0 {
+		child, found := m[v.rest[0]]
+		if !found {
+			return false, nil
+		}
+		return jsondata.Accept[bool](child, matchVisitor{cmp: v.cmp, rest: v.rest[1:]})
+	}
+	val, err := jsondata.NewJSONValue(m)
+	return v.cmp.equality(err == nil && val.Equal(v.cmp.wrapped)), err
+}
+
+// Slice descends into the element indexed by the next segment, or checks whether the array contains the literal
+func (v matchVisitor) Slice(s []jsondata.JSONValue) (bool, error) {
+	if len(v.rest) > 0 {
+		i, err := strconv.Atoi(v.rest[0])
+		if err != nil || i < 0 || i >= len(s) {
+			return false, nil
+		}
+		return jsondata.Accept[bool](s[i], matchVisitor{cmp: v.cmp, rest: v.rest[1:]})
+	}
+	if v.cmp.op == "contains" {
+		for _, elem := range s {
+			if elem.Equal(v.cmp.wrapped) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	val, err := jsondata.NewJSONValue(s)
+	return v.cmp.equality(err == nil && val.Equal(v.cmp.wrapped)), err
+}
+
+// Bool compares a boolean to the literal
+func (v matchVisitor) Bool(b bool) (bool, error) {
+	if len(v.rest) > 0 {
+		return false, nil
+	}
+	lit, isBool := v.cmp.literal.(bool)
+	return v.cmp.equality(isBool && lit == b), nil
+}
+
+// Float64 compares a number to the literal
+func (v matchVisitor) Float64(f float64) (bool, error) {
+	if len(v.rest) > 0 {
+		return false, nil
+	}
+	if lit, isNum := v.cmp.literal.(float64); isNum && v.cmp.op != "contains" {
+		return ordered(v.cmp.op, f, lit), nil
+	}
+	return v.cmp.equality(false), nil
+}
+
+// String compares a string to the literal, or checks whether it contains the literal
+func (v matchVisitor) String(s string) (bool, error) {
+	if len(v.rest) > 0 {
+		return false, nil
+	}
+	lit, isStr := v.cmp.literal.(string)
+	switch {
+	case isStr && v.cmp.op == "contains":
+		return strings.Contains(s, lit), nil
+	case isStr:
+		return ordered(v.cmp.op, s, lit), nil
+	}
+	return v.cmp.equality(false), nil
+}
+
+// Null compares null to the literal
+func (v matchVisitor) Null() (bool, error) {
+	if len(v.rest) > 0 {
+		return false, nil
+	}
+	return v.cmp.equality(v.cmp.literal == nil), nil
+}
@@ -0,0 +1,78 @@
+package query
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/jsondata"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/patcher"
+)
+
+// parsePointer splits a JSON pointer naming a value inside documents into its segments.
+// Returns the segments, or an error if ptr is not a JSON pointer to a value below the root
+func parsePointer(ptr string) ([]string, error) {
+	segments, err := patcher.SplitJSONPointer(ptr)
+	if err != nil || len(segments) == 0 {
+		return nil, err
+	}
+	return segments, nil
+}
+
+// joinPointer builds the JSON pointer made of segments
+func joinPointer(segments []string) string {
+	var b strings.Builder
+	for _, seg := range segments {
+		b.WriteString("/" + patcher.EscapeJSONPointer(seg))
+	}
+	return b.String()
+}
+
+// lookupVisitor descends along the segments of a JSON pointer, returning the value found there or nil if the
+// document holds no such value
+type lookupVisitor struct {
+	rest []string // the segments still to descend
+}
+
+// descend continues the lookup into child
+func (v lookupVisitor) descend(child jsondata.JSONValue) (*jsondata.JSONValue, error) {
+	if len(v.rest) == 1 {
+		return &child, nil
+	}
+	return jsondata.Accept[*jsondata.JSONValue](child, lookupVisitor{rest: v.rest[1:]})
+}
+
+// Map descends into the member named by the next segment
+func (v lookupVisitor) Map(m map[string]jsondata.JSONValue) (*jsondata.JSONValue, error) {
+	child, found := m[v.rest[0]]
+	if !found {
+		return nil, nil
+	}
+	return v.descend(child)
+}
+
+// Slice descends into the element indexed by the next segment
+func (v lookupVisitor) Slice(s []jsondata.JSONValue) (*jsondata.JSONValue, error) {
+	i, err := strconv.Atoi(v.rest[0])
+	if err != nil || i < 0 || i >= len(s) {
+		return nil, nil
+	}
+	return v.descend(s[i])
+}
+
+// Bool, Float64, String and Null end the lookup: scalars have no members
+func (v lookupVisitor) Bool(bool) (*jsondata.JSONValue, error)       { return nil, nil }
+func (v lookupVisitor) Float64(float64) (*jsondata.JSONValue, error) { return nil, nil }
+func (v lookupVisitor) String(string) (*jsondata.JSONValue, error)   { return nil, nil }
+func (v lookupVisitor) Null() (*jsondata.JSONValue, error)           { return nil, nil }
+
+// lookup returns the value at the pointer made of segments within doc, or nil if there is none
+func lookup(doc jsondata.JSONValue, segments []string) *jsondata.JSONValue {
+	if len(segments) == 0 {
+		return &doc
+	}
+	found, err := jsondata.Accept[*jsondata.JSONValue](doc, lookupVisitor{rest: segments})
+	if err != nil {
+		return nil
+	}
+	return found
+}
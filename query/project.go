@@ -0,0 +1,137 @@
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/jsondata"
+)
+
+// Projection selects the parts of documents' contents returned by a read. Selected values keep their place in the
+// document: members of objects stay under their names and elements of arrays keep their order.
+type Projection struct {
+	fields [][]string // the segments of the JSON pointers selected
+}
+
+// ParseFields parses a comma-separated list of JSON pointers, such as /a,/b/c.
+// Returns the projection, or an error if a pointer is malformed
+func ParseFields(spec string) (*Projection, error) {
+	p := &Projection{}
+	seen := map[string]bool{}
+	for _, ptr := range strings.Split(spec, ",") {
+		field, err := parsePointer(ptr)
+		if err != nil || field == nil {
+			return nil, fmt.Errorf("malformed fields parameter: '%s' is not a JSON pointer", ptr)
+		}
+		if canonical := joinPointer(field); !seen[canonical] {
+			seen[canonical] = true
+			p.fields = append(p.fields, field)
+		}
+	}
+	return p, nil
+}
+
+// Apply returns the serialized document serial with its contents reduced to the selected values
+func (p *Projection) Apply(serial []byte) []byte {
+	parsed, err := parse(serial)
+	if err != nil {
+		return serial
+	}
+	projected, err := jsondata.Accept[*jsondata.JSONValue](parsed.Doc, projectVisitor{fields: p.fields})
+	if err != nil || projected == nil {
+		empty, _ := jsondata.NewJSONValue(map[string]any{})
+		projected = &empty
+	}
+	parsed.Doc = *projected
+	res, err := json.Marshal(parsed)
+	if err != nil {
+		return serial
+	}
+	return res
+}
+
+// projectVisitor keeps the parts of a value selected by the remaining segments of some fields, returning nil if none
+// of them is found
+type projectVisitor struct {
+	fields [][]string // the remaining segments of the fields reaching the value
+}
+
+// group splits the fields by their first segment, in the order segments first appear
+func (v projectVisitor) group() (firsts []string, rests map[string][][]string) {
+	rests = map[string][][]string{}
+	for _, field := range v.fields {
+		if _, seen := rests[field[0]]; !seen {
+			firsts = append(firsts, field[0])
+		}
+		rests[field[0]] = append(rests[field[0]], field[1:])
+	}
+	return firsts, rests
+}
+
+// project keeps the parts of child selected by rest; an empty rest selects child whole
+func project(child jsondata.JSONValue, rest [][]string) *jsondata.JSONValue {
+	var deeper [][]string
+	for _, r := range rest {
+		if len(r) == 0 {
+			return &child
+		}
+		deeper = append(deeper, r)
+	}
+	res, err := jsondata.Accept[*jsondata.JSONValue](child, projectVisitor{fields: deeper})
+	if err != nil {
+		return nil
+	}
+	return res
+}
+
+// Map keeps the selected members of an object
+func (v projectVisitor) Map(m map[string]jsondata.JSONValue) (*jsondata.JSONValue, error) {
+	firsts, rests := v.group()
+	res := map[string]jsondata.JSONValue{}
+	for _, name := range firsts {
+		child, found := m[name]
+		if !found {
+			continue
+		}
+		if kept := project(child, rests[name]); kept != nil {
+			res[name] = *kept
+		}
+	}
+	if len(res) == 0 {
+		return nil, nil
+	}
+	val, err := jsondata.NewJSONValue(res)
+	return &val, err
+}
+
+// Slice keeps the selected elements of an array, in their original order
+func (v projectVisitor) Slice(s []jsondata.JSONValue) (*jsondata.JSONValue, error) {
+	firsts, rests := v.group()
+	var indices []int
+	for _, seg := range firsts {
+		if i, err := strconv.Atoi(seg); err == nil && strconv.Itoa(i) == seg && i >= 0 && i < len(s) {
+			indices = append(indices, i)
+		}
+	}
+	slices.Sort(indices)
+	var res []jsondata.JSONValue
+	for _, i := range indices {
+		if kept := project(s[i], rests[strconv.Itoa(i)]); kept != nil {
+			res = append(res, *kept)
+		}
+	}
+	if len(res) == 0 {
+		return nil, nil
+	}
+	val, err := jsondata.NewJSONValue(res)
+	return &val, err
+}
+
+// Bool, Float64, String and Null hold no members to select
+func (v projectVisitor) Bool(bool) (*jsondata.JSONValue, error)       { return nil, nil }
+func (v projectVisitor) Float64(float64) (*jsondata.JSONValue, error) { return nil, nil }
+func (v projectVisitor) String(string) (*jsondata.JSONValue, error)   { return nil, nil }
+func (v projectVisitor) Null() (*jsondata.JSONValue, error)           { return nil, nil }
@@ -0,0 +1,129 @@
+// Package query describes and evaluates the options of collection reads, which refine the documents returned on top
+// of the interval of names requested.
+package query
+
+import (
+	"encoding/json"
+	"path"
+	"slices"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/jsondata"
+)
+
+// Options refines the documents returned by a collection read. The zero value returns every document in the interval.
+type Options struct {
+	Filter *Filter // the predicate documents must satisfy; nil keeps every document
+
+	Sort *Sort // the order of the documents returned; nil orders them by name
+
+	Limit int // the maximum number of documents returned; 0 means no limit
+
+	After string // only documents ordered after the one named After are returned; empty means from the start
+
+	AfterValue json.RawMessage // with Sort, the value the document named After is sorted by; nil if it lacks one
+}
+
+// Low returns the lower bound to query an index with, given the lower bound lo of the interval requested
+func (o Options) Low(lo string) string {
+	if o.Sort != nil {
+		return lo
+	}
+	return max(lo, o.After)
+}
+
+// Matcher returns the predicate selecting serialized documents as the filter of o does, or nil if o has none
+func (o Options) Matcher() func(serial []byte) bool {
+	if o.Filter == nil {
+		return nil
+	}
+	return o.Filter.MatchSerial
+}
+
+// Position returns the values of After and AfterValue resuming a read after the serialized document serial
+func (o Options) Position(serial []byte) (after string, afterValue json.RawMessage) {
+	parsed, _ := parse(serial)
+	if o.Sort != nil {
+		afterValue = o.Sort.valueOf(parsed.Doc)
+	}
+	return path.Base(parsed.Path), afterValue
+}
+
+// serialized is the parsed form of a serialized document
+type serialized struct {
+	Path string             `json:"path"`
+	Doc  jsondata.JSONValue `json:"doc"`
+	Meta json.RawMessage    `json:"meta"`
+}
+
+// parse parses a serialized document
+func parse(serial []byte) (serialized, error) {
+	var parsed serialized
+	err := json.Unmarshal(serial, &parsed)
+	return parsed, err
+}
+
+// entry is a document kept by a read
+type entry struct {
+	key    string          // the name of the document
+	serial json.RawMessage // the serialized document
+	sort   sortKey         // the key the document is sorted by, if the read is sorted
+}
+
+// Page accumulates the result of a collection read, fed with documents in the order of their names
+type Page struct {
+	opts    Options // the options of the read
+	entries []entry // the documents kept so far
+}
+
+// NewPage starts the result of a read with options o
+func (o Options) NewPage() *Page {
+	return &Page{opts: o}
+}
+
+// Add offers the document named key, serialized as serial, to the page.
+// Returns true once the page is full, after which no more documents need to be offered
+func (p *Page) Add(key string, serial []byte) (full bool) {
+	o := p.opts
+	if o.Sort == nil && o.After != "" && key <= o.After {
+		return false
+	}
+	e := entry{key: key, serial: serial}
+	if o.Filter != nil || o.Sort != nil {
+		parsed, err := parse(serial)
+		if err != nil || (o.Filter != nil && !o.Filter.Match(parsed.Doc)) {
+			return false
+		}
+		if o.Sort != nil {
+			e.sort = keyOf(lookup(parsed.Doc, o.Sort.field))
+		}
+	}
+	p.entries = append(p.entries, e)
+	//a sorted page only knows which documents come first once it has seen all of them
+	return o.Sort == nil && o.Limit > 0 && len(p.entries) >= o.Limit
+}
+
+// Marshal returns the page as a JSON array of serialized documents
+func (p *Page) Marshal() []byte {
+	o := p.opts
+	entries := p.entries
+	if o.Sort != nil {
+		slices.SortFunc(entries, func(a entry, b entry) int {
+			return o.Sort.compare(a.sort, a.key, b.sort, b.key)
+		})
+		if o.After != "" {
+			after := keyOfRaw(o.AfterValue)
+			entries = slices.DeleteFunc(entries, func(e entry) bool {
+				return o.Sort.compare(e.sort, e.key, after, o.After) <= 0
+			})
+		}
+		if o.Limit > 0 && len(entries) > o.Limit {
+			entries = entries[:o.Limit]
+		}
+	}
+	docs := make([]json.RawMessage, len(entries))
+	for i, e := range entries {
+		docs[i] = e.serial
+	}
+	res, _ := json.Marshal(docs)
+	return res
+}
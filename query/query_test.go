@@ -0,0 +1,114 @@
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+// names returns the names of the documents in a marshaled page
+func names(t *testing.T, page []byte) []string {
+	var docs []struct {
+		Path string `json:"path"`
+	}
+	if err := json.Unmarshal(page, &docs); err != nil {
+		t.Fatalf("bad page %s", page)
+	}
+	res := []string{}
+	for _, doc := range docs {
+		res = append(res, doc.Path[1:])
+	}
+	return res
+}
+
+// sortDocs are the documents sorted in TestSort, in the order of their names
+var sortDocs = []struct {
+	key string
+	doc string
+}{
+	{"a", `{"n":3}`},
+	{"b", `{"n":"x"}`},
+	{"c", `{}`},
+	{"d", `{"n":1}`},
+	{"e", `{"n":3}`},
+	{"f", `{"n":null}`},
+}
+
+// readSorted runs a read with opts over sortDocs
+func readSorted(opts Options) []byte {
+	page := opts.NewPage()
+	for _, d := range sortDocs {
+		if page.Add(d.key, []byte(fmt.Sprintf(`{"path":"/%s","doc":%s,"meta":{}}`, d.key, d.doc))) {
+			break
+		}
+	}
+	return page.Marshal()
+}
+
+func TestSort(t *testing.T) {
+	asc, _ := ParseSort("/n")
+	desc, _ := ParseSort("/n:desc")
+	tests := []struct {
+		opts Options
+		want string
+	}{
+		{Options{Sort: asc}, "[f d a e b c]"},
+		{Options{Sort: desc}, "[b a e d f c]"},
+		{Options{Sort: asc, Limit: 3}, "[f d a]"},
+		{Options{Sort: asc, Limit: 2, After: "a", AfterValue: json.RawMessage(`3`)}, "[e b]"},
+		{Options{Sort: desc, After: "d", AfterValue: json.RawMessage(`1`)}, "[f c]"},
+		{Options{Sort: asc, After: "c"}, "[]"},
+	}
+	for _, test := range tests {
+		if got := fmt.Sprint(names(t, readSorted(test.opts))); got != test.want {
+			t.Errorf("TestSort failed, expected %s for %+v, got %s", test.want, test.opts, got)
+		}
+	}
+
+	//the position of a document resumes the read right after it
+	after, value := Options{Sort: asc}.Position([]byte(`{"path":"/x/y/a","doc":{"n":3},"meta":{}}`))
+	if after != "a" || string(value) != "3" {
+		t.Errorf("TestSort failed, unexpected position %s %s", after, value)
+	}
+
+	for _, spec := range []string{"", "n", "/n:sideways"} {
+		if _, err := ParseSort(spec); err == nil {
+			t.Errorf("TestSort failed, expected %q to be rejected", spec)
+		}
+	}
+}
+
+func TestProjection(t *testing.T) {
+	serial := []byte(`{"path":"/a","doc":{"a":1,"b":{"c":2,"d":3},"e":[10,{"f":4,"g":5},30],"h~/":6},"meta":{"createdBy":"u"}}`)
+	tests := []struct {
+		fields string
+		want   string
+	}{
+		{"/a", `{"a":1}`},
+		{"/b/c,/a", `{"a":1,"b":{"c":2}}`},
+		{"/b,/b/c", `{"b":{"c":2,"d":3}}`},
+		{"/e/2,/e/1/g", `{"e":[{"g":5},30]}`},
+		{"/h~0~1", `{"h~/":6}`},
+		{"/missing,/a/deeper", `{}`},
+	}
+	for _, test := range tests {
+		p, err := ParseFields(test.fields)
+		if err != nil {
+			t.Fatalf("TestProjection failed, unable to parse %s: %s", test.fields, err.Error())
+		}
+		var got struct {
+			Path string          `json:"path"`
+			Doc  json.RawMessage `json:"doc"`
+			Meta json.RawMessage `json:"meta"`
+		}
+		json.Unmarshal(p.Apply(serial), &got)
+		if string(got.Doc) != test.want || got.Path != "/a" || string(got.Meta) != `{"createdBy":"u"}` {
+			t.Errorf("TestProjection failed, expected %s for %s, got %+v", test.want, test.fields, got)
+		}
+	}
+	for _, spec := range []string{"", "a", "/a,b", "/a,"} {
+		if _, err := ParseFields(spec); err == nil {
+			t.Errorf("TestProjection failed, expected %q to be rejected", spec)
+		}
+	}
+}
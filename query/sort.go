@@ -0,0 +1,131 @@
+package query
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/jsondata"
+)
+
+// Sort orders the documents of a collection read by a value inside their contents instead of by name. Values of
+// different types order as null < booleans < numbers < strings < arrays < objects; documents lacking the value come
+// last in either direction, and ties are broken by document name.
+type Sort struct {
+	field []string // the segments of the JSON pointer to the value sorted by
+	desc  bool     // set to sort in descending order
+}
+
+// ParseSort parses a sort specification: a JSON pointer, optionally followed by :asc or :desc.
+// Returns the sort, or an error if the specification is malformed
+func ParseSort(spec string) (*Sort, error) {
+	ptr, dir, _ := strings.Cut(spec, ":")
+	field, err := parsePointer(ptr)
+	if err != nil || field == nil {
+		return nil, fmt.Errorf("malformed sort parameter: expected a JSON pointer")
+	}
+	switch dir {
+	case "", "asc":
+		return &Sort{field: field}, nil
+	case "desc":
+		return &Sort{field: field, desc: true}, nil
+	}
+	return nil, fmt.Errorf("malformed sort parameter: unknown direction '%s'", dir)
+}
+
+// sortKey is the comparable form of the value a document is sorted by
+type sortKey struct {
+	rank int     // the rank of the value's type; rankMissing if the document lacks the value
+	num  float64 // the value of numbers, and 0 or 1 for booleans
+	str  string  // the value of strings
+}
+
+// The ranks of the types of values sorted by
+const (
+	rankNull = iota
+	rankBool
+	rankNumber
+	rankString
+	rankArray
+	rankObject
+	rankMissing
+)
+
+// keyOf returns the sort key of a value, nil meaning the value is missing
+func keyOf(value *jsondata.JSONValue) sortKey {
+	if value == nil {
+		return sortKey{rank: rankMissing}
+	}
+	key, err := jsondata.Accept[sortKey](*value, sortKeyVisitor{})
+	if err != nil {
+		return sortKey{rank: rankMissing}
+	}
+	return key
+}
+
+// compare orders two documents, given their sort keys and names
+func (s *Sort) compare(a sortKey, aName string, b sortKey, bName string) int {
+	var res int
+	if a.rank == rankMissing || b.rank == rankMissing {
+		res = cmp.Compare(a.rank, b.rank) //missing values come last in either direction
+	} else {
+		res = cmp.Or(cmp.Compare(a.rank, b.rank), cmp.Compare(a.num, b.num), cmp.Compare(a.str, b.str))
+		if s.desc {
+			res = -res
+		}
+	}
+	return cmp.Or(res, cmp.Compare(aName, bName))
+}
+
+// valueOf returns the value a document is sorted by, encoded as JSON, or nil if the document lacks it
+func (s *Sort) valueOf(doc jsondata.JSONValue) json.RawMessage {
+	value := lookup(doc, s.field)
+	if value == nil {
+		return nil
+	}
+	raw, _ := json.Marshal(*value)
+	return raw
+}
+
+// keyOfRaw returns the sort key of a value encoded as JSON, nil meaning the value is missing
+func keyOfRaw(raw json.RawMessage) sortKey {
+	if raw == nil {
+		return sortKey{rank: rankMissing}
+	}
+	var value jsondata.JSONValue
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return sortKey{rank: rankMissing}
+	}
+	return keyOf(&value)
+}
+
+// sortKeyVisitor computes the sort key of a value
+type sortKeyVisitor struct{}
+
+func (sortKeyVisitor) Map(map[string]jsondata.JSONValue) (sortKey, error) {
+	return sortKey{rank: rankObject}, nil
+}
+
+func (sortKeyVisitor) Slice([]jsondata.JSONValue) (sortKey, error) {
+	return sortKey{rank: rankArray}, nil
+}
+
+func (sortKeyVisitor) Bool(b bool) (sortKey, error) {
+	if b {
+		return sortKey{rank: rankBool, num: 1}, nil
+	}
+	return sortKey{rank: rankBool}, nil
+}
+
+func (sortKeyVisitor) Float64(f float64) (sortKey, error) {
+	return sortKey{rank: rankNumber, num: f}, nil
+}
+
+func (sortKeyVisitor) String(s string) (sortKey, error) {
+	return sortKey{rank: rankString, str: s}, nil
+}
+
+func (sortKeyVisitor) Null() (sortKey, error) {
+	return sortKey{rank: rankNull}, nil
+}
@@ -0,0 +1,159 @@
+package resourceCreatorService
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/fieldIndex"
+)
+
+// importEntry is a single line of a JSONL dump: a document (path, doc and meta) or, if its path ends in a slash,
+// a collection along with its secondary indices
+type importEntry struct {
+	Path    string                  `json:"path"`
+	Doc     json.RawMessage         `json:"doc,omitempty"`
+	Meta    json.RawMessage         `json:"meta,omitempty"`
+	Indexes []fieldIndex.Definition `json:"indexes,omitempty"`
+
+	line  int    // the line of the dump the entry was read from
+	raw   []byte // the entry as it appears in the dump
+	depth int    // the number of segments in the entry's path
+}
+
+// ImportDB replaces the contents of the database dbName (creating it if needed) with the entries of dump, a JSONL
+// dump as produced by an export. Entries may appear in any order, and the collection holding a document is created
+// if it is not listed. The new tree is built aside and swapped in at once, so readers see either the old database
+// or the complete import, and a malformed dump leaves the database untouched. The import is made on behalf of user.
+// Returns a JSON-encoded response, a status code and the URI of the database
+func (rcs *ResourceCreatorService[K, T]) ImportDB(dbName string, dump []byte, user string) ([]byte, int, string) {
+	entries, err := rcs.parseDump(dump)
+	if err != nil {
+		errmsg, _ := json.Marshal(err.Error())
+		return errmsg, http.StatusBadRequest, ""
+	}
+
+	newDB := rcs.dbfactory(dbName)
+	for _, entry := range entries {
+		relPath := strings.TrimPrefix(entry.Path, "/")
+		var resp []byte
+		stat := http.StatusCreated
+		if relPath == "" { //the top-level collection exists along with the database
+			continue
+		} else if strings.HasSuffix(relPath, "/") {
+			resp, stat = newDB.RestoreCollection(strings.TrimSuffix(relPath, "/"))
+		} else {
+			if entry.depth > 1 {
+				resp, stat = newDB.RestoreCollection(relPath[:strings.LastIndex(relPath, "/")])
+			}
+			if stat < 300 {
+				resp, stat = newDB.RestoreDocument(relPath, entry.raw)
+			}
+		}
+		if stat >= 300 {
+			return importError(entry, resp), http.StatusBadRequest, ""
+		}
+	}
+	//indices are built once every document is in, so that unique ones reject a dump holding duplicates
+	for _, entry := range entries {
+		for _, def := range entry.Indexes {
+			if resp, stat := newDB.RestoreIndex(strings.TrimSuffix(strings.TrimPrefix(entry.Path, "/"), "/"), def); stat >= 300 {
+				return importError(entry, resp), http.StatusBadRequest, ""
+			}
+		}
+	}
+
+	//the journal keeps the import as a single record so that a crash never leaves it half-applied
+	var journaled bytes.Buffer
+	journaled.WriteByte('[')
+	for i, entry := range entries {
+		if i > 0 {
+			journaled.WriteByte(',')
+		}
+		journaled.Write(entry.raw)
+	}
+	journaled.WriteByte(']')
+
+	var replaced T
+	var didReplace bool
+	check := func(key K, curVal T, exists bool) (T, error) {
+		replaced, didReplace = curVal, exists
+		if err := rcs.journal.RecordImportDB(dbName, journaled.Bytes(), user); err != nil {
+			return curVal, err
+		}
+		return newDB, nil
+	}
+	if _, err := rcs.dbs.Upsert(K(dbName), check); err != nil {
+		errmsg, _ := json.Marshal("unable to persist the change: " + err.Error())
+		return errmsg, http.StatusInternalServerError, ""
+	}
+	if didReplace {
+		replaced.NotifyAll("/")
+	}
+
+	resp, _ := json.Marshal(struct {
+		Uri string `json:"uri"`
+	}{Uri: "/v1/" + dbName})
+	return resp, http.StatusCreated, "/v1/" + dbName
+}
+
+// importError builds the error reported when entry cannot be imported, resp being the JSON-encoded reason.
+// Returns the JSON-encoded error
+func importError(entry importEntry, resp []byte) []byte {
+	var reason string
+	json.Unmarshal(resp, &reason)
+	errmsg, _ := json.Marshal(fmt.Sprintf("line %d: unable to import %s: %s", entry.line, entry.Path, reason))
+	return errmsg
+}
+
+// parseDump decodes and validates every entry of a JSONL dump, ordering them so that parents precede their children.
+// Returns the entries, or an error naming the first malformed line
+func (rcs *ResourceCreatorService[K, T]) parseDump(dump []byte) ([]importEntry, error) {
+	var entries []importEntry
+	scanner := bufio.NewScanner(bytes.NewReader(dump))
+	scanner.Buffer(nil, len(dump)+1)
+	line := 0
+	for scanner.Scan() {
+		line++
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+		entry := importEntry{line: line, raw: slices.Clone(raw)}
+		if err := json.Unmarshal(raw, &entry); err != nil {
+			return nil, fmt.Errorf("line %d: malformed entry", line)
+		}
+		if !strings.HasPrefix(entry.Path, "/") || strings.Contains(entry.Path, "//") {
+			return nil, fmt.Errorf("line %d: bad resource path", line)
+		}
+		relPath := strings.TrimPrefix(entry.Path, "/")
+		isCol := strings.HasSuffix(relPath, "/")
+		entry.depth = len(strings.Split(strings.TrimSuffix(relPath, "/"), "/"))
+		switch {
+		case relPath == "" && len(entry.Indexes) > 0: //the top-level collection, which is only listed for its indexes
+			entry.depth = 0
+		case relPath == "":
+			return nil, fmt.Errorf("line %d: bad resource path", line)
+		case isCol && entry.depth%2 != 0, !isCol && entry.depth%2 != 1:
+			return nil, fmt.Errorf("line %d: bad resource path", line)
+		case !isCol && len(entry.Indexes) > 0:
+			return nil, fmt.Errorf("line %d: only collections have indexes", line)
+		case !isCol && len(entry.Doc) == 0:
+			return nil, fmt.Errorf("line %d: missing doc", line)
+		case !isCol && rcs.validator.Validate(entry.Doc) != nil:
+			return nil, fmt.Errorf("line %d: document does not conform to schema", line)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	slices.SortStableFunc(entries, func(a importEntry, b importEntry) int {
+		return a.depth - b.depth
+	})
+	return entries, nil
+}
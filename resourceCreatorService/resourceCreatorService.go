@@ -10,13 +10,22 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/fieldIndex"
 	"github.com/RICE-COMP318-FALL24/owldb-p1group24/index_utils"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/precondition"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/transaction"
 )
 
 // Upsertdatabaser defines the interface for uploading collections and documents to a database.
 type Upsertdatabaser interface {
-	UploadCol(colpath string, dbName string) ([]byte, int, string)                                                                    // Uploads a collection to the database.
-	UploadDocument(docpath string, payload []byte, docname, user string, overwrite, isPost bool, dbName string) ([]byte, int, string) // Uploads a document to the database.
+	UploadCol(colpath string, dbName string, user string) ([]byte, int, string)                                                                                     // Uploads a collection to the database.
+	UploadDocument(docpath string, payload []byte, docname, user string, overwrite, isPost bool, dbName string, cond precondition.Conditions) ([]byte, int, string) // Uploads a document to the database.
+	RestoreDocument(docpath string, serial []byte) ([]byte, int)                                                                                                    // Recreates a serialized document, metadata included.
+	RestoreCollection(colpath string) ([]byte, int)                                                                                                                 // Recreates a collection if it does not exist.
+	UploadIndex(colpath string, def fieldIndex.Definition, dbName string) ([]byte, int, string)                                                                     // Adds a secondary index to a collection.
+	RestoreIndex(colpath string, def fieldIndex.Definition) ([]byte, int)                                                                                           // Recreates a secondary index if it does not exist.
+	NotifyAll(colname string)                                                                                                                                       // Notifies every subscriber that the database was replaced.
+	Transact(ops []transaction.Operation, user string) ([]byte, int)                                                                                                // Applies the operations of a transaction as a whole.
 }
 
 // DatabaseIndex describes the necessary behaviors for the underlying container of the databases themselves
@@ -30,6 +39,14 @@ type ResourceCreatorService[K string, T Upsertdatabaser] struct {
 	dbs       DatabaseIndex[K, T] // The collection of databases.
 	dbfactory DBFactory[T]        // A factory function for creating new databases.
 	validator Validator           // Validates the schema of documents before uploading.
+	journal   Journal             // Records the creation of databases.
+}
+
+// Journal records every database created, so that it can be recreated after a restart. A database the journal fails
+// to record is not created.
+type Journal interface {
+	RecordCreateDB(dbName string, user string) error                 // Records the creation of a database by user.
+	RecordImportDB(dbName string, entries []byte, user string) error // Records the import of a database by user, entries being a JSON array.
 }
 
 // Validator defines an interface for validating JSON data against a schema.
@@ -38,13 +55,26 @@ type Validator interface {
 }
 
 // New creates a new instance of a ResourceCreatorService. Note that the arguments passed in must themselves be initialized properly to ensure correct behavior
-func New[K string, T Upsertdatabaser](dbs DatabaseIndex[K, T], dbfactory DBFactory[T], validator Validator) *ResourceCreatorService[K, T] {
-	return &ResourceCreatorService[K, T]{dbs: dbs, dbfactory: dbfactory, validator: validator}
+func New[K string, T Upsertdatabaser](dbs DatabaseIndex[K, T], dbfactory DBFactory[T], validator Validator, journal Journal) *ResourceCreatorService[K, T] {
+	return &ResourceCreatorService[K, T]{dbs: dbs, dbfactory: dbfactory, validator: validator, journal: journal}
 }
 
-// PutCol will put a collection at the database dtb with collection path colpath.
+// PutCol will put a collection at the database dtb with collection path colpath, on behalf of user.
 // It returns a JSON-encoded response and a status code indicating success or failure.
-func (rcs *ResourceCreatorService[K, T]) PutCol(dtb string, colpath string) ([]byte, int, string) {
+func (rcs *ResourceCreatorService[K, T]) PutCol(dtb string, colpath string, user string) ([]byte, int, string) {
+	db, found := rcs.dbs.Find(K(dtb))
+
+	if !found {
+		errmsg, _ := json.Marshal("Error: no such database exists")
+		return errmsg, http.StatusNotFound, ""
+	}
+
+	return db.UploadCol(colpath, dtb, user)
+}
+
+// PutIndex will create the secondary index described by def over the collection at path colpath, in the database dtb.
+// It returns a JSON-encoded response, a status code and the URI of the index.
+func (rcs *ResourceCreatorService[K, T]) PutIndex(dtb string, colpath string, def fieldIndex.Definition) ([]byte, int, string) {
 	db, found := rcs.dbs.Find(K(dtb))
 
 	if !found {
@@ -52,24 +82,32 @@ func (rcs *ResourceCreatorService[K, T]) PutCol(dtb string, colpath string) ([]b
 		return errmsg, http.StatusNotFound, ""
 	}
 
-	return db.UploadCol(colpath, dtb)
+	return db.UploadIndex(colpath, def, dtb)
 }
 
 // DBFactory is a factory function for creating new databases.
 // It returns a JSON-encoded response and a status code indicating success or failure.
 type DBFactory[T Upsertdatabaser] func(string) T
 
-// CreateDB creates a database with name dbName
-func (rcs *ResourceCreatorService[K, T]) CreateDB(dbName string) ([]byte, int, string) {
+// CreateDB creates a database with name dbName on behalf of user
+func (rcs *ResourceCreatorService[K, T]) CreateDB(dbName string, user string) ([]byte, int, string) {
 	var nullDB T
+	var journalErr error
 	check := func(key K, curVal T, exists bool) (newVal T, err error) {
 		if exists {
 			return nullDB, fmt.Errorf("database with that name exists")
 		}
+		if journalErr = rcs.journal.RecordCreateDB(string(key), user); journalErr != nil {
+			return nullDB, journalErr
+		}
 		return rcs.dbfactory(string(key)), nil
 	}
 
 	_, err := rcs.dbs.Upsert(K(dbName), check)
+	if journalErr != nil {
+		errmsg, _ := json.Marshal("unable to persist the change: " + journalErr.Error())
+		return errmsg, http.StatusInternalServerError, ""
+	}
 	if err != nil {
 		errmsg, _ := json.Marshal(err.Error())
 		return errmsg, http.StatusBadRequest, ""
@@ -122,12 +160,12 @@ func (rcs *ResourceCreatorService[K, T]) PostDoc(dbName string, colpath string,
 	}
 	slog.Debug(fmt.Sprintf("PostDoc: the path for this document is %s", docPath))
 
-	return db.UploadDocument(docPath, payload, docName, user, true, true, string(dbName))
+	return db.UploadDocument(docPath, payload, docName, user, true, true, string(dbName), precondition.Conditions{})
 }
 
-// PutDoc puts a document in the database
+// PutDoc puts a document in the database, provided the document it replaces, if any, satisfies the conditions cond
 // It returns a JSON-encoded response and a status code indicating success or failure.
-func (rcs *ResourceCreatorService[K, T]) PutDoc(dbName K, docpath string, docname string, payload []byte, overwrite bool, user string) ([]byte, int, string) {
+func (rcs *ResourceCreatorService[K, T]) PutDoc(dbName K, docpath string, docname string, payload []byte, overwrite bool, user string, cond precondition.Conditions) ([]byte, int, string) {
 	db, found := rcs.dbs.Find(dbName)
 	if !found {
 		errmsg, _ := json.Marshal("Collection does not exist")
@@ -141,5 +179,17 @@ func (rcs *ResourceCreatorService[K, T]) PutDoc(dbName K, docpath string, docnam
 		return errmsg, http.StatusBadRequest, ""
 	}
 	slog.Debug(fmt.Sprintf("calling PutDoc with the following params: overwrite %t", overwrite))
-	return db.UploadDocument(docpath, payload, docname, user, overwrite, false, string(dbName))
+	return db.UploadDocument(docpath, payload, docname, user, overwrite, false, string(dbName), cond)
+}
+
+// RestoreDoc recreates the document at docpath in the database dbName from its serialized form, keeping
+// the metadata it was serialized with. It is used to rebuild documents from durable storage.
+// It returns a JSON-encoded response and a status code indicating success or failure.
+func (rcs *ResourceCreatorService[K, T]) RestoreDoc(dbName string, docpath string, serial []byte) ([]byte, int) {
+	db, found := rcs.dbs.Find(K(dbName))
+	if !found {
+		errmsg, _ := json.Marshal("Error: no such database exists")
+		return errmsg, http.StatusNotFound
+	}
+	return db.RestoreDocument(docpath, serial)
 }
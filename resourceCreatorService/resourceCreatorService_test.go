@@ -6,17 +6,23 @@ import (
 	"net/http"
 	"testing"
 
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/fieldIndex"
 	"github.com/RICE-COMP318-FALL24/owldb-p1group24/index_utils"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/precondition"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/transaction"
 )
 
 // Mocking Upsertdatabaser
 type upserterDBMock struct {
 	uploadColErr      error
 	uploadDocumentErr error
+	restored          []string // the paths restored, collections ending in a slash
+	notified          bool     // set once NotifyAll is called
+	transacted        int      // the number of operations of the last transaction applied
 }
 
 // UploadCol mocks the behavior of uploading a collection. Returns an error status if uploadColErr is set.
-func (mock *upserterDBMock) UploadCol(colpath string, dbName string) ([]byte, int, string) {
+func (mock *upserterDBMock) UploadCol(colpath string, dbName string, user string) ([]byte, int, string) {
 	if mock.uploadColErr != nil {
 		return nil, http.StatusInternalServerError, ""
 	}
@@ -24,13 +30,47 @@ func (mock *upserterDBMock) UploadCol(colpath string, dbName string) ([]byte, in
 }
 
 // UploadDocument mocks the behavior of uploading a document. Returns an error status if uploadDocumentErr is set.
-func (mock *upserterDBMock) UploadDocument(docpath string, payload []byte, docname, user string, overwrite, isPost bool, dbName string) ([]byte, int, string) {
+func (mock *upserterDBMock) UploadDocument(docpath string, payload []byte, docname, user string, overwrite, isPost bool, dbName string, cond precondition.Conditions) ([]byte, int, string) {
 	if mock.uploadDocumentErr != nil {
 		return nil, http.StatusInternalServerError, ""
 	}
 	return []byte(docpath), http.StatusOK, ""
 }
 
+// RestoreDocument mocks the behavior of restoring a serialized document.
+func (mock *upserterDBMock) RestoreDocument(docpath string, serial []byte) ([]byte, int) {
+	mock.restored = append(mock.restored, docpath)
+	return nil, http.StatusCreated
+}
+
+// RestoreCollection mocks the behavior of restoring a collection.
+func (mock *upserterDBMock) RestoreCollection(colpath string) ([]byte, int) {
+	mock.restored = append(mock.restored, colpath+"/")
+	return nil, http.StatusCreated
+}
+
+// UploadIndex mocks the behavior of adding a secondary index.
+func (mock *upserterDBMock) UploadIndex(colpath string, def fieldIndex.Definition, dbName string) ([]byte, int, string) {
+	return nil, http.StatusCreated, "/v1/" + dbName + "/" + colpath + "/?index=" + def.Field
+}
+
+// RestoreIndex mocks the behavior of restoring a secondary index.
+func (mock *upserterDBMock) RestoreIndex(colpath string, def fieldIndex.Definition) ([]byte, int) {
+	mock.restored = append(mock.restored, colpath+"/?index="+def.Field)
+	return nil, http.StatusCreated
+}
+
+// NotifyAll mocks notifying the subscribers of a database.
+func (mock *upserterDBMock) NotifyAll(colname string) {
+	mock.notified = true
+}
+
+// Transact mocks the behavior of applying a transaction.
+func (mock *upserterDBMock) Transact(ops []transaction.Operation, user string) ([]byte, int) {
+	mock.transacted = len(ops)
+	return []byte("[]"), http.StatusOK
+}
+
 // Mocking DatabaseIndex
 type dbIndexMock struct {
 	findFunc   func(key string) (Upsertdatabaser, bool)
@@ -66,9 +106,9 @@ func TestCreateDB(t *testing.T) {
 	}
 	service := New(mockDBIndex, func(string) Upsertdatabaser {
 		return &upserterDBMock{}
-	}, &validatorMock{})
+	}, &validatorMock{}, &mocks.MockJournal{})
 
-	result, statusCode, _ := service.CreateDB("Neyida's DB")
+	result, statusCode, _ := service.CreateDB("Neyida's DB", "user")
 	fmt.Println("CreateDB result:", string(result), "Status code:", statusCode)
 
 }
@@ -84,10 +124,10 @@ func TestPutDoc(t *testing.T) {
 	}
 
 	validator := &validatorMock{}
-	service := New[string, Upsertdatabaser](mockDBIndex, nil, validator)
+	service := New[string, Upsertdatabaser](mockDBIndex, nil, validator, &mocks.MockJournal{})
 
 	payload := []byte(`{"name": "John Doe"}`)
-	result, statusCode, _ := service.PutDoc("testDB", "testPath", "testDoc", payload, true, "user")
+	result, statusCode, _ := service.PutDoc("testDB", "testPath", "testDoc", payload, true, "user", precondition.Conditions{})
 
 	//if it passes
 	if statusCode != http.StatusOK {
@@ -101,7 +141,7 @@ func TestPutDoc(t *testing.T) {
 	mockDBIndex.findFunc = func(key string) (Upsertdatabaser, bool) {
 		return nil, false // Simulate that the database is not found
 	}
-	result, statusCode, _ = service.PutDoc("missingDB", "testPath", "testDoc", payload, true, "user")
+	result, statusCode, _ = service.PutDoc("missingDB", "testPath", "testDoc", payload, true, "user", precondition.Conditions{})
 	if statusCode != http.StatusNotFound {
 		t.Errorf("Expected status code: %d, got: %d", http.StatusNotFound, statusCode)
 	}
@@ -119,10 +159,10 @@ func TestPostDoc(t *testing.T) {
 			return mockDB, true // Simulate that the database is found
 		},
 	}
-	service := New[string, Upsertdatabaser](mockDBIndex, nil, nil)
+	service := New[string, Upsertdatabaser](mockDBIndex, nil, nil, &mocks.MockJournal{})
 
 	// Test successful PutCol
-	result, statusCode, _ := service.PutCol("testDB", "testCollection")
+	result, statusCode, _ := service.PutCol("testDB", "testCollection", "user")
 
 	if statusCode != http.StatusOK {
 		t.Errorf("Expected status code: %d, got: %d", http.StatusOK, statusCode)
@@ -141,10 +181,10 @@ func TestPutCol(t *testing.T) {
 			return mockDB, true // Simulate that the database is found
 		},
 	}
-	service := New[string, Upsertdatabaser](mockDBIndex, nil, nil)
+	service := New[string, Upsertdatabaser](mockDBIndex, nil, nil, &mocks.MockJournal{})
 
 	// Test successful PutCol
-	result, statusCode, _ := service.PutCol("testDB", "testCollection")
+	result, statusCode, _ := service.PutCol("testDB", "testCollection", "user")
 
 	if statusCode != http.StatusOK {
 		t.Errorf("Expected status code: %d, got: %d", http.StatusOK, statusCode)
@@ -157,7 +197,7 @@ func TestPutCol(t *testing.T) {
 	mockDBIndex.findFunc = func(key string) (Upsertdatabaser, bool) {
 		return nil, false // Simulate that the database is not found
 	}
-	result, statusCode, _ = service.PutCol("missingDB", "testCollection")
+	result, statusCode, _ = service.PutCol("missingDB", "testCollection", "user")
 
 	if statusCode != http.StatusNotFound {
 		t.Errorf("Expected status code: %d, got: %d", http.StatusNotFound, statusCode)
@@ -167,6 +207,22 @@ func TestPutCol(t *testing.T) {
 	}
 }
 
+func TestPutIndex(t *testing.T) {
+	mockDBIndex := &dbIndexMock{
+		findFunc: func(key string) (Upsertdatabaser, bool) {
+			return &upserterDBMock{}, key == "testDB"
+		},
+	}
+	service := New[string, Upsertdatabaser](mockDBIndex, nil, nil, &mocks.MockJournal{})
+
+	if _, statusCode, uri := service.PutIndex("testDB", "doc/col", fieldIndex.Definition{Field: "/email"}); statusCode != http.StatusCreated || uri != "/v1/testDB/doc/col/?index=/email" {
+		t.Errorf("Expected the index to be created, got: %d and %s", statusCode, uri)
+	}
+	if _, statusCode, _ := service.PutIndex("missingDB", "doc/col", fieldIndex.Definition{Field: "/email"}); statusCode != http.StatusNotFound {
+		t.Errorf("Expected status code: %d, got: %d", http.StatusNotFound, statusCode)
+	}
+}
+
 type mockValidator struct {
 }
 
@@ -177,7 +233,7 @@ func (m mockValidator) Validate([]byte) error {
 func TestResourceCreatorService_PostDoc(t *testing.T) {
 
 	mockDBIndex := mocks.NewMockSL[string, Upsertdatabaser]()
-	service := New[string, Upsertdatabaser](mockDBIndex, nil, mockValidator{})
+	service := New[string, Upsertdatabaser](mockDBIndex, nil, mockValidator{}, &mocks.MockJournal{})
 
 	// Test successful PutCol
 	check := func(string, Upsertdatabaser, bool) (Upsertdatabaser, error) {
@@ -187,3 +243,70 @@ func TestResourceCreatorService_PostDoc(t *testing.T) {
 
 	service.PostDoc("db", "doc1/col1", "user", []byte("payload"))
 }
+
+func TestImportDB(t *testing.T) {
+	mockDBIndex := mocks.NewMockSL[string, Upsertdatabaser]()
+	journal := &mocks.MockJournal{}
+	var created []*upserterDBMock
+	service := New[string, Upsertdatabaser](mockDBIndex, func(string) Upsertdatabaser {
+		db := &upserterDBMock{}
+		created = append(created, db)
+		return db
+	}, mockValidator{}, journal)
+
+	dump := `{"path":"/a/c/x","doc":{},"meta":{}}` + "\n\n" + `{"path":"/a","doc":{}}` + "\n" + `{"path":"/b/e/","indexes":[{"field":"/email"}]}` + "\n"
+	if _, stat, uri := service.ImportDB("db", []byte(dump), "user"); stat != http.StatusCreated || uri != "/v1/db" {
+		t.Fatalf("TestImportDB failed, expected 201 and /v1/db, got %d and %s", stat, uri)
+	}
+	want := fmt.Sprint([]string{"a", "b/e/", "a/c/", "a/c/x", "b/e/?index=/email"})
+	if got := fmt.Sprint(created[0].restored); got != want {
+		t.Errorf("TestImportDB failed, expected parents to be restored first and indices last %s, got %s", want, got)
+	}
+	if len(journal.Ops) != 1 || journal.Ops[0] != "importdb db/" {
+		t.Errorf("TestImportDB failed, expected the import to be journaled once, got %v", journal.Ops)
+	}
+
+	//importing again replaces the database and notifies its subscribers
+	service.ImportDB("db", []byte(`{"path":"/a","doc":{}}`), "user")
+	if found, _ := mockDBIndex.Find("db"); !created[0].notified || found != created[1] {
+		t.Errorf("TestImportDB failed, expected the database to be replaced")
+	}
+
+	//a malformed dump is rejected before anything is created
+	for _, bad := range []string{`{"path":"/a"}`, `{"path":"a","doc":{}}`, `{"path":"/a/c","doc":{}}`, `{"path":"/a/"}`, `{"path":"/a","doc":{},"indexes":[{"field":"/x"}]}`, `not json`} {
+		if _, stat, _ := service.ImportDB("db", []byte(bad), "user"); stat != http.StatusBadRequest {
+			t.Errorf("TestImportDB failed, expected 400 for %s, got %d", bad, stat)
+		}
+	}
+	if len(created) != 2 || len(journal.Ops) != 2 {
+		t.Errorf("TestImportDB failed, expected malformed dumps to leave the database untouched")
+	}
+}
+
+func TestTransact(t *testing.T) {
+	mockDB := &upserterDBMock{}
+	mockDBIndex := &dbIndexMock{
+		findFunc: func(key string) (Upsertdatabaser, bool) {
+			return mockDB, key == "testDB"
+		},
+	}
+	validator := &validatorMock{}
+	service := New[string, Upsertdatabaser](mockDBIndex, nil, validator, &mocks.MockJournal{})
+
+	body := []byte(`[{"op":"put","path":"/a","doc":{}},{"op":"delete","path":"/b"}]`)
+	if _, statusCode := service.Transact("testDB", body, "user"); statusCode != http.StatusOK || mockDB.transacted != 2 {
+		t.Errorf("Expected the transaction to be applied, got: %d", statusCode)
+	}
+	if _, statusCode := service.Transact("missingDB", body, "user"); statusCode != http.StatusNotFound {
+		t.Errorf("Expected status code: %d, got: %d", http.StatusNotFound, statusCode)
+	}
+	if _, statusCode := service.Transact("testDB", []byte(`[{"op":"put","path":"/a/b"}]`), "user"); statusCode != http.StatusBadRequest {
+		t.Errorf("Expected a malformed transaction to be rejected, got: %d", statusCode)
+	}
+
+	mockDB.transacted = 0
+	validator.validateErr = fmt.Errorf("invalid")
+	if _, statusCode := service.Transact("testDB", body, "user"); statusCode != http.StatusBadRequest || mockDB.transacted != 0 {
+		t.Errorf("Expected an invalid document to be rejected before anything is applied, got: %d", statusCode)
+	}
+}
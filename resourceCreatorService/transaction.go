@@ -0,0 +1,37 @@
+package resourceCreatorService
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/transaction"
+)
+
+// Transact applies the transaction held in body, a JSON array of put, patch and delete operations, to the database
+// dbName as a whole: either every operation applies or none does. The documents written by puts are validated before
+// anything is applied.
+// Returns a JSON-encoded response holding the outcome of every operation, or the error that aborted the transaction,
+// and a status code
+func (rcs *ResourceCreatorService[K, T]) Transact(dbName string, body []byte, user string) ([]byte, int) {
+	db, found := rcs.dbs.Find(K(dbName))
+	if !found {
+		errmsg, _ := json.Marshal("Error: no such database exists")
+		return errmsg, http.StatusNotFound
+	}
+	ops, err := transaction.Parse(body)
+	if err != nil {
+		errmsg, _ := json.Marshal(err.Error())
+		return errmsg, http.StatusBadRequest
+	}
+	for i, op := range ops {
+		if op.Op != transaction.OpPut {
+			continue
+		}
+		if err = rcs.validator.Validate(op.Doc); err != nil {
+			errmsg, _ := json.Marshal(fmt.Sprintf("operation %d: Malformed document, document does not conform to schema for reason", i))
+			return errmsg, http.StatusBadRequest
+		}
+	}
+	return db.Transact(ops, user)
+}
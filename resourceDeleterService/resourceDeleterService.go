@@ -5,6 +5,9 @@ import (
 	"encoding/json"
 	"log/slog"
 	"net/http"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/index_utils"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/precondition"
 )
 
 // Notifier is an interface that defines a method for notifying subscribers of changes to a resource.
@@ -15,39 +18,49 @@ type Notifier interface {
 // Deletedatabaser encompasses the behaviors needed for ResourceDeleterService to operate on
 type Deletedatabaser interface {
 	Notifier
-	DeleteDoc(docpath string) ([]byte, int) //DeleteDoc should delete the document at the provided path
-	DeleteCol(colpath string) ([]byte, int) //DeleteCol should delete the collection at the provided path
+	DeleteDoc(docpath string, user string, cond precondition.Conditions) ([]byte, int) //DeleteDoc should delete the document at the provided path on behalf of user, if it satisfies cond
+	DeleteCol(colpath string, user string) ([]byte, int)                               //DeleteCol should delete the collection at the provided path on behalf of user
+	DeleteIndex(colpath string, field string) ([]byte, int)                            //DeleteIndex should drop the secondary index on field from the collection at the provided path
 }
 
 // DatabaseIndex is a generic interface that defines operations for managing databases.
 type DatabaseIndex[K string, V Deletedatabaser] interface {
 	Remove(key K) (removedVal V, removed bool)
+	RemoveIf(key K, check index_utils.RemoveCheck[K, V]) (removedVal V, removed bool, err error)
 	Find(key K) (foundVal V, found bool)
 }
 
+// Journal records every database deleted, so that the deletion survives a restart. A database whose deletion the
+// journal fails to record is not deleted.
+type Journal interface {
+	RecordDeleteDB(dbName string, user string) error // Records the deletion of a database by user
+}
+
 // ResourceDeleterService is responsible for the deletion of resources
 type ResourceDeleterService[K string, V Deletedatabaser] struct {
-	dbs DatabaseIndex[K, V]
+	dbs     DatabaseIndex[K, V]
+	journal Journal
 }
 
 // New creates a new ResourceDeleterService, ready to use as long as the dbs has been initialized.
-func New[K string, T Deletedatabaser](dbs DatabaseIndex[K, T]) *ResourceDeleterService[K, T] {
-	return &ResourceDeleterService[K, T]{dbs: dbs}
+func New[K string, T Deletedatabaser](dbs DatabaseIndex[K, T], journal Journal) *ResourceDeleterService[K, T] {
+	return &ResourceDeleterService[K, T]{dbs: dbs, journal: journal}
 }
 
-// DeleteDoc deletes the document located at the path docpath, under the database dbName
-func (rds *ResourceDeleterService[K, T]) DeleteDoc(dbName string, docpath string) ([]byte, int) {
+// DeleteDoc deletes the document located at the path docpath, under the database dbName, on behalf of user, provided it
+// satisfies the conditions cond
+func (rds *ResourceDeleterService[K, T]) DeleteDoc(dbName string, docpath string, user string, cond precondition.Conditions) ([]byte, int) {
 	dtb, found := rds.dbs.Find(K(dbName))
 
 	if !found {
 		errmsg, _ := json.Marshal("Error: database does not exist")
 		return errmsg, http.StatusNotFound
 	}
-	return dtb.DeleteDoc(docpath)
+	return dtb.DeleteDoc(docpath, user, cond)
 }
 
-// DeleteCol deletes the document loacted at the path colpath, under the database dtb
-func (rds *ResourceDeleterService[K, T]) DeleteCol(dtb string, colpath string) ([]byte, int) {
+// DeleteCol deletes the document loacted at the path colpath, under the database dtb, on behalf of user
+func (rds *ResourceDeleterService[K, T]) DeleteCol(dtb string, colpath string, user string) ([]byte, int) {
 	db, found := rds.dbs.Find(K(dtb))
 
 	if !found {
@@ -55,12 +68,31 @@ func (rds *ResourceDeleterService[K, T]) DeleteCol(dtb string, colpath string) (
 		return errmsg, http.StatusNotFound
 	}
 
-	return db.DeleteCol(colpath)
+	return db.DeleteCol(colpath, user)
 }
 
-// DeleteDB deletes the database named dtb. It returns a json-encoded response, and a status code
-func (rds *ResourceDeleterService[K, T]) DeleteDB(dtb string) ([]byte, int) {
-	db, success := rds.dbs.Remove(K(dtb))
+// DeleteIndex drops the secondary index on the field named by the JSON pointer field from the collection located at the
+// path colpath, under the database dtb
+func (rds *ResourceDeleterService[K, T]) DeleteIndex(dtb string, colpath string, field string) ([]byte, int) {
+	db, found := rds.dbs.Find(K(dtb))
+
+	if !found {
+		errmsg, _ := json.Marshal("Error: no such database exists")
+		return errmsg, http.StatusNotFound
+	}
+
+	return db.DeleteIndex(colpath, field)
+}
+
+// DeleteDB deletes the database named dtb on behalf of user. It returns a json-encoded response, and a status code
+func (rds *ResourceDeleterService[K, T]) DeleteDB(dtb string, user string) ([]byte, int) {
+	db, success, err := rds.dbs.RemoveIf(K(dtb), func(key K, curVal T) error {
+		return rds.journal.RecordDeleteDB(dtb, user)
+	})
+	if err != nil {
+		errmsg, _ := json.Marshal("unable to persist the change: " + err.Error())
+		return errmsg, http.StatusInternalServerError
+	}
 	if success {
 		msg, _ := json.Marshal("Deleted.")
 		slog.Debug("About to notify subscribers that this database is deleted")
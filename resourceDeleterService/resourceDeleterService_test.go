@@ -7,6 +7,7 @@ import (
 	"reflect"
 	"testing"
 
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/precondition"
 	"github.com/RICE-COMP318-FALL24/owldb-p1group24/resourceDeleterService"
 )
 
@@ -18,16 +19,20 @@ type MockDeletedatabaser struct {
 }
 
 // Mock Deletedatabaser to simulate the deletion behavior
-func (m *MockDeletedatabaser) DeleteDoc(docpath string) ([]byte, int) {
+func (m *MockDeletedatabaser) DeleteDoc(docpath string, user string, cond precondition.Conditions) ([]byte, int) {
 	m.deleteDocCalled = true
 	return []byte(`{"success":"Document deleted"}`), http.StatusOK
 }
 
-func (m *MockDeletedatabaser) DeleteCol(colpath string) ([]byte, int) {
+func (m *MockDeletedatabaser) DeleteCol(colpath string, user string) ([]byte, int) {
 	m.deleteColCalled = true
 	return []byte(`{"success":"Collection deleted"}`), http.StatusOK
 }
 
+func (m *MockDeletedatabaser) DeleteIndex(colpath string, field string) ([]byte, int) {
+	return nil, http.StatusNoContent
+}
+
 func (m *MockDeletedatabaser) NotifyAll(colname string) {
 	m.notifyAllCalled = true
 }
@@ -63,13 +68,13 @@ func setupService() *resourceDeleterService.ResourceDeleterService[string, *Mock
 	}
 	dbs.Upsert("db1", chk)
 	// Correct way to instantiate ResourceDeleterService
-	return resourceDeleterService.New[string, *MockDeletedatabaser](dbs)
+	return resourceDeleterService.New[string, *MockDeletedatabaser](dbs, &mocks.MockJournal{})
 }
 
 func TestDeleteDoc_Found(t *testing.T) {
 	service := setupService()
 
-	resp, status := service.DeleteDoc("db1", "/path/to/doc")
+	resp, status := service.DeleteDoc("db1", "/path/to/doc", "user", precondition.Conditions{})
 
 	expectedResp := []byte(`{"success":"Document deleted"}`)
 	expectedStatus := http.StatusOK
@@ -82,7 +87,7 @@ func TestDeleteDoc_Found(t *testing.T) {
 func TestDeleteDoc_NotFound(t *testing.T) {
 	service := setupService()
 
-	resp, status := service.DeleteDoc("db2", "/path/to/doc")
+	resp, status := service.DeleteDoc("db2", "/path/to/doc", "user", precondition.Conditions{})
 
 	expectedResp, _ := json.Marshal("Error: database does not exist")
 	expectedStatus := http.StatusNotFound
@@ -95,7 +100,7 @@ func TestDeleteDoc_NotFound(t *testing.T) {
 func TestDeleteDB_NotFound(t *testing.T) {
 	service := setupService()
 
-	resp, status := service.DeleteDB("db2")
+	resp, status := service.DeleteDB("db2", "user")
 
 	expectedResp, _ := json.Marshal("Error: database does not exist")
 	expectedStatus := http.StatusNotFound
@@ -108,7 +113,7 @@ func TestDeleteDB_NotFound(t *testing.T) {
 func TestDeleteDB_Found(t *testing.T) {
 	service := setupService()
 
-	resp, status := service.DeleteDB("db1")
+	resp, status := service.DeleteDB("db1", "user")
 
 	expectedResp := []byte(`"Deleted."`)
 	expectedStatus := http.StatusNoContent
@@ -121,7 +126,7 @@ func TestDeleteDB_Found(t *testing.T) {
 func TestDeleteCol_Found(t *testing.T) {
 	service := setupService()
 
-	resp, status := service.DeleteCol("db1", "/path/to/col")
+	resp, status := service.DeleteCol("db1", "/path/to/col", "user")
 
 	expectedResp := []byte(`{"success":"Collection deleted"}`)
 	expectedStatus := http.StatusOK
@@ -134,7 +139,7 @@ func TestDeleteCol_Found(t *testing.T) {
 func TestDeleteCol_NotFound(t *testing.T) {
 	service := setupService()
 
-	resp, status := service.DeleteCol("db2", "/path/to/col")
+	resp, status := service.DeleteCol("db2", "/path/to/col", "user")
 
 	expectedResp, _ := json.Marshal("Error: no such database exists")
 	expectedStatus := http.StatusNotFound
@@ -144,6 +149,17 @@ func TestDeleteCol_NotFound(t *testing.T) {
 	}
 }
 
+func TestDeleteIndex(t *testing.T) {
+	service := setupService()
+
+	if _, status := service.DeleteIndex("db1", "doc/col", "/email"); status != http.StatusNoContent {
+		t.Errorf("Expected status: %d, got status: %d", http.StatusNoContent, status)
+	}
+	if _, status := service.DeleteIndex("db2", "doc/col", "/email"); status != http.StatusNotFound {
+		t.Errorf("Expected status: %d, got status: %d", http.StatusNotFound, status)
+	}
+}
+
 //Need to test
 //Delete Database not found
 //Delete Database found
@@ -2,20 +2,33 @@
 package resourceGetterService
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log/slog"
 	"net/http"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/fieldIndex"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/history"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/index_utils"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/query"
 )
 
 type Getdatabaser interface {
-	GetDocumentSerial(docpath string, isSubscribe bool) (payload []byte, subChannel *chan []byte, subId string, statusCode int, docEvent []byte)
-	GetColSerial(colpath string, lo string, hi string, isSubscription bool) (payload []byte, stat_code int, subChan *chan []byte, subId string, docEvents [][]byte)
+	GetDocumentSerial(docpath string, isSubscribe bool, lastEventID string) (payload []byte, subChannel *chan []byte, subId string, statusCode int, docEvent []byte)
+	GetColSerial(colpath string, lo string, hi string, isSubscription bool, opts query.Options, lastEventID string) (payload []byte, stat_code int, subChan *chan []byte, subId string, docEvents [][]byte)
+	GetDocumentHistory(docpath string, sel history.Selector) ([]byte, int)
+	UnsubscribeDoc(docpath string, subId string)
+	UnsubscribeCol(colpath string, subId string)
+	SubscribeSubtree(path string, lastEventID string) (payload []byte, statusCode int, subChan *chan []byte, subId string, events [][]byte)
+	UnsubscribeSubtree(path string, subId string)
+	Walk(ctx context.Context, visitCol func(colpath string, indexes []fieldIndex.Definition), visitDoc func(docpath string, serial []byte)) error
 }
 
 // DatabaseIndex represents indices for our databases
 type DatabaseIndex[K string, V Getdatabaser] interface {
 	Find(key K) (foundValue V, found bool)
+	Query(ctx context.Context, low K, hi K) (results []index_utils.Pair[K, V], err error)
 }
 
 type ResourceGetterService[K string, V Getdatabaser] struct {
@@ -26,8 +39,9 @@ func New[K string, V Getdatabaser](dbs DatabaseIndex[K, V]) *ResourceGetterServi
 	return &ResourceGetterService[K, V]{dbs: dbs}
 }
 
-// GetCol retrieves the top-level database in the path, and then delegates the call to a Getdatabase (if found)
-func (rgs *ResourceGetterService[K, T]) GetCol(dtb string, colpath string, lower string, upper string, mode bool) (payload []byte, statCode int, subChan *chan []byte, subId string, docEvents [][]byte) {
+// GetCol retrieves the top-level database in the path, and then delegates the call to a Getdatabase (if found).
+// A subscription resumes from the event lastEventID if it is given
+func (rgs *ResourceGetterService[K, T]) GetCol(dtb string, colpath string, lower string, upper string, mode bool, opts query.Options, lastEventID string) (payload []byte, statCode int, subChan *chan []byte, subId string, docEvents [][]byte) {
 
 	if mode {
 		slog.Debug(fmt.Sprintf("Received subscription request"))
@@ -39,11 +53,12 @@ func (rgs *ResourceGetterService[K, T]) GetCol(dtb string, colpath string, lower
 		return errmsg, http.StatusNotFound, subChan, subId, docEvents
 	}
 
-	return db.GetColSerial(colpath, lower, upper, mode)
+	return db.GetColSerial(colpath, lower, upper, mode, opts, lastEventID)
 }
 
-// GetDoc gets a document by first retrieving the database it belongs to, and forwarding the request at the path pathstr to the database
-func (rgs *ResourceGetterService[K, T]) GetDoc(dtb string, pathstr string, subscription bool) (response []byte, statCode int, subCh *chan []byte, id string, docEvent []byte) {
+// GetDoc gets a document by first retrieving the database it belongs to, and forwarding the request at the path pathstr to the database.
+// A subscription resumes from the event lastEventID if it is given
+func (rgs *ResourceGetterService[K, T]) GetDoc(dtb string, pathstr string, subscription bool, lastEventID string) (response []byte, statCode int, subCh *chan []byte, id string, docEvent []byte) {
 
 	if subscription {
 		slog.Debug(fmt.Sprintf("Received a subscription request"))
@@ -55,6 +70,105 @@ func (rgs *ResourceGetterService[K, T]) GetDoc(dtb string, pathstr string, subsc
 		return errmsg, http.StatusNotFound, nil, id, docEvent
 	}
 
-	doc, subChan, subId, stat, docEv := root.GetDocumentSerial(pathstr, subscription)
+	doc, subChan, subId, stat, docEv := root.GetDocumentSerial(pathstr, subscription, lastEventID)
 	return doc, stat, subChan, subId, docEv
 }
+
+// GetDocHistory reads the history of the document at pathstr by first retrieving the database it belongs to: the
+// versions kept if sel is zero, or the version sel picks
+func (rgs *ResourceGetterService[K, T]) GetDocHistory(dtb string, pathstr string, sel history.Selector) ([]byte, int) {
+	root, ok := rgs.dbs.Find(K(dtb))
+	if !ok {
+		errmsg, _ := json.Marshal("Error getting document: a database with that name does not exist.")
+		return errmsg, http.StatusNotFound
+	}
+	return root.GetDocumentHistory(pathstr, sel)
+}
+
+// UnsubscribeDoc ends the subscription subId to the document at pathstr by first retrieving the database it belongs
+// to. Nothing happens if the database no longer exists
+func (rgs *ResourceGetterService[K, T]) UnsubscribeDoc(dtb string, pathstr string, subId string) {
+	if root, ok := rgs.dbs.Find(K(dtb)); ok {
+		root.UnsubscribeDoc(pathstr, subId)
+	}
+}
+
+// UnsubscribeCol ends the subscription subId to the collection at colpath by first retrieving the database it
+// belongs to. Nothing happens if the database no longer exists
+func (rgs *ResourceGetterService[K, T]) UnsubscribeCol(dtb string, colpath string, subId string) {
+	if root, ok := rgs.dbs.Find(K(dtb)); ok {
+		root.UnsubscribeCol(colpath, subId)
+	}
+}
+
+// SubscribeSubtree subscribes to the subtree rooted at path, a document or a collection if path ends with "/", by
+// first retrieving the database it belongs to. The subscription resumes from the event lastEventID if it is given
+func (rgs *ResourceGetterService[K, T]) SubscribeSubtree(dtb string, path string, lastEventID string) (payload []byte, statCode int, subChan *chan []byte, subId string, events [][]byte) {
+	root, ok := rgs.dbs.Find(K(dtb))
+	if !ok {
+		errmsg, _ := json.Marshal("Database does not exist")
+		return errmsg, http.StatusNotFound, nil, "", nil
+	}
+	return root.SubscribeSubtree(path, lastEventID)
+}
+
+// UnsubscribeSubtree ends the subscription subId to the subtree rooted at path by first retrieving the database it
+// belongs to. Nothing happens if the database no longer exists
+func (rgs *ResourceGetterService[K, T]) UnsubscribeSubtree(dtb string, path string, subId string) {
+	if root, ok := rgs.dbs.Find(K(dtb)); ok {
+		root.UnsubscribeSubtree(path, subId)
+	}
+}
+
+// Walk visits every database and every collection and document they hold, parents before children. It is used to
+// take a point-in-time image of the server's contents
+func (rgs *ResourceGetterService[K, T]) Walk(ctx context.Context, visitDB func(dbName string), visitCol func(dbName string, colpath string, indexes []fieldIndex.Definition), visitDoc func(dbName string, docpath string, serial []byte)) error {
+	dbs, err := rgs.dbs.Query(ctx, K(string(rune(0))), K(string(rune(127))))
+	if err != nil {
+		return err
+	}
+	for _, pair := range dbs {
+		dbName := string(pair.Key)
+		visitDB(dbName)
+		err = pair.Value.Walk(ctx, func(colpath string, indexes []fieldIndex.Definition) {
+			visitCol(dbName, colpath, indexes)
+		}, func(docpath string, serial []byte) {
+			visitDoc(dbName, docpath, serial)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExportDB writes every collection and document of the database dtb as a line of JSON, parents before children.
+// Documents are written as serialized (path, doc and meta), collections as their path followed by a slash along with
+// the definitions of their secondary indices. The top-level collection is written as "/" if it has secondary indices.
+// Returns a JSON-encoded error and a status code if the database does not exist or could not be walked
+func (rgs *ResourceGetterService[K, T]) ExportDB(ctx context.Context, dtb string, write func(line []byte)) ([]byte, int) {
+	db, found := rgs.dbs.Find(K(dtb))
+	if !found {
+		errmsg, _ := json.Marshal("Database does not exist")
+		return errmsg, http.StatusNotFound
+	}
+
+	err := db.Walk(ctx, func(colpath string, indexes []fieldIndex.Definition) {
+		path := "/" + colpath + "/"
+		if colpath == "" {
+			path = "/"
+		}
+		line, _ := json.Marshal(struct {
+			Path    string                  `json:"path"`
+			Indexes []fieldIndex.Definition `json:"indexes,omitempty"`
+		}{Path: path, Indexes: indexes})
+		write(line)
+	}, func(docpath string, serial []byte) {
+		write(serial)
+	})
+	if err != nil {
+		errmsg, _ := json.Marshal(fmt.Sprintf("Unable to export database: %s", err.Error()))
+		return errmsg, http.StatusInternalServerError
+	}
+	return nil, http.StatusOK
+}
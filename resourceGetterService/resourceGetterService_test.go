@@ -1,46 +1,111 @@
 package resourceGetterService
 
 import (
+	"context"
 	"fmt"
 	"testing"
 
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/fieldIndex"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/history"
 	"github.com/RICE-COMP318-FALL24/owldb-p1group24/mocks"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/query"
 	"net/http"
 )
 
 // goodmockDB is a mock implementation of a database that simulates successful responses.
-type goodmockDB struct{}
+type goodmockDB struct {
+	unsubscribed []string // the subscriptions ended, as "path subId"
+}
 
 // GetColSerial simulates a successful column retrieval, returning http.StatusOK.
-func (m *goodmockDB) GetColSerial(string, string, string, bool) ([]byte, int, *chan []byte, string, [][]byte) {
+func (m *goodmockDB) GetColSerial(string, string, string, bool, query.Options, string) ([]byte, int, *chan []byte, string, [][]byte) {
 	return nil, http.StatusOK, nil, "", nil
 }
 
 // GetDocumentSerial simulates a successful document retrieval, returning http.StatusOK.
-func (m *goodmockDB) GetDocumentSerial(string, bool) ([]byte, *chan []byte, string, int, []byte) {
+func (m *goodmockDB) GetDocumentSerial(string, bool, string) ([]byte, *chan []byte, string, int, []byte) {
 	return nil, nil, "", http.StatusOK, nil
 }
 
+// GetDocumentHistory simulates a successful history retrieval, returning http.StatusOK.
+func (m *goodmockDB) GetDocumentHistory(string, history.Selector) ([]byte, int) {
+	return nil, http.StatusOK
+}
+
+// UnsubscribeDoc records the subscription ended.
+func (m *goodmockDB) UnsubscribeDoc(docpath string, subId string) {
+	m.unsubscribed = append(m.unsubscribed, docpath+" "+subId)
+}
+
+// UnsubscribeCol records the subscription ended.
+func (m *goodmockDB) UnsubscribeCol(colpath string, subId string) {
+	m.unsubscribed = append(m.unsubscribed, colpath+" "+subId)
+}
+
+// SubscribeSubtree simulates a successful subscription, returning http.StatusOK.
+func (m *goodmockDB) SubscribeSubtree(string, string) ([]byte, int, *chan []byte, string, [][]byte) {
+	return nil, http.StatusOK, nil, "", nil
+}
+
+// UnsubscribeSubtree records the subscription ended.
+func (m *goodmockDB) UnsubscribeSubtree(path string, subId string) {
+	m.unsubscribed = append(m.unsubscribed, path+" "+subId)
+}
+
+// Walk simulates a database holding a single document and one of its collections.
+func (m *goodmockDB) Walk(ctx context.Context, visitCol func(string, []fieldIndex.Definition), visitDoc func(string, []byte)) error {
+	visitDoc("doc1", []byte(`{}`))
+	visitCol("doc1/col", []fieldIndex.Definition{{Field: "/email"}})
+	return nil
+}
+
 // badmockDB is a mock implementation of a database, similar to goodmockDB.
 type badmockDB struct{}
 
 // GetColSerial simulates a column retrieval, returning http.StatusOK.
-func (m *badmockDB) GetColSerial(string, string, string, bool) ([]byte, int, *chan []byte, string, [][]byte) {
+func (m *badmockDB) GetColSerial(string, string, string, bool, query.Options, string) ([]byte, int, *chan []byte, string, [][]byte) {
 	return nil, http.StatusOK, nil, "", nil
 }
 
 // GetDocumentSerial simulates a document retrieval, returning http.StatusOK.
-func (m *badmockDB) GetDocumentSerial(string, bool) ([]byte, *chan []byte, string, int, []byte) {
+func (m *badmockDB) GetDocumentSerial(string, bool, string) ([]byte, *chan []byte, string, int, []byte) {
 	return nil, nil, "", http.StatusOK, nil
 }
 
+// GetDocumentHistory simulates a history retrieval, returning http.StatusOK.
+func (m *badmockDB) GetDocumentHistory(string, history.Selector) ([]byte, int) {
+	return nil, http.StatusOK
+}
+
+// UnsubscribeDoc does nothing.
+func (m *badmockDB) UnsubscribeDoc(string, string) {
+}
+
+// UnsubscribeCol does nothing.
+func (m *badmockDB) UnsubscribeCol(string, string) {
+}
+
+// SubscribeSubtree simulates a subscription, returning http.StatusOK.
+func (m *badmockDB) SubscribeSubtree(string, string) ([]byte, int, *chan []byte, string, [][]byte) {
+	return nil, http.StatusOK, nil, "", nil
+}
+
+// UnsubscribeSubtree does nothing.
+func (m *badmockDB) UnsubscribeSubtree(string, string) {
+}
+
+// Walk simulates a database that fails while being walked.
+func (m *badmockDB) Walk(context.Context, func(string, []fieldIndex.Definition), func(string, []byte)) error {
+	return fmt.Errorf("walk failed")
+}
+
 // TestGetColDB tests the scenario where the database is not found when attempting to get a column using goodmockDB.
 func TestGetColDB(t *testing.T) {
 	dbs := mocks.NewMockSL[string, *goodmockDB]()
-	rcs := New[string, 
-	*goodmockDB](dbs)
+	rcs := New[string,
+		*goodmockDB](dbs)
 
-	_, stat, _, _, _ := rcs.GetCol("fakeDB", "doc1/col1", "a", "z", false)
+	_, stat, _, _, _ := rcs.GetCol("fakeDB", "doc1/col1", "a", "z", false, query.Options{}, "")
 
 	if stat != http.StatusNotFound {
 		t.Errorf("TestGetColNoDB failed")
@@ -51,7 +116,7 @@ func TestGetColDB(t *testing.T) {
 func TestGetColNoDB(t *testing.T) {
 	dbs := mocks.NewMockSL[string, *badmockDB]()
 	rcs := New[string, *badmockDB](dbs)
-	_, stat, _, _, _ := rcs.GetCol("fakeDB", "doc1/col1", "a", "z", false)
+	_, stat, _, _, _ := rcs.GetCol("fakeDB", "doc1/col1", "a", "z", false, query.Options{}, "")
 
 	if stat != http.StatusNotFound {
 		t.Errorf("TestGetColDB failed")
@@ -62,7 +127,7 @@ func TestGetColNoDB(t *testing.T) {
 func TestGetDocNoDB(t *testing.T) {
 	dbs := mocks.NewMockSL[string, *badmockDB]()
 	rcs := New[string, *badmockDB](dbs)
-	_, stat, _, _, _ := rcs.GetDoc("fakeDB", "doc1/col1", false)
+	_, stat, _, _, _ := rcs.GetDoc("fakeDB", "doc1/col1", false, "")
 
 	if stat != http.StatusNotFound {
 		fmt.Printf("%d", stat)
@@ -77,10 +142,113 @@ func TestGetDocDB(t *testing.T) {
 		return &goodmockDB{}, nil
 	})
 	rcs := New[string, *goodmockDB](dbs)
-	_, stat, _, _, _ := rcs.GetDoc("goodDB", "doc1/col1", false)
+	_, stat, _, _, _ := rcs.GetDoc("goodDB", "doc1/col1", false, "")
 
 	if stat != http.StatusOK {
 		fmt.Printf("%d", stat)
 		t.Errorf("TestGetColDB failed")
 	}
 }
+
+// TestGetDocHistory tests that the history of a document is read from the database it belongs to.
+func TestGetDocHistory(t *testing.T) {
+	dbs := mocks.NewMockSL[string, *goodmockDB]()
+	dbs.Upsert("goodDB", func(string, *goodmockDB, bool) (*goodmockDB, error) {
+		return &goodmockDB{}, nil
+	})
+	rgs := New[string, *goodmockDB](dbs)
+	if _, stat := rgs.GetDocHistory("goodDB", "doc1", history.Selector{Version: 1}); stat != http.StatusOK {
+		t.Errorf("TestGetDocHistory failed, got %d", stat)
+	}
+	if _, stat := rgs.GetDocHistory("fakeDB", "doc1", history.Selector{}); stat != http.StatusNotFound {
+		t.Errorf("TestGetDocHistory failed, expected 404 for a missing database, got %d", stat)
+	}
+}
+
+// TestUnsubscribe tests that ending a subscription is forwarded to the database it belongs to, and ignored when the
+// database does not exist.
+func TestUnsubscribe(t *testing.T) {
+	dbs := mocks.NewMockSL[string, *goodmockDB]()
+	db := &goodmockDB{}
+	dbs.Upsert("goodDB", func(string, *goodmockDB, bool) (*goodmockDB, error) {
+		return db, nil
+	})
+	rgs := New[string, *goodmockDB](dbs)
+	rgs.UnsubscribeDoc("goodDB", "doc1", "1")
+	rgs.UnsubscribeCol("goodDB", "doc1/col1/", "2")
+	rgs.UnsubscribeDoc("fakeDB", "doc1", "3")
+	rgs.UnsubscribeSubtree("goodDB", "doc1", "4")
+
+	want := []string{"doc1 1", "doc1/col1/ 2", "doc1 4"}
+	if fmt.Sprint(db.unsubscribed) != fmt.Sprint(want) {
+		t.Errorf("TestUnsubscribe failed, expected %v, got %v", want, db.unsubscribed)
+	}
+}
+
+// TestSubscribeSubtree tests that a subtree subscription is made with the database it belongs to, and fails when the
+// database does not exist.
+func TestSubscribeSubtree(t *testing.T) {
+	dbs := mocks.NewMockSL[string, *goodmockDB]()
+	dbs.Upsert("goodDB", func(string, *goodmockDB, bool) (*goodmockDB, error) {
+		return &goodmockDB{}, nil
+	})
+	rgs := New[string, *goodmockDB](dbs)
+	if _, stat, _, _, _ := rgs.SubscribeSubtree("goodDB", "doc1/col1/", ""); stat != http.StatusOK {
+		t.Errorf("TestSubscribeSubtree failed, got %d", stat)
+	}
+	if _, stat, _, _, _ := rgs.SubscribeSubtree("fakeDB", "doc1", ""); stat != http.StatusNotFound {
+		t.Errorf("TestSubscribeSubtree failed, expected 404 for a missing database, got %d", stat)
+	}
+}
+
+// TestWalk tests that walking the service visits every database along with its documents.
+func TestWalk(t *testing.T) {
+	dbs := mocks.NewMockSL[string, *goodmockDB]()
+	dbs.Upsert("goodDB", func(string, *goodmockDB, bool) (*goodmockDB, error) {
+		return &goodmockDB{}, nil
+	})
+	rgs := New[string, *goodmockDB](dbs)
+	var visited []string
+	err := rgs.Walk(context.Background(), func(dbName string) {
+		visited = append(visited, dbName)
+	}, func(dbName string, colpath string, indexes []fieldIndex.Definition) {
+		visited = append(visited, dbName+"/"+colpath)
+	}, func(dbName string, docpath string, serial []byte) {
+		visited = append(visited, dbName+"/"+docpath)
+	})
+	if err != nil || len(visited) != 3 || visited[0] != "goodDB" || visited[1] != "goodDB/doc1" {
+		t.Errorf("TestWalk failed, got %v, %v", visited, err)
+	}
+}
+
+// TestWalkError tests that a failure while walking a database is reported.
+func TestWalkError(t *testing.T) {
+	dbs := mocks.NewMockSL[string, *badmockDB]()
+	dbs.Upsert("badDB", func(string, *badmockDB, bool) (*badmockDB, error) {
+		return &badmockDB{}, nil
+	})
+	rgs := New[string, *badmockDB](dbs)
+	err := rgs.Walk(context.Background(), func(string) {}, func(string, string, []fieldIndex.Definition) {}, func(string, string, []byte) {})
+	if err == nil {
+		t.Errorf("TestWalkError failed, expected an error")
+	}
+}
+
+// TestExportDB tests that documents and collections are exported one line each, and that unknown databases are reported.
+func TestExportDB(t *testing.T) {
+	dbs := mocks.NewMockSL[string, *goodmockDB]()
+	dbs.Upsert("goodDB", func(string, *goodmockDB, bool) (*goodmockDB, error) {
+		return &goodmockDB{}, nil
+	})
+	rgs := New[string, *goodmockDB](dbs)
+	var lines []string
+	_, stat := rgs.ExportDB(context.Background(), "goodDB", func(line []byte) {
+		lines = append(lines, string(line))
+	})
+	if stat != http.StatusOK || len(lines) != 2 || lines[0] != `{}` || lines[1] != `{"path":"/doc1/col/","indexes":[{"field":"/email"}]}` {
+		t.Errorf("TestExportDB failed, got %d and %v", stat, lines)
+	}
+	if _, stat := rgs.ExportDB(context.Background(), "missing", func([]byte) {}); stat != http.StatusNotFound {
+		t.Errorf("TestExportDB failed, expected 404 for a missing database, got %d", stat)
+	}
+}
@@ -3,12 +3,13 @@ package resourcePatcherService
 
 import (
 	"github.com/RICE-COMP318-FALL24/owldb-p1group24/index_utils"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/precondition"
 	"net/http"
 )
 
 // Patchdatabaser is an interface that represents an object capable of applying patches to documents.
 type Patchdatabaser interface {
-	Patch(docPath string, patches []byte, user string) ([]byte, int)
+	Patch(docPath string, patches []byte, user string, mediaType string, cond precondition.Conditions) ([]byte, int)
 }
 
 // DatabaseIndex is a generic interface that defines operations for managing databases.
@@ -35,16 +36,17 @@ func New[K string, T Patchdatabaser](dbs DatabaseIndex[K, T]) *ResourcePatcherSe
 	return &ResourcePatcherService[K, T]{dbs: dbs}
 }
 
-// PatchDoc processes a PATCH request for a document within a specific database.
+// PatchDoc processes a PATCH request for a document within a specific database, the patches being written in the
+// dialect of mediaType. The patches are only applied if the document satisfies the conditions cond.
 // Returns the response as a byte slice and the corresponding HTTP status code.
-func (rps *ResourcePatcherService[K, T]) PatchDoc(dtb string, docpath string, patches []byte, user string) ([]byte, int, string) {
+func (rps *ResourcePatcherService[K, T]) PatchDoc(dtb string, docpath string, patches []byte, user string, mediaType string, cond precondition.Conditions) ([]byte, int, string) {
 
 	db, found := rps.dbs.Find(K(dtb))
 	if found == false {
 		return []byte(`{"error": "Database not exist"}`), http.StatusNotFound, ""
 	} // find the database
 
-	responses, status := db.Patch(docpath, patches, user)
+	responses, status := db.Patch(docpath, patches, user, mediaType, cond)
 
 	return responses, status, "/v1/" + dtb + "/" + docpath
 }
@@ -2,6 +2,8 @@ package resourcePatcherService
 
 import (
 	"github.com/RICE-COMP318-FALL24/owldb-p1group24/mocks"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/patcher"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/precondition"
 	"net/http"
 	"testing"
 )
@@ -48,7 +50,7 @@ type mockDB struct {
 }
 
 // Patch simulates a patch operation on a mockDB.
-func (m *mockDB) Patch(docPath string, patches []byte, user string) ([]byte, int) {
+func (m *mockDB) Patch(docPath string, patches []byte, user string, mediaType string, cond precondition.Conditions) ([]byte, int) {
 
 	return []byte("Pretend this is a success message"), 200
 }
@@ -71,7 +73,7 @@ func TestResourcePatcherService_PatchDocNoDBFound(t *testing.T) {
 
 	rps := setup()
 
-	_, stat, _ := rps.PatchDoc("db", "doc1/col1/doc1", []byte("PATCHES"), "USER")
+	_, stat, _ := rps.PatchDoc("db", "doc1/col1/doc1", []byte("PATCHES"), "USER", patcher.MediaTypeOwlDB, precondition.Conditions{})
 
 	if stat != http.StatusNotFound {
 		t.Errorf("TestResourcePatcherService_PatchDocNoDBFound")
@@ -82,7 +84,7 @@ func TestResourcePatcherService_PatchDocNoDBFound(t *testing.T) {
 func TestResourcePatcherService_PatchDocDBFound(t *testing.T) {
 	rps := setupContainsDB()
 
-	_, stat, _ := rps.PatchDoc("db", "doc1", []byte("PATCHES"), "USER")
+	_, stat, _ := rps.PatchDoc("db", "doc1", []byte("PATCHES"), "USER", patcher.MediaTypeOwlDB, precondition.Conditions{})
 
 	if stat != http.StatusOK {
 		t.Errorf("TestResourcePatcherService_PatchDocDBFound failed")
@@ -94,7 +96,7 @@ func TestResourcePatcherService_PatchDocDBFound(t *testing.T) {
 func TestResourcePatcherService_PatchDoc(t *testing.T) {
 	rps := setupContainsDB()
 
-	_, stat, _ := rps.PatchDoc("db", "doc1", []byte("PATCHES"), "USER")
+	_, stat, _ := rps.PatchDoc("db", "doc1", []byte("PATCHES"), "USER", patcher.MediaTypeOwlDB, precondition.Conditions{})
 
 	if stat != http.StatusOK {
 		t.Errorf("TestResourcePatcherService_PatchDocDBFound failed")
@@ -115,7 +117,7 @@ func TestResourcePatcherService_PatchDocObjectAdd(t *testing.T) {
 	// Convert the JSON patch to a byte slice
 	patchBytes := []byte(patch)
 
-	_, stat, _ := rps.PatchDoc("db", "doc1", patchBytes, "USER")
+	_, stat, _ := rps.PatchDoc("db", "doc1", patchBytes, "USER", patcher.MediaTypeOwlDB, precondition.Conditions{})
 
 	if stat != http.StatusOK {
 		t.Errorf("TestResourcePatcherService_PatchDocObjectAdd failed: expected status 200, got %d", stat)
@@ -136,7 +138,7 @@ func TestResourcePatcherService_PatchDocArrayAdd(t *testing.T) {
 	// Convert the JSON patch to a byte slice
 	patchBytes := []byte(patch)
 
-	_, stat, _ := rps.PatchDoc("db", "doc1", patchBytes, "USER")
+	_, stat, _ := rps.PatchDoc("db", "doc1", patchBytes, "USER", patcher.MediaTypeOwlDB, precondition.Conditions{})
 
 	if stat != http.StatusOK {
 		t.Errorf("TestResourcePatcherService_PatchDocWithPatchBytes failed")
@@ -159,7 +161,7 @@ func TestResourcePatcherService_PatchDocArrayRemove(t *testing.T) {
 	// Convert the JSON patch to a byte slice
 	patchBytes := []byte(patch)
 
-	_, stat, _ := rps.PatchDoc("db", "doc1", patchBytes, "USER")
+	_, stat, _ := rps.PatchDoc("db", "doc1", patchBytes, "USER", patcher.MediaTypeOwlDB, precondition.Conditions{})
 
 	if stat != http.StatusOK {
 		t.Errorf("TestResourcePatcherService_PatchDocWithPatchBytes failed")
@@ -8,7 +8,8 @@ import (
 )
 
 // loginHandler processes user login requests.
-// It reads the JSON body containing a username and returns an authentication token upon successful login.
+// It reads the JSON body containing a username and a password, and returns an authentication token if the password
+// is that of the user.
 func (dbh *DbHarness) loginhandler(w http.ResponseWriter, r *http.Request) {
 	//read body from request
 	body, err := io.ReadAll(r.Body)
@@ -22,6 +23,7 @@ func (dbh *DbHarness) loginhandler(w http.ResponseWriter, r *http.Request) {
 	//constructing data
 	var data struct {
 		Username string `json:"username"`
+		Password string `json:"password"`
 	}
 	err = json.Unmarshal(body, &data)
 	if err != nil {
@@ -34,6 +36,17 @@ func (dbh *DbHarness) loginhandler(w http.ResponseWriter, r *http.Request) {
 		writeResponse(w, http.StatusBadRequest, errmsg)
 		return
 	}
+	if data.Password == "" {
+		errmsg, _ := json.Marshal("No password found")
+		writeResponse(w, http.StatusBadRequest, errmsg)
+		return
+	}
+	if verifyerr := dbh.users.Verify(data.Username, data.Password); verifyerr != nil {
+		slog.Warn("Login failed", "user", data.Username)
+		errmsg, _ := json.Marshal(verifyerr.Error())
+		writeResponse(w, http.StatusUnauthorized, errmsg)
+		return
+	}
 	token, loginerr := dbh.auth.Login(data.Username)
 	if loginerr != nil {
 		errmsg, _ := json.Marshal(loginerr.Error())
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/auth"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/transaction"
+)
+
+// maxBatchSize is the largest number of items a batch or a multi-get may hold
+const maxBatchSize = 1000
+
+// batchResult is the outcome of a single item of a batch or a multi-get
+type batchResult struct {
+	Uri    string          `json:"uri"`            // the URI of the document
+	Status int             `json:"status"`         // the status code the item would have received on its own
+	Body   json.RawMessage `json:"body,omitempty"` // the response the item would have received on its own, if any
+}
+
+// batchHandler handles requests to apply a batch, an array of independent put, patch and delete operations on the
+// documents of a database. Every operation is applied as if it were sent on its own, so some may fail while others
+// succeed; the response lists the status and response of each, in order. An operation on a document its user does
+// not hold the writer role on fails with 403.
+// Validates the URL path and the bearer token once for the whole batch.
+func (dbh *DbHarness) batchHandler(w http.ResponseWriter, r *http.Request) {
+	dbName, user, items, ok := dbh.preprocessBatchRequest(w, r)
+	if !ok {
+		return
+	}
+
+	results := make([]batchResult, len(items))
+	for i, item := range items {
+		var op transaction.Operation
+		if err := json.Unmarshal(item, &op); err != nil {
+			results[i] = batchError(dbName, "", http.StatusBadRequest, "malformed operation")
+			continue
+		}
+		if err := op.Validate(); err != nil {
+			results[i] = batchError(dbName, op.Path, http.StatusBadRequest, err.Error())
+			continue
+		}
+		if dbh.users.Role(user, "/"+dbName+op.Path) < auth.Writer {
+			results[i] = batchError(dbName, op.Path, http.StatusForbidden, forbidden(user, "/"+dbName+op.Path, auth.Writer))
+			continue
+		}
+		docpath := op.DocPath()
+		var response []byte
+		var status int
+		switch op.Op {
+		case transaction.OpPut:
+			splitPath := strings.Split(docpath, "/")
+			response, status, _ = dbh.rc.PutDoc(dbName, docpath, splitPath[len(splitPath)-1], op.Doc, true, user, op.Conditions())
+		case transaction.OpPatch:
+			response, status, _ = dbh.rp.PatchDoc(dbName, docpath, op.Patch, user, op.MediaType(), op.Conditions())
+		case transaction.OpDelete:
+			response, status = dbh.rd.DeleteDoc(dbName, docpath, user, op.Conditions())
+		}
+		results[i] = batchResponse(dbName, op.Path, status, response)
+	}
+	b, _ := json.Marshal(results)
+	writeResponse(w, http.StatusOK, b)
+}
+
+// multiGetHandler handles requests to read many documents of a database at once. The body is an array of document
+// paths, e.g. ["/doc", "/doc/col/doc"]; the response lists the status and contents of each, in order. A document its
+// user does not hold the reader role on is reported with 403.
+// Validates the URL path and the bearer token once for the whole request.
+func (dbh *DbHarness) multiGetHandler(w http.ResponseWriter, r *http.Request) {
+	dbName, user, items, ok := dbh.preprocessBatchRequest(w, r)
+	if !ok {
+		return
+	}
+
+	results := make([]batchResult, len(items))
+	for i, item := range items {
+		var path string
+		if err := json.Unmarshal(item, &path); err != nil {
+			results[i] = batchError(dbName, "", http.StatusBadRequest, "malformed document path")
+			continue
+		}
+		docpath := strings.TrimPrefix(path, "/")
+		if !strings.HasPrefix(path, "/") || strings.Contains(docpath, "//") || validateDocPath(docpath) != nil {
+			results[i] = batchError(dbName, path, http.StatusBadRequest, "bad resource path")
+			continue
+		}
+		if dbh.users.Role(user, "/"+dbName+path) < auth.Reader {
+			results[i] = batchError(dbName, path, http.StatusForbidden, forbidden(user, "/"+dbName+path, auth.Reader))
+			continue
+		}
+		response, status, _, _, _ := dbh.rg.GetDoc(dbName, docpath, false, "")
+		results[i] = batchResponse(dbName, path, status, response)
+	}
+	b, _ := json.Marshal(results)
+	writeResponse(w, http.StatusOK, b)
+}
+
+// preprocessBatchRequest validates the URL path and the bearer token of a batch or a multi-get, and reads its body,
+// a JSON array of at most maxBatchSize items. Writes an error response if any of these fail.
+// Returns the database, the user, the items of the body and whether the request should proceed
+func (dbh *DbHarness) preprocessBatchRequest(w http.ResponseWriter, r *http.Request) (string, string, []json.RawMessage, bool) {
+	defer r.Body.Close()
+	if patherr := validateUrl(r.URL.Path); patherr != nil {
+		errmsg, _ := json.Marshal(patherr.Error())
+		writeResponse(w, http.StatusBadRequest, errmsg)
+		return "", "", nil, false
+	}
+	token, err := extractToken(r.Header)
+	if err != nil {
+		errmsg, _ := json.Marshal("Missing or invalid bearer token")
+		writeResponse(w, http.StatusUnauthorized, errmsg)
+		return "", "", nil, false
+	}
+	user, autherr := dbh.auth.ValidateSession(token)
+	if autherr != nil {
+		errmsg, _ := json.Marshal(autherr.Error())
+		writeResponse(w, http.StatusUnauthorized, errmsg)
+		return "", "", nil, false
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		errmsg, _ := json.Marshal(err.Error())
+		writeResponse(w, http.StatusBadRequest, errmsg)
+		return "", "", nil, false
+	}
+	var items []json.RawMessage
+	if err = json.Unmarshal(body, &items); err != nil || items == nil {
+		errmsg, _ := json.Marshal("the body must be a JSON array")
+		writeResponse(w, http.StatusBadRequest, errmsg)
+		return "", "", nil, false
+	}
+	if len(items) > maxBatchSize {
+		errmsg, _ := json.Marshal(fmt.Sprintf("at most %d items may be sent at once", maxBatchSize))
+		writeResponse(w, http.StatusRequestEntityTooLarge, errmsg)
+		return "", "", nil, false
+	}
+	return r.PathValue("resource"), user, items, true
+}
+
+// batchResponse builds the outcome of an item of a batch or a multi-get from the response it received
+func batchResponse(dbName string, path string, status int, response []byte) batchResult {
+	result := batchResult{Uri: "/v1/" + dbName + path, Status: status}
+	if len(response) > 0 && json.Valid(response) {
+		result.Body = response
+	}
+	return result
+}
+
+// batchError builds the outcome of an item of a batch or a multi-get that could not be attempted
+func batchError(dbName string, path string, status int, msg string) batchResult {
+	errmsg, _ := json.Marshal(msg)
+	return batchResponse(dbName, path, status, errmsg)
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/auth"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/changes"
+)
+
+// EpochHeader carries the epoch of the change feed; a sequence number only identifies a change within its epoch
+const EpochHeader = "X-OwlDB-Epoch"
+
+// changeFeed is an interface that defines the methods for reading the change feeds of databases.
+type changeFeed interface {
+	Epoch() string                                                             //Epoch should return the epoch of the feeds, which changes whenever they start over
+	Changes(dtb string, since int64) ([]changes.Change, error)                 //Changes should list the changes made to the database after the change numbered since
+	Subscribe(dtb string, since int64) ([][]byte, *chan []byte, string, error) //Subscribe should stream the changes made to the database after the change numbered since
+	Unsubscribe(dtb string, id string)                                         //Unsubscribe should stop streaming the feed of the database to the consumer id
+}
+
+// getChangesHandler handles requests made to read the change feed of a database: ?changes&since=N lists every
+// change made after the change numbered N, while mode=subscribe streams them, and every later one, as server-sent
+// events. A streaming consumer resumes from the Last-Event-ID header. A request whose epoch parameter is not the
+// current epoch of the feed, or whose changes are no longer kept, is answered with 410 Gone.
+// Validates the URL path, the bearer token and the query parameters before reading.
+func (dbh *DbHarness) getChangesHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	if patherr := validateUrl(r.URL.Path); patherr != nil {
+		errmsg, _ := json.Marshal(patherr.Error())
+		writeResponse(w, http.StatusBadRequest, errmsg)
+		return
+	}
+	token, err := extractToken(r.Header)
+	if err != nil {
+		errmsg, _ := json.Marshal("Missing or invalid bearer token")
+		writeResponse(w, http.StatusUnauthorized, errmsg)
+		return
+	}
+	user, autherr := dbh.auth.ValidateSession(token)
+	if autherr != nil {
+		errmsg, _ := json.Marshal(autherr.Error())
+		writeResponse(w, http.StatusUnauthorized, errmsg)
+		return
+	}
+	if !dbh.permit(w, user, "/"+r.PathValue("resource"), auth.Reader) {
+		return
+	}
+	qs := r.URL.Query()
+	mode := qs.Get("mode")
+	if mode != "" && !validateSubscribe(mode) {
+		errmsg, _ := json.Marshal("malformed subscribe parameter")
+		writeResponse(w, http.StatusBadRequest, errmsg)
+		return
+	}
+	subscribe := mode == "subscribe"
+	since, err := parseSince(qs, r.Header, subscribe)
+	if err != nil {
+		errmsg, _ := json.Marshal(err.Error())
+		writeResponse(w, http.StatusBadRequest, errmsg)
+		return
+	}
+	if qs.Has("epoch") && qs.Get("epoch") != dbh.feed.Epoch() {
+		errmsg, _ := json.Marshal("the change feed has started over since that epoch")
+		writeResponse(w, http.StatusGone, errmsg)
+		return
+	}
+
+	dtb := r.PathValue("resource")
+	w.Header().Set(EpochHeader, dbh.feed.Epoch())
+	if !subscribe {
+		list, err := dbh.feed.Changes(dtb, since)
+		if err != nil {
+			writeChangesError(w, err)
+			return
+		}
+		response, _ := json.Marshal(list)
+		writeResponse(w, http.StatusOK, response)
+		return
+	}
+	initial, subChan, subId, err := dbh.feed.Subscribe(dtb, since)
+	if err != nil {
+		writeChangesError(w, err)
+		return
+	}
+	dbh.streamEvents(w, r, token, user, initial, subChan, func() {
+		dbh.feed.Unsubscribe(dtb, subId)
+	})
+}
+
+// parseSince reads the since query field, or the Last-Event-ID header of a resuming subscriber, which takes precedence.
+// Returns the sequence number to read the changes after, 0 if neither is given, or an error if it is malformed
+func parseSince(qs url.Values, header http.Header, subscribe bool) (int64, error) {
+	raw := qs.Get("since")
+	if last := header.Get("Last-Event-ID"); subscribe && last != "" {
+		raw = last
+	}
+	if raw == "" {
+		return 0, nil
+	}
+	since, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || since < 0 {
+		return 0, errors.New("malformed since parameter")
+	}
+	return since, nil
+}
+
+// writeChangesError answers a request for the change feed that could not be served because of err
+func writeChangesError(w http.ResponseWriter, err error) {
+	errmsg, _ := json.Marshal(err.Error())
+	if errors.Is(err, changes.ErrNoDatabase) {
+		writeResponse(w, http.StatusNotFound, errmsg)
+		return
+	}
+	writeResponse(w, http.StatusGone, errmsg)
+}
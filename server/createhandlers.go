@@ -7,6 +7,8 @@ import (
 	"log/slog"
 	"net/http"
 	"strings"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/auth"
 )
 
 // putHandler dispatches PUT requests based on the end of the path;
@@ -25,6 +27,10 @@ func (dbh *DbHarness) putHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if strings.HasSuffix(resource, "/") {
+		if r.URL.Query().Has("index") {
+			dbh.putIndexHandler(w, r)
+			return
+		}
 		dbh.putColHandler(w, r)
 	} else {
 		dbh.putDocHandler(w, r)
@@ -52,12 +58,15 @@ func (dbh *DbHarness) createDBHandler(w http.ResponseWriter, r *http.Request) {
 		writeResponse(w, http.StatusUnauthorized, emg)
 	}
 
-	_, autherr := dbh.auth.ValidateSession(token)
+	user, autherr := dbh.auth.ValidateSession(token)
 	if autherr != nil {
 		errmsg, _ := json.Marshal(autherr.Error())
 		writeResponse(w, http.StatusUnauthorized, errmsg)
 		return
 	}
+	if !dbh.permit(w, user, "/"+r.PathValue("resource"), auth.Admin) {
+		return
+	}
 
 	dbName := r.PathValue("resource")
 	if dbName == "" {
@@ -65,7 +74,7 @@ func (dbh *DbHarness) createDBHandler(w http.ResponseWriter, r *http.Request) {
 		writeResponse(w, http.StatusBadRequest, errmsg)
 		return
 	}
-	response, status, uri := dbh.rc.CreateDB(dbName)
+	response, status, uri := dbh.rc.CreateDB(dbName, user)
 	if status == http.StatusCreated {
 		w.Header().Set("Location", uri)
 		writeResponse(w, status, response)
@@ -144,13 +153,16 @@ func (dbh *DbHarness) putDocHandler(w http.ResponseWriter, r *http.Request) {
 		writeResponse(w, http.StatusUnauthorized, errmsg)
 		return
 	}
+	if !dbh.permit(w, user, "/"+r.PathValue("resource"), auth.Writer) {
+		return
+	}
 	err = validateDocPath(docPath)
 	if err != nil {
 		errmsg, _ := json.Marshal(err.Error())
 		writeResponse(w, http.StatusBadRequest, errmsg)
 		return
 	}
-	response, status, uri := dbh.rc.PutDoc(dbName, docPath, splitPath[len(splitPath)-1], body, overwrite, user)
+	response, status, uri := dbh.rc.PutDoc(dbName, docPath, splitPath[len(splitPath)-1], body, overwrite, user, requestConditions(r.Header))
 	if status == http.StatusCreated || status == http.StatusOK {
 		w.Header().Set("Location", uri)
 		writeResponse(w, status, response)
@@ -213,6 +225,9 @@ func (dbh *DbHarness) postDocHandler(w http.ResponseWriter, r *http.Request) {
 		writeResponse(w, http.StatusUnauthorized, resp)
 		return
 	}
+	if !dbh.permit(w, user, "/"+r.PathValue("resource"), auth.Writer) {
+		return
+	}
 	resp, stat, uri := dbh.rc.PostDoc(dbName, colpath, user, body)
 	if stat == http.StatusCreated {
 		w.Header().Set("Location", uri)
@@ -247,15 +262,18 @@ func (dbh *DbHarness) putColHandler(w http.ResponseWriter, r *http.Request) {
 
 		writeResponse(w, http.StatusUnauthorized, emg)
 	}
-	_, autherr := dbh.auth.ValidateSession(token)
+	user, autherr := dbh.auth.ValidateSession(token)
 	if autherr != nil {
 		errmsg, _ := json.Marshal(autherr.Error())
 		writeResponse(w, http.StatusUnauthorized, errmsg)
 		return
 	}
+	if !dbh.permit(w, user, "/"+r.PathValue("resource"), auth.Writer) {
+		return
+	}
 	// If valid, it proceeds with the collection operation.
 	// Returns a 201 Created status upon success or an appropriate error status otherwise.
-	resp, stat, uri := dbh.rc.PutCol(dtb, colpath)
+	resp, stat, uri := dbh.rc.PutCol(dtb, colpath, user)
 	if stat == http.StatusCreated {
 		w.Header().Set("Location", uri)
 		writeResponse(w, stat, resp)
@@ -266,6 +284,7 @@ func (dbh *DbHarness) putColHandler(w http.ResponseWriter, r *http.Request) {
 
 // patchDocHandler processes PATCH requests for documents.
 // It validates the document path, JSON body, and the bearer token before forwarding the request to the patching service.
+// The Content-Type header picks the patch dialect, OwlDB's own operations being assumed if it is missing.
 func (dbh *DbHarness) patchDocHanlder(writer http.ResponseWriter, request *http.Request) {
 	defer request.Body.Close()
 	// Validates the URL path, JSON body, and Authorization header.
@@ -306,13 +325,16 @@ func (dbh *DbHarness) patchDocHanlder(writer http.ResponseWriter, request *http.
 		writeResponse(writer, http.StatusUnauthorized, errmsg)
 		return
 	}
+	if !dbh.permit(writer, user, "/"+request.PathValue("resource"), auth.Writer) {
+		return
+	}
 	err = validateDocPath(docPath) //check that this is a valid document path
 	if err != nil {
 		errmsg, _ := json.Marshal(err.Error())
 		writeResponse(writer, http.StatusBadRequest, errmsg)
 		return
 	}
-	resp, status, uri := dbh.rp.PatchDoc(dbName, docPath, body, user)
+	resp, status, uri := dbh.rp.PatchDoc(dbName, docPath, body, user, patchMediaType(request.Header), requestConditions(request.Header))
 	if status == http.StatusOK {
 		writer.Header().Set("Location", uri)
 	}
@@ -1,5 +1,5 @@
-// Package server provides the handlers for DELETE requests to OwlDB. 
-// It supports deletion of databases, collections, and documents, and ensures request validation, 
+// Package server provides the handlers for DELETE requests to OwlDB.
+// It supports deletion of databases, collections, and documents, and ensures request validation,
 // token authentication, and response formatting.
 package server
 
@@ -7,9 +7,11 @@ import (
 	"encoding/json"
 	"net/http"
 	"strings"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/auth"
 )
 
-// deleteHandler parses the resource path and dispatches requests accordingly 
+// deleteHandler parses the resource path and dispatches requests accordingly
 func (dbh *DbHarness) deleteHandler(w http.ResponseWriter, r *http.Request) {
 	resource := r.PathValue("resource")
 	if len(resource) == 0 { //empty strings are not allowed
@@ -23,6 +25,10 @@ func (dbh *DbHarness) deleteHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if strings.HasSuffix(resource, "/") { //must be a collection
+		if r.URL.Query().Has("index") {
+			dbh.deleteIndexHandler(w, r)
+			return
+		}
 		dbh.deleteColHandler(w, r)
 	} else {
 		dbh.deleteDocHandler(w, r) //must be a document
@@ -34,12 +40,12 @@ func (dbh *DbHarness) deleteHandler(w http.ResponseWriter, r *http.Request) {
 func (dbh *DbHarness) deleteColHandler(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 	patherr := validateUrl(r.URL.Path)
-    //validating the path
+	//validating the path
 	if patherr != nil {
 		errmsg, _ := json.Marshal(patherr.Error())
 		writeResponse(w, http.StatusBadRequest, errmsg)
 		return
-	
+
 	}
 	// Extract and validate the Bearer token
 	token, err := extractToken(r.Header)
@@ -48,12 +54,15 @@ func (dbh *DbHarness) deleteColHandler(w http.ResponseWriter, r *http.Request) {
 		writeResponse(w, http.StatusUnauthorized, errmsg)
 		return
 	}
-	_, autherr := dbh.auth.ValidateSession(token)
+	user, autherr := dbh.auth.ValidateSession(token)
 	if autherr != nil {
 		errmsg, _ := json.Marshal(autherr.Error())
 		writeResponse(w, http.StatusUnauthorized, errmsg)
 		return
 	}
+	if !dbh.permit(w, user, "/"+r.PathValue("resource"), auth.Writer) {
+		return
+	}
 	// Parse the resource path and validate
 	resourcePath := r.PathValue("resource")
 	dbName, colpath := parseResourcePath(resourcePath)
@@ -67,7 +76,7 @@ func (dbh *DbHarness) deleteColHandler(w http.ResponseWriter, r *http.Request) {
 		writeResponse(w, http.StatusBadRequest, errmsg)
 		return
 	}
-	response, status := dbh.rd.DeleteCol(dbName, colpath)
+	response, status := dbh.rd.DeleteCol(dbName, colpath, user)
 	writeResponse(w, status, response)
 }
 
@@ -75,7 +84,7 @@ func (dbh *DbHarness) deleteColHandler(w http.ResponseWriter, r *http.Request) {
 func (dbh *DbHarness) deleteDocHandler(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 	patherr := validateUrl(r.URL.Path)
- //validating the path
+	//validating the path
 	if patherr != nil {
 		errmsg, _ := json.Marshal(patherr.Error())
 		writeResponse(w, http.StatusBadRequest, errmsg)
@@ -94,12 +103,15 @@ func (dbh *DbHarness) deleteDocHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	// Authenticate the token
 
-	_, autherr := dbh.auth.ValidateSession(token)
+	user, autherr := dbh.auth.ValidateSession(token)
 	if autherr != nil {
 		errmsg, _ := json.Marshal(autherr.Error())
 		writeResponse(w, http.StatusUnauthorized, errmsg)
 		return
 	}
+	if !dbh.permit(w, user, "/"+r.PathValue("resource"), auth.Writer) {
+		return
+	}
 	path := r.PathValue("resource")
 	dbName, docpath := parseResourcePath(path)
 	err = validateDocPath(docpath)
@@ -109,7 +121,7 @@ func (dbh *DbHarness) deleteDocHandler(w http.ResponseWriter, r *http.Request) {
 		writeResponse(w, http.StatusBadRequest, errmsg)
 		return
 	}
-	response, status := dbh.rd.DeleteDoc(dbName, docpath)
+	response, status := dbh.rd.DeleteDoc(dbName, docpath, user, requestConditions(r.Header))
 	writeResponse(w, status, response)
 }
 
@@ -135,14 +147,17 @@ func (dbh *DbHarness) deleteDBHandler(w http.ResponseWriter, r *http.Request) {
 		writeResponse(w, http.StatusUnauthorized, emg)
 		return
 	}
-	_, autherr := dbh.auth.ValidateSession(token) //authenticating
+	user, autherr := dbh.auth.ValidateSession(token) //authenticating
 	if autherr != nil {
 		errmsg, _ := json.Marshal(autherr.Error())
 		writeResponse(w, http.StatusUnauthorized, errmsg)
 		return
 	}
+	if !dbh.permit(w, user, "/"+r.PathValue("resource"), auth.Admin) {
+		return
+	}
 
-	response, status := dbh.rd.DeleteDB(dbName)
+	response, status := dbh.rd.DeleteDB(dbName, user)
 
 	writeResponse(w, status, response)
 
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/auth"
+)
+
+// postHandler dispatches POST requests made to a database: ?import imports a dump, ?transaction applies a
+// transaction, ?batch applies a batch and ?multiget reads many documents. A document followed by ?restore is restored
+// to an earlier version. Anything else posts a document into a collection
+func (dbh *DbHarness) postHandler(w http.ResponseWriter, r *http.Request) {
+	resource := r.PathValue("resource")
+	if len(resource) != 0 && len(strings.Split(resource, "/")) == 1 {
+		qs := r.URL.Query()
+		switch {
+		case qs.Has("import"):
+			dbh.importDBHandler(w, r)
+			return
+		case qs.Has("transaction"):
+			dbh.transactionHandler(w, r)
+			return
+		case qs.Has("batch"):
+			dbh.batchHandler(w, r)
+			return
+		case qs.Has("multiget"):
+			dbh.multiGetHandler(w, r)
+			return
+		}
+	}
+	if r.URL.Query().Has("restore") {
+		dbh.restoreDocHandler(w, r)
+		return
+	}
+	dbh.postDocHandler(w, r)
+}
+
+// exportDBHandler handles requests to export a database as JSONL, one line per collection and document.
+// Validates the URL path, the export format and the bearer token before streaming the database.
+func (dbh *DbHarness) exportDBHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	if patherr := validateUrl(r.URL.Path); patherr != nil {
+		errmsg, _ := json.Marshal(patherr.Error())
+		writeResponse(w, http.StatusBadRequest, errmsg)
+		return
+	}
+	if format := r.URL.Query().Get("export"); format != "jsonl" {
+		errmsg, _ := json.Marshal("unsupported export format")
+		writeResponse(w, http.StatusBadRequest, errmsg)
+		return
+	}
+	token, err := extractToken(r.Header)
+	if err != nil {
+		errmsg, _ := json.Marshal("Missing or invalid bearer token")
+		writeResponse(w, http.StatusUnauthorized, errmsg)
+		return
+	}
+	user, autherr := dbh.auth.ValidateSession(token)
+	if autherr != nil {
+		errmsg, _ := json.Marshal(autherr.Error())
+		writeResponse(w, http.StatusUnauthorized, errmsg)
+		return
+	}
+	if !dbh.permit(w, user, "/"+r.PathValue("resource"), auth.Reader) {
+		return
+	}
+
+	//the header is only sent with the first line, so that a missing database can still be reported
+	started := false
+	response, status := dbh.rg.ExportDB(r.Context(), r.PathValue("resource"), func(line []byte) {
+		if !started {
+			w.Header().Set("Content-Type", "application/jsonl")
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+		w.Write(line)
+		w.Write([]byte("\n"))
+	})
+	switch {
+	case status != http.StatusOK && !started:
+		writeResponse(w, status, response)
+	case !started:
+		w.Header().Set("Content-Type", "application/jsonl")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// importDBHandler handles requests to replace a database with the contents of a JSONL dump.
+// Validates the URL path and the bearer token before importing the body.
+func (dbh *DbHarness) importDBHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	if patherr := validateUrl(r.URL.Path); patherr != nil {
+		errmsg, _ := json.Marshal(patherr.Error())
+		writeResponse(w, http.StatusBadRequest, errmsg)
+		return
+	}
+	token, err := extractToken(r.Header)
+	if err != nil {
+		errmsg, _ := json.Marshal("Missing or invalid bearer token")
+		writeResponse(w, http.StatusUnauthorized, errmsg)
+		return
+	}
+	user, autherr := dbh.auth.ValidateSession(token)
+	if autherr != nil {
+		errmsg, _ := json.Marshal(autherr.Error())
+		writeResponse(w, http.StatusUnauthorized, errmsg)
+		return
+	}
+	if !dbh.permit(w, user, "/"+r.PathValue("resource"), auth.Admin) {
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		errmsg, _ := json.Marshal(err.Error())
+		writeResponse(w, http.StatusBadRequest, errmsg)
+		return
+	}
+
+	response, status, uri := dbh.rc.ImportDB(r.PathValue("resource"), body, user)
+	if status == http.StatusCreated {
+		w.Header().Set("Location", uri)
+	}
+	writeResponse(w, status, response)
+}
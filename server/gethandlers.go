@@ -1,26 +1,27 @@
-// This package supports document and collection retrieval, streaming server-sent events (SSE) for subscribers, 
+// This package supports document and collection retrieval, streaming server-sent events (SSE) for subscribers,
 // and request preprocessing for validation and token extraction.
 package server
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"strings"
-	"time"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/auth"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/precondition"
 )
 
-// writeFlusher is an interface that composes http.ResponseWriter and http.Flusher. 
+// writeFlusher is an interface that composes http.ResponseWriter and http.Flusher.
 // It is used for Server-Sent Events (SSE) to send event data and flush/send the response to the client.
 type writeFlusher interface {
-	http.ResponseWriter // write response to client 
-	http.Flusher //  flush response to client
+	http.ResponseWriter // write response to client
+	http.Flusher        //  flush response to client
 }
 
 // getHandler is responsible for dispatching GET requests based on the URL path structure.
-// If the path represents a document, it delegates to getDocHandler. If it represents a collection, 
+// If the path represents a document, it delegates to getDocHandler. If it represents a collection,
 // it delegates to getColHandler. If the path is malformed, it returns a 400 Bad Request response.
 func (dbh *DbHarness) getHandler(w http.ResponseWriter, r *http.Request) {
 	resource := r.PathValue("resource")
@@ -31,11 +32,21 @@ func (dbh *DbHarness) getHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	splitPath := strings.Split(resource, "/")
 	if len(splitPath) == 1 {
+		if r.URL.Query().Has("export") {
+			dbh.exportDBHandler(w, r)
+			return
+		}
+		if r.URL.Query().Has("changes") {
+			dbh.getChangesHandler(w, r)
+			return
+		}
 		dbh.getDocHandler(w, r)
 		return
 	}
 	if strings.HasSuffix(resource, "/") {
 		dbh.getColHandler(w, r)
+	} else if qs := r.URL.Query(); qs.Has("history") || qs.Has("version") || qs.Has("asOf") {
+		dbh.getDocHistoryHandler(w, r)
 	} else {
 		dbh.getDocHandler(w, r)
 	}
@@ -60,12 +71,15 @@ func (dbh *DbHarness) getDocHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, autherr := dbh.auth.ValidateSession(token)
+	user, autherr := dbh.auth.ValidateSession(token)
 	if autherr != nil {
 		errmsg, _ := json.Marshal(autherr.Error())
 		writeResponse(w, http.StatusUnauthorized, errmsg)
 		return
 	}
+	if !dbh.permit(w, user, "/"+r.PathValue("resource"), auth.Reader) {
+		return
+	}
 	qs := r.URL.Query()
 	mode := qs.Get("mode")
 	subscribe := (mode == "subscribe")
@@ -76,7 +90,13 @@ func (dbh *DbHarness) getDocHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
-    // Parse the document path and validate
+	subtree, err := parseDepth(qs, subscribe)
+	if err != nil {
+		errmsg, _ := json.Marshal(err.Error())
+		writeResponse(w, http.StatusBadRequest, errmsg)
+		return
+	}
+	// Parse the document path and validate
 	path := r.PathValue("resource")
 	dtb, docpath := parseResourcePath(path)
 	err = validateDocPath(docpath)
@@ -90,58 +110,32 @@ func (dbh *DbHarness) getDocHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if subtree {
+		dbh.streamSubtree(w, r, token, user, dtb, docpath)
+		return
+	}
 	// Retrieve the document and handle the response or subscription
-	response, status, subChan, _, docEv := dbh.rg.GetDoc(dtb, docpath, subscribe)
+	response, status, subChan, subId, docEv := dbh.rg.GetDoc(dtb, docpath, subscribe, r.Header.Get("Last-Event-ID"))
 	if status != http.StatusOK {
 		writeResponse(w, status, response)
 		return
 	}
-	// If subscription is requested, send events via SSE
-	if subscribe && status == http.StatusOK {
-		wf, ok := w.(writeFlusher)
-		if !ok {
-			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+	// Tag a plain read with the document's version, which need not be sent again if the client already holds it
+	if version, found := documentVersion(response); found && !subscribe {
+		w.Header().Set("ETag", precondition.ETag(version))
+		w.Header().Set("Access-Control-Expose-Headers", "ETag")
+		if requestConditions(r.Header).NotModified(version) {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			w.WriteHeader(http.StatusNotModified)
 			return
 		}
-		wf.Header().Set("Content-Type", "text/event-stream")
-		wf.Header().Set("Cache-Control", "no-cache")
-		wf.Header().Set("Connection", "keep-alive")
-		wf.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Last-Event-ID")
-		wf.Header().Set("Access-Control-Allow-Origin", "*")
-		wf.WriteHeader(http.StatusOK)
-		wf.Flush()
-		var evt bytes.Buffer
-		evt.WriteString(string(docEv))
-
-		slog.Info("Sending", "msg", evt.String())
-
-		// Continuously send events or keep the connection alive
-		wf.Write(evt.Bytes())
-		wf.Flush()
-		ticker := time.NewTicker(15 * time.Second)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-ticker.C:
-				var evt bytes.Buffer
-				evt.WriteString(":keep-alive\n\n")
-				wf.Write(evt.Bytes())
-				wf.Flush()
-			case <-r.Context().Done():
-
-				continue
-			case event := <-*subChan:
-				var evt bytes.Buffer
-				evt.WriteString(string(event))
-
-				slog.Info("Sending", "msg", evt.String())
-
-				// Send event
-				wf.Write(evt.Bytes())
-				wf.Flush()
-			}
-
-		}
+	}
+	// If subscription is requested, send events via SSE
+	if subscribe && status == http.StatusOK {
+		dbh.streamEvents(w, r, token, user, [][]byte{docEv}, subChan, func() {
+			dbh.rg.UnsubscribeDoc(dtb, docpath, subId)
+		})
+		return
 	}
 	writeResponse(w, status, response)
 }
@@ -167,12 +161,15 @@ func (dbh *DbHarness) getColHandler(w http.ResponseWriter, r *http.Request) {
 		writeResponse(w, http.StatusUnauthorized, emg)
 		return
 	}
-	_, autherr := dbh.auth.ValidateSession(token)
+	user, autherr := dbh.auth.ValidateSession(token)
 	if autherr != nil {
 		errmsg, _ := json.Marshal(autherr.Error())
 		writeResponse(w, http.StatusUnauthorized, errmsg)
 		return
 	}
+	if !dbh.permit(w, user, "/"+r.PathValue("resource"), auth.Reader) {
+		return
+	}
 	path := r.PathValue("resource")
 	dtb, colpath := parseResourcePath(path)
 
@@ -211,63 +208,70 @@ func (dbh *DbHarness) getColHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
+	subtree, err := parseDepth(qs, subscribe)
+	if err != nil {
+		errmsg, _ := json.Marshal(err.Error())
+		writeResponse(w, http.StatusBadRequest, errmsg)
+		return
+	}
+	if subtree && bounds != "" {
+		errmsg, _ := json.Marshal("interval is not supported on subtree subscriptions")
+		writeResponse(w, http.StatusBadRequest, errmsg)
+		return
+	}
+
+	opts, err := parseQueryOptions(qs)
+	if err != nil {
+		errmsg, _ := json.Marshal(err.Error())
+		writeResponse(w, http.StatusBadRequest, errmsg)
+		return
+	}
+	fields, err := parseFields(qs)
+	if err != nil {
+		errmsg, _ := json.Marshal(err.Error())
+		writeResponse(w, http.StatusBadRequest, errmsg)
+		return
+	}
+	if subtree && opts.Filter != nil {
+		errmsg, _ := json.Marshal("filter is not supported on subtree subscriptions")
+		writeResponse(w, http.StatusBadRequest, errmsg)
+		return
+	}
+	if subscribe && (opts.Limit > 0 || opts.After != "") {
+		errmsg, _ := json.Marshal("limit and cursor are not supported on subscriptions")
+		writeResponse(w, http.StatusBadRequest, errmsg)
+		return
+	}
+	if subscribe && (opts.Sort != nil || fields != nil) {
+		errmsg, _ := json.Marshal("sort and fields are not supported on subscriptions")
+		writeResponse(w, http.StatusBadRequest, errmsg)
+		return
+	}
+	if subtree {
+		dbh.streamSubtree(w, r, token, user, dtb, colpath)
+		return
+	}
+	limit := opts.Limit
+	if limit > 0 {
+		opts.Limit++ //the extra document tells whether another page follows
+	}
 
 	lower, upper := parseBounds(bounds)
 	slog.Debug(fmt.Sprintf("These are the bounds received: %s lower, %s upper", lower, upper))
-	b, status, subChan, _, docEvents := dbh.rg.GetCol(dtb, colpath, lower, upper, subscribe)
+	b, status, subChan, subId, docEvents := dbh.rg.GetCol(dtb, colpath, lower, upper, subscribe, opts, r.Header.Get("Last-Event-ID"))
 	slog.Debug(fmt.Sprintf("%d", status))
 	if subscribe && status == http.StatusOK { //subscription request
-		wf, ok := w.(writeFlusher)
 		slog.Debug(fmt.Sprintf("Beginning sse connection, payload is %s", string(b)))
-		if !ok {
-			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
-			return
+		dbh.streamEvents(w, r, token, user, docEvents, subChan, func() {
+			dbh.rg.UnsubscribeCol(dtb, colpath, subId)
+		})
+	} else { //not a subscription request
+		if status == http.StatusOK && limit > 0 {
+			b = paginate(w, r.URL, limit, opts, b)
 		}
-		wf.Header().Set("Content-Type", "text/event-stream")
-		wf.Header().Set("Cache-Control", "no-cache")
-		wf.Header().Set("Connection", "keep-alive")
-		wf.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Last-Event-ID")
-		wf.Header().Set("Access-Control-Allow-Origin", "*")
-		wf.WriteHeader(http.StatusOK)
-		wf.Flush()
-		ticker := time.NewTicker(15 * time.Second) //keep-alive comments
-		defer ticker.Stop()
-		for _, ev := range docEvents { //writing each document as an SSE
-			var evt bytes.Buffer
-			evt.WriteString(string(ev))
-
-			slog.Info("Sending", "msg", evt.String())
-
-			// Send event
-			wf.Write(evt.Bytes())
-			wf.Flush()
+		if status == http.StatusOK && fields != nil {
+			b = project(fields, b)
 		}
-		for {
-			select {
-
-			case <-ticker.C: //send a comment
-				var evt bytes.Buffer
-				evt.WriteString(":keep-alive\n\n")
-				wf.Write(evt.Bytes())
-				wf.Flush()
-
-			case <-r.Context().Done():
-
-				continue
-			case event := <-*subChan:
-				var evt bytes.Buffer
-				evt.WriteString(string(event))
-
-				slog.Info("Sending", "msg", evt.String())
-
-				// Send event
-				wf.Write(evt.Bytes())
-				wf.Flush()
-			}
-
-		}
-
-	} else { //not a subscription request
 		writeResponse(w, status, b)
 	}
 
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/auth"
+)
+
+// forbidden describes the role user lacks on the resource at path
+func forbidden(user string, path string, role auth.Role) string {
+	return fmt.Sprintf("%s does not hold the %s role on %s", user, role, path)
+}
+
+// permit checks that user holds at least role on the resource at path, such as "/db/doc/col/", answering with 403
+// if they do not.
+// Returns false if they do not
+func (dbh *DbHarness) permit(w http.ResponseWriter, user string, path string, role auth.Role) bool {
+	if dbh.users.Role(user, path) >= role {
+		return true
+	}
+	errmsg, _ := json.Marshal(forbidden(user, path, role))
+	writeResponse(w, http.StatusForbidden, errmsg)
+	return false
+}
+
+// authorize validates the bearer token of r, answering with 401 if it is missing or invalid, and checks that its
+// user holds at least role on the resource at path, answering with 403 if they do not.
+// Returns the user, and false if it answered
+func (dbh *DbHarness) authorize(w http.ResponseWriter, r *http.Request, path string, role auth.Role) (string, bool) {
+	token, err := extractToken(r.Header)
+	if err != nil {
+		errmsg, _ := json.Marshal("Missing or invalid bearer token")
+		writeResponse(w, http.StatusUnauthorized, errmsg)
+		return "", false
+	}
+	user, autherr := dbh.auth.ValidateSession(token)
+	if autherr != nil {
+		errmsg, _ := json.Marshal(autherr.Error())
+		writeResponse(w, http.StatusUnauthorized, errmsg)
+		return "", false
+	}
+	return user, dbh.permit(w, user, path, role)
+}
+
+// writeGrantError answers a request to manage the grants of a user that failed with err
+func writeGrantError(w http.ResponseWriter, err error) {
+	if errors.Is(err, auth.ErrNoGrant) {
+		errmsg, _ := json.Marshal(err.Error())
+		writeResponse(w, http.StatusNotFound, errmsg)
+		return
+	}
+	writeUserError(w, err)
+}
+
+// getGrantsHandler handles requests made to list the roles granted to a user.
+// Only administrators of the server and the user themselves may list them.
+func (dbh *DbHarness) getGrantsHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	username := r.PathValue("username")
+	token, err := extractToken(r.Header)
+	if err != nil {
+		errmsg, _ := json.Marshal("Missing or invalid bearer token")
+		writeResponse(w, http.StatusUnauthorized, errmsg)
+		return
+	}
+	user, autherr := dbh.auth.ValidateSession(token)
+	if autherr != nil {
+		errmsg, _ := json.Marshal(autherr.Error())
+		writeResponse(w, http.StatusUnauthorized, errmsg)
+		return
+	}
+	if user != username && !dbh.users.IsAdmin(user) {
+		errmsg, _ := json.Marshal("Only administrators may list the grants of other users")
+		writeResponse(w, http.StatusForbidden, errmsg)
+		return
+	}
+	grants, err := dbh.users.Grants(username)
+	if err != nil {
+		writeGrantError(w, err)
+		return
+	}
+	response, _ := json.Marshal(grants)
+	writeResponse(w, http.StatusOK, response)
+}
+
+// putGrantHandler handles requests made to grant a user a role on a path, whose body holds the path and the role,
+// replacing the role granted on that same path if any.
+// Only users holding the admin role on the path may grant roles on it.
+func (dbh *DbHarness) putGrantHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		errmsg, _ := json.Marshal("unable to read the request body")
+		writeResponse(w, http.StatusBadRequest, errmsg)
+		return
+	}
+	var grant auth.Grant
+	if err = json.Unmarshal(body, &grant); err != nil {
+		errmsg, _ := json.Marshal("malformed grant: " + err.Error())
+		writeResponse(w, http.StatusBadRequest, errmsg)
+		return
+	}
+	if err = auth.ValidateGrantPath(grant.Path); err != nil {
+		errmsg, _ := json.Marshal(err.Error())
+		writeResponse(w, http.StatusBadRequest, errmsg)
+		return
+	}
+	if _, ok := dbh.authorize(w, r, grant.Path, auth.Admin); !ok {
+		return
+	}
+	if err = dbh.users.SetGrant(r.PathValue("username"), grant.Path, grant.Role); err != nil {
+		writeGrantError(w, err)
+		return
+	}
+	writeResponse(w, http.StatusNoContent, nil)
+}
+
+// deleteGrantHandler handles requests made to revoke the role granted to a user on the path given by the path
+// parameter. Roles granted above or beneath that path are kept.
+// Only users holding the admin role on the path may revoke roles on it.
+func (dbh *DbHarness) deleteGrantHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	path := r.URL.Query().Get("path")
+	if err := auth.ValidateGrantPath(path); err != nil {
+		errmsg, _ := json.Marshal(err.Error())
+		writeResponse(w, http.StatusBadRequest, errmsg)
+		return
+	}
+	if _, ok := dbh.authorize(w, r, path, auth.Admin); !ok {
+		return
+	}
+	if err := dbh.users.RemoveGrant(r.PathValue("username"), path); err != nil {
+		writeGrantError(w, err)
+		return
+	}
+	writeResponse(w, http.StatusNoContent, nil)
+}
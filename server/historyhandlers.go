@@ -0,0 +1,104 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/auth"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/history"
+)
+
+// getDocHistoryHandler handles requests made to read the history of a document: ?history lists the versions kept,
+// while ?version=N and ?asOf=<unixms> read the document as it was at a single version.
+// Validates the URL path, the bearer token, the document path and the query parameters before reading.
+func (dbh *DbHarness) getDocHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	dtb, docpath, _, sel, ok := dbh.preprocessHistoryRequest(w, r, auth.Reader)
+	if !ok {
+		return
+	}
+	if r.URL.Query().Has("history") && !sel.IsZero() {
+		errmsg, _ := json.Marshal("history cannot be combined with version or asOf")
+		writeResponse(w, http.StatusBadRequest, errmsg)
+		return
+	}
+	response, status := dbh.rg.GetDocHistory(dtb, docpath, sel)
+	writeResponse(w, status, response)
+}
+
+// restoreDocHandler handles requests made to restore a document to an earlier version, picked by ?version=N or
+// ?asOf=<unixms>. The contents of that version are written back as a new version, as a PUT would, so the history
+// keeps every version in between.
+// Validates the URL path, the bearer token, the document path and the query parameters before restoring.
+func (dbh *DbHarness) restoreDocHandler(w http.ResponseWriter, r *http.Request) {
+	dtb, docpath, user, sel, ok := dbh.preprocessHistoryRequest(w, r, auth.Writer)
+	if !ok {
+		return
+	}
+	if sel.IsZero() {
+		errmsg, _ := json.Marshal("restore requires a version or asOf parameter")
+		writeResponse(w, http.StatusBadRequest, errmsg)
+		return
+	}
+	response, status := dbh.rg.GetDocHistory(dtb, docpath, sel)
+	if status != http.StatusOK {
+		writeResponse(w, status, response)
+		return
+	}
+	var old struct {
+		Doc json.RawMessage `json:"doc"`
+	}
+	if err := json.Unmarshal(response, &old); err != nil {
+		errmsg, _ := json.Marshal("unable to read the version to restore")
+		writeResponse(w, http.StatusInternalServerError, errmsg)
+		return
+	}
+
+	splitPath := strings.Split(docpath, "/")
+	response, status, uri := dbh.rc.PutDoc(dtb, docpath, splitPath[len(splitPath)-1], old.Doc, true, user, requestConditions(r.Header))
+	if status == http.StatusCreated || status == http.StatusOK {
+		w.Header().Set("Location", uri)
+	}
+	writeResponse(w, status, response)
+}
+
+// preprocessHistoryRequest validates the URL path, the bearer token and the document path of a request made to the
+// history of a document, checks that its user holds at least role on the document, and parses the version it
+// selects. Writes an error response if any of these fail.
+// Returns the database, the document path, the user, the selector and whether the request should proceed
+func (dbh *DbHarness) preprocessHistoryRequest(w http.ResponseWriter, r *http.Request, role auth.Role) (string, string, string, history.Selector, bool) {
+	defer r.Body.Close()
+	var sel history.Selector
+	if patherr := validateUrl(r.URL.Path); patherr != nil {
+		errmsg, _ := json.Marshal(patherr.Error())
+		writeResponse(w, http.StatusBadRequest, errmsg)
+		return "", "", "", sel, false
+	}
+	token, err := extractToken(r.Header)
+	if err != nil {
+		errmsg, _ := json.Marshal("Missing or invalid bearer token")
+		writeResponse(w, http.StatusUnauthorized, errmsg)
+		return "", "", "", sel, false
+	}
+	user, autherr := dbh.auth.ValidateSession(token)
+	if autherr != nil {
+		errmsg, _ := json.Marshal(autherr.Error())
+		writeResponse(w, http.StatusUnauthorized, errmsg)
+		return "", "", "", sel, false
+	}
+	if !dbh.permit(w, user, "/"+r.PathValue("resource"), role) {
+		return "", "", "", sel, false
+	}
+	dtb, docpath := parseResourcePath(r.PathValue("resource"))
+	if err = validateDocPath(docpath); err != nil {
+		errmsg, _ := json.Marshal(err.Error())
+		writeResponse(w, http.StatusBadRequest, errmsg)
+		return "", "", "", sel, false
+	}
+	if sel, err = history.ParseSelector(r.URL.Query()); err != nil {
+		errmsg, _ := json.Marshal(err.Error())
+		writeResponse(w, http.StatusBadRequest, errmsg)
+		return "", "", "", sel, false
+	}
+	return dtb, docpath, user, sel, true
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/auth"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/fieldIndex"
+)
+
+// putIndexHandler handles requests to create a secondary index on a collection, the indexed field being named by
+// the JSON pointer held in the index parameter. The index is unique if the unique parameter is given.
+// It validates the collection path and the bearer token before creating the index.
+func (dbh *DbHarness) putIndexHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	dtb, colpath, ok := dbh.preprocessIndexRequest(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	def := fieldIndex.Definition{Field: query.Get("index"), Unique: query.Has("unique") && query.Get("unique") != "false"}
+	resp, stat, uri := dbh.rc.PutIndex(dtb, colpath, def)
+	if stat == http.StatusCreated {
+		w.Header().Set("Location", uri)
+	}
+	writeResponse(w, stat, resp)
+}
+
+// deleteIndexHandler handles requests to drop the secondary index on the field named by the index parameter.
+// It validates the collection path and the bearer token before dropping the index.
+func (dbh *DbHarness) deleteIndexHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	dtb, colpath, ok := dbh.preprocessIndexRequest(w, r)
+	if !ok {
+		return
+	}
+	resp, stat := dbh.rd.DeleteIndex(dtb, colpath, r.URL.Query().Get("index"))
+	writeResponse(w, stat, resp)
+}
+
+// preprocessIndexRequest validates the path and bearer token of a request on the indices of a collection, and checks
+// that its user holds the admin role on the collection, writing an error response if any of these fail.
+// Returns the database and collection path the request is made on, and whether the request may proceed
+func (dbh *DbHarness) preprocessIndexRequest(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	if patherr := validateUrl(r.URL.Path); patherr != nil {
+		errmsg, _ := json.Marshal(patherr.Error())
+		writeResponse(w, http.StatusBadRequest, errmsg)
+		return "", "", false
+	}
+	dtb, colpath := parseResourcePath(r.PathValue("resource"))
+	if colpath != "" { //an empty path names the top-level collection of the database
+		if err := validatePutColPath(colpath); err != nil {
+			errmsg, _ := json.Marshal(err.Error())
+			writeResponse(w, http.StatusBadRequest, errmsg)
+			return "", "", false
+		}
+	}
+	token, err := extractToken(r.Header)
+	if err != nil {
+		errmsg, _ := json.Marshal("Missing or invalid bearer token")
+		writeResponse(w, http.StatusUnauthorized, errmsg)
+		return "", "", false
+	}
+	user, autherr := dbh.auth.ValidateSession(token)
+	if autherr != nil {
+		errmsg, _ := json.Marshal(autherr.Error())
+		writeResponse(w, http.StatusUnauthorized, errmsg)
+		return "", "", false
+	}
+	if !dbh.permit(w, user, "/"+r.PathValue("resource"), auth.Admin) {
+		return "", "", false
+	}
+	return dtb, colpath, true
+}
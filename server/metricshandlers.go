@@ -0,0 +1,37 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/auth"
+)
+
+// metricsHandler handles requests made to read the metrics of the server, such as the number of events dropped
+// because a subscriber fell behind.
+// Validates the bearer token, and that its user holds the reader role on every database, before reading.
+func (dbh *DbHarness) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	token, err := extractToken(r.Header)
+	if err != nil {
+		errmsg, _ := json.Marshal("Missing or invalid bearer token")
+		writeResponse(w, http.StatusUnauthorized, errmsg)
+		return
+	}
+	user, autherr := dbh.auth.ValidateSession(token)
+	if autherr != nil {
+		errmsg, _ := json.Marshal(autherr.Error())
+		writeResponse(w, http.StatusUnauthorized, errmsg)
+		return
+	}
+	if !dbh.permit(w, user, "/", auth.Reader) {
+		return
+	}
+	response, err := json.Marshal(map[string]json.Marshaler{"subscriptions": dbh.metrics})
+	if err != nil {
+		errmsg, _ := json.Marshal("unable to read the metrics")
+		writeResponse(w, http.StatusInternalServerError, errmsg)
+		return
+	}
+	writeResponse(w, http.StatusOK, response)
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/query"
+)
+
+// cursor is the position a paged collection read resumes from; it travels to clients as an opaque token
+type cursor struct {
+	After string          `json:"after"`           // the name of the last document returned
+	Value json.RawMessage `json:"value,omitempty"` // in sorted reads, the value the last document was sorted by
+}
+
+// encodeCursor returns the opaque token for c
+func encodeCursor(c cursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor parses an opaque token returned by encodeCursor.
+// Returns the cursor, or an error if the token is malformed
+func decodeCursor(token string) (cursor, error) {
+	var c cursor
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || json.Unmarshal(b, &c) != nil || c.After == "" {
+		return c, fmt.Errorf("malformed cursor")
+	}
+	return c, nil
+}
+
+// parseLimit parses the limit query parameter.
+// Returns the limit (0 if absent), or an error if it is not a positive integer
+func parseLimit(qs url.Values) (int, error) {
+	if !qs.Has("limit") {
+		return 0, nil
+	}
+	limit, err := strconv.Atoi(qs.Get("limit"))
+	if err != nil || limit <= 0 {
+		return 0, fmt.Errorf("malformed limit parameter")
+	}
+	return limit, nil
+}
+
+// paginate trims a collection read fetched with opts, asking for one document more than limit, down to limit
+// documents, setting a Link header pointing at the next page if the extra document was found.
+// Returns the trimmed payload
+func paginate(w http.ResponseWriter, requested *url.URL, limit int, opts query.Options, payload []byte) []byte {
+	var docs []json.RawMessage
+	if err := json.Unmarshal(payload, &docs); err != nil || len(docs) <= limit {
+		return payload
+	}
+	docs = docs[:limit]
+	var next cursor
+	next.After, next.Value = opts.Position(docs[limit-1])
+
+	qs := requested.Query()
+	qs.Set("cursor", encodeCursor(next))
+	link := url.URL{Path: requested.Path, RawQuery: qs.Encode()}
+	w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", link.String()))
+	w.Header().Set("Access-Control-Expose-Headers", "Link")
+
+	trimmed, _ := json.Marshal(docs)
+	return trimmed
+}
+
+// project reduces the contents of every document of a collection read to the values selected by fields.
+// Returns the projected payload
+func project(fields *query.Projection, payload []byte) []byte {
+	var docs []json.RawMessage
+	if err := json.Unmarshal(payload, &docs); err != nil {
+		return payload
+	}
+	for i, doc := range docs {
+		docs[i] = fields.Apply(doc)
+	}
+	projected, _ := json.Marshal(docs)
+	return projected
+}
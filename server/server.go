@@ -2,44 +2,72 @@
 package server
 
 import (
+	"context"
+	"encoding/json"
 	"log/slog"
 	"net/http"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/fieldIndex"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/history"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/precondition"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/query"
 )
 
 // resourceCreator is an interface that defines the methods for creating resources in OwlDB.
 type resourceCreator interface {
-	PostDoc(dbName string, colpath string, user string, payload []byte) ([]byte, int, string) //PostDoc should create a new document in the collection at the provided path
-	PutDoc(dbName string, docpath string, docname string, payload []byte, overwrite bool, user string) ([]byte, int, string) // PutDoc should create a new document at the provided path
-	PutCol(dtb string, colpath string) ([]byte, int, string) //PutCol should create a new collection at the provided path
-	CreateDB(dbName string) ([]byte, int, string) // CreateDB should create a new database with the provided name
+	PostDoc(dbName string, colpath string, user string, payload []byte) ([]byte, int, string)                                                              //PostDoc should create a new document in the collection at the provided path
+	PutDoc(dbName string, docpath string, docname string, payload []byte, overwrite bool, user string, cond precondition.Conditions) ([]byte, int, string) // PutDoc should create a new document at the provided path, if the document it replaces satisfies cond
+	PutCol(dtb string, colpath string, user string) ([]byte, int, string)                                                                                  //PutCol should create a new collection at the provided path on behalf of user
+	CreateDB(dbName string, user string) ([]byte, int, string)                                                                                             // CreateDB should create a new database with the provided name on behalf of user
+	ImportDB(dbName string, dump []byte, user string) ([]byte, int, string)                                                                                // ImportDB should replace the database with the contents of a JSONL dump on behalf of user
+	PutIndex(dtb string, colpath string, def fieldIndex.Definition) ([]byte, int, string)                                                                  // PutIndex should create a secondary index on the collection at the provided path
+	Transact(dbName string, body []byte, user string) ([]byte, int)                                                                                        // Transact should apply the operations of the transaction held in body as a whole
 }
 
 // resourceGetter is an interface that defines the methods for retrieving resources from OwlDB.
 type resourceGetter interface {
-	GetDoc(dtb string, pathstr string, subscription bool) (response []byte, statCode int, subCh *chan []byte, id string, docEvent []byte) //GetDoc should retrieve the document at the provided path
+	GetDoc(dtb string, pathstr string, subscription bool, lastEventID string) (response []byte, statCode int, subCh *chan []byte, id string, docEvent []byte) //GetDoc should retrieve the document at the provided path, resuming a subscription from the event lastEventID if given
+
+	GetCol(dtb string, colpath string, lower string, upper string, mode bool, opts query.Options, lastEventID string) (payload []byte, statCode int, subChan *chan []byte, subId string, docEvents [][]byte) //GetCol should retrieve the collection at the provided path, keeping the documents selected by opts, resuming a subscription from the event lastEventID if given
+
+	GetDocHistory(dtb string, docpath string, sel history.Selector) ([]byte, int) //GetDocHistory should list the versions kept of the document at the provided path, or read the version sel picks
+
+	ExportDB(ctx context.Context, dtb string, write func(line []byte)) ([]byte, int) //ExportDB should write every collection and document of the database as a line of JSON
 
-	GetCol(dtb string, colpath string, lower string, upper string, mode bool) (payload []byte, statCode int, subChan *chan []byte, subId string, docEvents [][]byte) //GetCol should retrieve the collection at the provided path
+	UnsubscribeDoc(dtb string, docpath string, subId string) //UnsubscribeDoc should end the subscription subId to the document at the provided path
+
+	UnsubscribeCol(dtb string, colpath string, subId string) //UnsubscribeCol should end the subscription subId to the collection at the provided path
+
+	SubscribeSubtree(dtb string, path string, lastEventID string) (payload []byte, statCode int, subChan *chan []byte, subId string, events [][]byte) //SubscribeSubtree should subscribe to every document and collection beneath the provided path, a collection if it ends with "/", resuming from the event lastEventID if given
+
+	UnsubscribeSubtree(dtb string, path string, subId string) //UnsubscribeSubtree should end the subscription subId to the subtree at the provided path
 }
 
 // resourceDeleter is an interface that defines the methods for deleting resources from OwlDB.
 type resourceDeleter interface {
-	DeleteCol(dtb string, colpath string) ([]byte, int) //DeleteCol should delete the collection at the provided path
-	DeleteDoc(dbName string, docpath string) ([]byte, int) //DeleteDoc should delete the document at the provided path
-	DeleteDB(dbName string) ([]byte, int) // DeleteDB should delete the database with the provided name
+	DeleteCol(dtb string, colpath string, user string) ([]byte, int)                                  //DeleteCol should delete the collection at the provided path on behalf of user
+	DeleteDoc(dbName string, docpath string, user string, cond precondition.Conditions) ([]byte, int) //DeleteDoc should delete the document at the provided path on behalf of user, if it satisfies cond
+	DeleteDB(dbName string, user string) ([]byte, int)                                                // DeleteDB should delete the database with the provided name on behalf of user
+	DeleteIndex(dtb string, colpath string, field string) ([]byte, int)                               // DeleteIndex should drop the secondary index on field from the collection at the provided path
 }
 
 // resourcePatcher is an interface that defines the methods for patching resources in OwlDB.
 type resourcePatcher interface {
-	PatchDoc(dtb string, docpath string, patches []byte, user string) ([]byte, int, string) //PatchDoc should apply the provided patches to the document at the provided path
+	PatchDoc(dtb string, docpath string, patches []byte, user string, mediaType string, cond precondition.Conditions) ([]byte, int, string) //PatchDoc should apply the provided patches, written in the dialect of mediaType, to the document at the provided path, if it satisfies cond
 }
 
 // DbHarness serves as a structure that exposes the resource services to HTTP endpoints
 type DbHarness struct {
-	rg   resourceGetter  //rg manages all requests to GET resources
-	rd   resourceDeleter //rd manages all requests to DELETE resources
-	rc   resourceCreator //rc manages all requests to PUT & POST resources
-	rp   resourcePatcher //rp manages all requests to PATCH resources
-	auth Authorizer      //auth manages all authorization mechanisms
+	rg      resourceGetter       //rg manages all requests to GET resources
+	rd      resourceDeleter      //rd manages all requests to DELETE resources
+	rc      resourceCreator      //rc manages all requests to PUT & POST resources
+	rp      resourcePatcher      //rp manages all requests to PATCH resources
+	auth    Authorizer           //auth manages all authorization mechanisms
+	metrics json.Marshaler       //metrics reports the counters of the subscription system
+	subs    *subscriptionTracker //subs keeps the subscriptions being streamed
+	hooks   webhookRegistry      //hooks manages the webhooks of the databases
+	feed    changeFeed           //feed serves the change feeds of the databases
+	users   credentialStore      //users checks the passwords of the users logging in and manages their accounts
 }
 
 // Authorizer encapsulates the necessary functionalities for authentication
@@ -50,22 +78,29 @@ type Authorizer interface {
 	Logout(token string) (bool, error)             //logs out
 }
 
-// New creates a new HTTP server, taking a resourceDeleter, resourceGetter, and resourceCreator
-func New(rd resourceDeleter, rg resourceGetter, rc resourceCreator, auth Authorizer, rp resourcePatcher) http.Handler {
+// New creates a new HTTP server, taking a resourceDeleter, resourceGetter, and resourceCreator, along with the
+// metrics served at /metrics, the registry of the webhooks served at /webhooks, the change feeds served at
+// /v1/{db}?changes and the accounts served at /users
+func New(rd resourceDeleter, rg resourceGetter, rc resourceCreator, auth Authorizer, rp resourcePatcher, metrics json.Marshaler, hooks webhookRegistry, feed changeFeed, users credentialStore) http.Handler {
 
 	dbharness := DbHarness{
-		rg:   rg,
-		rd:   rd,
-		rc:   rc,
-		rp:   rp,
-		auth: auth,
+		rg:      rg,
+		rd:      rd,
+		rc:      rc,
+		rp:      rp,
+		auth:    auth,
+		metrics: metrics,
+		subs:    newSubscriptionTracker(),
+		hooks:   hooks,
+		feed:    feed,
+		users:   users,
 	}
 
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("PUT /v1/{resource...}", dbharness.putHandler)
 	mux.HandleFunc("GET /v1/{resource...}", dbharness.getHandler)
-	mux.HandleFunc("POST /v1/{resource...}", dbharness.postDocHandler)
+	mux.HandleFunc("POST /v1/{resource...}", dbharness.postHandler)
 	mux.HandleFunc("PATCH /v1/{resource...}", dbharness.patchDocHanlder)
 	mux.HandleFunc("DELETE /v1/{resource...}", dbharness.deleteHandler)
 
@@ -73,6 +108,21 @@ func New(rd resourceDeleter, rg resourceGetter, rc resourceCreator, auth Authori
 	mux.HandleFunc("OPTIONS /v1/", optionsHandler)
 	mux.HandleFunc("POST /auth", dbharness.loginhandler)
 	mux.HandleFunc("DELETE /auth", dbharness.logoutHandler)
+	mux.HandleFunc("GET /metrics", dbharness.metricsHandler)
+	mux.HandleFunc("GET /subscriptions", dbharness.getSubscriptionsHandler)
+	mux.HandleFunc("DELETE /subscriptions/{id}", dbharness.deleteSubscriptionHandler)
+	mux.HandleFunc("GET /ws", dbharness.websocketHandler)
+	mux.HandleFunc("POST /webhooks/{db}", dbharness.postWebhookHandler)
+	mux.HandleFunc("GET /webhooks/{db}", dbharness.getWebhooksHandler)
+	mux.HandleFunc("DELETE /webhooks/{db}/{id}", dbharness.deleteWebhookHandler)
+	mux.HandleFunc("GET /webhooks/{db}/dead-letters", dbharness.getDeadLettersHandler)
+	mux.HandleFunc("POST /users", dbharness.postUserHandler)
+	mux.HandleFunc("GET /users", dbharness.getUsersHandler)
+	mux.HandleFunc("DELETE /users/{username}", dbharness.deleteUserHandler)
+	mux.HandleFunc("PUT /users/{username}/password", dbharness.putPasswordHandler)
+	mux.HandleFunc("GET /users/{username}/grants", dbharness.getGrantsHandler)
+	mux.HandleFunc("PUT /users/{username}/grants", dbharness.putGrantHandler)
+	mux.HandleFunc("DELETE /users/{username}/grants", dbharness.deleteGrantHandler)
 
 	return requestPreprocessor(mux)
 }
@@ -83,7 +133,7 @@ func optionsHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Allow", "GET, POST, PUT, DELETE, OPTIONS, PATCH")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS, PATCH")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, If-Match, If-None-Match")
 
 	w.WriteHeader(http.StatusOK)
 }
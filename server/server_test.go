@@ -1,50 +1,81 @@
 package server
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"runtime"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/auth"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/changes"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/fieldIndex"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/history"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/precondition"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/query"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/webhook"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/websocket"
 )
 
 type mockResourceDeleter struct {
-	didDeleteCol bool
-	didDeleteDoc bool
-	didDeleteDB  bool
+	didDeleteCol   bool
+	didDeleteDoc   bool
+	didDeleteDB    bool
+	didDeleteIndex bool
+	cond           precondition.Conditions
 }
 
-func (m *mockResourceDeleter) DeleteCol(dtb string, colpath string) ([]byte, int) {
+func (m *mockResourceDeleter) DeleteCol(dtb string, colpath string, user string) ([]byte, int) {
 	m.didDeleteCol = true
 	return nil, 204
 }
 
-func (m *mockResourceDeleter) DeleteDoc(dbName string, docpath string) ([]byte, int) {
+func (m *mockResourceDeleter) DeleteDoc(dbName string, docpath string, user string, cond precondition.Conditions) ([]byte, int) {
 	m.didDeleteDoc = true
+	m.cond = cond
 	return nil, 204
 }
 
-func (m *mockResourceDeleter) DeleteDB(dbName string) ([]byte, int) {
+func (m *mockResourceDeleter) DeleteDB(dbName string, user string) ([]byte, int) {
 	m.didDeleteDB = true
 	return nil, 204
 }
 
+func (m *mockResourceDeleter) DeleteIndex(dtb string, colpath string, field string) ([]byte, int) {
+	m.didDeleteIndex = true
+	return nil, 204
+}
+
 type mockResourceGetter struct {
-	didGetDoc bool
-	didGetCol bool
+	didGetDoc    bool
+	didGetCol    bool
+	didExportDB  bool
+	sel          history.Selector
+	subChan      chan []byte  // the channel subscriptions are served from, if not nil
+	unsubscribed atomic.Int32 // the number of subscriptions ended
+	subtree      string       // the path of the last subtree subscribed to
 }
 
-func (m *mockResourceGetter) GetDoc(dtb string, pathstr string, subscription bool) (response []byte, statCode int, subCh *chan []byte, id string, docEvent []byte) {
+func (m *mockResourceGetter) GetDoc(dtb string, pathstr string, subscription bool, lastEventID string) (response []byte, statCode int, subCh *chan []byte, id string, docEvent []byte) {
 	m.didGetDoc = true
 	if subscription {
-		ch := make(chan []byte)
+		ch := m.subChan
+		if ch == nil {
+			ch = make(chan []byte)
+		}
 		return []byte("payload"), 200, &ch, "", nil
 	}
 	return nil, 200, nil, "", nil
 }
 
-func (m *mockResourceGetter) GetCol(dtb string, colpath string, lower string, upper string, mode bool) (payload []byte, statCode int, subChan *chan []byte, subId string, docEvents [][]byte) {
+func (m *mockResourceGetter) GetCol(dtb string, colpath string, lower string, upper string, mode bool, opts query.Options, lastEventID string) (payload []byte, statCode int, subChan *chan []byte, subId string, docEvents [][]byte) {
 	m.didGetCol = true
 	if mode {
 		ch := make(chan []byte)
@@ -54,12 +85,50 @@ func (m *mockResourceGetter) GetCol(dtb string, colpath string, lower string, up
 
 }
 
+func (m *mockResourceGetter) GetDocHistory(dtb string, docpath string, sel history.Selector) ([]byte, int) {
+	m.sel = sel
+	return []byte(`{"path":"/doc1","doc":{"a":1},"meta":{"version":1}}`), http.StatusOK
+}
+
+func (m *mockResourceGetter) ExportDB(ctx context.Context, dtb string, write func(line []byte)) ([]byte, int) {
+	m.didExportDB = true
+	write([]byte(`{"path":"/doc1/col1/"}`))
+	return nil, http.StatusOK
+}
+
+func (m *mockResourceGetter) UnsubscribeDoc(dtb string, docpath string, subId string) {
+	m.unsubscribed.Add(1)
+}
+
+func (m *mockResourceGetter) UnsubscribeCol(dtb string, colpath string, subId string) {
+	m.unsubscribed.Add(1)
+}
+
+func (m *mockResourceGetter) SubscribeSubtree(dtb string, path string, lastEventID string) (payload []byte, statCode int, subChan *chan []byte, subId string, events [][]byte) {
+	m.subtree = path
+	if path == "missing" {
+		errmsg, _ := json.Marshal("Document does not exist")
+		return errmsg, http.StatusNotFound, nil, "", nil
+	}
+	ch := m.subChan
+	if ch == nil {
+		ch = make(chan []byte)
+	}
+	return nil, http.StatusOK, &ch, "", [][]byte{[]byte("event: update\ndata: \"/" + path + "\"\n\n")}
+}
+
+func (m *mockResourceGetter) UnsubscribeSubtree(dtb string, path string, subId string) {
+	m.unsubscribed.Add(1)
+}
+
 type mockResourcePatcher struct {
 	didPatchDoc bool
+	mediaType   string
 }
 
-func (m *mockResourcePatcher) PatchDoc(dtb string, docpath string, patches []byte, user string) ([]byte, int, string) {
+func (m *mockResourcePatcher) PatchDoc(dtb string, docpath string, patches []byte, user string, mediaType string, cond precondition.Conditions) ([]byte, int, string) {
 	m.didPatchDoc = true
+	m.mediaType = mediaType
 	return []byte("hello"), http.StatusOK, ""
 }
 
@@ -68,6 +137,10 @@ type mockCreator struct {
 	didPutDoc   bool
 	didPutCol   bool
 	didCreateDB bool
+	didImportDB bool
+	didPutIndex bool
+	didTransact bool
+	putIndexCol string // the collection path of the last index created
 }
 
 func (m *mockCreator) PostDoc(dbName string, colpath string, user string, payload []byte) ([]byte, int, string) {
@@ -75,7 +148,7 @@ func (m *mockCreator) PostDoc(dbName string, colpath string, user string, payloa
 	return []byte("hello"), http.StatusCreated, "Posted Doc"
 }
 
-func (m *mockCreator) PutDoc(dbName string, docpath string, docname string, payload []byte, overwrite bool, user string) ([]byte, int, string) {
+func (m *mockCreator) PutDoc(dbName string, docpath string, docname string, payload []byte, overwrite bool, user string, cond precondition.Conditions) ([]byte, int, string) {
 	m.didPutDoc = true
 	if overwrite {
 		//simulates a replacement
@@ -86,46 +159,226 @@ func (m *mockCreator) PutDoc(dbName string, docpath string, docname string, payl
 	return []byte("hello"), http.StatusCreated, ""
 }
 
-func (m *mockCreator) PutCol(dtb string, colpath string) ([]byte, int, string) {
+func (m *mockCreator) PutCol(dtb string, colpath string, user string) ([]byte, int, string) {
 	m.didPutCol = true
 	return []byte("hello"), http.StatusCreated, ""
 }
 
-func (m *mockCreator) CreateDB(dbName string) ([]byte, int, string) {
+func (m *mockCreator) CreateDB(dbName string, user string) ([]byte, int, string) {
 	m.didCreateDB = true
 
 	return []byte("hello"), http.StatusCreated, ""
 }
 
+func (m *mockCreator) PutIndex(dtb string, colpath string, def fieldIndex.Definition) ([]byte, int, string) {
+	m.didPutIndex = true
+	m.putIndexCol = colpath
+	return []byte("hello"), http.StatusCreated, "/v1/" + dtb + "/" + strings.TrimSuffix(colpath, "/") + "/?index=" + url.QueryEscape(def.Field)
+}
+
+func (m *mockCreator) ImportDB(dbName string, dump []byte, user string) ([]byte, int, string) {
+	m.didImportDB = true
+	return []byte("hello"), http.StatusCreated, "/v1/" + dbName
+}
+
+func (m *mockCreator) Transact(dbName string, body []byte, user string) ([]byte, int) {
+	m.didTransact = true
+	return []byte("[]"), http.StatusOK
+}
+
 type mockAuthorizer struct {
-	didLogout          bool
-	didCreateSession   bool
-	didLogin           bool
-	didValidateSession bool
+	didLogout          atomic.Bool
+	didCreateSession   atomic.Bool
+	didLogin           atomic.Bool
+	didValidateSession atomic.Bool // set by the handlers of every request, some of which run concurrently
+	expired            atomic.Bool // whether every session has expired
 }
 
 func (m *mockAuthorizer) CreateSession(username string) (string, error) {
-	m.didCreateSession = true
+	m.didCreateSession.Store(true)
 	return "token", nil
 }
 
 func (m *mockAuthorizer) ValidateSession(token string) (string, error) {
-	m.didValidateSession = true
+	m.didValidateSession.Store(true)
+	if m.expired.Load() {
+		return "", fmt.Errorf("Missing or invalid bearer token")
+	}
 	return "user", nil
 }
 
 func (m *mockAuthorizer) Login(username string) (string, error) {
-	m.didLogin = true
+	m.didLogin.Store(true)
 	return "token", nil
 }
 
 func (m *mockAuthorizer) Logout(token string) (bool, error) {
-	m.didLogout = true
+	m.didLogout.Store(true)
 	return true, nil
 }
 
+type mockMetrics struct {
+}
+
+func (m *mockMetrics) MarshalJSON() ([]byte, error) {
+	return []byte(`{"dropped":3}`), nil
+}
+
+type mockWebhooks struct {
+	hooks []webhook.Hook
+}
+
+func (m *mockWebhooks) Register(dtb string, hook webhook.Hook) (webhook.Hook, error) {
+	if hook.URL == "" {
+		return webhook.Hook{}, fmt.Errorf("url must be an absolute http or https URL")
+	}
+	hook.Id = fmt.Sprint(len(m.hooks) + 1)
+	hook.Database = dtb
+	hook.Secret = ""
+	m.hooks = append(m.hooks, hook)
+	return hook, nil
+}
+
+func (m *mockWebhooks) List(dtb string) []webhook.Hook {
+	hooks := make([]webhook.Hook, 0)
+	for _, hook := range m.hooks {
+		if hook.Database == dtb {
+			hooks = append(hooks, hook)
+		}
+	}
+	return hooks
+}
+
+func (m *mockWebhooks) Remove(dtb string, id string) bool {
+	for i, hook := range m.hooks {
+		if hook.Database == dtb && hook.Id == id {
+			m.hooks = append(m.hooks[:i], m.hooks[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (m *mockWebhooks) DeadLetters(dtb string) []webhook.DeadLetter {
+	return []webhook.DeadLetter{{URL: "http://example.com", Attempts: 5, Event: webhook.Event{Database: dtb}}}
+}
+
+// mockFeed serves a change feed holding two changes of db24
+type mockFeed struct {
+	since int64
+}
+
+func (m *mockFeed) Epoch() string {
+	return "1"
+}
+
+func (m *mockFeed) Changes(dtb string, since int64) ([]changes.Change, error) {
+	m.since = since
+	if dtb != "db24" {
+		return nil, changes.ErrNoDatabase
+	}
+	if since > 2 {
+		return nil, fmt.Errorf("changes since %d are no longer available", since)
+	}
+	list := []changes.Change{{Seq: 1, Op: "createdb", Path: "/"}, {Seq: 2, Op: "putdoc", Path: "/doc1"}}
+	return list[since:], nil
+}
+
+func (m *mockFeed) Subscribe(dtb string, since int64) ([][]byte, *chan []byte, string, error) {
+	if _, err := m.Changes(dtb, since); err != nil {
+		return nil, nil, "", err
+	}
+	ch := make(chan []byte)
+	close(ch)
+	return [][]byte{[]byte("event: change\ndata: {}\nid: 2\n\n")}, &ch, "1", nil
+}
+
+func (m *mockFeed) Unsubscribe(dtb string, id string) {
+}
+
+type mockUsers struct {
+	notAdmin bool                 // whether the user of every session is a regular user
+	changed  string               // the account created, deleted or whose password was reset
+	roles    map[string]auth.Role // the roles of a regular user, by the exact path they apply to
+}
+
+func (m *mockUsers) Verify(username string, password string) error {
+	if password != "secret" {
+		return auth.ErrInvalidCredentials
+	}
+	return nil
+}
+
+func (m *mockUsers) IsAdmin(username string) bool {
+	return !m.notAdmin
+}
+
+func (m *mockUsers) List() []auth.User {
+	return []auth.User{{Username: "user", Admin: true}}
+}
+
+func (m *mockUsers) Create(username string, password string, admin bool) error {
+	if username == "user" {
+		return auth.ErrUserExists
+	}
+	if password == "" {
+		return fmt.Errorf("password is empty")
+	}
+	m.changed = username
+	return nil
+}
+
+func (m *mockUsers) Delete(username string) error {
+	if username != "bob" {
+		return auth.ErrNoUser
+	}
+	m.changed = username
+	return nil
+}
+
+func (m *mockUsers) Role(username string, path string) auth.Role {
+	if !m.notAdmin {
+		return auth.Admin
+	}
+	return m.roles[path]
+}
+
+func (m *mockUsers) Grants(username string) ([]auth.Grant, error) {
+	if username != "user" && username != "bob" {
+		return nil, auth.ErrNoUser
+	}
+	return []auth.Grant{{Path: "/db", Role: auth.Reader}}, nil
+}
+
+func (m *mockUsers) SetGrant(username string, path string, role auth.Role) error {
+	if username != "bob" {
+		return auth.ErrNoUser
+	}
+	m.changed = username
+	return nil
+}
+
+func (m *mockUsers) RemoveGrant(username string, path string) error {
+	if username != "bob" {
+		return auth.ErrNoUser
+	}
+	if path != "/db" {
+		return auth.ErrNoGrant
+	}
+	m.changed = username
+	return nil
+}
+
+func (m *mockUsers) SetPassword(username string, password string) error {
+	if username != "bob" {
+		return auth.ErrNoUser
+	}
+	m.changed = username
+	return nil
+}
+
 func setup() http.Handler {
-	return New(&mockResourceDeleter{}, &mockResourceGetter{}, &mockCreator{}, &mockAuthorizer{}, &mockResourcePatcher{})
+	return New(&mockResourceDeleter{}, &mockResourceGetter{}, &mockCreator{}, &mockAuthorizer{}, &mockResourcePatcher{}, &mockMetrics{}, &mockWebhooks{}, &mockFeed{}, &mockUsers{})
 }
 
 func TestGetDoc(t *testing.T) {
@@ -255,6 +508,149 @@ func TestPutDB(t *testing.T) {
 	}
 }
 
+func TestExportDB(t *testing.T) {
+	rg := &mockResourceGetter{}
+	srv := New(&mockResourceDeleter{}, rg, &mockCreator{}, &mockAuthorizer{}, &mockResourcePatcher{}, &mockMetrics{}, &mockWebhooks{}, &mockFeed{}, &mockUsers{})
+	r := httptest.NewRequest("GET", "/v1/db24?export=jsonl", nil)
+	r.Header.Set("Authorization", "Bearer ADMIN")
+	w := httptest.NewRecorder()
+
+	srv.ServeHTTP(w, r)
+
+	if w.Result().StatusCode != http.StatusOK || !rg.didExportDB || w.Body.String() != "{\"path\":\"/doc1/col1/\"}\n" {
+		t.Errorf("TestExportDB failed, got %d: %s", w.Result().StatusCode, w.Body.String())
+	}
+
+	r = httptest.NewRequest("GET", "/v1/db24?export=csv", nil)
+	r.Header.Set("Authorization", "Bearer ADMIN")
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	if w.Result().StatusCode != http.StatusBadRequest {
+		t.Errorf("TestExportDB failed, expected an unknown format to be rejected, got %d", w.Result().StatusCode)
+	}
+}
+
+func TestImportDB(t *testing.T) {
+	rc := &mockCreator{}
+	srv := New(&mockResourceDeleter{}, &mockResourceGetter{}, rc, &mockAuthorizer{}, &mockResourcePatcher{}, &mockMetrics{}, &mockWebhooks{}, &mockFeed{}, &mockUsers{})
+	r := httptest.NewRequest("POST", "/v1/db24?import", strings.NewReader(`{"path":"/doc1/col1/"}`))
+	r.Header.Set("Authorization", "Bearer ADMIN")
+	w := httptest.NewRecorder()
+
+	srv.ServeHTTP(w, r)
+
+	if w.Result().StatusCode != http.StatusCreated || !rc.didImportDB || rc.didPostDoc || w.Header().Get("Location") != "/v1/db24" {
+		t.Errorf("TestImportDB failed, got %d", w.Result().StatusCode)
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	rc := &mockCreator{}
+	srv := New(&mockResourceDeleter{}, &mockResourceGetter{}, rc, &mockAuthorizer{}, &mockResourcePatcher{}, &mockMetrics{}, &mockWebhooks{}, &mockFeed{}, &mockUsers{})
+	r := httptest.NewRequest("POST", "/v1/db24?transaction", strings.NewReader(`[{"op":"delete","path":"/doc1"}]`))
+	r.Header.Set("Authorization", "Bearer ADMIN")
+	w := httptest.NewRecorder()
+
+	srv.ServeHTTP(w, r)
+
+	if w.Result().StatusCode != http.StatusOK || !rc.didTransact || rc.didPostDoc {
+		t.Errorf("TestTransaction failed, got %d", w.Result().StatusCode)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	rc := &mockCreator{}
+	rd := &mockResourceDeleter{}
+	rp := &mockResourcePatcher{}
+	srv := New(rd, &mockResourceGetter{}, rc, &mockAuthorizer{}, rp, &mockMetrics{}, &mockWebhooks{}, &mockFeed{}, &mockUsers{})
+	body := `[{"op":"put","path":"/doc1","doc":{}},{"op":"patch","path":"/doc1/col1/doc2","patch":[]},{"op":"delete","path":"/doc3","ifMatch":"\"2\""},{"op":"put","path":"/doc1/col1"},42]`
+	r := httptest.NewRequest("POST", "/v1/db24?batch", strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer ADMIN")
+	w := httptest.NewRecorder()
+
+	srv.ServeHTTP(w, r)
+
+	var results []batchResult
+	if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil || w.Result().StatusCode != http.StatusOK {
+		t.Fatalf("TestBatch failed, got %d %s", w.Result().StatusCode, w.Body.String())
+	}
+	statuses := fmt.Sprint(results[0].Status, results[1].Status, results[2].Status, results[3].Status, results[4].Status)
+	if len(results) != 5 || statuses != "200 200 204 400 400" || results[2].Uri != "/v1/db24/doc3" {
+		t.Errorf("TestBatch failed, unexpected results %s", w.Body.String())
+	}
+	if !rc.didPutDoc || !rp.didPatchDoc || !rd.didDeleteDoc || len(rd.cond.IfMatch) != 1 {
+		t.Errorf("TestBatch failed, expected every well-formed operation to be applied")
+	}
+
+	for _, bad := range []string{`{}`, `not json`, "[" + strings.Repeat(`{},`, maxBatchSize) + "{}]"} {
+		r = httptest.NewRequest("POST", "/v1/db24?batch", strings.NewReader(bad))
+		r.Header.Set("Authorization", "Bearer ADMIN")
+		w = httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		if w.Result().StatusCode != http.StatusBadRequest && w.Result().StatusCode != http.StatusRequestEntityTooLarge {
+			t.Errorf("TestBatch failed, expected the batch to be rejected, got %d", w.Result().StatusCode)
+		}
+	}
+}
+
+func TestMultiGet(t *testing.T) {
+	rg := &mockResourceGetter{}
+	srv := New(&mockResourceDeleter{}, rg, &mockCreator{}, &mockAuthorizer{}, &mockResourcePatcher{}, &mockMetrics{}, &mockWebhooks{}, &mockFeed{}, &mockUsers{})
+	r := httptest.NewRequest("POST", "/v1/db24?multiget", strings.NewReader(`["/doc1","/doc1/col1/doc2","/doc1/col1","doc1"]`))
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	if w.Result().StatusCode != http.StatusUnauthorized || rg.didGetDoc {
+		t.Errorf("TestMultiGet failed, expected 401 without a token, got %d", w.Result().StatusCode)
+	}
+
+	r = httptest.NewRequest("POST", "/v1/db24?multiget", strings.NewReader(`["/doc1","/doc1/col1/doc2","/doc1/col1","doc1"]`))
+	r.Header.Set("Authorization", "Bearer ADMIN")
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+
+	var results []batchResult
+	if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil || w.Result().StatusCode != http.StatusOK {
+		t.Fatalf("TestMultiGet failed, got %d %s", w.Result().StatusCode, w.Body.String())
+	}
+	if len(results) != 4 || results[1].Status != http.StatusOK || results[1].Uri != "/v1/db24/doc1/col1/doc2" || results[2].Status != http.StatusBadRequest || results[3].Status != http.StatusBadRequest {
+		t.Errorf("TestMultiGet failed, unexpected results %s", w.Body.String())
+	}
+}
+
+func TestDocHistory(t *testing.T) {
+	rg := &mockResourceGetter{}
+	rc := &mockCreator{}
+	srv := New(&mockResourceDeleter{}, rg, rc, &mockAuthorizer{}, &mockResourcePatcher{}, &mockMetrics{}, &mockWebhooks{}, &mockFeed{}, &mockUsers{})
+	tests := []struct {
+		method string
+		target string
+		status int
+		sel    history.Selector
+	}{
+		{"GET", "/v1/db24/doc1?history", http.StatusOK, history.Selector{}},
+		{"GET", "/v1/db24/doc1/col1/doc2?version=3", http.StatusOK, history.Selector{Version: 3}},
+		{"GET", "/v1/db24/doc1?asOf=1700000000000", http.StatusOK, history.Selector{AsOf: 1700000000000}},
+		{"GET", "/v1/db24/doc1?version=0", http.StatusBadRequest, history.Selector{}},
+		{"GET", "/v1/db24/doc1?version=1&asOf=2", http.StatusBadRequest, history.Selector{}},
+		{"GET", "/v1/db24/doc1?history&version=1", http.StatusBadRequest, history.Selector{}},
+		{"POST", "/v1/db24/doc1?restore&version=2", http.StatusOK, history.Selector{Version: 2}},
+		{"POST", "/v1/db24/doc1?restore", http.StatusBadRequest, history.Selector{}},
+	}
+	for _, tt := range tests {
+		rg.sel = history.Selector{}
+		r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(""))
+		r.Header.Set("Authorization", "Bearer ADMIN")
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		if w.Result().StatusCode != tt.status || rg.sel != tt.sel {
+			t.Errorf("TestDocHistory failed for %s %s, got %d and %+v", tt.method, tt.target, w.Result().StatusCode, rg.sel)
+		}
+	}
+	if !rc.didPutDoc || rc.didPostDoc {
+		t.Errorf("TestDocHistory failed, expected restore to write the version back")
+	}
+}
+
 func TestDeleteDB(t *testing.T) {
 	srv := setup()
 	r := httptest.NewRequest("DELETE", "/v1/db24", strings.NewReader(""))
@@ -301,7 +697,7 @@ func TestPostDoc(t *testing.T) {
 
 func TestPostAuth(t *testing.T) {
 	srv := setup()
-	r := httptest.NewRequest("POST", "/auth", strings.NewReader(`{"username":"me"}`))
+	r := httptest.NewRequest("POST", "/auth", strings.NewReader(`{"username":"me","password":"secret"}`))
 	w := httptest.NewRecorder()
 
 	srv.ServeHTTP(w, r)
@@ -454,17 +850,122 @@ func TestColGetIntervalsBadIntervals(t *testing.T) {
 	}
 }
 
+func TestColGetFilter(t *testing.T) {
+	srv := setup()
+	tests := []struct {
+		target string
+		want   int
+	}{
+		{"/v1/db24/doc1/col1/?filter=" + url.QueryEscape(`age>30 AND tags contains "x"`), http.StatusOK},
+		{"/v1/db24/?filter=" + url.QueryEscape(`age>30`), http.StatusOK},
+		{"/v1/db24/doc1/col1/?filter=" + url.QueryEscape(`age>`), http.StatusBadRequest},
+		{"/v1/db24/doc1/col1/?mode=subscribe&depth=infinite&filter=" + url.QueryEscape(`age>30`), http.StatusBadRequest},
+		{"/v1/db24/doc1/col1/?mode=subscribe&filter=" + url.QueryEscape(`age>`), http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", test.target, nil)
+		r.Header.Set("Authorization", "Bearer TEST")
+		w := httptest.NewRecorder()
+
+		srv.ServeHTTP(w, r)
+
+		if w.Result().StatusCode != test.want {
+			t.Errorf("TestColGetFilter failed for %s, expected %d, got %d", test.target, test.want, w.Result().StatusCode)
+		}
+	}
+}
+
+func TestColGetPagination(t *testing.T) {
+	srv := setup()
+	tests := []struct {
+		target string
+		want   int
+	}{
+		{"/v1/db24/doc1/col1/?limit=2", http.StatusOK},
+		{"/v1/db24/doc1/col1/?limit=2&cursor=" + encodeCursor(cursor{After: "b"}), http.StatusOK},
+		{"/v1/db24/doc1/col1/?limit=0", http.StatusBadRequest},
+		{"/v1/db24/doc1/col1/?limit=two", http.StatusBadRequest},
+		{"/v1/db24/doc1/col1/?cursor=notacursor", http.StatusBadRequest},
+		{"/v1/db24/doc1/col1/?mode=subscribe&limit=2", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", test.target, nil)
+		r.Header.Set("Authorization", "Bearer TEST")
+		w := httptest.NewRecorder()
+
+		srv.ServeHTTP(w, r)
+
+		if w.Result().StatusCode != test.want {
+			t.Errorf("TestColGetPagination failed for %s, expected %d, got %d", test.target, test.want, w.Result().StatusCode)
+		}
+	}
+}
+
+func TestColGetSortFields(t *testing.T) {
+	srv := setup()
+	tests := []struct {
+		target string
+		want   int
+	}{
+		{"/v1/db24/doc1/col1/?sort=/age:desc&fields=/name,/address/city", http.StatusOK},
+		{"/v1/db24/doc1/col1/?sort=age", http.StatusBadRequest},
+		{"/v1/db24/doc1/col1/?sort=/age:up", http.StatusBadRequest},
+		{"/v1/db24/doc1/col1/?fields=name", http.StatusBadRequest},
+		{"/v1/db24/doc1/col1/?mode=subscribe&sort=/age", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", test.target, nil)
+		r.Header.Set("Authorization", "Bearer TEST")
+		w := httptest.NewRecorder()
+
+		srv.ServeHTTP(w, r)
+
+		if w.Result().StatusCode != test.want {
+			t.Errorf("TestColGetSortFields failed for %s, expected %d, got %d", test.target, test.want, w.Result().StatusCode)
+		}
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	requested, _ := url.Parse("/v1/db24/doc1/col1/?limit=2&interval=[a,z]")
+	w := httptest.NewRecorder()
+	payload := paginate(w, requested, 2, query.Options{}, []byte(`[{"path":"/doc1/col1/a"},{"path":"/doc1/col1/b"},{"path":"/doc1/col1/c"}]`))
+	if string(payload) != `[{"path":"/doc1/col1/a"},{"path":"/doc1/col1/b"}]` {
+		t.Errorf("TestPaginate failed, expected the extra document to be trimmed, got %s", payload)
+	}
+	link := w.Header().Get("Link")
+	if !strings.Contains(link, "cursor="+encodeCursor(cursor{After: "b"})) || !strings.Contains(link, "limit=2") || !strings.HasSuffix(link, `rel="next"`) {
+		t.Errorf("TestPaginate failed, unexpected next link %s", link)
+	}
+
+	//the last page carries no link
+	w = httptest.NewRecorder()
+	paginate(w, requested, 2, query.Options{}, []byte(`[{"path":"/doc1/col1/c"}]`))
+	if w.Header().Get("Link") != "" {
+		t.Errorf("TestPaginate failed, expected no next link on the last page")
+	}
+}
+
 func TestColGetIntervalsSubscribe(t *testing.T) {
 	srv := setup()
 	r := httptest.NewRequest("GET", "/v1/db24/doc1/col1/?mode=subscribe", strings.NewReader(""))
 	r.Header.Set("Authorization", "Bearer TEST")
+	ctx, cancel := context.WithCancel(r.Context())
+	r = r.WithContext(ctx)
 	w := httptest.NewRecorder()
 
-	go srv.ServeHTTP(w, r)
+	//the recorder is only read once the handler has returned, since it is not safe for concurrent use
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		srv.ServeHTTP(w, r)
+	}()
 	select {
 	case <-time.After(2 * time.Second):
 		t.Log("PASSED")
 	}
+	cancel()
+	<-done
 	if w.Result().StatusCode != http.StatusOK {
 		t.Errorf("TestColGetIntervals failed")
 	}
@@ -797,7 +1298,7 @@ func TestBadGetColUri(t *testing.T) {
 }
 
 func TestGetHandler(t *testing.T) {
-	auth := New(mockAuthorizer{})
+	auth := &mockAuthorizer{}
 	tests := []struct {
 		name           string
 		resource       string
@@ -807,14 +1308,14 @@ func TestGetHandler(t *testing.T) {
 	}{
 		{
 			name:           "Get Document",
-			resource:       "dbName/collectionName/docName",
+			resource:       "dbName/docName",
 			method:         "GET",
 			expectedStatus: http.StatusOK,
 			expectedFunc:   "GetDoc",
 		},
 		{
 			name:           "Get Collection",
-			resource:       "dbName/collectionName/",
+			resource:       "dbName/docName/collectionName/",
 			method:         "GET",
 			expectedStatus: http.StatusOK,
 			expectedFunc:   "GetCol",
@@ -832,11 +1333,13 @@ func TestGetHandler(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			mockRG := &mockResourceGetter{}
 			dbh := &DbHarness{
-				auth: mockAuthorizer,
-				rg:   mockRG,
+				auth:  auth,
+				rg:    mockRG,
+				users: &mockUsers{},
 			}
 
 			req := httptest.NewRequest(tt.method, "/v1/"+tt.resource, nil)
+			req.SetPathValue("resource", tt.resource)
 			req.Header.Set("Authorization", "Bearer validToken")
 			w := httptest.NewRecorder()
 
@@ -857,3 +1360,684 @@ func TestGetHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestColIndex(t *testing.T) {
+	rc := &mockCreator{}
+	rd := &mockResourceDeleter{}
+	srv := New(rd, &mockResourceGetter{}, rc, &mockAuthorizer{}, &mockResourcePatcher{}, &mockMetrics{}, &mockWebhooks{}, &mockFeed{}, &mockUsers{})
+
+	r := httptest.NewRequest("PUT", "/v1/db24/doc/col/?index=/email", nil)
+	r.Header.Set("Authorization", "Bearer ADMIN")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	if w.Result().StatusCode != http.StatusCreated || !rc.didPutIndex || rc.didPutCol || w.Header().Get("Location") != "/v1/db24/doc/col/?index=%2Femail" {
+		t.Errorf("TestColIndex failed, expected the index to be created, got %d", w.Result().StatusCode)
+	}
+
+	r = httptest.NewRequest("DELETE", "/v1/db24/doc/col/?index=/email", nil)
+	r.Header.Set("Authorization", "Bearer ADMIN")
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	if w.Result().StatusCode != http.StatusNoContent || !rd.didDeleteIndex || rd.didDeleteCol {
+		t.Errorf("TestColIndex failed, expected the index to be dropped, got %d", w.Result().StatusCode)
+	}
+
+	//the top-level collection of a database is indexed like any other
+	rc.didPutIndex = false
+	r = httptest.NewRequest("PUT", "/v1/db24/?index=/email", nil)
+	r.Header.Set("Authorization", "Bearer ADMIN")
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	if w.Result().StatusCode != http.StatusCreated || !rc.didPutIndex || rc.putIndexCol != "" {
+		t.Errorf("TestColIndex failed, expected the top-level collection to be indexed, got %d", w.Result().StatusCode)
+	}
+
+	//a path naming a document is not a collection
+	r = httptest.NewRequest("PUT", "/v1/db24/doc/?index=/email", nil)
+	r.Header.Set("Authorization", "Bearer ADMIN")
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	if w.Result().StatusCode != http.StatusBadRequest {
+		t.Errorf("TestColIndex failed, expected 400, got %d", w.Result().StatusCode)
+	}
+}
+
+func TestPatchDocMediaType(t *testing.T) {
+	rp := &mockResourcePatcher{}
+	srv := New(&mockResourceDeleter{}, &mockResourceGetter{}, &mockCreator{}, &mockAuthorizer{}, rp, &mockMetrics{}, &mockWebhooks{}, &mockFeed{}, &mockUsers{})
+	tests := []struct {
+		contentType string
+		wantStatus  int
+		wantType    string
+	}{
+		{"", http.StatusOK, "application/json"},
+		{"application/json; charset=utf-8", http.StatusOK, "application/json"},
+		{"application/json-patch+json", http.StatusOK, "application/json-patch+json"},
+		{"application/merge-patch+json", http.StatusOK, "application/merge-patch+json"},
+		//clients written before the other dialects existed send OwlDB patches with whatever type their library picks
+		{"text/plain;charset=UTF-8", http.StatusOK, "application/json"},
+		{"application/x-www-form-urlencoded", http.StatusOK, "application/json"},
+		{"not a media type", http.StatusOK, "application/json"},
+	}
+	for _, test := range tests {
+		rp.mediaType = ""
+		r := httptest.NewRequest("PATCH", "/v1/db24/doc1", strings.NewReader(`[]`))
+		r.Header.Set("Authorization", "Bearer ADMIN")
+		if test.contentType != "" {
+			r.Header.Set("Content-Type", test.contentType)
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		if w.Result().StatusCode != test.wantStatus || rp.mediaType != test.wantType {
+			t.Errorf("TestPatchDocMediaType failed for '%s', got %d and '%s'", test.contentType, w.Result().StatusCode, rp.mediaType)
+		}
+	}
+}
+
+func TestDeleteDocConditions(t *testing.T) {
+	rd := &mockResourceDeleter{}
+	srv := New(rd, &mockResourceGetter{}, &mockCreator{}, &mockAuthorizer{}, &mockResourcePatcher{}, &mockMetrics{}, &mockWebhooks{}, &mockFeed{}, &mockUsers{})
+	r := httptest.NewRequest("DELETE", "/v1/db24/doc1", strings.NewReader(""))
+	r.Header.Set("Authorization", "Bearer ADMIN")
+	r.Header.Set("If-Match", `"1", W/"2"`)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	if len(rd.cond.IfMatch) != 2 || rd.cond.IfMatch[0] != `"1"` || rd.cond.IfMatch[1] != `W/"2"` || rd.cond.IfNoneMatch != nil {
+		t.Errorf("TestDeleteDocConditions failed, got %+v", rd.cond)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	srv := setup()
+	r := httptest.NewRequest("GET", "/metrics", nil)
+	r.Header.Set("Authorization", "Bearer TEST")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != `{"subscriptions":{"dropped":3}}` {
+		t.Errorf("TestMetrics failed, got %d %s", w.Code, w.Body.String())
+	}
+
+	r = httptest.NewRequest("GET", "/metrics", nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("TestMetrics failed, expected 401 without a token, got %d", w.Code)
+	}
+}
+
+// subscribe opens a subscription to the resource at path of srv, returning once the stream has begun.
+// Returns the response, and a function that disconnects the client
+func subscribe(t *testing.T, srv *httptest.Server, path string) (*http.Response, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r, _ := http.NewRequestWithContext(ctx, "GET", srv.URL+path, nil)
+	r.Header.Set("Authorization", "Bearer ADMIN")
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	resp, err := client.Do(r)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		cancel()
+		t.Fatalf("subscribing to %s failed: %v", path, err)
+	}
+	return resp, cancel
+}
+
+// listSubscriptions lists the subscriptions being streamed by srv
+func listSubscriptions(t *testing.T, srv http.Handler) []subscription {
+	r := httptest.NewRequest("GET", "/subscriptions", nil)
+	r.Header.Set("Authorization", "Bearer ADMIN")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	var subs []subscription
+	if err := json.Unmarshal(w.Body.Bytes(), &subs); w.Code != http.StatusOK || err != nil {
+		t.Fatalf("listing subscriptions failed, got %d %s", w.Code, w.Body.String())
+	}
+	return subs
+}
+
+// eventually reports whether cond holds within two seconds
+func eventually(cond func() bool) bool {
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if cond() {
+			return true
+		}
+	}
+	return cond()
+}
+
+// readUntilEnd reads the events of a stream until the server ends it
+func readUntilEnd(resp *http.Response) string {
+	var events strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		events.WriteString(scanner.Text() + "\n")
+	}
+	return events.String()
+}
+
+func TestSubscriptionDisconnect(t *testing.T) {
+	rg := &mockResourceGetter{}
+	handler := New(&mockResourceDeleter{}, rg, &mockCreator{}, &mockAuthorizer{}, &mockResourcePatcher{}, &mockMetrics{}, &mockWebhooks{}, &mockFeed{}, &mockUsers{})
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	baseline := runtime.NumGoroutine()
+
+	var cancels []context.CancelFunc
+	for _, path := range []string{"/v1/db24/doc1?mode=subscribe", "/v1/db24/doc1/col1/?mode=subscribe"} {
+		_, cancel := subscribe(t, srv, path)
+		cancels = append(cancels, cancel)
+	}
+	subs := listSubscriptions(t, handler)
+	if len(subs) != 2 || subs[0].Resource != "/v1/db24/doc1" || subs[1].Resource != "/v1/db24/doc1/col1/" || subs[0].User != "user" {
+		t.Fatalf("TestSubscriptionDisconnect failed, got %+v", subs)
+	}
+
+	for _, cancel := range cancels {
+		cancel()
+	}
+	if !eventually(func() bool { return rg.unsubscribed.Load() == 2 }) {
+		t.Errorf("TestSubscriptionDisconnect failed, expected 2 subscriptions ended, got %d", rg.unsubscribed.Load())
+	}
+	if subs := listSubscriptions(t, handler); len(subs) != 0 {
+		t.Errorf("TestSubscriptionDisconnect failed, expected no subscriptions, got %+v", subs)
+	}
+	if !eventually(func() bool { return runtime.NumGoroutine() <= baseline }) {
+		t.Errorf("TestSubscriptionDisconnect failed, expected at most %d goroutines, got %d", baseline, runtime.NumGoroutine())
+	}
+}
+
+func TestSubscriptionClose(t *testing.T) {
+	rg := &mockResourceGetter{}
+	handler := New(&mockResourceDeleter{}, rg, &mockCreator{}, &mockAuthorizer{}, &mockResourcePatcher{}, &mockMetrics{}, &mockWebhooks{}, &mockFeed{}, &mockUsers{})
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	baseline := runtime.NumGoroutine()
+
+	resp, cancel := subscribe(t, srv, "/v1/db24/doc1?mode=subscribe")
+	defer cancel()
+	subs := listSubscriptions(t, handler)
+	if len(subs) != 1 {
+		t.Fatalf("TestSubscriptionClose failed, got %+v", subs)
+	}
+	r := httptest.NewRequest("DELETE", "/subscriptions/"+subs[0].Id, nil)
+	r.Header.Set("Authorization", "Bearer ADMIN")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("TestSubscriptionClose failed, expected 204, got %d", w.Code)
+	}
+	if events := readUntilEnd(resp); !strings.Contains(events, "event: close\ndata: \"subscription closed\"\n") {
+		t.Errorf("TestSubscriptionClose failed, got events %q", events)
+	}
+	if !eventually(func() bool { return rg.unsubscribed.Load() == 1 }) {
+		t.Errorf("TestSubscriptionClose failed, expected the subscription ended, got %d", rg.unsubscribed.Load())
+	}
+	resp.Body.Close()
+	if !eventually(func() bool { return runtime.NumGoroutine() <= baseline }) {
+		t.Errorf("TestSubscriptionClose failed, expected at most %d goroutines, got %d", baseline, runtime.NumGoroutine())
+	}
+
+	r = httptest.NewRequest("DELETE", "/subscriptions/"+subs[0].Id, nil)
+	r.Header.Set("Authorization", "Bearer ADMIN")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("TestSubscriptionClose failed, expected 404 for a closed subscription, got %d", w.Code)
+	}
+	r = httptest.NewRequest("GET", "/subscriptions", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("TestSubscriptionClose failed, expected 401 without a token, got %d", w.Code)
+	}
+}
+
+func TestSubscriptionSessionExpiry(t *testing.T) {
+	defer func(interval time.Duration) { keepAliveInterval = interval }(keepAliveInterval)
+	keepAliveInterval = 20 * time.Millisecond
+	rg := &mockResourceGetter{}
+	auth := &mockAuthorizer{}
+	srv := httptest.NewServer(New(&mockResourceDeleter{}, rg, &mockCreator{}, auth, &mockResourcePatcher{}, &mockMetrics{}, &mockWebhooks{}, &mockFeed{}, &mockUsers{}))
+	defer srv.Close()
+
+	resp, cancel := subscribe(t, srv, "/v1/db24/doc1/col1/?mode=subscribe")
+	defer cancel()
+	defer resp.Body.Close()
+	auth.expired.Store(true)
+	if events := readUntilEnd(resp); !strings.Contains(events, "event: close\ndata: \"session expired\"\n") {
+		t.Errorf("TestSubscriptionSessionExpiry failed, got events %q", events)
+	}
+	if !eventually(func() bool { return rg.unsubscribed.Load() == 1 }) {
+		t.Errorf("TestSubscriptionSessionExpiry failed, expected the subscription ended, got %d", rg.unsubscribed.Load())
+	}
+}
+
+func TestSubscriptionResourceDeleted(t *testing.T) {
+	rg := &mockResourceGetter{subChan: make(chan []byte, 1)}
+	srv := httptest.NewServer(New(&mockResourceDeleter{}, rg, &mockCreator{}, &mockAuthorizer{}, &mockResourcePatcher{}, &mockMetrics{}, &mockWebhooks{}, &mockFeed{}, &mockUsers{}))
+	defer srv.Close()
+
+	resp, cancel := subscribe(t, srv, "/v1/db24/doc1?mode=subscribe")
+	defer cancel()
+	defer resp.Body.Close()
+	rg.subChan <- []byte("event: delete\ndata: \"/doc1\"\n\n")
+	close(rg.subChan)
+	if events := readUntilEnd(resp); events != "event: delete\ndata: \"/doc1\"\n\n" {
+		t.Errorf("TestSubscriptionResourceDeleted failed, got events %q", events)
+	}
+	if !eventually(func() bool { return rg.unsubscribed.Load() == 1 }) {
+		t.Errorf("TestSubscriptionResourceDeleted failed, expected the subscription ended, got %d", rg.unsubscribed.Load())
+	}
+}
+
+func TestSubtreeSubscription(t *testing.T) {
+	rg := &mockResourceGetter{subChan: make(chan []byte, 1)}
+	srv := httptest.NewServer(New(&mockResourceDeleter{}, rg, &mockCreator{}, &mockAuthorizer{}, &mockResourcePatcher{}, &mockMetrics{}, &mockWebhooks{}, &mockFeed{}, &mockUsers{}))
+	defer srv.Close()
+
+	for _, target := range []string{"/v1/db24/doc1", "/v1/db24/doc1/col1/"} {
+		resp, cancel := subscribe(t, srv, target+"?mode=subscribe&depth=infinite")
+		rg.subChan <- []byte("event: delete\ndata: \"/doc1/col1/doc2\"\n\n")
+		cancel()
+		events := readUntilEnd(resp)
+		resp.Body.Close()
+		want := "event: update\ndata: \"/" + strings.TrimPrefix(target, "/v1/db24/") + "\"\n\n"
+		if rg.subtree != strings.TrimPrefix(target, "/v1/db24/") || !strings.HasPrefix(events, want) {
+			t.Errorf("TestSubtreeSubscription failed for %s, subscribed to %q, got events %q", target, rg.subtree, events)
+		}
+	}
+	if !eventually(func() bool { return rg.unsubscribed.Load() == 2 }) {
+		t.Errorf("TestSubtreeSubscription failed, expected 2 subscriptions ended, got %d", rg.unsubscribed.Load())
+	}
+
+	for target, want := range map[string]int{
+		"/v1/db24/doc1?depth=infinite":                                     http.StatusBadRequest,
+		"/v1/db24/doc1?mode=subscribe&depth=1":                             http.StatusBadRequest,
+		"/v1/db24/doc1/col1/?mode=subscribe&depth=infinite&interval=[a,b]": http.StatusBadRequest,
+		"/v1/db24/missing?mode=subscribe&depth=infinite":                   http.StatusNotFound,
+	} {
+		req, _ := http.NewRequest("GET", srv.URL+target, nil)
+		req.Header.Set("Authorization", "Bearer ADMIN")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("TestSubtreeSubscription failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("TestSubtreeSubscription failed for %s, expected %d, got %d", target, want, resp.StatusCode)
+		}
+	}
+}
+
+// dialWebSocket opens a WebSocket connection to srv, authenticated by header
+func dialWebSocket(t *testing.T, srv *httptest.Server, query string, header http.Header) *websocket.Conn {
+	conn, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws"+query, header)
+	if err != nil {
+		t.Fatalf("unable to open a websocket: %v", err)
+	}
+	return conn
+}
+
+// exchange sends req over conn, if not empty, and reads the message that follows
+func exchange(t *testing.T, conn *websocket.Conn, req string) wsMessage {
+	if req != "" {
+		conn.WriteMessage([]byte(req))
+	}
+	raw, err := conn.ReadMessage()
+	var msg wsMessage
+	if err != nil || json.Unmarshal(raw, &msg) != nil {
+		t.Fatalf("expected a message after %s, got %s %v", req, raw, err)
+	}
+	return msg
+}
+
+func TestWebSocketSubscriptions(t *testing.T) {
+	rg := &mockResourceGetter{subChan: make(chan []byte, 1)}
+	handler := New(&mockResourceDeleter{}, rg, &mockCreator{}, &mockAuthorizer{}, &mockResourcePatcher{}, &mockMetrics{}, &mockWebhooks{}, &mockFeed{}, &mockUsers{})
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	baseline := runtime.NumGoroutine()
+	conn := dialWebSocket(t, srv, "", http.Header{"Authorization": {"Bearer ADMIN"}})
+
+	if msg := exchange(t, conn, `{"op":"subscribe","id":"a","path":"/db24/doc1"}`); msg.Type != "subscribed" || msg.Id != "a" {
+		t.Errorf("TestWebSocketSubscriptions failed, expected a to be subscribed, got %+v", msg)
+	}
+	if msg := exchange(t, conn, `{"op":"subscribe","id":"b","path":"/db24/doc1/col1/","interval":"[a,c]"}`); msg.Type != "subscribed" || msg.Id != "b" {
+		t.Errorf("TestWebSocketSubscriptions failed, expected b to be subscribed, got %+v", msg)
+	}
+	rg.subChan <- []byte("event: update\ndata: {\"path\":\"/doc1\"}\nid: 7-1\n\n")
+	if msg := exchange(t, conn, ""); msg.Type != "event" || msg.Id != "a" || msg.Event != "update" || string(msg.Data) != `{"path":"/doc1"}` || msg.EventId != "7-1" {
+		t.Errorf("TestWebSocketSubscriptions failed, expected an update event, got %+v", msg)
+	}
+	if subs := listSubscriptions(t, handler); len(subs) != 2 || subs[0].Resource != "/v1/db24/doc1" || subs[1].Resource != "/v1/db24/doc1/col1/" {
+		t.Errorf("TestWebSocketSubscriptions failed, expected both subscriptions listed, got %+v", subs)
+	}
+
+	if msg := exchange(t, conn, `{"op":"subscribe","id":"t","path":"/db24/doc1/col1/","depth":"infinite"}`); msg.Type != "subscribed" || rg.subtree != "doc1/col1/" {
+		t.Errorf("TestWebSocketSubscriptions failed, expected t to be subscribed to the subtree, got %+v", msg)
+	}
+	if msg := exchange(t, conn, ""); msg.Type != "event" || msg.Id != "t" || string(msg.Data) != `"/doc1/col1/"` {
+		t.Errorf("TestWebSocketSubscriptions failed, expected the subtree snapshot, got %+v", msg)
+	}
+	exchange(t, conn, `{"op":"unsubscribe","id":"t"}`)
+
+	for _, bad := range []string{`{"op":"subscribe","id":"b","path":"/db24/doc2"}`, `{"op":"subscribe","id":"d","path":"/db24/doc1","depth":"2"}`, `{"op":"subscribe","id":"e","path":"/db24/doc1","filter":"a=1"}`, `{"op":"subscribe","id":"f","path":"/db24/doc1/col1/","filter":"a>"}`, `{"op":"subscribe","id":"c","path":"/db24/doc1/col1"}`, `{"op":"unsubscribe","id":"z"}`, `{"op":"publish"}`, `not json`} {
+		if msg := exchange(t, conn, bad); msg.Type != "error" || msg.Message == "" {
+			t.Errorf("TestWebSocketSubscriptions failed, expected an error for %s, got %+v", bad, msg)
+		}
+	}
+	if msg := exchange(t, conn, `{"op":"unsubscribe","id":"a"}`); msg.Type != "unsubscribed" || msg.Id != "a" || msg.Message != "" {
+		t.Errorf("TestWebSocketSubscriptions failed, expected a to be unsubscribed, got %+v", msg)
+	}
+	if !eventually(func() bool { return rg.unsubscribed.Load() == 2 }) {
+		t.Errorf("TestWebSocketSubscriptions failed, expected 2 subscriptions ended, got %d", rg.unsubscribed.Load())
+	}
+
+	conn.Close()
+	if !eventually(func() bool { return rg.unsubscribed.Load() == 3 }) {
+		t.Errorf("TestWebSocketSubscriptions failed, expected 3 subscriptions ended, got %d", rg.unsubscribed.Load())
+	}
+	if subs := listSubscriptions(t, handler); len(subs) != 0 {
+		t.Errorf("TestWebSocketSubscriptions failed, expected no subscriptions, got %+v", subs)
+	}
+	if !eventually(func() bool { return runtime.NumGoroutine() <= baseline }) {
+		t.Errorf("TestWebSocketSubscriptions failed, expected at most %d goroutines, got %d", baseline, runtime.NumGoroutine())
+	}
+}
+
+func TestWebSocketSubscriptionEnds(t *testing.T) {
+	defer func(interval time.Duration) { keepAliveInterval = interval }(keepAliveInterval)
+	keepAliveInterval = 20 * time.Millisecond
+	rg := &mockResourceGetter{subChan: make(chan []byte, 1)}
+	auth := &mockAuthorizer{}
+	handler := New(&mockResourceDeleter{}, rg, &mockCreator{}, auth, &mockResourcePatcher{}, &mockMetrics{}, &mockWebhooks{}, &mockFeed{}, &mockUsers{})
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	conn := dialWebSocket(t, srv, "?token=ADMIN", nil)
+	defer conn.Close()
+
+	// The resource subscribed to is deleted
+	exchange(t, conn, `{"op":"subscribe","id":"a","path":"/db24/doc1"}`)
+	close(rg.subChan)
+	if msg := exchange(t, conn, ""); msg.Type != "unsubscribed" || msg.Id != "a" || msg.Message != "subscription ended" {
+		t.Errorf("TestWebSocketSubscriptionEnds failed, expected a to end, got %+v", msg)
+	}
+
+	// The subscription is closed through the subscriptions endpoint
+	exchange(t, conn, `{"op":"subscribe","id":"a","path":"/db24/doc1/col1/"}`)
+	subs := listSubscriptions(t, handler)
+	r := httptest.NewRequest("DELETE", "/subscriptions/"+subs[0].Id, nil)
+	r.Header.Set("Authorization", "Bearer ADMIN")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if msg := exchange(t, conn, ""); msg.Type != "unsubscribed" || msg.Id != "a" || msg.Message != "subscription closed" {
+		t.Errorf("TestWebSocketSubscriptionEnds failed, expected a to be closed, got %+v", msg)
+	}
+
+	// The session expires
+	auth.expired.Store(true)
+	if msg := exchange(t, conn, ""); msg.Type != "close" || msg.Message != "session expired" {
+		t.Errorf("TestWebSocketSubscriptionEnds failed, expected the connection to close, got %+v", msg)
+	}
+	if _, err := conn.ReadMessage(); err == nil {
+		t.Errorf("TestWebSocketSubscriptionEnds failed, expected the connection to be closed")
+	}
+	if _, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil); err == nil {
+		t.Errorf("TestWebSocketSubscriptionEnds failed, expected a connection without a token to be refused")
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	srv := setup()
+	do := func(method string, target string, body string, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do("POST", "/webhooks/db24", `{"url":"http://example.com/hook","prefix":"/doc1/","secret":"s"}`, "ADMIN")
+	var hook webhook.Hook
+	json.Unmarshal(w.Body.Bytes(), &hook)
+	if w.Code != http.StatusCreated || hook.Id != "1" || hook.Database != "db24" || w.Header().Get("Location") != "/webhooks/db24/1" {
+		t.Errorf("TestWebhooks failed, expected the hook to be registered, got %d %s", w.Code, w.Body.String())
+	}
+	for _, body := range []string{`{"prefix":"/"}`, `not json`} {
+		if w := do("POST", "/webhooks/db24", body, "ADMIN"); w.Code != http.StatusBadRequest {
+			t.Errorf("TestWebhooks failed, expected 400 for %s, got %d", body, w.Code)
+		}
+	}
+	var hooks []webhook.Hook
+	if w := do("GET", "/webhooks/db24", "", "ADMIN"); w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &hooks) != nil || len(hooks) != 1 {
+		t.Errorf("TestWebhooks failed, expected the hook to be listed, got %d %s", w.Code, w.Body.String())
+	}
+	var dead []webhook.DeadLetter
+	if w := do("GET", "/webhooks/db24/dead-letters", "", "ADMIN"); w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &dead) != nil || len(dead) != 1 {
+		t.Errorf("TestWebhooks failed, expected the dead letters to be listed, got %d %s", w.Code, w.Body.String())
+	}
+	if w := do("DELETE", "/webhooks/db24/1", "", "ADMIN"); w.Code != http.StatusNoContent {
+		t.Errorf("TestWebhooks failed, expected the hook to be removed, got %d", w.Code)
+	}
+	if w := do("DELETE", "/webhooks/db24/1", "", "ADMIN"); w.Code != http.StatusNotFound {
+		t.Errorf("TestWebhooks failed, expected 404 for a removed hook, got %d", w.Code)
+	}
+	if w := do("GET", "/webhooks/db24", "", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("TestWebhooks failed, expected 401 without a token, got %d", w.Code)
+	}
+}
+
+func TestChangeFeed(t *testing.T) {
+	feed := &mockFeed{}
+	srv := New(&mockResourceDeleter{}, &mockResourceGetter{}, &mockCreator{}, &mockAuthorizer{}, &mockResourcePatcher{}, &mockMetrics{}, &mockWebhooks{}, feed, &mockUsers{})
+	tests := []struct {
+		target string
+		last   string
+		status int
+		since  int64
+	}{
+		{"/v1/db24?changes", "", http.StatusOK, 0},
+		{"/v1/db24?changes&since=1", "", http.StatusOK, 1},
+		{"/v1/db24?changes&since=1&epoch=1", "", http.StatusOK, 1},
+		{"/v1/db24?changes&mode=subscribe&since=1", "2", http.StatusOK, 2},
+		{"/v1/db24?changes&since=-1", "", http.StatusBadRequest, 0},
+		{"/v1/db24?changes&since=one", "", http.StatusBadRequest, 0},
+		{"/v1/db24?changes&mode=sub", "", http.StatusBadRequest, 0},
+		{"/v1/db24?changes&since=3", "", http.StatusGone, 3},
+		{"/v1/db24?changes&epoch=0", "", http.StatusGone, 0},
+		{"/v1/db25?changes", "", http.StatusNotFound, 0},
+	}
+	for _, tt := range tests {
+		feed.since = 0
+		r := httptest.NewRequest("GET", tt.target, strings.NewReader(""))
+		r.Header.Set("Authorization", "Bearer ADMIN")
+		if tt.last != "" {
+			r.Header.Set("Last-Event-ID", tt.last)
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		if w.Code != tt.status || feed.since != tt.since {
+			t.Errorf("TestChangeFeed failed for %s, expected %d after %d, got %d after %d: %s", tt.target, tt.status, tt.since, w.Code, feed.since, w.Body.String())
+		}
+		if w.Code == http.StatusOK && w.Header().Get(EpochHeader) != "1" {
+			t.Errorf("TestChangeFeed failed for %s, expected the epoch header, got %q", tt.target, w.Header().Get(EpochHeader))
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/v1/db24?changes", strings.NewReader(""))
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("TestChangeFeed failed, expected 401 without a token, got %d", w.Code)
+	}
+}
+
+func TestLoginPassword(t *testing.T) {
+	srv := setup()
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{`{"username":"me","password":"secret"}`, http.StatusOK},
+		{`{"username":"me","password":"guess"}`, http.StatusUnauthorized},
+		{`{"username":"me"}`, http.StatusBadRequest},
+		{`{"password":"secret"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/auth", strings.NewReader(tt.body))
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("TestLoginPassword failed for %s, expected %d, got %d", tt.body, tt.status, w.Code)
+		}
+	}
+}
+
+func TestUsers(t *testing.T) {
+	users := &mockUsers{}
+	srv := New(&mockResourceDeleter{}, &mockResourceGetter{}, &mockCreator{}, &mockAuthorizer{}, &mockResourcePatcher{}, &mockMetrics{}, &mockWebhooks{}, &mockFeed{}, users)
+	tests := []struct {
+		method  string
+		target  string
+		body    string
+		status  int
+		changed string
+	}{
+		{"POST", "/users", `{"username":"bob","password":"pw"}`, http.StatusCreated, "bob"},
+		{"POST", "/users", `{"username":"user","password":"pw"}`, http.StatusConflict, ""},
+		{"POST", "/users", `{"username":"bob"}`, http.StatusBadRequest, ""},
+		{"POST", "/users", `{"username":`, http.StatusBadRequest, ""},
+		{"GET", "/users", "", http.StatusOK, ""},
+		{"PUT", "/users/bob/password", `{"password":"pw2"}`, http.StatusNoContent, "bob"},
+		{"PUT", "/users/amy/password", `{"password":"pw2"}`, http.StatusNotFound, ""},
+		{"DELETE", "/users/bob", "", http.StatusNoContent, "bob"},
+		{"DELETE", "/users/amy", "", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		users.changed = ""
+		r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+		r.Header.Set("Authorization", "Bearer ADMIN")
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		if w.Code != tt.status || users.changed != tt.changed {
+			t.Errorf("TestUsers failed for %s %s, expected %d changing %q, got %d changing %q", tt.method, tt.target, tt.status, tt.changed, w.Code, users.changed)
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/users", strings.NewReader(""))
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("TestUsers failed, expected 401 without a token, got %d", w.Code)
+	}
+	users.notAdmin = true
+	r = httptest.NewRequest("DELETE", "/users/bob", strings.NewReader(""))
+	r.Header.Set("Authorization", "Bearer ADMIN")
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden || users.changed != "" {
+		t.Errorf("TestUsers failed, expected 403 for a regular user, got %d", w.Code)
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	users := &mockUsers{notAdmin: true, roles: map[string]auth.Role{"/db/doc": auth.Reader, "/db/doc/col/": auth.Writer, "/db/doc/col/doc": auth.Writer}}
+	srv := New(&mockResourceDeleter{}, &mockResourceGetter{}, &mockCreator{}, &mockAuthorizer{}, &mockResourcePatcher{}, &mockMetrics{}, &mockWebhooks{}, &mockFeed{}, users)
+	tests := []struct {
+		method string
+		target string
+		body   string
+		status int
+	}{
+		{"GET", "/v1/db/doc", "", http.StatusOK},
+		{"PUT", "/v1/db/doc", `{}`, http.StatusForbidden},
+		{"PATCH", "/v1/db/doc", `[]`, http.StatusForbidden},
+		{"DELETE", "/v1/db/doc", "", http.StatusForbidden},
+		{"GET", "/v1/db/doc?history", "", http.StatusOK},
+		{"POST", "/v1/db/doc?restore&version=1", "", http.StatusForbidden},
+		{"PUT", "/v1/db/doc/col/doc", `{}`, http.StatusOK},
+		{"POST", "/v1/db/doc/col/", `{}`, http.StatusCreated},
+		{"PUT", "/v1/db/doc/col/?index=%2Fa", "", http.StatusForbidden},
+		{"DELETE", "/v1/db/doc/col/?index=%2Fa", "", http.StatusForbidden},
+		{"GET", "/v1/db/other", "", http.StatusForbidden},
+		{"GET", "/v1/db/", "", http.StatusForbidden},
+		{"PUT", "/v1/db", "", http.StatusForbidden},
+		{"DELETE", "/v1/db", "", http.StatusForbidden},
+		{"GET", "/v1/db?export=jsonl", "", http.StatusForbidden},
+		{"GET", "/v1/db?changes", "", http.StatusForbidden},
+		{"POST", "/v1/db?import", "", http.StatusForbidden},
+		{"POST", "/v1/db?transaction", `[{"op":"put","path":"/doc/col/doc","doc":{}},{"op":"delete","path":"/doc"}]`, http.StatusForbidden},
+		{"POST", "/v1/db?transaction", `[{"op":"put","path":"/doc/col/doc","doc":{}}]`, http.StatusOK},
+		{"POST", "/webhooks/db", `{"url":"http://example.com"}`, http.StatusForbidden},
+		{"GET", "/webhooks/db", "", http.StatusForbidden},
+		{"GET", "/metrics", "", http.StatusForbidden},
+		{"GET", "/users", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+		r.Header.Set("Authorization", "Bearer ADMIN")
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("TestPermissions failed for %s %s, expected %d, got %d: %s", tt.method, tt.target, tt.status, w.Code, w.Body.String())
+		}
+	}
+
+	r := httptest.NewRequest("POST", "/v1/db?batch", strings.NewReader(`[{"op":"put","path":"/doc/col/doc","doc":{}},{"op":"delete","path":"/doc"}]`))
+	r.Header.Set("Authorization", "Bearer ADMIN")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	var results []batchResult
+	json.Unmarshal(w.Body.Bytes(), &results)
+	if len(results) != 2 || results[0].Status == http.StatusForbidden || results[1].Status != http.StatusForbidden {
+		t.Errorf("TestPermissions failed, expected only the delete of the batch to be forbidden, got %s", w.Body.String())
+	}
+	r = httptest.NewRequest("POST", "/v1/db?multiget", strings.NewReader(`["/doc","/other"]`))
+	r.Header.Set("Authorization", "Bearer ADMIN")
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	results = nil
+	json.Unmarshal(w.Body.Bytes(), &results)
+	if len(results) != 2 || results[0].Status == http.StatusForbidden || results[1].Status != http.StatusForbidden {
+		t.Errorf("TestPermissions failed, expected only the second read of the multi-get to be forbidden, got %s", w.Body.String())
+	}
+}
+
+func TestGrants(t *testing.T) {
+	users := &mockUsers{}
+	srv := New(&mockResourceDeleter{}, &mockResourceGetter{}, &mockCreator{}, &mockAuthorizer{}, &mockResourcePatcher{}, &mockMetrics{}, &mockWebhooks{}, &mockFeed{}, users)
+	tests := []struct {
+		notAdmin bool
+		method   string
+		target   string
+		body     string
+		status   int
+		changed  string
+	}{
+		{false, "GET", "/users/bob/grants", "", http.StatusOK, ""},
+		{false, "GET", "/users/amy/grants", "", http.StatusNotFound, ""},
+		{false, "PUT", "/users/bob/grants", `{"path":"/db","role":"writer"}`, http.StatusNoContent, "bob"},
+		{false, "PUT", "/users/amy/grants", `{"path":"/db","role":"writer"}`, http.StatusNotFound, ""},
+		{false, "PUT", "/users/bob/grants", `{"path":"/db","role":"owner"}`, http.StatusBadRequest, ""},
+		{false, "PUT", "/users/bob/grants", `{"path":"db","role":"reader"}`, http.StatusBadRequest, ""},
+		{false, "DELETE", "/users/bob/grants?path=/db", "", http.StatusNoContent, "bob"},
+		{false, "DELETE", "/users/bob/grants?path=/db2", "", http.StatusNotFound, ""},
+		{false, "DELETE", "/users/bob/grants", "", http.StatusBadRequest, ""},
+		{true, "GET", "/users/user/grants", "", http.StatusOK, ""},
+		{true, "GET", "/users/bob/grants", "", http.StatusForbidden, ""},
+		{true, "PUT", "/users/bob/grants", `{"path":"/db/doc","role":"reader"}`, http.StatusNoContent, "bob"},
+		{true, "PUT", "/users/bob/grants", `{"path":"/db","role":"reader"}`, http.StatusForbidden, ""},
+		{true, "DELETE", "/users/bob/grants?path=/db", "", http.StatusForbidden, ""},
+	}
+	for _, tt := range tests {
+		users.notAdmin = tt.notAdmin
+		users.roles = map[string]auth.Role{"/db/doc": auth.Admin}
+		users.changed = ""
+		r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+		r.Header.Set("Authorization", "Bearer ADMIN")
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		if w.Code != tt.status || users.changed != tt.changed {
+			t.Errorf("TestGrants failed for %s %s, expected %d changing %q, got %d changing %q: %s", tt.method, tt.target, tt.status, tt.changed, w.Code, users.changed, w.Body.String())
+		}
+	}
+}
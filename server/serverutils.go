@@ -7,9 +7,15 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"mime"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strings"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/patcher"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/precondition"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/query"
 )
 
 // writeResponse writes a response with appropriate headers
@@ -41,6 +47,36 @@ func extractToken(header http.Header) (string, error) {
 	return token, nil
 }
 
+// patchMediaType parses the Content-Type header of a PATCH request.
+// Returns the media type naming the patch dialect. Any type other than JSON Patch and JSON Merge Patch, or none at all,
+// names OwlDB's own operations, which were accepted regardless of the header before the other dialects existed
+func patchMediaType(header http.Header) string {
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil || (mediaType != patcher.MediaTypeJSONPatch && mediaType != patcher.MediaTypeMergePatch) {
+		return patcher.MediaTypeOwlDB
+	}
+	return mediaType
+}
+
+// requestConditions parses the If-Match and If-None-Match headers of a request
+func requestConditions(header http.Header) precondition.Conditions {
+	return precondition.Parse(header.Get("If-Match"), header.Get("If-None-Match"))
+}
+
+// documentVersion reads the version out of the metadata of a serialized document.
+// Returns the version, or false if the payload holds none
+func documentVersion(payload []byte) (int64, bool) {
+	var serial struct {
+		Meta struct {
+			Version *int64 `json:"version"`
+		} `json:"meta"`
+	}
+	if err := json.Unmarshal(payload, &serial); err != nil || serial.Meta.Version == nil {
+		return 0, false
+	}
+	return *serial.Meta.Version, true
+}
+
 // validates Bounds for query params
 func validateBounds(param string) bool {
 	pattern := "^(\\[|\\()[^[\\]()]*,[^[\\]()]*(\\]|\\))$"
@@ -67,6 +103,21 @@ func validateSubscribe(param string) bool {
 	return false
 }
 
+// parseDepth reads the depth query field, which only subscriptions take: "infinite" subscribes to the whole subtree of
+// the resource. Returns whether it does, or an error if the field is malformed or given without subscribing
+func parseDepth(qs url.Values, subscribe bool) (bool, error) {
+	if !qs.Has("depth") {
+		return false, nil
+	}
+	if qs.Get("depth") != "infinite" {
+		return false, errors.New("malformed depth parameter")
+	}
+	if !subscribe {
+		return false, errors.New("depth is only supported on subscriptions")
+	}
+	return true, nil
+}
+
 // validateOverwrite checks that overwrite is a correct
 func validateOverwrite(param string) bool {
 	pattern := "^(no)?overwrite$"
@@ -194,3 +245,46 @@ func validateUrl(url string) error {
 	}
 	return nil
 }
+
+// parseQueryOptions parses the query parameters refining collection reads. The limit is returned as requested; callers
+// paging through a collection fetch one more document to find out whether another page follows.
+// Returns the options, or an error if a parameter is malformed
+func parseQueryOptions(qs url.Values) (query.Options, error) {
+	var opts query.Options
+	if qs.Has("filter") {
+		filter, err := query.ParseFilter(qs.Get("filter"))
+		if err != nil {
+			return opts, err
+		}
+		opts.Filter = filter
+	}
+	if qs.Has("sort") {
+		sort, err := query.ParseSort(qs.Get("sort"))
+		if err != nil {
+			return opts, err
+		}
+		opts.Sort = sort
+	}
+	limit, err := parseLimit(qs)
+	if err != nil {
+		return opts, err
+	}
+	opts.Limit = limit
+	if qs.Has("cursor") {
+		c, err := decodeCursor(qs.Get("cursor"))
+		if err != nil {
+			return opts, err
+		}
+		opts.After, opts.AfterValue = c.After, c.Value
+	}
+	return opts, nil
+}
+
+// parseFields parses the fields query parameter, which projects the documents of collection reads
+// Returns the projection (nil if absent), or an error if it is malformed
+func parseFields(qs url.Values) (*query.Projection, error) {
+	if !qs.Has("fields") {
+		return nil, nil
+	}
+	return query.ParseFields(qs.Get("fields"))
+}
@@ -0,0 +1,240 @@
+package server
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// keepAliveInterval is how often a subscriber is sent a keep-alive comment, and its session checked again
+var keepAliveInterval = 15 * time.Second
+
+// subscription describes a subscription being streamed to a client
+type subscription struct {
+	Id       string             `json:"id"`       // identifies the subscription to the subscriptions endpoints
+	Resource string             `json:"resource"` // the URL path subscribed to
+	User     string             `json:"user"`     // the user who subscribed
+	Since    int64              `json:"since"`    // the time the subscription began, in Unix milliseconds
+	seq      int64              // the order in which the subscription began
+	cancel   context.CancelFunc // ends the stream
+}
+
+// subscriptionTracker keeps the subscriptions being streamed, so that they can be listed and closed. It is safe for
+// concurrent use.
+type subscriptionTracker struct {
+	mu   sync.Mutex
+	next int64                    // the number of subscriptions tracked so far
+	subs map[string]*subscription // the subscriptions being streamed, by ID
+}
+
+// newSubscriptionTracker creates a tracker with no subscriptions
+func newSubscriptionTracker() *subscriptionTracker {
+	return &subscriptionTracker{subs: make(map[string]*subscription)}
+}
+
+// add tracks a new subscription of user to resource, which cancel ends.
+// Returns the subscription
+func (t *subscriptionTracker) add(resource string, user string, cancel context.CancelFunc) *subscription {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.next++
+	sub := &subscription{
+		Id:       strconv.FormatInt(t.next, 10),
+		Resource: resource,
+		User:     user,
+		Since:    time.Now().UnixMilli(),
+		seq:      t.next,
+		cancel:   cancel,
+	}
+	t.subs[sub.Id] = sub
+	return sub
+}
+
+// remove stops tracking the subscription id
+func (t *subscriptionTracker) remove(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.subs, id)
+}
+
+// close ends the subscription id.
+// Returns false if no such subscription is being streamed
+func (t *subscriptionTracker) close(id string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sub, found := t.subs[id]
+	if found {
+		sub.cancel()
+	}
+	return found
+}
+
+// owner returns the user who made the subscription id.
+// Returns false if no such subscription is being streamed
+func (t *subscriptionTracker) owner(id string) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sub, found := t.subs[id]
+	if !found {
+		return "", false
+	}
+	return sub.User, true
+}
+
+// list returns the subscriptions being streamed made by user, or every one if user is "", oldest first
+func (t *subscriptionTracker) list(user string) []*subscription {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	subs := make([]*subscription, 0, len(t.subs))
+	for _, sub := range t.subs {
+		if user == "" || sub.User == user {
+			subs = append(subs, sub)
+		}
+	}
+	slices.SortFunc(subs, func(a, b *subscription) int {
+		return cmp.Compare(a.seq, b.seq)
+	})
+	return subs
+}
+
+// streamEvents streams the events of a subscription to the client as server-sent events, starting with initial and
+// followed by every event received from subChan, on behalf of the user whose session token is token.
+// The stream ends, and unsubscribe is called, when the client disconnects, subChan is closed, the subscription is
+// closed through the subscriptions endpoint or the session expires. The last two are announced by a close event.
+func (dbh *DbHarness) streamEvents(w http.ResponseWriter, r *http.Request, token string, user string, initial [][]byte, subChan *chan []byte, unsubscribe func()) {
+	defer unsubscribe()
+	wf, ok := w.(writeFlusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	sub := dbh.subs.add(r.URL.Path, user, cancel)
+	defer dbh.subs.remove(sub.Id)
+
+	wf.Header().Set("Content-Type", "text/event-stream")
+	wf.Header().Set("Cache-Control", "no-cache")
+	wf.Header().Set("Connection", "keep-alive")
+	wf.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Last-Event-ID")
+	wf.Header().Set("Access-Control-Allow-Origin", "*")
+	wf.WriteHeader(http.StatusOK)
+	wf.Flush()
+	for _, ev := range initial {
+		writeEvent(wf, ev)
+	}
+
+	ticker := time.NewTicker(keepAliveInterval) //keep-alive comments
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := dbh.auth.ValidateSession(token); err != nil {
+				msg, _ := json.Marshal("session expired")
+				writeEvent(wf, []byte("event: close\ndata: "+string(msg)+"\n\n"))
+				return
+			}
+			writeEvent(wf, []byte(":keep-alive\n\n"))
+		case <-ctx.Done():
+			if r.Context().Err() == nil { //closed through the subscriptions endpoint
+				msg, _ := json.Marshal("subscription closed")
+				writeEvent(wf, []byte("event: close\ndata: "+string(msg)+"\n\n"))
+			}
+			return
+		case event, ok := <-*subChan:
+			if !ok { //the subscriber was disconnected, or the resource deleted
+				return
+			}
+			writeEvent(wf, event)
+		}
+	}
+}
+
+// streamSubtree streams the events of every document and collection beneath the resource at path in the database
+// dtb, a collection if path ends with "/", on behalf of the user whose session token is token
+func (dbh *DbHarness) streamSubtree(w http.ResponseWriter, r *http.Request, token string, user string, dtb string, path string) {
+	payload, status, subChan, subId, initial := dbh.rg.SubscribeSubtree(dtb, path, r.Header.Get("Last-Event-ID"))
+	if status != http.StatusOK {
+		writeResponse(w, status, payload)
+		return
+	}
+	dbh.streamEvents(w, r, token, user, initial, subChan, func() {
+		dbh.rg.UnsubscribeSubtree(dtb, path, subId)
+	})
+}
+
+// writeEvent sends a formatted event to the client
+func writeEvent(wf writeFlusher, event []byte) {
+	var evt bytes.Buffer
+	evt.Write(event)
+	slog.Info("Sending", "msg", evt.String())
+	wf.Write(evt.Bytes())
+	wf.Flush()
+}
+
+// getSubscriptionsHandler handles requests made to list the subscriptions being streamed. Administrators of the server
+// are sent every subscription, other users only their own.
+// Validates the bearer token before listing.
+func (dbh *DbHarness) getSubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	token, err := extractToken(r.Header)
+	if err != nil {
+		errmsg, _ := json.Marshal("Missing or invalid bearer token")
+		writeResponse(w, http.StatusUnauthorized, errmsg)
+		return
+	}
+	user, autherr := dbh.auth.ValidateSession(token)
+	if autherr != nil {
+		errmsg, _ := json.Marshal(autherr.Error())
+		writeResponse(w, http.StatusUnauthorized, errmsg)
+		return
+	}
+	if dbh.users.IsAdmin(user) {
+		user = ""
+	}
+	response, err := json.Marshal(dbh.subs.list(user))
+	if err != nil {
+		errmsg, _ := json.Marshal("unable to list the subscriptions")
+		writeResponse(w, http.StatusInternalServerError, errmsg)
+		return
+	}
+	writeResponse(w, http.StatusOK, response)
+}
+
+// deleteSubscriptionHandler handles requests made to close a subscription being streamed. Only administrators of the
+// server may close the subscriptions of other users.
+// Validates the bearer token before closing.
+func (dbh *DbHarness) deleteSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	token, err := extractToken(r.Header)
+	if err != nil {
+		errmsg, _ := json.Marshal("Missing or invalid bearer token")
+		writeResponse(w, http.StatusUnauthorized, errmsg)
+		return
+	}
+	user, autherr := dbh.auth.ValidateSession(token)
+	if autherr != nil {
+		errmsg, _ := json.Marshal(autherr.Error())
+		writeResponse(w, http.StatusUnauthorized, errmsg)
+		return
+	}
+	if owner, found := dbh.subs.owner(r.PathValue("id")); found && owner != user && !dbh.users.IsAdmin(user) {
+		errmsg, _ := json.Marshal("Only administrators may close the subscriptions of other users")
+		writeResponse(w, http.StatusForbidden, errmsg)
+		return
+	}
+	if !dbh.subs.close(r.PathValue("id")) {
+		errmsg, _ := json.Marshal("Subscription does not exist")
+		writeResponse(w, http.StatusNotFound, errmsg)
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/auth"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/transaction"
+)
+
+// transactionHandler handles requests to apply a transaction, an ordered list of put, patch and delete operations
+// applied to a database as a whole. Validates the URL path and the bearer token, and that its user holds the writer
+// role on every document written, before applying the body.
+func (dbh *DbHarness) transactionHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	if patherr := validateUrl(r.URL.Path); patherr != nil {
+		errmsg, _ := json.Marshal(patherr.Error())
+		writeResponse(w, http.StatusBadRequest, errmsg)
+		return
+	}
+	token, err := extractToken(r.Header)
+	if err != nil {
+		errmsg, _ := json.Marshal("Missing or invalid bearer token")
+		writeResponse(w, http.StatusUnauthorized, errmsg)
+		return
+	}
+	user, autherr := dbh.auth.ValidateSession(token)
+	if autherr != nil {
+		errmsg, _ := json.Marshal(autherr.Error())
+		writeResponse(w, http.StatusUnauthorized, errmsg)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		errmsg, _ := json.Marshal(err.Error())
+		writeResponse(w, http.StatusBadRequest, errmsg)
+		return
+	}
+	//the transaction applies as a whole, so it is refused if any of its writes is; malformed ones are reported by Transact
+	if ops, err := transaction.Parse(body); err == nil {
+		for _, op := range ops {
+			if !dbh.permit(w, user, "/"+r.PathValue("resource")+op.Path, auth.Writer) {
+				return
+			}
+		}
+	}
+
+	response, status := dbh.rc.Transact(r.PathValue("resource"), body, user)
+	writeResponse(w, status, response)
+}
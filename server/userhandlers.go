@@ -0,0 +1,147 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/auth"
+)
+
+// credentialStore is an interface that defines the methods for checking passwords and managing the accounts of users.
+type credentialStore interface {
+	Verify(username string, password string) error               //Verify should check that password is the password of username
+	IsAdmin(username string) bool                                //IsAdmin should report whether username may manage the accounts
+	List() []auth.User                                           //List should list every account, without its password
+	Create(username string, password string, admin bool) error   //Create should add an account, failing if it exists
+	Delete(username string) error                                //Delete should remove an account and end its sessions
+	SetPassword(username string, password string) error          //SetPassword should replace the password of an account and end its sessions
+	Role(username string, path string) auth.Role                 //Role should return the highest role granted to username on path or above it
+	Grants(username string) ([]auth.Grant, error)                //Grants should list the roles granted to username
+	SetGrant(username string, path string, role auth.Role) error //SetGrant should grant username the role on path
+	RemoveGrant(username string, path string) error              //RemoveGrant should revoke the role granted to username on path
+}
+
+// authenticateAdmin validates the bearer token of r, answering with 401 if it is missing or invalid, and with 403 if
+// its user is not an administrator.
+// Returns false if it did either
+func (dbh *DbHarness) authenticateAdmin(w http.ResponseWriter, r *http.Request) bool {
+	token, err := extractToken(r.Header)
+	if err != nil {
+		errmsg, _ := json.Marshal("Missing or invalid bearer token")
+		writeResponse(w, http.StatusUnauthorized, errmsg)
+		return false
+	}
+	user, autherr := dbh.auth.ValidateSession(token)
+	if autherr != nil {
+		errmsg, _ := json.Marshal(autherr.Error())
+		writeResponse(w, http.StatusUnauthorized, errmsg)
+		return false
+	}
+	if !dbh.users.IsAdmin(user) {
+		errmsg, _ := json.Marshal("Only administrators may manage users")
+		writeResponse(w, http.StatusForbidden, errmsg)
+		return false
+	}
+	return true
+}
+
+// writeUserError answers a request to manage the accounts that failed with err
+func writeUserError(w http.ResponseWriter, err error) {
+	errmsg, _ := json.Marshal(err.Error())
+	switch {
+	case errors.Is(err, auth.ErrUserExists):
+		writeResponse(w, http.StatusConflict, errmsg)
+	case errors.Is(err, auth.ErrNoUser):
+		writeResponse(w, http.StatusNotFound, errmsg)
+	default:
+		writeResponse(w, http.StatusBadRequest, errmsg)
+	}
+}
+
+// postUserHandler handles requests made to create an account, whose body holds its username, its password and
+// whether it is an administrator.
+// Only administrators may create accounts.
+func (dbh *DbHarness) postUserHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	if !dbh.authenticateAdmin(w, r) {
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		errmsg, _ := json.Marshal("unable to read the request body")
+		writeResponse(w, http.StatusBadRequest, errmsg)
+		return
+	}
+	var data struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Admin    bool   `json:"admin"`
+	}
+	if err = json.Unmarshal(body, &data); err != nil {
+		errmsg, _ := json.Marshal("malformed user")
+		writeResponse(w, http.StatusBadRequest, errmsg)
+		return
+	}
+	if err = dbh.users.Create(data.Username, data.Password, data.Admin); err != nil {
+		writeUserError(w, err)
+		return
+	}
+	response, _ := json.Marshal(auth.User{Username: data.Username, Admin: data.Admin})
+	w.Header().Set("Location", "/users/"+data.Username)
+	writeResponse(w, http.StatusCreated, response)
+}
+
+// getUsersHandler handles requests made to list the accounts.
+// Only administrators may list them.
+func (dbh *DbHarness) getUsersHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	if !dbh.authenticateAdmin(w, r) {
+		return
+	}
+	response, _ := json.Marshal(dbh.users.List())
+	writeResponse(w, http.StatusOK, response)
+}
+
+// deleteUserHandler handles requests made to delete an account, ending its sessions.
+// Only administrators may delete accounts.
+func (dbh *DbHarness) deleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	if !dbh.authenticateAdmin(w, r) {
+		return
+	}
+	if err := dbh.users.Delete(r.PathValue("username")); err != nil {
+		writeUserError(w, err)
+		return
+	}
+	writeResponse(w, http.StatusNoContent, nil)
+}
+
+// putPasswordHandler handles requests made to reset the password of an account, whose body holds the new password.
+// The sessions of the account are ended. Only administrators may reset passwords.
+func (dbh *DbHarness) putPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	if !dbh.authenticateAdmin(w, r) {
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		errmsg, _ := json.Marshal("unable to read the request body")
+		writeResponse(w, http.StatusBadRequest, errmsg)
+		return
+	}
+	var data struct {
+		Password string `json:"password"`
+	}
+	if err = json.Unmarshal(body, &data); err != nil {
+		errmsg, _ := json.Marshal("malformed password")
+		writeResponse(w, http.StatusBadRequest, errmsg)
+		return
+	}
+	if err = dbh.users.SetPassword(r.PathValue("username"), data.Password); err != nil {
+		writeUserError(w, err)
+		return
+	}
+	writeResponse(w, http.StatusNoContent, nil)
+}
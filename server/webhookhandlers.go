@@ -0,0 +1,86 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/auth"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/webhook"
+)
+
+// webhookRegistry is an interface that defines the methods for managing the webhooks of databases.
+type webhookRegistry interface {
+	Register(dtb string, hook webhook.Hook) (webhook.Hook, error) //Register should register the hook with the database, returning it without its secret
+	List(dtb string) []webhook.Hook                               //List should list the hooks of the database, without their secrets
+	Remove(dtb string, id string) bool                            //Remove should unregister the hook id of the database, returning false if there is none
+	DeadLetters(dtb string) []webhook.DeadLetter                  //DeadLetters should list the events of the database that could not be delivered
+}
+
+// postWebhookHandler handles requests made to register a webhook with a database.
+// Validates the bearer token, and that its user holds the admin role on the database, before registering.
+func (dbh *DbHarness) postWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	if _, ok := dbh.authorize(w, r, "/"+r.PathValue("db"), auth.Admin); !ok {
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		errmsg, _ := json.Marshal("unable to read the request body")
+		writeResponse(w, http.StatusBadRequest, errmsg)
+		return
+	}
+	var hook webhook.Hook
+	if err = json.Unmarshal(body, &hook); err != nil {
+		errmsg, _ := json.Marshal("malformed webhook")
+		writeResponse(w, http.StatusBadRequest, errmsg)
+		return
+	}
+	registered, err := dbh.hooks.Register(r.PathValue("db"), hook)
+	if err != nil {
+		errmsg, _ := json.Marshal(err.Error())
+		writeResponse(w, http.StatusBadRequest, errmsg)
+		return
+	}
+	response, _ := json.Marshal(registered)
+	w.Header().Set("Location", "/webhooks/"+r.PathValue("db")+"/"+registered.Id)
+	writeResponse(w, http.StatusCreated, response)
+}
+
+// getWebhooksHandler handles requests made to list the webhooks of a database.
+// Validates the bearer token, and that its user holds the admin role on the database, before listing.
+func (dbh *DbHarness) getWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	if _, ok := dbh.authorize(w, r, "/"+r.PathValue("db"), auth.Admin); !ok {
+		return
+	}
+	response, _ := json.Marshal(dbh.hooks.List(r.PathValue("db")))
+	writeResponse(w, http.StatusOK, response)
+}
+
+// deleteWebhookHandler handles requests made to unregister a webhook of a database.
+// Validates the bearer token, and that its user holds the admin role on the database, before unregistering.
+func (dbh *DbHarness) deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	if _, ok := dbh.authorize(w, r, "/"+r.PathValue("db"), auth.Admin); !ok {
+		return
+	}
+	if !dbh.hooks.Remove(r.PathValue("db"), r.PathValue("id")) {
+		errmsg, _ := json.Marshal("Webhook does not exist")
+		writeResponse(w, http.StatusNotFound, errmsg)
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getDeadLettersHandler handles requests made to list the events of a database its webhooks could not be sent.
+// Validates the bearer token, and that its user holds the admin role on the database, before listing.
+func (dbh *DbHarness) getDeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	if _, ok := dbh.authorize(w, r, "/"+r.PathValue("db"), auth.Admin); !ok {
+		return
+	}
+	response, _ := json.Marshal(dbh.hooks.DeadLetters(r.PathValue("db")))
+	writeResponse(w, http.StatusOK, response)
+}
@@ -0,0 +1,306 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/auth"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/query"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/websocket"
+)
+
+// wsRequest is a message sent by a WebSocket client
+type wsRequest struct {
+	Op          string `json:"op"`          // "subscribe" or "unsubscribe"
+	Id          string `json:"id"`          // names the subscription within the connection, chosen by the client
+	Path        string `json:"path"`        // the document or collection subscribed to, such as "/db/doc" or "/db/doc/col/"
+	Interval    string `json:"interval"`    // restricts a collection subscription, as the interval parameter does
+	LastEventID string `json:"lastEventId"` // resumes the subscription from this event, as the Last-Event-ID header does
+	Depth       string `json:"depth"`       // "infinite" subscribes to the whole subtree, as the depth parameter does
+	Filter      string `json:"filter"`      // restricts a collection subscription by the contents of the documents, as the filter parameter does
+}
+
+// wsMessage is a message sent to a WebSocket client
+type wsMessage struct {
+	Type    string          `json:"type"`              // "subscribed", "event", "unsubscribed", "error" or "close"
+	Id      string          `json:"id,omitempty"`      // the subscription the message is about
+	Event   string          `json:"event,omitempty"`   // the type of the event, as sent to SSE subscribers
+	Data    json.RawMessage `json:"data,omitempty"`    // the payload of the event
+	EventId string          `json:"eventId,omitempty"` // the ID of the event, which a later subscription may resume from
+	Message string          `json:"message,omitempty"` // describes an error, or why a subscription or the connection ended
+}
+
+// The reasons a subscription made over a WebSocket ends
+var (
+	errUnsubscribed = errors.New("unsubscribed")        // the client unsubscribed
+	errClosedByAPI  = errors.New("subscription closed") // the subscription was closed through the subscriptions endpoint
+)
+
+// wsSession serves the subscriptions made over a WebSocket connection
+type wsSession struct {
+	dbh    *DbHarness
+	conn   *websocket.Conn
+	token  string             // the session token of the client
+	user   string             // the user the session belongs to
+	ctx    context.Context    // done once the connection ends
+	cancel context.CancelFunc // ends the connection
+
+	mu   sync.Mutex
+	subs map[string]context.CancelCauseFunc // ends each subscription, by the id the client gave it
+	wg   sync.WaitGroup                     // waits for the subscriptions to end
+}
+
+// websocketHandler handles requests made to open a WebSocket connection, over which the client subscribes to and
+// unsubscribes from documents and collections.
+// Validates the bearer token, taken from the Authorization header or the token parameter, before upgrading.
+func (dbh *DbHarness) websocketHandler(w http.ResponseWriter, r *http.Request) {
+	token, err := extractToken(r.Header)
+	if err != nil {
+		token = r.URL.Query().Get("token")
+	}
+	if token == "" {
+		errmsg, _ := json.Marshal("Missing or invalid bearer token")
+		writeResponse(w, http.StatusUnauthorized, errmsg)
+		return
+	}
+	user, autherr := dbh.auth.ValidateSession(token)
+	if autherr != nil {
+		errmsg, _ := json.Marshal(autherr.Error())
+		writeResponse(w, http.StatusUnauthorized, errmsg)
+		return
+	}
+	conn, status, err := websocket.Upgrade(w, r)
+	if err != nil {
+		errmsg, _ := json.Marshal(err.Error())
+		writeResponse(w, status, errmsg)
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &wsSession{dbh: dbh, conn: conn, token: token, user: user, ctx: ctx, cancel: cancel, subs: make(map[string]context.CancelCauseFunc)}
+	s.serve()
+}
+
+// serve reads the requests of the client until the connection ends, then ends its subscriptions
+func (s *wsSession) serve() {
+	defer s.wg.Wait()
+	defer s.conn.Close()
+	defer s.cancel()
+	go s.keepAlive()
+	for {
+		raw, err := s.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var req wsRequest
+		if err = json.Unmarshal(raw, &req); err != nil {
+			s.send(wsMessage{Type: "error", Message: "malformed request"})
+			continue
+		}
+		switch req.Op {
+		case "subscribe":
+			s.subscribe(req)
+		case "unsubscribe":
+			s.unsubscribe(req.Id)
+		default:
+			s.send(wsMessage{Type: "error", Id: req.Id, Message: "unknown op"})
+		}
+	}
+}
+
+// keepAlive pings the client every keepAliveInterval, closing the connection once its session has expired
+func (s *wsSession) keepAlive() {
+	ticker := time.NewTicker(keepAliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.dbh.auth.ValidateSession(s.token); err != nil {
+				s.send(wsMessage{Type: "close", Message: "session expired"})
+				s.cancel()
+				s.conn.Close()
+				return
+			}
+			s.conn.Ping()
+		}
+	}
+}
+
+// subscribe starts the subscription req asks for, sending the events it starts with
+func (s *wsSession) subscribe(req wsRequest) {
+	if req.Id == "" {
+		s.send(wsMessage{Type: "error", Message: "missing subscription id"})
+		return
+	}
+	s.mu.Lock()
+	_, taken := s.subs[req.Id]
+	s.mu.Unlock()
+	if taken {
+		s.send(wsMessage{Type: "error", Id: req.Id, Message: "subscription id already in use"})
+		return
+	}
+	path := strings.TrimPrefix(req.Path, "/")
+	if validateUrl(path) != nil || !strings.Contains(path, "/") {
+		s.send(wsMessage{Type: "error", Id: req.Id, Message: "bad resource path"})
+		return
+	}
+	dtb, resource := parseResourcePath(path)
+	if s.dbh.users.Role(s.user, "/"+path) < auth.Reader {
+		s.send(wsMessage{Type: "error", Id: req.Id, Message: forbidden(s.user, "/"+path, auth.Reader)})
+		return
+	}
+
+	var payload []byte
+	var status int
+	var subChan *chan []byte
+	var subId string
+	var initial [][]byte
+	var unsubscribe func()
+	if req.Depth != "" && (req.Depth != "infinite" || req.Interval != "" || req.Filter != "") {
+		s.send(wsMessage{Type: "error", Id: req.Id, Message: "bad depth, interval or filter"})
+		return
+	}
+	var opts query.Options
+	if req.Filter != "" {
+		filter, err := query.ParseFilter(req.Filter)
+		if err != nil || !strings.HasSuffix(path, "/") {
+			s.send(wsMessage{Type: "error", Id: req.Id, Message: "bad filter"})
+			return
+		}
+		opts.Filter = filter
+	}
+	if req.Depth != "" {
+		if (strings.HasSuffix(path, "/") && validateColPath(resource) != nil) || (!strings.HasSuffix(path, "/") && validateDocPath(resource) != nil) {
+			s.send(wsMessage{Type: "error", Id: req.Id, Message: "bad resource path"})
+			return
+		}
+		payload, status, subChan, subId, initial = s.dbh.rg.SubscribeSubtree(dtb, resource, req.LastEventID)
+		unsubscribe = func() { s.dbh.rg.UnsubscribeSubtree(dtb, resource, subId) }
+	} else if strings.HasSuffix(path, "/") {
+		if validateColPath(resource) != nil || (req.Interval != "" && !validateBounds(req.Interval)) {
+			s.send(wsMessage{Type: "error", Id: req.Id, Message: "bad resource path or interval"})
+			return
+		}
+		lower, upper := parseBounds(req.Interval)
+		payload, status, subChan, subId, initial = s.dbh.rg.GetCol(dtb, resource, lower, upper, true, opts, req.LastEventID)
+		unsubscribe = func() { s.dbh.rg.UnsubscribeCol(dtb, resource, subId) }
+	} else {
+		if validateDocPath(resource) != nil {
+			s.send(wsMessage{Type: "error", Id: req.Id, Message: "bad resource path"})
+			return
+		}
+		var docEv []byte
+		payload, status, subChan, subId, docEv = s.dbh.rg.GetDoc(dtb, resource, true, req.LastEventID)
+		initial = [][]byte{docEv}
+		unsubscribe = func() { s.dbh.rg.UnsubscribeDoc(dtb, resource, subId) }
+	}
+	if status != http.StatusOK || subChan == nil {
+		var msg string
+		if json.Unmarshal(payload, &msg) != nil {
+			msg = string(payload)
+		}
+		s.send(wsMessage{Type: "error", Id: req.Id, Message: msg})
+		return
+	}
+
+	ctx, cancel := context.WithCancelCause(s.ctx)
+	s.mu.Lock()
+	s.subs[req.Id] = cancel
+	s.mu.Unlock()
+	tracked := s.dbh.subs.add("/v1/"+path, s.user, func() { cancel(errClosedByAPI) })
+	s.send(wsMessage{Type: "subscribed", Id: req.Id})
+	for _, ev := range initial {
+		s.sendEvents(req.Id, ev)
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer unsubscribe()
+		defer s.dbh.subs.remove(tracked.Id)
+		s.forward(ctx, req.Id, subChan)
+	}()
+}
+
+// forward sends the events of the subscription id, received from subChan, until it ends. Unless the connection
+// ended, the client is told the subscription ended, and may then reuse its id
+func (s *wsSession) forward(ctx context.Context, id string, subChan *chan []byte) {
+	for {
+		select {
+		case <-ctx.Done():
+			if s.ctx.Err() != nil { //the connection ended
+				return
+			}
+			msg := ""
+			if cause := context.Cause(ctx); cause != errUnsubscribed {
+				msg = cause.Error()
+			}
+			s.forget(id)
+			s.send(wsMessage{Type: "unsubscribed", Id: id, Message: msg})
+			return
+		case event, ok := <-*subChan:
+			if !ok { //the subscriber was disconnected, or the resource deleted
+				s.forget(id)
+				s.send(wsMessage{Type: "unsubscribed", Id: id, Message: "subscription ended"})
+				return
+			}
+			s.sendEvents(id, event)
+		}
+	}
+}
+
+// unsubscribe ends the subscription id at the request of the client
+func (s *wsSession) unsubscribe(id string) {
+	s.mu.Lock()
+	cancel, found := s.subs[id]
+	s.mu.Unlock()
+	if !found {
+		s.send(wsMessage{Type: "error", Id: id, Message: "Subscription does not exist"})
+		return
+	}
+	cancel(errUnsubscribed)
+}
+
+// forget stops tracking the subscription id, so that the client may reuse its id
+func (s *wsSession) forget(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subs, id)
+}
+
+// sendEvents sends each of the server-sent events held in raw to the client as a message about the subscription id
+func (s *wsSession) sendEvents(id string, raw []byte) {
+	for _, block := range bytes.Split(raw, []byte("\n\n")) {
+		msg := wsMessage{Type: "event", Id: id}
+		for _, line := range strings.Split(string(block), "\n") {
+			field, value, _ := strings.Cut(line, ": ")
+			switch field {
+			case "event":
+				msg.Event = value
+			case "id":
+				msg.EventId = value
+			case "data":
+				if json.Valid([]byte(value)) {
+					msg.Data = json.RawMessage(value)
+				} else {
+					msg.Data, _ = json.Marshal(value)
+				}
+			}
+		}
+		if msg.Data != nil {
+			s.send(msg)
+		}
+	}
+}
+
+// send sends msg to the client. A failure to send is noticed by the reading side, which ends the connection
+func (s *wsSession) send(msg wsMessage) {
+	b, _ := json.Marshal(msg)
+	s.conn.WriteMessage(b)
+}
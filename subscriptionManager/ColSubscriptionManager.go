@@ -4,18 +4,22 @@ package subscriptionManager
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/RICE-COMP318-FALL24/owldb-p1group24/index_utils"
 	"log/slog"
-	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 // internal data structure for each subscriber
 type Colsubscriber struct {
-	ch *chan []byte //the channel on which to send events
-	lo string       //the lower bound
-	hi string       //the upper bound
+	ch       *chan []byte             //the channel on which to send events
+	lo       string                   //the lower bound
+	hi       string                   //the upper bound
+	filter   func(serial []byte) bool //selects the documents sent by their contents, nil for all of them
+	matching map[string]bool          //the documents selected by filter, as of the last event sent about them
 }
 
 // IdToCSub defines an interface for managing collection-level subscribers.
@@ -30,20 +34,42 @@ type IdToCSub[id string, sub Colsubscriber] interface {
 type ColSubscriptionManager struct {
 	subs IdToCSub[string, Colsubscriber] //active subscribers to a collection
 	//an internal id mapper
+	delivery Delivery   //says how events are queued for subscribers
+	mu       sync.Mutex //orders the logging and delivery of events with the arrival and departure of subscribers
+	log      eventLog   //keeps the most recent events, for subscribers resuming a subscription
 }
 
-// NewColSubManager creates a new ColSubscriptionManager with the provided subscriber management system.
-func NewColSubManager(subs IdToCSub[string, Colsubscriber]) *ColSubscriptionManager {
+// NewColSubManager creates a new ColSubscriptionManager with the provided subscriber management system, queuing
+// events for its subscribers as delivery says.
+func NewColSubManager(subs IdToCSub[string, Colsubscriber], delivery Delivery) *ColSubscriptionManager {
 	return &ColSubscriptionManager{
-		subs: subs,
+		subs:     subs,
+		delivery: delivery,
+		log:      newEventLog(),
 	}
 }
 
-// AddSubscriber adds a subscriber to a collection. Returns the channel on which it will send
-// all future events
-func (c *ColSubscriptionManager) AddSubscriber(lo string, hi string) (subChan *chan []byte, id string) {
-	ch := make(chan []byte)
-	cs := Colsubscriber{ch: &ch, lo: lo, hi: hi}
+// AddSubscriber adds a subscriber to the documents of a collection between lo and hi, or only to those whose
+// serialized form satisfies filter if it is not nil. Returns the channel on which it will send all future events,
+// along with the events the subscriber starts with: those it missed since lastEventID if it is resuming a
+// subscription, or else an update event for each payload of snapshot, preceded by a resync event if the missed events
+// are no longer kept. A filtered subscriber is never resumed, as the events it missed depend on what it was sent.
+func (c *ColSubscriptionManager) AddSubscriber(lo string, hi string, filter func(serial []byte) bool, lastEventID string, snapshot [][]byte) (subChan *chan []byte, id string, events [][]byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := c.delivery.newQueue()
+	cs := Colsubscriber{ch: &ch, lo: lo, hi: hi, filter: filter}
+	if filter != nil {
+		cs.matching = make(map[string]bool)
+		matched := make([][]byte, 0, len(snapshot))
+		for _, payload := range snapshot {
+			if filter(payload) {
+				cs.matching[docName(payload)] = true
+				matched = append(matched, payload)
+			}
+		}
+		snapshot = matched
+	}
 
 	check := func(key string, curV Colsubscriber, exists bool) (newV Colsubscriber, err error) {
 		return cs, nil
@@ -51,64 +77,133 @@ func (c *ColSubscriptionManager) AddSubscriber(lo string, hi string) (subChan *c
 	subId := generateResourceName()
 	c.subs.Upsert(subId, check)
 
-	return &ch, subId
+	if filter != nil {
+		return &ch, subId, c.log.resync(lastEventID, snapshot)
+	}
+	inRange := func(docname string) bool {
+		return docname == "" || lo <= docname && docname <= hi
+	}
+	return &ch, subId, c.log.replay(lastEventID, snapshot, inRange)
 }
 
 // Notify will take a document name and event (as a series of bytes), notify every collection subscriber
-// listening on a range that contains a document.
+// listening on a range that contains a document, without waiting for any of them. A filtered subscriber is sent an
+// enter event when an update makes the document satisfy its filter, a leave event carrying the path of the document
+// when an update makes it stop, and is not sent about documents that satisfy it neither before nor after.
 func (c *ColSubscriptionManager) Notify(docname string, evType string, payload []byte) {
 	slog.Debug(fmt.Sprintf("Notifying subscribers using about an update to %s", docname))
 	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(10*time.Second))
 	defer cancel()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	event := c.log.append(docname, evType, payload)
 	subs, _ := c.subs.Query(ctx, string(rune(0)), string(rune(127)))
 	for _, v := range subs {
 		lower, upper := v.Value.lo, v.Value.hi
 		if lower <= docname && docname <= upper { //notify based on the ranges they are listening to
-			*v.Value.ch <- c.GenerateEvent(evType, payload)
+			if v.Value.filter == nil {
+				c.deliver(v.Key, v.Value, event)
+			} else if filtered := c.filterEvent(v.Value, docname, evType, payload, event); filtered != nil {
+				c.deliver(v.Key, v.Value, filtered)
+			}
 		}
 
 	}
 }
 
-// NotifyAll sends a "delete" event to all subscribers of a collection.
+// filterEvent translates event, of type evType about the document docname carrying payload, for the filtered
+// subscriber sub, and records whether the document now satisfies its filter. The caller must hold c.mu.
+// Returns the event to send, or nil if the subscriber is not sent about it
+func (c *ColSubscriptionManager) filterEvent(sub Colsubscriber, docname string, evType string, payload []byte, event []byte) []byte {
+	was := sub.matching[docname]
+	now := evType != "delete" && sub.filter(payload)
+	if now {
+		sub.matching[docname] = true
+	} else {
+		delete(sub.matching, docname)
+	}
+	switch {
+	case was && (now || evType == "delete"):
+		return event
+	case now:
+		return formatEvent("enter", payload, c.log.id(c.log.seq))
+	case was:
+		path, _ := json.Marshal(docPath(payload))
+		return formatEvent("leave", path, c.log.id(c.log.seq))
+	}
+	return nil
+}
+
+// docPath reads the path out of a serialized document
+func docPath(serial []byte) string {
+	var doc struct {
+		Path string `json:"path"`
+	}
+	json.Unmarshal(serial, &doc)
+	return doc.Path
+}
+
+// docName reads the name out of a serialized document
+func docName(serial []byte) string {
+	p := docPath(serial)
+	return p[strings.LastIndex(p, "/")+1:]
+}
+
+// NotifyAll sends a "delete" event to all subscribers of a collection, then removes them and closes their channels.
 // It notifies every active subscriber regardless of their specific range.
 func (c *ColSubscriptionManager) NotifyAll(colname string) {
 	slog.Debug(fmt.Sprintf("Notifying subscribers using about an update to %s", colname))
 	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(10*time.Second))
 	defer cancel()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	event := c.log.append("", "delete", []byte(colname))
 	subs, _ := c.subs.Query(ctx, string(rune(0)), string(rune(127)))
 	for _, v := range subs {
 
 		//notify based on the ranges they are listening to
-		*v.Value.ch <- c.GenerateEvent("delete", []byte(colname))
+		if c.deliver(v.Key, v.Value, event) {
+			c.subs.Remove(v.Key)
+			close(*v.Value.ch)
+		}
 
 	}
 }
 
+// deliver queues event for the subscriber sub, whose id is id, disconnecting it if the delivery policy says so.
+// The caller must hold c.mu.
+// Returns false if the subscriber was disconnected
+func (c *ColSubscriptionManager) deliver(id string, sub Colsubscriber, event []byte) bool {
+	if !c.delivery.deliver(*sub.ch, event) {
+		slog.Warn("Disconnecting a subscriber that fell behind", "id", id)
+		c.subs.Remove(id)
+		c.delivery.disconnect(*sub.ch)
+		return false
+	}
+	return true
+}
+
 // Remove removes a subscriber from the collection
 // It closes the channel associated with the subscriber
 func (c *ColSubscriptionManager) Remove(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
 	slog.Debug(fmt.Sprintf("Removing subscriber with id %s \n]]", id))
-	_, removed := c.subs.Remove(id)
+	removedSub, removed := c.subs.Remove(id)
 	if removed == false {
-		slog.Warn(fmt.Sprintf("Warning: a removal of a subscriber was unsuccessful"))
+		slog.Debug(fmt.Sprintf("The subscriber %s was already removed", id))
 		return
 	}
+	close(*removedSub.ch)
 
 }
 
 // GenerateEvent creates a formatted server-sent event (SSE) message.
-// The event type can be "update" or "delete", and the event data is included in the payload.
+// The event type can be "update" or "delete", and the event data is included in the payload. The event carries the
+// ID of the latest event sent, and is not logged.
 func (c *ColSubscriptionManager) GenerateEvent(evType string, payload []byte) []byte {
-	res := ""
-	if evType == "update" {
-		res += "event: update\n"
-	} else if evType == "delete" {
-		res += "event: delete\n"
-	}
-	res += "data: " + string(payload) + "\n"
-	res += "id: " + strconv.FormatInt(time.Now().UnixMilli(), 10)
-	res += "\n\n"
-	return []byte(res)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return formatEvent(evType, payload, c.log.id(c.log.seq))
 }
@@ -1,67 +1,164 @@
 // Package subscriptionManager manages subscriptions to documents and enables real-time notifications to clients
-// when documents are updated. It provides the necessary structures to add subscribers, notify them of changes, 
+// when documents are updated. It provides the necessary structures to add subscribers, notify them of changes,
 // and maintain these active subscriptions within a Messager object.
 package subscriptionManager
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"github.com/RICE-COMP318-FALL24/owldb-p1group24/index_utils"
 	"log/slog"
+	"strings"
+	"time"
 )
 
 // SubscriptionManager manages a list of subscribers to a document
 type UriToDocs[uri string, docSubs *SubscriptionManager] interface {
 	Upsert(key string, check index_utils.UpdateCheck[uri, docSubs]) (updated bool, err error) // Upserts (inserts or updates) a subscriber.
 	Find(key string) (foundDsm *SubscriptionManager, found bool)
+	Query(ctx context.Context, low uri, hi uri) (res []index_utils.Pair[uri, docSubs], err error) // Queries the documents whose URIs lie within a range.
 }
 
 // Messager contains all active subscriptions
 type Messager struct {
-	idtosubfactory IdToSubFactory // Factory function for creating a new IdToSub
-	docSubs UriToDocs[string, *SubscriptionManager] // Map of document URIs to their SubscriptionManagers
+	idtosubfactory IdToSubFactory                          // Factory function for creating a new IdToSub
+	delivery       Delivery                                // Says how events are queued for subscribers
+	docSubs        UriToDocs[string, *SubscriptionManager] // Map of document URIs to their SubscriptionManagers
+	treeSubs       UriToDocs[string, *SubscriptionManager] // Map of the URIs of subtree roots to their SubscriptionManagers
+	publisher      Publisher                               // Is told about every change, if not nil
+}
+
+// Publisher is told about every change subscribers are notified of, such as to deliver it to webhooks
+type Publisher interface {
+	Publish(uri string, evType string, payload []byte) // Publishes an event of type evType about the resource at uri
 }
 
 // IdToSubFactory is a factory function for
 type IdToSubFactory func() IdToSub[string, *chan []byte]
 
-// NewMessager creates a new Messager instance with the given IdToSubFactory and UriToDocs.
+// NewMessager creates a new Messager instance with the given IdToSubFactory and UriToDocs, one for subscriptions to
+// documents and one for subscriptions to subtrees, queuing events for subscribers as delivery says. Every change is
+// also published to publisher, unless it is nil.
 // It returns a pointer to the new Messager.
-func NewMessager(idtosubfactory IdToSubFactory, docsubs UriToDocs[string, *SubscriptionManager]) *Messager {
+func NewMessager(idtosubfactory IdToSubFactory, docsubs UriToDocs[string, *SubscriptionManager], treesubs UriToDocs[string, *SubscriptionManager], delivery Delivery, publisher Publisher) *Messager {
 	return &Messager{
 		idtosubfactory: idtosubfactory, // Factory function for creating a new IdToSub
-		docSubs:        docsubs, // Map of document URIs to their SubscriptionManagers
+		delivery:       delivery,       // Says how events are queued for subscribers
+		docSubs:        docsubs,        // Map of document URIs to their SubscriptionManagers
+		treeSubs:       treesubs,       // Map of the URIs of subtree roots to their SubscriptionManagers
+		publisher:      publisher,      // Is told about every change, if not nil
 	}
 }
 
 // AddDocSubscriber adds a subscriber to a resource located at uri. It returns the initial sse sent to the client,
-// alongside a channel to listen on
-func (m *Messager) AddDocSubscriber(uri string) (*chan []byte, string) {
+// alongside a channel to listen on: the events missed since lastEventID if the client is resuming a subscription,
+// or else an update event carrying snapshot, the current state of the resource
+func (m *Messager) AddDocSubscriber(uri string, lastEventID string, snapshot []byte) (*chan []byte, string, []byte) {
+	slog.Debug(fmt.Sprintf("Adding a subscriber to the doc at uri %s", uri))
+	resChan, id, events := m.addSubscriber(m.docSubs, uri, lastEventID, [][]byte{snapshot})
+	return resChan, id, bytes.Join(events, nil)
+}
+
+// AddTreeSubscriber adds a subscriber to the subtree rooted at uri: a document, a collection if uri ends with "/",
+// or every document of a database if uri is the name of the database followed by "/". It returns the events sent
+// to the client first, alongside a channel to listen on: the events missed since lastEventID if the client is
+// resuming a subscription, or else an update event for each payload of snapshot, the current state of the subtree
+func (m *Messager) AddTreeSubscriber(uri string, lastEventID string, snapshot [][]byte) (*chan []byte, string, [][]byte) {
+	slog.Debug(fmt.Sprintf("Adding a subscriber to the subtree at uri %s", uri))
+	return m.addSubscriber(m.treeSubs, uri, lastEventID, snapshot)
+}
+
+// addSubscriber adds a subscriber to the SubscriptionManager of subs at uri, creating it if needed
+func (m *Messager) addSubscriber(subs UriToDocs[string, *SubscriptionManager], uri string, lastEventID string, snapshot [][]byte) (*chan []byte, string, [][]byte) {
 	var resChan *chan []byte
 	var id string
-	slog.Debug(fmt.Sprintf("Adding a subscriber to the doc at uri %s", uri))
+	var events [][]byte
 	check := func(uri string, curDsm *SubscriptionManager, exists bool) (newDsm *SubscriptionManager, err error) {
 		if exists {
 
-			resChan, id = curDsm.AddSubscriber()
+			resChan, id, events = curDsm.AddSubscriber(lastEventID, snapshot)
 			return curDsm, nil
 		} else {
 
 			idtosub := m.idtosubfactory()
-			newDsm = New(idtosub)
-			resChan, id = newDsm.AddSubscriber()
+			newDsm = New(idtosub, m.delivery)
+			resChan, id, events = newDsm.AddSubscriber(lastEventID, snapshot)
 		}
 		return newDsm, nil
 	}
-	m.docSubs.Upsert(uri, check)
-	return resChan, id
+	subs.Upsert(uri, check)
+	return resChan, id, events
 }
 
-// NotifyDocs notifies all subscribers to a document about an update
+// NotifyDocs notifies all subscribers to the document at uri, or to a collection if uri ends with "/", about an
+// update, along with the subscribers to every subtree holding it, and publishes it. A delete event is also sent to the subscribers
+// of every document and subtree beneath uri, and ends the subscriptions of all of them
 func (m *Messager) NotifyDocs(uri string, evtype string, payload []byte) {
+	if m.publisher != nil {
+		m.publisher.Publish(uri, evtype, payload)
+	}
 	dsm, found := m.docSubs.Find(uri)
 
 	if found {
 		slog.Debug("Found, going to notify now!")
 		dsm.Notify(evtype, payload)
 	}
+	for _, root := range treeRoots(uri) {
+		if tsm, found := m.treeSubs.Find(root); found {
+			tsm.Notify(evtype, payload)
+		}
+	}
+	if evtype != "delete" {
+		return
+	}
+	if found {
+		dsm.CloseAll()
+	}
+	if tsm, found := m.treeSubs.Find(uri); found {
+		tsm.CloseAll()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	prefix := strings.TrimSuffix(uri, "/") + "/"
+	for _, subs := range []UriToDocs[string, *SubscriptionManager]{m.docSubs, m.treeSubs} {
+		beneath, _ := subs.Query(ctx, prefix, prefix+string(rune(127)))
+		for _, sub := range beneath {
+			if sub.Key == uri {
+				continue
+			}
+			sub.Value.Notify(evtype, payload)
+			sub.Value.CloseAll()
+		}
+	}
+}
+
+// treeRoots lists the URIs of the subtrees holding the resource at uri, from the database down to the resource
+func treeRoots(uri string) []string {
+	parts := strings.Split(strings.TrimSuffix(uri, "/"), "/")
+	roots := []string{parts[0] + "/"}
+	for i := 1; i < len(parts); i++ {
+		root := strings.Join(parts[:i+1], "/")
+		if i%2 == 0 { //a collection
+			root += "/"
+		}
+		roots = append(roots, root)
+	}
+	return roots
+}
+
+// RemoveDocSubscriber removes the subscriber with id id from the document at uri, closing its channel. Nothing
+// happens if it was already removed
+func (m *Messager) RemoveDocSubscriber(uri string, id string) {
+	if dsm, found := m.docSubs.Find(uri); found {
+		dsm.RemoveSubscriber(id)
+	}
+}
+
+// RemoveTreeSubscriber removes the subscriber with id id from the subtree rooted at uri, closing its channel.
+// Nothing happens if it was already removed
+func (m *Messager) RemoveTreeSubscriber(uri string, id string) {
+	if tsm, found := m.treeSubs.Find(uri); found {
+		tsm.RemoveSubscriber(id)
+	}
 }
@@ -0,0 +1,113 @@
+package subscriptionManager
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+)
+
+// DefaultDepth is the number of events queued for each subscriber unless configured otherwise
+const DefaultDepth = 64
+
+// Policy decides what happens to an event sent to a subscriber whose queue is full
+type Policy int
+
+const (
+	DropOldest Policy = iota // the oldest event queued is dropped to make room for the new one
+	Disconnect               // the queued events are dropped, and the subscriber sent a terminal event and disconnected
+)
+
+// ParsePolicy reads a policy from its name, "drop-oldest" or "disconnect".
+// Returns the policy, or an error if the name is unknown
+func ParsePolicy(name string) (Policy, error) {
+	switch name {
+	case "drop-oldest":
+		return DropOldest, nil
+	case "disconnect":
+		return Disconnect, nil
+	}
+	return DropOldest, fmt.Errorf("unknown subscriber policy %q", name)
+}
+
+// Delivery configures how events are queued for subscribers. Events are never sent to a subscriber by waiting for it
+// to read them, so a slow or dead subscriber cannot hold up the writes it is notified of.
+type Delivery struct {
+	Depth   int      // the number of events queued for each subscriber, at least 1
+	Policy  Policy   // what happens to events sent to a subscriber whose queue is full
+	Metrics *Metrics // counts the events delivered and dropped, if not nil
+}
+
+// newQueue creates the queue of a new subscriber
+func (d Delivery) newQueue() chan []byte {
+	return make(chan []byte, max(d.Depth, 1))
+}
+
+// deliver queues event for the subscriber reading from ch, applying the policy if its queue is full. Only one
+// goroutine may deliver to a subscriber at a time.
+// Returns false if the subscriber must be disconnected
+func (d Delivery) deliver(ch chan []byte, event []byte) bool {
+	select {
+	case ch <- event:
+		d.Metrics.record(1, 0, 0)
+		return true
+	default:
+	}
+	if d.Policy == Disconnect {
+		return false
+	}
+	select {
+	case <-ch:
+		d.Metrics.record(0, 1, 0)
+	default: //the subscriber caught up in the meantime
+	}
+	select {
+	case ch <- event:
+		d.Metrics.record(1, 0, 0)
+	default:
+		d.Metrics.record(0, 1, 0)
+	}
+	return true
+}
+
+// disconnect drops the events queued for the subscriber reading from ch, sends it a terminal event and closes ch.
+// The terminal event carries no ID, so that a subscriber resuming afterwards is sent the events it missed.
+func (d Delivery) disconnect(ch chan []byte) {
+	for drained := false; !drained; {
+		select {
+		case <-ch:
+			d.Metrics.record(0, 1, 0)
+		default:
+			drained = true
+		}
+	}
+	d.Metrics.record(0, 1, 1) //the event that did not fit
+	msg, _ := json.Marshal("subscriber fell too far behind")
+	ch <- formatEvent("disconnect", msg, "")
+	close(ch)
+}
+
+// Metrics counts the events sent to subscribers. It is safe for concurrent use.
+type Metrics struct {
+	delivered    atomic.Int64 // events queued for a subscriber
+	dropped      atomic.Int64 // events dropped because a queue was full
+	disconnected atomic.Int64 // subscribers disconnected because their queue was full
+}
+
+// record adds to the counters of m, if m is not nil
+func (m *Metrics) record(delivered int64, dropped int64, disconnected int64) {
+	if m == nil {
+		return
+	}
+	m.delivered.Add(delivered)
+	m.dropped.Add(dropped)
+	m.disconnected.Add(disconnected)
+}
+
+// MarshalJSON encodes the current value of every counter
+func (m *Metrics) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Delivered    int64 `json:"delivered"`
+		Dropped      int64 `json:"dropped"`
+		Disconnected int64 `json:"disconnected"`
+	}{m.delivered.Load(), m.dropped.Load(), m.disconnected.Load()})
+}
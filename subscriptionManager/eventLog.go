@@ -0,0 +1,111 @@
+package subscriptionManager
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EventLogLimit is the number of recent events kept for each resource, which reconnecting subscribers may replay
+const EventLogLimit = 256
+
+// loggedEvent is an event kept in an eventLog
+type loggedEvent struct {
+	seq   int64  // the sequence number of the event
+	key   string // the document the event is about, or "" if it concerns every subscriber
+	event []byte // the formatted event
+}
+
+// eventLog numbers the events sent about a resource and keeps the most recent ones. Event IDs are made of the time
+// the log was started and the sequence number of the event, so that IDs handed out by an earlier log, one kept
+// before a restart or for a deleted resource, are never mistaken for its own.
+type eventLog struct {
+	epoch  string        // the time the log was started, in Unix milliseconds
+	seq    int64         // the sequence number of the latest event
+	events []loggedEvent // the most recent events, oldest first
+}
+
+// newEventLog creates an empty event log
+func newEventLog() eventLog {
+	return eventLog{epoch: strconv.FormatInt(time.Now().UnixMilli(), 10)}
+}
+
+// id returns the ID of the event numbered seq
+func (l *eventLog) id(seq int64) string {
+	return l.epoch + "-" + strconv.FormatInt(seq, 10)
+}
+
+// append numbers a new event of type evType about the document key, and keeps it.
+// Returns the formatted event
+func (l *eventLog) append(key string, evType string, payload []byte) []byte {
+	l.seq++
+	event := formatEvent(evType, payload, l.id(l.seq))
+	l.events = append(l.events, loggedEvent{seq: l.seq, key: key, event: event})
+	if len(l.events) > EventLogLimit {
+		l.events = l.events[len(l.events)-EventLogLimit:]
+	}
+	return event
+}
+
+// since finds the events logged after the event lastEventID whose document is selected by match.
+// Returns the events, or false if lastEventID was not handed out by this log or some events since are not kept
+func (l *eventLog) since(lastEventID string, match func(key string) bool) ([][]byte, bool) {
+	epoch, num, found := strings.Cut(lastEventID, "-")
+	seq, err := strconv.ParseInt(num, 10, 64)
+	if !found || err != nil || epoch != l.epoch || seq < 0 || seq > l.seq {
+		return nil, false
+	}
+	if len(l.events) > 0 && seq < l.events[0].seq-1 {
+		return nil, false
+	}
+	missed := make([][]byte, 0)
+	for _, ev := range l.events {
+		if ev.seq > seq && match(ev.key) {
+			missed = append(missed, ev.event)
+		}
+	}
+	return missed, true
+}
+
+// replay picks the events a new subscriber starts with: the events it missed since lastEventID if it is resuming
+// and they are all kept, or else those resync picks.
+// Returns the events
+func (l *eventLog) replay(lastEventID string, snapshot [][]byte, match func(key string) bool) [][]byte {
+	if lastEventID != "" {
+		if missed, ok := l.since(lastEventID, match); ok {
+			return missed
+		}
+	}
+	return l.resync(lastEventID, snapshot)
+}
+
+// resync picks the events a new subscriber starts with when it does not resume from lastEventID: an update event for
+// each payload of snapshot, preceded by a resync event if it tried to.
+// Returns the events
+func (l *eventLog) resync(lastEventID string, snapshot [][]byte) [][]byte {
+	events := make([][]byte, 0, len(snapshot)+1)
+	if lastEventID != "" {
+		msg, _ := json.Marshal(fmt.Sprintf("events since %s are no longer available", lastEventID))
+		events = append(events, formatEvent("resync", msg, l.id(l.seq)))
+	}
+	for _, payload := range snapshot {
+		events = append(events, formatEvent("update", payload, l.id(l.seq)))
+	}
+	return events
+}
+
+// formatEvent formats a server-sent event of type evType, carrying payload, with ID id if it is not empty
+func formatEvent(evType string, payload []byte, id string) []byte {
+	res := ""
+	if evType != "" {
+		res += "event: " + evType + "\n"
+	}
+	res += "data: " + string(payload) + "\n"
+	if id != "" {
+		res += "id: " + id + "\n"
+	}
+	res += "\n"
+	return []byte(res)
+}
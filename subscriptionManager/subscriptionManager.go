@@ -8,7 +8,7 @@ import (
 	"github.com/RICE-COMP318-FALL24/owldb-p1group24/index_utils"
 	"log/slog"
 	"math/rand/v2"
-	"strconv"
+	"sync"
 	"time"
 )
 
@@ -21,11 +21,14 @@ type IdToSub[id string, ch *chan []byte] interface {
 	Query(ctx context.Context, low id, upper id) (res []index_utils.Pair[id, ch], err error) // Query retrieves subscribers within a range of IDs.
 }
 
-// New creates a new SubscriptionManager and initializes its subscriber management system.
-func New(subs IdToSub[string, *chan []byte]) *SubscriptionManager {
+// New creates a new SubscriptionManager and initializes its subscriber management system, queuing events for its
+// subscribers as delivery says.
+func New(subs IdToSub[string, *chan []byte], delivery Delivery) *SubscriptionManager {
 	return &SubscriptionManager{
 		idCounter: 1,
-		subs:      subs}
+		subs:      subs,
+		delivery:  delivery,
+		log:       newEventLog()}
 }
 
 // SubscriptionManager is responsible for managing subscribers and their channels.
@@ -33,13 +36,20 @@ func New(subs IdToSub[string, *chan []byte]) *SubscriptionManager {
 type SubscriptionManager struct {
 	idCounter int                           // Tracks the next subscriber ID.
 	subs      IdToSub[string, *chan []byte] // Manages the channels for subscribers.
+	delivery  Delivery                      // Says how events are queued for subscribers.
+	mu        sync.Mutex                    // Orders the logging and delivery of events with the arrival and departure of subscribers.
+	log       eventLog                      // Keeps the most recent events, for subscribers resuming a subscription.
 }
 
 // AddSubscriber adds a new subscriber to the subscription manager.
-// It creates a new channel for the subscriber, assigns an ID, and returns the channel and ID.
-func (s *SubscriptionManager) AddSubscriber() (*chan []byte, string) {
+// It creates a new channel for the subscriber, assigns an ID, and returns the channel and ID, along with the events
+// the subscriber starts with: those it missed since lastEventID if it is resuming a subscription, or else an update
+// event for each payload of snapshot, preceded by a resync event if the missed events are no longer kept.
+func (s *SubscriptionManager) AddSubscriber(lastEventID string, snapshot [][]byte) (*chan []byte, string, [][]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	slog.Debug(fmt.Sprintf("Current subscriber count is %d", s.idCounter))
-	newCh := make(chan []byte)
+	newCh := s.delivery.newQueue()
 	slog.Debug("Hello from s.Addsucrbiber")
 	chk := func(key string, curV *chan []byte, exists bool) (ch *chan []byte, err error) {
 		return &newCh, nil
@@ -50,48 +60,69 @@ func (s *SubscriptionManager) AddSubscriber() (*chan []byte, string) {
 
 	slog.Debug(fmt.Sprintf("Current subscriber count is %d", s.idCounter))
 
-	return &newCh, id
+	all := func(string) bool { return true }
+	return &newCh, id, s.log.replay(lastEventID, snapshot, all)
 }
 
 // RemoveSubscriber removes a subscriber with id id
-// It closes the channel associated with the subscriber
+// It closes the channel associated with the subscriber, unless it was already disconnected
 func (s *SubscriptionManager) RemoveSubscriber(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	slog.Debug(fmt.Sprintf("Removing a subscriber whose id is %s", id))
-	removedChan, _ := s.subs.Remove(id)
+	removedChan, removed := s.subs.Remove(id)
+	if !removed {
+		return
+	}
 
 	ch := *removedChan
 	close(ch)
 }
 
-// Notify will send every subscriber an SSE of type evType, with payload byte
+// CloseAll removes every subscriber, closing their channels
+func (s *SubscriptionManager) CloseAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	subscribers, _ := s.subs.Query(ctx, string(rune(0)), string(rune(127)))
+	for _, sub := range subscribers {
+		if _, removed := s.subs.Remove(sub.Key); removed {
+			close(*sub.Value)
+		}
+	}
+}
+
+// Notify will send every subscriber an SSE of type evType, with payload byte, without waiting for any of them.
+// Subscribers whose queue is full are handled as the delivery policy says
 func (s *SubscriptionManager) Notify(evType string, payload []byte) {
 	slog.Debug(fmt.Sprintf("DOCSUB MANAGER,payload is %s", string(payload)))
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	slog.Debug(fmt.Sprintf("Current sub counter is %d", s.idCounter))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	event := s.log.append("", evType, payload)
 	subscribers, _ := s.subs.Query(ctx, string(rune(0)), string(rune(127))) //we need to notify everyone
 
 	for _, sub := range subscribers {
 		slog.Debug("Notifying subscriber")
 		v := sub.Value
-		*v <- s.GenerateEvent(evType, payload)
+		if !s.delivery.deliver(*v, event) {
+			slog.Warn("Disconnecting a subscriber that fell behind", "id", sub.Key)
+			s.subs.Remove(sub.Key)
+			s.delivery.disconnect(*v)
+		}
 
 	}
 }
 
-// GenerateEvent generates a formatted sse of type evtype, with payload payload
+// GenerateEvent generates a formatted sse of type evtype, with payload payload, carrying the ID of the latest event
+// sent. The event is not logged
 func (s *SubscriptionManager) GenerateEvent(evtype string, payload []byte) []byte {
-
-	res := ""
-	if evtype == "update" {
-		res += "event: update\n"
-	} else if evtype == "delete" {
-		res += "event: delete\n"
-	}
-	res += "data: " + string(payload) + "\n"
-	res += "id: " + strconv.FormatInt(time.Now().UnixMilli(), 10)
-	res += "\n\n"
-	return []byte(res)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return formatEvent(evtype, payload, s.log.id(s.log.seq))
 }
 
 // generateResourceName is an internal routine to generate the name of a resource
@@ -1,27 +1,29 @@
 package subscriptionManager
 
 import (
+	"encoding/json"
 	"fmt"
 
 	"github.com/RICE-COMP318-FALL24/owldb-p1group24/mocks"
 	"regexp"
+	"strings"
 	"testing"
 	"time"
 )
 
 func TestSubscriptionManager_AddSubscriber(t *testing.T) {
 
-	sm := New(mocks.NewMockSL[string, *chan []byte]())
+	sm := New(mocks.NewMockSL[string, *chan []byte](), Delivery{Depth: DefaultDepth})
 
-	subChan, _ := sm.AddSubscriber()
+	subChan, _, _ := sm.AddSubscriber("", nil)
 	if subChan == nil {
 		t.Errorf("AddSubscriber failed, no channel was give")
 	}
 }
 
 func TestSubscriptionManager_Notify(t *testing.T) {
-	sm := New(mocks.NewMockSL[string, *chan []byte]())
-	subChan, _ := sm.AddSubscriber()
+	sm := New(mocks.NewMockSL[string, *chan []byte](), Delivery{Depth: DefaultDepth})
+	subChan, _, _ := sm.AddSubscriber("", nil)
 	if subChan == nil {
 		t.Errorf("AddSubscriber failed, no channel was give")
 	}
@@ -35,8 +37,8 @@ func TestSubscriptionManager_Notify(t *testing.T) {
 }
 
 func TestSubscriptionManager_RemoveSubscriber(t *testing.T) {
-	sm := New(mocks.NewMockSL[string, *chan []byte]())
-	subChan, id := sm.AddSubscriber()
+	sm := New(mocks.NewMockSL[string, *chan []byte](), Delivery{Depth: DefaultDepth})
+	subChan, id, _ := sm.AddSubscriber("", nil)
 	if subChan == nil {
 		t.Errorf("AddSubscriber failed, no channel was give")
 	}
@@ -49,7 +51,7 @@ func TestSubscriptionManager_RemoveSubscriber(t *testing.T) {
 }
 
 func TestSubscriptionManager_GenerateEvent(t *testing.T) {
-	sm := New(mocks.NewMockSL[string, *chan []byte]())
+	sm := New(mocks.NewMockSL[string, *chan []byte](), Delivery{Depth: DefaultDepth})
 
 	eventUpdate := sm.GenerateEvent("update", []byte("payload"))
 
@@ -68,8 +70,8 @@ func TestSubscriptionManager_GenerateEvent(t *testing.T) {
 
 func TestColSubscriptionManager_AddSubscriber(t *testing.T) {
 	subs := mocks.NewMockSL[string, Colsubscriber]()
-	sm := NewColSubManager(subs)
-	ch, _ := sm.AddSubscriber("a", "d")
+	sm := NewColSubManager(subs, Delivery{Depth: DefaultDepth})
+	ch, _, _ := sm.AddSubscriber("a", "d", nil, "", nil)
 	if ch == nil {
 		t.Errorf("TestColSubscriptionManager failed")
 	}
@@ -78,11 +80,11 @@ func TestColSubscriptionManager_AddSubscriber(t *testing.T) {
 
 func TestColSubscriptionManager_Notify(t *testing.T) {
 	subs := mocks.NewMockSL[string, Colsubscriber]()
-	sm := NewColSubManager(subs)
+	sm := NewColSubManager(subs, Delivery{Depth: DefaultDepth})
 
-	chanAD, _ := sm.AddSubscriber("a", "d")
+	chanAD, _, _ := sm.AddSubscriber("a", "d", nil, "", nil)
 
-	chanBG, _ := sm.AddSubscriber("b", "g")
+	chanBG, _, _ := sm.AddSubscriber("b", "g", nil, "", nil)
 
 	go sm.Notify("a", "update", []byte("payload"))
 	for {
@@ -109,8 +111,8 @@ func TestMessager_AddDocSubscriber(t *testing.T) {
 	var idtosubfactory IdToSubFactory = func() IdToSub[string, *chan []byte] {
 		return mocks.NewMockSL[string, *chan []byte]()
 	}
-	messager := NewMessager(idtosubfactory, sl)
-	ch, _ := messager.AddDocSubscriber("db/doc1")
+	messager := NewMessager(idtosubfactory, sl, mocks.NewMockSL[string, *SubscriptionManager](), Delivery{Depth: DefaultDepth}, nil)
+	ch, _, _ := messager.AddDocSubscriber("db/doc1", "", nil)
 	if ch == nil {
 		t.Errorf("AddDocSubscriber failed, channel returned was nil")
 	}
@@ -121,13 +123,13 @@ func TestMessager_AddMultipleDocSubscribers(t *testing.T) {
 	var idtosubfactory IdToSubFactory = func() IdToSub[string, *chan []byte] {
 		return mocks.NewMockSL[string, *chan []byte]()
 	}
-	messager := NewMessager(idtosubfactory, sl)
-	ch, id1 := messager.AddDocSubscriber("db/doc1")
+	messager := NewMessager(idtosubfactory, sl, mocks.NewMockSL[string, *SubscriptionManager](), Delivery{Depth: DefaultDepth}, nil)
+	ch, id1, _ := messager.AddDocSubscriber("db/doc1", "", nil)
 	if ch == nil {
 		t.Errorf("AddDocSubscriber failed, channel returned was nil")
 	}
 
-	ch2, id2 := messager.AddDocSubscriber("db/doc1")
+	ch2, id2, _ := messager.AddDocSubscriber("db/doc1", "", nil)
 	if ch == ch2 {
 		t.Errorf("AddMultipleSubscriber failed, got the same channel twice")
 	}
@@ -141,8 +143,8 @@ func TestMessager_NotifyDocs(t *testing.T) {
 	var idtosubfactory IdToSubFactory = func() IdToSub[string, *chan []byte] {
 		return mocks.NewMockSL[string, *chan []byte]()
 	}
-	messager := NewMessager(idtosubfactory, sl)
-	ch, _ := messager.AddDocSubscriber("db/doc1")
+	messager := NewMessager(idtosubfactory, sl, mocks.NewMockSL[string, *SubscriptionManager](), Delivery{Depth: DefaultDepth}, nil)
+	ch, _, _ := messager.AddDocSubscriber("db/doc1", "", nil)
 	if ch == nil {
 		t.Errorf("AddDocSubscriber failed, channel returned was nil")
 	}
@@ -159,3 +161,271 @@ func TestMessager_NotifyDocs(t *testing.T) {
 		return
 	}
 }
+
+// eventID extracts the ID of a formatted event
+func eventID(event []byte) string {
+	return regexp.MustCompile(`id: (\S+)`).FindStringSubmatch(string(event))[1]
+}
+
+func TestSubscriptionManager_Resume(t *testing.T) {
+	sm := New(mocks.NewMockSL[string, *chan []byte](), Delivery{Depth: DefaultDepth})
+	_, id, events := sm.AddSubscriber("", [][]byte{[]byte("snapshot")})
+	if len(events) != 1 || !strings.HasPrefix(string(events[0]), "event: update\ndata: snapshot\n") {
+		t.Fatalf("AddSubscriber failed, expected the snapshot, got %q", events)
+	}
+	sm.RemoveSubscriber(id)
+	last := eventID(events[0])
+	for i := 1; i <= 3; i++ {
+		sm.Notify("update", []byte(fmt.Sprintf("payload%d", i)))
+	}
+
+	_, id, events = sm.AddSubscriber(last, [][]byte{[]byte("snapshot")})
+	sm.RemoveSubscriber(id)
+	if len(events) != 3 || !strings.Contains(string(events[0]), "payload1") || !strings.Contains(string(events[2]), "payload3") {
+		t.Errorf("AddSubscriber failed, expected the 3 missed events, got %q", events)
+	}
+	_, id, events = sm.AddSubscriber(eventID(events[1]), [][]byte{[]byte("snapshot")})
+	sm.RemoveSubscriber(id)
+	if len(events) != 1 || !strings.Contains(string(events[0]), "payload3") {
+		t.Errorf("AddSubscriber failed, expected the last event only, got %q", events)
+	}
+	_, id, events = sm.AddSubscriber(eventID(events[0]), [][]byte{[]byte("snapshot")})
+	sm.RemoveSubscriber(id)
+	if events == nil || len(events) != 0 {
+		t.Errorf("AddSubscriber failed, expected nothing to replay, got %q", events)
+	}
+}
+
+func TestSubscriptionManager_Resync(t *testing.T) {
+	sm := New(mocks.NewMockSL[string, *chan []byte](), Delivery{Depth: DefaultDepth})
+	sm.Notify("update", []byte("first"))
+	_, id, events := sm.AddSubscriber("", nil)
+	sm.RemoveSubscriber(id)
+	old := sm.GenerateEvent("update", []byte("first"))
+	for i := 0; i < EventLogLimit+1; i++ {
+		sm.Notify("update", []byte("payload"))
+	}
+
+	for _, lastEventID := range []string{eventID(old), "1234", "0-1", eventID(old) + "0000"} {
+		_, id, events = sm.AddSubscriber(lastEventID, [][]byte{[]byte("snapshot")})
+		sm.RemoveSubscriber(id)
+		if len(events) != 2 || !strings.HasPrefix(string(events[0]), "event: resync\n") || !strings.Contains(string(events[1]), "snapshot") {
+			t.Errorf("AddSubscriber failed, expected a resync for %s, got %q", lastEventID, events)
+		}
+	}
+}
+
+func TestColSubscriptionManager_Resume(t *testing.T) {
+	sm := NewColSubManager(mocks.NewMockSL[string, Colsubscriber](), Delivery{Depth: DefaultDepth})
+	_, id, events := sm.AddSubscriber("a", "c", nil, "", [][]byte{[]byte("a"), []byte("b")})
+	sm.Remove(id)
+	if len(events) != 2 {
+		t.Fatalf("AddSubscriber failed, expected an event for each document, got %q", events)
+	}
+	sm.Notify("b", "update", []byte("inside"))
+	sm.Notify("x", "update", []byte("outside"))
+	sm.NotifyAll("/col/")
+
+	_, id, events = sm.AddSubscriber("a", "c", nil, eventID(events[1]), nil)
+	sm.Remove(id)
+	if len(events) != 2 || !strings.Contains(string(events[0]), "inside") || !strings.HasPrefix(string(events[1]), "event: delete\n") {
+		t.Errorf("AddSubscriber failed, expected the missed events in range, got %q", events)
+	}
+}
+
+func TestSubscriptionManager_DropOldest(t *testing.T) {
+	metrics := &Metrics{}
+	sm := New(mocks.NewMockSL[string, *chan []byte](), Delivery{Depth: 2, Policy: DropOldest, Metrics: metrics})
+	subChan, _, _ := sm.AddSubscriber("", nil)
+	done := make(chan bool)
+	go func() {
+		for i := 1; i <= 5; i++ {
+			sm.Notify("update", []byte(fmt.Sprintf("payload%d", i)))
+		}
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Notify failed, blocked on a subscriber that does not read")
+	}
+	first, second := <-*subChan, <-*subChan
+	if !strings.Contains(string(first), "payload4") || !strings.Contains(string(second), "payload5") {
+		t.Errorf("Notify failed, expected the newest events to be kept, got %q and %q", first, second)
+	}
+	b, _ := json.Marshal(metrics)
+	if string(b) != `{"delivered":5,"dropped":3,"disconnected":0}` {
+		t.Errorf("Notify failed, unexpected metrics %s", b)
+	}
+}
+
+func TestSubscriptionManager_Disconnect(t *testing.T) {
+	metrics := &Metrics{}
+	sm := New(mocks.NewMockSL[string, *chan []byte](), Delivery{Depth: 1, Policy: Disconnect, Metrics: metrics})
+	subChan, id, _ := sm.AddSubscriber("", nil)
+	sm.Notify("update", []byte("payload1"))
+	sm.Notify("update", []byte("payload2"))
+	sm.Notify("update", []byte("payload3"))
+
+	if event := <-*subChan; !strings.HasPrefix(string(event), "event: disconnect\n") || strings.Contains(string(event), "id:") {
+		t.Errorf("Notify failed, expected a terminal event without an ID, got %q", event)
+	}
+	if _, ok := <-*subChan; ok {
+		t.Errorf("Notify failed, expected the channel to be closed")
+	}
+	sm.RemoveSubscriber(id)
+	b, _ := json.Marshal(metrics)
+	if string(b) != `{"delivered":1,"dropped":2,"disconnected":1}` {
+		t.Errorf("Notify failed, unexpected metrics %s", b)
+	}
+}
+
+func TestColSubscriptionManager_Disconnect(t *testing.T) {
+	sm := NewColSubManager(mocks.NewMockSL[string, Colsubscriber](), Delivery{Depth: 1, Policy: Disconnect})
+	slow, _, _ := sm.AddSubscriber("a", "c", nil, "", nil)
+	other, _, _ := sm.AddSubscriber("x", "z", nil, "", nil)
+	sm.Notify("b", "update", []byte("payload1"))
+	sm.Notify("b", "update", []byte("payload2"))
+	sm.Notify("y", "update", []byte("payload3"))
+
+	if event := <-*slow; !strings.HasPrefix(string(event), "event: disconnect\n") {
+		t.Errorf("Notify failed, expected a terminal event, got %q", event)
+	}
+	if _, ok := <-*slow; ok {
+		t.Errorf("Notify failed, expected the channel to be closed")
+	}
+	if event := <-*other; !strings.Contains(string(event), "payload3") {
+		t.Errorf("Notify failed, expected the other subscriber to be notified, got %q", event)
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	if policy, err := ParsePolicy("disconnect"); err != nil || policy != Disconnect {
+		t.Errorf("ParsePolicy failed for disconnect")
+	}
+	if policy, err := ParsePolicy("drop-oldest"); err != nil || policy != DropOldest {
+		t.Errorf("ParsePolicy failed for drop-oldest")
+	}
+	if _, err := ParsePolicy("block"); err == nil {
+		t.Errorf("ParsePolicy failed, expected an error for an unknown policy")
+	}
+}
+
+func TestColSubscriptionManager_NotifyAll(t *testing.T) {
+	sm := NewColSubManager(mocks.NewMockSL[string, Colsubscriber](), Delivery{Depth: DefaultDepth})
+	subChan, id, _ := sm.AddSubscriber("a", "z", nil, "", nil)
+	sm.NotifyAll(`"/col/"`)
+
+	if event := <-*subChan; !strings.HasPrefix(string(event), "event: delete\n") {
+		t.Errorf("NotifyAll failed, expected a delete event, got %q", event)
+	}
+	if _, ok := <-*subChan; ok {
+		t.Errorf("NotifyAll failed, expected the channel to be closed")
+	}
+	sm.Remove(id) //already removed
+}
+
+func TestMessager_NotifyDocsDelete(t *testing.T) {
+	sl := mocks.NewMockSL[string, *SubscriptionManager]()
+	var idtosubfactory IdToSubFactory = func() IdToSub[string, *chan []byte] {
+		return mocks.NewMockSL[string, *chan []byte]()
+	}
+	messager := NewMessager(idtosubfactory, sl, mocks.NewMockSL[string, *SubscriptionManager](), Delivery{Depth: DefaultDepth}, nil)
+	doc, docId, _ := messager.AddDocSubscriber("db/doc1", "", nil)
+	child, _, _ := messager.AddDocSubscriber("db/doc1/col/doc2", "", nil)
+	sibling, _, _ := messager.AddDocSubscriber("db/doc10", "", nil)
+	messager.NotifyDocs("db/doc1", "delete", []byte(`"/doc1"`))
+
+	for _, ch := range []*chan []byte{doc, child} {
+		if event := <-*ch; !strings.HasPrefix(string(event), "event: delete\n") {
+			t.Errorf("NotifyDocs failed, expected a delete event, got %q", event)
+		}
+		if _, ok := <-*ch; ok {
+			t.Errorf("NotifyDocs failed, expected the channel to be closed")
+		}
+	}
+	select {
+	case event := <-*sibling:
+		t.Errorf("NotifyDocs failed, the subscriber to another document got %q", event)
+	default:
+	}
+	messager.RemoveDocSubscriber("db/doc1", docId) //already removed
+
+	again, _, _ := messager.AddDocSubscriber("db/doc1", "", nil)
+	messager.NotifyDocs("db/doc1", "update", []byte("payload"))
+	if event := <-*again; !strings.Contains(string(event), "payload") {
+		t.Errorf("NotifyDocs failed, expected a new subscriber to be notified, got %q", event)
+	}
+}
+
+func TestColSubscriptionManager_Filter(t *testing.T) {
+	sm := NewColSubManager(mocks.NewMockSL[string, Colsubscriber](), Delivery{Depth: DefaultDepth})
+	serial := func(name string, status string) []byte {
+		return []byte(`{"path":"/col/` + name + `","doc":{"status":"` + status + `"}}`)
+	}
+	open := func(serial []byte) bool {
+		return strings.Contains(string(serial), `"status":"open"`)
+	}
+	subChan, id, events := sm.AddSubscriber("a", "z", open, "", [][]byte{serial("a", "open"), serial("b", "closed")})
+	defer sm.Remove(id)
+	if len(events) != 1 || !strings.Contains(string(events[0]), `"/col/a"`) {
+		t.Fatalf("AddSubscriber failed, expected the matching documents only, got %q", events)
+	}
+
+	sm.Notify("b", "update", serial("b", "closed")) //still not matching
+	sm.Notify("b", "update", serial("b", "open"))
+	sm.Notify("a", "update", serial("a", "open"))
+	sm.Notify("a", "update", serial("a", "closed"))
+	sm.Notify("a", "delete", []byte(`"/col/a"`)) //no longer matching
+	sm.Notify("b", "delete", []byte(`"/col/b"`))
+	want := []string{
+		"event: enter\ndata: " + string(serial("b", "open")) + "\n",
+		"event: update\ndata: " + string(serial("a", "open")) + "\n",
+		"event: leave\ndata: \"/col/a\"\n",
+		"event: delete\ndata: \"/col/b\"\n",
+	}
+	var received [][]byte
+	for _, w := range want {
+		event := <-*subChan
+		received = append(received, event)
+		if !strings.HasPrefix(string(event), w) {
+			t.Errorf("Notify failed, expected %q, got %q", w, event)
+		}
+	}
+	select {
+	case event := <-*subChan:
+		t.Errorf("Notify failed, expected no more events, got %q", event)
+	default:
+	}
+
+	_, resumed, events := sm.AddSubscriber("a", "z", open, eventID(received[0]), nil)
+	sm.Remove(resumed)
+	if len(events) != 1 || !strings.HasPrefix(string(events[0]), "event: resync\n") {
+		t.Errorf("AddSubscriber failed, expected a filtered subscriber to resync, got %q", events)
+	}
+}
+
+// recordingPublisher records the events published to it
+type recordingPublisher struct {
+	published []string
+}
+
+func (p *recordingPublisher) Publish(uri string, evType string, payload []byte) {
+	p.published = append(p.published, evType+" "+uri+" "+string(payload))
+}
+
+func TestMessager_Publish(t *testing.T) {
+	var idtosubfactory IdToSubFactory = func() IdToSub[string, *chan []byte] {
+		return mocks.NewMockSL[string, *chan []byte]()
+	}
+	publisher := &recordingPublisher{}
+	messager := NewMessager(idtosubfactory, mocks.NewMockSL[string, *SubscriptionManager](), mocks.NewMockSL[string, *SubscriptionManager](), Delivery{Depth: DefaultDepth}, publisher)
+	messager.AddDocSubscriber("db/doc1/col/doc2", "", nil)
+	messager.NotifyDocs("db/doc1", "update", []byte("payload"))
+	messager.NotifyDocs("db/doc1", "delete", []byte(`"/doc1"`)) //ends the subscription beneath it without publishing again
+
+	want := []string{"update db/doc1 payload", `delete db/doc1 "/doc1"`}
+	if fmt.Sprint(publisher.published) != fmt.Sprint(want) {
+		t.Errorf("NotifyDocs failed, expected %v to be published, got %v", want, publisher.published)
+	}
+}
@@ -0,0 +1,111 @@
+// Package transaction describes the multi-document writes of OwlDB: the operations a transaction or a batch is made
+// of, and the writes a transaction leaves behind until it commits or rolls back.
+package transaction
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/patcher"
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/precondition"
+)
+
+// The kinds of operations a transaction can hold
+const (
+	OpPut    = "put"    // creates or replaces a document
+	OpPatch  = "patch"  // patches a document
+	OpDelete = "delete" // deletes a document
+)
+
+// Operation is a single write of a transaction or a batch
+type Operation struct {
+	Op          string          `json:"op"`                    // one of put, patch or delete
+	Path        string          `json:"path"`                  // the path of the document, relative to the database, e.g. /doc/col/doc
+	Doc         json.RawMessage `json:"doc,omitempty"`         // the new contents of the document, for put
+	Patch       json.RawMessage `json:"patch,omitempty"`       // the patch applied to the document, for patch
+	PatchType   string          `json:"patchType,omitempty"`   // the media type naming the dialect of the patch; OwlDB's own if empty
+	IfMatch     string          `json:"ifMatch,omitempty"`     // the operation applies only if the document's ETag is listed
+	IfNoneMatch string          `json:"ifNoneMatch,omitempty"` // the operation applies only if the document's ETag is not listed
+}
+
+// DocPath returns the path of the document written by the operation, without its leading slash
+func (op Operation) DocPath() string {
+	return strings.TrimPrefix(op.Path, "/")
+}
+
+// Conditions returns the conditions the document must satisfy for the operation to apply
+func (op Operation) Conditions() precondition.Conditions {
+	return precondition.Parse(op.IfMatch, op.IfNoneMatch)
+}
+
+// MediaType returns the media type naming the dialect of the operation's patch
+func (op Operation) MediaType() string {
+	if op.PatchType == "" {
+		return patcher.MediaTypeOwlDB
+	}
+	return op.PatchType
+}
+
+// Parse decodes and validates the operations of a transaction, a JSON array of operations applied in order.
+// Returns the operations, or an error naming the first malformed one
+func Parse(body []byte) ([]Operation, error) {
+	var ops []Operation
+	if err := json.Unmarshal(body, &ops); err != nil {
+		return nil, fmt.Errorf("a transaction must be a JSON array of operations")
+	}
+	if len(ops) == 0 {
+		return nil, fmt.Errorf("a transaction must hold at least one operation")
+	}
+	for i, op := range ops {
+		if err := op.Validate(); err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+	}
+	return ops, nil
+}
+
+// Validate checks that the operation is well-formed.
+// Returns an error describing the first problem found
+func (op Operation) Validate() error {
+	if !strings.HasPrefix(op.Path, "/") {
+		return fmt.Errorf("path '%s' must start with a slash", op.Path)
+	}
+	segments := strings.Split(op.DocPath(), "/")
+	if len(segments)%2 == 0 {
+		return fmt.Errorf("path '%s' does not name a document", op.Path)
+	}
+	for _, seg := range segments {
+		if seg == "" {
+			return fmt.Errorf("path '%s' has an empty segment", op.Path)
+		}
+	}
+	switch op.Op {
+	case OpPut:
+		if len(op.Doc) == 0 {
+			return fmt.Errorf("put has no doc")
+		}
+	case OpPatch:
+		if len(op.Patch) == 0 {
+			return fmt.Errorf("patch has no patch")
+		}
+		if !patcher.SupportsMediaType(op.MediaType()) {
+			return fmt.Errorf("unsupported patch media type '%s'", op.PatchType)
+		}
+	case OpDelete:
+	default:
+		return fmt.Errorf("unknown op '%s'", op.Op)
+	}
+	return nil
+}
+
+// Write is the effect of an operation on a single document, kept until the transaction it belongs to ends. Puts and
+// patches are applied at once, while a deleted document is only removed when the transaction commits, so that a
+// rollback never has to rebuild the collections beneath it.
+type Write struct {
+	Status int    // 201 if the document was created, 200 if it was replaced or patched, 204 if it was deleted
+	Serial []byte // the new state of the document, as returned by GetSerial, or nil if it was deleted
+	Undo   func() // reverts the write; the writes of a transaction are undone in the reverse order they were made
+	Commit func() // completes the write once every operation of the transaction has applied; may be nil
+	Notify func() // tells subscribers about the write, once the transaction has committed
+}
@@ -0,0 +1,44 @@
+package transaction
+
+import (
+	"testing"
+
+	"github.com/RICE-COMP318-FALL24/owldb-p1group24/patcher"
+)
+
+func TestParse(t *testing.T) {
+	ops, err := Parse([]byte(`[{"op":"put","path":"/a","doc":{"x":1}},{"op":"patch","path":"/a/c/d","patch":[],"ifMatch":"\"2\""},{"op":"delete","path":"/b"}]`))
+	if err != nil {
+		t.Fatalf("TestParse failed: %s", err.Error())
+	}
+	if len(ops) != 3 || ops[1].DocPath() != "a/c/d" || ops[1].MediaType() != patcher.MediaTypeOwlDB {
+		t.Errorf("TestParse failed, got %+v", ops)
+	}
+	if cond := ops[1].Conditions(); len(cond.IfMatch) != 1 || cond.IfMatch[0] != `"2"` || cond.IfNoneMatch != nil {
+		t.Errorf("TestParse failed, expected the conditions of the patch, got %+v", cond)
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"not an array", `{"op":"delete","path":"/a"}`},
+		{"empty", `[]`},
+		{"relative path", `[{"op":"delete","path":"a"}]`},
+		{"collection path", `[{"op":"delete","path":"/a/c"}]`},
+		{"empty segment", `[{"op":"delete","path":"/a//d"}]`},
+		{"put without doc", `[{"op":"put","path":"/a"}]`},
+		{"patch without patch", `[{"op":"patch","path":"/a"}]`},
+		{"unsupported patch type", `[{"op":"patch","path":"/a","patch":[],"patchType":"text/plain"}]`},
+		{"unknown op", `[{"op":"move","path":"/a"}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse([]byte(tt.body)); err == nil {
+				t.Errorf("expected %s to be rejected", tt.body)
+			}
+		})
+	}
+}
@@ -0,0 +1,593 @@
+// Package webhook delivers the changes made to databases to the HTTP endpoints registered for them, for consumers
+// that cannot hold a subscription open. Each event is POSTed as JSON, signed with the secret of its hook, and retried
+// with exponential backoff; the events that could not be delivered are kept for inspection. Given a data directory,
+// the hooks and the undelivered events are kept across restarts, and the events still waiting to be delivered when
+// the server stops are kept as undelivered.
+package webhook
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// QueueDepth is the number of events queued for each hook; an event published while its queue is full is
+// dead-lettered
+const QueueDepth = 256
+
+// DeadLetterLimit is the number of undelivered events kept; the oldest are dropped to make room
+const DeadLetterLimit = 1000
+
+// HookFile is the name of the file holding the hooks and the undelivered events in the data directory
+const HookFile = "webhooks.json"
+
+// PendingFile is the name of the file, in the data directory, tracking the events waiting to be delivered, so that
+// those a crash left waiting are dead-lettered once the server restarts
+const PendingFile = "webhooks-pending.jsonl"
+
+// errStopped is why an event still waiting to be delivered when the server stopped is dead-lettered
+var errStopped = errors.New("the server stopped before the event was delivered")
+
+// SignatureHeader carries the HMAC-SHA256 of the body of an event, keyed by the secret of its hook, as
+// "sha256=<hex>"
+const SignatureHeader = "X-OwlDB-Signature"
+
+// Hook is a webhook registered with a database
+type Hook struct {
+	Id       string   `json:"id"`               // identifies the hook within the registry
+	Database string   `json:"database"`         // the database whose changes are sent
+	URL      string   `json:"url"`              // the http or https URL events are POSTed to
+	Events   []string `json:"events"`           // the types of events sent, "update" and "delete"; every type if empty
+	Prefix   string   `json:"prefix"`           // only changes to resources whose path starts with it are sent
+	Secret   string   `json:"secret,omitempty"` // the key events are signed with, never listed
+}
+
+// Event is the body POSTed to a hook
+type Event struct {
+	Id        string          `json:"id"`        // identifies the event, and stays the same across retries
+	Hook      string          `json:"hook"`      // the hook the event is sent to
+	Type      string          `json:"type"`      // "update" or "delete"
+	Database  string          `json:"database"`  // the database changed
+	Path      string          `json:"path"`      // the document or collection changed, such as "/doc" or "/doc/col/"
+	Data      json.RawMessage `json:"data"`      // the document updated, or the path of the resource deleted
+	Timestamp int64           `json:"timestamp"` // the time of the change, in Unix milliseconds
+}
+
+// DeadLetter is an event that could not be delivered
+type DeadLetter struct {
+	URL      string `json:"url"`      // the URL the event was sent to
+	Event    Event  `json:"event"`    // the event
+	Attempts int    `json:"attempts"` // the number of attempts made to deliver it
+	Error    string `json:"error"`    // why the last attempt failed
+	FailedAt int64  `json:"failedAt"` // the time of the last attempt, in Unix milliseconds
+}
+
+// Retry says how the delivery of an event is retried
+type Retry struct {
+	Attempts   int           // the number of attempts made before an event is dead-lettered
+	Backoff    time.Duration // the wait before the first retry, doubled before each of the next ones
+	MaxBackoff time.Duration // the longest wait between two attempts
+	Timeout    time.Duration // how long each attempt waits for a response
+}
+
+// DefaultRetry makes 5 attempts, waiting 1s, 2s, 4s then 8s between them
+var DefaultRetry = Retry{Attempts: 5, Backoff: time.Second, MaxBackoff: time.Minute, Timeout: 10 * time.Second}
+
+// state is the contents of HookFile
+type state struct {
+	Next  int64        `json:"next"`  // the number of hooks registered so far
+	Hooks []Hook       `json:"hooks"` // the hooks, with their secrets
+	Dead  []DeadLetter `json:"dead"`  // the most recent undelivered events, oldest first
+}
+
+// pendingEntry is a line of PendingFile: either an event queued for delivery to URL, or the end of the delivery of the
+// event Settled, be it delivered, dead-lettered or dropped
+type pendingEntry struct {
+	URL     string `json:"url,omitempty"`
+	Event   *Event `json:"event,omitempty"`
+	Settled string `json:"settled,omitempty"`
+}
+
+// inFlight is an event waiting to be delivered, queued or being retried
+type inFlight struct {
+	url      string
+	event    Event
+	attempts int // the number of attempts made to deliver it so far
+}
+
+// worker delivers the events of a hook, one at a time and in order
+type worker struct {
+	hook  Hook
+	queue chan Event    // the events waiting to be delivered
+	done  chan struct{} // closed once the hook is removed
+}
+
+// Registry keeps the hooks of every database and delivers the events published to them. It is safe for concurrent
+// use.
+type Registry struct {
+	retry  Retry
+	client *http.Client
+
+	file    string   // the file the hooks and undelivered events are persisted to, or "" to keep them in memory
+	pending *os.File // the open PendingFile, or nil if nothing is persisted
+
+	mu       sync.Mutex
+	next     int64                // the number of hooks registered so far
+	hooks    map[string]*worker   // the hooks, by ID
+	dead     []DeadLetter         // the most recent undelivered events, oldest first
+	inFlight map[string]*inFlight // the events waiting to be delivered, by ID
+	closed   bool                 // set once the registry is closed, after which nothing is delivered
+}
+
+// NewRegistry creates a registry without hooks, kept in memory only, retrying deliveries as retry says
+func NewRegistry(retry Retry) *Registry {
+	return &Registry{retry: retry, client: &http.Client{}, hooks: make(map[string]*worker), inFlight: make(map[string]*inFlight)}
+}
+
+// OpenRegistry loads the hooks and undelivered events persisted to the data directory dir, starting the delivery of
+// the events of each hook, or starts with none if dir is "" or holds none yet. The events a crash left waiting to be
+// delivered are dead-lettered. Deliveries are retried as retry says.
+// Returns an error if the hooks or the events could not be read
+func OpenRegistry(dir string, retry Retry) (*Registry, error) {
+	r := NewRegistry(retry)
+	if dir == "" {
+		return r, nil
+	}
+	r.file = filepath.Join(dir, HookFile)
+	data, err := os.ReadFile(r.file)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("unable to read the webhooks: %w", err)
+	}
+	if err == nil {
+		var saved state
+		if err = json.Unmarshal(data, &saved); err != nil {
+			return nil, fmt.Errorf("malformed webhooks in %s: %w", r.file, err)
+		}
+		r.next = saved.Next
+		r.dead = saved.Dead
+		for _, hook := range saved.Hooks {
+			w := &worker{hook: hook, queue: make(chan Event, QueueDepth), done: make(chan struct{})}
+			r.hooks[hook.Id] = w
+			go r.run(w)
+		}
+	}
+
+	pendingName := filepath.Join(dir, PendingFile)
+	left, err := readPending(pendingName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the webhook events waiting to be delivered: %w", err)
+	}
+	if len(left) > 0 {
+		slog.Warn("Dead-lettering the webhook events left waiting to be delivered", "events", len(left))
+		for _, p := range left {
+			r.deadLetter(p.url, p.event, p.attempts, errStopped)
+		}
+		//the events are only forgotten once dead-lettered, so that a crash in between leaves them waiting
+		if err = r.save(); err != nil {
+			return nil, err
+		}
+	}
+	r.pending, err = os.OpenFile(pendingName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open %s: %w", pendingName, err)
+	}
+	slog.Info("Loaded webhooks", "hooks", len(r.hooks), "deadLetters", len(r.dead))
+	return r, nil
+}
+
+// readPending reads the PendingFile name, ignoring a torn last line.
+// Returns the events it holds that were never settled, oldest first
+func readPending(name string) ([]inFlight, error) {
+	data, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var order []string
+	waiting := make(map[string]inFlight)
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		var entry pendingEntry
+		if json.Unmarshal(line, &entry) != nil {
+			continue
+		}
+		if entry.Event != nil {
+			order = append(order, entry.Event.Id)
+			waiting[entry.Event.Id] = inFlight{url: entry.URL, event: *entry.Event}
+		} else {
+			delete(waiting, entry.Settled)
+		}
+	}
+	var left []inFlight
+	for _, id := range order {
+		if p, found := waiting[id]; found {
+			left = append(left, p)
+			delete(waiting, id)
+		}
+	}
+	return left, nil
+}
+
+// Close stops delivering events, dead-lettering those still waiting to be delivered, and persists what it holds.
+// Returns an error if the undelivered events could not be persisted
+func (r *Registry) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	for _, w := range r.hooks {
+		close(w.done)
+	}
+	ids := make([]string, 0, len(r.inFlight))
+	for id := range r.inFlight {
+		ids = append(ids, id)
+	}
+	slices.SortFunc(ids, func(a, b string) int {
+		return cmp.Compare(r.inFlight[a].event.Timestamp, r.inFlight[b].event.Timestamp)
+	})
+	for _, id := range ids {
+		p := r.inFlight[id]
+		r.deadLetter(p.url, p.event, p.attempts, errStopped)
+		delete(r.inFlight, id)
+	}
+	if err := r.save(); err != nil {
+		return err
+	}
+	if r.pending != nil {
+		r.pending.Truncate(0)
+		return r.pending.Close()
+	}
+	return nil
+}
+
+// Register validates hook and registers it with the database dtb, starting the delivery of its events.
+// Returns the hook registered, without its secret, or an error if hook is malformed
+func (r *Registry) Register(dtb string, hook Hook) (Hook, error) {
+	target, err := url.Parse(hook.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return Hook{}, errors.New("url must be an absolute http or https URL")
+	}
+	for _, evType := range hook.Events {
+		if evType != "update" && evType != "delete" {
+			return Hook{}, fmt.Errorf("unknown event type %q", evType)
+		}
+	}
+	if hook.Prefix == "" {
+		hook.Prefix = "/"
+	}
+	if !strings.HasPrefix(hook.Prefix, "/") {
+		return Hook{}, errors.New("prefix must start with /")
+	}
+	if hook.Secret == "" {
+		return Hook{}, errors.New("missing secret")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return Hook{}, errors.New("the server is stopping")
+	}
+	r.next++
+	hook.Id = strconv.FormatInt(r.next, 10)
+	hook.Database = dtb
+	w := &worker{hook: hook, queue: make(chan Event, QueueDepth), done: make(chan struct{})}
+	r.hooks[hook.Id] = w
+	if err := r.save(); err != nil {
+		delete(r.hooks, hook.Id)
+		r.next--
+		return Hook{}, err
+	}
+	go r.run(w)
+	return w.listed(), nil
+}
+
+// List returns the hooks of the database dtb, oldest first, without their secrets
+func (r *Registry) List(dtb string) []Hook {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	hooks := make([]Hook, 0)
+	for _, w := range r.hooks {
+		if w.hook.Database == dtb {
+			hooks = append(hooks, w.listed())
+		}
+	}
+	slices.SortFunc(hooks, func(a, b Hook) int {
+		ai, _ := strconv.ParseInt(a.Id, 10, 64)
+		bi, _ := strconv.ParseInt(b.Id, 10, 64)
+		return cmp.Compare(ai, bi)
+	})
+	return hooks
+}
+
+// Remove unregisters the hook id of the database dtb, dropping the events still waiting to be delivered.
+// Returns false if the database has no such hook
+func (r *Registry) Remove(dtb string, id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	w, found := r.hooks[id]
+	if !found || w.hook.Database != dtb || r.closed {
+		return false
+	}
+	delete(r.hooks, id)
+	close(w.done)
+	for evId, p := range r.inFlight {
+		if p.event.Hook == id {
+			r.settle(evId)
+		}
+	}
+	if err := r.save(); err != nil {
+		slog.Error("Unable to persist the removal of a webhook", "hook", id, "error", err)
+	}
+	return true
+}
+
+// DeadLetters returns the events of the database dtb that could not be delivered, oldest first
+func (r *Registry) DeadLetters(dtb string) []DeadLetter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	dead := make([]DeadLetter, 0)
+	for _, letter := range r.dead {
+		if letter.Event.Database == dtb {
+			dead = append(dead, letter)
+		}
+	}
+	return dead
+}
+
+// Publish queues an event of type evType about the resource at uri, the name of its database followed by its path,
+// for every hook it concerns, without waiting for any of them. A hook is sent the changes beneath its prefix, along
+// with the deletion of the resources holding it.
+func (r *Registry) Publish(uri string, evType string, payload []byte) {
+	dtb, rest, _ := strings.Cut(uri, "/")
+	path := "/" + rest
+	data := json.RawMessage(payload)
+	if !json.Valid(payload) {
+		data, _ = json.Marshal(string(payload))
+	}
+	now := time.Now().UnixMilli()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	full := false
+	for _, w := range r.hooks {
+		if !w.concerns(dtb, evType, path) {
+			continue
+		}
+		ev := Event{Id: newEventId(), Hook: w.hook.Id, Type: evType, Database: dtb, Path: path, Data: data, Timestamp: now}
+		select {
+		case w.queue <- ev:
+			r.inFlight[ev.Id] = &inFlight{url: w.hook.URL, event: ev}
+			r.track(pendingEntry{URL: w.hook.URL, Event: &ev})
+		default:
+			slog.Warn("Dropping a webhook event, the queue of its hook is full", "hook", w.hook.Id)
+			r.deadLetter(w.hook.URL, ev, 0, errors.New("queue full"))
+			full = true
+		}
+	}
+	if full {
+		if err := r.save(); err != nil {
+			slog.Error("Unable to persist the undelivered webhook events", "error", err)
+		}
+	}
+}
+
+// concerns reports whether the hook of w is sent events of type evType about the resource at path in dtb
+func (w *worker) concerns(dtb string, evType string, path string) bool {
+	if w.hook.Database != dtb || (len(w.hook.Events) > 0 && !slices.Contains(w.hook.Events, evType)) {
+		return false
+	}
+	if strings.HasPrefix(path, w.hook.Prefix) {
+		return true
+	}
+	//the deletion of a resource holding the prefix deletes everything beneath it
+	return evType == "delete" && (path == "/" || strings.HasPrefix(w.hook.Prefix, strings.TrimSuffix(path, "/")+"/"))
+}
+
+// listed returns the hook of w as it is listed, without its secret
+func (w *worker) listed() Hook {
+	hook := w.hook
+	hook.Secret = ""
+	hook.Events = slices.Clone(hook.Events)
+	return hook
+}
+
+// run delivers the events queued for w until its hook is removed
+func (r *Registry) run(w *worker) {
+	for {
+		select {
+		case <-w.done:
+			return
+		case ev := <-w.queue:
+			r.deliver(w, ev)
+		}
+	}
+}
+
+// deliver POSTs ev to the hook of w, retrying with exponential backoff, and dead-letters it once every attempt
+// failed. It gives up if the hook is removed meanwhile
+func (r *Registry) deliver(w *worker, ev Event) {
+	body, _ := json.Marshal(ev)
+	backoff := r.retry.Backoff
+	for attempt := 1; ; attempt++ {
+		err := r.post(w.hook, ev, body)
+		if err == nil {
+			r.finish(ev, attempt, nil)
+			return
+		}
+		slog.Debug("Unable to deliver a webhook event", "hook", w.hook.Id, "attempt", attempt, "error", err)
+		if attempt >= r.retry.Attempts {
+			r.finish(ev, attempt, err)
+			return
+		}
+		r.attempted(ev.Id, attempt)
+		select {
+		case <-w.done:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, r.retry.MaxBackoff)
+	}
+}
+
+// post makes a single attempt to deliver ev, whose body is body, to hook.
+// Returns an error unless the hook answered with a 2xx status
+func (r *Registry) post(hook Hook, ev Event, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), r.retry.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-OwlDB-Event", ev.Type)
+	req.Header.Set("X-OwlDB-Delivery", ev.Id)
+	req.Header.Set(SignatureHeader, Sign(hook.Secret, body))
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// attempted records that attempts attempts were made to deliver the event id so far
+func (r *Registry) attempted(id string, attempts int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if p, found := r.inFlight[id]; found {
+		p.attempts = attempts
+	}
+}
+
+// finish ends the delivery of ev after attempts attempts, dead-lettering it if err says why the last one failed.
+// Nothing happens if the event is no longer waiting, its hook removed or the registry closed meanwhile
+func (r *Registry) finish(ev Event, attempts int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, found := r.inFlight[ev.Id]
+	if !found {
+		return
+	}
+	r.settle(ev.Id)
+	if err == nil {
+		return
+	}
+	r.deadLetter(p.url, ev, attempts, err)
+	if err = r.save(); err != nil {
+		slog.Error("Unable to persist the undelivered webhook events", "error", err)
+	}
+}
+
+// settle forgets the event id, which no longer waits to be delivered.
+// The caller must hold r.mu.
+func (r *Registry) settle(id string) {
+	delete(r.inFlight, id)
+	r.track(pendingEntry{Settled: id})
+	if len(r.inFlight) == 0 && r.pending != nil {
+		//nothing left waiting, so the file starts over rather than growing forever
+		r.pending.Truncate(0)
+	}
+}
+
+// track appends entry to PendingFile, if there is one. It is not flushed to stable storage, which would hold up every
+// write to the databases, so only the events waiting when the process itself crashed are dead-lettered for sure.
+// The caller must hold r.mu.
+func (r *Registry) track(entry pendingEntry) {
+	if r.pending == nil {
+		return
+	}
+	line, _ := json.Marshal(entry)
+	if _, err := r.pending.Write(append(line, '\n')); err != nil {
+		slog.Error("Unable to track a webhook event waiting to be delivered", "error", err)
+	}
+}
+
+// deadLetter keeps ev, which could not be delivered to url after attempts attempts because of err.
+// The caller must hold r.mu.
+func (r *Registry) deadLetter(url string, ev Event, attempts int, err error) {
+	r.dead = append(r.dead, DeadLetter{URL: url, Event: ev, Attempts: attempts, Error: err.Error(), FailedAt: time.Now().UnixMilli()})
+	if len(r.dead) > DeadLetterLimit {
+		r.dead = r.dead[len(r.dead)-DeadLetterLimit:]
+	}
+}
+
+// save persists the hooks and the undelivered events, replacing the file atomically so that a crash leaves either
+// the old or the new ones behind. The caller must hold r.mu.
+func (r *Registry) save() error {
+	if r.file == "" {
+		return nil
+	}
+	saved := state{Next: r.next, Hooks: make([]Hook, 0, len(r.hooks)), Dead: r.dead}
+	for _, w := range r.hooks {
+		saved.Hooks = append(saved.Hooks, w.hook)
+	}
+	slices.SortFunc(saved.Hooks, func(a, b Hook) int {
+		return cmp.Or(cmp.Compare(len(a.Id), len(b.Id)), cmp.Compare(a.Id, b.Id)) //numeric order
+	})
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := r.file + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to save the webhooks: %w", err)
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, r.file)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("unable to save the webhooks: %w", err)
+	}
+	return nil
+}
+
+// Sign computes the value of SignatureHeader for body, keyed by secret
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// newEventId generates a random event ID
+func newEventId() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
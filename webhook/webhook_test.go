@@ -0,0 +1,256 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fastRetry retries deliveries quickly, so that tests do not wait long
+var fastRetry = Retry{Attempts: 3, Backoff: 5 * time.Millisecond, MaxBackoff: 20 * time.Millisecond, Timeout: time.Second}
+
+// receiver is an httptest endpoint that records the events it is sent, failing the first failures attempts
+type receiver struct {
+	srv      *httptest.Server
+	failures int32
+	attempts atomic.Int32
+
+	mu     sync.Mutex
+	events []Event
+	valid  []bool // whether the signature of each event checked out
+}
+
+func newReceiver(secret string, failures int32) *receiver {
+	rc := &receiver{failures: failures}
+	rc.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rc.attempts.Add(1) <= rc.failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		var ev Event
+		json.Unmarshal(body, &ev)
+		rc.mu.Lock()
+		rc.events = append(rc.events, ev)
+		rc.valid = append(rc.valid, r.Header.Get(SignatureHeader) == Sign(secret, body) && r.Header.Get("X-OwlDB-Delivery") == ev.Id)
+		rc.mu.Unlock()
+	}))
+	return rc
+}
+
+// received returns the events received so far, and whether each was correctly signed
+func (rc *receiver) received() ([]Event, []bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return append([]Event(nil), rc.events...), append([]bool(nil), rc.valid...)
+}
+
+// eventually reports whether cond holds within a second
+func eventually(cond func() bool) bool {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if cond() {
+			return true
+		}
+	}
+	return cond()
+}
+
+func TestRegisterValidation(t *testing.T) {
+	r := NewRegistry(fastRetry)
+	for _, hook := range []Hook{
+		{URL: "ftp://example.com", Secret: "s"},
+		{URL: "/relative", Secret: "s"},
+		{URL: "http://example.com", Secret: "s", Events: []string{"create"}},
+		{URL: "http://example.com", Secret: "s", Prefix: "doc/"},
+		{URL: "http://example.com"},
+	} {
+		if _, err := r.Register("db", hook); err == nil {
+			t.Errorf("Register failed, expected an error for %+v", hook)
+		}
+	}
+	hook, err := r.Register("db", Hook{URL: "http://example.com", Secret: "s"})
+	if err != nil || hook.Id == "" || hook.Prefix != "/" || hook.Secret != "" || hook.Database != "db" {
+		t.Fatalf("Register failed, got %+v %v", hook, err)
+	}
+	if hooks := r.List("db"); len(hooks) != 1 || hooks[0].Id != hook.Id || hooks[0].Secret != "" {
+		t.Errorf("List failed, got %+v", hooks)
+	}
+	if hooks := r.List("other"); len(hooks) != 0 {
+		t.Errorf("List failed, expected no hooks for another database, got %+v", hooks)
+	}
+	if r.Remove("other", hook.Id) || !r.Remove("db", hook.Id) || r.Remove("db", hook.Id) {
+		t.Errorf("Remove failed")
+	}
+}
+
+func TestPublish(t *testing.T) {
+	rc := newReceiver("secret", 0)
+	defer rc.srv.Close()
+	r := NewRegistry(fastRetry)
+	hook, _ := r.Register("db", Hook{URL: rc.srv.URL, Secret: "secret", Prefix: "/doc1/col/"})
+	defer r.Remove("db", hook.Id)
+	deletes, _ := r.Register("db", Hook{URL: rc.srv.URL, Secret: "secret", Events: []string{"delete"}, Prefix: "/doc2"})
+	defer r.Remove("db", deletes.Id)
+
+	r.Publish("db/doc1/col/", "update", []byte(`"/doc1/col/"`))
+	r.Publish("db/doc1/col/a", "update", []byte(`{"path":"/doc1/col/a"}`))
+	r.Publish("db/doc1", "update", []byte(`{"path":"/doc1"}`))          //outside the prefix
+	r.Publish("other/doc1/col/a", "update", []byte(`{"path":"/doc1"}`)) //another database
+	r.Publish("db/doc2", "update", []byte(`{"path":"/doc2"}`))          //not an event type of the hook
+	r.Publish("db/doc1/col/a", "delete", []byte(`/doc1/col/a`))
+	r.Publish("db/doc1", "delete", []byte(`"/doc1"`)) //holds the prefix
+
+	want := []struct{ hook, typ, path, data string }{
+		{hook.Id, "update", "/doc1/col/", `"/doc1/col/"`},
+		{hook.Id, "update", "/doc1/col/a", `{"path":"/doc1/col/a"}`},
+		{hook.Id, "delete", "/doc1/col/a", `"/doc1/col/a"`},
+		{hook.Id, "delete", "/doc1", `"/doc1"`},
+	}
+	if !eventually(func() bool { events, _ := rc.received(); return len(events) >= len(want) }) {
+		t.Fatalf("Publish failed, expected %d events", len(want))
+	}
+	time.Sleep(20 * time.Millisecond) //no more events follow
+	events, valid := rc.received()
+	if len(events) != len(want) {
+		t.Fatalf("Publish failed, expected %d events, got %+v", len(want), events)
+	}
+	for i, w := range want {
+		ev := events[i]
+		if ev.Hook != w.hook || ev.Type != w.typ || ev.Database != "db" || ev.Path != w.path || string(ev.Data) != w.data || !valid[i] {
+			t.Errorf("Publish failed, expected %+v, got %+v (signed: %v)", w, ev, valid[i])
+		}
+	}
+}
+
+func TestRetryAndDeadLetters(t *testing.T) {
+	flaky := newReceiver("secret", 2)
+	defer flaky.srv.Close()
+	down := newReceiver("secret", 1000)
+	defer down.srv.Close()
+	r := NewRegistry(fastRetry)
+	ok, _ := r.Register("db", Hook{URL: flaky.srv.URL, Secret: "secret"})
+	defer r.Remove("db", ok.Id)
+	failing, _ := r.Register("db", Hook{URL: down.srv.URL, Secret: "secret"})
+	defer r.Remove("db", failing.Id)
+
+	r.Publish("db/doc1", "update", []byte(`{"path":"/doc1"}`))
+	if !eventually(func() bool { events, _ := flaky.received(); return len(events) == 1 }) {
+		t.Errorf("Publish failed, expected the event to be delivered on the third attempt, got %d attempts", flaky.attempts.Load())
+	}
+	if !eventually(func() bool { return len(r.DeadLetters("db")) == 1 }) {
+		t.Fatalf("Publish failed, expected a dead letter, got %+v", r.DeadLetters("db"))
+	}
+	letter := r.DeadLetters("db")[0]
+	if letter.URL != down.srv.URL || letter.Attempts != fastRetry.Attempts || letter.Error == "" || letter.Event.Path != "/doc1" || down.attempts.Load() != int32(fastRetry.Attempts) {
+		t.Errorf("Publish failed, got dead letter %+v after %d attempts", letter, down.attempts.Load())
+	}
+	if dead := r.DeadLetters("other"); len(dead) != 0 {
+		t.Errorf("DeadLetters failed, expected none for another database, got %+v", dead)
+	}
+}
+
+// slowRetry keeps failed deliveries waiting to be retried for the length of a test
+var slowRetry = Retry{Attempts: 3, Backoff: time.Minute, MaxBackoff: time.Minute, Timeout: time.Second}
+
+func TestOpenRegistryRestores(t *testing.T) {
+	dir := t.TempDir()
+	rc := newReceiver("secret", 0)
+	defer rc.srv.Close()
+	down := newReceiver("secret", 1000)
+	defer down.srv.Close()
+	r, err := OpenRegistry(dir, fastRetry)
+	if err != nil {
+		t.Fatalf("OpenRegistry failed: %s", err)
+	}
+	first, _ := r.Register("db", Hook{URL: rc.srv.URL, Secret: "secret", Prefix: "/docs"})
+	second, _ := r.Register("db", Hook{URL: down.srv.URL, Secret: "secret"})
+	r.Remove("db", second.Id)
+	failing, _ := r.Register("db", Hook{URL: down.srv.URL, Secret: "secret"})
+	r.Publish("db/docs/doc1", "update", []byte(`{}`))
+	if !eventually(func() bool { return len(r.DeadLetters("db")) == 1 }) {
+		t.Fatalf("Publish failed, expected a dead letter, got %+v", r.DeadLetters("db"))
+	}
+	if err = r.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+
+	r, err = OpenRegistry(dir, fastRetry)
+	if err != nil {
+		t.Fatalf("OpenRegistry failed: %s", err)
+	}
+	defer r.Close()
+	if hooks := r.List("db"); len(hooks) != 2 || hooks[0].Id != first.Id || hooks[1].Id != failing.Id || hooks[0].Prefix != "/docs" {
+		t.Errorf("OpenRegistry failed, expected hooks %s and %s to be restored, got %+v", first.Id, failing.Id, hooks)
+	}
+	if dead := r.DeadLetters("db"); len(dead) != 1 || dead[0].Event.Path != "/docs/doc1" || dead[0].Attempts != fastRetry.Attempts {
+		t.Errorf("OpenRegistry failed, expected the dead letter to be restored, got %+v", dead)
+	}
+	if third, _ := r.Register("db", Hook{URL: rc.srv.URL, Secret: "secret"}); third.Id != "4" {
+		t.Errorf("Register failed, expected hook 4 after a restart, got %s", third.Id)
+	}
+	before, _ := rc.received()
+	r.Publish("db/docs/doc2", "update", []byte(`{}`))
+	if !eventually(func() bool { events, _ := rc.received(); return len(events) == len(before)+2 }) {
+		t.Fatalf("Publish failed, expected the restored hook to be sent the event")
+	}
+	if _, valid := rc.received(); !valid[len(valid)-1] || !valid[len(valid)-2] {
+		t.Errorf("Publish failed, expected the restored hook to sign events with its secret")
+	}
+}
+
+func TestCloseDeadLettersPending(t *testing.T) {
+	dir := t.TempDir()
+	down := newReceiver("secret", 1000)
+	defer down.srv.Close()
+	r, _ := OpenRegistry(dir, slowRetry)
+	r.Register("db", Hook{URL: down.srv.URL, Secret: "secret"})
+	r.Publish("db/doc1", "update", []byte(`{}`))
+	if !eventually(func() bool { return down.attempts.Load() == 1 }) {
+		t.Fatalf("Publish failed, expected a first attempt, got %d", down.attempts.Load())
+	}
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+	if dead := r.DeadLetters("db"); len(dead) != 1 || dead[0].Attempts != 1 || dead[0].Error != errStopped.Error() {
+		t.Errorf("Close failed, expected the event being retried to be dead-lettered, got %+v", dead)
+	}
+
+	r, _ = OpenRegistry(dir, slowRetry)
+	defer r.Close()
+	if dead := r.DeadLetters("db"); len(dead) != 1 || dead[0].Event.Path != "/doc1" {
+		t.Errorf("OpenRegistry failed, expected the dead letter written on close, got %+v", dead)
+	}
+}
+
+func TestOpenRegistryDeadLettersPending(t *testing.T) {
+	dir := t.TempDir()
+	down := newReceiver("secret", 1000)
+	defer down.srv.Close()
+	crashed, _ := OpenRegistry(dir, slowRetry)
+	crashed.Register("db", Hook{URL: down.srv.URL, Secret: "secret"})
+	crashed.Register("db", Hook{URL: down.srv.URL, Secret: "secret", Prefix: "/other"})
+	crashed.Publish("db/doc1", "update", []byte(`{}`))
+	crashed.Publish("db/doc2", "delete", []byte(`{}`))
+
+	//the first registry is never closed, as if the server had crashed
+	r, err := OpenRegistry(dir, slowRetry)
+	if err != nil {
+		t.Fatalf("OpenRegistry failed: %s", err)
+	}
+	dead := r.DeadLetters("db")
+	if len(dead) != 2 || dead[0].Event.Path != "/doc1" || dead[1].Event.Path != "/doc2" || dead[0].Error != errStopped.Error() {
+		t.Fatalf("OpenRegistry failed, expected both events waiting to be dead-lettered in order, got %+v", dead)
+	}
+	r.Close()
+
+	r, _ = OpenRegistry(dir, slowRetry)
+	defer r.Close()
+	if dead = r.DeadLetters("db"); len(dead) != 2 {
+		t.Errorf("OpenRegistry failed, expected the events to be dead-lettered only once, got %+v", dead)
+	}
+}
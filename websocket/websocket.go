@@ -0,0 +1,297 @@
+// Package websocket implements the WebSocket protocol (RFC 6455), as much of it as OwlDB needs to exchange text
+// messages with its clients: the opening handshake, fragmented and control frames, and the closing handshake.
+// Extensions and subprotocols are not supported.
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// MaxMessageSize is the size of the largest message read; a peer sending a larger one is disconnected
+const MaxMessageSize = 1 << 20
+
+// acceptGUID is appended to the key of a handshake to compute its accept value
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// The opcodes of the frames
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// The status codes sent in close frames
+const (
+	CloseNormal        = 1000 // the connection fulfilled its purpose
+	CloseProtocolError = 1002 // the peer broke the protocol
+	CloseTooBig        = 1009 // the peer sent a message larger than MaxMessageSize
+)
+
+// ErrClosed is returned when reading from a connection the peer closed
+var ErrClosed = errors.New("websocket: connection closed")
+
+// Conn is a WebSocket connection. One goroutine may read from it while others write to it.
+type Conn struct {
+	conn      net.Conn      // the underlying connection
+	br        *bufio.Reader // buffers the frames read
+	client    bool          // whether this is the client end, which masks the frames it sends
+	wmu       sync.Mutex    // serializes writes
+	closeOnce sync.Once
+}
+
+// Upgrade performs the opening handshake of a WebSocket connection requested by r, taking over its connection.
+// Returns the connection, or the status code to respond with and an error if r is not a valid handshake
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, int, error) {
+	if !headerHas(r.Header, "Connection", "upgrade") || !headerHas(r.Header, "Upgrade", "websocket") {
+		return nil, http.StatusBadRequest, errors.New("not a websocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, http.StatusUpgradeRequired, errors.New("unsupported websocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return nil, http.StatusBadRequest, errors.New("malformed websocket key")
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, http.StatusInternalServerError, errors.New("websocket unsupported")
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	rw.WriteString("Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n")
+	if err = rw.Flush(); err != nil {
+		conn.Close()
+		return nil, http.StatusInternalServerError, err
+	}
+	return &Conn{conn: conn, br: rw.Reader}, http.StatusSwitchingProtocols, nil
+}
+
+// Dial opens a WebSocket connection to the ws:// URL rawURL, sending header along with the handshake.
+// Returns the connection, or an error if the server refused it
+func Dial(rawURL string, header http.Header) (*Conn, error) {
+	if !strings.HasPrefix(rawURL, "ws://") {
+		return nil, fmt.Errorf("unsupported websocket url %q", rawURL)
+	}
+	host, path, _ := strings.Cut(strings.TrimPrefix(rawURL, "ws://"), "/")
+	conn, err := net.Dial("tcp", host)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+	req, _ := http.NewRequest("GET", "http://"+host+"/"+path, nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	if err = req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		conn.Close()
+		return nil, fmt.Errorf("websocket handshake refused: %s", resp.Status)
+	}
+	return &Conn{conn: conn, br: br, client: true}, nil
+}
+
+// ReadMessage reads the next text or binary message, answering the pings and close frames that precede it.
+// Returns the message, or an error if the connection was closed or the peer broke the protocol
+func (c *Conn) ReadMessage() ([]byte, error) {
+	var message []byte
+	started := false
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case opPing:
+			c.writeFrame(opPong, payload)
+		case opPong:
+		case opClose:
+			code := CloseNormal
+			if len(payload) >= 2 {
+				code = int(binary.BigEndian.Uint16(payload))
+			}
+			c.close(code, "")
+			return nil, ErrClosed
+		case opText, opBinary, opContinuation:
+			if started == (opcode != opContinuation) {
+				c.close(CloseProtocolError, "unexpected continuation")
+				return nil, errors.New("websocket: unexpected continuation frame")
+			}
+			started = true
+			if len(message)+len(payload) > MaxMessageSize {
+				c.close(CloseTooBig, "message too big")
+				return nil, errors.New("websocket: message too big")
+			}
+			message = append(message, payload...)
+			if fin {
+				return message, nil
+			}
+		default:
+			c.close(CloseProtocolError, "unknown opcode")
+			return nil, fmt.Errorf("websocket: unknown opcode %d", opcode)
+		}
+	}
+}
+
+// readFrame reads a single frame, unmasking its payload.
+// Returns whether it is the final frame of its message, its opcode and its payload
+func (c *Conn) readFrame() (bool, byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.br, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin, opcode := head[0]&0x80 != 0, head[0]&0x0F
+	masked, length := head[1]&0x80 != 0, uint64(head[1]&0x7F)
+	if head[0]&0x70 != 0 || masked == c.client {
+		c.close(CloseProtocolError, "malformed frame")
+		return false, 0, nil, errors.New("websocket: malformed frame")
+	}
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if opcode >= opClose && (length > 125 || !fin) {
+		c.close(CloseProtocolError, "malformed control frame")
+		return false, 0, nil, errors.New("websocket: malformed control frame")
+	}
+	if length > MaxMessageSize {
+		c.close(CloseTooBig, "message too big")
+		return false, 0, nil, errors.New("websocket: message too big")
+	}
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin, opcode, payload, nil
+}
+
+// WriteMessage sends message as a single text frame
+func (c *Conn) WriteMessage(message []byte) error {
+	return c.writeFrame(opText, message)
+}
+
+// Ping sends a ping frame, which the peer answers to show it is still there
+func (c *Conn) Ping() error {
+	return c.writeFrame(opPing, nil)
+}
+
+// writeFrame sends a single frame with opcode carrying payload, masking it if this is the client end
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	frame := make([]byte, 0, len(payload)+14)
+	frame = append(frame, 0x80|opcode)
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, maskBit|byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+	if c.client {
+		var mask [4]byte
+		rand.Read(mask[:])
+		frame = append(frame, mask[:]...)
+		for i, b := range payload {
+			frame = append(frame, b^mask[i%4])
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// Close sends a close frame with a normal status and closes the connection
+func (c *Conn) Close() error {
+	return c.close(CloseNormal, "")
+}
+
+// close sends a close frame with code and reason, unless one was sent already, and closes the connection
+func (c *Conn) close(code int, reason string) error {
+	var err error
+	c.closeOnce.Do(func() {
+		payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+		c.writeFrame(opClose, append(payload, reason...))
+		err = c.conn.Close()
+	})
+	return err
+}
+
+// acceptKey computes the accept value answering the handshake key
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// headerHas reports whether one of the comma-separated values of the header name is token, ignoring case
+func headerHas(header http.Header, name string, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
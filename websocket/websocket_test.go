@@ -0,0 +1,137 @@
+package websocket
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// echoServer starts a server echoing every message it reads back to the client
+func echoServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, status, err := Upgrade(w, r)
+		if err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+		defer conn.Close()
+		for {
+			message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(message)
+		}
+	}))
+}
+
+func TestAcceptKey(t *testing.T) {
+	if got := acceptKey("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("acceptKey failed, got %s", got)
+	}
+}
+
+func TestEcho(t *testing.T) {
+	srv := echoServer()
+	defer srv.Close()
+	conn, err := Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/", nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	for _, message := range [][]byte{[]byte(`{"op":"subscribe"}`), bytes.Repeat([]byte("a"), 300), bytes.Repeat([]byte("b"), 70000)} {
+		if err = conn.WriteMessage(message); err != nil {
+			t.Fatalf("WriteMessage failed: %v", err)
+		}
+		if got, err := conn.ReadMessage(); err != nil || !bytes.Equal(got, message) {
+			t.Errorf("ReadMessage failed, expected %d bytes, got %d: %v", len(message), len(got), err)
+		}
+	}
+}
+
+func TestFragmentsAndPing(t *testing.T) {
+	srv := echoServer()
+	defer srv.Close()
+	conn, err := Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	// A message split in two frames, with a ping between them
+	conn.conn.Write(maskedFrame(opText, false, []byte("hello ")))
+	conn.conn.Write(maskedFrame(opPing, true, []byte("are you there")))
+	conn.conn.Write(maskedFrame(opContinuation, true, []byte("world")))
+
+	fin, opcode, payload, err := conn.readFrame()
+	if err != nil || !fin || opcode != opPong || string(payload) != "are you there" {
+		t.Errorf("expected a pong, got %d %q %v", opcode, payload, err)
+	}
+	if got, err := conn.ReadMessage(); err != nil || string(got) != "hello world" {
+		t.Errorf("ReadMessage failed, got %q %v", got, err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	srv := echoServer()
+	defer srv.Close()
+	conn, err := Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	conn.conn.Write(maskedFrame(opClose, true, []byte{0x03, 0xE8}))
+	if _, err = conn.ReadMessage(); err != ErrClosed {
+		t.Errorf("expected the server to answer the close frame, got %v", err)
+	}
+}
+
+func TestUnmaskedFrame(t *testing.T) {
+	srv := echoServer()
+	defer srv.Close()
+	conn, err := Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.conn.Write([]byte{0x80 | opText, 2, 'h', 'i'})
+
+	fin, opcode, payload, err := conn.readFrame()
+	if err != nil || !fin || opcode != opClose || len(payload) < 2 || int(payload[0])<<8|int(payload[1]) != CloseProtocolError {
+		t.Errorf("expected a protocol error, got %d %v %v", opcode, payload, err)
+	}
+}
+
+func TestBadHandshake(t *testing.T) {
+	srv := echoServer()
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	if err != nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for a plain request, got %v %v", resp, err)
+	}
+
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "8")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUpgradeRequired || resp.Header.Get("Sec-WebSocket-Version") != "13" {
+		t.Errorf("expected 426 for an unsupported version, got %v %v", resp, err)
+	}
+}
+
+// maskedFrame builds a small frame the way a client sends it
+func maskedFrame(opcode byte, fin bool, payload []byte) []byte {
+	head := opcode
+	if fin {
+		head |= 0x80
+	}
+	mask := []byte{1, 2, 3, 4}
+	frame := append([]byte{head, 0x80 | byte(len(payload))}, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	return frame
+}